@@ -78,29 +78,41 @@ func NewConfig() *Config {
 }
 
 func NewConfigFromFile(path string) *Config {
+	config, err := ReadConfigFromFile(path)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	return config
+}
+
+// ReadConfigFromFile returns the error instead of the exit, so the config can be reloaded without the restart.
+func ReadConfigFromFile(path string) (*Config, error) {
 	logger.Infof("reading config %q", path)
 	yamlContents, err := os.ReadFile(path)
 	if err != nil {
-		logger.Fatalf("can't read config file %q: %s", path, err)
+		return nil, fmt.Errorf("can't read config file %q: %w", path, err)
 	}
 
 	jsonContents, err := yaml.YAMLToJSON(yamlContents)
 	if err != nil {
 		logger.Infof("config content:\n%s", logger.Numerate(string(yamlContents)))
-		logger.Fatalf("can't parse config file yaml %q: %s", path, err.Error())
+		return nil, fmt.Errorf("can't parse config file yaml %q: %w", path, err)
 	}
 
 	object, err := simplejson.NewJson(jsonContents)
 	if err != nil {
-		logger.Fatalf("can't convert config to json %q: %s", path, err.Error())
+		return nil, fmt.Errorf("can't convert config to json %q: %w", path, err)
 	}
 
 	err = applyEnvs(object)
 	if err != nil {
-		logger.Fatalf("can't get config values from environments: %s", err.Error())
+		return nil, fmt.Errorf("can't get config values from environments: %w", err)
 	}
 
-	config := parseConfig(object)
+	config, err := parseConfig(object)
+	if err != nil {
+		return nil, err
+	}
 	var apps []funcApplier
 
 	// add applicator for env variables
@@ -111,7 +123,7 @@ func NewConfigFromFile(path string) *Config {
 	if config.Vault.ShouldUse {
 		vault, err = newVault(config.Vault.Address, config.Vault.Token)
 		if err != nil {
-			logger.Fatalf("can't create vault client: %s", err.Error())
+			return nil, fmt.Errorf("can't create vault client: %w", err)
 		}
 	}
 
@@ -124,7 +136,7 @@ func NewConfigFromFile(path string) *Config {
 
 	logger.Infof("config parsed, found %d pipelines", len(config.Pipelines))
 
-	return config
+	return config, nil
 }
 
 func applyEnvs(object *simplejson.Json) error {
@@ -145,7 +157,7 @@ func applyEnvs(object *simplejson.Json) error {
 	return nil
 }
 
-func parseConfig(object *simplejson.Json) *Config {
+func parseConfig(object *simplejson.Json) (*Config, error) {
 	config := NewConfig()
 	vault := object.Get("vault")
 	var err error
@@ -154,7 +166,7 @@ func parseConfig(object *simplejson.Json) *Config {
 	if addr.Interface() != nil {
 		config.Vault.Address, err = addr.String()
 		if err != nil {
-			logger.Panicf("can't parse vault address: %s", err.Error())
+			return nil, fmt.Errorf("can't parse vault address: %w", err)
 		}
 	}
 
@@ -162,7 +174,7 @@ func parseConfig(object *simplejson.Json) *Config {
 	if token.Interface() != nil {
 		config.Vault.Token, err = token.String()
 		if err != nil {
-			logger.Panicf("can't parse vault token: %s", err.Error())
+			return nil, fmt.Errorf("can't parse vault token: %w", err)
 		}
 	}
 	config.Vault.ShouldUse = config.Vault.Address != "" && config.Vault.Token != ""
@@ -170,17 +182,17 @@ func parseConfig(object *simplejson.Json) *Config {
 	pipelinesJson := object.Get("pipelines")
 	pipelines := pipelinesJson.MustMap()
 	if len(pipelines) == 0 {
-		logger.Fatalf("no pipelines defined in config")
+		return nil, fmt.Errorf("no pipelines defined in config")
 	}
 	for name := range pipelines {
 		if err := validatePipelineName(name); err != nil {
-			logger.Fatal(err)
+			return nil, err
 		}
 		raw := pipelinesJson.Get(name)
 		config.Pipelines[name] = &PipelineConfig{Raw: raw}
 	}
 
-	return config
+	return config, nil
 }
 
 func validatePipelineName(name string) error {
@@ -3,6 +3,8 @@ package cfg
 import (
 	"fmt"
 	"strings"
+
+	insaneJSON "github.com/vitkovskii/insane-json"
 )
 
 type SubstitutionOpKind int
@@ -17,56 +19,143 @@ type SubstitutionOp struct {
 	Data []string
 }
 
-func ParseSubstitution(substitution string) ([]SubstitutionOp, error) {
-	result := make([]SubstitutionOp, 0)
-	tail := ""
+// SubstitutionEscape is the escaping of the values appended by the AppendSubstitution
+type SubstitutionEscape int
+
+const (
+	// SubstitutionEscapeNone appends the values as is.
+	SubstitutionEscapeNone SubstitutionEscape = iota
+	// SubstitutionEscapeStrings escapes the string values for JSON, so they can be placed in the strings of the JSON template,
+	// the other values are appended as JSON.
+	SubstitutionEscapeStrings
+	// SubstitutionEscapeAll escapes the whole result for JSON including the raw parts, so it can be placed in the JSON string.
+	SubstitutionEscapeAll
+)
+
+// SplitSubstitution calls raw for the text parts of the substitution and placeholder for the contents
+// of the `${...}` parts in order, `$$` is the escaped `$`.
+func SplitSubstitution(substitution string, raw func(string), placeholder func(string) error) error {
+	text := strings.Builder{}
 	for {
 		pos := strings.IndexByte(substitution, '$')
-		if pos == -1 {
+		if pos == -1 || pos+1 == len(substitution) {
 			break
 		}
 
-		if len(substitution) < pos+1 {
-			substitution = substitution[pos+1:]
-			continue
-		}
-
 		switch substitution[pos+1] {
 		case '$':
-			tail = substitution[:pos+1]
+			text.WriteString(substitution[:pos+1])
 			substitution = substitution[pos+2:]
 		case '{':
-			result = append(result, SubstitutionOp{
-				Kind: SubstitutionOpKindRaw,
-				Data: []string{tail + substitution[:pos]},
-			})
-			tail = ""
-
-			end := strings.IndexByte(substitution, '}')
+			end := strings.IndexByte(substitution[pos:], '}')
 			if end == -1 {
-				return nil, fmt.Errorf("can't find substitution end '}': %s", substitution)
+				return fmt.Errorf("can't find substitution end '}': %s", substitution)
 			}
 
-			selector := substitution[pos+2 : end]
-			path := ParseFieldSelector(selector)
-			result = append(result, SubstitutionOp{
-				Kind: SubstitutionOpKindField,
-				Data: path,
-			})
-
-			substitution = substitution[end+1:]
+			text.WriteString(substitution[:pos])
+			if text.Len() != 0 {
+				raw(text.String())
+				text.Reset()
+			}
+			if err := placeholder(substitution[pos+2 : pos+end]); err != nil {
+				return err
+			}
+			substitution = substitution[pos+end+1:]
 		default:
-			tail = substitution[:pos+1]
+			text.WriteString(substitution[:pos+1])
 			substitution = substitution[pos+1:]
 		}
 	}
 
-	if len(substitution)+len(tail) != 0 {
+	text.WriteString(substitution)
+	if text.Len() != 0 {
+		raw(text.String())
+	}
+
+	return nil
+}
+
+// ParseSubstitution parses the substitution of the event fields, e.g. `${service}-${level}`.
+func ParseSubstitution(substitution string) ([]SubstitutionOp, error) {
+	result := make([]SubstitutionOp, 0)
+	err := SplitSubstitution(substitution, func(raw string) {
 		result = append(result, SubstitutionOp{
 			Kind: SubstitutionOpKindRaw,
-			Data: []string{tail + substitution},
+			Data: []string{raw},
 		})
+	}, func(selector string) error {
+		if selector == "" {
+			return fmt.Errorf("empty field in substitution: %s", substitution)
+		}
+		result = append(result, SubstitutionOp{
+			Kind: SubstitutionOpKindField,
+			Data: ParseFieldSelector(selector),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	return result, nil
 }
+
+// AppendSubstitution appends the substitution of the event, the strings are appended as is
+// and the other values as JSON. It returns false if any field is missing, the missing fields are skipped.
+func AppendSubstitution(out []byte, ops []SubstitutionOp, root *insaneJSON.Root, escape SubstitutionEscape) ([]byte, bool) {
+	ok := true
+	for i := range ops {
+		op := &ops[i]
+		if op.Kind == SubstitutionOpKindRaw {
+			if escape == SubstitutionEscapeAll {
+				out = AppendEscapedJSON(out, op.Data[0])
+			} else {
+				out = append(out, op.Data[0]...)
+			}
+			continue
+		}
+
+		node := root.Dig(op.Data...)
+		switch {
+		case node == nil:
+			ok = false
+		case escape == SubstitutionEscapeAll:
+			if node.IsString() {
+				out = AppendEscapedJSON(out, node.AsString())
+			} else {
+				out = AppendEscapedJSON(out, node.EncodeToString())
+			}
+		case !node.IsString():
+			out = node.Encode(out)
+		case escape == SubstitutionEscapeStrings:
+			out = AppendEscapedJSON(out, node.AsString())
+		default:
+			out = append(out, node.AsString()...)
+		}
+	}
+	return out, ok
+}
+
+// AppendEscapedJSON appends the string escaped for JSON without the quotes, the string is expected to be valid UTF-8
+func AppendEscapedJSON(out []byte, s string) []byte {
+	const hex = "0123456789abcdef"
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"' || c == '\\':
+			out = append(out, '\\', c)
+		case c == '\n':
+			out = append(out, '\\', 'n')
+		case c == '\r':
+			out = append(out, '\\', 'r')
+		case c == '\t':
+			out = append(out, '\\', 't')
+		case c < 0x20:
+			out = append(out, '\\', 'u', '0', '0', hex[c>>4], hex[c&0xf])
+		default:
+			out = append(out, c)
+		}
+	}
+	return out
+}
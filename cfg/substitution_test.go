@@ -4,6 +4,8 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	insaneJSON "github.com/vitkovskii/insane-json"
 )
 
 func TestParseVale(t *testing.T) {
@@ -48,3 +50,77 @@ func TestParseErr(t *testing.T) {
 
 	assert.NotNil(t, err, "no error")
 }
+
+func TestParseSubstitutionEdgeCases(t *testing.T) {
+	cases := []struct {
+		substitution string
+		want         []SubstitutionOp
+		wantErr      bool
+	}{
+		{
+			substitution: "a$$b$$c",
+			want:         []SubstitutionOp{{Kind: SubstitutionOpKindRaw, Data: []string{"a$b$c"}}},
+		},
+		{
+			substitution: "price $",
+			want:         []SubstitutionOp{{Kind: SubstitutionOpKindRaw, Data: []string{"price $"}}},
+		},
+		{
+			substitution: "{a}-${b}",
+			want: []SubstitutionOp{
+				{Kind: SubstitutionOpKindRaw, Data: []string{"{a}-"}},
+				{Kind: SubstitutionOpKindField, Data: []string{"b"}},
+			},
+		},
+		{
+			substitution: "${a}${b}",
+			want: []SubstitutionOp{
+				{Kind: SubstitutionOpKindField, Data: []string{"a"}},
+				{Kind: SubstitutionOpKindField, Data: []string{"b"}},
+			},
+		},
+		{
+			substitution: "",
+			want:         []SubstitutionOp{},
+		},
+		{
+			substitution: "${}",
+			wantErr:      true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.substitution, func(t *testing.T) {
+			result, err := ParseSubstitution(tc.substitution)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.want, result)
+		})
+	}
+}
+
+func TestAppendSubstitution(t *testing.T) {
+	root, err := insaneJSON.DecodeString(`{"s":"a\"b","n":1,"o":{"k":"v"}}`)
+	require.NoError(t, err)
+	defer insaneJSON.Release(root)
+
+	ops, err := ParseSubstitution(`"${s}":${n}/${o}/${missing}`)
+	require.NoError(t, err)
+
+	cases := []struct {
+		escape SubstitutionEscape
+		want   string
+	}{
+		{escape: SubstitutionEscapeNone, want: `"a"b":1/{"k":"v"}/`},
+		{escape: SubstitutionEscapeStrings, want: `"a\"b":1/{"k":"v"}/`},
+		{escape: SubstitutionEscapeAll, want: `\"a\"b\":1/{\"k\":\"v\"}/`},
+	}
+	for _, tc := range cases {
+		out, ok := AppendSubstitution(nil, ops, root, tc.escape)
+		assert.False(t, ok, "missing field isn't reported")
+		assert.Equal(t, tc.want, string(out))
+	}
+}
@@ -1,9 +1,11 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 	"time"
 
@@ -16,39 +18,81 @@ import (
 	"github.com/ozontech/file.d/pipeline"
 	_ "github.com/ozontech/file.d/plugin/action/add_file_name"
 	_ "github.com/ozontech/file.d/plugin/action/add_host"
+	_ "github.com/ozontech/file.d/plugin/action/convert"
 	_ "github.com/ozontech/file.d/plugin/action/convert_date"
 	_ "github.com/ozontech/file.d/plugin/action/convert_log_level"
 	_ "github.com/ozontech/file.d/plugin/action/debug"
+	_ "github.com/ozontech/file.d/plugin/action/decode"
+	_ "github.com/ozontech/file.d/plugin/action/dedup"
 	_ "github.com/ozontech/file.d/plugin/action/discard"
+	_ "github.com/ozontech/file.d/plugin/action/expression"
 	_ "github.com/ozontech/file.d/plugin/action/flatten"
+	_ "github.com/ozontech/file.d/plugin/action/geoip"
+	_ "github.com/ozontech/file.d/plugin/action/grok"
 	_ "github.com/ozontech/file.d/plugin/action/join"
 	_ "github.com/ozontech/file.d/plugin/action/join_template"
 	_ "github.com/ozontech/file.d/plugin/action/json_decode"
 	_ "github.com/ozontech/file.d/plugin/action/json_encode"
 	_ "github.com/ozontech/file.d/plugin/action/keep_fields"
+	_ "github.com/ozontech/file.d/plugin/action/limit_field_size"
+	_ "github.com/ozontech/file.d/plugin/action/lookup"
 	_ "github.com/ozontech/file.d/plugin/action/mask"
 	_ "github.com/ozontech/file.d/plugin/action/modify"
+	_ "github.com/ozontech/file.d/plugin/action/normalize_level"
 	_ "github.com/ozontech/file.d/plugin/action/parse_es"
+	_ "github.com/ozontech/file.d/plugin/action/parse_logfmt"
 	_ "github.com/ozontech/file.d/plugin/action/parse_re2"
+	_ "github.com/ozontech/file.d/plugin/action/parse_time"
+	_ "github.com/ozontech/file.d/plugin/action/parse_uri"
+	_ "github.com/ozontech/file.d/plugin/action/parse_user_agent"
 	_ "github.com/ozontech/file.d/plugin/action/remove_fields"
 	_ "github.com/ozontech/file.d/plugin/action/rename"
+	_ "github.com/ozontech/file.d/plugin/action/rename_regex"
+	_ "github.com/ozontech/file.d/plugin/action/sample"
+	_ "github.com/ozontech/file.d/plugin/action/secret_scan"
+	_ "github.com/ozontech/file.d/plugin/action/set_if"
 	_ "github.com/ozontech/file.d/plugin/action/set_time"
+	_ "github.com/ozontech/file.d/plugin/action/split"
 	_ "github.com/ozontech/file.d/plugin/action/throttle"
+	_ "github.com/ozontech/file.d/plugin/action/time_filter"
+	_ "github.com/ozontech/file.d/plugin/action/unflatten"
+	_ "github.com/ozontech/file.d/plugin/action/validate"
 	_ "github.com/ozontech/file.d/plugin/input/dmesg"
 	_ "github.com/ozontech/file.d/plugin/input/fake"
 	_ "github.com/ozontech/file.d/plugin/input/file"
+	_ "github.com/ozontech/file.d/plugin/input/generator"
+	_ "github.com/ozontech/file.d/plugin/input/grpc"
 	_ "github.com/ozontech/file.d/plugin/input/http"
 	_ "github.com/ozontech/file.d/plugin/input/journalctl"
 	_ "github.com/ozontech/file.d/plugin/input/k8s"
 	_ "github.com/ozontech/file.d/plugin/input/kafka"
+	_ "github.com/ozontech/file.d/plugin/input/mqtt"
+	_ "github.com/ozontech/file.d/plugin/input/nats"
+	_ "github.com/ozontech/file.d/plugin/input/redis_streams"
+	_ "github.com/ozontech/file.d/plugin/input/sftp"
+	_ "github.com/ozontech/file.d/plugin/input/sqs"
+	_ "github.com/ozontech/file.d/plugin/input/syslog"
+	_ "github.com/ozontech/file.d/plugin/output/amqp"
+	_ "github.com/ozontech/file.d/plugin/output/azure_blob"
+	_ "github.com/ozontech/file.d/plugin/output/capture"
 	_ "github.com/ozontech/file.d/plugin/output/clickhouse"
 	_ "github.com/ozontech/file.d/plugin/output/devnull"
 	_ "github.com/ozontech/file.d/plugin/output/elasticsearch"
 	_ "github.com/ozontech/file.d/plugin/output/file"
+	_ "github.com/ozontech/file.d/plugin/output/gcs"
 	_ "github.com/ozontech/file.d/plugin/output/gelf"
+	_ "github.com/ozontech/file.d/plugin/output/grpc"
+	_ "github.com/ozontech/file.d/plugin/output/http"
 	_ "github.com/ozontech/file.d/plugin/output/kafka"
+	_ "github.com/ozontech/file.d/plugin/output/kinesis"
+	_ "github.com/ozontech/file.d/plugin/output/loki"
+	_ "github.com/ozontech/file.d/plugin/output/mongo"
+	_ "github.com/ozontech/file.d/plugin/output/nats"
+	_ "github.com/ozontech/file.d/plugin/output/otlp"
 	_ "github.com/ozontech/file.d/plugin/output/postgres"
+	_ "github.com/ozontech/file.d/plugin/output/prometheus_remote_write"
 	_ "github.com/ozontech/file.d/plugin/output/s3"
+	_ "github.com/ozontech/file.d/plugin/output/socket"
 	_ "github.com/ozontech/file.d/plugin/output/splunk"
 	_ "github.com/ozontech/file.d/plugin/output/stdout"
 	insaneJSON "github.com/vitkovskii/insane-json"
@@ -58,6 +102,8 @@ import (
 var (
 	fileD *fd.FileD
 	exit  = make(chan bool)
+	// reloadMu prevents the concurrent reloads by the signal and the config watcher
+	reloadMu sync.Mutex
 
 	config        = kingpin.Flag("config", `Config file name`).Required().ExistingFile()
 	http          = kingpin.Flag("http", `HTTP listen addr eg. ":9000", "off" to disable`).Default(":9000").String()
@@ -66,6 +112,10 @@ var (
 		`Value to set GOMEMLIMIT (https://pkg.go.dev/runtime) with the value from the cgroup's memory limit and given ratio. `+
 			`If there is a need to reduce the load GC, it is recommended to set 0.9. Default is disabled.`,
 	).Default("0").Float64()
+	configWatchInterval = kingpin.Flag(
+		"config-watch-interval",
+		`Interval of checking the config file changes to reload it, "0" to disable. The config is also reloaded on SIGHUP.`,
+	).Default("0").Duration()
 )
 
 func main() {
@@ -92,6 +142,51 @@ func start() {
 
 	fileD = fd.New(appCfg, *http)
 	fileD.Start()
+
+	if *configWatchInterval > 0 {
+		go watchConfig(*configWatchInterval)
+	}
+}
+
+// reload applies the changes of the pipelines, the config is rejected entirely on errors
+// and file.d keeps working with the previous one
+func reload() {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	appCfg, err := cfg.ReadConfigFromFile(*config)
+	if err != nil {
+		logger.Errorf("can't reload config: %s", err.Error())
+		return
+	}
+
+	if err := fileD.Reload(appCfg); err != nil {
+		logger.Errorf("can't reload config: %s", err.Error())
+	}
+}
+
+func watchConfig(interval time.Duration) {
+	last, err := os.ReadFile(*config)
+	if err != nil {
+		logger.Errorf("can't read config file %q: %s", *config, err.Error())
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		content, err := os.ReadFile(*config)
+		if err != nil {
+			logger.Errorf("can't read config file %q: %s", *config, err.Error())
+			continue
+		}
+		if bytes.Equal(content, last) {
+			continue
+		}
+		last = content
+
+		logger.Infof("config file %q is changed", *config)
+		reload()
+	}
 }
 
 func listenSignals() {
@@ -104,17 +199,10 @@ func listenSignals() {
 		switch s {
 		case syscall.SIGHUP:
 			logger.Infof("SIGHUP received")
-
-			ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
-			err := fileD.Stop(ctx)
-			if err != nil {
-				logger.Fatalf("can't stop file.d with SIGHUP: %s", err.Error())
-			}
-			cancel()
-
-			start()
+			reload()
 		case syscall.SIGINT, syscall.SIGTERM:
 			logger.Infof("SIGTERM or SIGINT received")
+			reloadMu.Lock()
 
 			ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
 			err := fileD.Stop(ctx)
@@ -6,12 +6,18 @@ import (
 )
 
 const (
-	criDelimiter = ' '
+	criDelimiter    = ' '
+	criTagDelimiter = ':'
+
+	// CRITruncatedTag marks the line which is joined from partial lines
+	// and truncated because it's too long, e.g. `F:truncated`
+	CRITruncatedTag = "truncated"
 )
 
 type CRIRow struct {
 	Log, Time, Stream []byte
 	IsPartial         bool
+	IsTruncated       bool
 }
 
 // DecodeCRI decodes CRI formatted event.
@@ -51,6 +57,17 @@ func DecodeCRI(data []byte) (row CRIRow, _ error) {
 	}
 
 	row.IsPartial = tags[0] == 'P'
+	// tags are separated by the colon, the first one is the partial/full tag
+	for pos = bytes.IndexByte(tags, criTagDelimiter); pos >= 0; pos = bytes.IndexByte(tags, criTagDelimiter) {
+		tags = tags[pos+1:]
+		tag := tags
+		if end := bytes.IndexByte(tags, criTagDelimiter); end >= 0 {
+			tag = tags[:end]
+		}
+		if string(tag) == CRITruncatedTag {
+			row.IsTruncated = true
+		}
+	}
 
 	log := data
 	// remove \n from log for partial logs
@@ -26,6 +26,20 @@ func TestCRIFull(t *testing.T) {
 	assert.Equal(t, false, row.IsPartial)
 }
 
+func TestCRITruncated(t *testing.T) {
+	row, err := DecodeCRI([]byte("2016-10-06T00:17:09.669794202Z stdout F:truncated truncated content\n"))
+
+	assert.NoError(t, err, "error while decoding cri log")
+	assert.Equal(t, "truncated content\n", string(row.Log))
+	assert.Equal(t, false, row.IsPartial)
+	assert.Equal(t, true, row.IsTruncated)
+
+	row, err = DecodeCRI([]byte("2016-10-06T00:17:09.669794202Z stdout F:other full content\n"))
+
+	assert.NoError(t, err, "error while decoding cri log")
+	assert.Equal(t, false, row.IsTruncated)
+}
+
 func TestCRIError(t *testing.T) {
 	_, err := DecodeCRI([]byte("2016-10-06T00:17:09.669794202Z stdout  full content 3\n"))
 
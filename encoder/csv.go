@@ -0,0 +1,87 @@
+package encoder
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/ozontech/file.d/cfg"
+	insaneJSON "github.com/vitkovskii/insane-json"
+)
+
+// csvEncoder encodes the values of the columns separated by commas as RFC 4180 describes.
+// The missing field is an empty value, the objects and the arrays are encoded as JSON.
+type csvEncoder struct {
+	columns [][]string
+	header  []byte
+}
+
+func newCSVEncoder(columns []string, header bool) (*csvEncoder, error) {
+	if len(columns) == 0 {
+		return nil, errors.New("csv columns aren't set")
+	}
+
+	e := &csvEncoder{columns: make([][]string, 0, len(columns))}
+	for _, column := range columns {
+		e.columns = append(e.columns, cfg.ParseFieldSelector(column))
+	}
+	if header {
+		for i, column := range columns {
+			if i > 0 {
+				e.header = append(e.header, ',')
+			}
+			e.header = appendCSVValue(e.header, column)
+		}
+	}
+	return e, nil
+}
+
+func (e *csvEncoder) Encode(out []byte, root *insaneJSON.Root) []byte {
+	for i, column := range e.columns {
+		if i > 0 {
+			out = append(out, ',')
+		}
+
+		node := root.Dig(column...)
+		switch {
+		case node == nil || node.IsNull():
+		case node.IsObject() || node.IsArray():
+			l := len(out)
+			out = node.Encode(out)
+			out = appendCSVValue(out[:l], string(out[l:]))
+		default:
+			out = appendCSVValue(out, node.AsString())
+		}
+	}
+	return out
+}
+
+func (e *csvEncoder) Header() []byte {
+	return e.header
+}
+
+// appendCSVValue appends the value, it's quoted if it contains the separator, the quotes, the line breaks
+// or the leading or trailing spaces, the quotes are doubled
+func appendCSVValue(out []byte, value string) []byte {
+	if !needsCSVQuote(value) {
+		return append(out, value...)
+	}
+
+	out = append(out, '"')
+	for i := 0; i < len(value); i++ {
+		if value[i] == '"' {
+			out = append(out, '"')
+		}
+		out = append(out, value[i])
+	}
+	return append(out, '"')
+}
+
+func needsCSVQuote(value string) bool {
+	if value == "" {
+		return false
+	}
+	if value[0] == ' ' || value[len(value)-1] == ' ' {
+		return true
+	}
+	return strings.ContainsAny(value, ",\"\r\n")
+}
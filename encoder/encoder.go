@@ -0,0 +1,57 @@
+package encoder
+
+import (
+	"fmt"
+
+	insaneJSON "github.com/vitkovskii/insane-json"
+)
+
+const (
+	TypeJSON       = "json"
+	TypePrettyJSON = "pretty_json"
+	TypeLogfmt     = "logfmt"
+	TypeCSV        = "csv"
+)
+
+// Encoder serializes the events for the outputs writing them line by line.
+// The encoders are stateless, so they can be used by many workers at once.
+type Encoder interface {
+	// Encode appends the event without the trailing line break.
+	Encode(out []byte, root *insaneJSON.Root) []byte
+	// Header returns the line which is written before the events or nil.
+	Header() []byte
+}
+
+// Config is the config of the encoder, the columns and the header are used only by CSV.
+type Config struct {
+	Type       string
+	CSVColumns []string
+	CSVHeader  bool
+}
+
+// New returns the encoder of the type.
+func New(config Config) (Encoder, error) {
+	switch config.Type {
+	case TypeJSON, "":
+		return jsonEncoder{}, nil
+	case TypePrettyJSON:
+		return prettyJSONEncoder{}, nil
+	case TypeLogfmt:
+		return logfmtEncoder{}, nil
+	case TypeCSV:
+		return newCSVEncoder(config.CSVColumns, config.CSVHeader)
+	default:
+		return nil, fmt.Errorf("unknown encoder %q", config.Type)
+	}
+}
+
+// jsonEncoder encodes the event as compact JSON.
+type jsonEncoder struct{}
+
+func (jsonEncoder) Encode(out []byte, root *insaneJSON.Root) []byte {
+	return root.Encode(out)
+}
+
+func (jsonEncoder) Header() []byte {
+	return nil
+}
@@ -0,0 +1,100 @@
+package encoder
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	insaneJSON "github.com/vitkovskii/insane-json"
+)
+
+func TestEncode(t *testing.T) {
+	const event = `{"level":"info","message":"user \"bob\" logged in","ts":1700000000,"ok":true,"tags":["a","b"],"http":{"method":"GET","code":200},"empty":{}}`
+
+	cases := []struct {
+		name   string
+		config Config
+		want   string
+		header string
+	}{
+		{
+			name:   "json",
+			config: Config{Type: TypeJSON},
+			want:   event,
+		},
+		{
+			name:   "pretty json",
+			config: Config{Type: TypePrettyJSON},
+			want: `{
+  "level": "info",
+  "message": "user \"bob\" logged in",
+  "ts": 1700000000,
+  "ok": true,
+  "tags": [
+    "a",
+    "b"
+  ],
+  "http": {
+    "method": "GET",
+    "code": 200
+  },
+  "empty": {}
+}`,
+		},
+		{
+			name:   "logfmt",
+			config: Config{Type: TypeLogfmt},
+			want:   `level=info message="user \"bob\" logged in" ts=1700000000 ok=true tags="[\"a\",\"b\"]" http.method=GET http.code=200 empty={}`,
+		},
+		{
+			name: "csv",
+			config: Config{
+				Type:       TypeCSV,
+				CSVColumns: []string{"ts", "level", "http.code", "message", "missing", "tags"},
+				CSVHeader:  true,
+			},
+			want:   `1700000000,info,200,"user ""bob"" logged in",,"[""a"",""b""]"`,
+			header: `ts,level,http.code,message,missing,tags`,
+		},
+	}
+
+	root, err := insaneJSON.DecodeString(event)
+	require.NoError(t, err)
+	defer insaneJSON.Release(root)
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := New(tt.config)
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.want, string(e.Encode([]byte("prefix:"), root))[len("prefix:"):])
+			assert.Equal(t, tt.header, string(e.Header()))
+		})
+	}
+}
+
+func TestLogfmtValues(t *testing.T) {
+	root, err := insaneJSON.DecodeString(`{"empty":"","with space":"a=b","null":null,"":"x","line":"a\nb"}`)
+	require.NoError(t, err)
+	defer insaneJSON.Release(root)
+
+	out := logfmtEncoder{}.Encode(nil, root)
+	assert.Equal(t, `empty="" with_space="a=b" null=null _=x line="a\nb"`, string(out))
+}
+
+func TestCSVValues(t *testing.T) {
+	e, err := New(Config{Type: TypeCSV, CSVColumns: []string{"a", "b", "c"}})
+	require.NoError(t, err)
+	assert.Nil(t, e.Header())
+
+	root, err := insaneJSON.DecodeString(`{"a":" padded","b":"multi\r\nline","c":null}`)
+	require.NoError(t, err)
+	defer insaneJSON.Release(root)
+
+	assert.Equal(t, "\" padded\",\"multi\r\nline\",", string(e.Encode(nil, root)))
+
+	_, err = New(Config{Type: TypeCSV})
+	assert.Error(t, err)
+	_, err = New(Config{Type: "yaml"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,96 @@
+package encoder
+
+import (
+	"github.com/ozontech/file.d/cfg"
+	insaneJSON "github.com/vitkovskii/insane-json"
+)
+
+// logfmtEncoder encodes the event as `key=value` pairs separated by spaces.
+// The nested objects are flattened by the dotted keys, the arrays are encoded as JSON.
+type logfmtEncoder struct{}
+
+func (logfmtEncoder) Encode(out []byte, root *insaneJSON.Root) []byte {
+	start := len(out)
+	return appendLogfmtObject(out, start, nil, root.Node)
+}
+
+func (logfmtEncoder) Header() []byte {
+	return nil
+}
+
+// appendLogfmtObject appends the fields of the object, the prefix is the key of the object itself
+func appendLogfmtObject(out []byte, start int, prefix []byte, node *insaneJSON.Node) []byte {
+	for _, field := range node.AsFields() {
+		value := field.AsFieldValue()
+
+		name := field.AsString()
+		if value.IsObject() && len(value.AsFields()) > 0 {
+			nested := make([]byte, 0, len(prefix)+len(name)+1)
+			nested = append(append(append(nested, prefix...), name...), '.')
+			out = appendLogfmtObject(out, start, nested, value)
+			continue
+		}
+
+		if len(out) > start {
+			out = append(out, ' ')
+		}
+		out = appendLogfmtKey(out, prefix, name)
+		out = append(out, '=')
+
+		switch {
+		case value.IsObject() || value.IsArray():
+			l := len(out)
+			out = value.Encode(out)
+			out = appendLogfmtValue(out[:l], string(out[l:]))
+		case value.IsString():
+			out = appendLogfmtValue(out, value.AsString())
+		default:
+			out = append(out, value.AsString()...)
+		}
+	}
+	return out
+}
+
+// appendLogfmtKey appends the key, the characters which can't be in the key are replaced by `_`
+func appendLogfmtKey(out []byte, prefix []byte, name string) []byte {
+	if len(prefix) == 0 && name == "" {
+		return append(out, '_')
+	}
+	for _, c := range prefix {
+		out = append(out, logfmtKeyChar(c))
+	}
+	for i := 0; i < len(name); i++ {
+		out = append(out, logfmtKeyChar(name[i]))
+	}
+	return out
+}
+
+func logfmtKeyChar(c byte) byte {
+	if c <= ' ' || c == '=' || c == '"' || c == 0x7f {
+		return '_'
+	}
+	return c
+}
+
+// appendLogfmtValue appends the value, it's quoted if it's empty or contains the spaces, `=` or `"`
+func appendLogfmtValue(out []byte, value string) []byte {
+	if !needsLogfmtQuote(value) {
+		return append(out, value...)
+	}
+	out = append(out, '"')
+	out = cfg.AppendEscapedJSON(out, value)
+	return append(out, '"')
+}
+
+func needsLogfmtQuote(value string) bool {
+	if value == "" {
+		return true
+	}
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if c <= ' ' || c == '=' || c == '"' || c == '\\' || c == 0x7f {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,67 @@
+package encoder
+
+import (
+	"github.com/ozontech/file.d/cfg"
+	insaneJSON "github.com/vitkovskii/insane-json"
+)
+
+const prettyIndent = "  "
+
+// prettyJSONEncoder encodes the event as JSON indented by two spaces, the event takes several lines.
+type prettyJSONEncoder struct{}
+
+func (prettyJSONEncoder) Encode(out []byte, root *insaneJSON.Root) []byte {
+	return appendPretty(out, root.Node, 0)
+}
+
+func (prettyJSONEncoder) Header() []byte {
+	return nil
+}
+
+func appendPretty(out []byte, node *insaneJSON.Node, depth int) []byte {
+	switch {
+	case node.IsObject():
+		fields := node.AsFields()
+		if len(fields) == 0 {
+			return append(out, "{}"...)
+		}
+		out = append(out, '{')
+		for i, field := range fields {
+			if i > 0 {
+				out = append(out, ',')
+			}
+			out = appendIndent(out, depth+1)
+			out = append(out, '"')
+			out = cfg.AppendEscapedJSON(out, field.AsString())
+			out = append(out, `": `...)
+			out = appendPretty(out, field.AsFieldValue(), depth+1)
+		}
+		out = appendIndent(out, depth)
+		return append(out, '}')
+	case node.IsArray():
+		items := node.AsArray()
+		if len(items) == 0 {
+			return append(out, "[]"...)
+		}
+		out = append(out, '[')
+		for i, item := range items {
+			if i > 0 {
+				out = append(out, ',')
+			}
+			out = appendIndent(out, depth+1)
+			out = appendPretty(out, item, depth+1)
+		}
+		out = appendIndent(out, depth)
+		return append(out, ']')
+	default:
+		return node.Encode(out)
+	}
+}
+
+func appendIndent(out []byte, depth int) []byte {
+	out = append(out, '\n')
+	for i := 0; i < depth; i++ {
+		out = append(out, prettyIndent...)
+	}
+	return out
+}
@@ -8,8 +8,11 @@ import (
 	"io"
 	"net/http"
 	"net/http/pprof"
+	"regexp"
 	"runtime"
 	"runtime/debug"
+	"sort"
+	"time"
 
 	"github.com/bitly/go-simplejson"
 	"github.com/ozontech/file.d/buildinfo"
@@ -22,6 +25,9 @@ import (
 	"go.uber.org/atomic"
 )
 
+// outputNameRe is the format of the names of the `outputs` section since they're the parts of the metric names
+var outputNameRe = regexp.MustCompile(`^[a-zA-Z0-9_]+$`)
+
 type FileD struct {
 	config    *cfg.Config
 	httpAddr  string
@@ -32,6 +38,11 @@ type FileD struct {
 	mux       *http.ServeMux
 	metricCtl *metric.Ctl
 
+	// pipelineConfigs contains the configs of the running pipelines encoded before the plugins are created
+	pipelineConfigs map[string][]byte
+	// pipelinesHTTP is replaced on the reload, so the handlers don't use the pipelines while they're changed
+	pipelinesHTTP atomic.Pointer[pipelinesHTTP]
+
 	// file_d metrics
 
 	versionMetric *prometheus.CounterVec
@@ -74,138 +85,372 @@ func (f *FileD) createRegistry() {
 
 func (f *FileD) startPipelines() {
 	f.Pipelines = f.Pipelines[:0]
+	f.pipelineConfigs = make(map[string][]byte, len(f.config.Pipelines))
 	for name, config := range f.config.Pipelines {
 		f.addPipeline(name, config)
 	}
+	f.setupPipelinesHandlers()
 	for _, p := range f.Pipelines {
 		p.Start()
 	}
 }
 
 func (f *FileD) addPipeline(name string, config *cfg.PipelineConfig) {
-	mux := f.mux
-	settings := extractPipelineParams(config.Raw.Get("settings"))
+	raw, err := config.Raw.Encode()
+	if err != nil {
+		logger.Fatalf("can't encode config of pipeline %q: %s", name, err.Error())
+	}
+
+	plugins, err := f.preparePipeline(config)
+	if err != nil {
+		logger.Fatalf("can't create pipeline %q: %s", name, err.Error())
+	}
+
+	f.Pipelines = append(f.Pipelines, f.createPipeline(name, plugins))
+	f.pipelineConfigs[name] = raw
+}
+
+// pipelinePlugins is the checked config of the pipeline, the pipeline is created from it without errors
+type pipelinePlugins struct {
+	settings    *pipeline.Settings
+	input       *pipeline.InputPluginInfo
+	actions     []*pipeline.ActionPluginStaticInfo
+	output      *pipeline.OutputPluginInfo
+	deadLetter  *pipeline.OutputPluginInfo
+	errorOutput *pipeline.OutputPluginInfo
+}
+
+// preparePipeline checks the config and creates the plugins without starting them,
+// so the config can be rejected without affecting the running pipelines
+func (f *FileD) preparePipeline(config *cfg.PipelineConfig) (*pipelinePlugins, error) {
+	settings, err := extractPipelineParams(config.Raw.Get("settings"))
+	if err != nil {
+		return nil, err
+	}
 
 	values := map[string]int{
 		"capacity":   settings.Capacity,
 		"gomaxprocs": runtime.GOMAXPROCS(0),
 	}
 
-	logger.Infof("creating pipeline %q: capacity=%d, stream field=%s, decoder=%s", name, settings.Capacity, settings.StreamField, settings.Decoder)
+	plugins := &pipelinePlugins{settings: settings}
+	plugins.input, plugins.actions, err = f.getInput(config, values)
+	if err != nil {
+		return nil, err
+	}
 
-	p := pipeline.New(name, settings, f.registry)
-	err := f.setupInput(p, config, values)
+	actions, err := f.getActions(config, values)
 	if err != nil {
-		logger.Fatalf("can't create pipeline %q: %s", name, err.Error())
+		return nil, err
 	}
+	plugins.actions = append(plugins.actions, actions...)
 
-	f.setupActions(p, config, values)
+	if len(settings.OrderingKey) > 0 {
+		for _, action := range plugins.actions {
+			if action.StreamRequired {
+				return nil, fmt.Errorf("ordering key can't be used with %q action since it joins the events of the input stream", action.Type)
+			}
+		}
+	}
 
-	err = f.setupOutput(p, config, values)
+	plugins.output, err = f.getOutput(config, values)
 	if err != nil {
-		logger.Fatalf("can't create pipeline %q: %s", name, err.Error())
+		return nil, err
 	}
 
-	p.SetupHTTPHandlers(mux)
-	f.Pipelines = append(f.Pipelines, p)
-}
+	plugins.deadLetter, err = f.getSectionOutput(config, "dead_letter", values)
+	if err != nil {
+		return nil, fmt.Errorf("can't create dead letter output: %w", err)
+	}
 
-func (f *FileD) setupInput(p *pipeline.Pipeline, pipelineConfig *cfg.PipelineConfig, values map[string]int) error {
-	inputInfo, err := f.getStaticInfo(pipelineConfig, pipeline.PluginKindInput, values)
+	plugins.errorOutput, err = f.getSectionOutput(config, "error_output", values)
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("can't create error output: %w", err)
 	}
 
-	p.SetInput(&pipeline.InputPluginInfo{
-		PluginStaticInfo:  inputInfo,
-		PluginRuntimeInfo: f.instantiatePlugin(inputInfo),
+	return plugins, nil
+}
+
+func (f *FileD) createPipeline(name string, plugins *pipelinePlugins) *pipeline.Pipeline {
+	settings := plugins.settings
+	logger.Infof("creating pipeline %q: capacity=%d, stream field=%s, decoder=%s", name, settings.Capacity, settings.StreamField, settings.Decoder)
+
+	p := pipeline.New(name, settings, f.registry)
+	p.SetInput(plugins.input)
+	for _, action := range plugins.actions {
+		p.AddAction(action)
+	}
+	p.SetOutput(plugins.output)
+	if plugins.deadLetter != nil {
+		p.SetDeadLetter(plugins.deadLetter)
+	}
+	if plugins.errorOutput != nil {
+		p.SetErrorOutput(plugins.errorOutput)
+	}
+
+	return p
+}
+
+// pipelinesHTTP contains the running pipelines and the mux of their handlers
+type pipelinesHTTP struct {
+	pipelines []*pipeline.Pipeline
+	mux       *http.ServeMux
+}
+
+// setupPipelinesHandlers replaces the mux of the pipelines handlers since the handlers can't be removed from the mux
+func (f *FileD) setupPipelinesHandlers() {
+	mux := http.NewServeMux()
+	for _, p := range f.Pipelines {
+		p.SetupHTTPHandlers(mux)
+	}
+	f.pipelinesHTTP.Store(&pipelinesHTTP{
+		pipelines: append([]*pipeline.Pipeline(nil), f.Pipelines...),
+		mux:       mux,
 	})
+}
+
+func (f *FileD) servePipelines(w http.ResponseWriter, r *http.Request) {
+	h := f.pipelinesHTTP.Load()
+	if h == nil {
+		http.NotFound(w, r)
+		return
+	}
+	h.mux.ServeHTTP(w, r)
+}
+
+// getInput returns the input and the actions it requires
+func (f *FileD) getInput(pipelineConfig *cfg.PipelineConfig, values map[string]int) (*pipeline.InputPluginInfo, []*pipeline.ActionPluginStaticInfo, error) {
+	inputInfo, err := f.getStaticInfo(pipelineConfig, pipeline.PluginKindInput, values)
+	if err != nil {
+		return nil, nil, err
+	}
 
+	actions := make([]*pipeline.ActionPluginStaticInfo, 0, len(inputInfo.AdditionalActions))
 	for _, actionType := range inputInfo.AdditionalActions {
-		actionInfo := f.plugins.GetActionByType(actionType)
+		actionInfo, err := f.plugins.Find(pipeline.PluginKindAction, actionType)
+		if err != nil {
+			return nil, nil, err
+		}
 
 		infoCopy := *actionInfo
 		infoCopy.Config = inputInfo.Config
 		infoCopy.Type = actionType
 
-		p.AddAction(&pipeline.ActionPluginStaticInfo{
+		actions = append(actions, &pipeline.ActionPluginStaticInfo{
 			PluginStaticInfo: &infoCopy,
 			MatchConditions:  pipeline.MatchConditions{},
 		})
 	}
 
-	return nil
+	return &pipeline.InputPluginInfo{
+		PluginStaticInfo:  inputInfo,
+		PluginRuntimeInfo: f.instantiatePlugin(inputInfo),
+	}, actions, nil
 }
 
-func (f *FileD) setupActions(p *pipeline.Pipeline, pipelineConfig *cfg.PipelineConfig, values map[string]int) {
-	actions := pipelineConfig.Raw.Get("actions")
-	for index := range actions.MustArray() {
-		actionJSON := actions.GetIndex(index)
+func (f *FileD) getActions(pipelineConfig *cfg.PipelineConfig, values map[string]int) ([]*pipeline.ActionPluginStaticInfo, error) {
+	actionsJSON := pipelineConfig.Raw.Get("actions")
+	actions := make([]*pipeline.ActionPluginStaticInfo, 0, len(actionsJSON.MustArray()))
+	for index := range actionsJSON.MustArray() {
+		actionJSON := actionsJSON.GetIndex(index)
 		if actionJSON.MustMap() == nil {
-			logger.Fatalf("empty action #%d for pipeline %q", index, p.Name)
+			return nil, fmt.Errorf("empty action #%d", index)
 		}
 
 		t := actionJSON.Get("type").MustString()
 		if t == "" {
-			logger.Fatalf("action #%d doesn't provide type %q", index, p.Name)
+			return nil, fmt.Errorf("action #%d doesn't provide type", index)
 		}
-		f.setupAction(p, index, t, actionJSON, values)
+
+		action, err := f.getAction(index, t, actionJSON, values)
+		if err != nil {
+			return nil, err
+		}
+		actions = append(actions, action)
 	}
+
+	return actions, nil
 }
 
-func (f *FileD) setupAction(p *pipeline.Pipeline, index int, t string, actionJSON *simplejson.Json, values map[string]int) {
-	logger.Infof("creating action with type %q for pipeline %q", t, p.Name)
-	info := f.plugins.GetActionByType(t)
+func (f *FileD) getAction(index int, t string, actionJSON *simplejson.Json, values map[string]int) (*pipeline.ActionPluginStaticInfo, error) {
+	logger.Infof("creating action with type %q", t)
+	info, err := f.plugins.Find(pipeline.PluginKindAction, t)
+	if err != nil {
+		return nil, err
+	}
 
 	matchMode := extractMatchMode(actionJSON)
 	if matchMode == pipeline.MatchModeUnknown {
-		logger.Fatalf("unknown match_mode value for action %d/%s in pipeline %q", index, t, p.Name)
+		return nil, fmt.Errorf("unknown match_mode value for action %d/%s", index, t)
 	}
 	matchInvert := extractMatchInvert(actionJSON)
 	conditions, err := extractConditions(actionJSON.Get("match_fields"))
 	if err != nil {
-		logger.Fatalf("can't extract conditions for action %d/%s in pipeline %q: %s", index, t, p.Name, err.Error())
+		return nil, fmt.Errorf("can't extract conditions for action %d/%s: %w", index, t, err)
+	}
+	matchExpr, err := extractMatchExpr(actionJSON)
+	if err != nil {
+		return nil, fmt.Errorf("can't extract match expression for action %d/%s: %w", index, t, err)
 	}
 	metricName, metricLabels, skipStatus := extractMetrics(actionJSON)
+	errorEventsDisabled := extractErrorEventsDisabled(actionJSON)
 	configJSON := makeActionJSON(actionJSON)
 
 	_, config := info.Factory()
 	if err := DecodeConfig(config, configJSON); err != nil {
-		logger.Fatalf("can't unmarshal config for %s action in pipeline %q: %s", info.Type, p.Name, err.Error())
+		return nil, fmt.Errorf("can't unmarshal config for %s action: %w", info.Type, err)
 	}
 
 	err = cfg.Parse(config, values)
 	if err != nil {
-		logger.Fatalf("wrong config for %q action in pipeline %q: %s", info.Type, p.Name, err.Error())
+		return nil, fmt.Errorf("wrong config for %q action: %w", info.Type, err)
 	}
 
 	infoCopy := *info
 	infoCopy.Config = config
 	infoCopy.Type = t
+	infoCopy.ErrorEventsDisabled = errorEventsDisabled
 
-	p.AddAction(&pipeline.ActionPluginStaticInfo{
+	return &pipeline.ActionPluginStaticInfo{
 		PluginStaticInfo: &infoCopy,
 		MatchConditions:  conditions,
 		MatchMode:        matchMode,
+		MatchExpr:        matchExpr,
 		MetricName:       metricName,
 		MetricLabels:     metricLabels,
 		MetricSkipStatus: skipStatus,
 		MatchInvert:      matchInvert,
-	})
+	}, nil
 }
 
-func (f *FileD) setupOutput(p *pipeline.Pipeline, pipelineConfig *cfg.PipelineConfig, values map[string]int) error {
+func (f *FileD) getOutput(pipelineConfig *cfg.PipelineConfig, values map[string]int) (*pipeline.OutputPluginInfo, error) {
+	if _, ok := pipelineConfig.Raw.CheckGet("outputs"); ok {
+		return f.getRouter(pipelineConfig, values)
+	}
+
 	info, err := f.getStaticInfo(pipelineConfig, pipeline.PluginKindOutput, values)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	p.SetOutput(&pipeline.OutputPluginInfo{
+	return &pipeline.OutputPluginInfo{
 		PluginStaticInfo:  info,
 		PluginRuntimeInfo: f.instantiatePlugin(info),
+	}, nil
+}
+
+// getRouter returns the router of the named outputs of the `outputs` section,
+// the events are passed to them by the `routes` and the `default_outputs`
+// or to all of them if there are no routes
+func (f *FileD) getRouter(pipelineConfig *cfg.PipelineConfig, values map[string]int) (*pipeline.OutputPluginInfo, error) {
+	if _, ok := pipelineConfig.Raw.CheckGet(string(pipeline.PluginKindOutput)); ok {
+		return nil, fmt.Errorf("both output and outputs are provided")
+	}
+
+	outputsJSON := pipelineConfig.Raw.Get("outputs")
+	names := make([]string, 0, len(outputsJSON.MustMap()))
+	for name := range outputsJSON.MustMap() {
+		if !outputNameRe.MatchString(name) {
+			return nil, fmt.Errorf("wrong output name %q, it should match %s", name, outputNameRe.String())
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	outputs := make([]pipeline.RouterOutput, 0, len(names))
+	for _, name := range names {
+		configJSON := outputsJSON.Get(name)
+		if configJSON.MustMap() == nil {
+			return nil, fmt.Errorf("empty output %q", name)
+		}
+
+		info, err := f.getPluginStaticInfo(configJSON, pipeline.PluginKindOutput, values)
+		if err != nil {
+			return nil, fmt.Errorf("output %q: %w", name, err)
+		}
+		outputs = append(outputs, pipeline.RouterOutput{
+			Name: name,
+			Info: &pipeline.OutputPluginInfo{
+				PluginStaticInfo:  info,
+				PluginRuntimeInfo: f.instantiatePlugin(info),
+			},
+		})
+	}
+
+	routesJSON := pipelineConfig.Raw.Get("routes")
+	routes := make([]pipeline.Route, 0, len(routesJSON.MustArray()))
+	for index := range routesJSON.MustArray() {
+		routeJSON := routesJSON.GetIndex(index)
+
+		matchMode := extractMatchMode(routeJSON)
+		if matchMode == pipeline.MatchModeUnknown {
+			return nil, fmt.Errorf("unknown match_mode value for route #%d", index)
+		}
+		conditions, err := extractConditions(routeJSON.Get("match_fields"))
+		if err != nil {
+			return nil, fmt.Errorf("can't extract conditions for route #%d: %w", index, err)
+		}
+		matchExpr, err := extractMatchExpr(routeJSON)
+		if err != nil {
+			return nil, fmt.Errorf("can't extract match expression for route #%d: %w", index, err)
+		}
+
+		routes = append(routes, pipeline.Route{
+			Outputs:         routeJSON.Get("outputs").MustStringArray(),
+			MatchConditions: conditions,
+			MatchMode:       matchMode,
+			MatchExpr:       matchExpr,
+			MatchInvert:     extractMatchInvert(routeJSON),
+			Continue:        routeJSON.Get("continue").MustBool(),
+		})
+	}
+
+	var commitTimeout time.Duration
+	if str := pipelineConfig.Raw.Get("commit_timeout").MustString(); str != "" {
+		var err error
+		commitTimeout, err = time.ParseDuration(str)
+		if err != nil {
+			return nil, fmt.Errorf("can't parse commit timeout: %w", err)
+		}
+	}
+
+	router, err := pipeline.NewRouter(outputs, pipeline.RouterOptions{
+		Routes:          routes,
+		DefaultOutputs:  pipelineConfig.Raw.Get("default_outputs").MustStringArray(),
+		RequiredOutputs: pipelineConfig.Raw.Get("required_outputs").MustStringArray(),
+		CommitTimeout:   commitTimeout,
 	})
+	if err != nil {
+		return nil, err
+	}
 
-	return nil
+	return &pipeline.OutputPluginInfo{
+		PluginStaticInfo: &pipeline.PluginStaticInfo{
+			Type: pipeline.RouterType,
+		},
+		PluginRuntimeInfo: &pipeline.PluginRuntimeInfo{
+			Plugin: router,
+		},
+	}, nil
+}
+
+// getSectionOutput returns the output of the section which has the same format as the `output` one,
+// e.g. `dead_letter` or `error_output`, it returns nil if the section isn't set
+func (f *FileD) getSectionOutput(pipelineConfig *cfg.PipelineConfig, section string, values map[string]int) (*pipeline.OutputPluginInfo, error) {
+	configJSON := pipelineConfig.Raw.Get(section)
+	if configJSON.MustMap() == nil {
+		return nil, nil
+	}
+
+	info, err := f.getPluginStaticInfo(configJSON, pipeline.PluginKindOutput, values)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pipeline.OutputPluginInfo{
+		PluginStaticInfo:  info,
+		PluginRuntimeInfo: f.instantiatePlugin(info),
+	}, nil
 }
 
 func (f *FileD) instantiatePlugin(info *pipeline.PluginStaticInfo) *pipeline.PluginRuntimeInfo {
@@ -221,14 +466,26 @@ func (f *FileD) getStaticInfo(pipelineConfig *cfg.PipelineConfig, pluginKind pip
 	if configJSON.MustMap() == nil {
 		return nil, fmt.Errorf("no %s plugin provided", pluginKind)
 	}
+	return f.getPluginStaticInfo(configJSON, pluginKind, values)
+}
+
+func (f *FileD) getPluginStaticInfo(configJSON *simplejson.Json, pluginKind pipeline.PluginKind, values map[string]int) (*pipeline.PluginStaticInfo, error) {
 	t := configJSON.Get("type").MustString()
 	// delete for success decode into config
 	configJSON.Del("type")
+	errorEventsDisabled := false
+	if pluginKind == pipeline.PluginKindOutput {
+		errorEventsDisabled = extractErrorEventsDisabled(configJSON)
+		configJSON.Del("error_events")
+	}
 	if t == "" {
 		return nil, fmt.Errorf("%s doesn't have type", pluginKind)
 	}
 	logger.Infof("creating %s with type %q", pluginKind, t)
-	info := f.plugins.Get(pluginKind, t)
+	info, err := f.plugins.Find(pluginKind, t)
+	if err != nil {
+		return nil, err
+	}
 	configJson, err := configJSON.Encode()
 	if err != nil {
 		logger.Panicf("can't create config json for %s", t)
@@ -240,11 +497,12 @@ func (f *FileD) getStaticInfo(pipelineConfig *cfg.PipelineConfig, pluginKind pip
 
 	err = cfg.Parse(config, values)
 	if err != nil {
-		logger.Fatalf("wrong config for %q plugin %q: %s", pluginKind, t, err.Error())
+		return nil, fmt.Errorf("wrong config for %q plugin %q: %w", pluginKind, t, err)
 	}
 
 	infoCopy := *info
 	infoCopy.Config = config
+	infoCopy.ErrorEventsDisabled = errorEventsDisabled
 
 	return &infoCopy, nil
 }
@@ -282,13 +540,14 @@ func (f *FileD) startHTTP() {
 	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
 	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
 
-	mux.HandleFunc("/live", f.serveLiveReady)
-	mux.HandleFunc("/ready", f.serveLiveReady)
+	mux.HandleFunc("/live", f.serveLive)
+	mux.HandleFunc("/ready", f.serveReady)
 	mux.HandleFunc("/freeosmem", f.serveFreeOsMem)
 	mux.Handle("/metrics", promhttp.InstrumentMetricHandler(
 		f.registry, promhttp.HandlerFor(f.registry, promhttp.HandlerOpts{}),
 	))
 	mux.Handle("/log/level", logger.Level)
+	mux.HandleFunc("/pipelines/", f.servePipelines)
 
 	// serve value changers to set runtime values
 	mux.Handle("/runtime/mutex-profile-fraction", valueChangerHandler{
@@ -326,8 +585,29 @@ func (f *FileD) serveFreeOsMem(_ http.ResponseWriter, _ *http.Request) {
 	logger.Infof("free OS memory OK")
 }
 
-func (f *FileD) serveLiveReady(_ http.ResponseWriter, _ *http.Request) {
-	logger.Infof("live/ready OK")
+// serveLive fails only if some pipeline is stuck, so file.d is restarted
+func (f *FileD) serveLive(w http.ResponseWriter, _ *http.Request) {
+	f.serveHealth(w, "live", (*pipeline.Pipeline).Live)
+}
+
+// serveReady fails if some pipeline can't accept the events, e.g. its output is in the sustained backpressure
+func (f *FileD) serveReady(w http.ResponseWriter, _ *http.Request) {
+	f.serveHealth(w, "ready", (*pipeline.Pipeline).Ready)
+}
+
+func (f *FileD) serveHealth(w http.ResponseWriter, check string, fn func(p *pipeline.Pipeline) error) {
+	h := f.pipelinesHTTP.Load()
+	if h != nil {
+		for _, p := range h.pipelines {
+			if err := fn(p); err != nil {
+				logger.Errorf("%s check failed for pipeline %q: %s", check, p.Name, err.Error())
+				w.WriteHeader(http.StatusServiceUnavailable)
+				_, _ = fmt.Fprintf(w, "pipeline %q: %s\n", p.Name, err.Error())
+				return
+			}
+		}
+	}
+	logger.Infof("%s OK", check)
 }
 
 type valueChangerHandler struct {
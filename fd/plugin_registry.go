@@ -1,6 +1,8 @@
 package fd
 
 import (
+	"fmt"
+
 	"github.com/ozontech/file.d/logger"
 	"github.com/ozontech/file.d/pipeline"
 )
@@ -14,17 +16,25 @@ type PluginRegistry struct {
 }
 
 func (r *PluginRegistry) Get(kind pipeline.PluginKind, t string) *pipeline.PluginStaticInfo {
-	id := r.MakeID(kind, t)
-
-	info := r.plugins[id]
-	if info == nil {
-		logger.Fatalf("can't find plugin kind=%s type=%s", kind, t)
+	info, err := r.Find(kind, t)
+	if err != nil {
+		logger.Fatal(err)
 		return nil
 	}
 
 	return info
 }
 
+// Find returns the error instead of the exit if the plugin isn't registered.
+func (r *PluginRegistry) Find(kind pipeline.PluginKind, t string) (*pipeline.PluginStaticInfo, error) {
+	info := r.plugins[r.MakeID(kind, t)]
+	if info == nil {
+		return nil, fmt.Errorf("can't find plugin kind=%s type=%s", kind, t)
+	}
+
+	return info, nil
+}
+
 func (r *PluginRegistry) GetActionByType(t string) *pipeline.PluginStaticInfo {
 	id := r.MakeID(pipeline.PluginKindAction, t)
 
@@ -0,0 +1,97 @@
+package fd
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/bitly/go-simplejson"
+	"github.com/ozontech/file.d/cfg"
+	"github.com/ozontech/file.d/logger"
+	"github.com/ozontech/file.d/pipeline"
+)
+
+// Reload applies the config without the restart. The changed pipelines are stopped, so the outputs flush
+// the batches and the inputs save the offsets, and they're started with the new config from the saved offsets.
+// The removed pipelines are stopped, the added ones are started and the unchanged ones keep working.
+// The config is rejected entirely if some pipeline can't be created or the input of the running pipeline is changed.
+func (f *FileD) Reload(config *cfg.Config) error {
+	configs := make(map[string][]byte, len(config.Pipelines))
+	changed := make(map[string]*pipelinePlugins)
+	for name, pipelineConfig := range config.Pipelines {
+		raw, err := pipelineConfig.Raw.Encode()
+		if err != nil {
+			return fmt.Errorf("can't encode config of pipeline %q: %w", name, err)
+		}
+		configs[name] = raw
+
+		old, ok := f.pipelineConfigs[name]
+		if ok && bytes.Equal(old, raw) {
+			continue
+		}
+		if ok {
+			equal, err := equalInputs(old, raw)
+			if err != nil {
+				return fmt.Errorf("can't compare inputs of pipeline %q: %w", name, err)
+			}
+			if !equal {
+				return fmt.Errorf("input of pipeline %q is changed, it can't be applied without the restart", name)
+			}
+		}
+
+		plugins, err := f.preparePipeline(pipelineConfig)
+		if err != nil {
+			return fmt.Errorf("can't create pipeline %q: %w", name, err)
+		}
+		changed[name] = plugins
+	}
+
+	pipelines := make([]*pipeline.Pipeline, 0, len(config.Pipelines))
+	for _, p := range f.Pipelines {
+		_, isChanged := changed[p.Name]
+		if _, ok := config.Pipelines[p.Name]; ok && !isChanged {
+			pipelines = append(pipelines, p)
+			continue
+		}
+
+		logger.Infof("stopping pipeline %q to reload config", p.Name)
+		p.Stop()
+	}
+
+	started := make([]*pipeline.Pipeline, 0, len(changed))
+	for name, plugins := range changed {
+		p := f.createPipeline(name, plugins)
+		pipelines = append(pipelines, p)
+		started = append(started, p)
+	}
+
+	f.config = config
+	f.Pipelines = pipelines
+	f.pipelineConfigs = configs
+	f.setupPipelinesHandlers()
+	for _, p := range started {
+		p.Start()
+	}
+
+	logger.Infof("config is reloaded: pipelines=%d, started=%d", len(pipelines), len(started))
+	return nil
+}
+
+func equalInputs(a, b []byte) (bool, error) {
+	inputA, err := encodeInput(a)
+	if err != nil {
+		return false, err
+	}
+	inputB, err := encodeInput(b)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(inputA, inputB), nil
+}
+
+func encodeInput(raw []byte) ([]byte, error) {
+	config, err := simplejson.NewJson(raw)
+	if err != nil {
+		return nil, err
+	}
+	return config.Get(string(pipeline.PluginKindInput)).Encode()
+}
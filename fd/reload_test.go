@@ -0,0 +1,109 @@
+package fd_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/bitly/go-simplejson"
+	"github.com/ozontech/file.d/cfg"
+	"github.com/ozontech/file.d/fd"
+	"github.com/ozontech/file.d/pipeline"
+	_ "github.com/ozontech/file.d/plugin/action/discard"
+	_ "github.com/ozontech/file.d/plugin/action/join"
+	_ "github.com/ozontech/file.d/plugin/input/fake"
+	_ "github.com/ozontech/file.d/plugin/output/devnull"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newReloadConfig(t *testing.T, pipelines map[string]string) *cfg.Config {
+	t.Helper()
+
+	config := cfg.NewConfig()
+	for name, raw := range pipelines {
+		json, err := simplejson.NewJson([]byte(raw))
+		require.NoError(t, err)
+		config.Pipelines[name] = &cfg.PipelineConfig{Raw: json}
+	}
+	return config
+}
+
+func pipelinesByName(fileD *fd.FileD) map[string]*pipeline.Pipeline {
+	result := make(map[string]*pipeline.Pipeline, len(fileD.Pipelines))
+	for _, p := range fileD.Pipelines {
+		result[p.Name] = p
+	}
+	return result
+}
+
+func TestReload(t *testing.T) {
+	const (
+		simple     = `{"input":{"type":"fake"},"output":{"type":"devnull"}}`
+		withAction = `{"input":{"type":"fake"},"actions":[{"type":"discard"}],"output":{"type":"devnull"}}`
+	)
+
+	fileD := fd.New(newReloadConfig(t, map[string]string{
+		"changed":   simple,
+		"unchanged": simple,
+		"removed":   simple,
+	}), "off")
+	fileD.Start()
+	before := pipelinesByName(fileD)
+
+	err := fileD.Reload(newReloadConfig(t, map[string]string{
+		"changed":   withAction,
+		"unchanged": simple,
+		"added":     simple,
+	}))
+	require.NoError(t, err)
+
+	after := pipelinesByName(fileD)
+	require.Len(t, after, 3)
+	assert.Same(t, before["unchanged"], after["unchanged"], "unchanged pipeline is restarted")
+	assert.NotSame(t, before["changed"], after["changed"], "changed pipeline isn't restarted")
+	assert.Contains(t, after, "added")
+	assert.NotContains(t, after, "removed")
+
+	tests := []struct {
+		name      string
+		pipelines map[string]string
+	}{
+		{
+			name: "changed_input",
+			pipelines: map[string]string{
+				"changed":   `{"input":{"type":"fake","offsets_op":"reset"},"actions":[{"type":"discard"}],"output":{"type":"devnull"}}`,
+				"unchanged": simple,
+			},
+		},
+		{
+			name: "unknown_plugin",
+			pipelines: map[string]string{
+				"changed":   withAction,
+				"unchanged": `{"input":{"type":"fake"},"output":{"type":"unknown"}}`,
+			},
+		},
+		{
+			name: "wrong_settings",
+			pipelines: map[string]string{
+				"changed":   withAction,
+				"unchanged": `{"settings":{"event_timeout":"wrong"},"input":{"type":"fake"},"output":{"type":"devnull"}}`,
+			},
+		},
+		{
+			name: "ordering_key_with_join",
+			pipelines: map[string]string{
+				"changed":   withAction,
+				"unchanged": `{"settings":{"ordering_key":"user.id"},"input":{"type":"fake"},"actions":[{"type":"join","field":"message","start":"/^start/","continue":"/^ /"}],"output":{"type":"devnull"}}`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := fileD.Reload(newReloadConfig(t, tt.pipelines))
+			require.Error(t, err)
+			assert.Equal(t, after, pipelinesByName(fileD), "rejected config is partially applied")
+		})
+	}
+
+	require.NoError(t, fileD.Stop(context.Background()))
+}
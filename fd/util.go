@@ -3,6 +3,7 @@ package fd
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"time"
 
@@ -11,19 +12,33 @@ import (
 	"github.com/ozontech/file.d/cfg/matchrule"
 	"github.com/ozontech/file.d/logger"
 	"github.com/ozontech/file.d/pipeline"
+	"github.com/ozontech/file.d/pipeline/antispam"
 )
 
-func extractPipelineParams(settings *simplejson.Json) *pipeline.Settings {
+func extractPipelineParams(settings *simplejson.Json) (*pipeline.Settings, error) {
 	capacity := pipeline.DefaultCapacity
 	antispamThreshold := 0
 	var antispamExceptions matchrule.RuleSets
 	avgInputEventSize := pipeline.DefaultAvgInputEventSize
 	maxInputEventSize := pipeline.DefaultMaxInputEventSize
+	maxEventSizePolicy := pipeline.MaxEventSizeDrop
 	streamField := pipeline.DefaultStreamField
 	maintenanceInterval := pipeline.DefaultMaintenanceInterval
 	decoder := "auto"
 	isStrict := false
 	eventTimeout := pipeline.DefaultEventTimeout
+	var maxEventAge time.Duration
+	circuitBreaker := pipeline.BatcherCircuitBreaker{Cooldown: pipeline.DefaultCircuitBreakerCooldown}
+	var health pipeline.HealthSettings
+	sourceInflightLimit := 0
+	errorEvents := pipeline.ErrorEventsSettings{
+		PerSec:     pipeline.DefaultErrorEventsPerSec,
+		SampleSize: pipeline.DefaultErrorEventSampleSize,
+	}
+	sampleRate := 1.0
+	maxEventsPerSec := 0
+	var orderingKey []string
+	orderingLanes := 0
 
 	if settings != nil {
 		val := settings.Get("capacity").MustInt()
@@ -41,7 +56,18 @@ func extractPipelineParams(settings *simplejson.Json) *pipeline.Settings {
 			maxInputEventSize = val
 		}
 
-		str := settings.Get("decoder").MustString()
+		str := settings.Get("max_event_size_policy").MustString()
+		switch str {
+		case "", "drop":
+		case "truncate":
+			maxEventSizePolicy = pipeline.MaxEventSizeTruncate
+		case "dead_letter":
+			maxEventSizePolicy = pipeline.MaxEventSizeDeadLetter
+		default:
+			return nil, fmt.Errorf("unknown max event size policy %q", str)
+		}
+
+		str = settings.Get("decoder").MustString()
 		if str != "" {
 			decoder = str
 		}
@@ -55,7 +81,7 @@ func extractPipelineParams(settings *simplejson.Json) *pipeline.Settings {
 		if str != "" {
 			i, err := time.ParseDuration(str)
 			if err != nil {
-				logger.Fatalf("can't parse pipeline maintenance interval: %s", err.Error())
+				return nil, fmt.Errorf("can't parse pipeline maintenance interval: %w", err)
 			}
 			maintenanceInterval = i
 		}
@@ -64,13 +90,114 @@ func extractPipelineParams(settings *simplejson.Json) *pipeline.Settings {
 		if str != "" {
 			i, err := time.ParseDuration(str)
 			if err != nil {
-				logger.Fatalf("can't parse pipeline event timeout: %s", err.Error())
+				return nil, fmt.Errorf("can't parse pipeline event timeout: %w", err)
 			}
 			eventTimeout = i
 		}
 
-		antispamThreshold = settings.Get("antispam_threshold").MustInt()
-		antispamThreshold *= int(maintenanceInterval / time.Second)
+		str = settings.Get("max_event_age").MustString()
+		if str != "" {
+			i, err := time.ParseDuration(str)
+			if err != nil {
+				return nil, fmt.Errorf("can't parse pipeline max event age: %w", err)
+			}
+			maxEventAge = i
+		}
+
+		circuitBreaker.FailureThreshold = settings.Get("circuit_breaker_threshold").MustInt()
+		if circuitBreaker.FailureThreshold < 0 {
+			return nil, fmt.Errorf("circuit breaker threshold can't be negative")
+		}
+
+		str = settings.Get("circuit_breaker_cooldown").MustString()
+		if str != "" {
+			i, err := time.ParseDuration(str)
+			if err != nil {
+				return nil, fmt.Errorf("can't parse pipeline circuit breaker cooldown: %w", err)
+			}
+			circuitBreaker.Cooldown = i
+		}
+
+		str = settings.Get("ready_backpressure_timeout").MustString()
+		if str != "" {
+			i, err := time.ParseDuration(str)
+			if err != nil {
+				return nil, fmt.Errorf("can't parse pipeline ready backpressure timeout: %w", err)
+			}
+			health.BackpressureTimeout = i
+		}
+
+		str = settings.Get("ready_flush_timeout").MustString()
+		if str != "" {
+			i, err := time.ParseDuration(str)
+			if err != nil {
+				return nil, fmt.Errorf("can't parse pipeline ready flush timeout: %w", err)
+			}
+			health.FlushTimeout = i
+		}
+
+		health.NotReadyOnOpenCircuit = settings.Get("not_ready_on_open_circuit").MustBool()
+
+		str = settings.Get("live_deadlock_timeout").MustString()
+		if str != "" {
+			i, err := time.ParseDuration(str)
+			if err != nil {
+				return nil, fmt.Errorf("can't parse pipeline live deadlock timeout: %w", err)
+			}
+			health.DeadlockTimeout = i
+		}
+
+		sourceInflightLimit = settings.Get("source_inflight_limit").MustInt()
+		if sourceInflightLimit < 0 {
+			return nil, fmt.Errorf("source inflight limit can't be negative")
+		}
+		if sourceInflightLimit >= capacity {
+			logger.Warnf("source_inflight_limit=%d isn't less than the capacity=%d, so the source can take all the events", sourceInflightLimit, capacity)
+		}
+
+		if _, has := settings.CheckGet("sample_rate"); has {
+			sampleRate = settings.Get("sample_rate").MustFloat64()
+			if sampleRate <= 0 || sampleRate > 1 {
+				return nil, fmt.Errorf("sample rate should be in (0, 1]")
+			}
+		}
+
+		maxEventsPerSec = settings.Get("max_events_per_sec").MustInt()
+		if maxEventsPerSec < 0 {
+			return nil, fmt.Errorf("max events per sec can't be negative")
+		}
+
+		if _, has := settings.CheckGet("error_events_per_sec"); has {
+			errorEvents.PerSec = settings.Get("error_events_per_sec").MustInt()
+			if errorEvents.PerSec <= 0 {
+				return nil, fmt.Errorf("error events per sec should be positive")
+			}
+		}
+
+		if _, has := settings.CheckGet("error_event_sample_size"); has {
+			errorEvents.SampleSize = settings.Get("error_event_sample_size").MustInt()
+			if errorEvents.SampleSize < 0 {
+				return nil, fmt.Errorf("error event sample size can't be negative")
+			}
+		}
+
+		str = settings.Get("ordering_key").MustString()
+		if str != "" {
+			orderingKey = cfg.ParseFieldSelector(str)
+			orderingLanes = pipeline.DefaultOrderingLanes
+		}
+
+		if _, has := settings.CheckGet("ordering_lanes"); has {
+			if orderingKey == nil {
+				return nil, fmt.Errorf("ordering lanes can't be set without the ordering key")
+			}
+			orderingLanes = settings.Get("ordering_lanes").MustInt()
+			if orderingLanes <= 0 {
+				return nil, fmt.Errorf("ordering lanes should be positive")
+			}
+		}
+
+		antispamThreshold = antispam.ScaleThreshold(settings.Get("antispam_threshold").MustInt(), maintenanceInterval)
 		if antispamThreshold < 0 {
 			logger.Warn("negative antispam_threshold value, antispam disabled")
 			antispamThreshold = 0
@@ -79,7 +206,7 @@ func extractPipelineParams(settings *simplejson.Json) *pipeline.Settings {
 		var err error
 		antispamExceptions, err = extractExceptions(settings)
 		if err != nil {
-			logger.Fatalf("extract exceptions: %s", err)
+			return nil, fmt.Errorf("extract exceptions: %w", err)
 		}
 		antispamExceptions.Prepare()
 
@@ -91,13 +218,23 @@ func extractPipelineParams(settings *simplejson.Json) *pipeline.Settings {
 		Capacity:            capacity,
 		AvgEventSize:        avgInputEventSize,
 		MaxEventSize:        maxInputEventSize,
+		MaxEventSizePolicy:  maxEventSizePolicy,
+		ErrorEvents:         errorEvents,
 		AntispamThreshold:   antispamThreshold,
 		AntispamExceptions:  antispamExceptions,
+		SourceInflightLimit: sourceInflightLimit,
+		SampleRate:          sampleRate,
+		MaxEventsPerSec:     maxEventsPerSec,
 		MaintenanceInterval: maintenanceInterval,
 		EventTimeout:        eventTimeout,
+		MaxEventAge:         maxEventAge,
+		CircuitBreaker:      circuitBreaker,
+		Health:              health,
 		StreamField:         streamField,
 		IsStrict:            isStrict,
-	}
+		OrderingKey:         orderingKey,
+		OrderingLanes:       orderingLanes,
+	}, nil
 }
 
 func extractExceptions(settings *simplejson.Json) (matchrule.RuleSets, error) {
@@ -128,10 +265,14 @@ func extractMatchInvert(actionJSON *simplejson.Json) bool {
 }
 
 func extractConditions(condJSON *simplejson.Json) (pipeline.MatchConditions, error) {
-	conditions := make(pipeline.MatchConditions, 0)
-	for field := range condJSON.MustMap() {
-		obj := condJSON.Get(field).Interface()
+	return ParseMatchConditions(condJSON.MustMap())
+}
 
+// ParseMatchConditions builds the match conditions of the `match_fields` format,
+// so the plugins can match the events the same way as the actions are matched.
+func ParseMatchConditions(fields map[string]any) (pipeline.MatchConditions, error) {
+	conditions := make(pipeline.MatchConditions, 0)
+	for field, obj := range fields {
 		condition := pipeline.MatchCondition{
 			Field: cfg.ParseFieldSelector(field),
 		}
@@ -170,6 +311,18 @@ func extractConditions(condJSON *simplejson.Json) (pipeline.MatchConditions, err
 	return conditions, nil
 }
 
+// extractMatchExpr compiles the `match_expr` field, it can't be used with the `match_fields` one
+func extractMatchExpr(pluginJSON *simplejson.Json) (*pipeline.MatchExpr, error) {
+	expr := pluginJSON.Get("match_expr").MustString()
+	if expr == "" {
+		return nil, nil
+	}
+	if _, ok := pluginJSON.CheckGet("match_fields"); ok {
+		return nil, errors.New("match_fields and match_expr can't be used together")
+	}
+	return pipeline.ParseMatchExpr(expr)
+}
+
 func extractMetrics(actionJSON *simplejson.Json) (string, []string, bool) {
 	metricName := actionJSON.Get("metric_name").MustString()
 	metricLabels := actionJSON.Get("metric_labels").MustStringArray()
@@ -180,14 +333,21 @@ func extractMetrics(actionJSON *simplejson.Json) (string, []string, bool) {
 	return metricName, metricLabels, skipStatus
 }
 
+// extractErrorEventsDisabled returns true if the `error_events` field of the plugin is false
+func extractErrorEventsDisabled(pluginJSON *simplejson.Json) bool {
+	return !pluginJSON.Get("error_events").MustBool(true)
+}
+
 func makeActionJSON(actionJSON *simplejson.Json) []byte {
 	actionJSON.Del("type")
 	actionJSON.Del("match_fields")
 	actionJSON.Del("match_mode")
+	actionJSON.Del("match_expr")
 	actionJSON.Del("metric_name")
 	actionJSON.Del("metric_labels")
 	actionJSON.Del("metric_skip_status")
 	actionJSON.Del("match_invert")
+	actionJSON.Del("error_events")
 	configJson, err := actionJSON.Encode()
 	if err != nil {
 		logger.Panicf("can't create action json")
@@ -33,3 +33,25 @@ func Test_extractConditions(t *testing.T) {
 	}
 	require.Equal(t, expected, got)
 }
+
+func Test_extractPipelineParamsOrdering(t *testing.T) {
+	j, err := simplejson.NewJson([]byte(`{"ordering_key": "user.id"}`))
+	require.NoError(t, err)
+	settings, err := extractPipelineParams(j)
+	require.NoError(t, err)
+	require.Equal(t, []string{"user", "id"}, settings.OrderingKey)
+	require.Equal(t, pipeline.DefaultOrderingLanes, settings.OrderingLanes)
+
+	j, err = simplejson.NewJson([]byte(`{"ordering_key": "user", "ordering_lanes": 4}`))
+	require.NoError(t, err)
+	settings, err = extractPipelineParams(j)
+	require.NoError(t, err)
+	require.Equal(t, 4, settings.OrderingLanes)
+
+	for _, s := range []string{`{"ordering_lanes": 4}`, `{"ordering_key": "user", "ordering_lanes": 0}`} {
+		j, err = simplejson.NewJson([]byte(s))
+		require.NoError(t, err)
+		_, err = extractPipelineParams(j)
+		require.Error(t, err, s)
+	}
+}
@@ -14,6 +14,8 @@ var (
 	SecondsBucketsDetailedNano = prometheus.ExponentialBuckets(0.000005, 2, 19) // covers range from 5ns to 1.3ms
 	SecondsBucketsDetailed     = prometheus.ExponentialBuckets(0.0005, 2, 16)   // covers range from 500us to 16.384s
 	SecondsBucketsLong         = prometheus.ExponentialBuckets(0.005, 2, 16)    // covers range from 5ms to 163.84s
+	CountBuckets               = prometheus.ExponentialBuckets(1, 2, 17)        // covers range from 1 to 65536
+	SizeBucketsBytes           = prometheus.ExponentialBuckets(1024, 4, 11)     // covers range from 1KiB to 1GiB
 )
 
 type Ctl struct {
@@ -107,3 +109,9 @@ func (mc *Ctl) RegisterHistogram(name, help string, buckets []float64, labels ..
 	mc.register.MustRegister(promHistogram)
 	return promHistogram
 }
+
+// Named returns the controller registering the metrics with the name appended to the subsystem,
+// so the plugins of the same type don't share the metrics.
+func (mc *Ctl) Named(name string) *Ctl {
+	return New(mc.subsystem+"_"+name, mc.register)
+}
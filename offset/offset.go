@@ -47,7 +47,11 @@ func (o *Offset) saveToTmp() error {
 	defer func(file *os.File) {
 		_ = file.Close()
 	}(file)
-	return o.Callback.Save(file)
+	if err := o.Callback.Save(file); err != nil {
+		return err
+	}
+	// the data should be on the disk before the rename, otherwise the file may be empty after the crash
+	return file.Sync()
 }
 
 func (o *Offset) Save() error {
@@ -0,0 +1,81 @@
+package pipeline
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ozontech/file.d/logger"
+)
+
+// adaptiveSize is an AIMD controller of max events per batch:
+// the size grows additively while OutFn is faster than the target latency
+// and is cut in half as soon as OutFn becomes slower or fails.
+type adaptiveSize struct {
+	mu sync.Mutex
+
+	min    int
+	max    int
+	step   int
+	target time.Duration
+
+	current int
+}
+
+func newAdaptiveSize(opts BatcherAdaptive, initial int) *adaptiveSize {
+	if opts.MinSizeCount <= 0 {
+		logger.Fatalf("adaptive batch min count should be greater than 0")
+	}
+	if opts.MaxSizeCount < opts.MinSizeCount {
+		logger.Fatalf("adaptive batch max count less than min count")
+	}
+	if opts.TargetLatency <= 0 {
+		logger.Fatalf("adaptive batch target latency should be greater than 0")
+	}
+
+	step := (opts.MaxSizeCount - opts.MinSizeCount) / 10
+	if step == 0 {
+		step = 1
+	}
+
+	a := &adaptiveSize{
+		min:     opts.MinSizeCount,
+		max:     opts.MaxSizeCount,
+		step:    step,
+		target:  opts.TargetLatency,
+		current: initial,
+	}
+	a.current = a.clamp(initial)
+
+	return a
+}
+
+func (a *adaptiveSize) clamp(size int) int {
+	if size < a.min {
+		return a.min
+	}
+	if size > a.max {
+		return a.max
+	}
+	return size
+}
+
+// observe adjusts the size by the OutFn result and returns the new size
+func (a *adaptiveSize) observe(latency time.Duration, success bool) int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if success && latency <= a.target {
+		a.current = a.clamp(a.current + a.step)
+	} else {
+		a.current = a.clamp(a.current / 2)
+	}
+
+	return a.current
+}
+
+func (a *adaptiveSize) sizeCount() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.current
+}
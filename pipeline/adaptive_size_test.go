@@ -0,0 +1,32 @@
+package pipeline
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdaptiveSize(t *testing.T) {
+	a := newAdaptiveSize(BatcherAdaptive{
+		MinSizeCount:  100,
+		MaxSizeCount:  1100,
+		TargetLatency: time.Second,
+	}, 5000)
+	assert.Equal(t, 1100, a.sizeCount(), "initial size should be clamped")
+
+	assert.Equal(t, 550, a.observe(time.Second*2, true))
+	assert.Equal(t, 275, a.observe(time.Millisecond, false))
+	assert.Equal(t, 375, a.observe(time.Millisecond, true))
+	assert.Equal(t, 475, a.observe(time.Second, true))
+
+	for i := 0; i < 10; i++ {
+		a.observe(time.Second*10, true)
+	}
+	assert.Equal(t, 100, a.sizeCount(), "size should be limited by min")
+
+	for i := 0; i < 20; i++ {
+		a.observe(time.Millisecond, true)
+	}
+	assert.Equal(t, 1100, a.sizeCount(), "size should be limited by max")
+}
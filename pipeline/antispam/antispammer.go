@@ -2,6 +2,8 @@ package antispam
 
 import (
 	"fmt"
+	"math"
+	"sort"
 	"sync"
 	"time"
 
@@ -17,24 +19,35 @@ import (
 // It can be useful when any application writes logs at speed faster than File.d can read it.
 //
 // Anti-spammer supports exceptions for cases where you need to guarantee delivery of an important events.
+// The threshold can be changed and the sources can be unbanned at runtime.
 type Antispammer struct {
-	unbanIterations int
-	threshold       int
-	mu              sync.RWMutex
-	sources         map[uint64]source
-	exceptions      matchrule.RuleSets
+	unbanIterations     int
+	maintenanceInterval time.Duration
+	// threshold is the current threshold, it's set to the configuredThreshold after the override expires
+	threshold           atomic.Int32
+	configuredThreshold int
+	mu                  sync.RWMutex
+	sources             map[uint64]*source
+	exceptions          matchrule.RuleSets
+	// thresholdExpiresAt is zero if the threshold isn't overridden or the override doesn't expire
+	thresholdExpiresAt time.Time
 
 	logger *zap.Logger
 
 	// antispammer metrics
-	activeMetric    *prometheus.GaugeVec
-	banMetric       *prometheus.GaugeVec
-	exceptionMetric *prometheus.CounterVec
+	activeMetric         *prometheus.GaugeVec
+	banMetric            *prometheus.GaugeVec
+	exceptionMetric      *prometheus.CounterVec
+	suppressedMetric     *prometheus.CounterVec
+	thresholdMetric      prometheus.Gauge
+	manualUnbannedMetric prometheus.Counter
 }
 
 type source struct {
 	counter atomic.Int32
 	name    string
+	// suppressed is set while the source is banned
+	suppressed atomic.Pointer[prometheus.Counter]
 }
 
 type Options struct {
@@ -47,6 +60,22 @@ type Options struct {
 	MetricsController *metric.Ctl
 }
 
+// BannedSource is the state of the banned source.
+type BannedSource struct {
+	ID            uint64    `json:"source_id"`
+	Name          string    `json:"source_name"`
+	EventsCounter int       `json:"events_counter"`
+	UnbanAt       time.Time `json:"unban_at"`
+}
+
+// State is the state of the antispammer.
+type State struct {
+	Threshold           int            `json:"threshold"`
+	ConfiguredThreshold int            `json:"configured_threshold"`
+	ThresholdExpiresAt  *time.Time     `json:"threshold_expires_at,omitempty"`
+	Banned              []BannedSource `json:"banned"`
+}
+
 func NewAntispammer(o Options) *Antispammer {
 	if o.Threshold > 0 {
 		o.Logger.Info("antispam enabled",
@@ -55,11 +84,12 @@ func NewAntispammer(o Options) *Antispammer {
 	}
 
 	a := &Antispammer{
-		unbanIterations: o.UnbanIterations,
-		threshold:       o.Threshold,
-		sources:         make(map[uint64]source),
-		exceptions:      o.Exceptions,
-		logger:          o.Logger,
+		unbanIterations:     o.UnbanIterations,
+		maintenanceInterval: o.MaintenanceInterval,
+		configuredThreshold: o.Threshold,
+		sources:             make(map[uint64]*source),
+		exceptions:          o.Exceptions,
+		logger:              o.Logger,
 		activeMetric: o.MetricsController.RegisterGauge("antispam_active",
 			"Gauge indicates whether the antispam is enabled",
 		),
@@ -70,7 +100,19 @@ func NewAntispammer(o Options) *Antispammer {
 			"How many times an exception match with an event",
 			"name",
 		),
+		suppressedMetric: o.MetricsController.RegisterCounter("antispam_suppressed_events_total",
+			"How many events of the banned source are suppressed, the source is removed after it's unbanned",
+			"source_name",
+		),
+		thresholdMetric: o.MetricsController.RegisterGauge("antispam_threshold",
+			"Current threshold of the antispam, it differs from the configured one while it's overridden",
+		).WithLabelValues(),
+		manualUnbannedMetric: o.MetricsController.RegisterCounter("antispam_manual_unbans_total",
+			"How many sources are unbanned by the admin endpoint",
+		).WithLabelValues(),
 	}
+	a.threshold.Store(int32(o.Threshold))
+	a.thresholdMetric.Set(float64(o.Threshold))
 
 	// not enabled by default
 	a.activeMetric.WithLabelValues().Set(0)
@@ -79,7 +121,8 @@ func NewAntispammer(o Options) *Antispammer {
 }
 
 func (a *Antispammer) IsSpam(id uint64, name string, isNewSource bool, event []byte) bool {
-	if a.threshold <= 0 {
+	threshold := a.threshold.Load()
+	if threshold <= 0 {
 		return false
 	}
 
@@ -102,10 +145,7 @@ func (a *Antispammer) IsSpam(id uint64, name string, isNewSource bool, event []b
 		if newSrc, has := a.sources[id]; has {
 			src = newSrc
 		} else {
-			src = source{
-				counter: atomic.Int32{},
-				name:    name,
-			}
+			src = &source{name: name}
 			a.sources[id] = src
 		}
 		a.mu.Unlock()
@@ -117,46 +157,64 @@ func (a *Antispammer) IsSpam(id uint64, name string, isNewSource bool, event []b
 	}
 
 	x := src.counter.Inc()
-	if x == int32(a.threshold) {
-		src.counter.Swap(int32(a.unbanIterations * a.threshold))
-		a.activeMetric.WithLabelValues().Set(1)
-		a.banMetric.WithLabelValues().Inc()
-		a.logger.Warn("source has been banned",
-			zap.Uint64("id", id), zap.String("name", name))
+	if x < threshold {
+		return false
+	}
+	if x == threshold {
+		src.counter.Swap(int32(a.unbanIterations) * threshold)
 	}
 
-	return x >= int32(a.threshold)
+	// the counter may be greater than the threshold without the ban if the threshold is lowered at runtime
+	counter := src.suppressed.Load()
+	if counter == nil {
+		c := a.suppressedMetric.WithLabelValues(src.name)
+		if src.suppressed.CompareAndSwap(nil, &c) {
+			a.activeMetric.WithLabelValues().Set(1)
+			a.banMetric.WithLabelValues().Inc()
+			a.logger.Warn("source has been banned",
+				zap.Uint64("id", id), zap.String("name", name))
+		}
+		counter = &c
+	}
+	(*counter).Inc()
+
+	return true
 }
 
 func (a *Antispammer) Maintenance() {
 	a.mu.Lock()
 
+	if !a.thresholdExpiresAt.IsZero() && time.Now().After(a.thresholdExpiresAt) {
+		a.logger.Info("antispam threshold override has expired", zap.Int("threshold", a.configuredThreshold))
+		a.setThreshold(a.configuredThreshold, 0)
+	}
+	threshold := int(a.threshold.Load())
+
 	allUnbanned := true
 	for sourceID, source := range a.sources {
 		x := int(source.counter.Load())
 
 		if x == 0 {
-			delete(a.sources, sourceID)
+			a.removeSource(sourceID, source)
 			continue
 		}
 
-		isMore := x >= a.threshold
-		x -= a.threshold
+		isMore := x >= threshold
+		x -= threshold
 		if x < 0 {
 			x = 0
 		}
 
-		if isMore && x < a.threshold {
-			a.banMetric.WithLabelValues().Dec()
+		if isMore && x < threshold && a.unban(source) {
 			a.logger.Info("source has been unbanned", zap.Uint64("id", sourceID))
 		}
 
-		if x >= a.threshold {
+		if x >= threshold {
 			allUnbanned = false
 		}
 
-		if x > a.unbanIterations*a.threshold {
-			x = a.unbanIterations * a.threshold
+		if x > a.unbanIterations*threshold {
+			x = a.unbanIterations * threshold
 		}
 
 		source.counter.Swap(int32(x))
@@ -171,17 +229,137 @@ func (a *Antispammer) Maintenance() {
 	a.mu.Unlock()
 }
 
+// ScaleThreshold converts the threshold of events per second to the threshold of the maintenance interval.
+// The interval may be less than a second, so the positive threshold is rounded up to keep the antispam working.
+func ScaleThreshold(threshold int, maintenanceInterval time.Duration) int {
+	if threshold <= 0 {
+		return threshold
+	}
+	return int(math.Ceil(float64(threshold) * maintenanceInterval.Seconds()))
+}
+
+// SetThreshold overrides the threshold, the configured threshold is restored after the ttl if it isn't zero.
+// Zero threshold disables the antispam.
+func (a *Antispammer) SetThreshold(threshold int, ttl time.Duration) error {
+	if threshold < 0 {
+		return fmt.Errorf("threshold can't be negative")
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.setThreshold(threshold, ttl)
+	a.logger.Warn("antispam threshold has been changed", zap.Int("threshold", threshold), zap.Duration("ttl", ttl))
+	return nil
+}
+
+// ResetThreshold restores the configured threshold.
+func (a *Antispammer) ResetThreshold() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.setThreshold(a.configuredThreshold, 0)
+	a.logger.Warn("antispam threshold has been reset", zap.Int("threshold", a.configuredThreshold))
+}
+
+// setThreshold mu should be locked
+func (a *Antispammer) setThreshold(threshold int, ttl time.Duration) {
+	a.threshold.Store(int32(threshold))
+	a.thresholdMetric.Set(float64(threshold))
+
+	a.thresholdExpiresAt = time.Time{}
+	if ttl > 0 {
+		a.thresholdExpiresAt = time.Now().Add(ttl)
+	}
+}
+
+// Unban unbans the sources with the id or with the name if the id is zero, it returns the number of the unbanned sources.
+func (a *Antispammer) Unban(id uint64, name string) int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	unbanned := 0
+	for sourceID, source := range a.sources {
+		if id != 0 && sourceID != id || id == 0 && source.name != name {
+			continue
+		}
+
+		source.counter.Swap(0)
+		if a.unban(source) {
+			a.manualUnbannedMetric.Inc()
+			a.logger.Warn("source has been unbanned manually", zap.Uint64("id", sourceID), zap.String("name", source.name))
+			unbanned++
+		}
+	}
+	return unbanned
+}
+
+// unban returns false if the source isn't banned
+func (a *Antispammer) unban(source *source) bool {
+	if source.suppressed.Swap(nil) == nil {
+		return false
+	}
+	a.banMetric.WithLabelValues().Dec()
+	a.suppressedMetric.DeleteLabelValues(source.name)
+	return true
+}
+
+// removeSource mu should be locked
+func (a *Antispammer) removeSource(sourceID uint64, source *source) {
+	a.unban(source)
+	delete(a.sources, sourceID)
+}
+
+// State returns the threshold and the banned sources, the unban time is estimated
+// by the maintenance iterations the source needs if it doesn't write anymore.
+func (a *Antispammer) State() State {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	threshold := int(a.threshold.Load())
+	state := State{
+		Threshold:           threshold,
+		ConfiguredThreshold: a.configuredThreshold,
+		Banned:              make([]BannedSource, 0),
+	}
+	if !a.thresholdExpiresAt.IsZero() {
+		expiresAt := a.thresholdExpiresAt
+		state.ThresholdExpiresAt = &expiresAt
+	}
+	if threshold <= 0 {
+		return state
+	}
+
+	now := time.Now()
+	for id, source := range a.sources {
+		if source.suppressed.Load() == nil {
+			continue
+		}
+
+		x := int(source.counter.Load())
+		iterations := (x-threshold)/threshold + 1
+		state.Banned = append(state.Banned, BannedSource{
+			ID:            id,
+			Name:          source.name,
+			EventsCounter: x,
+			UnbanAt:       now.Add(time.Duration(iterations) * a.maintenanceInterval),
+		})
+	}
+	sort.Slice(state.Banned, func(i, j int) bool {
+		return state.Banned[i].ID < state.Banned[j].ID
+	})
+
+	return state
+}
+
 func (a *Antispammer) Dump() string {
-	out := logger.Cond(len(a.sources) == 0, logger.Header("no banned"), func() string {
+	state := a.State()
+	out := logger.Cond(len(state.Banned) == 0, logger.Header("no banned"), func() string {
 		o := logger.Header("banned sources")
-		a.mu.RLock()
-		for s, source := range a.sources {
-			value := source.counter.Load()
-			if int(value) >= a.threshold {
-				o += fmt.Sprintf("source_id: %d, source_name: %s, events_counter: %d\n", s, source.name, value)
-			}
+		for _, s := range state.Banned {
+			o += fmt.Sprintf("source_id: %d, source_name: %s, events_counter: %d, unban_at: %s\n",
+				s.ID, s.Name, s.EventsCounter, s.UnbanAt.Format(time.RFC3339))
 		}
-		a.mu.RUnlock()
 		return o
 	})
 
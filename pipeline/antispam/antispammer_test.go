@@ -0,0 +1,107 @@
+package antispam
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ozontech/file.d/logger"
+	"github.com/ozontech/file.d/metric"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestAntispammer(threshold int) *Antispammer {
+	return NewAntispammer(Options{
+		MaintenanceInterval: time.Second,
+		Threshold:           threshold,
+		UnbanIterations:     4,
+		Logger:              logger.Instance.Desugar(),
+		MetricsController:   metric.New("test", prometheus.NewRegistry()),
+	})
+}
+
+func TestAntispammerBan(t *testing.T) {
+	a := newTestAntispammer(3)
+
+	spam := 0
+	for i := 0; i < 10; i++ {
+		if a.IsSpam(1, "pod", false, []byte("event")) {
+			spam++
+		}
+	}
+	assert.Equal(t, 8, spam)
+	assert.False(t, a.IsSpam(2, "other", false, []byte("event")), "other source is banned")
+	assert.Equal(t, 8.0, testutil.ToFloat64(a.suppressedMetric.WithLabelValues("pod")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(a.banMetric))
+
+	state := a.State()
+	require.Len(t, state.Banned, 1)
+	assert.Equal(t, uint64(1), state.Banned[0].ID)
+	assert.Equal(t, "pod", state.Banned[0].Name)
+	assert.True(t, state.Banned[0].UnbanAt.After(time.Now()))
+
+	for i := 0; i < 5; i++ {
+		a.Maintenance()
+	}
+	assert.False(t, a.IsSpam(1, "pod", false, []byte("event")), "source isn't unbanned")
+	assert.Empty(t, a.State().Banned)
+	assert.Equal(t, 0.0, testutil.ToFloat64(a.banMetric))
+	assert.Equal(t, 0, testutil.CollectAndCount(a.suppressedMetric), "metric of the unbanned source isn't deleted")
+}
+
+func TestAntispammerSetThreshold(t *testing.T) {
+	a := newTestAntispammer(100)
+
+	require.Error(t, a.SetThreshold(-1, 0))
+	require.NoError(t, a.SetThreshold(2, 50*time.Millisecond))
+
+	state := a.State()
+	assert.Equal(t, 2, state.Threshold)
+	assert.Equal(t, 100, state.ConfiguredThreshold)
+	assert.NotNil(t, state.ThresholdExpiresAt)
+	assert.Equal(t, 2.0, testutil.ToFloat64(a.thresholdMetric))
+
+	a.IsSpam(1, "pod", false, nil)
+	assert.True(t, a.IsSpam(1, "pod", false, nil), "source isn't banned with the lowered threshold")
+
+	a.Maintenance()
+	assert.Equal(t, 2, a.State().Threshold, "threshold is restored before the ttl")
+
+	time.Sleep(60 * time.Millisecond)
+	a.Maintenance()
+	state = a.State()
+	assert.Equal(t, 100, state.Threshold)
+	assert.Nil(t, state.ThresholdExpiresAt)
+
+	require.NoError(t, a.SetThreshold(0, 0))
+	assert.False(t, a.IsSpam(1, "pod", false, nil), "antispam isn't disabled by the zero threshold")
+	a.ResetThreshold()
+	assert.Equal(t, 100, a.State().Threshold)
+}
+
+func TestAntispammerUnban(t *testing.T) {
+	a := newTestAntispammer(1)
+
+	a.IsSpam(1, "pod", false, nil)
+	a.IsSpam(2, "pod", false, nil)
+	a.IsSpam(3, "other", false, nil)
+	require.Len(t, a.State().Banned, 3)
+
+	assert.Equal(t, 0, a.Unban(4, ""))
+	assert.Equal(t, 2, a.Unban(0, "pod"))
+	assert.Equal(t, 1, a.Unban(3, ""))
+	assert.Equal(t, 0, a.Unban(3, ""), "source is unbanned twice")
+	assert.Empty(t, a.State().Banned)
+	assert.Equal(t, 3.0, testutil.ToFloat64(a.manualUnbannedMetric))
+	assert.Equal(t, 0.0, testutil.ToFloat64(a.banMetric))
+}
+
+func TestScaleThreshold(t *testing.T) {
+	assert.Equal(t, 500, ScaleThreshold(100, 5*time.Second))
+	assert.Equal(t, 50, ScaleThreshold(100, 500*time.Millisecond))
+	assert.Equal(t, 1, ScaleThreshold(1, 100*time.Millisecond), "positive threshold is scaled to zero")
+	assert.Equal(t, 0, ScaleThreshold(0, time.Second))
+	assert.Equal(t, -1, ScaleThreshold(-1, time.Second))
+}
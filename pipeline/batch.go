@@ -2,6 +2,7 @@ package pipeline
 
 import (
 	"context"
+	"math/rand"
 	"sync"
 	"time"
 
@@ -16,6 +17,8 @@ const (
 	BatchStatusNotReady BatchStatus = iota
 	BatchStatusMaxSizeExceeded
 	BatchStatusTimeoutExceeded
+	BatchStatusForceFlushed
+	BatchStatusFlushMarker
 )
 
 type Batch struct {
@@ -27,11 +30,57 @@ type Batch struct {
 	timeout    time.Duration
 	startTime  time.Time
 
+	// baseTimeout is the timeout without the jitter
+	baseTimeout time.Duration
+	// timeoutJitter is a fraction of the baseTimeout the timeout is randomized by on reset
+	timeoutJitter float64
+
 	// maxSizeCount max events per batch
 	maxSizeCount int
 	// maxSizeBytes max size of events per batch in bytes
 	maxSizeBytes int
-	status       BatchStatus
+	// minSizeCount min events per batch to be sent by the timeout
+	minSizeCount int
+	// maxHoldTimeout after this timeout batch is sent regardless of the minSizeCount
+	maxHoldTimeout time.Duration
+	status         BatchStatus
+
+	// flushMarkers contains flush marker events which aren't passed to the output but must be committed
+	flushMarkers []*Event
+	// childParents contains parents of spawned events, they aren't passed to the output,
+	// but they're committed in order with Events, so Events, Next() and ForEach() contain only children
+	childParents []childParent
+	// forwardFlushMarkers puts the flush marker events into Events
+	forwardFlushMarkers bool
+	flushRequested      bool
+
+	partitionKey string
+
+	// iteratorIndex is the index of the event returned by the Value
+	iteratorIndex int
+
+	// failed contains events marked by the output as failed, they are passed to the OutFn again
+	failed []*Event
+	// rejected contains events which the output can't send regardless of retries
+	rejected []rejectedEvent
+	// retryDelay is the min delay before the next retry set by the output
+	retryDelay time.Duration
+
+	compression      BatchCompression
+	compressor       batchCompressor
+	compressBuf      []byte
+	compressionRatio prometheus.Observer
+}
+
+type rejectedEvent struct {
+	event  *Event
+	reason string
+}
+
+type childParent struct {
+	event *Event
+	// index is the number of Events appended before the parent
+	index int
 }
 
 func newBatch(maxSizeCount, maxSizeBytes int, timeout time.Duration) *Batch {
@@ -49,28 +98,173 @@ func newBatch(maxSizeCount, maxSizeBytes int, timeout time.Duration) *Batch {
 		maxSizeCount: maxSizeCount,
 		maxSizeBytes: maxSizeBytes,
 		timeout:      timeout,
+		baseTimeout:  timeout,
 		Events:       make([]*Event, 0, maxSizeCount),
 	}
 }
 
+// jitteredTimeout returns the random timeout within baseTimeout ± baseTimeout*timeoutJitter
+func (b *Batch) jitteredTimeout() time.Duration {
+	if b.timeoutJitter <= 0 || b.baseTimeout <= 0 {
+		return b.baseTimeout
+	}
+
+	delta := (rand.Float64()*2 - 1) * b.timeoutJitter
+	timeout := time.Duration(float64(b.baseTimeout) * (1 + delta))
+	if timeout <= 0 {
+		timeout = time.Millisecond
+	}
+	return timeout
+}
+
 func (b *Batch) reset() {
 	b.Events = b.Events[:0]
+	b.iteratorIndex = -1
+	b.failed = b.failed[:0]
+	clear(b.rejected)
+	b.rejected = b.rejected[:0]
+	b.retryDelay = 0
+	b.flushMarkers = b.flushMarkers[:0]
+	clear(b.childParents)
+	b.childParents = b.childParents[:0]
+	b.flushRequested = false
 	b.eventsSize = 0
 	b.status = BatchStatusNotReady
 	b.startTime = time.Now()
+	b.timeout = b.jitteredTimeout()
 }
 
 func (b *Batch) append(e *Event) {
+	if e.IsChildParent() {
+		b.childParents = append(b.childParents, childParent{event: e, index: len(b.Events)})
+		b.flushRequested = b.flushRequested || e.IsFlushMarker()
+		return
+	}
+
+	if e.IsFlushMarker() {
+		b.flushRequested = true
+		if !b.forwardFlushMarkers {
+			b.flushMarkers = append(b.flushMarkers, e)
+			return
+		}
+	}
+
 	b.Events = append(b.Events, e)
 	b.eventsSize += e.Size
 }
 
+// Len returns the number of events passed to the output.
+func (b *Batch) Len() int {
+	return len(b.Events)
+}
+
+// ForEach calls fn for every event of the batch in order until fn returns false.
+func (b *Batch) ForEach(fn func(event *Event) bool) {
+	for _, e := range b.Events {
+		if !fn(e) {
+			return
+		}
+	}
+}
+
+// Next moves the iterator to the next event and returns false if there are no events left.
+//
+//	for batch.Next() {
+//		event := batch.Value()
+//	}
+func (b *Batch) Next() bool {
+	if b.iteratorIndex+1 >= len(b.Events) {
+		return false
+	}
+	b.iteratorIndex++
+	return true
+}
+
+// Value returns the current event of the iterator.
+func (b *Batch) Value() *Event {
+	return b.Events[b.iteratorIndex]
+}
+
+// RewindIterator moves the iterator before the first event,
+// so the output can iterate over the batch again within the OutFn, e.g. to retry failed events.
+func (b *Batch) RewindIterator() {
+	b.iteratorIndex = -1
+}
+
+// MarkFailed is called by the OutFn for the event that isn't sent.
+// After the OutFn returns, the batcher passes only failed events to it again
+// according to the BatcherOptions.Retry and commits the whole batch after that.
+func (b *Batch) MarkFailed(e *Event) {
+	b.failed = append(b.failed, e)
+}
+
+// MarkRejected is called by the OutFn for the event that can't be sent regardless of retries,
+// e.g. the event is refused by the receiver as invalid. The event isn't passed to the OutFn again,
+// it's passed to the dead letter output or dropped after the OutFn returns.
+func (b *Batch) MarkRejected(e *Event, reason string) {
+	b.rejected = append(b.rejected, rejectedEvent{event: e, reason: reason})
+}
+
+// unmark drops the marks of the failed and rejected events added after the first ones
+func (b *Batch) unmark(failed, rejected int) {
+	b.failed = b.failed[:failed]
+	clear(b.rejected[rejected:])
+	b.rejected = b.rejected[:rejected]
+}
+
+// DelayRetry is called by the OutFn to make the batcher wait at least for d before the next retry
+// of the batch or its failed events, e.g. according to the Retry-After header of the response.
+// The delay is applied once instead of the backoff if it's longer.
+func (b *Batch) DelayRetry(d time.Duration) {
+	b.retryDelay = d
+}
+
+// retryBackoff returns the delay before the next retry and resets the one set by the DelayRetry
+func (b *Batch) retryBackoff(backoff time.Duration) time.Duration {
+	delay := max(backoff, b.retryDelay)
+	b.retryDelay = 0
+	return delay
+}
+
+// PartitionKey returns the value of the BatcherOptions.PartitionKey field which is the same for all events of the batch.
+// It's empty if partitioning is disabled.
+func (b *Batch) PartitionKey() string {
+	return b.partitionKey
+}
+
+func (b *Batch) isEmpty() bool {
+	return len(b.Events) == 0 && len(b.flushMarkers) == 0 && len(b.childParents) == 0
+}
+
+// readyReason explains why the batch isn't BatchStatusNotReady anymore
+func (b *Batch) readyReason() string {
+	switch b.status {
+	case BatchStatusMaxSizeExceeded:
+		if b.maxSizeCount != 0 && len(b.Events) >= b.maxSizeCount {
+			return "count"
+		}
+		return "bytes"
+	case BatchStatusTimeoutExceeded:
+		return "timeout"
+	case BatchStatusForceFlushed:
+		return "force_flushed"
+	case BatchStatusFlushMarker:
+		return "flush_marker"
+	default:
+		return "not_ready"
+	}
+}
+
 func (b *Batch) updateStatus() BatchStatus {
 	l := len(b.Events)
 	switch {
-	case (b.maxSizeCount != 0 && l == b.maxSizeCount) || (b.maxSizeBytes != 0 && b.maxSizeBytes <= b.eventsSize):
+	case b.flushRequested:
+		b.status = BatchStatusFlushMarker
+	case (b.maxSizeCount != 0 && l >= b.maxSizeCount) || (b.maxSizeBytes != 0 && b.maxSizeBytes <= b.eventsSize):
 		b.status = BatchStatusMaxSizeExceeded
-	case l > 0 && time.Since(b.startTime) > b.timeout:
+	case !b.isEmpty() && l >= b.minSizeCount && time.Since(b.startTime) > b.timeout:
+		b.status = BatchStatusTimeoutExceeded
+	case !b.isEmpty() && b.minSizeCount != 0 && time.Since(b.startTime) > b.maxHoldTimeout:
 		b.status = BatchStatusTimeoutExceeded
 	default:
 		b.status = BatchStatusNotReady
@@ -81,32 +275,119 @@ func (b *Batch) updateStatus() BatchStatus {
 type Batcher struct {
 	opts BatcherOptions
 
-	batch *Batch
+	// batches are active batches by the partition key, if partitioning is disabled there is the only key
+	batches       map[string]*Batch
+	maxPartitions int
 
-	// cycle of batches: freeBatches => fullBatches, fullBatches => freeBatches
-	freeBatches chan *Batch
-	fullBatches chan *Batch
-	workersWg   sync.WaitGroup
+	// batches are taken from the batchPool, sent to the fullBatches and put back to the pool after commit,
+	// inFlight limits the number of batches taken from the pool at the same time
+	batchPool    sync.Pool
+	inFlightMu   *sync.Mutex
+	inFlightCond *sync.Cond
+	inFlight     int
+	maxInFlight  int
+	// exhaustedSince is the time all the in-flight batches are taken, it's zero if some batches are free
+	exhaustedSince time.Time
+	fullBatches    chan *Batch
+	workersWg      sync.WaitGroup
+	// sendersWg tracks batches that are ready but not yet put into fullBatches,
+	// so fullBatches is closed only after the last of them is sent
+	sendersWg sync.WaitGroup
+	stopOnce  sync.Once
 
 	mu         sync.Mutex
 	shouldStop bool
 
+	heartbeatInterval time.Duration
+
 	seqMu     *sync.Mutex
 	cond      *sync.Cond
 	outSeq    int64
 	commitSeq int64
 
+	// adaptive is nil if adaptive batch sizing is disabled
+	adaptive *adaptiveSize
+	// breaker is nil if the circuit breaker is disabled
+	breaker *circuitBreaker
+
+	// healthMu protects the state used by the pipeline health checks
+	healthMu sync.Mutex
+	// pending is the number of the batches sent to the workers and not committed yet
+	pending        int
+	pendingSince   time.Time
+	lastSuccessAt  time.Time
+	lastActivityAt time.Time
+
 	batchOutFnSeconds    prometheus.Observer
 	commitWaitingSeconds prometheus.Observer
+	eventLatencySeconds  prometheus.Observer
 	workersInProgress    prometheus.Gauge
+	batchesInFlight      prometheus.Gauge
+	outFnRetries         prometheus.Counter
+	failedEventsRetries  prometheus.Counter
+	expiredEvents        prometheus.Counter
+	rejectedEvents       prometheus.Counter
+	adaptiveBatchSize    prometheus.Gauge
+	batchEventsCount     prometheus.Observer
+	batchSizeBytes       prometheus.Observer
+	batchesReady         *prometheus.CounterVec
 	batchesDoneByMaxSize prometheus.Counter
 	batchesDoneByTimeout prometheus.Counter
+	batchesDoneByStop    prometheus.Counter
+	batchesDoneByMarker  prometheus.Counter
+	oversizedEvents      prometheus.Counter
 }
 
+type PartitionOverflowPolicy byte
+
+const (
+	// PartitionOverflowShared puts events of new keys into the one shared batch
+	PartitionOverflowShared PartitionOverflowPolicy = iota
+	// PartitionOverflowFlushOldest flushes the oldest batch to make room for the new key
+	PartitionOverflowFlushOldest
+)
+
+const (
+	defaultMaxPartitions = 128
+	// partitionKeyOverflow can't be a value of the event field since it isn't valid UTF-8
+	partitionKeyOverflow = "\xff"
+
+	defaultHeartbeatInterval = time.Millisecond * 100
+	minHeartbeatInterval     = time.Millisecond
+
+	// defaultFailedRetryTimeout limits the retries of the events marked as failed if BatcherRetry.FailedTimeout isn't set
+	defaultFailedRetryTimeout = time.Minute
+)
+
 type (
-	BatcherOutFn         func(*WorkerData, *Batch)
+	// BatcherOutFn sends the batch. If it returns an error, the batch is retried according to BatcherOptions.Retry.
+	BatcherOutFn         func(*WorkerData, *Batch) error
 	BatcherMaintenanceFn func(*WorkerData)
 
+	// BatcherAdaptive is disabled if MaxSizeCount is zero.
+	BatcherAdaptive struct {
+		// MinSizeCount is the lower limit of max events per batch
+		MinSizeCount int
+		// MaxSizeCount is the upper limit of max events per batch
+		MaxSizeCount int
+		// TargetLatency is the OutFn duration which the batch size is adjusted to
+		TargetLatency time.Duration
+	}
+
+	BatcherRetry struct {
+		// MaxRetries is the number of OutFn retries, zero means the batch isn't retried
+		MaxRetries int
+		// InitialBackoff is the delay before the first retry
+		InitialBackoff time.Duration
+		// BackoffMultiplier is the factor the delay grows by after each retry, values less than 1 are treated as 1
+		BackoffMultiplier float64
+		// MaxBackoff limits the delay, zero means no limit
+		MaxBackoff time.Duration
+		// FailedTimeout limits the total time of the retries of the events marked as failed by the OutFn,
+		// so a few failing events don't hold the worker for all the retries, zero means one minute
+		FailedTimeout time.Duration
+	}
+
 	BatcherOptions struct {
 		PipelineName        string
 		OutputType          string
@@ -119,46 +400,273 @@ type (
 		FlushTimeout        time.Duration
 		MaintenanceInterval time.Duration
 		MetricCtl           *metric.Ctl
+
+		// MaxInFlightBatches is the max number of batches that are filled, queued or processed at the same time.
+		// If it's greater than Workers, the batcher keeps accepting events while all workers are busy.
+		// Default is Workers.
+		MaxInFlightBatches int
+
+		// FlushTimeoutJitter is a fraction of the FlushTimeout, e.g. 0.1,
+		// the timeout of each batch is randomized within FlushTimeout ± FlushTimeout*FlushTimeoutJitter,
+		// so the batchers started at the same time don't flush simultaneously.
+		FlushTimeoutJitter float64
+
+		// HeartbeatInterval is how often batches are checked for the flush timeout. Default is 100ms,
+		// it's clamped to FlushTimeout/2, so the flush by timeout isn't delayed much.
+		HeartbeatInterval time.Duration
+
+		// MinBatchSizeCount suppresses flushes by the FlushTimeout until the batch has at least this number of events.
+		MinBatchSizeCount int
+		// MaxHoldTimeout is used with the MinBatchSizeCount, after this timeout the batch is flushed anyway,
+		// so events of the idle stream aren't held forever. Default is 10*FlushTimeout.
+		MaxHoldTimeout time.Duration
+
+		// Adaptive changes max events per batch depending on the OutFn latency.
+		Adaptive BatcherAdaptive
+
+		// PartitionKey is the event field path, each batch contains events with the same value of this field.
+		PartitionKey []string
+		// MaxPartitions is the max number of batches filled at the same time. Default is 128.
+		MaxPartitions int
+		// PartitionOverflow defines what to do with the event of the new key if there are MaxPartitions batches.
+		PartitionOverflow PartitionOverflowPolicy
+
+		// Compression is the codec used by the Batch.Compress. Default is none.
+		Compression BatchCompression
+
+		// ForwardFlushMarkers passes flush marker events to the OutFn,
+		// otherwise they only force the flush of the batch and are committed without sending.
+		ForwardFlushMarkers bool
+
+		// MaxEventAge makes the batcher commit events received by the pipeline earlier than this duration ago
+		// without passing them to the OutFn, so stale events aren't delivered. Zero means no limit.
+		MaxEventAge time.Duration
+
+		// Retry is applied when OutFn returns an error.
+		Retry BatcherRetry
+		// FatalOnFailedInsert makes the batcher exit after retries are exhausted,
+		// otherwise the batch is dropped and its events are committed.
+		FatalOnFailedInsert bool
+
+		// CircuitBreaker stops calling the OutFn for the cooldown after consecutive failures.
+		CircuitBreaker BatcherCircuitBreaker
+
+		// DeadLetter gets the events which aren't sent after retries are exhausted,
+		// it takes precedence over the FatalOnFailedInsert.
+		DeadLetter *DeadLetter
+
+		// Health checks the state of the batcher for the readiness and the liveness of the pipeline.
+		Health *Health
+
+		// ErrorReporter gets the errors of the batches and the events which aren't sent after retries are exhausted.
+		ErrorReporter *ErrorReporter
 	}
 )
 
+func (r BatcherRetry) nextBackoff(cur time.Duration) time.Duration {
+	next := cur
+	if r.BackoffMultiplier > 1 {
+		next = time.Duration(float64(cur) * r.BackoffMultiplier)
+	}
+	if r.MaxBackoff != 0 && next > r.MaxBackoff {
+		next = r.MaxBackoff
+	}
+	return next
+}
+
 func NewBatcher(opts BatcherOptions) *Batcher { // nolint: gocritic // hugeParam is ok here
 	ctl := opts.MetricCtl
 	jobsDone := ctl.RegisterCounter("batcher_jobs_done_total", "", "status")
 
-	freeBatches := make(chan *Batch, opts.Workers)
-	fullBatches := make(chan *Batch, opts.Workers)
-	for i := 0; i < opts.Workers; i++ {
-		freeBatches <- newBatch(opts.BatchSizeCount, opts.BatchSizeBytes, opts.FlushTimeout)
+	maxInFlight := opts.MaxInFlightBatches
+	if maxInFlight < opts.Workers {
+		maxInFlight = opts.Workers
+	}
+
+	maxPartitions := 1
+	if len(opts.PartitionKey) != 0 {
+		maxPartitions = opts.MaxPartitions
+		if maxPartitions <= 0 {
+			maxPartitions = defaultMaxPartitions
+		}
+		// each active partition holds the batch, so workers must have batches to process too,
+		// +1 is for the shared overflow batch
+		if maxInFlight < maxPartitions+1+opts.Workers {
+			maxInFlight = maxPartitions + 1 + opts.Workers
+		}
+	}
+
+	if opts.FlushTimeoutJitter < 0 || opts.FlushTimeoutJitter >= 1 {
+		logger.Fatalf("batch flush timeout jitter should be in [0, 1)")
+	}
+	heartbeatInterval := opts.HeartbeatInterval
+	if heartbeatInterval <= 0 {
+		heartbeatInterval = defaultHeartbeatInterval
+	}
+	if half := opts.FlushTimeout / 2; half > 0 && heartbeatInterval > half {
+		heartbeatInterval = half
+	}
+	if heartbeatInterval < minHeartbeatInterval {
+		heartbeatInterval = minHeartbeatInterval
 	}
 
+	if opts.MinBatchSizeCount < 0 {
+		logger.Fatalf("why batch min count less than 0?")
+	}
+	maxHoldTimeout := opts.MaxHoldTimeout
+	if maxHoldTimeout == 0 {
+		maxHoldTimeout = opts.FlushTimeout * 10
+	}
+	if maxHoldTimeout < opts.FlushTimeout {
+		maxHoldTimeout = opts.FlushTimeout
+	}
+
+	var adaptive *adaptiveSize
+	if opts.Adaptive.MaxSizeCount != 0 {
+		adaptive = newAdaptiveSize(opts.Adaptive, opts.BatchSizeCount)
+	}
+
+	var breaker *circuitBreaker
+	if opts.CircuitBreaker.FailureThreshold > 0 {
+		breaker = newCircuitBreaker(opts.CircuitBreaker,
+			ctl.RegisterGauge("batcher_circuit_breaker_state", "State of the circuit breaker: 0 is closed, 1 is open, 2 is half-open").WithLabelValues(),
+			ctl.RegisterCounter("batcher_circuit_breaker_opened_total", "Count of the circuit breaker openings").WithLabelValues(),
+		)
+	}
+
+	compressionRatio := ctl.RegisterHistogram("batcher_compression_ratio", "Ratio of compressed to original batch size",
+		prometheus.LinearBuckets(0.05, 0.05, 20)).WithLabelValues()
+
 	seqMu := &sync.Mutex{}
-	return &Batcher{
-		seqMu:                seqMu,
-		cond:                 sync.NewCond(seqMu),
-		freeBatches:          freeBatches,
-		fullBatches:          fullBatches,
+	inFlightMu := &sync.Mutex{}
+	b := &Batcher{
+		batches:       make(map[string]*Batch, maxPartitions),
+		maxPartitions: maxPartitions,
+		adaptive:      adaptive,
+		breaker:       breaker,
+		seqMu:         seqMu,
+		cond:          sync.NewCond(seqMu),
+		inFlightMu:    inFlightMu,
+		inFlightCond:  sync.NewCond(inFlightMu),
+		maxInFlight:   maxInFlight,
+		batchPool: sync.Pool{
+			New: func() any {
+				batch := newBatch(opts.BatchSizeCount, opts.BatchSizeBytes, opts.FlushTimeout)
+				batch.minSizeCount = opts.MinBatchSizeCount
+				batch.timeoutJitter = opts.FlushTimeoutJitter
+				batch.maxHoldTimeout = maxHoldTimeout
+				batch.forwardFlushMarkers = opts.ForwardFlushMarkers
+				batch.compression = opts.Compression
+				batch.compressor = newBatchCompressor(opts.Compression)
+				batch.compressionRatio = compressionRatio
+				return batch
+			},
+		},
+		fullBatches:          make(chan *Batch, maxInFlight),
+		heartbeatInterval:    heartbeatInterval,
 		opts:                 opts,
 		batchOutFnSeconds:    ctl.RegisterHistogram("batcher_out_fn_seconds", "", metric.SecondsBucketsLong).WithLabelValues(),
 		commitWaitingSeconds: ctl.RegisterHistogram("batcher_commit_waiting_seconds", "", metric.SecondsBucketsDetailed).WithLabelValues(),
+		eventLatencySeconds:  ctl.RegisterHistogram("batcher_event_latency_seconds", "Time from receiving the event by the pipeline to committing it by the output", metric.SecondsBucketsLong, "output").WithLabelValues(opts.OutputType),
 		workersInProgress:    ctl.RegisterGauge("batcher_workers_in_progress", "").WithLabelValues(),
+		batchesInFlight:      ctl.RegisterGauge("batcher_batches_in_flight", "").WithLabelValues(),
+		outFnRetries:         ctl.RegisterCounter("batcher_out_fn_retries_total", "").WithLabelValues(),
+		failedEventsRetries:  ctl.RegisterCounter("batcher_failed_events_retries_total", "Count of events marked as failed and sent again").WithLabelValues(),
+		expiredEvents:        ctl.RegisterCounter("batcher_expired_events_total", "Count of events older than the max event age which are committed without sending").WithLabelValues(),
+		rejectedEvents:       ctl.RegisterCounter("batcher_rejected_events_total", "Count of events rejected by the output which aren't sent again").WithLabelValues(),
+		adaptiveBatchSize:    ctl.RegisterGauge("batcher_adaptive_batch_size", "Current max events per batch chosen by the adaptive sizing").WithLabelValues(),
+		batchEventsCount:     ctl.RegisterHistogram("batcher_batch_events_count", "Count of events in the batch at flush", metric.CountBuckets).WithLabelValues(),
+		batchSizeBytes:       ctl.RegisterHistogram("batcher_batch_size_bytes", "Size of events in the batch at flush", metric.SizeBucketsBytes).WithLabelValues(),
+		batchesReady:         ctl.RegisterCounter("batcher_batches_ready_total", "Count of flushed batches by the reason the batch became ready", "reason"),
 		batchesDoneByMaxSize: jobsDone.WithLabelValues("max_size_exceeded"),
 		batchesDoneByTimeout: jobsDone.WithLabelValues("timeout_exceeded"),
+		batchesDoneByStop:    jobsDone.WithLabelValues("force_flushed"),
+		batchesDoneByMarker:  jobsDone.WithLabelValues("flush_marker"),
+		oversizedEvents:      ctl.RegisterCounter("batcher_oversized_events_total", "Count of events exceeding the max batch size in bytes which are sent in their own batches").WithLabelValues(),
+	}
+	if opts.Health != nil {
+		opts.Health.add(b)
 	}
+	return b
 }
 
-func (b *Batcher) Start(ctx context.Context) {
-	go func() {
-		<-ctx.Done()
-		b.Stop()
-	}()
+// batcherHealth contains the durations of the problems of the batcher, they're zero if there are no problems
+type batcherHealth struct {
+	// exhausted is how long all the in-flight batches are taken
+	exhausted time.Duration
+	// noSuccess is how long there are pending batches and OutFn doesn't succeed
+	noSuccess time.Duration
+	// noActivity is how long there are pending batches and OutFn isn't called and batches aren't committed
+	noActivity time.Duration
+
+	circuitOpen bool
+}
+
+func (b *Batcher) health(now time.Time) batcherHealth {
+	h := batcherHealth{}
+
+	b.inFlightMu.Lock()
+	if !b.exhaustedSince.IsZero() {
+		h.exhausted = now.Sub(b.exhaustedSince)
+	}
+	b.inFlightMu.Unlock()
+
+	b.healthMu.Lock()
+	if b.pending > 0 {
+		h.noSuccess = now.Sub(latest(b.pendingSince, b.lastSuccessAt))
+		h.noActivity = now.Sub(latest(b.pendingSince, b.lastActivityAt))
+	}
+	b.healthMu.Unlock()
+
+	if b.breaker != nil {
+		h.circuitOpen = b.breaker.isOpen()
+	}
+
+	return h
+}
+
+func latest(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+func (b *Batcher) addPending(n int) {
+	b.healthMu.Lock()
+	defer b.healthMu.Unlock()
+
+	if b.pending == 0 {
+		b.pendingSince = time.Now()
+	}
+	b.pending += n
+}
 
+// outDone updates the health state after the OutFn call or after the batch is committed
+func (b *Batcher) outDone(success, committed bool) {
+	now := time.Now()
+
+	b.healthMu.Lock()
+	defer b.healthMu.Unlock()
+
+	b.lastActivityAt = now
+	if success {
+		b.lastSuccessAt = now
+	}
+	if committed {
+		b.pending--
+	}
+}
+
+// Start runs workers and the heartbeat.
+// Cancelling the ctx has the same effect as calling Stop.
+func (b *Batcher) Start(ctx context.Context) {
 	b.workersWg.Add(b.opts.Workers)
 	for i := 0; i < b.opts.Workers; i++ {
 		go b.work()
 	}
 
-	go b.heartbeat()
+	go b.heartbeat(ctx)
 }
 
 type WorkerData any
@@ -168,12 +676,31 @@ func (b *Batcher) work() {
 
 	t := time.Now()
 	data := WorkerData(nil)
+	retry := &failedRetry{}
+	all := make([]*Event, 0)
 	for batch := range b.fullBatches {
 		b.workersInProgress.Inc()
 
-		now := time.Now()
-		b.opts.OutFn(&data, batch)
-		b.batchOutFnSeconds.Observe(time.Since(now).Seconds())
+		if b.opts.MaxEventAge > 0 {
+			all = b.dropExpired(batch, all)
+		}
+
+		// the batch may contain only flush markers or expired events
+		if len(batch.Events) > 0 {
+			b.out(&data, batch)
+		}
+		if len(batch.failed) > 0 {
+			b.retryFailed(&data, batch, retry)
+		}
+		if len(batch.rejected) > 0 {
+			b.dropRejected(batch)
+		}
+
+		// expired events are committed in order with others
+		if b.opts.MaxEventAge > 0 {
+			batch.Events = append(batch.Events[:0], all...)
+			clear(all)
+		}
 
 		status := b.commitBatch(batch)
 
@@ -189,12 +716,178 @@ func (b *Batcher) work() {
 			b.batchesDoneByMaxSize.Inc()
 		case BatchStatusTimeoutExceeded:
 			b.batchesDoneByTimeout.Inc()
+		case BatchStatusForceFlushed:
+			b.batchesDoneByStop.Inc()
+		case BatchStatusFlushMarker:
+			b.batchesDoneByMarker.Inc()
 		default:
 			logger.Panic("unreachable")
 		}
 	}
 }
 
+// out calls OutFn and retries it with backoff until it succeeds or retries are exhausted,
+// the calls wait while the circuit breaker is open.
+// The events marked by the attempt which returns the error are unmarked, since the whole batch is passed again
+// or passed to the dead letter.
+func (b *Batcher) out(data *WorkerData, batch *Batch) {
+	failedFrom, rejectedFrom := len(batch.failed), len(batch.rejected)
+
+	retry := b.opts.Retry
+	backoff := retry.InitialBackoff
+	for attempt := 0; ; attempt++ {
+		batch.unmark(failedFrom, rejectedFrom)
+		if b.breaker != nil {
+			b.breaker.acquire()
+		}
+
+		now := time.Now()
+		err := b.opts.OutFn(data, batch)
+		elapsed := time.Since(now)
+		if b.breaker != nil {
+			b.breaker.done(err == nil)
+		}
+		b.outDone(err == nil, false)
+		b.batchOutFnSeconds.Observe(elapsed.Seconds())
+		if b.adaptive != nil {
+			b.adaptiveBatchSize.Set(float64(b.adaptive.observe(elapsed, err == nil)))
+		}
+		if err == nil {
+			return
+		}
+
+		if attempt >= retry.MaxRetries {
+			batch.unmark(failedFrom, rejectedFrom)
+			if len(batch.Events) != 0 {
+				b.opts.ErrorReporter.Report(err.Error(), batch.Events[0])
+			}
+			if b.opts.DeadLetter != nil {
+				logger.Errorf("can't send batch to the %s output of the %s pipeline after %d attempts, batch is passed to the dead letter output: %s",
+					b.opts.OutputType, b.opts.PipelineName, attempt+1, err.Error())
+				b.opts.DeadLetter.Send(b.opts.OutputType, err.Error(), attempt+1, batch.Events)
+				return
+			}
+			if b.opts.FatalOnFailedInsert {
+				logger.Fatalf("can't send batch to the %s output of the %s pipeline after %d attempts: %s",
+					b.opts.OutputType, b.opts.PipelineName, attempt+1, err.Error())
+			}
+			logger.Errorf("can't send batch to the %s output of the %s pipeline after %d attempts, batch is dropped: %s",
+				b.opts.OutputType, b.opts.PipelineName, attempt+1, err.Error())
+			return
+		}
+
+		b.outFnRetries.Inc()
+		time.Sleep(batch.retryBackoff(backoff))
+		backoff = retry.nextBackoff(backoff)
+	}
+}
+
+// failedRetry contains worker buffers for the retryFailed
+type failedRetry struct {
+	events []*Event
+	failed []*Event
+}
+
+// dropExpired removes events older than the MaxEventAge from the batch, so they aren't passed to the OutFn,
+// and returns all events of the batch in all to commit them after the batch is sent
+func (b *Batcher) dropExpired(batch *Batch, all []*Event) []*Event {
+	all = append(all[:0], batch.Events...)
+
+	now := time.Now()
+	batch.Events = batch.Events[:0]
+	for _, e := range all {
+		if e.isExpired(now, b.opts.MaxEventAge) {
+			b.expiredEvents.Inc()
+			continue
+		}
+		batch.Events = append(batch.Events, e)
+	}
+
+	return all
+}
+
+// retryFailed passes events marked as failed to the OutFn again until all of them are sent,
+// retries are exhausted or the BatcherRetry.FailedTimeout expires.
+// The batch contains only failed events during the retry, so the original events are restored after.
+// The batch is committed only after that to keep the commit order.
+func (b *Batcher) retryFailed(data *WorkerData, batch *Batch, r *failedRetry) {
+	r.events = append(r.events[:0], batch.Events...)
+
+	timeout := b.opts.Retry.FailedTimeout
+	if timeout <= 0 {
+		timeout = defaultFailedRetryTimeout
+	}
+	deadline := time.Now().Add(timeout)
+
+	backoff := b.opts.Retry.InitialBackoff
+	for attempt := 0; len(batch.failed) > 0; attempt++ {
+		delay := batch.retryBackoff(backoff)
+		if attempt >= b.opts.Retry.MaxRetries || time.Now().Add(delay).After(deadline) {
+			b.dropFailed(batch, attempt)
+			break
+		}
+
+		b.failedEventsRetries.Add(float64(len(batch.failed)))
+		time.Sleep(delay)
+		backoff = b.opts.Retry.nextBackoff(backoff)
+
+		r.failed = append(r.failed[:0], batch.failed...)
+		batch.failed = batch.failed[:0]
+		batch.Events = append(batch.Events[:0], r.failed...)
+		batch.RewindIterator()
+		b.out(data, batch)
+	}
+
+	batch.Events = append(batch.Events[:0], r.events...)
+	batch.failed = batch.failed[:0]
+	batch.RewindIterator()
+
+	// don't hold the events after the batch is committed
+	clear(r.events)
+	clear(r.failed)
+}
+
+// dropFailed passes the events which are still marked as failed after the retries to the dead letter or drops them
+func (b *Batcher) dropFailed(batch *Batch, attempt int) {
+	b.opts.ErrorReporter.Report("events are marked as failed by the output", batch.failed[0])
+	if b.opts.DeadLetter != nil {
+		logger.Errorf("can't send %d events to the %s output of the %s pipeline after %d retries, events are passed to the dead letter output",
+			len(batch.failed), b.opts.OutputType, b.opts.PipelineName, attempt)
+		b.opts.DeadLetter.Send(b.opts.OutputType, "events are marked as failed by the output", attempt+1, batch.failed)
+		return
+	}
+	if b.opts.FatalOnFailedInsert {
+		logger.Fatalf("can't send %d events to the %s output of the %s pipeline after %d retries",
+			len(batch.failed), b.opts.OutputType, b.opts.PipelineName, attempt)
+	}
+	logger.Errorf("can't send %d events to the %s output of the %s pipeline after %d retries, events are dropped",
+		len(batch.failed), b.opts.OutputType, b.opts.PipelineName, attempt)
+}
+
+// dropRejected passes the events rejected by the output to the dead letter or drops them
+func (b *Batcher) dropRejected(batch *Batch) {
+	b.rejectedEvents.Add(float64(len(batch.rejected)))
+	for _, r := range batch.rejected {
+		b.opts.ErrorReporter.Report(r.reason, r.event)
+		if b.opts.DeadLetter != nil {
+			b.opts.DeadLetter.Send(b.opts.OutputType, r.reason, 1, []*Event{r.event})
+		}
+	}
+
+	reason := batch.rejected[0].reason
+	if b.opts.DeadLetter != nil {
+		logger.Errorf("%d events are rejected by the %s output of the %s pipeline, events are passed to the dead letter output: %s",
+			len(batch.rejected), b.opts.OutputType, b.opts.PipelineName, reason)
+		return
+	}
+	if b.opts.FatalOnFailedInsert {
+		logger.Fatalf("%d events are rejected by the %s output of the %s pipeline: %s",
+			len(batch.rejected), b.opts.OutputType, b.opts.PipelineName, reason)
+	}
+	logger.Errorf("%d events are rejected by the %s output of the %s pipeline, events are dropped: %s",
+		len(batch.rejected), b.opts.OutputType, b.opts.PipelineName, reason)
+}
+
 func (b *Batcher) commitBatch(batch *Batch) BatchStatus {
 	batchSeq := batch.seq
 
@@ -211,32 +904,72 @@ func (b *Batcher) commitBatch(batch *Batch) BatchStatus {
 		b.cond.Wait()
 	}
 	b.commitSeq++
-	b.commitWaitingSeconds.Observe(time.Since(now).Seconds())
+	committedAt := time.Now()
+	b.commitWaitingSeconds.Observe(committedAt.Sub(now).Seconds())
 
+	parents := batch.childParents
 	for i := range batch.Events {
+		for len(parents) != 0 && parents[0].index == i {
+			b.opts.Controller.Commit(parents[0].event)
+			parents = parents[1:]
+		}
+		// the latency includes the time in the pipeline, in the batch, in the output and waiting for the previous batches
+		if createdAt := batch.Events[i].createdAt; !createdAt.IsZero() {
+			b.eventLatencySeconds.Observe(committedAt.Sub(createdAt).Seconds())
+		}
 		b.opts.Controller.Commit(batch.Events[i])
 	}
+	for i := range parents {
+		b.opts.Controller.Commit(parents[i].event)
+	}
+	// flush marker is the last event of the batch
+	for i := range batch.flushMarkers {
+		b.opts.Controller.Commit(batch.flushMarkers[i])
+	}
 
 	status := batch.status
-	b.freeBatches <- batch
 	b.cond.Broadcast()
 	b.seqMu.Unlock()
+	b.outDone(false, true)
+
+	b.releaseBatch(batch)
 
 	return status
 }
 
-func (b *Batcher) heartbeat() {
+func (b *Batcher) heartbeat(ctx context.Context) {
+	ticker := time.NewTicker(b.heartbeatInterval)
+	defer ticker.Stop()
+
+	ready := make([]*Batch, 0)
 	for {
+		select {
+		case <-ctx.Done():
+			b.Stop()
+			return
+		case <-ticker.C:
+		}
+
 		b.mu.Lock()
 		if b.shouldStop {
 			b.mu.Unlock()
 			return
 		}
 
-		batch := b.getBatch()
-		b.trySendBatchAndUnlock(batch)
+		// without partitioning the batch is allocated in advance,
+		// so the flush timeout is counted from the tick rather than from the first event
+		if len(b.opts.PartitionKey) == 0 {
+			b.getBatch("")
+		}
 
-		time.Sleep(time.Millisecond * 100)
+		ready = ready[:0]
+		for key, batch := range b.batches {
+			if batch.updateStatus() != BatchStatusNotReady {
+				ready = append(ready, batch)
+				delete(b.batches, key)
+			}
+		}
+		b.sendBatchesAndUnlock(ready...)
 	}
 }
 
@@ -248,42 +981,205 @@ func (b *Batcher) Add(event *Event) {
 		return
 	}
 
-	batch := b.getBatch()
+	oversized := b.opts.BatchSizeBytes != 0 && event.Size >= b.opts.BatchSizeBytes
+	if oversized {
+		b.oversizedEvents.Inc()
+	}
+
+	key := b.partitionKey(event)
+	batch, overflowed := b.getBatch(key)
+	for oversized && !batch.isEmpty() {
+		// the event exceeding the max batch size is sent in its own batch,
+		// so the batch doesn't exceed the max size by more than one event,
+		// the current batch is sent first since the new one may not be acquired while it's in flight
+		delete(b.batches, batch.partitionKey)
+		batch.status = BatchStatusForceFlushed
+		b.sendBatchesAndUnlock(batch)
+
+		b.mu.Lock()
+		if b.shouldStop {
+			b.mu.Unlock()
+			return
+		}
+		batch, overflowed = b.getBatch(key)
+	}
 	batch.append(event)
 
-	b.trySendBatchAndUnlock(batch)
+	b.trySendBatchAndUnlock(batch, overflowed)
+}
+
+func (b *Batcher) partitionKey(event *Event) string {
+	if len(b.opts.PartitionKey) == 0 {
+		return ""
+	}
+	return event.Root.Dig(b.opts.PartitionKey...).AsString()
 }
 
 // trySendBatch mu should be locked, and it'll be unlocked after execution of this function
-func (b *Batcher) trySendBatchAndUnlock(batch *Batch) {
-	if batch.updateStatus() == BatchStatusNotReady {
+func (b *Batcher) trySendBatchAndUnlock(batch, overflowed *Batch) {
+	ready := batch.updateStatus() != BatchStatusNotReady
+	if ready {
+		delete(b.batches, batch.partitionKey)
+	}
+
+	switch {
+	case ready && overflowed != nil:
+		b.sendBatchesAndUnlock(overflowed, batch)
+	case ready:
+		b.sendBatchesAndUnlock(batch)
+	case overflowed != nil:
+		b.sendBatchesAndUnlock(overflowed)
+	default:
+		b.mu.Unlock()
+	}
+}
+
+// sendBatchesAndUnlock mu should be locked, and it'll be unlocked after execution of this function
+func (b *Batcher) sendBatchesAndUnlock(batches ...*Batch) {
+	if len(batches) == 0 {
 		b.mu.Unlock()
 		return
 	}
 
-	batch.seq = b.outSeq
-	b.outSeq++
-	b.batch = nil
+	for _, batch := range batches {
+		b.batchEventsCount.Observe(float64(len(batch.Events)))
+		b.batchSizeBytes.Observe(float64(batch.eventsSize))
+		b.batchesReady.WithLabelValues(batch.readyReason()).Inc()
+
+		batch.seq = b.outSeq
+		b.outSeq++
+	}
+	b.sendersWg.Add(len(batches))
+	b.addPending(len(batches))
 	b.mu.Unlock()
 
-	b.fullBatches <- batch
+	for _, batch := range batches {
+		b.fullBatches <- batch
+		b.sendersWg.Done()
+	}
 }
 
-func (b *Batcher) getBatch() *Batch {
-	if b.batch == nil {
-		b.batch = <-b.freeBatches
-		b.batch.reset()
+// getBatch mu should be locked.
+// If the max number of partitions is reached, the returned overflowed batch is
+// detached from the batcher and must be sent by the caller.
+func (b *Batcher) getBatch(key string) (batch, overflowed *Batch) {
+	if batch, has := b.batches[key]; has {
+		return batch, nil
 	}
-	return b.batch
+
+	if len(b.opts.PartitionKey) != 0 && len(b.batches) >= b.maxPartitions {
+		switch b.opts.PartitionOverflow {
+		case PartitionOverflowShared:
+			key = partitionKeyOverflow
+			if batch, has := b.batches[key]; has {
+				return batch, nil
+			}
+		case PartitionOverflowFlushOldest:
+			overflowed = b.detachOldestBatch()
+		}
+	}
+
+	batch = b.acquireBatch()
+	batch.partitionKey = CloneString(key)
+	b.batches[batch.partitionKey] = batch
+	return batch, overflowed
+}
+
+func (b *Batcher) detachOldestBatch() *Batch {
+	var oldest *Batch
+	for _, batch := range b.batches {
+		if oldest == nil || batch.startTime.Before(oldest.startTime) {
+			oldest = batch
+		}
+	}
+	delete(b.batches, oldest.partitionKey)
+	oldest.status = BatchStatusForceFlushed
+	return oldest
+}
+
+// acquireBatch blocks while there are maxInFlight batches in progress
+func (b *Batcher) acquireBatch() *Batch {
+	b.inFlightMu.Lock()
+	for b.inFlight >= b.maxInFlight {
+		b.inFlightCond.Wait()
+	}
+	b.inFlight++
+	if b.inFlight == b.maxInFlight {
+		b.exhaustedSince = time.Now()
+	}
+	b.inFlightMu.Unlock()
+	b.batchesInFlight.Inc()
+
+	batch := b.batchPool.Get().(*Batch)
+	batch.reset()
+	if b.adaptive != nil {
+		batch.maxSizeCount = b.adaptive.sizeCount()
+	}
+	return batch
+}
+
+func (b *Batcher) releaseBatch(batch *Batch) {
+	b.batchPool.Put(batch)
+
+	b.inFlightMu.Lock()
+	b.inFlight--
+	b.exhaustedSince = time.Time{}
+	b.inFlightCond.Signal()
+	b.inFlightMu.Unlock()
+	b.batchesInFlight.Dec()
 }
 
+// Stop stops accepting new events, flushes partial batches
+// and blocks until all batches are passed to the OutFn and committed.
 func (b *Batcher) Stop() {
-	b.mu.Lock()
-	if !b.shouldStop {
+	b.stopOnce.Do(func() {
+		if b.breaker != nil {
+			b.breaker.stop()
+		}
+
+		b.mu.Lock()
 		b.shouldStop = true
+		partial := make([]*Batch, 0, len(b.batches))
+		for key, batch := range b.batches {
+			delete(b.batches, key)
+			if batch.isEmpty() {
+				b.releaseBatch(batch)
+				continue
+			}
+			batch.status = BatchStatusForceFlushed
+			partial = append(partial, batch)
+		}
+		b.sendBatchesAndUnlock(partial...)
+
+		// there are no new senders after shouldStop is set,
+		// so it's safe to close the channel once in-flight sends are done
+		b.sendersWg.Wait()
 		close(b.fullBatches)
-	}
-	b.mu.Unlock()
+	})
 
 	b.workersWg.Wait()
 }
+
+// Shutdown stops accepting new events, flushes partial batches through the OutFn
+// and waits until all batches are committed in sequence order.
+// If the ctx is done earlier, Shutdown returns the ctx error and the flush continues in the background.
+func (b *Batcher) Shutdown(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		b.Stop()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		b.inFlightMu.Lock()
+		inFlight := b.inFlight
+		b.inFlightMu.Unlock()
+
+		logger.Warnf("shutdown of the %s output of the %s pipeline is interrupted, %d batches aren't committed: %s",
+			b.opts.OutputType, b.opts.PipelineName, inFlight, ctx.Err())
+		return ctx.Err()
+	}
+}
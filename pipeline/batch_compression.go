@@ -0,0 +1,126 @@
+package pipeline
+
+import (
+	"bytes"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+	"github.com/ozontech/file.d/logger"
+	"github.com/pierrec/lz4/v4"
+)
+
+type BatchCompression string
+
+const (
+	BatchCompressionNone BatchCompression = "none"
+	BatchCompressionGzip BatchCompression = "gzip"
+	BatchCompressionZstd BatchCompression = "zstd"
+	BatchCompressionLZ4  BatchCompression = "lz4"
+)
+
+// batchCompressor isn't safe for concurrent use, so every batch has its own one
+type batchCompressor interface {
+	compress(dst, src []byte) ([]byte, error)
+}
+
+// zstdEncoder is safe for concurrent use of the EncodeAll, so it's shared by all batches
+var zstdEncoder, _ = zstd.NewWriter(nil)
+
+func newBatchCompressor(c BatchCompression) batchCompressor {
+	switch c {
+	case "", BatchCompressionNone:
+		return nil
+	case BatchCompressionGzip:
+		return &gzipCompressor{w: gzip.NewWriter(nil)}
+	case BatchCompressionZstd:
+		return zstdCompressor{}
+	case BatchCompressionLZ4:
+		return &lz4Compressor{w: lz4.NewWriter(nil)}
+	default:
+		logger.Fatalf("unknown batch compression %q", c)
+		return nil
+	}
+}
+
+type gzipCompressor struct {
+	w *gzip.Writer
+}
+
+func (c *gzipCompressor) compress(dst, src []byte) ([]byte, error) {
+	buf := bytes.NewBuffer(dst[:0])
+	c.w.Reset(buf)
+	if _, err := c.w.Write(src); err != nil {
+		return nil, err
+	}
+	if err := c.w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+type zstdCompressor struct{}
+
+func (zstdCompressor) compress(dst, src []byte) ([]byte, error) {
+	return zstdEncoder.EncodeAll(src, dst[:0]), nil
+}
+
+type lz4Compressor struct {
+	w *lz4.Writer
+}
+
+func (c *lz4Compressor) compress(dst, src []byte) ([]byte, error) {
+	buf := bytes.NewBuffer(dst[:0])
+	c.w.Reset(buf)
+	if _, err := c.w.Write(src); err != nil {
+		return nil, err
+	}
+	if err := c.w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Compress compresses the data written outside the batches by the codec, e.g. the header of the file,
+// the gzip and zstd results can be concatenated with the data compressed by the Batch.Compress.
+func Compress(c BatchCompression, data []byte) ([]byte, error) {
+	compressor := newBatchCompressor(c)
+	if compressor == nil {
+		return data, nil
+	}
+	return compressor.compress(nil, data)
+}
+
+// SetCompression sets the codec of the Compress. The batches of the Batcher get it from the BatcherOptions.Compression,
+// so it's set only for the batches created outside the Batcher, e.g. by the tests of the outputs.
+func (b *Batch) SetCompression(c BatchCompression) {
+	b.compression = c
+	b.compressor = newBatchCompressor(c)
+}
+
+// Compression returns the codec used by the Compress.
+func (b *Batch) Compression() BatchCompression {
+	if b.compressor == nil {
+		return BatchCompressionNone
+	}
+	return b.compression
+}
+
+// Compress compresses the serialized batch with the BatcherOptions.Compression codec.
+// The result is valid until the batch is committed since the buffer is reused by the next batches.
+// If compression is disabled, data is returned as is.
+func (b *Batch) Compress(data []byte) ([]byte, error) {
+	if b.compressor == nil {
+		return data, nil
+	}
+
+	out, err := b.compressor.compress(b.compressBuf, data)
+	if err != nil {
+		return nil, err
+	}
+	b.compressBuf = out
+
+	if b.compressionRatio != nil && len(data) != 0 {
+		b.compressionRatio.Observe(float64(len(out)) / float64(len(data)))
+	}
+	return out, nil
+}
@@ -0,0 +1,89 @@
+package pipeline
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBatchCompress(t *testing.T) {
+	data := []byte(strings.Repeat(`{"level":"info","message":"some message"}`+"\n", 100))
+
+	tests := []struct {
+		compression BatchCompression
+		decompress  func(t *testing.T, data []byte) []byte
+	}{
+		{
+			compression: BatchCompressionNone,
+			decompress: func(_ *testing.T, data []byte) []byte {
+				return data
+			},
+		},
+		{
+			compression: BatchCompressionGzip,
+			decompress: func(t *testing.T, data []byte) []byte {
+				r, err := gzip.NewReader(bytes.NewReader(data))
+				require.NoError(t, err)
+				out, err := io.ReadAll(r)
+				require.NoError(t, err)
+				return out
+			},
+		},
+		{
+			compression: BatchCompressionZstd,
+			decompress: func(t *testing.T, data []byte) []byte {
+				r, err := zstd.NewReader(nil)
+				require.NoError(t, err)
+				out, err := r.DecodeAll(data, nil)
+				require.NoError(t, err)
+				return out
+			},
+		},
+		{
+			compression: BatchCompressionLZ4,
+			decompress: func(t *testing.T, data []byte) []byte {
+				out, err := io.ReadAll(lz4.NewReader(bytes.NewReader(data)))
+				require.NoError(t, err)
+				return out
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.compression), func(t *testing.T) {
+			batch := newBatch(10, 0, 0)
+			batch.compression = tt.compression
+			batch.compressor = newBatchCompressor(tt.compression)
+			batch.compressionRatio = prometheus.NewHistogram(prometheus.HistogramOpts{Name: "test"})
+			assert.Equal(t, tt.compression, batch.Compression())
+
+			// the buffer is reused for the next batches
+			for i := 0; i < 3; i++ {
+				compressed, err := batch.Compress(data)
+				require.NoError(t, err)
+				if tt.compression != BatchCompressionNone {
+					assert.Less(t, len(compressed), len(data))
+				}
+				assert.Equal(t, data, tt.decompress(t, compressed))
+			}
+
+			if tt.compression == BatchCompressionLZ4 {
+				return
+			}
+			// the data compressed outside the batch is concatenated with the batch
+			header, err := Compress(tt.compression, []byte("header\n"))
+			require.NoError(t, err)
+			compressed, err := batch.Compress(data)
+			require.NoError(t, err)
+			assert.Equal(t, append([]byte("header\n"), data...), tt.decompress(t, append(header, compressed...)))
+		})
+	}
+}
@@ -2,6 +2,8 @@ package pipeline
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"sync"
 	"testing"
 	"time"
@@ -9,7 +11,10 @@ import (
 	"github.com/ozontech/file.d/logger"
 	"github.com/ozontech/file.d/metric"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	insaneJSON "github.com/vitkovskii/insane-json"
 	"go.uber.org/atomic"
 )
 
@@ -36,12 +41,13 @@ func TestBatcher(t *testing.T) {
 	wg.Add(eventCount)
 
 	batchCount := &atomic.Int32{}
-	batcherOut := func(workerData *WorkerData, batch *Batch) {
+	batcherOut := func(workerData *WorkerData, batch *Batch) error {
 		if *workerData == nil {
 			*workerData = batchCount
 		}
 		counter := (*workerData).(*atomic.Int32)
 		counter.Inc()
+		return nil
 	}
 
 	seqIDs := make(map[SourceID]uint64)
@@ -107,12 +113,13 @@ func TestBatcherMaxSize(t *testing.T) {
 	wg.Add(eventCount)
 
 	batchCount := &atomic.Int32{}
-	batcherOut := func(workerData *WorkerData, batch *Batch) {
+	batcherOut := func(workerData *WorkerData, batch *Batch) error {
 		if *workerData == nil {
 			*workerData = batchCount
 		}
 		counter := (*workerData).(*atomic.Int32)
 		counter.Inc()
+		return nil
 	}
 
 	seqIDs := make(map[SourceID]uint64)
@@ -166,3 +173,963 @@ func TestBatcherMaxSize(t *testing.T) {
 	assert.Equal(t, int32(eventCount), commitsCount.Load(), "wrong commits count")
 	assert.Equal(t, int32(eventCount/(batchSize/eventSize)), batchCount.Load(), "wrong batches count")
 }
+
+func TestBatcherStopFlushesPartialBatch(t *testing.T) {
+	tests := []struct {
+		name string
+		stop func(b *Batcher, cancel context.CancelFunc)
+	}{
+		{
+			name: "stop",
+			stop: func(b *Batcher, _ context.CancelFunc) {
+				b.Stop()
+			},
+		},
+		{
+			name: "cancel",
+			stop: func(b *Batcher, cancel context.CancelFunc) {
+				cancel()
+				b.Stop()
+			},
+		},
+		{
+			name: "shutdown",
+			stop: func(b *Batcher, _ context.CancelFunc) {
+				assert.NoError(t, b.Shutdown(context.Background()))
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			eventCount := 150
+			batchSize := 100
+
+			batchCount := atomic.Int32{}
+			batcherOut := func(_ *WorkerData, _ *Batch) error {
+				batchCount.Inc()
+				return nil
+			}
+
+			commitsCount := atomic.Int32{}
+			prevSeqID := atomic.Int64{}
+			prevSeqID.Store(-1)
+			tail := &batcherTail{commit: func(event *Event) {
+				if int64(event.SeqID) <= prevSeqID.Load() {
+					logger.Panicf("wrong batch sequence: seq=%d, prev seq=%d", event.SeqID, prevSeqID.Load())
+				}
+				prevSeqID.Store(int64(event.SeqID))
+				commitsCount.Inc()
+			}}
+
+			batcher := NewBatcher(BatcherOptions{
+				PipelineName:   "test",
+				OutputType:     "devnull",
+				OutFn:          batcherOut,
+				Controller:     tail,
+				Workers:        4,
+				BatchSizeCount: batchSize,
+				FlushTimeout:   time.Hour,
+				MetricCtl:      metric.New("", prometheus.NewRegistry()),
+			})
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			batcher.Start(ctx)
+
+			for i := 0; i < eventCount; i++ {
+				batcher.Add(&Event{SeqID: uint64(i)})
+			}
+
+			tt.stop(batcher, cancel)
+
+			assert.Equal(t, int32(eventCount), commitsCount.Load(), "wrong commits count")
+			assert.Equal(t, int32(2), batchCount.Load(), "wrong batches count")
+
+			// events added after the stop are ignored
+			batcher.Add(&Event{SeqID: uint64(eventCount)})
+			batcher.Stop()
+			assert.Equal(t, int32(eventCount), commitsCount.Load(), "wrong commits count")
+		})
+	}
+}
+
+func TestBatcherShutdownTimeout(t *testing.T) {
+	release := make(chan struct{})
+	batcherOut := func(_ *WorkerData, _ *Batch) error {
+		<-release
+		return nil
+	}
+
+	commitsCount := atomic.Int32{}
+	tail := &batcherTail{commit: func(_ *Event) {
+		commitsCount.Inc()
+	}}
+
+	batcher := NewBatcher(BatcherOptions{
+		PipelineName:   "test",
+		OutputType:     "devnull",
+		OutFn:          batcherOut,
+		Controller:     tail,
+		Workers:        1,
+		BatchSizeCount: 10,
+		FlushTimeout:   time.Hour,
+		MetricCtl:      metric.New("", prometheus.NewRegistry()),
+	})
+	batcher.Start(context.Background())
+
+	for i := 0; i < 5; i++ {
+		batcher.Add(&Event{SeqID: uint64(i)})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	assert.ErrorIs(t, batcher.Shutdown(ctx), context.DeadlineExceeded)
+	assert.Equal(t, int32(0), commitsCount.Load(), "batch shouldn't be committed")
+
+	// the flush continues after the timeout
+	close(release)
+	batcher.Stop()
+	assert.Equal(t, int32(5), commitsCount.Load(), "wrong commits count")
+}
+
+func TestBatcherOversizedEvent(t *testing.T) {
+	mu := sync.Mutex{}
+	batches := make([][]uint64, 0)
+	batcherOut := func(_ *WorkerData, batch *Batch) error {
+		seqIDs := make([]uint64, 0, batch.Len())
+		batch.ForEach(func(e *Event) bool {
+			seqIDs = append(seqIDs, e.SeqID)
+			return true
+		})
+		mu.Lock()
+		batches = append(batches, seqIDs)
+		mu.Unlock()
+		return nil
+	}
+
+	ctl := metric.New("", prometheus.NewRegistry())
+	batcher := NewBatcher(BatcherOptions{
+		PipelineName:       "test",
+		OutputType:         "devnull",
+		OutFn:              batcherOut,
+		Controller:         &batcherTail{commit: func(_ *Event) {}},
+		Workers:            1,
+		MaxInFlightBatches: 1,
+		BatchSizeBytes:     100,
+		FlushTimeout:       time.Hour,
+		MetricCtl:          ctl,
+	})
+	batcher.Start(context.Background())
+
+	for i, size := range []int{10, 10, 1000, 10} {
+		batcher.Add(&Event{SeqID: uint64(i), Size: size})
+	}
+	batcher.Stop()
+
+	assert.Equal(t, [][]uint64{{0, 1}, {2}, {3}}, batches, "oversized event isn't sent in its own batch")
+	assert.Equal(t, 1.0, testutil.ToFloat64(batcher.oversizedEvents))
+}
+
+func TestBatcherMaxInFlightBatches(t *testing.T) {
+	maxInFlight := 4
+
+	unblock := make(chan struct{})
+	batcherOut := func(_ *WorkerData, _ *Batch) error {
+		<-unblock
+		return nil
+	}
+
+	commitsCount := atomic.Int32{}
+	tail := &batcherTail{commit: func(_ *Event) {
+		commitsCount.Inc()
+	}}
+
+	batcher := NewBatcher(BatcherOptions{
+		PipelineName:       "test",
+		OutputType:         "devnull",
+		OutFn:              batcherOut,
+		Controller:         tail,
+		Workers:            1,
+		MaxInFlightBatches: maxInFlight,
+		BatchSizeCount:     1,
+		FlushTimeout:       time.Hour,
+		MetricCtl:          metric.New("", prometheus.NewRegistry()),
+	})
+	batcher.Start(context.Background())
+
+	added := atomic.Int32{}
+	go func() {
+		for i := 0; i < maxInFlight+1; i++ {
+			batcher.Add(&Event{SeqID: uint64(i)})
+			added.Inc()
+		}
+	}()
+
+	// the only worker is stuck, but the batcher accepts events until in-flight limit is reached
+	assert.Eventually(t, func() bool {
+		return added.Load() == int32(maxInFlight)
+	}, time.Second*5, time.Millisecond*10)
+	time.Sleep(time.Millisecond * 100)
+	assert.Equal(t, int32(maxInFlight), added.Load(), "batcher should apply backpressure")
+
+	close(unblock)
+	assert.Eventually(t, func() bool {
+		return commitsCount.Load() == int32(maxInFlight+1)
+	}, time.Second*5, time.Millisecond*10)
+
+	batcher.Stop()
+}
+
+func TestBatcherRetry(t *testing.T) {
+	tests := []struct {
+		name          string
+		failures      int32
+		maxRetries    int
+		wantCalls     int32
+		wantRetries   float64
+		wantCommitted int32
+	}{
+		{
+			name:          "success_after_retries",
+			failures:      2,
+			maxRetries:    3,
+			wantCalls:     3,
+			wantRetries:   2,
+			wantCommitted: 10,
+		},
+		{
+			name:          "retries_exhausted",
+			failures:      100,
+			maxRetries:    3,
+			wantCalls:     4,
+			wantRetries:   3,
+			wantCommitted: 10,
+		},
+		{
+			name:          "no_retries",
+			failures:      1,
+			maxRetries:    0,
+			wantCalls:     1,
+			wantRetries:   0,
+			wantCommitted: 10,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := atomic.Int32{}
+			batcherOut := func(_ *WorkerData, _ *Batch) error {
+				if calls.Inc() <= tt.failures {
+					return errors.New("output is unavailable")
+				}
+				return nil
+			}
+
+			commitsCount := atomic.Int32{}
+			tail := &batcherTail{commit: func(_ *Event) {
+				commitsCount.Inc()
+			}}
+
+			ctl := metric.New("", prometheus.NewRegistry())
+			batcher := NewBatcher(BatcherOptions{
+				PipelineName:   "test",
+				OutputType:     "devnull",
+				OutFn:          batcherOut,
+				Controller:     tail,
+				Workers:        1,
+				BatchSizeCount: 10,
+				FlushTimeout:   time.Hour,
+				MetricCtl:      ctl,
+				Retry: BatcherRetry{
+					MaxRetries:        tt.maxRetries,
+					InitialBackoff:    time.Millisecond,
+					BackoffMultiplier: 2,
+					MaxBackoff:        time.Millisecond * 4,
+				},
+			})
+			batcher.Start(context.Background())
+
+			for i := 0; i < 10; i++ {
+				batcher.Add(&Event{SeqID: uint64(i)})
+			}
+			batcher.Stop()
+
+			assert.Equal(t, tt.wantCalls, calls.Load(), "wrong out fn calls count")
+			assert.Equal(t, tt.wantCommitted, commitsCount.Load(), "wrong commits count")
+			assert.Equal(t, tt.wantRetries, testutil.ToFloat64(batcher.outFnRetries), "wrong retries count")
+		})
+	}
+}
+
+func TestBatcherRetryNextBackoff(t *testing.T) {
+	r := BatcherRetry{
+		InitialBackoff:    time.Millisecond * 10,
+		BackoffMultiplier: 3,
+		MaxBackoff:        time.Millisecond * 50,
+	}
+
+	backoff := r.InitialBackoff
+	var got []time.Duration
+	for i := 0; i < 4; i++ {
+		backoff = r.nextBackoff(backoff)
+		got = append(got, backoff)
+	}
+	assert.Equal(t, []time.Duration{
+		time.Millisecond * 30, time.Millisecond * 50, time.Millisecond * 50, time.Millisecond * 50,
+	}, got)
+
+	r.BackoffMultiplier = 0
+	assert.Equal(t, time.Millisecond*10, r.nextBackoff(time.Millisecond*10))
+}
+
+func TestBatchDelayRetry(t *testing.T) {
+	b := newBatch(10, 0, time.Hour)
+
+	assert.Equal(t, time.Millisecond*10, b.retryBackoff(time.Millisecond*10))
+
+	b.DelayRetry(time.Second)
+	assert.Equal(t, time.Second, b.retryBackoff(time.Millisecond*10))
+	// the delay is applied once
+	assert.Equal(t, time.Millisecond*10, b.retryBackoff(time.Millisecond*10))
+
+	// the backoff is used if it's longer
+	b.DelayRetry(time.Millisecond)
+	assert.Equal(t, time.Millisecond*10, b.retryBackoff(time.Millisecond*10))
+}
+
+func TestBatchReadyReason(t *testing.T) {
+	tests := []struct {
+		name         string
+		maxSizeCount int
+		maxSizeBytes int
+		timeout      time.Duration
+		events       []*Event
+		want         string
+	}{
+		{
+			name:         "count",
+			maxSizeCount: 2,
+			timeout:      time.Hour,
+			events:       []*Event{{Size: 1}, {Size: 1}},
+			want:         "count",
+		},
+		{
+			name:         "bytes",
+			maxSizeBytes: 10,
+			timeout:      time.Hour,
+			events:       []*Event{{Size: 6}, {Size: 6}},
+			want:         "bytes",
+		},
+		{
+			name:         "timeout",
+			maxSizeCount: 10,
+			timeout:      -time.Second,
+			events:       []*Event{{Size: 1}},
+			want:         "timeout",
+		},
+		{
+			name:         "not_ready",
+			maxSizeCount: 10,
+			timeout:      time.Hour,
+			events:       []*Event{{Size: 1}},
+			want:         "not_ready",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			batch := newBatch(tt.maxSizeCount, tt.maxSizeBytes, tt.timeout)
+			batch.reset()
+			for _, e := range tt.events {
+				batch.append(e)
+			}
+			batch.updateStatus()
+			assert.Equal(t, tt.want, batch.readyReason())
+		})
+	}
+}
+
+func TestBatchMinSizeCount(t *testing.T) {
+	tests := []struct {
+		name           string
+		events         int
+		minSizeCount   int
+		timeout        time.Duration
+		maxHoldTimeout time.Duration
+		want           BatchStatus
+	}{
+		{
+			name:           "min_size_not_reached",
+			events:         2,
+			minSizeCount:   3,
+			timeout:        -time.Second,
+			maxHoldTimeout: time.Hour,
+			want:           BatchStatusNotReady,
+		},
+		{
+			name:           "min_size_reached",
+			events:         3,
+			minSizeCount:   3,
+			timeout:        -time.Second,
+			maxHoldTimeout: time.Hour,
+			want:           BatchStatusTimeoutExceeded,
+		},
+		{
+			name:           "min_size_reached_timeout_not_exceeded",
+			events:         3,
+			minSizeCount:   3,
+			timeout:        time.Hour,
+			maxHoldTimeout: time.Hour,
+			want:           BatchStatusNotReady,
+		},
+		{
+			name:           "max_hold_timeout_exceeded",
+			events:         1,
+			minSizeCount:   3,
+			timeout:        -time.Second,
+			maxHoldTimeout: -time.Second,
+			want:           BatchStatusTimeoutExceeded,
+		},
+		{
+			name:           "max_hold_timeout_empty_batch",
+			events:         0,
+			minSizeCount:   3,
+			timeout:        -time.Second,
+			maxHoldTimeout: -time.Second,
+			want:           BatchStatusNotReady,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			batch := newBatch(10, 0, tt.timeout)
+			batch.minSizeCount = tt.minSizeCount
+			batch.maxHoldTimeout = tt.maxHoldTimeout
+			batch.reset()
+			for i := 0; i < tt.events; i++ {
+				batch.append(&Event{})
+			}
+			assert.Equal(t, tt.want, batch.updateStatus())
+		})
+	}
+}
+
+func TestBatchFlushTimeoutJitter(t *testing.T) {
+	const timeout = time.Second
+
+	batch := newBatch(10, 0, timeout)
+	batch.timeoutJitter = 0.2
+
+	seen := make(map[time.Duration]struct{})
+	for i := 0; i < 100; i++ {
+		batch.reset()
+		assert.GreaterOrEqual(t, batch.timeout, time.Duration(float64(timeout)*0.8))
+		assert.LessOrEqual(t, batch.timeout, time.Duration(float64(timeout)*1.2))
+		seen[batch.timeout] = struct{}{}
+	}
+	assert.Greater(t, len(seen), 1, "timeout isn't randomized")
+
+	batch.timeoutJitter = 0
+	batch.reset()
+	assert.Equal(t, timeout, batch.timeout)
+}
+
+func TestBatcherHeartbeatInterval(t *testing.T) {
+	tests := []struct {
+		name              string
+		heartbeatInterval time.Duration
+		flushTimeout      time.Duration
+		want              time.Duration
+	}{
+		{
+			name:         "default",
+			flushTimeout: time.Second,
+			want:         defaultHeartbeatInterval,
+		},
+		{
+			name:              "custom",
+			heartbeatInterval: time.Millisecond * 10,
+			flushTimeout:      time.Second,
+			want:              time.Millisecond * 10,
+		},
+		{
+			name:         "clamped_by_flush_timeout",
+			flushTimeout: time.Millisecond * 20,
+			want:         time.Millisecond * 10,
+		},
+		{
+			name:         "min",
+			flushTimeout: time.Microsecond,
+			want:         minHeartbeatInterval,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			batcher := NewBatcher(BatcherOptions{
+				PipelineName:      "test",
+				OutputType:        "devnull",
+				Workers:           1,
+				BatchSizeCount:    10,
+				FlushTimeout:      tt.flushTimeout,
+				HeartbeatInterval: tt.heartbeatInterval,
+				MetricCtl:         metric.New("", prometheus.NewRegistry()),
+			})
+			assert.Equal(t, tt.want, batcher.heartbeatInterval)
+		})
+	}
+}
+
+func TestBatcherFlushMarker(t *testing.T) {
+	for _, forward := range []bool{false, true} {
+		t.Run(fmt.Sprintf("forward_%t", forward), func(t *testing.T) {
+			sentCount := atomic.Int32{}
+			batcherOut := func(_ *WorkerData, batch *Batch) error {
+				sentCount.Add(int32(len(batch.Events)))
+				return nil
+			}
+
+			commitsCount := atomic.Int32{}
+			lastCommitted := atomic.Uint64{}
+			tail := &batcherTail{commit: func(event *Event) {
+				commitsCount.Inc()
+				lastCommitted.Store(event.SeqID)
+			}}
+
+			batcher := NewBatcher(BatcherOptions{
+				PipelineName:        "test",
+				OutputType:          "devnull",
+				OutFn:               batcherOut,
+				Controller:          tail,
+				Workers:             1,
+				BatchSizeCount:      100,
+				FlushTimeout:        time.Hour,
+				ForwardFlushMarkers: forward,
+				MetricCtl:           metric.New("", prometheus.NewRegistry()),
+			})
+			batcher.Start(context.Background())
+
+			for i := 0; i < 5; i++ {
+				batcher.Add(&Event{SeqID: uint64(i)})
+			}
+			marker := &Event{SeqID: 5}
+			marker.SetFlushMarker()
+			batcher.Add(marker)
+
+			assert.Eventually(t, func() bool {
+				return commitsCount.Load() == 6
+			}, time.Second*5, time.Millisecond*10, "batch should be flushed without waiting for the timeout")
+			assert.Equal(t, uint64(5), lastCommitted.Load(), "marker should be committed last")
+
+			wantSent := int32(5)
+			if forward {
+				wantSent = 6
+			}
+			assert.Equal(t, wantSent, sentCount.Load(), "wrong sent events count")
+
+			batcher.Stop()
+		})
+	}
+}
+
+func TestBatcherChildParent(t *testing.T) {
+	sent := make([]uint64, 0)
+	batcherOut := func(_ *WorkerData, batch *Batch) error {
+		for batch.Next() {
+			sent = append(sent, batch.Value().SeqID)
+		}
+		return nil
+	}
+
+	mu := sync.Mutex{}
+	committed := make([]uint64, 0)
+	tail := &batcherTail{commit: func(event *Event) {
+		mu.Lock()
+		committed = append(committed, event.SeqID)
+		mu.Unlock()
+	}}
+
+	batcher := NewBatcher(BatcherOptions{
+		PipelineName:   "test",
+		OutputType:     "devnull",
+		OutFn:          batcherOut,
+		Controller:     tail,
+		Workers:        1,
+		BatchSizeCount: 100,
+		FlushTimeout:   time.Millisecond * 100,
+		MetricCtl:      metric.New("", prometheus.NewRegistry()),
+	})
+	batcher.Start(context.Background())
+
+	// the children have the seq id of the parent, so the ids are shifted to tell them apart
+	batcher.Add(&Event{SeqID: 0})
+	batcher.Add(&Event{SeqID: 11, isChild: true})
+	batcher.Add(&Event{SeqID: 12, isChild: true})
+	batcher.Add(&Event{SeqID: 1, isChildParent: true})
+	batcher.Add(&Event{SeqID: 2})
+	batcher.Add(&Event{SeqID: 3, isChildParent: true})
+
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(committed) == 6
+	}, time.Second*5, time.Millisecond*10, "batch with parents should be flushed by the timeout")
+	batcher.Stop()
+
+	assert.Equal(t, []uint64{0, 11, 12, 2}, sent, "parents shouldn't be passed to the output")
+	assert.Equal(t, []uint64{0, 11, 12, 1, 2, 3}, committed, "parents should be committed in order")
+}
+
+func TestBatcherPartitionKey(t *testing.T) {
+	tests := []struct {
+		name        string
+		keys        int
+		overflow    PartitionOverflowPolicy
+		wantMixed   bool
+		wantBatches int32
+	}{
+		{
+			name:        "keys_fit",
+			keys:        3,
+			overflow:    PartitionOverflowShared,
+			wantBatches: 3,
+		},
+		{
+			name:        "overflow_shared",
+			keys:        5,
+			overflow:    PartitionOverflowShared,
+			wantMixed:   true,
+			wantBatches: 4,
+		},
+		{
+			name:     "overflow_flush_oldest",
+			keys:     5,
+			overflow: PartitionOverflowFlushOldest,
+			// every new key flushes the oldest batch
+			wantBatches: 20,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			eventsPerKey := 4
+			eventCount := tt.keys * eventsPerKey
+
+			mu := sync.Mutex{}
+			mixed := false
+			batchCount := atomic.Int32{}
+			batcherOut := func(_ *WorkerData, batch *Batch) error {
+				batchCount.Inc()
+				mu.Lock()
+				defer mu.Unlock()
+				for _, e := range batch.Events {
+					if e.Root.Dig("key").AsString() != batch.Events[0].Root.Dig("key").AsString() {
+						mixed = true
+					}
+				}
+				return nil
+			}
+
+			commitsCount := atomic.Int32{}
+			tail := &batcherTail{commit: func(_ *Event) {
+				commitsCount.Inc()
+			}}
+
+			batcher := NewBatcher(BatcherOptions{
+				PipelineName:      "test",
+				OutputType:        "devnull",
+				OutFn:             batcherOut,
+				Controller:        tail,
+				Workers:           2,
+				BatchSizeCount:    eventCount,
+				FlushTimeout:      time.Hour,
+				PartitionKey:      []string{"key"},
+				MaxPartitions:     3,
+				PartitionOverflow: tt.overflow,
+				MetricCtl:         metric.New("", prometheus.NewRegistry()),
+			})
+			batcher.Start(context.Background())
+
+			roots := make([]*insaneJSON.Root, 0, eventCount)
+			defer func() {
+				for _, root := range roots {
+					insaneJSON.Release(root)
+				}
+			}()
+			for i := 0; i < eventCount; i++ {
+				root, err := insaneJSON.DecodeString(fmt.Sprintf(`{"key":"k%d"}`, i%tt.keys))
+				assert.NoError(t, err)
+				roots = append(roots, root)
+				batcher.Add(&Event{SeqID: uint64(i), Root: root})
+			}
+			batcher.Stop()
+
+			assert.Equal(t, int32(eventCount), commitsCount.Load(), "wrong commits count")
+			assert.Equal(t, tt.wantBatches, batchCount.Load(), "wrong batches count")
+			assert.Equal(t, tt.wantMixed, mixed, "wrong batches content")
+		})
+	}
+}
+
+func TestBatchForEach(t *testing.T) {
+	batch := newBatch(10, 0, time.Hour)
+	batch.reset()
+	for i := 0; i < 5; i++ {
+		batch.append(&Event{SeqID: uint64(i)})
+	}
+	marker := &Event{SeqID: 5}
+	marker.SetFlushMarker()
+	batch.append(marker)
+
+	assert.Equal(t, 5, batch.Len(), "flush marker shouldn't be counted")
+
+	var seqIDs []uint64
+	batch.ForEach(func(event *Event) bool {
+		seqIDs = append(seqIDs, event.SeqID)
+		return true
+	})
+	assert.Equal(t, []uint64{0, 1, 2, 3, 4}, seqIDs)
+
+	seqIDs = seqIDs[:0]
+	batch.ForEach(func(event *Event) bool {
+		seqIDs = append(seqIDs, event.SeqID)
+		return event.SeqID < 2
+	})
+	assert.Equal(t, []uint64{0, 1, 2}, seqIDs, "iteration should stop early")
+}
+
+func TestBatchRewindIterator(t *testing.T) {
+	batch := newBatch(10, 0, time.Hour)
+	batch.reset()
+	for i := 0; i < 3; i++ {
+		batch.append(&Event{SeqID: uint64(i)})
+	}
+
+	collect := func() []uint64 {
+		var seqIDs []uint64
+		for batch.Next() {
+			seqIDs = append(seqIDs, batch.Value().SeqID)
+		}
+		return seqIDs
+	}
+
+	assert.Equal(t, []uint64{0, 1, 2}, collect())
+	assert.Empty(t, collect(), "iterator should be exhausted")
+
+	batch.RewindIterator()
+	assert.Equal(t, []uint64{0, 1, 2}, collect(), "iterator should be rewound")
+
+	batch.reset()
+	batch.append(&Event{SeqID: 3})
+	assert.Equal(t, []uint64{3}, collect(), "reset should rewind the iterator")
+}
+
+func TestBatcherMarkFailed(t *testing.T) {
+	tests := []struct {
+		name       string
+		maxRetries int
+		// failUntil is the attempt number after which events are sent successfully
+		failUntil int
+		wantSent  []uint64
+	}{
+		{
+			name:       "retry_failed_only",
+			maxRetries: 3,
+			failUntil:  2,
+			wantSent:   []uint64{0, 1, 2, 3, 4, 5, 1, 3, 5, 1, 3, 5},
+		},
+		{
+			name:       "retries_exhausted",
+			maxRetries: 1,
+			failUntil:  10,
+			wantSent:   []uint64{0, 1, 2, 3, 4, 5, 1, 3, 5},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempt := 0
+			var sent []uint64
+			batcherOut := func(_ *WorkerData, batch *Batch) error {
+				attempt++
+				for batch.Next() {
+					e := batch.Value()
+					sent = append(sent, e.SeqID)
+					if e.SeqID%2 == 1 && attempt <= tt.failUntil {
+						batch.MarkFailed(e)
+					}
+				}
+				return nil
+			}
+
+			var committed []uint64
+			tail := &batcherTail{commit: func(event *Event) {
+				committed = append(committed, event.SeqID)
+			}}
+
+			batcher := NewBatcher(BatcherOptions{
+				PipelineName:   "test",
+				OutputType:     "devnull",
+				OutFn:          batcherOut,
+				Controller:     tail,
+				Workers:        1,
+				BatchSizeCount: 6,
+				FlushTimeout:   time.Hour,
+				MetricCtl:      metric.New("", prometheus.NewRegistry()),
+				Retry: BatcherRetry{
+					MaxRetries:     tt.maxRetries,
+					InitialBackoff: time.Millisecond,
+				},
+			})
+			batcher.Start(context.Background())
+
+			for i := 0; i < 6; i++ {
+				batcher.Add(&Event{SeqID: uint64(i)})
+			}
+			batcher.Stop()
+
+			assert.Equal(t, tt.wantSent, sent, "wrong sent events")
+			assert.Equal(t, []uint64{0, 1, 2, 3, 4, 5}, committed, "all events should be committed in order")
+		})
+	}
+}
+
+func TestBatchEventRecalcSize(t *testing.T) {
+	event := newEvent()
+	assert.NoError(t, event.parseJSON([]byte(`{"message":"hello"}`)))
+	event.Size = len(`{"message":"hello"}`)
+	event.Buf = append(event.Buf, "prefix"...)
+
+	event.Root.AddFieldNoAlloc(event.Root, "level").MutateToString("info")
+	event.RecalcSize()
+	assert.Equal(t, len(`{"message":"hello","level":"info"}`), event.Size)
+	assert.Equal(t, "prefix", string(event.Buf), "buf shouldn't be changed")
+
+	batch := newBatch(0, 100, time.Hour)
+	batch.reset()
+	batch.append(event)
+	assert.Equal(t, len(`{"message":"hello","level":"info"}`), batch.eventsSize)
+}
+
+func TestBatcherEventLatency(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	batcher := NewBatcher(BatcherOptions{
+		PipelineName: "test",
+		OutputType:   "devnull",
+		OutFn: func(_ *WorkerData, _ *Batch) error {
+			return nil
+		},
+		Controller:     &batcherTail{commit: func(_ *Event) {}},
+		Workers:        1,
+		BatchSizeCount: 3,
+		FlushTimeout:   time.Hour,
+		MetricCtl:      metric.New("", registry),
+	})
+	batcher.Start(context.Background())
+
+	now := time.Now()
+	batcher.Add(&Event{SeqID: 0, createdAt: now.Add(-time.Second)})
+	batcher.Add(&Event{SeqID: 1, createdAt: now.Add(-2 * time.Second)})
+	// events made by the plugins don't have the creation time
+	batcher.Add(&Event{SeqID: 2})
+	batcher.Stop()
+
+	families, err := registry.Gather()
+	require.NoError(t, err)
+	for _, family := range families {
+		if family.GetName() != "file_d_batcher_event_latency_seconds" {
+			continue
+		}
+		require.Len(t, family.GetMetric(), 1)
+		m := family.GetMetric()[0]
+		assert.Equal(t, "devnull", m.GetLabel()[0].GetValue())
+		assert.Equal(t, uint64(2), m.GetHistogram().GetSampleCount())
+		assert.GreaterOrEqual(t, m.GetHistogram().GetSampleSum(), 3.0)
+		return
+	}
+	t.Fatal("latency histogram isn't registered")
+}
+
+func TestBatcherMaxEventAge(t *testing.T) {
+	sent := make([]uint64, 0)
+	batcherOut := func(_ *WorkerData, batch *Batch) error {
+		for batch.Next() {
+			sent = append(sent, batch.Value().SeqID)
+		}
+		return nil
+	}
+
+	committed := make([]uint64, 0)
+	tail := &batcherTail{commit: func(event *Event) {
+		committed = append(committed, event.SeqID)
+	}}
+
+	ctl := metric.New("", prometheus.NewRegistry())
+	batcher := NewBatcher(BatcherOptions{
+		PipelineName:   "test",
+		OutputType:     "devnull",
+		OutFn:          batcherOut,
+		Controller:     tail,
+		Workers:        1,
+		BatchSizeCount: 4,
+		FlushTimeout:   time.Hour,
+		MaxEventAge:    time.Minute,
+		MetricCtl:      ctl,
+	})
+	batcher.Start(context.Background())
+
+	now := time.Now()
+	batcher.Add(&Event{SeqID: 0, createdAt: now.Add(-time.Hour)})
+	batcher.Add(&Event{SeqID: 1, createdAt: now})
+	batcher.Add(&Event{SeqID: 2, createdAt: now.Add(-time.Hour)})
+	// events without the creation time never expire
+	batcher.Add(&Event{SeqID: 3})
+	batcher.Stop()
+
+	assert.Equal(t, []uint64{1, 3}, sent, "expired events shouldn't be sent")
+	assert.Equal(t, []uint64{0, 1, 2, 3}, committed, "all events should be committed in order")
+	assert.Equal(t, float64(2), testutil.ToFloat64(batcher.expiredEvents))
+}
+
+func TestBatcherFailedRetryTimeout(t *testing.T) {
+	calls := 0
+	batcherOut := func(_ *WorkerData, batch *Batch) error {
+		calls++
+		for batch.Next() {
+			if batch.Value().SeqID == 1 {
+				batch.MarkFailed(batch.Value())
+			}
+		}
+		return nil
+	}
+
+	var committed []uint64
+	batcher := NewBatcher(BatcherOptions{
+		PipelineName:   "test",
+		OutputType:     "devnull",
+		OutFn:          batcherOut,
+		Controller:     &batcherTail{commit: func(event *Event) { committed = append(committed, event.SeqID) }},
+		Workers:        1,
+		BatchSizeCount: 3,
+		FlushTimeout:   time.Hour,
+		MetricCtl:      metric.New("", prometheus.NewRegistry()),
+		Retry: BatcherRetry{
+			MaxRetries:     1000,
+			InitialBackoff: 20 * time.Millisecond,
+			FailedTimeout:  100 * time.Millisecond,
+		},
+	})
+	batcher.Start(context.Background())
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		batcher.Add(&Event{SeqID: uint64(i)})
+	}
+	batcher.Stop()
+
+	// the failing event doesn't hold the worker for all the retries
+	assert.Less(t, time.Since(start), time.Second)
+	assert.LessOrEqual(t, calls, 6)
+	assert.Equal(t, []uint64{0, 1, 2}, committed)
+}
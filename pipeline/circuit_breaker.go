@@ -0,0 +1,149 @@
+package pipeline
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const DefaultCircuitBreakerCooldown = 30 * time.Second
+
+// BatcherCircuitBreaker is disabled if FailureThreshold is zero.
+type BatcherCircuitBreaker struct {
+	// FailureThreshold is the number of consecutive OutFn failures which opens the breaker
+	FailureThreshold int
+	// Cooldown is how long the breaker stays open before one OutFn call is allowed to test the output
+	Cooldown time.Duration
+}
+
+type circuitState byte
+
+const (
+	circuitClosed circuitState = iota
+	circuitOpen
+	circuitHalfOpen
+)
+
+// circuitBreaker stops the OutFn calls of all workers when the output fails continuously.
+// While it's open the workers hold their batches, so the batcher stops accepting events
+// when all in-flight batches are held and the input is blocked.
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	state    circuitState
+	failures int
+	openedAt time.Time
+	// changed is closed on each state change to wake up the waiting workers
+	changed  chan struct{}
+	stopped  chan struct{}
+	stopOnce sync.Once
+
+	stateMetric  prometheus.Gauge
+	openedMetric prometheus.Counter
+}
+
+func newCircuitBreaker(opts BatcherCircuitBreaker, stateMetric prometheus.Gauge, openedMetric prometheus.Counter) *circuitBreaker {
+	cooldown := opts.Cooldown
+	if cooldown <= 0 {
+		cooldown = DefaultCircuitBreakerCooldown
+	}
+
+	return &circuitBreaker{
+		threshold:    opts.FailureThreshold,
+		cooldown:     cooldown,
+		changed:      make(chan struct{}),
+		stopped:      make(chan struct{}),
+		stateMetric:  stateMetric,
+		openedMetric: openedMetric,
+	}
+}
+
+// acquire blocks while the breaker is open. After the cooldown the breaker is half-open
+// and only the first caller passes, others wait for the result of its call.
+// The breaker doesn't block after the stop, so the batches are flushed with the usual retries.
+func (c *circuitBreaker) acquire() {
+	for {
+		c.mu.Lock()
+		var wait time.Duration
+		switch c.state {
+		case circuitClosed:
+			c.mu.Unlock()
+			return
+		case circuitOpen:
+			wait = c.cooldown - time.Since(c.openedAt)
+			if wait <= 0 {
+				c.setState(circuitHalfOpen)
+				c.mu.Unlock()
+				return
+			}
+		}
+		changed := c.changed
+		c.mu.Unlock()
+
+		if !c.wait(changed, wait) {
+			return
+		}
+	}
+}
+
+// wait returns false if the breaker is stopped, zero timeout means no timeout
+func (c *circuitBreaker) wait(changed chan struct{}, timeout time.Duration) bool {
+	var timer <-chan time.Time
+	if timeout > 0 {
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+		timer = t.C
+	}
+
+	select {
+	case <-changed:
+	case <-timer:
+	case <-c.stopped:
+		return false
+	}
+	return true
+}
+
+// done reports the result of the OutFn call
+func (c *circuitBreaker) done(ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if ok {
+		c.failures = 0
+		if c.state != circuitClosed {
+			c.setState(circuitClosed)
+		}
+		return
+	}
+
+	c.failures++
+	if c.state == circuitHalfOpen || c.state == circuitClosed && c.failures >= c.threshold {
+		c.openedAt = time.Now()
+		c.setState(circuitOpen)
+		c.openedMetric.Inc()
+	}
+}
+
+func (c *circuitBreaker) isOpen() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.state == circuitOpen
+}
+
+func (c *circuitBreaker) stop() {
+	c.stopOnce.Do(func() {
+		close(c.stopped)
+	})
+}
+
+// setState mu should be locked
+func (c *circuitBreaker) setState(state circuitState) {
+	c.state = state
+	c.stateMetric.Set(float64(state))
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
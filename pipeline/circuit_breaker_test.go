@@ -0,0 +1,148 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ozontech/file.d/metric"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/atomic"
+)
+
+func newTestCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	ctl := metric.New("", prometheus.NewRegistry())
+	return newCircuitBreaker(BatcherCircuitBreaker{FailureThreshold: threshold, Cooldown: cooldown},
+		ctl.RegisterGauge("state", "").WithLabelValues(),
+		ctl.RegisterCounter("opened", "").WithLabelValues(),
+	)
+}
+
+func acquireAsync(c *circuitBreaker) chan struct{} {
+	acquired := make(chan struct{})
+	go func() {
+		c.acquire()
+		close(acquired)
+	}()
+	return acquired
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	c := newTestCircuitBreaker(2, 50*time.Millisecond)
+
+	c.done(false)
+	c.acquire()
+	assert.Equal(t, circuitClosed, c.state, "breaker is opened before the threshold")
+
+	c.done(false)
+	assert.Equal(t, circuitOpen, c.state)
+
+	first := acquireAsync(c)
+	select {
+	case <-first:
+		t.Fatal("call is allowed before the cooldown")
+	case <-time.After(20 * time.Millisecond):
+	}
+	<-first
+	assert.Equal(t, circuitHalfOpen, c.state)
+
+	second := acquireAsync(c)
+	select {
+	case <-second:
+		t.Fatal("second call is allowed in the half-open state")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	c.done(true)
+	<-second
+	assert.Equal(t, circuitClosed, c.state)
+	assert.Equal(t, 1.0, testutil.ToFloat64(c.openedMetric))
+}
+
+func TestCircuitBreakerHalfOpenFailure(t *testing.T) {
+	c := newTestCircuitBreaker(1, 20*time.Millisecond)
+
+	c.done(false)
+	c.acquire()
+	require.Equal(t, circuitHalfOpen, c.state)
+
+	c.done(false)
+	assert.Equal(t, circuitOpen, c.state, "failed trial call should open the breaker again")
+	assert.Equal(t, 2.0, testutil.ToFloat64(c.openedMetric))
+}
+
+func TestCircuitBreakerStop(t *testing.T) {
+	c := newTestCircuitBreaker(1, time.Hour)
+	c.done(false)
+
+	acquired := acquireAsync(c)
+	c.stop()
+
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatal("call is blocked after the stop")
+	}
+}
+
+func TestBatcherCircuitBreaker(t *testing.T) {
+	const cooldown = 100 * time.Millisecond
+
+	mu := sync.Mutex{}
+	calls := make([]time.Time, 0)
+	batcherOut := func(_ *WorkerData, _ *Batch) error {
+		mu.Lock()
+		defer mu.Unlock()
+		calls = append(calls, time.Now())
+		if len(calls) <= 3 {
+			return errors.New("output is unavailable")
+		}
+		return nil
+	}
+
+	commitsCount := atomic.Int32{}
+	tail := &batcherTail{commit: func(_ *Event) {
+		commitsCount.Inc()
+	}}
+
+	batcher := NewBatcher(BatcherOptions{
+		PipelineName:   "test",
+		OutputType:     "devnull",
+		OutFn:          batcherOut,
+		Controller:     tail,
+		Workers:        1,
+		BatchSizeCount: 10,
+		FlushTimeout:   time.Hour,
+		MetricCtl:      metric.New("", prometheus.NewRegistry()),
+		Retry: BatcherRetry{
+			MaxRetries:     10,
+			InitialBackoff: time.Millisecond,
+		},
+		CircuitBreaker: BatcherCircuitBreaker{
+			FailureThreshold: 2,
+			Cooldown:         cooldown,
+		},
+	})
+	batcher.Start(context.Background())
+
+	for i := 0; i < 10; i++ {
+		batcher.Add(&Event{SeqID: uint64(i)})
+	}
+	assert.Eventually(t, func() bool {
+		return commitsCount.Load() == 10
+	}, 5*time.Second, 10*time.Millisecond)
+	batcher.Stop()
+
+	// the breaker is opened by the second failure and by the failed trial call after the first cooldown
+	require.Len(t, calls, 4)
+	assert.Less(t, calls[1].Sub(calls[0]), cooldown)
+	assert.GreaterOrEqual(t, calls[2].Sub(calls[1]), cooldown)
+	assert.GreaterOrEqual(t, calls[3].Sub(calls[2]), cooldown)
+	assert.Equal(t, 2.0, testutil.ToFloat64(batcher.breaker.openedMetric))
+	assert.Equal(t, 0.0, testutil.ToFloat64(batcher.breaker.stateMetric))
+}
@@ -0,0 +1,61 @@
+package pipeline
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	insaneJSON "github.com/vitkovskii/insane-json"
+)
+
+const DeadLetterField = "dead_letter"
+
+// DeadLetter passes the events which aren't delivered by the pipeline output after all the retries to the other output.
+// The copies of the events are passed since the original ones are committed by the batcher and reused.
+type DeadLetter struct {
+	output OutputPlugin
+
+	eventsMetric prometheus.Counter
+}
+
+// NewDeadLetter wraps the started output.
+func NewDeadLetter(output OutputPlugin, eventsMetric prometheus.Counter) *DeadLetter {
+	return &DeadLetter{
+		output:       output,
+		eventsMetric: eventsMetric,
+	}
+}
+
+// Send passes the copies of the events with the `dead_letter` field containing
+// the type of the output, the error and the number of attempts.
+func (d *DeadLetter) Send(outputType, errText string, attempts int, events []*Event) {
+	for _, event := range events {
+		clone := event.Clone()
+		// the clone of the event without the root has no root too
+		if clone.Root == nil {
+			clone.Root = insaneJSON.Spawn()
+		}
+		if clone.Root.Node == nil {
+			_ = clone.Root.DecodeString("{}")
+		}
+
+		meta := clone.Root.AddFieldNoAlloc(clone.Root, DeadLetterField).MutateToObject()
+		meta.AddFieldNoAlloc(clone.Root, "output").MutateToString(outputType)
+		meta.AddFieldNoAlloc(clone.Root, "error").MutateToString(errText)
+		meta.AddFieldNoAlloc(clone.Root, "attempts").MutateToInt(attempts)
+
+		d.output.Out(clone)
+	}
+	d.eventsMetric.Add(float64(len(events)))
+}
+
+// deadLetterController doesn't pass the commits of the dead letter and the error outputs to the input
+// since the copies of the events and the error events don't belong to it, the copies are released instead
+type deadLetterController struct {
+	controller OutputPluginController
+}
+
+func (c deadLetterController) Commit(event *Event) {
+	event.ReleaseClone()
+}
+
+func (c deadLetterController) Error(err string) {
+	c.controller.Error(err)
+}
@@ -0,0 +1,155 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ozontech/file.d/metric"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	insaneJSON "github.com/vitkovskii/insane-json"
+	"go.uber.org/atomic"
+)
+
+type deadLetterOutput struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (o *deadLetterOutput) Start(_ AnyConfig, _ *OutputPluginParams) {}
+
+func (o *deadLetterOutput) Stop() {}
+
+func (o *deadLetterOutput) Out(event *Event) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.events = append(o.events, event.Root.EncodeToString())
+}
+
+func TestBatcherDeadLetter(t *testing.T) {
+	tests := []struct {
+		name           string
+		out            BatcherOutFn
+		wantDeadLetter []string
+	}{
+		{
+			name: "batch_failed",
+			out: func(_ *WorkerData, _ *Batch) error {
+				return errors.New("output is unavailable")
+			},
+			wantDeadLetter: []string{
+				`{"n":0,"dead_letter":{"output":"devnull","error":"output is unavailable","attempts":3}}`,
+				`{"n":1,"dead_letter":{"output":"devnull","error":"output is unavailable","attempts":3}}`,
+			},
+		},
+		{
+			name: "batch_failed_with_marks",
+			out: func(_ *WorkerData, batch *Batch) error {
+				batch.ForEach(func(e *Event) bool {
+					if e.SeqID == 0 {
+						batch.MarkFailed(e)
+					} else {
+						batch.MarkRejected(e, "event is invalid")
+					}
+					return true
+				})
+				return errors.New("output is unavailable")
+			},
+			// the events are passed to the dead letter once with the batch
+			wantDeadLetter: []string{
+				`{"n":0,"dead_letter":{"output":"devnull","error":"output is unavailable","attempts":3}}`,
+				`{"n":1,"dead_letter":{"output":"devnull","error":"output is unavailable","attempts":3}}`,
+			},
+		},
+		{
+			name: "events_failed",
+			out: func(_ *WorkerData, batch *Batch) error {
+				batch.ForEach(func(e *Event) bool {
+					if e.SeqID == 1 {
+						batch.MarkFailed(e)
+					}
+					return true
+				})
+				return nil
+			},
+			wantDeadLetter: []string{
+				`{"n":1,"dead_letter":{"output":"devnull","error":"events are marked as failed by the output","attempts":3}}`,
+			},
+		},
+		{
+			name: "events_rejected",
+			out: func(_ *WorkerData, batch *Batch) error {
+				batch.ForEach(func(e *Event) bool {
+					if e.SeqID == 0 {
+						batch.MarkRejected(e, "event is invalid")
+					}
+					return true
+				})
+				return nil
+			},
+			wantDeadLetter: []string{
+				`{"n":0,"dead_letter":{"output":"devnull","error":"event is invalid","attempts":1}}`,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			commitsCount := atomic.Int32{}
+			tail := &batcherTail{commit: func(_ *Event) {
+				commitsCount.Inc()
+			}}
+
+			output := &deadLetterOutput{}
+			ctl := metric.New("", prometheus.NewRegistry())
+			deadLetter := NewDeadLetter(output, ctl.RegisterCounter("dead_letter_events_total", "").WithLabelValues())
+
+			batcher := NewBatcher(BatcherOptions{
+				PipelineName:   "test",
+				OutputType:     "devnull",
+				OutFn:          tt.out,
+				Controller:     tail,
+				Workers:        1,
+				BatchSizeCount: 2,
+				FlushTimeout:   time.Hour,
+				MetricCtl:      ctl,
+				Retry: BatcherRetry{
+					MaxRetries:     2,
+					InitialBackoff: time.Millisecond,
+				},
+				FatalOnFailedInsert: true,
+				DeadLetter:          deadLetter,
+			})
+			batcher.Start(context.Background())
+
+			for i := 0; i < 2; i++ {
+				root, err := insaneJSON.DecodeString(`{"n":` + strconv.Itoa(i) + `}`)
+				require.NoError(t, err)
+				defer insaneJSON.Release(root)
+				batcher.Add(&Event{SeqID: uint64(i), Root: root})
+			}
+			batcher.Stop()
+
+			assert.Equal(t, int32(2), commitsCount.Load(), "wrong commits count")
+			assert.Equal(t, tt.wantDeadLetter, output.events)
+			assert.Equal(t, float64(len(tt.wantDeadLetter)), testutil.ToFloat64(deadLetter.eventsMetric))
+		})
+	}
+}
+
+func TestDeadLetterSendNoRoot(t *testing.T) {
+	output := &deadLetterOutput{}
+	ctl := metric.New("", prometheus.NewRegistry())
+	deadLetter := NewDeadLetter(output, ctl.RegisterCounter("dead_letter_events_total", "").WithLabelValues())
+
+	deadLetter.Send("devnull", "output is unavailable", 1, []*Event{{SeqID: 1}})
+
+	assert.Equal(t, []string{`{"dead_letter":{"output":"devnull","error":"output is unavailable","attempts":1}}`}, output.events)
+	assert.Equal(t, float64(1), testutil.ToFloat64(deadLetter.eventsMetric))
+}
@@ -0,0 +1,129 @@
+package pipeline
+
+import (
+	"time"
+	"unicode/utf8"
+
+	"github.com/prometheus/client_golang/prometheus"
+	insaneJSON "github.com/vitkovskii/insane-json"
+)
+
+const (
+	DefaultErrorEventsPerSec    = 10
+	DefaultErrorEventSampleSize = 1024
+)
+
+// ErrorEventsSettings are the settings of the structured events about the plugin errors.
+type ErrorEventsSettings struct {
+	// PerSec limits the number of the error events per second, so the errors of the error output
+	// passed back to the pipeline don't make a feedback loop
+	PerSec int
+	// SampleSize is the max size of the offending event in bytes put to the error event, zero means the event isn't put
+	SampleSize int
+}
+
+// ErrorEvents passes the structured events about the errors of the actions and the outputs to the error output,
+// so there is a searchable record of the ingestion problems.
+// The error output gets the new events which don't belong to the input, so its commits are ignored.
+type ErrorEvents struct {
+	output       OutputPlugin
+	pipelineName string
+	sampleSize   int
+	rate         *rateLimiter
+
+	eventsMetric  prometheus.Counter
+	droppedMetric prometheus.Counter
+}
+
+// NewErrorEvents wraps the started output.
+func NewErrorEvents(output OutputPlugin, pipelineName string, settings ErrorEventsSettings, eventsMetric, droppedMetric prometheus.Counter) *ErrorEvents {
+	return &ErrorEvents{
+		output:        output,
+		pipelineName:  pipelineName,
+		sampleSize:    settings.SampleSize,
+		rate:          newRateLimiter(settings.PerSec),
+		eventsMetric:  eventsMetric,
+		droppedMetric: droppedMetric,
+	}
+}
+
+// Reporter returns the reporter of the plugin errors, it returns nil if the error output isn't set
+// or the plugin has the error events disabled.
+func (e *ErrorEvents) Reporter(kind PluginKind, info *PluginStaticInfo) *ErrorReporter {
+	if e == nil || info.ErrorEventsDisabled {
+		return nil
+	}
+	return &ErrorReporter{events: e, kind: kind, pluginType: info.Type}
+}
+
+// ErrorReporter passes the errors of the plugin to the error output of the pipeline.
+// The nil reporter ignores the errors, so the plugins call it without checks.
+type ErrorReporter struct {
+	events     *ErrorEvents
+	kind       PluginKind
+	pluginType string
+	// pluginName is the name of the output of the router
+	pluginName string
+}
+
+// Named returns the reporter of the named output of the router.
+func (r *ErrorReporter) Named(name string, info *PluginStaticInfo) *ErrorReporter {
+	if r == nil || info.ErrorEventsDisabled {
+		return nil
+	}
+	return &ErrorReporter{events: r.events, kind: r.kind, pluginType: info.Type, pluginName: name}
+}
+
+// Report passes the error event containing the pipeline, the plugin, the error and the sample of the event.
+// The event may be nil if the error isn't related to the event.
+func (r *ErrorReporter) Report(errText string, event *Event) {
+	if r == nil {
+		return
+	}
+
+	e := r.events
+	if !e.rate.allow() {
+		e.droppedMetric.Inc()
+		return
+	}
+
+	errorEvent := &Event{
+		Root:      insaneJSON.Spawn(),
+		Buf:       make([]byte, 0, e.sampleSize),
+		createdAt: time.Now(),
+	}
+	_ = errorEvent.Root.DecodeString("{}")
+	root := errorEvent.Root
+	root.AddFieldNoAlloc(root, "pipeline").MutateToString(e.pipelineName)
+	root.AddFieldNoAlloc(root, "plugin_kind").MutateToString(string(r.kind))
+	root.AddFieldNoAlloc(root, "plugin_type").MutateToString(r.pluginType)
+	if r.pluginName != "" {
+		root.AddFieldNoAlloc(root, "plugin_name").MutateToString(r.pluginName)
+	}
+	root.AddFieldNoAlloc(root, "error").MutateToString(errText)
+	root.AddFieldNoAlloc(root, "time").MutateToString(errorEvent.createdAt.Format(time.RFC3339Nano))
+
+	if event != nil {
+		root.AddFieldNoAlloc(root, "source_name").MutateToString(event.SourceName)
+		if e.sampleSize > 0 && event.Root != nil && event.Root.Node != nil {
+			root.AddFieldNoAlloc(root, "event").MutateToBytesCopy(root, e.sample(event))
+		}
+	}
+
+	e.output.Out(errorEvent)
+	e.eventsMetric.Inc()
+}
+
+// sample returns the encoded event truncated to the sample size at the rune boundary
+func (e *ErrorEvents) sample(event *Event) []byte {
+	buf := event.Root.Encode(make([]byte, 0, e.sampleSize))
+	if len(buf) <= e.sampleSize {
+		return buf
+	}
+
+	n := e.sampleSize
+	for n > 0 && !utf8.RuneStart(buf[n]) {
+		n--
+	}
+	return buf[:n]
+}
@@ -0,0 +1,73 @@
+package pipeline
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ozontech/file.d/metric"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	insaneJSON "github.com/vitkovskii/insane-json"
+)
+
+func newTestErrorEvents(settings ErrorEventsSettings) (*ErrorEvents, *deadLetterOutput) {
+	output := &deadLetterOutput{}
+	ctl := metric.New("test", prometheus.NewRegistry())
+	errorEvents := NewErrorEvents(output, "test", settings,
+		ctl.RegisterCounter("error_events_total", "").WithLabelValues(),
+		ctl.RegisterCounter("error_events_dropped_total", "").WithLabelValues(),
+	)
+	errorEvents.rate.now = func() time.Time {
+		return time.Unix(100, 0)
+	}
+	return errorEvents, output
+}
+
+func TestErrorReporter(t *testing.T) {
+	errorEvents, output := newTestErrorEvents(ErrorEventsSettings{PerSec: 2, SampleSize: 12})
+
+	root, err := insaneJSON.DecodeString(`{"message":"привет"}`)
+	require.NoError(t, err)
+	defer insaneJSON.Release(root)
+	event := &Event{Root: root, SourceName: "app.log"}
+
+	reporter := errorEvents.Reporter(PluginKindAction, &PluginStaticInfo{Type: "json_decode"})
+	reporter.Report("can't decode json", event)
+	errorEvents.Reporter(PluginKindOutput, &PluginStaticInfo{Type: "router"}).
+		Named("archive", &PluginStaticInfo{Type: "s3"}).
+		Report("bucket is unavailable", nil)
+	reporter.Report("rate limited", event)
+
+	require.Len(t, output.events, 2)
+	errorEvent, err := insaneJSON.DecodeString(output.events[0])
+	require.NoError(t, err)
+	defer insaneJSON.Release(errorEvent)
+	assert.Equal(t, "test", errorEvent.Dig("pipeline").AsString())
+	assert.Equal(t, "action", errorEvent.Dig("plugin_kind").AsString())
+	assert.Equal(t, "json_decode", errorEvent.Dig("plugin_type").AsString())
+	assert.Equal(t, "can't decode json", errorEvent.Dig("error").AsString())
+	assert.Equal(t, "app.log", errorEvent.Dig("source_name").AsString())
+	assert.Equal(t, `{"message":"`, errorEvent.Dig("event").AsString(), "sample isn't truncated at the rune boundary")
+
+	assert.Contains(t, output.events[1], `"plugin_type":"s3","plugin_name":"archive","error":"bucket is unavailable"`)
+	assert.NotContains(t, output.events[1], `"event"`)
+
+	assert.Equal(t, 2.0, testutil.ToFloat64(errorEvents.eventsMetric))
+	assert.Equal(t, 1.0, testutil.ToFloat64(errorEvents.droppedMetric))
+}
+
+func TestErrorReporterDisabled(t *testing.T) {
+	var errorEvents *ErrorEvents
+	assert.Nil(t, errorEvents.Reporter(PluginKindAction, &PluginStaticInfo{Type: "json_decode"}), "reporter without the error output")
+
+	errorEvents, output := newTestErrorEvents(ErrorEventsSettings{PerSec: 10})
+	reporter := errorEvents.Reporter(PluginKindAction, &PluginStaticInfo{Type: "json_decode", ErrorEventsDisabled: true})
+	assert.Nil(t, reporter)
+
+	// nil reporter ignores the errors
+	reporter.Report("error", nil)
+	assert.Nil(t, reporter.Named("archive", &PluginStaticInfo{Type: "s3"}))
+	assert.Empty(t, output.events)
+}
@@ -24,10 +24,24 @@ type Event struct {
 	streamName StreamName
 	Size       int // last known event size, it may not be actual
 
+	// createdAt is the time the event is received by the pipeline, it's zero for events made by plugins
+	createdAt time.Time
+
 	action int
 	next   *Event
 	stream *stream
 
+	// flushMarker forces the output batch with this event to be flushed immediately
+	flushMarker bool
+
+	// isChild is set for events spawned by actions, they aren't taken from the pool and aren't committed
+	isChild bool
+	// isChildParent is set for the event children are spawned from,
+	// it isn't sent by the output, but it's committed in order after the children
+	isChildParent bool
+	// isClone is set for the events made by Clone, they're returned to the clone pool by ReleaseClone
+	isClone bool
+
 	// some debugging shit
 	stage eventStage
 }
@@ -118,6 +132,8 @@ func (e *Event) reset(avgEventSize int) {
 	e.action = 0
 	e.stream = nil
 	e.kind = EventKindRegular
+	e.flushMarker = false
+	e.isChildParent = false
 }
 
 func (e *Event) StreamNameBytes() []byte {
@@ -148,6 +164,127 @@ func (e *Event) IsTimeoutKind() bool {
 	return e.kind == EventKindTimeout
 }
 
+// SetFlushMarker makes the batcher flush the batch right after this event is added.
+// The event is committed in sequence with others, but it isn't passed to the output
+// unless the BatcherOptions.ForwardFlushMarkers is set.
+func (e *Event) SetFlushMarker() {
+	e.flushMarker = true
+}
+
+func (e *Event) IsFlushMarker() bool {
+	return e.flushMarker
+}
+
+// IsChild returns true for the event spawned by the action from the parent one.
+func (e *Event) IsChild() bool {
+	return e.isChild
+}
+
+// IsChildParent returns true for the event children are spawned from.
+// Outputs must not send it, but they must commit it as other events.
+func (e *Event) IsChildParent() bool {
+	return e.isChildParent
+}
+
+func (e *Event) isExpired(now time.Time, maxAge time.Duration) bool {
+	return !e.createdAt.IsZero() && now.Sub(e.createdAt) > maxAge
+}
+
+// clonePool holds the events of the clones, unlike the eventPool it isn't bounded by the pipeline capacity
+// since the clones are held by the outputs along with the original events
+var clonePool = sync.Pool{
+	New: func() any {
+		return newEvent()
+	},
+}
+
+// Clone returns the deep copy of the event, so changing the clone doesn't affect the original.
+// The clone is taken from the clone pool and isn't tracked by the stream of the original event,
+// it belongs to the caller: the original event is still the one to be committed,
+// so the clone must not be passed to the Commit of the input.
+// The caller returns the clone to the pool by ReleaseClone when it isn't used anymore,
+// e.g. after the output commits it. The clone which isn't released is collected by GC.
+func (e *Event) Clone() *Event {
+	if e.Root == nil {
+		return &Event{
+			kind:        e.kind,
+			SeqID:       e.SeqID,
+			Offset:      e.Offset,
+			SourceID:    e.SourceID,
+			SourceName:  e.SourceName,
+			streamName:  e.streamName,
+			flushMarker: e.flushMarker,
+			createdAt:   e.createdAt,
+			stage:       e.stage,
+		}
+	}
+
+	clone := clonePool.Get().(*Event)
+	clone.reset(DefaultAvgInputEventSize)
+	clone.kind = e.kind
+	clone.SeqID = e.SeqID
+	clone.Offset = e.Offset
+	clone.SourceID = e.SourceID
+	clone.SourceName = e.SourceName
+	clone.streamName = e.streamName
+	clone.flushMarker = e.flushMarker
+	clone.createdAt = e.createdAt
+	clone.stage = e.stage
+	clone.isClone = true
+
+	if e.Root.Node == nil {
+		clone.Size = 0
+		_ = clone.Root.DecodeString("{}")
+		return clone
+	}
+
+	// root decoder copies json into its own buffer, so Buf can be reused
+	clone.Buf = e.Root.Encode(clone.Buf)
+	clone.Size = len(clone.Buf)
+	if err := clone.Root.DecodeBytes(clone.Buf); err != nil {
+		logger.Panicf("can't decode encoded event: %s", err.Error())
+	}
+	clone.Buf = clone.Buf[:0]
+
+	return clone
+}
+
+// ReleaseClone returns the clone to the clone pool, so it must not be used after that.
+// It does nothing if the event isn't the clone.
+func (e *Event) ReleaseClone() {
+	if !e.isClone {
+		return
+	}
+	e.isClone = false
+	clonePool.Put(e)
+}
+
+// spawnChild returns the event made of the copy of the node, it has the source and offset of the parent
+func (e *Event) spawnChild(node *insaneJSON.Node) *Event {
+	child := &Event{
+		Root:       insaneJSON.Spawn(),
+		SeqID:      e.SeqID,
+		Offset:     e.Offset,
+		SourceID:   e.SourceID,
+		SourceName: e.SourceName,
+		streamName: e.streamName,
+		stream:     e.stream,
+		createdAt:  e.createdAt,
+		isChild:    true,
+		stage:      eventStageProcessor,
+	}
+
+	// root decoder copies json into its own buffer, so Buf can be reused
+	child.Buf = node.Encode(make([]byte, 0, 1024))
+	child.Size = len(child.Buf)
+	if err := child.Root.DecodeBytes(child.Buf); err != nil {
+		logger.Panicf("can't decode encoded node: %s", err.Error())
+	}
+	child.Buf = child.Buf[:0]
+
+	return child
+}
+
 func (e *Event) parseJSON(json []byte) error {
 	return e.Root.DecodeBytes(json)
 }
@@ -164,6 +301,19 @@ func (e *Event) Encode(outBuf []byte) ([]byte, int) {
 	return outBuf, l
 }
 
+// RecalcSize updates the Size by the current JSON of the event, since actions may change it.
+func (e *Event) RecalcSize() {
+	if e.Root == nil || e.Root.Node == nil {
+		return
+	}
+
+	// Buf is used as scratch space only, its content is restored
+	l := len(e.Buf)
+	e.Buf = e.Root.Encode(e.Buf)
+	e.Size = len(e.Buf) - l
+	e.Buf = e.Buf[:l]
+}
+
 func (e *Event) stageStr() string {
 	switch e.stage {
 	case eventStagePool:
@@ -200,6 +350,11 @@ type eventPool struct {
 	free1        []atomic.Bool
 	free2        []atomic.Bool
 
+	// maxInUseEvents is the high-water mark of the inUseEvents since the last resetMaxInUseEvents
+	maxInUseEvents atomic.Int64
+	// waits is the count of get calls which have to wait for the event to be returned to the pool
+	waits atomic.Int64
+
 	getMu   *sync.Mutex
 	getCond *sync.Cond
 }
@@ -228,6 +383,7 @@ const maxTries = 3
 func (p *eventPool) get() *Event {
 	x := (p.getCounter.Inc() - 1) % int64(p.capacity)
 	var tries int
+	waited := false
 	for {
 		if x < p.backCounter.Load() {
 			// fast path
@@ -247,6 +403,10 @@ func (p *eventPool) get() *Event {
 			runtime.Gosched()
 		} else {
 			// slowest path
+			if !waited {
+				waited = true
+				p.waits.Inc()
+			}
 			p.getMu.Lock()
 			p.getCond.Wait()
 			p.getMu.Unlock()
@@ -256,11 +416,25 @@ func (p *eventPool) get() *Event {
 	event := p.events[x]
 	p.events[x] = nil
 	p.free2[x].Store(false)
-	p.inUseEvents.Inc()
+	p.updateMaxInUseEvents(p.inUseEvents.Inc())
 	event.reset(p.avgEventSize)
 	return event
 }
 
+func (p *eventPool) updateMaxInUseEvents(inUse int64) {
+	for {
+		maxInUse := p.maxInUseEvents.Load()
+		if inUse <= maxInUse || p.maxInUseEvents.CAS(maxInUse, inUse) {
+			return
+		}
+	}
+}
+
+// resetMaxInUseEvents returns the high-water mark and starts the new one from the current in use events
+func (p *eventPool) resetMaxInUseEvents() int64 {
+	return p.maxInUseEvents.Swap(p.inUseEvents.Load())
+}
+
 func (p *eventPool) back(event *Event) {
 	event.stage = eventStagePool
 	x := (p.backCounter.Inc() - 1) % int64(p.capacity)
@@ -4,6 +4,7 @@ import (
 	"runtime"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -18,6 +19,39 @@ func TestEventPoolDump(t *testing.T) {
 	})
 }
 
+func TestEventPoolStats(t *testing.T) {
+	eventPool := newEventPool(2, DefaultAvgInputEventSize)
+	e1 := eventPool.get()
+	e2 := eventPool.get()
+	require.Equal(t, int64(0), eventPool.waits.Load())
+
+	got := make(chan *Event)
+	go func() {
+		got <- eventPool.get()
+	}()
+
+	require.Eventually(t, func() bool {
+		return eventPool.waits.Load() == 1
+	}, time.Second, time.Millisecond, "get should wait for the exhausted pool")
+
+	eventPool.back(e1)
+	var e3 *Event
+	for e3 == nil {
+		select {
+		case e3 = <-got:
+		case <-time.After(time.Millisecond * 10):
+			// wake up the getter if it wasn't waiting yet when the event was returned
+			eventPool.getCond.Broadcast()
+		}
+	}
+	require.Equal(t, int64(1), eventPool.waits.Load())
+
+	eventPool.back(e2)
+	eventPool.back(e3)
+	require.Equal(t, int64(2), eventPool.resetMaxInUseEvents())
+	require.Equal(t, int64(0), eventPool.resetMaxInUseEvents())
+}
+
 func BenchmarkEventPoolOneGoroutine(b *testing.B) {
 	const capacity = 32
 
@@ -67,3 +101,57 @@ func BenchmarkEventPoolSlowestPath(b *testing.B) {
 		wg.Wait()
 	}
 }
+
+func TestEventClone(t *testing.T) {
+	event := newEvent()
+	require.NoError(t, event.parseJSON([]byte(`{"message":"hello","level":"info"}`)))
+	event.SeqID = 10
+	event.SourceName = "source"
+	event.streamName = "stdout"
+
+	clone := event.Clone()
+	require.Equal(t, event.Root.EncodeToString(), clone.Root.EncodeToString())
+	require.Equal(t, uint64(10), clone.SeqID)
+	require.Equal(t, "source", clone.SourceName)
+	require.Equal(t, StreamName("stdout"), clone.streamName)
+	require.Equal(t, len(`{"message":"hello","level":"info"}`), clone.Size)
+
+	clone.Root.Dig("message").MutateToString("bye")
+	clone.Root.AddFieldNoAlloc(clone.Root, "new").MutateToString("field")
+	require.Equal(t, `{"message":"hello","level":"info"}`, event.Root.EncodeToString())
+
+	// the original root is reused for the next event
+	require.NoError(t, event.parseJSON([]byte(`{"message":"next"}`)))
+	require.Equal(t, `{"message":"bye","level":"info","new":"field"}`, clone.Root.EncodeToString())
+}
+
+func TestEventReleaseClone(t *testing.T) {
+	event := newEvent()
+	require.NoError(t, event.parseJSON([]byte(`{"message":"hello"}`)))
+
+	clone := event.Clone()
+	require.True(t, clone.isClone)
+	clone.ReleaseClone()
+	require.False(t, clone.isClone)
+
+	// the original event isn't the clone, so it isn't put to the clone pool
+	event.ReleaseClone()
+	require.Equal(t, `{"message":"hello"}`, event.Root.EncodeToString())
+
+	clone = event.Clone()
+	require.Equal(t, `{"message":"hello"}`, clone.Root.EncodeToString())
+	require.Equal(t, 0, clone.action)
+	require.Nil(t, clone.stream)
+}
+
+func BenchmarkEventClone(b *testing.B) {
+	event := newEvent()
+	require.NoError(b, event.parseJSON([]byte(`{"message":"hello","level":"info","ts":"2024-05-01T10:15:00Z","service":"api"}`)))
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		clone := event.Clone()
+		clone.ReleaseClone()
+	}
+}
@@ -0,0 +1,85 @@
+package pipeline
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// HealthSettings are the thresholds of the pipeline readiness and liveness, zero values disable the checks.
+type HealthSettings struct {
+	// BackpressureTimeout makes the pipeline not ready if all the in-flight batches of the output are taken longer than it
+	BackpressureTimeout time.Duration
+	// FlushTimeout makes the pipeline not ready if the output has the batches to send and doesn't send any longer than it
+	FlushTimeout time.Duration
+	// NotReadyOnOpenCircuit makes the pipeline not ready while the circuit breaker of the output is open
+	NotReadyOnOpenCircuit bool
+	// DeadlockTimeout makes the pipeline not live if the output has the batches to send
+	// and neither calls OutFn nor commits longer than it
+	DeadlockTimeout time.Duration
+}
+
+// Health checks the state of the batchers of the pipeline outputs.
+// The batchers are added by the outputs passing OutputPluginParams.Health to the BatcherOptions.
+type Health struct {
+	settings HealthSettings
+
+	mu       sync.Mutex
+	batchers []*Batcher
+}
+
+func newHealth(settings HealthSettings) *Health {
+	return &Health{settings: settings}
+}
+
+func (h *Health) add(b *Batcher) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.batchers = append(h.batchers, b)
+}
+
+// Ready returns the reason the pipeline can't accept the events or nil.
+func (h *Health) Ready() error {
+	s := h.settings
+	return h.check(func(b *Batcher, state batcherHealth) error {
+		output := b.opts.OutputType
+		if s.BackpressureTimeout > 0 && state.exhausted > s.BackpressureTimeout {
+			return fmt.Errorf("%s output is in backpressure for %s: all %d batches are in flight", output, state.exhausted, b.maxInFlight)
+		}
+		if s.FlushTimeout > 0 && state.noSuccess > s.FlushTimeout {
+			return fmt.Errorf("%s output doesn't send batches for %s", output, state.noSuccess)
+		}
+		if s.NotReadyOnOpenCircuit && state.circuitOpen {
+			return fmt.Errorf("circuit breaker of %s output is open", output)
+		}
+		return nil
+	})
+}
+
+// Live returns the reason the pipeline is stuck or nil.
+func (h *Health) Live() error {
+	s := h.settings
+	return h.check(func(b *Batcher, state batcherHealth) error {
+		if s.DeadlockTimeout > 0 && state.noActivity > s.DeadlockTimeout {
+			return fmt.Errorf("%s output is stuck for %s: batches are neither sent nor committed", b.opts.OutputType, state.noActivity)
+		}
+		return nil
+	})
+}
+
+func (h *Health) check(fn func(b *Batcher, state batcherHealth) error) error {
+	if h == nil {
+		return nil
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	now := time.Now()
+	for _, b := range h.batchers {
+		if err := fn(b, b.health(now)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package pipeline
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ozontech/file.d/metric"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/atomic"
+)
+
+func TestHealth(t *testing.T) {
+	health := newHealth(HealthSettings{
+		BackpressureTimeout: 20 * time.Millisecond,
+		FlushTimeout:        20 * time.Millisecond,
+		DeadlockTimeout:     20 * time.Millisecond,
+	})
+
+	release := make(chan struct{})
+	batcher := NewBatcher(BatcherOptions{
+		PipelineName: "test",
+		OutputType:   "devnull",
+		OutFn: func(_ *WorkerData, _ *Batch) error {
+			<-release
+			return nil
+		},
+		Controller:         &batcherTail{commit: func(_ *Event) {}},
+		Workers:            1,
+		MaxInFlightBatches: 1,
+		BatchSizeCount:     1,
+		FlushTimeout:       time.Hour,
+		MetricCtl:          metric.New("", prometheus.NewRegistry()),
+		Health:             health,
+	})
+	batcher.Start(context.Background())
+
+	assert.NoError(t, health.Ready())
+	assert.NoError(t, health.Live())
+
+	// the output holds the only batch
+	batcher.Add(&Event{SeqID: 0})
+	time.Sleep(50 * time.Millisecond)
+	assert.ErrorContains(t, health.Ready(), "backpressure")
+	assert.ErrorContains(t, health.Live(), "stuck")
+
+	close(release)
+	assert.Eventually(t, func() bool {
+		return health.Ready() == nil && health.Live() == nil
+	}, time.Second, 10*time.Millisecond)
+	batcher.Stop()
+}
+
+func TestHealthFlushTimeout(t *testing.T) {
+	health := newHealth(HealthSettings{
+		FlushTimeout:          20 * time.Millisecond,
+		NotReadyOnOpenCircuit: true,
+	})
+
+	failing := atomic.NewBool(true)
+	batcher := NewBatcher(BatcherOptions{
+		PipelineName: "test",
+		OutputType:   "devnull",
+		OutFn: func(_ *WorkerData, _ *Batch) error {
+			if failing.Load() {
+				return assert.AnError
+			}
+			return nil
+		},
+		Controller:     &batcherTail{commit: func(_ *Event) {}},
+		Workers:        1,
+		BatchSizeCount: 1,
+		FlushTimeout:   time.Hour,
+		MetricCtl:      metric.New("", prometheus.NewRegistry()),
+		Retry: BatcherRetry{
+			MaxRetries:     1000,
+			InitialBackoff: time.Millisecond,
+		},
+		CircuitBreaker: BatcherCircuitBreaker{
+			FailureThreshold: 1000,
+		},
+		Health: health,
+	})
+	batcher.Start(context.Background())
+
+	batcher.Add(&Event{SeqID: 0})
+	time.Sleep(50 * time.Millisecond)
+	assert.ErrorContains(t, health.Ready(), "doesn't send batches")
+
+	// the circuit breaker keeps the pipeline not ready after the flush timeout check passes
+	batcher.breaker.mu.Lock()
+	batcher.breaker.state = circuitOpen
+	batcher.breaker.mu.Unlock()
+	health.settings.FlushTimeout = 0
+	assert.ErrorContains(t, health.Ready(), "circuit breaker")
+
+	failing.Store(false)
+	batcher.Stop()
+}
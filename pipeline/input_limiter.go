@@ -0,0 +1,95 @@
+package pipeline
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/atomic"
+)
+
+const (
+	inputDropReasonSample    = "sample"
+	inputDropReasonRateLimit = "rate_limit"
+)
+
+// inputLimiter drops the events at the pipeline input before they take the events of the pool.
+// The dropped events aren't committed, so the inputs acknowledge them as the ones dropped by the antispam.
+type inputLimiter struct {
+	// sampleRate is the fraction of the events which are kept, the events are dropped evenly
+	sampleRate float64
+	sampled    atomic.Int64
+
+	rate *rateLimiter
+
+	sampledMetric     prometheus.Counter
+	rateLimitedMetric prometheus.Counter
+}
+
+// newInputLimiter returns nil if the events aren't limited, nil limiter doesn't drop the events
+func newInputLimiter(sampleRate float64, maxEventsPerSec int, droppedMetric *prometheus.CounterVec) *inputLimiter {
+	if (sampleRate <= 0 || sampleRate >= 1) && maxEventsPerSec <= 0 {
+		return nil
+	}
+
+	return &inputLimiter{
+		sampleRate:        sampleRate,
+		rate:              newRateLimiter(maxEventsPerSec),
+		sampledMetric:     droppedMetric.WithLabelValues(inputDropReasonSample),
+		rateLimitedMetric: droppedMetric.WithLabelValues(inputDropReasonRateLimit),
+	}
+}
+
+// drop returns true if the event should be dropped
+func (l *inputLimiter) drop() bool {
+	if l == nil {
+		return false
+	}
+
+	if l.sampleRate > 0 && l.sampleRate < 1 {
+		// the event is kept if the number of the kept events is increased by it
+		n := l.sampled.Inc()
+		if int64(float64(n)*l.sampleRate) == int64(float64(n-1)*l.sampleRate) {
+			l.sampledMetric.Inc()
+			return true
+		}
+	}
+
+	if !l.rate.allow() {
+		l.rateLimitedMetric.Inc()
+		return true
+	}
+
+	return false
+}
+
+// rateLimiter allows the limited number of the events per second
+type rateLimiter struct {
+	limit int64
+	// window is the unix second the count of the events belongs to
+	window atomic.Int64
+	count  atomic.Int64
+	now    func() time.Time
+}
+
+// newRateLimiter returns nil if the limit is zero, nil limiter allows all the events
+func newRateLimiter(limit int) *rateLimiter {
+	if limit <= 0 {
+		return nil
+	}
+	return &rateLimiter{
+		limit: int64(limit),
+		now:   time.Now,
+	}
+}
+
+func (l *rateLimiter) allow() bool {
+	if l == nil {
+		return true
+	}
+
+	sec := l.now().Unix()
+	if window := l.window.Load(); window != sec && l.window.CAS(window, sec) {
+		l.count.Store(0)
+	}
+	return l.count.Inc() <= l.limit
+}
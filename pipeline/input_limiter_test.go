@@ -0,0 +1,61 @@
+package pipeline
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ozontech/file.d/metric"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestInputLimiter(sampleRate float64, maxEventsPerSec int) *inputLimiter {
+	ctl := metric.New("test", prometheus.NewRegistry())
+	return newInputLimiter(sampleRate, maxEventsPerSec, ctl.RegisterCounter("input_dropped_events_total", "", "reason"))
+}
+
+func TestInputLimiterSample(t *testing.T) {
+	l := newTestInputLimiter(0.25, 0)
+
+	kept := make([]int, 0)
+	for i := 0; i < 12; i++ {
+		if !l.drop() {
+			kept = append(kept, i)
+		}
+	}
+	assert.Equal(t, []int{3, 7, 11}, kept, "events aren't dropped evenly")
+	assert.Equal(t, 9.0, testutil.ToFloat64(l.sampledMetric))
+}
+
+func TestInputLimiterRate(t *testing.T) {
+	l := newTestInputLimiter(1, 3)
+	now := time.Unix(100, 0)
+	l.rate.now = func() time.Time {
+		return now
+	}
+
+	count := func(n int) int {
+		kept := 0
+		for i := 0; i < n; i++ {
+			if !l.drop() {
+				kept++
+			}
+		}
+		return kept
+	}
+
+	assert.Equal(t, 3, count(5))
+	now = now.Add(500 * time.Millisecond)
+	assert.Equal(t, 0, count(5), "events over the limit are kept in the same second")
+	now = now.Add(500 * time.Millisecond)
+	assert.Equal(t, 3, count(5), "limit isn't reset in the next second")
+	assert.Equal(t, 9.0, testutil.ToFloat64(l.rateLimitedMetric))
+}
+
+func TestInputLimiterDisabled(t *testing.T) {
+	l := newTestInputLimiter(1, 0)
+	require.Nil(t, l)
+	assert.False(t, l.drop())
+}
@@ -0,0 +1,407 @@
+package pipeline
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/ozontech/file.d/cfg"
+)
+
+// MatchExpr is the compiled boolean expression over the event fields, e.g. `(status>=500 AND env=prod) OR level=fatal`.
+//
+// The expression consists of:
+//   - the comparisons `field=value`, `field!=value`, `field>number`, `field>=number`, `field<number`, `field<=number`,
+//     `field~regexp` and `field!~regexp`
+//   - the existence checks `exists(field)`
+//   - the `AND`, `OR` and `NOT` operators and the parentheses, `AND` takes precedence over `OR`
+//
+// The fields are the field selectors, e.g. `k8s.pod`. The values are the words, the quoted strings, e.g. `"internal error"`,
+// or the regexps in slashes, e.g. `/^5\d\d$/`. The comparisons of the missing fields are false except `!=` and `!~`.
+// The expression is compiled once, so the events are matched without allocations.
+type MatchExpr struct {
+	expr string
+	root matchNode
+}
+
+// ParseMatchExpr compiles the expression.
+func ParseMatchExpr(expr string) (*MatchExpr, error) {
+	p := &matchExprParser{s: expr}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpaces()
+	if p.pos != len(p.s) {
+		return nil, p.errorf("unexpected %q", p.s[p.pos:])
+	}
+	return &MatchExpr{expr: expr, root: root}, nil
+}
+
+// Match checks the event by the expression, the nil expression matches any event.
+func (e *MatchExpr) Match(event *Event) bool {
+	if e == nil {
+		return true
+	}
+	return e.root.match(event)
+}
+
+func (e *MatchExpr) String() string {
+	return e.expr
+}
+
+type matchNode interface {
+	match(event *Event) bool
+}
+
+type andNode struct {
+	left, right matchNode
+}
+
+func (n *andNode) match(event *Event) bool {
+	return n.left.match(event) && n.right.match(event)
+}
+
+type orNode struct {
+	left, right matchNode
+}
+
+func (n *orNode) match(event *Event) bool {
+	return n.left.match(event) || n.right.match(event)
+}
+
+type notNode struct {
+	node matchNode
+}
+
+func (n *notNode) match(event *Event) bool {
+	return !n.node.match(event)
+}
+
+type existsNode struct {
+	field []string
+}
+
+func (n *existsNode) match(event *Event) bool {
+	return event.Root.Dig(n.field...) != nil
+}
+
+type compareOp int
+
+const (
+	opEqual compareOp = iota
+	opNotEqual
+	opGreater
+	opGreaterOrEqual
+	opLess
+	opLessOrEqual
+	opRegexp
+	opNotRegexp
+)
+
+// compareOps are ordered so the two-symbol operators are checked first
+var compareOps = []struct {
+	symbol string
+	op     compareOp
+}{
+	{"!=", opNotEqual},
+	{">=", opGreaterOrEqual},
+	{"<=", opLessOrEqual},
+	{"!~", opNotRegexp},
+	{"=", opEqual},
+	{">", opGreater},
+	{"<", opLess},
+	{"~", opRegexp},
+}
+
+type compareNode struct {
+	field  []string
+	op     compareOp
+	value  string
+	number float64
+	re     *regexp.Regexp
+}
+
+func (n *compareNode) match(event *Event) bool {
+	node := event.Root.Dig(n.field...)
+	if node == nil {
+		return n.op == opNotEqual || n.op == opNotRegexp
+	}
+	value := node.AsString()
+
+	switch n.op {
+	case opEqual:
+		return value == n.value
+	case opNotEqual:
+		return value != n.value
+	case opRegexp:
+		return n.re.MatchString(value)
+	case opNotRegexp:
+		return !n.re.MatchString(value)
+	}
+
+	number, ok := parseNumber(value)
+	if !ok {
+		return false
+	}
+	switch n.op {
+	case opGreater:
+		return number > n.number
+	case opGreaterOrEqual:
+		return number >= n.number
+	case opLess:
+		return number < n.number
+	default:
+		return number <= n.number
+	}
+}
+
+// parseNumber parses the number, the strings which can't be the numbers are rejected before parsing
+// since the parsing error is allocated
+func parseNumber(s string) (float64, bool) {
+	digits := false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c >= '0' && c <= '9':
+			digits = true
+		case c == '-' || c == '+' || c == '.' || c == 'e' || c == 'E':
+		default:
+			return 0, false
+		}
+	}
+	if !digits {
+		return 0, false
+	}
+
+	number, err := strconv.ParseFloat(s, 64)
+	return number, err == nil
+}
+
+type matchExprParser struct {
+	s   string
+	pos int
+}
+
+func (p *matchExprParser) errorf(format string, args ...any) error {
+	return fmt.Errorf("wrong match expression at position %d: %s", p.pos, fmt.Sprintf(format, args...))
+}
+
+func (p *matchExprParser) skipSpaces() {
+	for p.pos < len(p.s) && isSpace(p.s[p.pos]) {
+		p.pos++
+	}
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+// keyword consumes the case-insensitive keyword followed by the space, the parenthesis or the end
+func (p *matchExprParser) keyword(keyword string) bool {
+	p.skipSpaces()
+	end := p.pos + len(keyword)
+	if end > len(p.s) || !strings.EqualFold(p.s[p.pos:end], keyword) {
+		return false
+	}
+	if end < len(p.s) && !isSpace(p.s[end]) && p.s[end] != '(' {
+		return false
+	}
+	p.pos = end
+	return true
+}
+
+func (p *matchExprParser) expect(c byte) error {
+	p.skipSpaces()
+	if p.pos == len(p.s) || p.s[p.pos] != c {
+		return p.errorf("%q is expected", c)
+	}
+	p.pos++
+	return nil
+}
+
+func (p *matchExprParser) parseOr() (matchNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("or") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &orNode{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *matchExprParser) parseAnd() (matchNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("and") {
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &andNode{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *matchExprParser) parseUnary() (matchNode, error) {
+	p.skipSpaces()
+	if p.pos < len(p.s) && p.s[p.pos] == '(' {
+		p.pos++
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		return node, p.expect(')')
+	}
+
+	if p.keyword("not") {
+		node, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &notNode{node: node}, nil
+	}
+
+	if p.keyword("exists") {
+		if err := p.expect('('); err != nil {
+			return nil, err
+		}
+		field, err := p.parseField()
+		if err != nil {
+			return nil, err
+		}
+		return &existsNode{field: field}, p.expect(')')
+	}
+
+	return p.parseCompare()
+}
+
+func (p *matchExprParser) parseField() ([]string, error) {
+	p.skipSpaces()
+	var field string
+	if p.pos < len(p.s) && p.s[p.pos] == '"' {
+		var err error
+		if field, err = p.parseQuoted(); err != nil {
+			return nil, err
+		}
+	} else {
+		begin := p.pos
+		for p.pos < len(p.s) && !isSpace(p.s[p.pos]) && !strings.ContainsRune("=!<>~()", rune(p.s[p.pos])) {
+			p.pos++
+		}
+		field = p.s[begin:p.pos]
+	}
+
+	if field == "" {
+		return nil, p.errorf("field is expected")
+	}
+	return cfg.ParseFieldSelector(field), nil
+}
+
+func (p *matchExprParser) parseCompare() (matchNode, error) {
+	field, err := p.parseField()
+	if err != nil {
+		return nil, err
+	}
+
+	p.skipSpaces()
+	node := &compareNode{field: field, op: -1}
+	for _, op := range compareOps {
+		if strings.HasPrefix(p.s[p.pos:], op.symbol) {
+			node.op = op.op
+			p.pos += len(op.symbol)
+			break
+		}
+	}
+	if node.op < 0 {
+		return nil, p.errorf("comparison operator is expected")
+	}
+
+	p.skipSpaces()
+	isRegexp := false
+	switch {
+	case p.pos < len(p.s) && p.s[p.pos] == '"':
+		node.value, err = p.parseQuoted()
+	case p.pos < len(p.s) && p.s[p.pos] == '/':
+		node.value, err = p.parseRegexp()
+		isRegexp = true
+	default:
+		begin := p.pos
+		for p.pos < len(p.s) && !isSpace(p.s[p.pos]) && p.s[p.pos] != ')' {
+			p.pos++
+		}
+		node.value = p.s[begin:p.pos]
+		if node.value == "" {
+			err = p.errorf("value is expected")
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch node.op {
+	case opRegexp, opNotRegexp:
+		if node.re, err = regexp.Compile(node.value); err != nil {
+			return nil, p.errorf("can't compile regexp %q: %s", node.value, err.Error())
+		}
+	case opEqual, opNotEqual:
+		if isRegexp {
+			return nil, p.errorf("regexp can be used only with ~ and !~")
+		}
+	default:
+		number, ok := parseNumber(node.value)
+		if !ok || isRegexp {
+			return nil, p.errorf("number is expected instead of %q", node.value)
+		}
+		node.number = number
+	}
+	return node, nil
+}
+
+// parseQuoted parses the Go quoted string
+func (p *matchExprParser) parseQuoted() (string, error) {
+	begin := p.pos
+	p.pos++
+	for p.pos < len(p.s) && p.s[p.pos] != '"' {
+		if p.s[p.pos] == '\\' {
+			p.pos++
+		}
+		p.pos++
+	}
+	if p.pos >= len(p.s) {
+		return "", p.errorf("unterminated string")
+	}
+	p.pos++
+
+	value, err := strconv.Unquote(p.s[begin:p.pos])
+	if err != nil {
+		return "", p.errorf("wrong string %s: %s", p.s[begin:p.pos], err.Error())
+	}
+	return value, nil
+}
+
+// parseRegexp parses the regexp in slashes, the escaped slashes are unescaped
+func (p *matchExprParser) parseRegexp() (string, error) {
+	p.pos++
+	var b strings.Builder
+	for p.pos < len(p.s) && p.s[p.pos] != '/' {
+		if p.s[p.pos] == '\\' && p.pos+1 < len(p.s) && p.s[p.pos+1] == '/' {
+			p.pos++
+		}
+		b.WriteByte(p.s[p.pos])
+		p.pos++
+	}
+	if p.pos == len(p.s) {
+		return "", p.errorf("unterminated regexp")
+	}
+	p.pos++
+	return b.String(), nil
+}
@@ -0,0 +1,98 @@
+package pipeline
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	insaneJSON "github.com/vitkovskii/insane-json"
+)
+
+func TestMatchExpr(t *testing.T) {
+	cases := []struct {
+		expr    string
+		matched []string
+		skipped []string
+	}{
+		{
+			expr:    `(status>=500 AND env=prod) OR level=fatal`,
+			matched: []string{`{"status":503,"env":"prod"}`, `{"status":"500","env":"prod"}`, `{"level":"fatal"}`},
+			skipped: []string{`{"status":404,"env":"prod"}`, `{"status":503,"env":"stg"}`, `{"status":"n/a","env":"prod"}`, `{}`},
+		},
+		{
+			expr:    `NOT (level=debug OR level=trace) and exists(k8s.pod)`,
+			matched: []string{`{"level":"info","k8s":{"pod":"api"}}`, `{"k8s":{"pod":"api"}}`},
+			skipped: []string{`{"level":"debug","k8s":{"pod":"api"}}`, `{"level":"info"}`},
+		},
+		{
+			expr:    `message~/time ?out/ and message !~ "(?i)retry" and service != checkout`,
+			matched: []string{`{"message":"request timeout"}`, `{"message":"time out","service":"api"}`},
+			skipped: []string{`{"message":"timeout, Retry"}`, `{"message":"timeout","service":"checkout"}`, `{"message":"ok"}`},
+		},
+		{
+			expr:    `latency > 1.5e3 or latency<0 or "user name" = "a b" or path="/"`,
+			matched: []string{`{"latency":2000}`, `{"latency":-1}`, `{"user name":"a b"}`, `{"path":"/"}`},
+			skipped: []string{`{"latency":1500}`, `{"user name":"a"}`, `{"path":"/a"}`},
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.expr, func(t *testing.T) {
+			expr, err := ParseMatchExpr(tt.expr)
+			require.NoError(t, err)
+
+			check := func(events []string, expected bool) {
+				for _, e := range events {
+					root, err := insaneJSON.DecodeString(e)
+					require.NoError(t, err)
+					assert.Equal(t, expected, expr.Match(&Event{Root: root}), e)
+					insaneJSON.Release(root)
+				}
+			}
+			check(tt.matched, true)
+			check(tt.skipped, false)
+		})
+	}
+}
+
+func TestMatchExprErrors(t *testing.T) {
+	for _, expr := range []string{
+		``,
+		`level`,
+		`level=`,
+		`(level=info`,
+		`level=info)`,
+		`level=info level=debug`,
+		`status>high`,
+		`status>/5/`,
+		`level=/info/`,
+		`message~/(/`,
+		`message="unterminated`,
+		`exists(level`,
+		`level=info AND`,
+	} {
+		_, err := ParseMatchExpr(expr)
+		assert.Error(t, err, expr)
+	}
+}
+
+func TestMatchExprNil(t *testing.T) {
+	var expr *MatchExpr
+	assert.True(t, expr.Match(&Event{}))
+}
+
+func TestMatchExprAllocs(t *testing.T) {
+	expr, err := ParseMatchExpr(`(status>=500 AND env=prod) OR message~/timeout/ OR NOT exists(level) OR status<100`)
+	require.NoError(t, err)
+
+	root, err := insaneJSON.DecodeString(`{"status":"not a number","env":"prod","message":"ok","level":"info"}`)
+	require.NoError(t, err)
+	defer insaneJSON.Release(root)
+	event := &Event{Root: root}
+
+	expr.Match(event)
+	allocs := testing.AllocsPerRun(100, func() {
+		expr.Match(event)
+	})
+	assert.Equal(t, 0.0, allocs)
+}
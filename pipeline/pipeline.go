@@ -11,12 +11,14 @@ import (
 	"sync"
 	"time"
 
+	"github.com/cespare/xxhash/v2"
 	"github.com/ozontech/file.d/cfg/matchrule"
 	"github.com/ozontech/file.d/decoder"
 	"github.com/ozontech/file.d/logger"
 	"github.com/ozontech/file.d/metric"
 	"github.com/ozontech/file.d/pipeline/antispam"
 	"github.com/prometheus/client_golang/prometheus"
+	insaneJSON "github.com/vitkovskii/insane-json"
 	"go.uber.org/atomic"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
@@ -32,6 +34,7 @@ const (
 	DefaultEventTimeout        = time.Second * 30
 	DefaultFieldValue          = "not_set"
 	DefaultStreamName          = StreamName("not_set")
+	DefaultOrderingLanes       = 16
 
 	EventSeqIDError = uint64(0)
 
@@ -43,15 +46,17 @@ type finalizeFn = func(event *Event, notifyInput bool, backEvent bool)
 
 type InputPluginController interface {
 	In(sourceID SourceID, sourceName string, offset int64, data []byte, isNewSource bool) uint64
-	UseSpread()                           // don't use stream field and spread all events across all processors
-	DisableStreams()                      // don't use stream field
-	SuggestDecoder(t decoder.DecoderType) // set decoder if pipeline uses "auto" value for decoder
-	IncReadOps()                          // inc read ops for metric
-	IncMaxEventSizeExceeded()             // inc max event size exceeded counter
+	UseSpread()                             // don't use stream field and spread all events across all processors
+	DisableStreams()                        // don't use stream field
+	SuggestDecoder(t decoder.DecoderType)   // set decoder if pipeline uses "auto" value for decoder
+	IncReadOps()                            // inc read ops for metric
+	IncMaxEventSizeExceeded()               // inc max event size exceeded counter
+	IsSourceLimited(sourceID SourceID) bool // the source has reached the source inflight limit, so it should be read later
 }
 
 type ActionPluginController interface {
-	Propagate(event *Event) // throw held event back to pipeline
+	Propagate(event *Event)                        // throw held event back to pipeline
+	Spawn(parent *Event, nodes []*insaneJSON.Node) // pass the children made of the nodes to the next actions, the parent should be returned with ActionBreak
 }
 
 type OutputPluginController interface {
@@ -64,6 +69,19 @@ type (
 	StreamName string
 )
 
+// MaxEventSizePolicy defines what to do with the event exceeding the Settings.MaxEventSize.
+type MaxEventSizePolicy byte
+
+const (
+	// MaxEventSizeDrop drops the event
+	MaxEventSizeDrop MaxEventSizePolicy = iota
+	// MaxEventSizeTruncate passes the first MaxEventSize bytes of the event in the `message` field
+	// with the `truncated` field, since the truncated event can't be decoded
+	MaxEventSizeTruncate
+	// MaxEventSizeDeadLetter passes the truncated event to the dead letter output instead of the pipeline output
+	MaxEventSizeDeadLetter
+)
+
 type Pipeline struct {
 	Name     string
 	started  bool
@@ -72,8 +90,10 @@ type Pipeline struct {
 	decoder          decoder.DecoderType // decoder set in the config
 	suggestedDecoder decoder.DecoderType // decoder suggested by input plugin, it is used when config decoder is set to "auto"
 
-	eventPool *eventPool
-	streamer  *streamer
+	eventPool     *eventPool
+	streamer      *streamer
+	sourceLimiter *sourceLimiter
+	inputLimiter  *inputLimiter
 
 	useSpread      bool
 	disableStreams bool
@@ -83,6 +103,8 @@ type Pipeline struct {
 	input      InputPlugin
 	inputInfo  *InputPluginInfo
 	antispamer *antispam.Antispammer
+	// discardAwareInput is set if the input should know about the discarded events
+	discardAwareInput DiscardAwarePlugin
 
 	actionInfos  []*ActionPluginStaticInfo
 	Procs        []*processor
@@ -93,6 +115,16 @@ type Pipeline struct {
 	output     OutputPlugin
 	outputInfo *OutputPluginInfo
 
+	deadLetterOutput OutputPlugin
+	deadLetterInfo   *OutputPluginInfo
+	deadLetter       *DeadLetter
+
+	errorOutput     OutputPlugin
+	errorOutputInfo *OutputPluginInfo
+	errorEvents     *ErrorEvents
+
+	health *Health
+
 	metricsHolder *metricsHolder
 
 	// some debugging stuff
@@ -111,6 +143,8 @@ type Pipeline struct {
 
 	inUseEventsMetric          *prometheus.GaugeVec
 	eventPoolCapacityMetric    *prometheus.GaugeVec
+	maxInUseEventsMetric       *prometheus.GaugeVec
+	eventPoolWaitsMetric       *prometheus.CounterVec
 	inputEventsCountMetric     *prometheus.CounterVec
 	inputEventSizeMetric       *prometheus.CounterVec
 	outputEventsCountMetric    *prometheus.CounterVec
@@ -119,6 +153,9 @@ type Pipeline struct {
 	wrongEventCRIFormatMetric  *prometheus.CounterVec
 	maxEventSizeExceededMetric *prometheus.CounterVec
 	eventPoolLatency           prometheus.Observer
+	sourceInflightMetric       *prometheus.GaugeVec
+	sourceLimitWaitsMetric     prometheus.Counter
+	inputDroppedEventsMetric   *prometheus.CounterVec
 }
 
 type Settings struct {
@@ -126,12 +163,23 @@ type Settings struct {
 	Capacity            int
 	MaintenanceInterval time.Duration
 	EventTimeout        time.Duration
+	MaxEventAge         time.Duration
+	CircuitBreaker      BatcherCircuitBreaker
+	Health              HealthSettings
 	AntispamThreshold   int
 	AntispamExceptions  matchrule.RuleSets
+	SourceInflightLimit int
+	SampleRate          float64
+	MaxEventsPerSec     int
 	AvgEventSize        int
 	MaxEventSize        int
+	MaxEventSizePolicy  MaxEventSizePolicy
+	ErrorEvents         ErrorEventsSettings
 	StreamField         string
 	IsStrict            bool
+	// OrderingKey is the field which events are routed to OrderingLanes by, if it's set
+	OrderingKey   []string
+	OrderingLanes int
 }
 
 // New creates new pipeline. Consider using `SetupHTTPHandlers` next.
@@ -153,6 +201,7 @@ func New(name string, settings *Settings, registry *prometheus.Registry) *Pipeli
 		},
 
 		metricsHolder: newMetricsHolder(name, registry, metricsGenInterval),
+		health:        newHealth(settings.Health),
 		streamer:      newStreamer(settings.EventTimeout),
 		eventPool:     newEventPool(settings.Capacity, settings.AvgEventSize),
 		antispamer: antispam.NewAntispammer(antispam.Options{
@@ -170,6 +219,8 @@ func New(name string, settings *Settings, registry *prometheus.Registry) *Pipeli
 
 	pipeline.registerMetrics()
 	pipeline.setDefaultMetrics()
+	pipeline.sourceLimiter = newSourceLimiter(settings.SourceInflightLimit, pipeline.sourceInflightMetric, pipeline.sourceLimitWaitsMetric)
+	pipeline.inputLimiter = newInputLimiter(settings.SampleRate, settings.MaxEventsPerSec, pipeline.inputDroppedEventsMetric)
 
 	switch settings.Decoder {
 	case "json":
@@ -203,6 +254,8 @@ func (p *Pipeline) registerMetrics() {
 	m := p.actionParams.MetricCtl
 	p.inUseEventsMetric = m.RegisterGauge("event_pool_in_use_events", "Count of pool events which is used for processing")
 	p.eventPoolCapacityMetric = m.RegisterGauge("event_pool_capacity", "Pool capacity value")
+	p.maxInUseEventsMetric = m.RegisterGauge("event_pool_max_in_use_events", "Max count of pool events used for processing during the maintenance interval")
+	p.eventPoolWaitsMetric = m.RegisterCounter("event_pool_waits_total", "Count of times the pool is exhausted and getting the event has to wait")
 	p.inputEventsCountMetric = m.RegisterCounter("input_events_count", "Count of events on pipeline input")
 	p.inputEventSizeMetric = m.RegisterCounter("input_events_size", "Size of events on pipeline input")
 	p.outputEventsCountMetric = m.RegisterCounter("output_events_count", "Count of events on pipeline output")
@@ -213,6 +266,12 @@ func (p *Pipeline) registerMetrics() {
 	p.eventPoolLatency = m.RegisterHistogram("event_pool_latency_seconds",
 		"How long we are wait an event from the pool", metric.SecondsBucketsDetailedNano).
 		WithLabelValues()
+	p.sourceInflightMetric = m.RegisterGauge("source_inflight_events",
+		"Count of events of the input source which are processed by the pipeline, it's set if the source inflight limit is enabled", "source_name")
+	p.sourceLimitWaitsMetric = m.RegisterCounter("source_limit_waits_total",
+		"Count of times the input source reaches the inflight limit and the input postpones reading it").WithLabelValues()
+	p.inputDroppedEventsMetric = m.RegisterCounter("input_dropped_events_total",
+		"Count of events dropped at the pipeline input by the sampling or by the rate limit", "reason")
 }
 
 func (p *Pipeline) setDefaultMetrics() {
@@ -236,6 +295,9 @@ func (p *Pipeline) SetupHTTPHandlers(mux *http.ServeMux) {
 	mux.HandleFunc(prefix, p.servePipeline)
 	prefixBanList := fmt.Sprintf("/pipelines/%s/ban_list", p.Name)
 	mux.HandleFunc(prefixBanList, p.servePipelineBanList)
+	mux.HandleFunc(prefix+"/antispam", p.serveAntispam)
+	mux.HandleFunc(prefix+"/antispam/threshold", p.serveAntispamThreshold)
+	mux.HandleFunc(prefix+"/antispam/unban", p.serveAntispamUnban)
 	for hName, handler := range p.inputInfo.PluginStaticInfo.Endpoints {
 		mux.HandleFunc(fmt.Sprintf("%s/0/%s", prefix, hName), handler)
 	}
@@ -264,10 +326,18 @@ func (p *Pipeline) Start() {
 	p.initProcs()
 	p.metricsHolder.start()
 
+	p.errorEvents = p.startErrorOutput()
+	p.deadLetter = p.startDeadLetter()
+	if p.settings.MaxEventSizePolicy == MaxEventSizeDeadLetter && p.deadLetter == nil {
+		p.logger.Warn("dead letter output isn't set, so the events exceeding the max event size are dropped")
+	}
 	outputParams := &OutputPluginParams{
 		PluginDefaultParams: p.actionParams,
 		Controller:          p,
 		Logger:              p.logger.Sugar().Named("output").Named(p.outputInfo.Type),
+		DeadLetter:          p.deadLetter,
+		Health:              p.health,
+		ErrorReporter:       p.errorEvents.Reporter(PluginKindOutput, p.outputInfo.PluginStaticInfo),
 	}
 	p.logger.Info("starting output plugin", zap.String("name", p.outputInfo.Type))
 
@@ -275,7 +345,7 @@ func (p *Pipeline) Start() {
 
 	p.logger.Info("stating processors", zap.Int("count", len(p.Procs)))
 	for _, processor := range p.Procs {
-		processor.start(p.actionParams, p.logger.Sugar())
+		processor.start(p.actionParams, p.logger.Sugar(), p.errorEvents)
 	}
 
 	p.logger.Info("starting input plugin", zap.String("name", p.inputInfo.Type))
@@ -312,12 +382,25 @@ func (p *Pipeline) Stop() {
 	p.logger.Info("stopping output")
 	p.output.Stop()
 
+	// the dead letter output is stopped after the output, so it gets the events failed on the output stop
+	if p.deadLetterOutput != nil {
+		p.logger.Info("stopping dead letter output")
+		p.deadLetterOutput.Stop()
+	}
+
+	// the error output is stopped last, so it gets the errors of the other outputs on their stop
+	if p.errorOutput != nil {
+		p.logger.Info("stopping error output")
+		p.errorOutput.Stop()
+	}
+
 	p.shouldStop.Store(true)
 }
 
 func (p *Pipeline) SetInput(info *InputPluginInfo) {
 	p.inputInfo = info
 	p.input = info.Plugin.(InputPlugin)
+	p.discardAwareInput, _ = info.Plugin.(DiscardAwarePlugin)
 }
 
 func (p *Pipeline) GetInput() InputPlugin {
@@ -333,6 +416,75 @@ func (p *Pipeline) GetOutput() OutputPlugin {
 	return p.output
 }
 
+// SetDeadLetter sets the output which gets the events undelivered by the pipeline output.
+func (p *Pipeline) SetDeadLetter(info *OutputPluginInfo) {
+	p.deadLetterInfo = info
+	p.deadLetterOutput = info.Plugin.(OutputPlugin)
+}
+
+// startDeadLetter starts the dead letter output, it returns nil if the output isn't set
+func (p *Pipeline) startDeadLetter() *DeadLetter {
+	if p.deadLetterOutput == nil {
+		return nil
+	}
+
+	p.logger.Info("starting dead letter output plugin", zap.String("name", p.deadLetterInfo.Type))
+	p.deadLetterOutput.Start(p.deadLetterInfo.Config, &OutputPluginParams{
+		PluginDefaultParams: p.actionParams,
+		Controller:          deadLetterController{controller: p},
+		Logger:              p.logger.Sugar().Named("dead_letter").Named(p.deadLetterInfo.Type),
+		Health:              p.health,
+	})
+
+	eventsMetric := p.actionParams.MetricCtl.RegisterCounter("dead_letter_events_total",
+		"Number of events undelivered by the output and passed to the dead letter output").WithLabelValues()
+	return NewDeadLetter(p.deadLetterOutput, eventsMetric)
+}
+
+// SetErrorOutput sets the output which gets the structured events about the errors of the actions and the outputs.
+func (p *Pipeline) SetErrorOutput(info *OutputPluginInfo) {
+	p.errorOutputInfo = info
+	p.errorOutput = info.Plugin.(OutputPlugin)
+}
+
+// startErrorOutput starts the error output, it returns nil if the output isn't set
+func (p *Pipeline) startErrorOutput() *ErrorEvents {
+	if p.errorOutput == nil {
+		return nil
+	}
+
+	p.logger.Info("starting error output plugin", zap.String("name", p.errorOutputInfo.Type))
+	// the error output doesn't get the error reporter, so its errors don't make a feedback loop
+	p.errorOutput.Start(p.errorOutputInfo.Config, &OutputPluginParams{
+		PluginDefaultParams: p.actionParams,
+		Controller:          deadLetterController{controller: p},
+		Logger:              p.logger.Sugar().Named("error_output").Named(p.errorOutputInfo.Type),
+	})
+
+	m := p.actionParams.MetricCtl
+	eventsMetric := m.RegisterCounter("error_events_total",
+		"Number of events about the plugin errors passed to the error output").WithLabelValues()
+	droppedMetric := m.RegisterCounter("error_events_dropped_total",
+		"Number of events about the plugin errors dropped by the rate limit").WithLabelValues()
+	return NewErrorEvents(p.errorOutput, p.Name, p.settings.ErrorEvents, eventsMetric, droppedMetric)
+}
+
+// Ready returns the reason the pipeline can't accept the events, e.g. the output is in the sustained backpressure.
+func (p *Pipeline) Ready() error {
+	return p.health.Ready()
+}
+
+// Live returns the reason the pipeline is stuck.
+func (p *Pipeline) Live() error {
+	return p.health.Live()
+}
+
+// IsSourceLimited returns true if the source has reached the source inflight limit,
+// the inputs stop reading the source until its events are committed.
+func (p *Pipeline) IsSourceLimited(sourceID SourceID) bool {
+	return p.sourceLimiter.isLimited(sourceID)
+}
+
 // In decodes message and passes it to event stream.
 func (p *Pipeline) In(sourceID SourceID, sourceName string, offset int64, bytes []byte, isNewSource bool) (seqID uint64) {
 	length := len(bytes)
@@ -346,7 +498,10 @@ func (p *Pipeline) In(sourceID SourceID, sourceName string, offset int64, bytes
 	isLong := p.settings.MaxEventSize != 0 && length > p.settings.MaxEventSize
 	if isLong {
 		p.IncMaxEventSizeExceeded()
-		return EventSeqIDError
+		policy := p.settings.MaxEventSizePolicy
+		if policy == MaxEventSizeDrop || policy == MaxEventSizeDeadLetter && p.deadLetter == nil {
+			return EventSeqIDError
+		}
 	}
 
 	var (
@@ -361,7 +516,7 @@ func (p *Pipeline) In(sourceID SourceID, sourceName string, offset int64, bytes
 	}
 	if dec == decoder.NO {
 		dec = decoder.JSON
-	} else if dec == decoder.CRI {
+	} else if dec == decoder.CRI && !isLong {
 		row, err = decoder.DecodeCRI(bytes)
 		if err != nil {
 			p.wrongEventCRIFormatMetric.WithLabelValues().Inc()
@@ -383,17 +538,29 @@ func (p *Pipeline) In(sourceID SourceID, sourceName string, offset int64, bytes
 		if isSpam {
 			return EventSeqIDError
 		}
+
+		if p.inputLimiter.drop() {
+			return EventSeqIDError
+		}
 	}
 
 	p.inputEvents.Inc()
 	p.inputSize.Add(int64(length))
 
+	p.sourceLimiter.acquire(sourceID, sourceName)
+
 	now := time.Now()
 	event := p.eventPool.get()
 	p.eventPoolLatency.Observe(time.Since(now).Seconds())
+	// the source is set before the decoding since the event is released by it on the error
+	event.SourceID = sourceID
 
-	switch dec {
-	case decoder.JSON:
+	switch {
+	case isLong:
+		_ = event.Root.DecodeString("{}")
+		event.Root.AddFieldNoAlloc(event.Root, "message").MutateToBytesCopy(event.Root, bytes[:p.settings.MaxEventSize])
+		event.Root.AddFieldNoAlloc(event.Root, "truncated").MutateToBool(true)
+	case dec == decoder.JSON:
 		err := event.parseJSON(bytes)
 		if err != nil {
 			level := zapcore.ErrorLevel
@@ -409,18 +576,21 @@ func (p *Pipeline) In(sourceID SourceID, sourceName string, offset int64, bytes
 				zap.ByteString("json", bytes))
 
 			// Can't process event, return to pool.
-			p.eventPool.back(event)
+			p.backEvent(event)
 			return EventSeqIDError
 		}
-	case decoder.RAW:
+	case dec == decoder.RAW:
 		_ = event.Root.DecodeString("{}")
 		event.Root.AddFieldNoAlloc(event.Root, "message").MutateToBytesCopy(event.Root, bytes[:len(bytes)-1])
-	case decoder.CRI:
+	case dec == decoder.CRI:
 		_ = event.Root.DecodeString("{}")
 		event.Root.AddFieldNoAlloc(event.Root, "log").MutateToBytesCopy(event.Root, row.Log)
 		event.Root.AddFieldNoAlloc(event.Root, "time").MutateToBytesCopy(event.Root, row.Time)
 		event.Root.AddFieldNoAlloc(event.Root, "stream").MutateToBytesCopy(event.Root, row.Stream)
-	case decoder.POSTGRES:
+		if row.IsTruncated {
+			event.Root.AddFieldNoAlloc(event.Root, "truncated").MutateToBool(true)
+		}
+	case dec == decoder.POSTGRES:
 		_ = event.Root.DecodeString("{}")
 		err := decoder.DecodePostgres(event.Root, bytes)
 		if err != nil {
@@ -434,7 +604,7 @@ func (p *Pipeline) In(sourceID SourceID, sourceName string, offset int64, bytes
 			// Dead route, never passed here.
 			return EventSeqIDError
 		}
-	case decoder.NGINX_ERROR:
+	case dec == decoder.NGINX_ERROR:
 		_ = event.Root.DecodeString("{}")
 		err := decoder.DecodeNginxError(event.Root, bytes)
 		if err != nil {
@@ -450,7 +620,7 @@ func (p *Pipeline) In(sourceID SourceID, sourceName string, offset int64, bytes
 				zap.String("source_name", sourceName),
 				zap.ByteString("log", bytes))
 
-			p.eventPool.back(event)
+			p.backEvent(event)
 			return EventSeqIDError
 		}
 	default:
@@ -458,31 +628,47 @@ func (p *Pipeline) In(sourceID SourceID, sourceName string, offset int64, bytes
 	}
 
 	event.Offset = offset
-	event.SourceID = sourceID
 	event.SourceName = sourceName
 	event.streamName = DefaultStreamName
 	event.Size = len(bytes)
+	// the long event keeps only the first MaxEventSize bytes
+	if isLong {
+		event.Size = p.settings.MaxEventSize
+	}
+	event.createdAt = time.Now()
+
+	if isLong && p.settings.MaxEventSizePolicy == MaxEventSizeDeadLetter {
+		errText := fmt.Sprintf("event size %d exceeds max event size %d", length, p.settings.MaxEventSize)
+		p.deadLetter.Send(p.outputInfo.Type, errText, 0, []*Event{event})
+		p.backEvent(event)
+		return EventSeqIDError
+	}
 
 	return p.streamEvent(event)
 }
 
 func (p *Pipeline) streamEvent(event *Event) uint64 {
 	streamID := StreamID(event.SourceID)
+	isOrdered := len(p.settings.OrderingKey) > 0
 
+	switch {
+	// the lane is the stream, so events with the same key are processed in order by one processor at a time
+	case isOrdered:
+		streamID = p.orderingLane(event)
 	// spread events across all processors
-	if p.useSpread {
+	case p.useSpread:
 		streamID = StreamID(event.SeqID % uint64(p.procCount.Load()))
 	}
 
 	if !p.disableStreams {
-		node := event.Root.Dig(p.settings.StreamField)
-		if node != nil {
+		// the stream field would split the lane, so events with the same key could be reordered
+		if node := event.Root.Dig(p.settings.StreamField); node != nil && !isOrdered {
 			event.streamName = StreamName(node.AsString())
 		}
 
 		if pass := p.input.PassEvent(event); !pass {
 			// Can't process event, return to pool.
-			p.eventPool.back(event)
+			p.backEvent(event)
 			return EventSeqIDError
 		}
 	}
@@ -490,6 +676,15 @@ func (p *Pipeline) streamEvent(event *Event) uint64 {
 	return p.streamer.putEvent(streamID, event.streamName, event)
 }
 
+// orderingLane returns the lane by the hash of the ordering key, events without the key share the same lane
+func (p *Pipeline) orderingLane(event *Event) StreamID {
+	key := ""
+	if node := event.Root.Dig(p.settings.OrderingKey...); node != nil {
+		key = node.AsString()
+	}
+	return StreamID(xxhash.Sum64String(key) % uint64(p.settings.OrderingLanes))
+}
+
 func (p *Pipeline) Commit(event *Event) {
 	p.finalize(event, true, true)
 }
@@ -503,7 +698,8 @@ func (p *Pipeline) Error(err string) {
 }
 
 func (p *Pipeline) finalize(event *Event, notifyInput bool, backEvent bool) {
-	if event.IsTimeoutKind() {
+	// children are committed by the parent
+	if event.IsTimeoutKind() || event.IsChild() {
 		return
 	}
 
@@ -511,6 +707,8 @@ func (p *Pipeline) finalize(event *Event, notifyInput bool, backEvent bool) {
 		p.input.Commit(event)
 		p.outputEvents.Inc()
 		p.outputSize.Add(int64(event.Size))
+	} else if backEvent && p.discardAwareInput != nil {
+		p.discardAwareInput.Discard(event)
 	}
 
 	// todo: avoid event.stream.commit(event)
@@ -526,6 +724,12 @@ func (p *Pipeline) finalize(event *Event, notifyInput bool, backEvent bool) {
 		p.eventLogMu.Unlock()
 	}
 
+	p.backEvent(event)
+}
+
+// backEvent returns the event of the input source to the pool
+func (p *Pipeline) backEvent(event *Event) {
+	p.sourceLimiter.release(event.SourceID)
 	p.eventPool.back(event)
 }
 
@@ -608,7 +812,7 @@ func (p *Pipeline) expandProcs() {
 	for x := 0; x < int(to-from); x++ {
 		proc := p.newProc(p.Procs[from-1].id + x)
 		p.Procs = append(p.Procs, proc)
-		proc.start(p.actionParams, p.logger.Sugar())
+		proc.start(p.actionParams, p.logger.Sugar(), p.errorEvents)
 	}
 
 	p.procCount.Swap(to)
@@ -671,6 +875,7 @@ func (p *Pipeline) incMetrics(inputEvents, inputSize, outputEvents, outputSize,
 
 func (p *Pipeline) setMetrics(inUseEvents int64) {
 	p.inUseEventsMetric.WithLabelValues().Set(float64(inUseEvents))
+	p.maxInUseEventsMetric.WithLabelValues().Set(float64(p.eventPool.resetMaxInUseEvents()))
 }
 
 func (p *Pipeline) maintenance() {
@@ -679,6 +884,7 @@ func (p *Pipeline) maintenance() {
 	outputEvents := newDeltaWrapper()
 	outputSize := newDeltaWrapper()
 	readOps := newDeltaWrapper()
+	poolWaits := newDeltaWrapper()
 
 	for {
 		time.Sleep(p.settings.MaintenanceInterval)
@@ -687,10 +893,12 @@ func (p *Pipeline) maintenance() {
 		}
 
 		p.antispamer.Maintenance()
+		p.sourceLimiter.maintenance()
 		p.metricsHolder.maintenance()
 
 		myDeltas := p.incMetrics(inputEvents, inputSize, outputEvents, outputSize, readOps)
 		p.setMetrics(p.eventPool.inUseEvents.Load())
+		p.eventPoolWaitsMetric.WithLabelValues().Add(poolWaits.updateValue(p.eventPool.waits.Load()))
 		p.logChanges(myDeltas)
 	}
 }
@@ -749,6 +957,100 @@ func (p *Pipeline) servePipelineBanList(w http.ResponseWriter, _ *http.Request)
 	_, _ = w.Write([]byte("</p></pre></body></html>"))
 }
 
+func (p *Pipeline) serveAntispam(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(p.antispamer.State())
+}
+
+// serveAntispamThreshold overrides the antispam threshold on PUT and restores the configured one on DELETE.
+// The threshold is set in the units of the `antispam_threshold` setting.
+func (p *Pipeline) serveAntispamThreshold(w http.ResponseWriter, r *http.Request) {
+	defer func(body io.ReadCloser) {
+		_ = body.Close()
+	}(r.Body)
+
+	switch r.Method {
+	case http.MethodPut:
+		req := struct {
+			Threshold int    `json:"threshold"`
+			TTL       string `json:"ttl"`
+		}{}
+
+		dec := json.NewDecoder(r.Body)
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&req); err != nil {
+			http.Error(w, fmt.Sprintf("decode request: %s", err), http.StatusBadRequest)
+			return
+		}
+
+		var ttl time.Duration
+		if req.TTL != "" {
+			var err error
+			ttl, err = time.ParseDuration(req.TTL)
+			if err != nil || ttl < 0 {
+				http.Error(w, fmt.Sprintf("wrong ttl %q", req.TTL), http.StatusBadRequest)
+				return
+			}
+		}
+
+		threshold := antispam.ScaleThreshold(req.Threshold, p.settings.MaintenanceInterval)
+		if err := p.antispamer.SetThreshold(threshold, ttl); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	case http.MethodDelete:
+		p.antispamer.ResetThreshold()
+	default:
+		http.Error(w, "", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(p.antispamer.State())
+}
+
+// serveAntispamUnban unbans the source by the id or by the name.
+func (p *Pipeline) serveAntispamUnban(w http.ResponseWriter, r *http.Request) {
+	defer func(body io.ReadCloser) {
+		_ = body.Close()
+	}(r.Body)
+
+	if r.Method != http.MethodPut {
+		http.Error(w, "", http.StatusMethodNotAllowed)
+		return
+	}
+
+	req := struct {
+		SourceID   uint64 `json:"source_id"`
+		SourceName string `json:"source_name"`
+	}{}
+
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("decode request: %s", err), http.StatusBadRequest)
+		return
+	}
+	if req.SourceID == 0 && req.SourceName == "" {
+		http.Error(w, "source_id or source_name should be set", http.StatusBadRequest)
+		return
+	}
+
+	res := struct {
+		Unbanned int `json:"unbanned"`
+	}{
+		Unbanned: p.antispamer.Unban(req.SourceID, req.SourceName),
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(res)
+}
+
 // serveActionInfo creates a handlerFunc for the given action.
 // it returns metric values for the given action.
 func (p *Pipeline) serveActionInfo(info *ActionPluginStaticInfo) func(http.ResponseWriter, *http.Request) {
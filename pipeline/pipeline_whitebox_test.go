@@ -3,6 +3,7 @@ package pipeline
 import (
 	"testing"
 
+	"github.com/cespare/xxhash/v2"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/stretchr/testify/assert"
 	"go.uber.org/atomic"
@@ -45,3 +46,89 @@ func (p *TestInputPlugin) Commit(_ *Event)                         {}
 func (p *TestInputPlugin) PassEvent(_ *Event) bool {
 	return true
 }
+
+func TestPipelineMaxEventSizePolicy(t *testing.T) {
+	newPipeline := func(policy MaxEventSizePolicy) *Pipeline {
+		settings := &Settings{
+			Capacity:           5,
+			Decoder:            "json",
+			MaxEventSize:       5,
+			MaxEventSizePolicy: policy,
+		}
+		p := New("test", settings, prometheus.NewRegistry())
+		p.input = &TestInputPlugin{}
+		p.outputInfo = &OutputPluginInfo{PluginStaticInfo: &PluginStaticInfo{Type: "devnull"}}
+		return p
+	}
+
+	p := newPipeline(MaxEventSizeDrop)
+	assert.Equal(t, EventSeqIDError, p.In(1, "source", 0, []byte(`{"a":"long"}`), false))
+
+	p = newPipeline(MaxEventSizeTruncate)
+	p.In(1, "source", 0, []byte(`{"a":"long"}`), false)
+	event := p.streamer.getStream(1, DefaultStreamName).first
+	assert.Equal(t, `{"message":"{\"a\":","truncated":true}`, event.Root.EncodeToString())
+	assert.Equal(t, 5, event.Size, "size isn't truncated")
+
+	p = newPipeline(MaxEventSizeDeadLetter)
+	output := &deadLetterOutput{}
+	p.deadLetter = NewDeadLetter(output, p.actionParams.MetricCtl.RegisterCounter("dead_letter_events_total", "").WithLabelValues())
+	assert.Equal(t, EventSeqIDError, p.In(1, "source", 0, []byte(`{"a":"long"}`), false))
+	assert.Equal(t, []string{
+		`{"message":"{\"a\":","truncated":true,"dead_letter":{"output":"devnull","error":"event size 12 exceeds max event size 5","attempts":0}}`,
+	}, output.events)
+	assert.Equal(t, int64(0), p.eventPool.inUseEvents.Load(), "event isn't returned to the pool")
+}
+
+func TestPipelineOrderingLanes(t *testing.T) {
+	settings := &Settings{
+		Capacity:      16,
+		Decoder:       "json",
+		StreamField:   DefaultStreamField,
+		OrderingKey:   []string{"user", "id"},
+		OrderingLanes: 4,
+	}
+	p := New("test", settings, prometheus.NewRegistry())
+	p.input = &TestInputPlugin{}
+	p.procCount = atomic.NewInt32(7)
+	// the lanes take precedence over the spread
+	p.UseSpread()
+
+	events := []string{
+		`{"user":{"id":"a"},"n":1,"stream":"stdout"}`,
+		`{"user":{"id":"b"},"n":2}`,
+		`{"user":{"id":"a"},"n":3,"stream":"stderr"}`,
+		`{"n":4}`,
+		`{"user":{"id":"a"},"n":5}`,
+	}
+	for i, e := range events {
+		p.In(SourceID(i), "source", 0, []byte(e), false)
+	}
+
+	lane := func(key string) []string {
+		got := make([]string, 0)
+		stream := p.streamer.getStream(StreamID(xxhash.Sum64String(key)%4), DefaultStreamName)
+		for event := stream.first; event != nil; event = event.next {
+			got = append(got, event.Root.Dig("n").AsString())
+		}
+		return got
+	}
+
+	// events with the same key are in the same stream in the order they came regardless of the source and the stream field
+	assert.Equal(t, []string{"1", "3", "5"}, filter(lane("a"), "1", "3", "5"))
+	assert.Contains(t, lane("b"), "2")
+	assert.Contains(t, lane(""), "4")
+}
+
+// filter keeps the values of the other keys sharing the lane out
+func filter(values []string, allowed ...string) []string {
+	out := make([]string, 0)
+	for _, v := range values {
+		for _, a := range allowed {
+			if v == a {
+				out = append(out, v)
+			}
+		}
+	}
+	return out
+}
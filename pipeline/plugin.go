@@ -4,6 +4,7 @@ import (
 	"net/http"
 	"regexp"
 	"strings"
+	"time"
 
 	"github.com/ozontech/file.d/metric"
 	"go.uber.org/zap"
@@ -24,12 +25,24 @@ type InputPlugin interface {
 	PassEvent(event *Event) bool
 }
 
+// DiscardAwarePlugin is implemented by the inputs which should know about the events discarded or collapsed by actions,
+// since such events are never committed. Unlike Commit, Discard is called out of the offset order.
+type DiscardAwarePlugin interface {
+	Discard(*Event)
+}
+
 type ActionPlugin interface {
 	Start(config AnyConfig, params *ActionPluginParams)
 	Stop()
 	Do(*Event) ActionResult
 }
 
+// HoldTimeoutPlugin is implemented by the actions which should get the timeout event
+// for the held event sooner than the pipeline event timeout, zero means the pipeline one.
+type HoldTimeoutPlugin interface {
+	HoldTimeout() time.Duration
+}
+
 type OutputPlugin interface {
 	Start(config AnyConfig, params *OutputPluginParams)
 	Stop()
@@ -52,12 +65,21 @@ type ActionPluginParams struct {
 	PluginDefaultParams
 	Controller ActionPluginController
 	Logger     *zap.SugaredLogger
+	// ErrorReporter is nil if the error output of the pipeline isn't set or the error events of the action are disabled
+	ErrorReporter *ErrorReporter
 }
 
 type OutputPluginParams struct {
 	PluginDefaultParams
 	Controller OutputPluginController
 	Logger     *zap.SugaredLogger
+	// DeadLetter is nil if the dead letter output of the pipeline isn't set
+	DeadLetter *DeadLetter
+	// Health should be passed to the BatcherOptions, so the batcher state is checked by the pipeline readiness and liveness
+	Health *Health
+	// ErrorReporter should be passed to the BatcherOptions, it's nil if the error output of the pipeline isn't set
+	// or the error events of the output are disabled
+	ErrorReporter *ErrorReporter
 }
 
 type InputPluginParams struct {
@@ -75,6 +97,11 @@ type PluginStaticInfo struct {
 	// Every plugin can provide their own API through Endpoints.
 	Endpoints         map[string]func(http.ResponseWriter, *http.Request)
 	AdditionalActions []string // used only for input plugins, defines actions that should be run right after input plugin with input config
+	// ErrorEventsDisabled stops passing the errors of the action or the output to the error output of the pipeline
+	ErrorEventsDisabled bool
+	// StreamRequired means the action joins the consecutive events of the input stream,
+	// so it can't be used with the ordering key which routes the events of the stream to the different lanes
+	StreamRequired bool
 }
 
 type PluginRuntimeInfo struct {
@@ -95,7 +122,9 @@ type ActionPluginStaticInfo struct {
 	MetricSkipStatus bool
 	MatchConditions  MatchConditions
 	MatchMode        MatchMode
-	MatchInvert      bool
+	// MatchExpr is used instead of the conditions if it's set
+	MatchExpr   *MatchExpr
+	MatchInvert bool
 }
 
 type ActionPluginInfo struct {
@@ -123,6 +152,50 @@ type MatchCondition struct {
 	Regexp *regexp.Regexp
 }
 
+// Match checks the event by the conditions in the mode, the invert isn't applied.
+func (mcs MatchConditions) Match(event *Event, mode MatchMode) bool {
+	if mode == MatchModeOr || mode == MatchModeOrPrefix {
+		return mcs.matchOr(event, mode == MatchModeOrPrefix)
+	}
+	return mcs.matchAnd(event, mode == MatchModeAndPrefix)
+}
+
+func (mcs MatchConditions) matchOr(event *Event, byPrefix bool) bool {
+	for i := range mcs {
+		node := event.Root.Dig(mcs[i].Field...)
+		if node == nil {
+			continue
+		}
+		if mcs[i].match(node.AsString(), byPrefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (mcs MatchConditions) matchAnd(event *Event, byPrefix bool) bool {
+	for i := range mcs {
+		node := event.Root.Dig(mcs[i].Field...)
+		if node == nil {
+			return false
+		}
+		if !mcs[i].match(node.AsString(), byPrefix) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// match checks the value by the regexp if it's set, otherwise by the values
+func (mc *MatchCondition) match(s string, byPrefix bool) bool {
+	if mc.Regexp != nil {
+		return mc.Regexp.MatchString(s)
+	}
+	return mc.valueExists(s, byPrefix)
+}
+
 func (mc *MatchCondition) valueExists(s string, byPrefix bool) bool {
 	var match bool
 	for i := range mc.Values {
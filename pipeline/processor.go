@@ -1,7 +1,10 @@
 package pipeline
 
 import (
+	"time"
+
 	"github.com/ozontech/file.d/logger"
+	insaneJSON "github.com/vitkovskii/insane-json"
 	"go.uber.org/atomic"
 	"go.uber.org/zap"
 )
@@ -21,6 +24,10 @@ const (
 	// check out Commit()/Propagate() functions in InputPluginController.
 	// plugin may receive event with EventKindTimeout if it takes to long to read next event from same stream.
 	ActionHold ActionResult = 3
+	// ActionBreak skip further processing of event and pass it to the output.
+	// It's used for the parent of events spawned by the Spawn() of ActionPluginController,
+	// the parent isn't sent by the output, but it's committed after the children.
+	ActionBreak ActionResult = 4
 )
 
 type eventStatus string
@@ -59,6 +66,9 @@ type processor struct {
 	actionInfos      []*ActionPluginStaticInfo
 	busyActions      []bool
 	busyActionsTotal int
+	holdTimeouts     []time.Duration
+	// isFlushing is set while the held events are flushed on the pipeline stop, so nothing is waited
+	isFlushing       bool
 	actionWatcher    *actionWatcher
 	recoverFromPanic func()
 
@@ -89,16 +99,24 @@ func newProcessor(
 	return processor
 }
 
-func (p *processor) start(params PluginDefaultParams, log *zap.SugaredLogger) {
+func (p *processor) start(params PluginDefaultParams, log *zap.SugaredLogger, errorEvents *ErrorEvents) {
 	for i, action := range p.actions {
 		actionInfo := p.actionInfos[i]
 		action.Start(actionInfo.PluginStaticInfo.Config, &ActionPluginParams{
 			PluginDefaultParams: params,
 			Controller:          p,
 			Logger:              log.Named("action").Named(actionInfo.Type),
+			ErrorReporter:       errorEvents.Reporter(PluginKindAction, actionInfo.PluginStaticInfo),
 		})
 	}
 
+	p.holdTimeouts = make([]time.Duration, len(p.actions))
+	for i, action := range p.actions {
+		if holder, ok := action.(HoldTimeoutPlugin); ok {
+			p.holdTimeouts[i] = holder.HoldTimeout()
+		}
+	}
+
 	go p.process()
 }
 
@@ -137,6 +155,11 @@ func (p *processor) processSequence(event *Event) bool {
 			return false
 		}
 
+		// actions may change the event, so the size taken on the input is stale
+		if len(p.actions) != 0 {
+			event.RecalcSize()
+		}
+
 		event.stage = eventStageOutput
 		p.output.Out(event)
 	}
@@ -147,6 +170,7 @@ func (p *processor) processSequence(event *Event) bool {
 func (p *processor) processEvent(event *Event) (isPassed bool, e *Event) {
 	for {
 		if event.IsUnlockKind() {
+			p.flushBusyActions(event.stream)
 			return true, event
 		}
 		stream := event.stream
@@ -158,12 +182,12 @@ func (p *processor) processEvent(event *Event) (isPassed bool, e *Event) {
 		event = nil // this event can be returned to the pool
 
 		// no busy actions, so return.
-		if p.busyActionsTotal == 0 {
+		if p.busyActionsTotal == 0 || p.isFlushing {
 			return false, nil
 		}
 
 		// there is busy action, waiting for next sequential event.
-		event = stream.blockGet()
+		event = stream.blockGet(p.holdTimeout(lastAction))
 		if event.IsTimeoutKind() {
 			// pass timeout directly to plugin which requested next sequential event.
 			event.action = lastAction
@@ -192,6 +216,11 @@ func (p *processor) doActions(event *Event) (isPassed bool, lastAction int) {
 			p.countEvent(event, index, eventStatusPassed)
 			p.tryResetBusy(index)
 			p.actionWatcher.setEventAfter(index, event, eventStatusPassed)
+		case ActionBreak:
+			p.countEvent(event, index, eventStatusPassed)
+			p.tryResetBusy(index)
+			p.actionWatcher.setEventAfter(index, event, eventStatusPassed)
+			return true, index
 		case ActionDiscard:
 			p.countEvent(event, index, eventStatusDiscarded)
 			p.tryResetBusy(index)
@@ -220,6 +249,29 @@ func (p *processor) doActions(event *Event) (isPassed bool, lastAction int) {
 	return true, l - 1
 }
 
+// holdTimeout returns the timeout of the held event of the action, zero means the pipeline event timeout
+func (p *processor) holdTimeout(index int) time.Duration {
+	if index < 0 || index >= len(p.holdTimeouts) {
+		return 0
+	}
+	return p.holdTimeouts[index]
+}
+
+// flushBusyActions passes the timeout events to the busy actions, so the held events aren't lost on the pipeline stop.
+// The actions are flushed in order, so the events propagated to the next busy actions are flushed too.
+func (p *processor) flushBusyActions(stream *stream) {
+	p.isFlushing = true
+	for index := 0; index < len(p.actions) && p.busyActionsTotal != 0; index++ {
+		if !p.busyActions[index] {
+			continue
+		}
+		timeout := newTimeoutEvent(stream)
+		timeout.action = index
+		p.doActions(timeout)
+	}
+	p.isFlushing = false
+}
+
 func (p *processor) tryMarkBusy(index int) {
 	if p.busyActions[index] {
 		return
@@ -253,14 +305,11 @@ func (p *processor) countEvent(event *Event, actionIndex int, status eventStatus
 
 func (p *processor) isMatch(index int, event *Event) bool {
 	info := p.actionInfos[index]
-	conds := info.MatchConditions
-	mode := info.MatchMode
-	match := false
-
-	if mode == MatchModeOr || mode == MatchModeOrPrefix {
-		match = p.isMatchOr(conds, event, mode == MatchModeOrPrefix)
+	var match bool
+	if info.MatchExpr != nil {
+		match = info.MatchExpr.Match(event)
 	} else {
-		match = p.isMatchAnd(conds, event, mode == MatchModeAndPrefix)
+		match = info.MatchConditions.Match(event, info.MatchMode)
 	}
 
 	if info.MatchInvert {
@@ -270,55 +319,6 @@ func (p *processor) isMatch(index int, event *Event) bool {
 	return match
 }
 
-func (p *processor) isMatchOr(conds MatchConditions, event *Event, byPrefix bool) bool {
-	for _, cond := range conds {
-		node := event.Root.Dig(cond.Field...)
-		if node == nil {
-			continue
-		}
-		value := node.AsString()
-		match := false
-		if cond.Regexp != nil {
-			match = cond.Regexp.MatchString(value)
-			if match {
-				return true
-			}
-		}
-
-		match = cond.valueExists(value, byPrefix)
-		if match {
-			return true
-		}
-	}
-
-	return false
-}
-
-func (p *processor) isMatchAnd(conds MatchConditions, event *Event, byPrefix bool) bool {
-	for _, cond := range conds {
-		node := event.Root.Dig(cond.Field...)
-		if node == nil {
-			return false
-		}
-		value := node.AsString()
-
-		match := false
-		if cond.Regexp != nil {
-			match = cond.Regexp.MatchString(value)
-			if !match {
-				return false
-			}
-		}
-
-		match = cond.valueExists(value, byPrefix)
-		if !match {
-			return false
-		}
-	}
-
-	return true
-}
-
 func (p *processor) stop() {
 	p.streamer.unblockProcessor()
 
@@ -341,6 +341,39 @@ func (p *processor) Propagate(event *Event) {
 	p.processSequence(event)
 }
 
+// Spawn passes the events made of the nodes to the actions after the current one and then to the output.
+// The action should return ActionBreak for the parent after that, so the parent is committed after the children.
+// Children held by the next actions are flushed by the timeout events, so they are passed to the output before the parent.
+func (p *processor) Spawn(parent *Event, nodes []*insaneJSON.Node) {
+	parent.isChildParent = true
+	nextAction := parent.action + 1
+
+	for _, node := range nodes {
+		child := parent.spawnChild(node)
+		child.action = nextAction
+
+		passed, _ := p.doActions(child)
+		if !passed {
+			continue
+		}
+		if nextAction < len(p.actions) {
+			child.RecalcSize()
+		}
+		child.stage = eventStageOutput
+		p.output.Out(child)
+	}
+
+	for index := nextAction; index < len(p.actions) && p.busyActionsTotal != 0; index++ {
+		if !p.busyActions[index] {
+			continue
+		}
+		timeout := newTimeoutEvent(parent.stream)
+		timeout.action = index
+		timeout.isChild = true
+		p.doActions(timeout)
+	}
+}
+
 func (p *processor) RecoverFromPanic() {
 	p.recoverFromPanic()
 }
@@ -1,6 +1,7 @@
 package pipeline
 
 import (
+	"regexp"
 	"strconv"
 	"testing"
 
@@ -59,6 +60,20 @@ func Test_processor_isMatch(t *testing.T) {
 			MatchMode: MatchModeOrPrefix, Log: `{"k8s_pod": "address-api-abcd-123123", "ns": "map"}`,
 			MustMatch: true,
 		},
+		{
+			Conds: []MatchCondition{
+				{
+					Field:  []string{"status"},
+					Regexp: regexp.MustCompile(`^5\d\d$`),
+				},
+				{
+					Field:  []string{"ns"},
+					Values: []string{"map"},
+				},
+			},
+			MatchMode: MatchModeAnd, Log: `{"status": 503, "ns": "map"}`,
+			MustMatch: true,
+		},
 
 		// negative test cases
 		{
@@ -0,0 +1,383 @@
+package pipeline
+
+import (
+	"fmt"
+	"math/bits"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+const (
+	RouterType = "router"
+
+	maxRouterOutputs = 64
+)
+
+// Route passes the matched events to the outputs.
+type Route struct {
+	Outputs         []string
+	MatchConditions MatchConditions
+	MatchMode       MatchMode
+	// MatchExpr is used instead of the conditions if it's set
+	MatchExpr   *MatchExpr
+	MatchInvert bool
+	// Continue makes the next routes be checked after the event is matched
+	Continue bool
+}
+
+// RouterOptions sets the routes and the commit coordination of the router.
+type RouterOptions struct {
+	Routes []Route
+	// DefaultOutputs get the events matching no route, all the outputs are default ones if there are no routes
+	DefaultOutputs []string
+	// RequiredOutputs should commit the event before it's committed to the input,
+	// the others are best-effort ones, all the outputs are required if it's empty
+	RequiredOutputs []string
+	// CommitTimeout is the time after which the event is committed even if the outputs don't commit it,
+	// zero means no timeout
+	CommitTimeout time.Duration
+}
+
+// RouterOutput is the named output of the router.
+type RouterOutput struct {
+	Name string
+	Info *OutputPluginInfo
+}
+
+type route struct {
+	Route
+	// outputs is the bit mask of the output indexes
+	outputs uint64
+}
+
+// match checks the event by the expression if it's set, otherwise by the conditions, the invert isn't applied
+func (rt *route) match(event *Event) bool {
+	if rt.MatchExpr != nil {
+		return rt.MatchExpr.Match(event)
+	}
+	return rt.MatchConditions.Match(event, rt.MatchMode)
+}
+
+// routedEvent is the event which is committed after all the required outputs commit it or its copies
+type routedEvent struct {
+	event *Event
+	// pending is the number of the required outputs which don't commit the event yet
+	pending  int
+	routedAt time.Time
+}
+
+// routedCopy is the event or its copy passed to the output
+type routedCopy struct {
+	routed *routedEvent
+	output int
+}
+
+// Router passes each event to the outputs of the routes which match it, each output has its own batcher.
+// The event is passed to the first output and its copies are passed to the others since the outputs
+// own the events until they're committed. The event is committed after all the required outputs commit it
+// or after the commit timeout, the events are committed in the order they're passed to the router.
+// If some outputs aren't required or the timeout is set, the outputs may hold the events after the commit,
+// so all the outputs get the copies.
+type Router struct {
+	outputs         []RouterOutput
+	plugins         []OutputPlugin
+	routes          []route
+	defaultOutputs  uint64
+	requiredOutputs uint64
+	commitTimeout   time.Duration
+	cloneAll        bool
+
+	controller OutputPluginController
+	logger     *zap.SugaredLogger
+
+	mu sync.Mutex
+	// queue contains the events in the order they're passed to the router
+	queue []*routedEvent
+	// events contains the events and the copies which aren't committed by the outputs yet
+	events map[*Event]routedCopy
+	stopCh chan struct{}
+
+	eventsMetric   *prometheus.CounterVec
+	unroutedMetric prometheus.Counter
+	pendingMetrics []prometheus.Gauge
+	timeoutsMetric prometheus.Counter
+}
+
+// NewRouter checks the routes refer to the outputs. The events matching no route are passed
+// to the default outputs or committed if there are no default outputs.
+func NewRouter(outputs []RouterOutput, opts RouterOptions) (*Router, error) {
+	if len(outputs) == 0 {
+		return nil, fmt.Errorf("no outputs")
+	}
+	if len(outputs) > maxRouterOutputs {
+		return nil, fmt.Errorf("too many outputs: %d, max is %d", len(outputs), maxRouterOutputs)
+	}
+
+	r := &Router{
+		outputs: outputs,
+		plugins: make([]OutputPlugin, 0, len(outputs)),
+		routes:  make([]route, 0, len(opts.Routes)),
+		queue:   make([]*routedEvent, 0),
+		events:  make(map[*Event]routedCopy),
+		stopCh:  make(chan struct{}),
+
+		commitTimeout: opts.CommitTimeout,
+	}
+
+	indexes := make(map[string]int, len(outputs))
+	for i, output := range outputs {
+		if _, ok := indexes[output.Name]; ok {
+			return nil, fmt.Errorf("output %q is duplicated", output.Name)
+		}
+		indexes[output.Name] = i
+		r.plugins = append(r.plugins, output.Info.Plugin.(OutputPlugin))
+	}
+
+	mask := func(names []string) (uint64, error) {
+		var m uint64
+		for _, name := range names {
+			i, ok := indexes[name]
+			if !ok {
+				return 0, fmt.Errorf("unknown output %q", name)
+			}
+			m |= 1 << i
+		}
+		return m, nil
+	}
+
+	for i, rt := range opts.Routes {
+		if len(rt.Outputs) == 0 {
+			return nil, fmt.Errorf("route #%d has no outputs", i)
+		}
+		m, err := mask(rt.Outputs)
+		if err != nil {
+			return nil, fmt.Errorf("route #%d: %w", i, err)
+		}
+		r.routes = append(r.routes, route{Route: rt, outputs: m})
+	}
+
+	// the shift by 64 gives zero, so all the bits are set
+	all := uint64(1)<<len(outputs) - 1
+
+	m, err := mask(opts.DefaultOutputs)
+	if err != nil {
+		return nil, fmt.Errorf("default outputs: %w", err)
+	}
+	r.defaultOutputs = m
+	if len(opts.Routes) == 0 && len(opts.DefaultOutputs) == 0 {
+		r.defaultOutputs = all
+	}
+
+	m, err = mask(opts.RequiredOutputs)
+	if err != nil {
+		return nil, fmt.Errorf("required outputs: %w", err)
+	}
+	r.requiredOutputs = m
+	if len(opts.RequiredOutputs) == 0 {
+		r.requiredOutputs = all
+	}
+
+	r.cloneAll = r.requiredOutputs != all || r.commitTimeout > 0
+
+	return r, nil
+}
+
+func (r *Router) Start(_ AnyConfig, params *OutputPluginParams) {
+	r.controller = params.Controller
+	r.logger = params.Logger
+
+	r.eventsMetric = params.MetricCtl.RegisterCounter("router_events_total", "Number of events passed to the outputs", "output")
+	r.unroutedMetric = params.MetricCtl.RegisterCounter("router_unrouted_events_total",
+		"Number of events matching no route which are committed without sending").WithLabelValues()
+	r.timeoutsMetric = params.MetricCtl.RegisterCounter("router_commit_timeouts_total",
+		"Number of events committed by the timeout before all the required outputs commit them").WithLabelValues()
+	pendingMetric := params.MetricCtl.RegisterGauge("router_output_pending_events",
+		"Number of events passed to the output which aren't committed by it yet", "output")
+	r.pendingMetrics = make([]prometheus.Gauge, 0, len(r.outputs))
+	for _, output := range r.outputs {
+		r.pendingMetrics = append(r.pendingMetrics, pendingMetric.WithLabelValues(output.Name))
+	}
+
+	for i, output := range r.outputs {
+		r.logger.Infof("starting output %q of type %q", output.Name, output.Info.Type)
+		defaultParams := params.PluginDefaultParams
+		defaultParams.MetricCtl = params.MetricCtl.Named("output_" + output.Name)
+		r.plugins[i].Start(output.Info.Config, &OutputPluginParams{
+			PluginDefaultParams: defaultParams,
+			Controller:          routerController{router: r},
+			Logger:              params.Logger.Named(output.Name),
+			DeadLetter:          params.DeadLetter,
+			Health:              params.Health,
+			ErrorReporter:       params.ErrorReporter.Named(output.Name, output.Info.PluginStaticInfo),
+		})
+	}
+
+	if r.commitTimeout > 0 {
+		go r.expireLoop()
+	}
+}
+
+func (r *Router) Stop() {
+	for i, output := range r.outputs {
+		r.logger.Infof("stopping output %q", output.Name)
+		r.plugins[i].Stop()
+	}
+	close(r.stopCh)
+}
+
+func (r *Router) Out(event *Event) {
+	outputs := r.match(event)
+
+	// the copies are made before the lock since they're encoded and decoded
+	count := bits.OnesCount64(outputs)
+	events := make([]*Event, 0, count)
+	for i := 0; i < count; i++ {
+		e := event
+		if i > 0 || r.cloneAll {
+			e = event.Clone()
+		}
+		events = append(events, e)
+	}
+
+	r.mu.Lock()
+	routed := &routedEvent{event: event, pending: bits.OnesCount64(outputs & r.requiredOutputs)}
+	if r.commitTimeout > 0 {
+		routed.routedAt = time.Now()
+	}
+	r.queue = append(r.queue, routed)
+	if count == 0 {
+		r.unroutedMetric.Inc()
+	}
+	j := 0
+	for rest := outputs; rest != 0; rest &= rest - 1 {
+		r.events[events[j]] = routedCopy{routed: routed, output: bits.TrailingZeros64(rest)}
+		j++
+	}
+	if routed.pending == 0 {
+		// all the outputs get the copies, so the event can be committed before they commit them
+		r.commitQueueAndUnlock()
+	} else {
+		r.mu.Unlock()
+	}
+
+	for rest := outputs; rest != 0; rest &= rest - 1 {
+		i := bits.TrailingZeros64(rest)
+		r.eventsMetric.WithLabelValues(r.outputs[i].Name).Inc()
+		r.pendingMetrics[i].Inc()
+		r.plugins[i].Out(events[0])
+		events = events[1:]
+	}
+}
+
+// match returns the bit mask of the outputs of the event
+func (r *Router) match(event *Event) uint64 {
+	var outputs uint64
+	matched := false
+	for i := range r.routes {
+		rt := &r.routes[i]
+		if rt.match(event) == rt.MatchInvert {
+			continue
+		}
+
+		matched = true
+		outputs |= rt.outputs
+		if !rt.Continue {
+			break
+		}
+	}
+
+	if !matched {
+		return r.defaultOutputs
+	}
+	return outputs
+}
+
+func (r *Router) commit(event *Event) {
+	r.mu.Lock()
+	c, ok := r.events[event]
+	if !ok {
+		r.mu.Unlock()
+		r.logger.Panicf("event isn't routed: seq id=%d", event.SeqID)
+	}
+	delete(r.events, event)
+	r.pendingMetrics[c.output].Dec()
+	if event != c.routed.event {
+		event.ReleaseClone()
+	}
+	if r.requiredOutputs&(1<<c.output) == 0 {
+		r.mu.Unlock()
+		return
+	}
+
+	// the pending count is negative if the event is committed by the timeout
+	c.routed.pending--
+	if c.routed.pending != 0 {
+		r.mu.Unlock()
+		return
+	}
+	r.commitQueueAndUnlock()
+}
+
+func (r *Router) expireLoop() {
+	interval := r.commitTimeout / 4
+	if interval < 10*time.Millisecond {
+		interval = 10 * time.Millisecond
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			r.expire()
+		case <-r.stopCh:
+			return
+		}
+	}
+}
+
+// expire commits the events which aren't committed by the required outputs during the timeout
+func (r *Router) expire() {
+	r.mu.Lock()
+	for _, routed := range r.queue {
+		if routed.pending == 0 {
+			continue
+		}
+		// the queue is ordered by the routing time
+		if time.Since(routed.routedAt) < r.commitTimeout {
+			break
+		}
+		routed.pending = 0
+		r.timeoutsMetric.Inc()
+	}
+	r.commitQueueAndUnlock()
+}
+
+// commitQueueAndUnlock mu should be locked, it commits the events from the head of the queue
+// which are committed by all the outputs, so the order of the commits is kept
+func (r *Router) commitQueueAndUnlock() {
+	n := 0
+	for n < len(r.queue) && r.queue[n].pending == 0 {
+		r.controller.Commit(r.queue[n].event)
+		r.queue[n] = nil
+		n++
+	}
+	r.queue = r.queue[n:]
+	r.mu.Unlock()
+}
+
+// routerController receives the commits of the outputs of the router
+type routerController struct {
+	router *Router
+}
+
+func (c routerController) Commit(event *Event) {
+	c.router.commit(event)
+}
+
+func (c routerController) Error(err string) {
+	c.router.controller.Error(err)
+}
@@ -0,0 +1,276 @@
+package pipeline
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ozontech/file.d/logger"
+	"github.com/ozontech/file.d/metric"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	insaneJSON "github.com/vitkovskii/insane-json"
+)
+
+// routerTestOutput holds the events until they're committed by the test
+type routerTestOutput struct {
+	controller OutputPluginController
+
+	mu     sync.Mutex
+	events []*Event
+}
+
+func (o *routerTestOutput) Start(_ AnyConfig, params *OutputPluginParams) {
+	o.controller = params.Controller
+}
+
+func (o *routerTestOutput) Stop() {}
+
+func (o *routerTestOutput) Out(event *Event) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.events = append(o.events, event)
+}
+
+func (o *routerTestOutput) encoded() []string {
+	result := make([]string, 0, len(o.events))
+	for _, e := range o.events {
+		result = append(result, e.Root.EncodeToString())
+	}
+	return result
+}
+
+func (o *routerTestOutput) commitAll() {
+	for _, e := range o.events {
+		o.controller.Commit(e)
+	}
+	o.events = o.events[:0]
+}
+
+func newTestRouter(t *testing.T, names []string, opts RouterOptions) (*Router, map[string]*routerTestOutput, *[]uint64) {
+	t.Helper()
+
+	outputs := make([]RouterOutput, 0, len(names))
+	plugins := make(map[string]*routerTestOutput, len(names))
+	for _, name := range names {
+		plugin := &routerTestOutput{}
+		plugins[name] = plugin
+		outputs = append(outputs, RouterOutput{
+			Name: name,
+			Info: &OutputPluginInfo{
+				PluginStaticInfo:  &PluginStaticInfo{Type: "test"},
+				PluginRuntimeInfo: &PluginRuntimeInfo{Plugin: plugin},
+			},
+		})
+	}
+
+	router, err := NewRouter(outputs, opts)
+	require.NoError(t, err)
+
+	committed := make([]uint64, 0)
+	router.Start(nil, &OutputPluginParams{
+		PluginDefaultParams: PluginDefaultParams{
+			PipelineName: "test",
+			MetricCtl:    metric.New("test", prometheus.NewRegistry()),
+		},
+		Controller: &batcherTail{commit: func(event *Event) {
+			committed = append(committed, event.SeqID)
+		}},
+		Logger: logger.Instance,
+	})
+	t.Cleanup(router.Stop)
+
+	return router, plugins, &committed
+}
+
+func newRouterTestEvent(t *testing.T, seqID uint64, json string) *Event {
+	t.Helper()
+
+	root, err := insaneJSON.DecodeString(json)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		insaneJSON.Release(root)
+	})
+	return &Event{SeqID: seqID, Root: root}
+}
+
+func TestRouterRoutes(t *testing.T) {
+	routes := []Route{
+		{
+			Outputs:         []string{"errors"},
+			MatchConditions: MatchConditions{{Field: []string{"level"}, Values: []string{"error"}}},
+			MatchMode:       MatchModeAnd,
+			Continue:        true,
+		},
+		{
+			Outputs:         []string{"metrics"},
+			MatchConditions: MatchConditions{{Field: []string{"type"}, Values: []string{"metric"}}},
+			MatchMode:       MatchModeAnd,
+		},
+		{
+			Outputs:         []string{"archive"},
+			MatchConditions: MatchConditions{{Field: []string{"type"}, Values: []string{"metric"}}},
+			MatchMode:       MatchModeAnd,
+		},
+	}
+	router, outputs, committed := newTestRouter(t, []string{"archive", "errors", "metrics"}, RouterOptions{
+		Routes:         routes,
+		DefaultOutputs: []string{"archive"},
+	})
+
+	events := []string{
+		`{"level":"error"}`,
+		`{"type":"metric"}`,
+		`{"level":"error","type":"metric"}`,
+		`{"level":"info"}`,
+	}
+	for i, e := range events {
+		router.Out(newRouterTestEvent(t, uint64(i), e))
+	}
+
+	assert.Equal(t, []string{`{"level":"error"}`, `{"level":"error","type":"metric"}`}, outputs["errors"].encoded())
+	assert.Equal(t, []string{`{"type":"metric"}`, `{"level":"error","type":"metric"}`}, outputs["metrics"].encoded())
+	assert.Equal(t, []string{`{"level":"info"}`}, outputs["archive"].encoded(), "unmatched event is passed to the default output and the routes after the matched one are skipped")
+	assert.Empty(t, *committed)
+
+	for _, name := range []string{"archive", "errors", "metrics"} {
+		outputs[name].commitAll()
+	}
+	assert.Equal(t, []uint64{0, 1, 2, 3}, *committed)
+	assert.Equal(t, 2.0, testutil.ToFloat64(router.eventsMetric.WithLabelValues("errors")))
+	assert.Empty(t, router.events)
+	assert.Empty(t, router.queue)
+}
+
+func TestRouterCommitOrder(t *testing.T) {
+	routes := []Route{
+		{
+			Outputs:         []string{"fast", "slow"},
+			MatchConditions: MatchConditions{{Field: []string{"all"}, Values: []string{"true"}}},
+			MatchMode:       MatchModeAnd,
+		},
+	}
+	router, outputs, committed := newTestRouter(t, []string{"fast", "slow"}, RouterOptions{Routes: routes})
+
+	router.Out(newRouterTestEvent(t, 0, `{"all":"true"}`))
+	router.Out(newRouterTestEvent(t, 1, `{"all":"false"}`))
+	router.Out(newRouterTestEvent(t, 2, `{"all":"true"}`))
+
+	slow := outputs["slow"]
+	require.Len(t, slow.events, 2)
+	slow.controller.Commit(slow.events[1])
+	outputs["fast"].commitAll()
+	assert.Equal(t, []uint64{}, *committed, "nothing is committed until the slow output commits the first event")
+
+	slow.controller.Commit(slow.events[0])
+	assert.Equal(t, []uint64{0, 1, 2}, *committed)
+	assert.Equal(t, 1.0, testutil.ToFloat64(router.unroutedMetric))
+}
+
+func TestRouterUnrouted(t *testing.T) {
+	routes := []Route{
+		{
+			Outputs:         []string{"errors"},
+			MatchConditions: MatchConditions{{Field: []string{"level"}, Values: []string{"error"}}},
+			MatchMode:       MatchModeAnd,
+		},
+	}
+	router, outputs, committed := newTestRouter(t, []string{"errors"}, RouterOptions{Routes: routes})
+
+	router.Out(newRouterTestEvent(t, 0, `{"level":"info"}`))
+
+	assert.Empty(t, outputs["errors"].events)
+	assert.Equal(t, []uint64{0}, *committed, "event matching no route is committed")
+}
+
+func TestRouterTee(t *testing.T) {
+	router, outputs, committed := newTestRouter(t, []string{"new", "old"}, RouterOptions{})
+
+	router.Out(newRouterTestEvent(t, 0, `{"message":"a"}`))
+
+	assert.Equal(t, []string{`{"message":"a"}`}, outputs["new"].encoded())
+	assert.Equal(t, []string{`{"message":"a"}`}, outputs["old"].encoded())
+	assert.NotSame(t, outputs["new"].events[0], outputs["old"].events[0], "outputs share the event")
+
+	outputs["old"].commitAll()
+	assert.Empty(t, *committed)
+	outputs["new"].commitAll()
+	assert.Equal(t, []uint64{0}, *committed)
+}
+
+func TestRouterRequiredOutputs(t *testing.T) {
+	router, outputs, committed := newTestRouter(t, []string{"new", "old"}, RouterOptions{
+		RequiredOutputs: []string{"old"},
+	})
+
+	event := newRouterTestEvent(t, 0, `{"message":"a"}`)
+	router.Out(event)
+	assert.NotSame(t, event, outputs["new"].events[0], "best-effort output gets the original event")
+	assert.NotSame(t, event, outputs["old"].events[0], "required output gets the original event")
+
+	outputs["old"].commitAll()
+	assert.Equal(t, []uint64{0}, *committed, "event isn't committed without the best-effort output")
+	assert.Equal(t, 1.0, testutil.ToFloat64(router.pendingMetrics[0]))
+
+	outputs["new"].commitAll()
+	assert.Equal(t, []uint64{0}, *committed)
+	assert.Equal(t, 0.0, testutil.ToFloat64(router.pendingMetrics[0]))
+	assert.Empty(t, router.events)
+}
+
+func TestRouterCommitTimeout(t *testing.T) {
+	router, outputs, committed := newTestRouter(t, []string{"new", "old"}, RouterOptions{
+		CommitTimeout: 50 * time.Millisecond,
+	})
+
+	router.Out(newRouterTestEvent(t, 0, `{"message":"a"}`))
+	outputs["old"].commitAll()
+
+	router.mu.Lock()
+	assert.Empty(t, *committed)
+	router.mu.Unlock()
+
+	assert.Eventually(t, func() bool {
+		router.mu.Lock()
+		defer router.mu.Unlock()
+		return len(*committed) == 1
+	}, time.Second, 10*time.Millisecond, "event isn't committed after the timeout")
+	assert.Equal(t, 1.0, testutil.ToFloat64(router.timeoutsMetric))
+
+	// the late commit of the slow output is ignored
+	outputs["new"].commitAll()
+	assert.Equal(t, []uint64{0}, *committed)
+	assert.Empty(t, router.events)
+	assert.Empty(t, router.queue)
+}
+
+func TestNewRouterErrors(t *testing.T) {
+	output := RouterOutput{
+		Name: "out",
+		Info: &OutputPluginInfo{
+			PluginStaticInfo:  &PluginStaticInfo{Type: "test"},
+			PluginRuntimeInfo: &PluginRuntimeInfo{Plugin: &routerTestOutput{}},
+		},
+	}
+
+	tests := []struct {
+		name    string
+		outputs []RouterOutput
+		opts    RouterOptions
+	}{
+		{name: "no_outputs"},
+		{name: "duplicated_output", outputs: []RouterOutput{output, output}},
+		{name: "route_without_outputs", outputs: []RouterOutput{output}, opts: RouterOptions{Routes: []Route{{}}}},
+		{name: "unknown_route_output", outputs: []RouterOutput{output}, opts: RouterOptions{Routes: []Route{{Outputs: []string{"unknown"}}}}},
+		{name: "unknown_default_output", outputs: []RouterOutput{output}, opts: RouterOptions{DefaultOutputs: []string{"unknown"}}},
+		{name: "unknown_required_output", outputs: []RouterOutput{output}, opts: RouterOptions{RequiredOutputs: []string{"unknown"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewRouter(tt.outputs, tt.opts)
+			assert.Error(t, err)
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package pipeline
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// sourceLimiter counts the events of each input source which are processed by the pipeline,
+// so the source which events are stuck in the pipeline can't take all the events of the pool and stall the other sources.
+// It doesn't block the pipeline input since the events of the source may be held by the actions or the ordering lanes,
+// instead, the inputs reading many sources by the same goroutine check the limit and read the other sources meanwhile.
+type sourceLimiter struct {
+	limit int
+
+	mu      sync.Mutex
+	sources map[SourceID]*limitedSource
+
+	inflightMetric *prometheus.GaugeVec
+	waitsMetric    prometheus.Counter
+	// names are the source names which have the inflight metric
+	names map[string]struct{}
+}
+
+type limitedSource struct {
+	name     string
+	inflight int
+}
+
+// newSourceLimiter returns nil if the limit is zero, nil limiter doesn't limit the sources
+func newSourceLimiter(limit int, inflightMetric *prometheus.GaugeVec, waitsMetric prometheus.Counter) *sourceLimiter {
+	if limit <= 0 {
+		return nil
+	}
+
+	return &sourceLimiter{
+		limit:          limit,
+		sources:        make(map[SourceID]*limitedSource),
+		inflightMetric: inflightMetric,
+		waitsMetric:    waitsMetric,
+		names:          make(map[string]struct{}),
+	}
+}
+
+// acquire counts the event of the source, it never blocks
+func (l *sourceLimiter) acquire(id SourceID, name string) {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	src, has := l.sources[id]
+	if !has {
+		src = &limitedSource{name: name}
+		l.sources[id] = src
+	}
+
+	src.inflight++
+	if src.inflight == l.limit {
+		l.waitsMetric.Inc()
+	}
+}
+
+// isLimited returns true if the source has the limit events in the pipeline or more
+func (l *sourceLimiter) isLimited(id SourceID) bool {
+	if l == nil {
+		return false
+	}
+
+	return l.inflight(id) >= l.limit
+}
+
+// release is called for the events returned to the pool, the sources without the events are removed
+func (l *sourceLimiter) release(id SourceID) {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	src, has := l.sources[id]
+	if !has {
+		return
+	}
+
+	src.inflight--
+	if src.inflight <= 0 {
+		delete(l.sources, id)
+	}
+}
+
+// maintenance updates the per-source inflight metric, the sources with the same name are summed up
+func (l *sourceLimiter) maintenance() {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	inflight := make(map[string]int, len(l.sources))
+	for _, src := range l.sources {
+		inflight[src.name] += src.inflight
+	}
+	l.mu.Unlock()
+
+	for name := range l.names {
+		if _, has := inflight[name]; !has {
+			l.inflightMetric.DeleteLabelValues(name)
+			delete(l.names, name)
+		}
+	}
+	for name, n := range inflight {
+		l.inflightMetric.WithLabelValues(name).Set(float64(n))
+		l.names[name] = struct{}{}
+	}
+}
+
+// inflight returns the number of the events of the source in the pipeline
+func (l *sourceLimiter) inflight(id SourceID) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if src, has := l.sources[id]; has {
+		return src.inflight
+	}
+	return 0
+}
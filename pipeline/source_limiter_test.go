@@ -0,0 +1,89 @@
+package pipeline
+
+import (
+	"testing"
+
+	"github.com/ozontech/file.d/metric"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestSourceLimiter(limit int) *sourceLimiter {
+	ctl := metric.New("test", prometheus.NewRegistry())
+	return newSourceLimiter(limit,
+		ctl.RegisterGauge("source_inflight_events", "", "source_name"),
+		ctl.RegisterCounter("source_limit_waits_total", "").WithLabelValues(),
+	)
+}
+
+func TestSourceLimiter(t *testing.T) {
+	l := newTestSourceLimiter(2)
+
+	l.acquire(1, "stuck")
+	assert.False(t, l.isLimited(1))
+	l.acquire(1, "stuck")
+	assert.True(t, l.isLimited(1), "source at the limit isn't limited")
+
+	// the source over the limit isn't blocked, the input just postpones reading it
+	l.acquire(1, "stuck")
+	assert.Equal(t, 3, l.inflight(1))
+
+	// the other source isn't limited by the stuck one
+	l.acquire(2, "healthy")
+	assert.False(t, l.isLimited(2))
+	l.release(2)
+	assert.Equal(t, 0, l.inflight(2))
+
+	l.maintenance()
+	assert.Equal(t, 3.0, testutil.ToFloat64(l.inflightMetric.WithLabelValues("stuck")))
+	assert.Equal(t, 1, testutil.CollectAndCount(l.inflightMetric), "released source has the metric")
+	assert.Equal(t, 1.0, testutil.ToFloat64(l.waitsMetric))
+
+	l.release(1)
+	l.release(1)
+	assert.False(t, l.isLimited(1), "source isn't unlimited by the release")
+
+	l.release(1)
+	l.maintenance()
+	assert.Equal(t, 0, testutil.CollectAndCount(l.inflightMetric), "metric of the source without the events isn't deleted")
+}
+
+func TestSourceLimiterDisabled(t *testing.T) {
+	l := newTestSourceLimiter(0)
+	require.Nil(t, l)
+
+	// nil limiter doesn't limit the sources
+	for i := 0; i < 10; i++ {
+		l.acquire(1, "source")
+	}
+	assert.False(t, l.isLimited(1))
+	l.release(1)
+	l.maintenance()
+}
+
+func TestPipelineSourceLimit(t *testing.T) {
+	settings := &Settings{
+		Capacity:            5,
+		Decoder:             "json",
+		SourceInflightLimit: 2,
+	}
+	p := New("test", settings, prometheus.NewRegistry())
+	p.input = &TestInputPlugin{}
+
+	p.In(1, "source", 0, []byte(`{"a":1}`), false)
+	p.In(1, "source", 0, []byte(`not json`), false)
+	assert.Equal(t, 1, p.sourceLimiter.inflight(1), "unparsable event isn't released")
+	assert.False(t, p.IsSourceLimited(1))
+
+	// the input isn't blocked at the limit
+	p.In(1, "source", 0, []byte(`{"a":2}`), false)
+	assert.True(t, p.IsSourceLimited(1))
+
+	event := p.streamer.getStream(1, DefaultStreamName).first
+	require.NotNil(t, event)
+	p.backEvent(event)
+	assert.Equal(t, 1, p.sourceLimiter.inflight(1))
+	assert.False(t, p.IsSourceLimited(1))
+}
@@ -24,6 +24,8 @@ type stream struct {
 	streamID  StreamID
 	streamer  *streamer
 	blockTime time.Time
+	// blockTimeout overrides the event timeout of the streamer if it's set
+	blockTimeout time.Duration
 
 	mu   *sync.Mutex
 	cond *sync.Cond
@@ -127,13 +129,14 @@ func (s *stream) put(event *Event) uint64 {
 	return seqID
 }
 
-func (s *stream) blockGet() *Event {
+func (s *stream) blockGet(timeout time.Duration) *Event {
 	s.mu.Lock()
 	if !s.isAttached {
 		logger.Panicf("why wait get? stream isn't attached")
 	}
 	for s.first == nil {
 		s.blockTime = time.Now()
+		s.blockTimeout = timeout
 		s.streamer.makeBlocked(s)
 		s.cond.Wait()
 		s.streamer.resetBlocked(s)
@@ -167,7 +170,11 @@ func (s *stream) tryUnblock() bool {
 	}
 
 	s.mu.Lock()
-	if time.Since(s.blockTime) < s.streamer.eventTimeout {
+	timeout := s.streamer.eventTimeout
+	if s.blockTimeout > 0 {
+		timeout = s.blockTimeout
+	}
+	if time.Since(s.blockTime) < timeout {
 		s.mu.Unlock()
 		return false
 	}
@@ -197,12 +197,16 @@ func ParseLevelAsString(level string) string {
 // CreateNestedField creates nested field by the path.
 // For example, []string{"path.to", "object"} creates:
 // { "path.to": {"object": {} }
+// Existing objects on the path are kept, so the fields of the same object can be created one by one.
 // Warn: it overrides fields if it contains non-object type on the path. For example:
 // in: { "path.to": [{"userId":"12345"}] }, out: { "path.to": {"object": {}} }
 func CreateNestedField(root *insaneJSON.Root, path []string) *insaneJSON.Node {
 	curr := root.Node
-	for _, p := range path {
-		curr = curr.AddFieldNoAlloc(root, p).MutateToObject()
+	for i, p := range path {
+		curr = curr.AddFieldNoAlloc(root, p)
+		if i == len(path)-1 || !curr.IsObject() {
+			curr.MutateToObject()
+		}
 	}
 	return curr
 }
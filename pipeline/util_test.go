@@ -73,6 +73,14 @@ func TestCreateNestedFieldPositive(t *testing.T) {
 			},
 			Want: `{"a":{"b":{"c":{}}}}`,
 		},
+		{
+			Name: "keep siblings",
+			Args: Args{
+				Root: `{"a": {"b":{"d":1},"e":2}}`,
+				Path: []string{"a", "b", "c"},
+			},
+			Want: `{"a":{"b":{"d":1,"c":{}},"e":2}}`,
+		},
 		{
 			Name: "override object",
 			Args: Args{
@@ -0,0 +1,314 @@
+package convert
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/ozontech/file.d/cfg"
+	"github.com/ozontech/file.d/fd"
+	"github.com/ozontech/file.d/metric"
+	"github.com/ozontech/file.d/pipeline"
+	"github.com/prometheus/client_golang/prometheus"
+	insaneJSON "github.com/vitkovskii/insane-json"
+	"go.uber.org/zap"
+)
+
+/*{ introduction
+It converts the values of the event fields to the given type: `int`, `float`, `bool` or `string`.
+If the field is an array, each element of it is converted. Missing fields are skipped.
+
+Conversions:
+* `int` – from numbers without fraction, numeric strings and bools (`1`/`0`)
+* `float` – from numbers, numeric strings and bools (`1`/`0`)
+* `bool` – from bools, strings accepted by `strconv.ParseBool` (`true`, `false`, `1`, `0`, `t`, `f`...) and numbers `1`/`0`
+* `string` – from strings, numbers and bools
+
+Nulls, objects and nested arrays can't be converted.
+
+**Example:**
+```yaml
+pipelines:
+  example_pipeline:
+    ...
+    actions:
+    - type: convert
+      fields:
+        - field: user.id
+          type: int
+          on_failure: remove
+        - field: price
+          type: float
+          on_failure: default
+          default: "0"
+        - field: flags
+          type: bool
+          on_failure: tag
+    ...
+```
+
+The event:
+```json
+{"user":{"id":"42"},"price":"n/a","flags":["true","0","maybe"]}
+```
+
+Will be transformed to:
+```json
+{"user":{"id":42},"price":0,"flags":[true,false,"maybe"],"convert_error":"flags: can't convert \"maybe\" to bool"}
+```
+}*/
+
+const (
+	typeInt    = "int"
+	typeFloat  = "float"
+	typeBool   = "bool"
+	typeString = "string"
+
+	onFailureLeave   = "leave"
+	onFailureRemove  = "remove"
+	onFailureDefault = "default"
+	onFailureTag     = "tag"
+)
+
+type Plugin struct {
+	config *Config
+	logger *zap.SugaredLogger
+	fields []field
+}
+
+// field is the compiled FieldConfig
+type field struct {
+	name      string
+	path      []string
+	typ       string
+	convert   func(node *insaneJSON.Node) bool
+	onFailure string
+	def       string
+
+	// field metrics
+	convertedMetric prometheus.Counter
+	failedMetric    prometheus.Counter
+}
+
+// ! config-params
+// ^ config-params
+type Config struct {
+	// > @3@4@5@6
+	// >
+	// > The list of fields to convert. It's a list of objects, each of them has the fields:
+	// > * `field` – the path of the field, e.g. `user.id`
+	// > * `type` – the target type: `int`, `float`, `bool` or `string`
+	// > * `on_failure` – what to do if the value can't be converted:
+	// > `leave` – keep the value as is, `remove` – remove the field or the array element,
+	// > `default` – set the `default` value, `tag` – keep the value and put the error to `error_field`
+	// > * `default` – the value to set if `on_failure` is `default`, it should be convertible to `type`
+	Fields []FieldConfig `json:"fields" required:"true" slice:"true"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The field to put the conversion error to if `on_failure` is `tag`.
+	ErrorField  cfg.FieldSelector `json:"error_field" default:"convert_error" parse:"selector"` // *
+	ErrorField_ []string
+}
+
+type FieldConfig struct {
+	Field     string `json:"field" required:"true"`
+	Type      string `json:"type" required:"true" options:"int|float|bool|string"`
+	OnFailure string `json:"on_failure" default:"leave" options:"leave|remove|default|tag"`
+	Default   string `json:"default"`
+}
+
+func init() {
+	fd.DefaultPluginRegistry.RegisterAction(&pipeline.PluginStaticInfo{
+		Type:    "convert",
+		Factory: factory,
+	})
+}
+
+func factory() (pipeline.AnyPlugin, pipeline.AnyConfig) {
+	return &Plugin{}, &Config{}
+}
+
+func (p *Plugin) Start(config pipeline.AnyConfig, params *pipeline.ActionPluginParams) {
+	p.config = config.(*Config)
+	p.logger = params.Logger
+
+	if len(p.config.Fields) == 0 {
+		p.logger.Fatalf("fields should be set")
+	}
+
+	convertedMetric, failedMetric := p.registerMetrics(params.MetricCtl)
+	p.fields = make([]field, 0, len(p.config.Fields))
+	for _, fc := range p.config.Fields {
+		f := field{
+			name:            fc.Field,
+			path:            cfg.ParseFieldSelector(fc.Field),
+			typ:             fc.Type,
+			convert:         converters[fc.Type],
+			onFailure:       fc.OnFailure,
+			def:             fc.Default,
+			convertedMetric: convertedMetric.WithLabelValues(fc.Field),
+			failedMetric:    failedMetric.WithLabelValues(fc.Field),
+		}
+		if f.onFailure == onFailureDefault && !isConvertible(f.def, f.typ) {
+			p.logger.Fatalf("default value %q of field %q can't be converted to %s", f.def, f.name, f.typ)
+		}
+		p.fields = append(p.fields, f)
+	}
+}
+
+func (p *Plugin) registerMetrics(ctl *metric.Ctl) (*prometheus.CounterVec, *prometheus.CounterVec) {
+	converted := ctl.RegisterCounter("action_convert_converted_total", "Number of converted field values", "field")
+	failed := ctl.RegisterCounter("action_convert_failed_total", "Number of field values which can't be converted", "field")
+	return converted, failed
+}
+
+func (p *Plugin) Stop() {
+}
+
+func (p *Plugin) Do(event *pipeline.Event) pipeline.ActionResult {
+	for i := range p.fields {
+		f := &p.fields[i]
+		node := event.Root.Dig(f.path...)
+		if node == nil {
+			continue
+		}
+
+		if !node.IsArray() {
+			p.convertNode(event, f, node)
+			continue
+		}
+
+		// backward, so removed elements don't shift the rest ones
+		elements := node.AsArray()
+		for j := len(elements) - 1; j >= 0; j-- {
+			p.convertNode(event, f, elements[j])
+		}
+	}
+
+	return pipeline.ActionPass
+}
+
+func (p *Plugin) convertNode(event *pipeline.Event, f *field, node *insaneJSON.Node) {
+	if f.convert(node) {
+		f.convertedMetric.Inc()
+		return
+	}
+	f.failedMetric.Inc()
+
+	switch f.onFailure {
+	case onFailureRemove:
+		node.Suicide()
+	case onFailureDefault:
+		node.MutateToString(f.def)
+		f.convert(node)
+	case onFailureTag:
+		message := fmt.Sprintf("%s: can't convert %s to %s", f.name, node.EncodeToString(), f.typ)
+		pipeline.CreateNestedField(event.Root, p.config.ErrorField_).MutateToString(message)
+	}
+}
+
+var converters = map[string]func(node *insaneJSON.Node) bool{
+	typeInt:    toInt,
+	typeFloat:  toFloat,
+	typeBool:   toBool,
+	typeString: toString,
+}
+
+// isConvertible checks if the string node of the value can be converted, it's used to check default values
+func isConvertible(value string, typ string) bool {
+	root := insaneJSON.Spawn()
+	defer insaneJSON.Release(root)
+
+	return converters[typ](root.MutateToString(value))
+}
+
+func toInt(node *insaneJSON.Node) bool {
+	switch {
+	case node.IsNumber(), node.IsString():
+		value, ok := parseInt(strings.TrimSpace(node.AsString()))
+		if !ok {
+			return false
+		}
+		node.MutateToInt64(value)
+	case node.IsTrue():
+		node.MutateToInt(1)
+	case node.IsFalse():
+		node.MutateToInt(0)
+	default:
+		return false
+	}
+	return true
+}
+
+// parseInt parses integers and floats without fraction, e.g. `1e3` or `2.0`
+func parseInt(s string) (int64, bool) {
+	if value, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return value, true
+	}
+
+	value, err := strconv.ParseFloat(s, 64)
+	if err != nil || value != math.Trunc(value) || value < math.MinInt64 || value >= math.MaxInt64 {
+		return 0, false
+	}
+	return int64(value), true
+}
+
+func toFloat(node *insaneJSON.Node) bool {
+	switch {
+	case node.IsNumber():
+	case node.IsString():
+		value, err := strconv.ParseFloat(strings.TrimSpace(node.AsString()), 64)
+		if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+			return false
+		}
+		node.MutateToFloat(value)
+	case node.IsTrue():
+		node.MutateToFloat(1)
+	case node.IsFalse():
+		node.MutateToFloat(0)
+	default:
+		return false
+	}
+	return true
+}
+
+func toBool(node *insaneJSON.Node) bool {
+	switch {
+	case node.IsTrue(), node.IsFalse():
+	case node.IsString():
+		value, err := strconv.ParseBool(strings.TrimSpace(node.AsString()))
+		if err != nil {
+			return false
+		}
+		node.MutateToBool(value)
+	case node.IsNumber():
+		switch value, err := strconv.ParseFloat(node.AsString(), 64); {
+		case err == nil && value == 1:
+			node.MutateToBool(true)
+		case err == nil && value == 0:
+			node.MutateToBool(false)
+		default:
+			return false
+		}
+	default:
+		return false
+	}
+	return true
+}
+
+func toString(node *insaneJSON.Node) bool {
+	switch {
+	case node.IsString():
+	case node.IsNumber():
+		node.MutateToString(node.AsString())
+	case node.IsTrue():
+		node.MutateToString("true")
+	case node.IsFalse():
+		node.MutateToString("false")
+	default:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,101 @@
+package convert
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/ozontech/file.d/pipeline"
+	"github.com/ozontech/file.d/test"
+	"github.com/stretchr/testify/require"
+	insaneJSON "github.com/vitkovskii/insane-json"
+)
+
+func TestConverters(t *testing.T) {
+	cases := []struct {
+		typ   string
+		in    string
+		out   string
+		isErr bool
+	}{
+		{typ: typeInt, in: `"42"`, out: `42`},
+		{typ: typeInt, in: `" -7 "`, out: `-7`},
+		{typ: typeInt, in: `2.0`, out: `2`},
+		{typ: typeInt, in: `"1e3"`, out: `1000`},
+		{typ: typeInt, in: `true`, out: `1`},
+		{typ: typeInt, in: `2.5`, isErr: true},
+		{typ: typeInt, in: `"abc"`, isErr: true},
+		{typ: typeInt, in: `null`, isErr: true},
+
+		{typ: typeFloat, in: `"1.5"`, out: `1.5`},
+		{typ: typeFloat, in: `3`, out: `3`},
+		{typ: typeFloat, in: `false`, out: `0`},
+		{typ: typeFloat, in: `"NaN"`, isErr: true},
+		{typ: typeFloat, in: `{}`, isErr: true},
+
+		{typ: typeBool, in: `"true"`, out: `true`},
+		{typ: typeBool, in: `"0"`, out: `false`},
+		{typ: typeBool, in: `1`, out: `true`},
+		{typ: typeBool, in: `false`, out: `false`},
+		{typ: typeBool, in: `2`, isErr: true},
+		{typ: typeBool, in: `"yes"`, isErr: true},
+
+		{typ: typeString, in: `12.50`, out: `"12.50"`},
+		{typ: typeString, in: `true`, out: `"true"`},
+		{typ: typeString, in: `"a"`, out: `"a"`},
+		{typ: typeString, in: `[]`, isErr: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.typ+" "+tc.in, func(t *testing.T) {
+			root, err := insaneJSON.DecodeString(tc.in)
+			require.NoError(t, err)
+			defer insaneJSON.Release(root)
+
+			ok := converters[tc.typ](root.Node)
+			require.Equal(t, !tc.isErr, ok)
+			if ok {
+				require.Equal(t, tc.out, root.EncodeToString())
+			}
+		})
+	}
+}
+
+func TestConvert(t *testing.T) {
+	config := test.NewConfig(&Config{
+		Fields: []FieldConfig{
+			{Field: "user.id", Type: "int", OnFailure: "remove"},
+			{Field: "price", Type: "float", OnFailure: "default", Default: "0"},
+			{Field: "flags", Type: "bool", OnFailure: "tag"},
+			{Field: "tags", Type: "string", OnFailure: "remove"},
+			{Field: "code", Type: "int"},
+		},
+	}, nil)
+	p, input, output := test.NewPipelineMock(test.NewActionPluginStaticInfo(factory, config, pipeline.MatchModeAnd, nil, false))
+
+	events := []string{
+		`{"user":{"id":"42"},"price":"9.99","flags":["true","0"],"tags":[1,null,"a"],"code":"200"}`,
+		`{"user":{"id":"abc"},"price":"n/a","flags":["yes",true],"code":"x"}`,
+		`{"message":"no fields"}`,
+	}
+
+	wg := &sync.WaitGroup{}
+	wg.Add(len(events))
+	outEvents := make([]string, 0, len(events))
+	output.SetOutFn(func(e *pipeline.Event) {
+		outEvents = append(outEvents, e.Root.EncodeToString())
+		wg.Done()
+	})
+
+	for _, e := range events {
+		input.In(0, "test.log", 0, []byte(e))
+	}
+
+	wg.Wait()
+	p.Stop()
+
+	require.Equal(t, []string{
+		`{"user":{"id":42},"price":9.99,"flags":[true,false],"tags":["1","a"],"code":200}`,
+		`{"user":{},"price":0,"flags":["yes",true],"code":"x","convert_error":"flags: can't convert \"yes\" to bool"}`,
+		`{"message":"no fields"}`,
+	}, outEvents)
+}
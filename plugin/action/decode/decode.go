@@ -0,0 +1,219 @@
+package decode
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+
+	"github.com/ozontech/file.d/cfg"
+	"github.com/ozontech/file.d/fd"
+	"github.com/ozontech/file.d/metric"
+	"github.com/ozontech/file.d/pipeline"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+/*{ introduction
+It decodes the base64 or hex string of the event field.
+The decoded value replaces the field or it's put to `target_field`. If `parse_json` is set, the decoded value is parsed as JSON,
+so it works in front of `json_decode` for double-encoded payloads.
+
+Base64 values are decoded both with and without the padding.
+
+**Example:**
+```yaml
+pipelines:
+  example_pipeline:
+    ...
+    actions:
+    - type: decode
+      field: payload
+      encoding: base64
+      parse_json: true
+      on_failure: tag
+    ...
+```
+
+The event:
+```json
+{"payload":"eyJ1c2VyIjoiYWxpY2UifQ"}
+```
+
+Will be transformed to:
+```json
+{"payload":{"user":"alice"}}
+```
+}*/
+
+const (
+	encodingBase64    = "base64"
+	encodingBase64URL = "base64url"
+	encodingHex       = "hex"
+
+	onFailureTag     = "tag"
+	onFailureDiscard = "discard"
+)
+
+var (
+	errNotString = errors.New("value isn't a string")
+	errWrongJSON = errors.New("decoded value isn't JSON")
+)
+
+type Plugin struct {
+	config *Config
+	decode func(dst []byte, src string) ([]byte, error)
+
+	errorReporter *pipeline.ErrorReporter
+
+	// plugin metrics
+	failedMetric *prometheus.CounterVec
+}
+
+// ! config-params
+// ^ config-params
+type Config struct {
+	// > @3@4@5@6
+	// >
+	// > The event field to decode. Must be a string.
+	Field  cfg.FieldSelector `json:"field" parse:"selector" required:"true"` // *
+	Field_ []string
+
+	// > @3@4@5@6
+	// >
+	// > The encoding of the field: `base64` – the standard base64, `base64url` – the URL-safe base64, `hex` – hex.
+	Encoding string `json:"encoding" default:"base64" options:"base64|base64url|hex"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The field to put the decoded value to. If it's empty, `field` is replaced.
+	TargetField  cfg.FieldSelector `json:"target_field" parse:"selector"` // *
+	TargetField_ []string
+
+	// > @3@4@5@6
+	// >
+	// > If set, the decoded value is parsed as JSON and put as the object, the array or the scalar.
+	ParseJSON bool `json:"parse_json" default:"false"` // *
+
+	// > @3@4@5@6
+	// >
+	// > What to do if the value can't be decoded:
+	// > * `leave` – keep the event as is
+	// > * `tag` – put the error to `error_field`
+	// > * `discard` – drop the event
+	OnFailure string `json:"on_failure" default:"leave" options:"leave|tag|discard"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The field to put the error to if `on_failure` is `tag`.
+	ErrorField  cfg.FieldSelector `json:"error_field" default:"decode_error" parse:"selector"` // *
+	ErrorField_ []string
+}
+
+func init() {
+	fd.DefaultPluginRegistry.RegisterAction(&pipeline.PluginStaticInfo{
+		Type:    "decode",
+		Factory: factory,
+	})
+}
+
+func factory() (pipeline.AnyPlugin, pipeline.AnyConfig) {
+	return &Plugin{}, &Config{}
+}
+
+func (p *Plugin) Start(config pipeline.AnyConfig, params *pipeline.ActionPluginParams) {
+	p.config = config.(*Config)
+	p.errorReporter = params.ErrorReporter
+	p.registerMetrics(params.MetricCtl)
+
+	switch p.config.Encoding {
+	case encodingBase64:
+		p.decode = decodeBase64(base64.RawStdEncoding)
+	case encodingBase64URL:
+		p.decode = decodeBase64(base64.RawURLEncoding)
+	case encodingHex:
+		p.decode = decodeHex
+	}
+
+	if len(p.config.TargetField_) == 0 {
+		p.config.TargetField_ = p.config.Field_
+	}
+}
+
+func (p *Plugin) registerMetrics(ctl *metric.Ctl) {
+	p.failedMetric = ctl.RegisterCounter("action_decode_failed_total", "Number of field values which can't be decoded")
+}
+
+func (p *Plugin) Stop() {
+}
+
+func (p *Plugin) Do(event *pipeline.Event) pipeline.ActionResult {
+	node := event.Root.Dig(p.config.Field_...)
+	if node == nil {
+		return pipeline.ActionPass
+	}
+
+	if !node.IsString() {
+		return p.fail(event, errNotString)
+	}
+
+	// the decoded value is put to the event buffer, so it lives as long as the event
+	l := len(event.Buf)
+	buf, err := p.decode(event.Buf, node.AsString())
+	if err != nil {
+		return p.fail(event, err)
+	}
+	event.Buf = buf
+	value := event.Buf[l:]
+
+	if !p.config.ParseJSON {
+		pipeline.CreateNestedField(event.Root, p.config.TargetField_).MutateToString(pipeline.ByteToStringUnsafe(value))
+		return pipeline.ActionPass
+	}
+
+	decoded, err := event.SubparseJSON(value)
+	if err != nil {
+		return p.fail(event, errWrongJSON)
+	}
+	pipeline.CreateNestedField(event.Root, p.config.TargetField_).MutateToNode(decoded)
+
+	return pipeline.ActionPass
+}
+
+func (p *Plugin) fail(event *pipeline.Event, err error) pipeline.ActionResult {
+	p.failedMetric.WithLabelValues().Inc()
+	p.errorReporter.Report("can't decode "+p.config.Encoding+": "+err.Error(), event)
+
+	switch p.config.OnFailure {
+	case onFailureDiscard:
+		return pipeline.ActionDiscard
+	case onFailureTag:
+		pipeline.CreateNestedField(event.Root, p.config.ErrorField_).MutateToString("can't decode " + p.config.Encoding + ": " + err.Error())
+	}
+	return pipeline.ActionPass
+}
+
+// decodeBase64 decodes base64 with and without the padding, the encoding should be the raw one
+func decodeBase64(encoding *base64.Encoding) func(dst []byte, src string) ([]byte, error) {
+	return func(dst []byte, src string) ([]byte, error) {
+		for len(src) != 0 && src[len(src)-1] == '=' {
+			src = src[:len(src)-1]
+		}
+
+		l := len(dst)
+		dst = append(dst, make([]byte, encoding.DecodedLen(len(src)))...)
+		n, err := encoding.Decode(dst[l:], []byte(src))
+		if err != nil {
+			return nil, err
+		}
+		return dst[:l+n], nil
+	}
+}
+
+func decodeHex(dst []byte, src string) ([]byte, error) {
+	l := len(dst)
+	dst = append(dst, make([]byte, hex.DecodedLen(len(src)))...)
+	n, err := hex.Decode(dst[l:], []byte(src))
+	if err != nil {
+		return nil, err
+	}
+	return dst[:l+n], nil
+}
@@ -0,0 +1,122 @@
+package decode
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/ozontech/file.d/pipeline"
+	"github.com/ozontech/file.d/test"
+	"github.com/stretchr/testify/require"
+)
+
+func runEvents(t *testing.T, config *Config, events []string, outCount int) []string {
+	test.NewConfig(config, nil)
+	p, input, output := test.NewPipelineMock(test.NewActionPluginStaticInfo(factory, config, pipeline.MatchModeAnd, nil, false))
+
+	wg := &sync.WaitGroup{}
+	wg.Add(outCount)
+	outEvents := make([]string, 0, outCount)
+	output.SetOutFn(func(e *pipeline.Event) {
+		outEvents = append(outEvents, e.Root.EncodeToString())
+		wg.Done()
+	})
+
+	for _, e := range events {
+		input.In(0, "test.log", 0, []byte(e))
+	}
+
+	wg.Wait()
+	p.Stop()
+	return outEvents
+}
+
+func TestDecode(t *testing.T) {
+	cases := []struct {
+		name   string
+		config *Config
+		in     string
+		out    string
+	}{
+		{
+			name:   "base64 padded",
+			config: &Config{Field: "data"},
+			in:     `{"data":"aGVsbG8gd29ybGQ="}`,
+			out:    `{"data":"hello world"}`,
+		},
+		{
+			name:   "base64 unpadded",
+			config: &Config{Field: "data"},
+			in:     `{"data":"aGVsbG8gd29ybGQ"}`,
+			out:    `{"data":"hello world"}`,
+		},
+		{
+			name:   "base64url",
+			config: &Config{Field: "data", Encoding: "base64url"},
+			in:     `{"data":"Pz8_Pw"}`,
+			out:    `{"data":"????"}`,
+		},
+		{
+			name:   "hex to target field",
+			config: &Config{Field: "data", Encoding: "hex", TargetField: "decoded.data"},
+			in:     `{"data":"0a6869"}`,
+			out:    `{"data":"0a6869","decoded":{"data":"\nhi"}}`,
+		},
+		{
+			name:   "json object",
+			config: &Config{Field: "payload", ParseJSON: true},
+			in:     `{"payload":"eyJ1c2VyIjoiYWxpY2UifQ"}`,
+			out:    `{"payload":{"user":"alice"}}`,
+		},
+		{
+			name:   "json string",
+			config: &Config{Field: "payload", ParseJSON: true, TargetField: "inner"},
+			in:     `{"payload":"IntcImFcIjoxfSI="}`,
+			out:    `{"payload":"IntcImFcIjoxfSI=","inner":"{\"a\":1}"}`,
+		},
+		{
+			name:   "leave",
+			config: &Config{Field: "data"},
+			in:     `{"data":"!!!"}`,
+			out:    `{"data":"!!!"}`,
+		},
+		{
+			name:   "tag wrong encoding",
+			config: &Config{Field: "data", Encoding: "hex", OnFailure: "tag"},
+			in:     `{"data":"zz"}`,
+			out:    `{"data":"zz","decode_error":"can't decode hex: encoding/hex: invalid byte: U+007A 'z'"}`,
+		},
+		{
+			name:   "tag wrong json",
+			config: &Config{Field: "data", ParseJSON: true, OnFailure: "tag", ErrorField: "error"},
+			in:     `{"data":"aGVsbG8"}`,
+			out:    `{"data":"aGVsbG8","error":"can't decode base64: decoded value isn't JSON"}`,
+		},
+		{
+			name:   "tag not string",
+			config: &Config{Field: "data", OnFailure: "tag", ErrorField: "error"},
+			in:     `{"data":1}`,
+			out:    `{"data":1,"error":"can't decode base64: value isn't a string"}`,
+		},
+		{
+			name:   "missing field",
+			config: &Config{Field: "data", OnFailure: "discard"},
+			in:     `{"message":"a"}`,
+			out:    `{"message":"a"}`,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			out := runEvents(t, tc.config, []string{tc.in}, 1)
+			require.Equal(t, []string{tc.out}, out)
+		})
+	}
+}
+
+func TestDecodeDiscard(t *testing.T) {
+	out := runEvents(t, &Config{Field: "data", OnFailure: "discard"}, []string{
+		`{"data":"!!!"}`,
+		`{"data":"aGk="}`,
+	}, 1)
+	require.Equal(t, []string{`{"data":"hi"}`}, out)
+}
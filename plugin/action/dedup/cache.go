@@ -0,0 +1,100 @@
+package dedup
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+var (
+	// caches are shared across the plugin instances of all pipeline processors by the config
+	caches   = map[*Config]*cache{}
+	cachesMu = &sync.Mutex{}
+)
+
+type entry struct {
+	hash uint64
+	seen time.Time
+}
+
+// cache keeps the hashes of the passed events, it's bounded by the window and by the max size.
+// The entries are ordered by the time they are seen, so the oldest one is evicted first in both cases.
+type cache struct {
+	window  time.Duration
+	maxSize int
+
+	mu      sync.Mutex
+	entries map[uint64]*list.Element
+	order   *list.List
+
+	// refs is the number of plugin instances using the cache
+	refs int
+}
+
+// acquireCache returns the shared cache of the config, the first call creates it
+func acquireCache(config *Config) *cache {
+	cachesMu.Lock()
+	defer cachesMu.Unlock()
+
+	if c, has := caches[config]; has {
+		c.refs++
+		return c
+	}
+
+	c := &cache{
+		window:  config.Window_,
+		maxSize: config.MaxSize,
+		entries: make(map[uint64]*list.Element),
+		order:   list.New(),
+		refs:    1,
+	}
+	caches[config] = c
+
+	return c
+}
+
+func (c *cache) release(config *Config) {
+	cachesMu.Lock()
+	defer cachesMu.Unlock()
+
+	c.refs--
+	if c.refs > 0 {
+		return
+	}
+	delete(caches, config)
+}
+
+// isDuplicate returns true if the hash is seen within the window, otherwise the hash is remembered
+func (c *cache) isDuplicate(hash uint64, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.evict(now)
+
+	if _, has := c.entries[hash]; has {
+		return true
+	}
+
+	if len(c.entries) >= c.maxSize {
+		c.remove(c.order.Front())
+	}
+	c.entries[hash] = c.order.PushBack(&entry{hash: hash, seen: now})
+
+	return false
+}
+
+// evict removes the entries which are out of the window
+func (c *cache) evict(now time.Time) {
+	for {
+		front := c.order.Front()
+		if front == nil || now.Sub(front.Value.(*entry).seen) < c.window {
+			return
+		}
+		c.remove(front)
+	}
+}
+
+func (c *cache) remove(el *list.Element) {
+	delete(c.entries, el.Value.(*entry).hash)
+	c.order.Remove(el)
+}
@@ -0,0 +1,138 @@
+package dedup
+
+import (
+	"time"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/ozontech/file.d/cfg"
+	"github.com/ozontech/file.d/fd"
+	"github.com/ozontech/file.d/metric"
+	"github.com/ozontech/file.d/pipeline"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+/*{ introduction
+It discards the duplicate events. The event is the duplicate if the event with the same hash is passed within the `window`.
+The hash is calculated over the values of `fields` or over the whole event if `fields` isn't set.
+
+The hashes are shared by all processors of the pipeline. Their number is bounded by `max_size`,
+so the oldest hashes are forgotten earlier than the `window` ends under the high cardinality.
+
+**Example:**
+```yaml
+pipelines:
+  example_pipeline:
+    ...
+    actions:
+    - type: dedup
+      fields:
+      - request_id
+      - message
+      window: 30s
+      max_size: 100000
+    ...
+```
+}*/
+
+type Plugin struct {
+	config *Config
+	cache  *cache
+	fields [][]string
+
+	digest *xxhash.Digest
+	buf    []byte
+
+	// nowFn is used for testing purposes
+	nowFn func() time.Time
+
+	// plugin metrics
+	droppedMetric *prometheus.CounterVec
+}
+
+// ! config-params
+// ^ config-params
+type Config struct {
+	// > @3@4@5@6
+	// >
+	// > The list of the fields to calculate the hash over. If it's empty, the whole event is hashed.
+	// > The missing field is hashed as the empty value.
+	Fields []string `json:"fields" slice:"true"` // *
+
+	// > @3@4@5@6
+	// >
+	// > How long to remember the passed event hash.
+	Window  cfg.Duration `json:"window" default:"1m" parse:"duration"` // *
+	Window_ time.Duration
+
+	// > @3@4@5@6
+	// >
+	// > The max number of remembered hashes. The oldest hash is forgotten when the limit is reached.
+	MaxSize int `json:"max_size" default:"100000"` // *
+}
+
+func init() {
+	fd.DefaultPluginRegistry.RegisterAction(&pipeline.PluginStaticInfo{
+		Type:    "dedup",
+		Factory: factory,
+	})
+}
+
+func factory() (pipeline.AnyPlugin, pipeline.AnyConfig) {
+	return &Plugin{}, &Config{}
+}
+
+func (p *Plugin) Start(config pipeline.AnyConfig, params *pipeline.ActionPluginParams) {
+	p.config = config.(*Config)
+	p.registerMetrics(params.MetricCtl)
+
+	if p.config.Window_ <= 0 {
+		params.Logger.Fatalf("window should be positive")
+	}
+	if p.config.MaxSize <= 0 {
+		params.Logger.Fatalf("max_size should be positive")
+	}
+
+	p.fields = make([][]string, 0, len(p.config.Fields))
+	for _, field := range p.config.Fields {
+		p.fields = append(p.fields, cfg.ParseFieldSelector(field))
+	}
+
+	p.digest = xxhash.New()
+	p.nowFn = time.Now
+	p.cache = acquireCache(p.config)
+}
+
+func (p *Plugin) registerMetrics(ctl *metric.Ctl) {
+	p.droppedMetric = ctl.RegisterCounter("action_dedup_dropped_total", "Number of discarded duplicate events")
+}
+
+func (p *Plugin) Stop() {
+	p.cache.release(p.config)
+}
+
+func (p *Plugin) Do(event *pipeline.Event) pipeline.ActionResult {
+	if p.cache.isDuplicate(p.hash(event), p.nowFn()) {
+		p.droppedMetric.WithLabelValues().Inc()
+		return pipeline.ActionDiscard
+	}
+	return pipeline.ActionPass
+}
+
+func (p *Plugin) hash(event *pipeline.Event) uint64 {
+	if len(p.fields) == 0 {
+		p.buf = event.Root.Encode(p.buf[:0])
+		return xxhash.Sum64(p.buf)
+	}
+
+	p.digest.Reset()
+	for _, field := range p.fields {
+		// the encoded value is written, so the string "1" and the number 1 differ, missing field is empty
+		p.buf = p.buf[:0]
+		if node := event.Root.Dig(field...); node != nil {
+			p.buf = node.Encode(p.buf)
+		}
+		p.buf = append(p.buf, 0)
+		_, _ = p.digest.Write(p.buf)
+	}
+	return p.digest.Sum64()
+}
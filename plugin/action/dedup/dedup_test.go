@@ -0,0 +1,105 @@
+package dedup
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ozontech/file.d/pipeline"
+	"github.com/ozontech/file.d/test"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDedup(t *testing.T) {
+	cases := []struct {
+		name   string
+		config *Config
+		in     []string
+		out    []string
+	}{
+		{
+			name:   "whole event",
+			config: &Config{},
+			in:     []string{`{"a":1}`, `{"a":1}`, `{"a":2}`, `{"a":1,"b":1}`, `{"a":2}`},
+			out:    []string{`{"a":1}`, `{"a":2}`, `{"a":1,"b":1}`},
+		},
+		{
+			name:   "fields",
+			config: &Config{Fields: []string{"request_id", "msg"}},
+			in: []string{
+				`{"request_id":"1","msg":"hi","ts":1}`,
+				`{"request_id":"1","msg":"hi","ts":2}`,
+				`{"request_id":"2","msg":"hi","ts":3}`,
+				`{"request_id":1,"msg":"hi","ts":4}`,
+				`{"msg":"hi","ts":5}`,
+				`{"msg":"hi","ts":6}`,
+			},
+			out: []string{
+				`{"request_id":"1","msg":"hi","ts":1}`,
+				`{"request_id":"2","msg":"hi","ts":3}`,
+				`{"request_id":1,"msg":"hi","ts":4}`,
+				`{"msg":"hi","ts":5}`,
+			},
+		},
+		{
+			name:   "max size",
+			config: &Config{MaxSize: 1},
+			in:     []string{`{"a":1}`, `{"a":2}`, `{"a":1}`, `{"a":1}`},
+			out:    []string{`{"a":1}`, `{"a":2}`, `{"a":1}`},
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			test.NewConfig(tt.config, nil)
+			p, input, output := test.NewPipelineMock(test.NewActionPluginStaticInfo(factory, tt.config, pipeline.MatchModeAnd, nil, false))
+
+			wg := &sync.WaitGroup{}
+			wg.Add(len(tt.out))
+			outEvents := make([]string, 0, len(tt.out))
+			output.SetOutFn(func(e *pipeline.Event) {
+				outEvents = append(outEvents, e.Root.EncodeToString())
+				wg.Done()
+			})
+
+			for _, e := range tt.in {
+				input.In(0, "test.log", 0, []byte(e))
+			}
+
+			wg.Wait()
+			p.Stop()
+
+			require.Equal(t, tt.out, outEvents)
+		})
+	}
+}
+
+func TestCacheWindow(t *testing.T) {
+	config := &Config{Window_: time.Minute, MaxSize: 10}
+	c := acquireCache(config)
+	defer c.release(config)
+
+	now := time.Now()
+	require.False(t, c.isDuplicate(1, now))
+	require.False(t, c.isDuplicate(2, now.Add(time.Second*30)))
+	require.True(t, c.isDuplicate(1, now.Add(time.Second*59)))
+
+	// the first hash is out of the window, but the second one isn't
+	require.False(t, c.isDuplicate(1, now.Add(time.Minute)))
+	require.True(t, c.isDuplicate(2, now.Add(time.Minute)))
+	require.Len(t, c.entries, 2)
+
+	require.False(t, c.isDuplicate(3, now.Add(time.Hour)))
+	require.Len(t, c.entries, 1, "expired hashes should be evicted")
+}
+
+func TestCacheShared(t *testing.T) {
+	config := &Config{Window_: time.Minute, MaxSize: 10}
+	first := acquireCache(config)
+	second := acquireCache(config)
+	require.Same(t, first, second)
+
+	first.release(config)
+	second.release(config)
+	require.NotContains(t, caches, config)
+}
@@ -0,0 +1,128 @@
+package expression
+
+import (
+	"github.com/ozontech/file.d/cfg"
+	"github.com/ozontech/file.d/fd"
+	"github.com/ozontech/file.d/pipeline"
+	"go.uber.org/zap"
+)
+
+/*{ introduction
+It computes the derived fields by the expressions of the event fields. The expressions are compiled once on start,
+the rules are evaluated in order, so the next rules can use the fields of the previous ones.
+If the result is `null`, the field isn't set.
+
+**Example:**
+```yaml
+pipelines:
+  example_pipeline:
+    ...
+    actions:
+    - type: expression
+      rules:
+        - field: duration_ms
+          expression: "(end - start) * 1000"
+        - field: level
+          expression: "upper(coalesce(severity, 'info'))"
+        - field: is_error
+          expression: "status >= 500 || level == 'ERROR'"
+    ...
+```
+The event `{"start":1.5,"end":2,"severity":"error","status":200}` will be transformed to
+`{"start":1.5,"end":2,"severity":"error","status":200,"duration_ms":500,"level":"ERROR","is_error":true}`.
+
+**Grammar:**
+* literals: numbers `1`, `2.5`, `1e3`, strings `'text'` or `"text"`, `true`, `false`, `null`
+* fields: `user.id`, or `${user.first-name}` for the names which aren't identifiers, the missing fields are `null`
+* operators by precedence: `||`, `&&`, `== != < <= > >=`, `+ -`, `* / %`, unary `- !`, and the parentheses
+* functions: `upper(s)`, `lower(s)`, `trim(s)`, `len(s)`, `substr(s, start[, length])`, `contains(s, sub)`,
+`starts_with(s, prefix)`, `ends_with(s, suffix)`, `replace(s, old, new)`, `concat(a, ...)`,
+`string(x)`, `number(x)`, `int(x)`, `abs(x)`, `round(x)`, `floor(x)`, `ceil(x)`, `min(a, ...)`, `max(a, ...)`,
+`coalesce(a, ...)` – the first non-null argument, `if(cond, then, else)` – only the chosen branch is evaluated
+
+**Type coercion:**
+* the arithmetic operators convert the operands to numbers: the strings are parsed, `true` is `1` and `false` is `0`;
+if any operand can't be converted or the result isn't finite (e.g. the division by zero), the result is `null`
+* `+` concatenates if any operand is a string and the other one isn't `null`, `concat` treats `null` as the empty string
+* the comparison is numeric if both operands can be converted to numbers, otherwise the strings are compared;
+`null` is only equal to `null` and isn't ordered
+* `&&`, `||`, `!` and `if` treat `null`, `false`, `0` and `""` as false
+* the objects and the arrays of the event are `null`
+* the integral numbers are set as integers
+}*/
+
+type Plugin struct {
+	config *Config
+	logger *zap.SugaredLogger
+	rules  []rule
+}
+
+type rule struct {
+	field []string
+	eval  evalFn
+}
+
+// ! config-params
+// ^ config-params
+type Config struct {
+	// > @3@4@5@6
+	// >
+	// > The list of the rules. It's a list of objects, each of them has the fields:
+	// > * `field` – the field to put the result to, e.g. `request.duration_ms`
+	// > * `expression` – the expression to compute
+	Rules []RuleConfig `json:"rules" required:"true" slice:"true"` // *
+}
+
+type RuleConfig struct {
+	Field      string `json:"field" required:"true"`
+	Expression string `json:"expression" required:"true"`
+}
+
+func init() {
+	fd.DefaultPluginRegistry.RegisterAction(&pipeline.PluginStaticInfo{
+		Type:    "expression",
+		Factory: factory,
+	})
+}
+
+func factory() (pipeline.AnyPlugin, pipeline.AnyConfig) {
+	return &Plugin{}, &Config{}
+}
+
+func (p *Plugin) Start(config pipeline.AnyConfig, params *pipeline.ActionPluginParams) {
+	p.config = config.(*Config)
+	p.logger = params.Logger
+
+	if len(p.config.Rules) == 0 {
+		p.logger.Fatalf("rules should be set")
+	}
+
+	// the expressions are compiled per instance since they have the buffers
+	p.rules = make([]rule, 0, len(p.config.Rules))
+	for _, rc := range p.config.Rules {
+		eval, err := compile(rc.Expression)
+		if err != nil {
+			p.logger.Fatalf("can't compile expression %q of field %s: %s", rc.Expression, rc.Field, err.Error())
+		}
+		p.rules = append(p.rules, rule{
+			field: cfg.ParseFieldSelector(rc.Field),
+			eval:  eval,
+		})
+	}
+}
+
+func (p *Plugin) Stop() {
+}
+
+func (p *Plugin) Do(event *pipeline.Event) pipeline.ActionResult {
+	for i := range p.rules {
+		r := &p.rules[i]
+		result := r.eval(event.Root)
+		if result.kind == kindNull {
+			continue
+		}
+		result.set(pipeline.CreateNestedField(event.Root, r.field))
+	}
+
+	return pipeline.ActionPass
+}
@@ -0,0 +1,101 @@
+package expression
+
+import (
+	"testing"
+
+	"github.com/ozontech/file.d/pipeline"
+	"github.com/ozontech/file.d/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	insaneJSON "github.com/vitkovskii/insane-json"
+)
+
+func TestCompile(t *testing.T) {
+	event := `{"start":1.5,"end":2,"name":" Bob ","status":"503","ok":true,"user":{"first-name":"alice"},"obj":{"a":1},"zero":0}`
+
+	cases := []struct {
+		expression string
+		result     value
+	}{
+		{expression: `(end - start) * 1000`, result: numberValue(500)},
+		{expression: `1 + 2 * 3 - 4 / 2`, result: numberValue(5)},
+		{expression: `-(1 + 2) % 2`, result: numberValue(-1)},
+		{expression: `status + 1`, result: stringValue("5031")},
+		{expression: `status * 1 + 1`, result: numberValue(504)},
+		{expression: `ok + 1`, result: numberValue(2)},
+		{expression: `end / zero`, result: null},
+		{expression: `missing + 1`, result: null},
+		{expression: `name - 1`, result: null},
+		{expression: `obj`, result: null},
+		{expression: `upper(trim(name))`, result: stringValue("BOB")},
+		{expression: `lower("A") + 'b\'c'`, result: stringValue("ab'c")},
+		{expression: `len(${user.first-name})`, result: numberValue(5)},
+		{expression: `substr("hello", 1, 3)`, result: stringValue("ell")},
+		{expression: `substr("hello", 3)`, result: stringValue("lo")},
+		{expression: `replace(name, " ", "_")`, result: stringValue("_Bob_")},
+		{expression: `concat(user.first-name, "-", 1)`, result: stringValue("-1")},
+		{expression: `concat(${user.first-name}, "-", 1, ok)`, result: stringValue("alice-1true")},
+		{expression: `coalesce(missing, null, "x")`, result: stringValue("x")},
+		{expression: `if(status >= 500, "error", missing.field)`, result: stringValue("error")},
+		{expression: `int(-2.7) + round(2.5) + floor(1.5) + ceil(1.2) + abs(-1)`, result: numberValue(5)},
+		{expression: `max(1, "3", missing, 2)`, result: stringValue("3")},
+		{expression: `min(3, 1, 2)`, result: numberValue(1)},
+		{expression: `status == 503 && status != "504"`, result: boolValue(true)},
+		{expression: `"b" > "a" && "10" > "9" && !(1 > 2)`, result: boolValue(true)},
+		{expression: `missing == null || missing < 1`, result: boolValue(true)},
+		{expression: `missing < 1`, result: boolValue(false)},
+		{expression: `contains(name, "ob") && starts_with("abc", "a") && ends_with("abc", "c")`, result: boolValue(true)},
+		{expression: `number("1e3") + string(1.5)`, result: stringValue("10001.5")},
+	}
+
+	root, err := insaneJSON.DecodeString(event)
+	require.NoError(t, err)
+	defer insaneJSON.Release(root)
+
+	for _, tt := range cases {
+		t.Run(tt.expression, func(t *testing.T) {
+			eval, err := compile(tt.expression)
+			require.NoError(t, err)
+			assert.Equal(t, tt.result, eval(root))
+		})
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	for _, expression := range []string{
+		``,
+		`1 +`,
+		`(1 + 2`,
+		`1 2`,
+		`"unclosed`,
+		`${unclosed`,
+		`unknown(1)`,
+		`upper(1, 2)`,
+		`if(1, 2)`,
+		`1 # 2`,
+		`1 < 2 < 3`,
+	} {
+		_, err := compile(expression)
+		assert.Error(t, err, "expression %q should fail", expression)
+	}
+}
+
+func TestExpression(t *testing.T) {
+	config := &Config{Rules: []RuleConfig{
+		{Field: "duration_ms", Expression: "(end - start) * 1000"},
+		{Field: "level", Expression: "upper(coalesce(severity, 'info'))"},
+		{Field: "flags.is_error", Expression: "status >= 500 || level == 'ERROR'"},
+		{Field: "ratio", Expression: "end / start"},
+		{Field: "missing", Expression: "missing"},
+	}}
+	test.NewConfig(config, nil)
+	p := &Plugin{}
+	p.Start(config, test.NewEmptyActionPluginParams())
+
+	root, err := insaneJSON.DecodeString(`{"start":1.5,"end":3,"severity":"error","status":200}`)
+	require.NoError(t, err)
+	defer insaneJSON.Release(root)
+
+	p.Do(&pipeline.Event{Root: root})
+	assert.Equal(t, `{"start":1.5,"end":3,"severity":"error","status":200,"duration_ms":1500,"level":"ERROR","flags":{"is_error":true},"ratio":2}`, root.EncodeToString())
+}
@@ -0,0 +1,172 @@
+package expression
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"unicode/utf8"
+
+	insaneJSON "github.com/vitkovskii/insane-json"
+)
+
+type function struct {
+	minArgs int
+	// maxArgs is -1 for any number of arguments
+	maxArgs int
+	fn      func(args []value) value
+}
+
+var functions = map[string]function{
+	"upper": {1, 1, stringFn(strings.ToUpper)},
+	"lower": {1, 1, stringFn(strings.ToLower)},
+	"trim":  {1, 1, stringFn(strings.TrimSpace)},
+	"len": {1, 1, func(args []value) value {
+		if args[0].kind == kindNull {
+			return null
+		}
+		return numberValue(float64(utf8.RuneCountInString(args[0].toString())))
+	}},
+	"substr": {2, 3, substr},
+	"contains": {2, 2, func(args []value) value {
+		return boolValue(strings.Contains(args[0].toString(), args[1].toString()))
+	}},
+	"starts_with": {2, 2, func(args []value) value {
+		return boolValue(strings.HasPrefix(args[0].toString(), args[1].toString()))
+	}},
+	"ends_with": {2, 2, func(args []value) value {
+		return boolValue(strings.HasSuffix(args[0].toString(), args[1].toString()))
+	}},
+	"replace": {3, 3, func(args []value) value {
+		if args[0].kind == kindNull {
+			return null
+		}
+		return stringValue(strings.ReplaceAll(args[0].toString(), args[1].toString(), args[2].toString()))
+	}},
+	"concat": {1, -1, func(args []value) value {
+		b := strings.Builder{}
+		for _, arg := range args {
+			b.WriteString(arg.toString())
+		}
+		return stringValue(b.String())
+	}},
+	"string": {1, 1, func(args []value) value {
+		if args[0].kind == kindNull {
+			return null
+		}
+		return stringValue(args[0].toString())
+	}},
+	"number": {1, 1, numberFn(func(x float64) float64 { return x })},
+	"int":    {1, 1, numberFn(math.Trunc)},
+	"abs":    {1, 1, numberFn(math.Abs)},
+	"round":  {1, 1, numberFn(math.Round)},
+	"floor":  {1, 1, numberFn(math.Floor)},
+	"ceil":   {1, 1, numberFn(math.Ceil)},
+	"min": {1, -1, func(args []value) value {
+		return extremum(args, -1)
+	}},
+	"max": {1, -1, func(args []value) value {
+		return extremum(args, 1)
+	}},
+	"coalesce": {1, -1, func(args []value) value {
+		for _, arg := range args {
+			if arg.kind != kindNull {
+				return arg
+			}
+		}
+		return null
+	}},
+}
+
+// call returns the function call, `if` is evaluated lazily
+func call(name token, args []evalFn) (evalFn, error) {
+	if name.text == "if" {
+		if len(args) != 3 {
+			return nil, fmt.Errorf("function if at %d expects 3 arguments, got %d", name.pos, len(args))
+		}
+		cond, then, otherwise := args[0], args[1], args[2]
+		return func(root *insaneJSON.Root) value {
+			if cond(root).isTrue() {
+				return then(root)
+			}
+			return otherwise(root)
+		}, nil
+	}
+
+	f, ok := functions[name.text]
+	if !ok {
+		return nil, fmt.Errorf("unknown function %s at %d", name.text, name.pos)
+	}
+	if len(args) < f.minArgs || f.maxArgs >= 0 && len(args) > f.maxArgs {
+		return nil, fmt.Errorf("wrong number of arguments of function %s at %d: %d", name.text, name.pos, len(args))
+	}
+
+	// the buffer is reused since the plugin instance compiles its own expressions
+	values := make([]value, len(args))
+	return func(root *insaneJSON.Root) value {
+		for i, arg := range args {
+			values[i] = arg(root)
+		}
+		return f.fn(values)
+	}, nil
+}
+
+func stringFn(fn func(string) string) func(args []value) value {
+	return func(args []value) value {
+		if args[0].kind == kindNull {
+			return null
+		}
+		return stringValue(fn(args[0].toString()))
+	}
+}
+
+func numberFn(fn func(float64) float64) func(args []value) value {
+	return func(args []value) value {
+		x, ok := args[0].toNumber()
+		if !ok {
+			return null
+		}
+		return numberValue(fn(x))
+	}
+}
+
+// substr returns the part of the string by the character positions
+func substr(args []value) value {
+	if args[0].kind == kindNull {
+		return null
+	}
+	runes := []rune(args[0].toString())
+	start, ok := args[1].toNumber()
+	if !ok {
+		return null
+	}
+	end := float64(len(runes))
+	if len(args) == 3 {
+		length, ok := args[2].toNumber()
+		if !ok {
+			return null
+		}
+		end = start + length
+	}
+
+	from := clamp(int(start), 0, len(runes))
+	to := clamp(int(end), from, len(runes))
+	return stringValue(string(runes[from:to]))
+}
+
+func clamp(x, low, high int) int {
+	return max(low, min(x, high))
+}
+
+// extremum returns the min for the negative sign and the max for the positive one, null values are skipped
+func extremum(args []value, sign int) value {
+	result := null
+	for _, arg := range args {
+		if arg.kind == kindNull {
+			continue
+		}
+		if result.kind == kindNull || compare(arg, result)*sign > 0 {
+			result = arg
+		}
+	}
+	return result
+}
@@ -0,0 +1,419 @@
+package expression
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/ozontech/file.d/cfg"
+	insaneJSON "github.com/vitkovskii/insane-json"
+)
+
+// evalFn is the compiled expression, it has no loops, so the evaluation time is bounded by the expression size
+type evalFn func(root *insaneJSON.Root) value
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenNumber
+	tokenString
+	tokenIdent
+	tokenField
+	tokenOp
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+// lex splits the expression into the tokens
+func lex(s string) ([]token, error) {
+	tokens := make([]token, 0)
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c >= '0' && c <= '9':
+			start := i
+			for i < len(s) && (s[i] >= '0' && s[i] <= '9' || s[i] == '.' || s[i] == 'e' || s[i] == 'E' ||
+				(s[i] == '-' || s[i] == '+') && (s[i-1] == 'e' || s[i-1] == 'E')) {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokenNumber, text: s[start:i], pos: start})
+		case c == '"' || c == '\'':
+			str, n, err := lexString(s[i:])
+			if err != nil {
+				return nil, fmt.Errorf("%w at %d", err, i)
+			}
+			tokens = append(tokens, token{kind: tokenString, text: str, pos: i})
+			i += n
+		case c == '$' && i+1 < len(s) && s[i+1] == '{':
+			end := strings.IndexByte(s[i:], '}')
+			if end < 0 {
+				return nil, fmt.Errorf("unclosed field at %d", i)
+			}
+			tokens = append(tokens, token{kind: tokenField, text: s[i+2 : i+end], pos: i})
+			i += end + 1
+		case isIdentStart(c):
+			start := i
+			for i < len(s) && (isIdentStart(s[i]) || s[i] >= '0' && s[i] <= '9' || s[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokenIdent, text: s[start:i], pos: start})
+		default:
+			op := ""
+			for _, candidate := range []string{"==", "!=", "<=", ">=", "&&", "||", "+", "-", "*", "/", "%", "<", ">", "!", "(", ")", ","} {
+				if strings.HasPrefix(s[i:], candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("unexpected symbol %q at %d", c, i)
+			}
+			tokens = append(tokens, token{kind: tokenOp, text: op, pos: i})
+			i += len(op)
+		}
+	}
+
+	return append(tokens, token{kind: tokenEOF, pos: len(s)}), nil
+}
+
+func isIdentStart(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_'
+}
+
+// lexString returns the unquoted string and the length of the quoted one
+func lexString(s string) (string, int, error) {
+	quote := s[0]
+	b := strings.Builder{}
+	for i := 1; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == quote:
+			return b.String(), i + 1, nil
+		case c == '\\' && i+1 < len(s):
+			i++
+			switch s[i] {
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			default:
+				b.WriteByte(s[i])
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", 0, fmt.Errorf("unclosed string")
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+// compile parses the expression to the tree of the functions
+func compile(s string) (evalFn, error) {
+	tokens, err := lex(s)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens}
+	fn, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokenEOF {
+		return nil, fmt.Errorf("unexpected %q at %d", t.text, t.pos)
+	}
+	return fn, nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+// acceptOp skips the operator if it's one of the provided ones
+func (p *parser) acceptOp(ops ...string) (string, bool) {
+	t := p.peek()
+	if t.kind != tokenOp {
+		return "", false
+	}
+	for _, op := range ops {
+		if t.text == op {
+			p.pos++
+			return op, true
+		}
+	}
+	return "", false
+}
+
+func (p *parser) expectOp(op string) error {
+	if _, ok := p.acceptOp(op); !ok {
+		t := p.peek()
+		return fmt.Errorf("expected %q at %d", op, t.pos)
+	}
+	return nil
+}
+
+func (p *parser) parseOr() (evalFn, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if _, ok := p.acceptOp("||"); !ok {
+			return left, nil
+		}
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(root *insaneJSON.Root) value {
+			return boolValue(l(root).isTrue() || right(root).isTrue())
+		}
+	}
+}
+
+func (p *parser) parseAnd() (evalFn, error) {
+	left, err := p.parseComparison()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if _, ok := p.acceptOp("&&"); !ok {
+			return left, nil
+		}
+		right, err := p.parseComparison()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(root *insaneJSON.Root) value {
+			return boolValue(l(root).isTrue() && right(root).isTrue())
+		}
+	}
+}
+
+func (p *parser) parseComparison() (evalFn, error) {
+	left, err := p.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+	op, ok := p.acceptOp("==", "!=", "<=", ">=", "<", ">")
+	if !ok {
+		return left, nil
+	}
+	right, err := p.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+
+	switch op {
+	case "==":
+		return func(root *insaneJSON.Root) value { return boolValue(equal(left(root), right(root))) }, nil
+	case "!=":
+		return func(root *insaneJSON.Root) value { return boolValue(!equal(left(root), right(root))) }, nil
+	}
+	return func(root *insaneJSON.Root) value {
+		a, b := left(root), right(root)
+		if a.kind == kindNull || b.kind == kindNull {
+			return boolValue(false)
+		}
+		c := compare(a, b)
+		switch op {
+		case "<":
+			return boolValue(c < 0)
+		case "<=":
+			return boolValue(c <= 0)
+		case ">":
+			return boolValue(c > 0)
+		default:
+			return boolValue(c >= 0)
+		}
+	}, nil
+}
+
+func (p *parser) parseAdditive() (evalFn, error) {
+	left, err := p.parseMultiplicative()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := p.acceptOp("+", "-")
+		if !ok {
+			return left, nil
+		}
+		right, err := p.parseMultiplicative()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		if op == "+" {
+			left = func(root *insaneJSON.Root) value { return add(l(root), right(root)) }
+			continue
+		}
+		left = arithmetic(l, right, func(x, y float64) float64 { return x - y })
+	}
+}
+
+func (p *parser) parseMultiplicative() (evalFn, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := p.acceptOp("*", "/", "%")
+		if !ok {
+			return left, nil
+		}
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		switch op {
+		case "*":
+			left = arithmetic(left, right, func(x, y float64) float64 { return x * y })
+		case "/":
+			// the division by zero is infinity or NaN, so the result is null
+			left = arithmetic(left, right, func(x, y float64) float64 { return x / y })
+		default:
+			left = arithmetic(left, right, math.Mod)
+		}
+	}
+}
+
+func (p *parser) parseUnary() (evalFn, error) {
+	op, ok := p.acceptOp("-", "!")
+	if !ok {
+		return p.parsePrimary()
+	}
+	operand, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	if op == "!" {
+		return func(root *insaneJSON.Root) value { return boolValue(!operand(root).isTrue()) }, nil
+	}
+	return func(root *insaneJSON.Root) value {
+		x, ok := operand(root).toNumber()
+		if !ok {
+			return null
+		}
+		return numberValue(-x)
+	}, nil
+}
+
+func (p *parser) parsePrimary() (evalFn, error) {
+	t := p.next()
+	switch t.kind {
+	case tokenNumber:
+		num, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("wrong number %q at %d", t.text, t.pos)
+		}
+		return constant(numberValue(num)), nil
+	case tokenString:
+		return constant(stringValue(t.text)), nil
+	case tokenField:
+		return field(cfg.ParseFieldSelector(t.text)), nil
+	case tokenIdent:
+		if _, ok := p.acceptOp("("); ok {
+			return p.parseCall(t)
+		}
+		switch t.text {
+		case "true":
+			return constant(boolValue(true)), nil
+		case "false":
+			return constant(boolValue(false)), nil
+		case "null":
+			return constant(null), nil
+		}
+		return field(strings.Split(t.text, ".")), nil
+	case tokenOp:
+		if t.text == "(" {
+			fn, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			return fn, p.expectOp(")")
+		}
+	case tokenEOF:
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+	return nil, fmt.Errorf("unexpected %q at %d", t.text, t.pos)
+}
+
+func (p *parser) parseCall(name token) (evalFn, error) {
+	args := make([]evalFn, 0)
+	if _, ok := p.acceptOp(")"); !ok {
+		for {
+			arg, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+			if _, ok := p.acceptOp(","); ok {
+				continue
+			}
+			if err := p.expectOp(")"); err != nil {
+				return nil, err
+			}
+			break
+		}
+	}
+
+	return call(name, args)
+}
+
+func constant(v value) evalFn {
+	return func(_ *insaneJSON.Root) value { return v }
+}
+
+func field(path []string) evalFn {
+	return func(root *insaneJSON.Root) value { return nodeValue(root.Dig(path...)) }
+}
+
+// add concatenates the strings and sums the other values
+func add(a, b value) value {
+	if a.kind == kindString || b.kind == kindString {
+		if a.kind == kindNull || b.kind == kindNull {
+			return null
+		}
+		return stringValue(a.toString() + b.toString())
+	}
+	x, okX := a.toNumber()
+	y, okY := b.toNumber()
+	if !okX || !okY {
+		return null
+	}
+	return numberValue(x + y)
+}
+
+func arithmetic(left, right evalFn, op func(x, y float64) float64) evalFn {
+	return func(root *insaneJSON.Root) value {
+		x, okX := left(root).toNumber()
+		y, okY := right(root).toNumber()
+		if !okX || !okY {
+			return null
+		}
+		return numberValue(op(x, y))
+	}
+}
@@ -0,0 +1,161 @@
+package expression
+
+import (
+	"math"
+	"strconv"
+
+	insaneJSON "github.com/vitkovskii/insane-json"
+)
+
+type kind int
+
+const (
+	kindNull kind = iota
+	kindNumber
+	kindString
+	kindBool
+)
+
+// value is the result of the expression, the numbers are float64 as in JSON
+type value struct {
+	kind kind
+	num  float64
+	str  string
+	b    bool
+}
+
+var null = value{}
+
+func numberValue(num float64) value {
+	if math.IsNaN(num) || math.IsInf(num, 0) {
+		return null
+	}
+	return value{kind: kindNumber, num: num}
+}
+
+func stringValue(str string) value {
+	return value{kind: kindString, str: str}
+}
+
+func boolValue(b bool) value {
+	return value{kind: kindBool, b: b}
+}
+
+// nodeValue converts the event field to the value, the objects and the arrays are null
+func nodeValue(node *insaneJSON.Node) value {
+	switch {
+	case node == nil || node.IsNull():
+		return null
+	case node.IsString():
+		return stringValue(node.AsString())
+	case node.IsNumber():
+		num, err := strconv.ParseFloat(node.AsString(), 64)
+		if err != nil {
+			return null
+		}
+		return numberValue(num)
+	case node.IsTrue():
+		return boolValue(true)
+	case node.IsFalse():
+		return boolValue(false)
+	default:
+		return null
+	}
+}
+
+// toNumber converts the value to the number, the strings are parsed and the booleans are 1 and 0
+func (v value) toNumber() (float64, bool) {
+	switch v.kind {
+	case kindNumber:
+		return v.num, true
+	case kindString:
+		num, err := strconv.ParseFloat(v.str, 64)
+		return num, err == nil
+	case kindBool:
+		if v.b {
+			return 1, true
+		}
+		return 0, true
+	default:
+		return 0, false
+	}
+}
+
+// toString converts the value to the string, null is the empty string
+func (v value) toString() string {
+	switch v.kind {
+	case kindNumber:
+		return strconv.FormatFloat(v.num, 'f', -1, 64)
+	case kindString:
+		return v.str
+	case kindBool:
+		return strconv.FormatBool(v.b)
+	default:
+		return ""
+	}
+}
+
+// isTrue returns false for null, false, zero and the empty string
+func (v value) isTrue() bool {
+	switch v.kind {
+	case kindNumber:
+		return v.num != 0
+	case kindString:
+		return v.str != ""
+	case kindBool:
+		return v.b
+	default:
+		return false
+	}
+}
+
+// compare returns -1, 0 or 1, the values are compared as numbers if both of them are convertible
+func compare(a, b value) int {
+	x, okX := a.toNumber()
+	y, okY := b.toNumber()
+	if okX && okY {
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		default:
+			return 0
+		}
+	}
+
+	s, t := a.toString(), b.toString()
+	switch {
+	case s < t:
+		return -1
+	case s > t:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func equal(a, b value) bool {
+	if a.kind == kindNull || b.kind == kindNull {
+		return a.kind == b.kind
+	}
+	return compare(a, b) == 0
+}
+
+// set puts the value to the node, the integral numbers are put as integers
+func (v value) set(node *insaneJSON.Node) {
+	switch v.kind {
+	case kindNumber:
+		if v.num == math.Trunc(v.num) && math.Abs(v.num) < 1<<53 {
+			node.MutateToInt64(int64(v.num))
+			return
+		}
+		node.MutateToFloat(v.num)
+	case kindString:
+		node.MutateToString(v.str)
+	case kindBool:
+		node.MutateToBool(v.b)
+	default:
+		node.MutateToNull()
+	}
+}
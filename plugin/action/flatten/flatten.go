@@ -1,13 +1,19 @@
 package flatten
 
 import (
+	"strconv"
+
 	"github.com/ozontech/file.d/cfg"
 	"github.com/ozontech/file.d/fd"
+	"github.com/ozontech/file.d/metric"
 	"github.com/ozontech/file.d/pipeline"
+	"github.com/prometheus/client_golang/prometheus"
+	insaneJSON "github.com/vitkovskii/insane-json"
 )
 
 /*{ introduction
 It extracts the object keys and adds them into the root with some prefix. If the provided field isn't an object, an event will be skipped.
+The nested objects are flattened recursively, their keys are joined with the separator.
 
 **Example:**
 ```yaml
@@ -20,11 +26,42 @@ pipelines:
       prefix: pet_
     ...
 ```
-It transforms `{"animal":{"type":"cat","paws":4}}` into `{"pet_type":"b","pet_paws":"4"}`.
+It transforms `{"animal":{"type":"cat","paws":4,"owner":{"name":"bob"}}}` into `{"pet_type":"cat","pet_paws":4,"pet_owner.name":"bob"}`.
+
+If the field isn't set, the whole event is flattened, e.g. for the flat schemas of ClickHouse:
+```yaml
+pipelines:
+  example_pipeline:
+    ...
+    actions:
+    - type: flatten
+      index_arrays: true
+      skip_fields:
+        - labels
+    ...
+```
+It transforms `{"items":[{"id":1},{"id":2}],"labels":{"app":"x"},"level":"info"}`
+into `{"level":"info","labels":{"app":"x"},"items.0.id":1,"items.1.id":2}`.
 }*/
 
 type Plugin struct {
 	config *Config
+
+	skipFields map[string]struct{}
+
+	// keyBuf contains the path to the current node joined with the separator
+	keyBuf []byte
+	leaves []leaf
+	fields []*insaneJSON.Node
+
+	//  plugin metrics
+
+	tooManyKeysMetric *prometheus.CounterVec
+}
+
+type leaf struct {
+	key  string
+	node *insaneJSON.Node
 }
 
 // ! config-params
@@ -32,14 +69,42 @@ type Plugin struct {
 type Config struct {
 	// > @3@4@5@6
 	// >
-	// > Defines the field that should be flattened.
-	Field  cfg.FieldSelector `json:"field" required:"true" parse:"selector"` // *
+	// > Defines the field that should be flattened. If it's empty, the whole event is flattened.
+	Field  cfg.FieldSelector `json:"field" parse:"selector"` // *
 	Field_ []string
 
 	// > @3@4@5@6
 	// >
 	// > Which prefix to use for extracted fields.
 	Prefix string `json:"prefix" default:""` // *
+
+	// > @3@4@5@6
+	// >
+	// > The separator of the keys of the nested objects.
+	Separator string `json:"separator" default:"."` // *
+
+	// > @3@4@5@6
+	// >
+	// > The max depth of the nested objects to flatten, the deeper objects are kept as is.
+	// > Zero means no limit, `1` extracts only the keys of the field.
+	MaxDepth int `json:"max_depth"` // *
+
+	// > @3@4@5@6
+	// >
+	// > If set, the arrays are flattened too with the indexes of the elements as keys, e.g. `items.0.id`.
+	IndexArrays bool `json:"index_arrays"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The max number of the keys produced for the event.
+	// > If the flattened field has more keys, it's kept as is.
+	MaxKeys int `json:"max_keys" default:"1000"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The paths of the nested objects which shouldn't be flattened.
+	// > The path is relative to the field and joined with the separator, e.g. `kubernetes.labels`.
+	SkipFields []string `json:"skip_fields" slice:"true"` // *
 }
 
 func init() {
@@ -53,32 +118,154 @@ func factory() (pipeline.AnyPlugin, pipeline.AnyConfig) {
 	return &Plugin{}, &Config{}
 }
 
-func (p *Plugin) Start(config pipeline.AnyConfig, _ *pipeline.ActionPluginParams) {
+func (p *Plugin) Start(config pipeline.AnyConfig, params *pipeline.ActionPluginParams) {
 	p.config = config.(*Config)
+
+	p.skipFields = make(map[string]struct{}, len(p.config.SkipFields))
+	for _, field := range p.config.SkipFields {
+		p.skipFields[field] = struct{}{}
+	}
+
+	p.registerMetrics(params.MetricCtl)
+}
+
+func (p *Plugin) registerMetrics(ctl *metric.Ctl) {
+	p.tooManyKeysMetric = ctl.RegisterCounter("action_flatten_too_many_keys_total", "Number of events which aren't flattened because of max_keys")
 }
 
 func (p *Plugin) Stop() {
 }
 
 func (p *Plugin) Do(event *pipeline.Event) pipeline.ActionResult {
+	if len(p.config.Field_) == 0 {
+		p.flattenRoot(event)
+		return pipeline.ActionPass
+	}
+
 	node := event.Root.Dig(p.config.Field_...)
 
-	if !node.IsObject() {
+	if !node.IsObject() && !(p.config.IndexArrays && node.IsArray()) {
+		return pipeline.ActionPass
+	}
+
+	p.leaves = p.leaves[:0]
+	p.keyBuf = p.keyBuf[:0]
+	if !p.collect(event, node, 1) {
+		p.tooManyKeysMetric.WithLabelValues().Inc()
 		return pipeline.ActionPass
 	}
 
 	node.Suicide()
+	p.addLeaves(event)
+
+	return pipeline.ActionPass
+}
 
-	fields := node.AsFields()
-	for _, field := range fields {
-		l := len(event.Buf)
-		event.Buf = append(event.Buf, p.config.Prefix...)
-		event.Buf = append(event.Buf, field.AsString()...)
-		field.MutateToField(pipeline.ByteToStringUnsafe(event.Buf[l:]))
+// flattenRoot flattens the fields of the root, the other fields are only prefixed.
+func (p *Plugin) flattenRoot(event *pipeline.Event) {
+	// fields are copied since the root is changed while flattening
+	p.fields = append(p.fields[:0], event.Root.AsFields()...)
+
+	p.leaves = p.leaves[:0]
+	for _, field := range p.fields {
+		p.keyBuf = append(p.keyBuf[:0], field.AsString()...)
+		value := field.AsFieldValue()
+		if !p.isExpandable(value, 1) {
+			continue
+		}
+		if !p.collect(event, value, 1) {
+			p.tooManyKeysMetric.WithLabelValues().Inc()
+			return
+		}
 	}
 
-	// place decoded object under root
-	event.Root.MergeWith(node)
+	for _, field := range p.fields {
+		p.keyBuf = append(p.keyBuf[:0], field.AsString()...)
+		value := field.AsFieldValue()
+		if p.isExpandable(value, 1) {
+			value.Suicide()
+			continue
+		}
+		if p.config.Prefix != "" {
+			l := len(event.Buf)
+			event.Buf = append(event.Buf, p.config.Prefix...)
+			event.Buf = append(event.Buf, p.keyBuf...)
+			field.MutateToField(pipeline.ByteToStringUnsafe(event.Buf[l:]))
+		}
+	}
 
-	return pipeline.ActionPass
+	p.addLeaves(event)
+}
+
+// collect adds the leaves of the node to the list, it returns false if there are too many keys.
+func (p *Plugin) collect(event *pipeline.Event, node *insaneJSON.Node, depth int) bool {
+	l := len(p.keyBuf)
+	if l != 0 {
+		p.keyBuf = append(p.keyBuf, p.config.Separator...)
+	}
+	keyStart := len(p.keyBuf)
+
+	if node.IsArray() {
+		for i, elem := range node.AsArray() {
+			p.keyBuf = strconv.AppendInt(p.keyBuf[:keyStart], int64(i), 10)
+			if !p.collectChild(event, elem, depth) {
+				return false
+			}
+		}
+	} else {
+		for _, field := range node.AsFields() {
+			p.keyBuf = append(p.keyBuf[:keyStart], field.AsString()...)
+			if !p.collectChild(event, field.AsFieldValue(), depth) {
+				return false
+			}
+		}
+	}
+
+	p.keyBuf = p.keyBuf[:l]
+	return true
+}
+
+func (p *Plugin) collectChild(event *pipeline.Event, node *insaneJSON.Node, depth int) bool {
+	if p.isExpandable(node, depth+1) {
+		return p.collect(event, node, depth+1)
+	}
+	return p.collectLeaf(event, node)
+}
+
+func (p *Plugin) collectLeaf(event *pipeline.Event, node *insaneJSON.Node) bool {
+	if len(p.leaves) >= p.config.MaxKeys {
+		return false
+	}
+
+	l := len(event.Buf)
+	event.Buf = append(event.Buf, p.config.Prefix...)
+	event.Buf = append(event.Buf, p.keyBuf...)
+	p.leaves = append(p.leaves, leaf{
+		key:  pipeline.ByteToStringUnsafe(event.Buf[l:]),
+		node: node,
+	})
+
+	return true
+}
+
+// isExpandable checks if the node at the depth should be flattened.
+func (p *Plugin) isExpandable(node *insaneJSON.Node, depth int) bool {
+	if p.config.MaxDepth != 0 && depth > p.config.MaxDepth {
+		return false
+	}
+	if _, ok := p.skipFields[string(p.keyBuf)]; ok {
+		return false
+	}
+
+	// empty objects and arrays are kept as is to not lose the keys
+	if node.IsObject() {
+		return len(node.AsFields()) != 0
+	}
+	return p.config.IndexArrays && node.IsArray() && len(node.AsArray()) != 0
+}
+
+func (p *Plugin) addLeaves(event *pipeline.Event) {
+	for _, l := range p.leaves {
+		event.Root.AddFieldNoAlloc(event.Root, l.key).MutateToNode(l.node)
+	}
 }
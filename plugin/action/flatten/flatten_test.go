@@ -9,6 +9,8 @@ import (
 	"github.com/ozontech/file.d/pipeline"
 	"github.com/ozontech/file.d/test"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	insaneJSON "github.com/vitkovskii/insane-json"
 )
 
 func TestFlatten(t *testing.T) {
@@ -45,3 +47,70 @@ func TestFlatten(t *testing.T) {
 	assert.Equal(t, 1, dumpedEvents)
 	assert.Equal(t, `{"flat_a":"b","flat_c":"d"}`, rawEvent, "wrong out events count")
 }
+
+func TestFlattenNested(t *testing.T) {
+	cases := []struct {
+		name   string
+		config *Config
+		in     string
+		out    string
+	}{
+		{
+			name:   "recursive",
+			config: &Config{Field: "complex", Prefix: "flat_"},
+			in:     `{"complex":{"a":{"b":{"c":1}},"d":"e"},"f":"g"}`,
+			out:    `{"f":"g","flat_a.b.c":1,"flat_d":"e"}`,
+		},
+		{
+			name:   "max_depth",
+			config: &Config{Field: "complex", Separator: "_", MaxDepth: 2},
+			in:     `{"complex":{"a":{"b":{"c":1}},"d":{}}}`,
+			out:    `{"a_b":{"c":1},"d":{}}`,
+		},
+		{
+			name:   "arrays",
+			config: &Config{Field: "complex", IndexArrays: true},
+			in:     `{"complex":{"items":[{"id":1},{"id":2}],"tags":["x"]}}`,
+			out:    `{"items.0.id":1,"items.1.id":2,"tags.0":"x"}`,
+		},
+		{
+			name:   "arrays_kept",
+			config: &Config{Field: "complex"},
+			in:     `{"complex":{"items":[{"id":1}]}}`,
+			out:    `{"items":[{"id":1}]}`,
+		},
+		{
+			name:   "root",
+			config: &Config{IndexArrays: true, SkipFields: []string{"k8s.labels"}},
+			in:     `{"items":[{"id":1}],"k8s":{"labels":{"app":"x"},"pod":"p"},"level":"info"}`,
+			out:    `{"level":"info","items.0.id":1,"k8s.labels":{"app":"x"},"k8s.pod":"p"}`,
+		},
+		{
+			name:   "root_prefix",
+			config: &Config{Prefix: "x_", MaxDepth: 1},
+			in:     `{"a":{"b":{"c":1}},"d":"e"}`,
+			out:    `{"x_d":"e","x_a.b":{"c":1}}`,
+		},
+		{
+			name:   "max_keys",
+			config: &Config{Field: "complex", MaxKeys: 2},
+			in:     `{"complex":{"a":1,"b":{"c":2,"d":3}}}`,
+			out:    `{"complex":{"a":1,"b":{"c":2,"d":3}}}`,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			test.NewConfig(tt.config, nil)
+			p := &Plugin{}
+			p.Start(tt.config, test.NewEmptyActionPluginParams())
+
+			root, err := insaneJSON.DecodeString(tt.in)
+			require.NoError(t, err)
+			defer insaneJSON.Release(root)
+
+			p.Do(&pipeline.Event{Root: root})
+			assert.Equal(t, tt.out, root.EncodeToString())
+		})
+	}
+}
@@ -0,0 +1,147 @@
+package geoip
+
+import (
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/oschwald/maxminddb-golang"
+	"go.uber.org/zap"
+)
+
+var (
+	// databases are shared across all plugin instances by the file path
+	databases   = map[string]*database{}
+	databasesMu = &sync.Mutex{}
+)
+
+// record holds the fields of City, Country and ASN databases, each database fills its own ones
+type record struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	City struct {
+		Names struct {
+			En string `maxminddb:"en"`
+		} `maxminddb:"names"`
+	} `maxminddb:"city"`
+	Location struct {
+		Latitude  *float64 `maxminddb:"latitude"`
+		Longitude *float64 `maxminddb:"longitude"`
+	} `maxminddb:"location"`
+	ASN   uint   `maxminddb:"autonomous_system_number"`
+	ASOrg string `maxminddb:"autonomous_system_organization"`
+}
+
+// database is the mmdb file loaded to the memory, it's reloaded when the file is changed
+type database struct {
+	path   string
+	reader atomic.Pointer[maxminddb.Reader]
+	logger *zap.SugaredLogger
+
+	// refs is the number of plugin instances using the database
+	refs    int
+	modTime time.Time
+	size    int64
+	stopCh  chan struct{}
+}
+
+// acquireDatabase returns the shared database of the file, the first call loads it
+func acquireDatabase(path string, reloadInterval time.Duration, logger *zap.SugaredLogger) (*database, error) {
+	databasesMu.Lock()
+	defer databasesMu.Unlock()
+
+	if db, has := databases[path]; has {
+		db.refs++
+		return db, nil
+	}
+
+	db := &database{
+		path:   path,
+		logger: logger,
+		refs:   1,
+		stopCh: make(chan struct{}),
+	}
+	if err := db.load(); err != nil {
+		return nil, err
+	}
+	databases[path] = db
+
+	if reloadInterval > 0 {
+		go db.watch(reloadInterval)
+	}
+
+	return db, nil
+}
+
+func (d *database) release() {
+	databasesMu.Lock()
+	defer databasesMu.Unlock()
+
+	d.refs--
+	if d.refs > 0 {
+		return
+	}
+	close(d.stopCh)
+	delete(databases, d.path)
+}
+
+// load reads the whole file, so the previous reader can be used by lookups while the new one is loaded
+func (d *database) load() error {
+	stat, err := os.Stat(d.path)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(d.path)
+	if err != nil {
+		return err
+	}
+	reader, err := maxminddb.FromBytes(data)
+	if err != nil {
+		return err
+	}
+
+	d.reader.Store(reader)
+	d.modTime = stat.ModTime()
+	d.size = stat.Size()
+	return nil
+}
+
+func (d *database) watch(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			d.reloadIfChanged()
+		case <-d.stopCh:
+			return
+		}
+	}
+}
+
+func (d *database) reloadIfChanged() {
+	stat, err := os.Stat(d.path)
+	if err != nil {
+		d.logger.Errorf("can't stat geoip database %s: %s", d.path, err.Error())
+		return
+	}
+	if stat.ModTime().Equal(d.modTime) && stat.Size() == d.size {
+		return
+	}
+
+	if err := d.load(); err != nil {
+		d.logger.Errorf("can't reload geoip database %s, the previous one is used: %s", d.path, err.Error())
+		return
+	}
+	d.logger.Infof("geoip database %s is reloaded", d.path)
+}
+
+// lookup fills the record by the ip, it returns false if the ip isn't found
+func (d *database) lookup(ip net.IP, rec *record) (bool, error) {
+	_, ok, err := d.reader.Load().LookupNetwork(ip, rec)
+	return ok, err
+}
@@ -0,0 +1,237 @@
+package geoip
+
+import (
+	"net"
+	"time"
+
+	"github.com/ozontech/file.d/cfg"
+	"github.com/ozontech/file.d/fd"
+	"github.com/ozontech/file.d/metric"
+	"github.com/ozontech/file.d/pipeline"
+	"github.com/prometheus/client_golang/prometheus"
+	insaneJSON "github.com/vitkovskii/insane-json"
+	"go.uber.org/zap"
+)
+
+/*{ introduction
+It enriches the event with the geo information of the IP address using MaxMind GeoLite2/GeoIP2 databases in `mmdb` format.
+City, Country and ASN databases can be used simultaneously, each of them fills its own fields.
+
+The databases are shared by all plugin instances and are reloaded when their files are changed.
+If the IP address is missing, private or isn't found, the fields aren't added.
+
+**Example:**
+```yaml
+pipelines:
+  example_pipeline:
+    ...
+    actions:
+    - type: geoip
+      field: client_ip
+      databases:
+        - /var/lib/geoip/GeoLite2-City.mmdb
+        - /var/lib/geoip/GeoLite2-ASN.mmdb
+      country_field: geo.country
+      city_field: geo.city
+      lat_field: geo.lat
+      lon_field: geo.lon
+      asn_field: geo.asn
+    ...
+```
+
+The event:
+```json
+{"client_ip":"81.2.69.142"}
+```
+
+Will be transformed to:
+```json
+{"client_ip":"81.2.69.142","geo":{"country":"GB","city":"London","lat":51.5142,"lon":-0.0931,"asn":20712}}
+```
+}*/
+
+const (
+	missReasonNoIP     = "no_ip"
+	missReasonPrivate  = "private"
+	missReasonNotFound = "not_found"
+	missReasonError    = "error"
+)
+
+type Plugin struct {
+	config    *Config
+	logger    *zap.SugaredLogger
+	databases []*database
+	record    record
+
+	// plugin metrics
+	missesMetric *prometheus.CounterVec
+}
+
+// ! config-params
+// ^ config-params
+type Config struct {
+	// > @3@4@5@6
+	// >
+	// > The event field which contains the IP address.
+	Field  cfg.FieldSelector `json:"field" parse:"selector" required:"true"` // *
+	Field_ []string
+
+	// > @3@4@5@6
+	// >
+	// > The list of paths to `mmdb` databases, e.g. City and ASN ones.
+	Databases []string `json:"databases" required:"true"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The field to put the ISO code of the country to. It's filled by City and Country databases.
+	CountryField  cfg.FieldSelector `json:"country_field" parse:"selector"` // *
+	CountryField_ []string
+
+	// > @3@4@5@6
+	// >
+	// > The field to put the English name of the city to. It's filled by City databases.
+	CityField  cfg.FieldSelector `json:"city_field" parse:"selector"` // *
+	CityField_ []string
+
+	// > @3@4@5@6
+	// >
+	// > The field to put the latitude to. It's filled by City databases.
+	LatField  cfg.FieldSelector `json:"lat_field" parse:"selector"` // *
+	LatField_ []string
+
+	// > @3@4@5@6
+	// >
+	// > The field to put the longitude to. It's filled by City databases.
+	LonField  cfg.FieldSelector `json:"lon_field" parse:"selector"` // *
+	LonField_ []string
+
+	// > @3@4@5@6
+	// >
+	// > The field to put the autonomous system number to. It's filled by ASN databases.
+	ASNField  cfg.FieldSelector `json:"asn_field" parse:"selector"` // *
+	ASNField_ []string
+
+	// > @3@4@5@6
+	// >
+	// > The field to put the autonomous system organization to. It's filled by ASN databases.
+	ASOrgField  cfg.FieldSelector `json:"as_org_field" parse:"selector"` // *
+	ASOrgField_ []string
+
+	// > @3@4@5@6
+	// >
+	// > How often to check if database files are changed. Zero disables reloading.
+	ReloadInterval  cfg.Duration `json:"reload_interval" default:"1m" parse:"duration"` // *
+	ReloadInterval_ time.Duration
+}
+
+func init() {
+	fd.DefaultPluginRegistry.RegisterAction(&pipeline.PluginStaticInfo{
+		Type:    "geoip",
+		Factory: factory,
+	})
+}
+
+func factory() (pipeline.AnyPlugin, pipeline.AnyConfig) {
+	return &Plugin{}, &Config{}
+}
+
+func (p *Plugin) Start(config pipeline.AnyConfig, params *pipeline.ActionPluginParams) {
+	p.config = config.(*Config)
+	p.logger = params.Logger
+	p.registerMetrics(params.MetricCtl)
+
+	if len(p.config.CountryField_)+len(p.config.CityField_)+len(p.config.LatField_)+
+		len(p.config.LonField_)+len(p.config.ASNField_)+len(p.config.ASOrgField_) == 0 {
+		p.logger.Fatalf("at least one of the target fields should be set")
+	}
+
+	if len(p.config.Databases) == 0 {
+		p.logger.Fatalf("databases should be set")
+	}
+
+	p.databases = make([]*database, 0, len(p.config.Databases))
+	for _, path := range p.config.Databases {
+		db, err := acquireDatabase(path, p.config.ReloadInterval_, p.logger)
+		if err != nil {
+			p.logger.Fatalf("can't load geoip database %s: %s", path, err.Error())
+		}
+		p.databases = append(p.databases, db)
+	}
+}
+
+func (p *Plugin) registerMetrics(ctl *metric.Ctl) {
+	p.missesMetric = ctl.RegisterCounter("action_geoip_misses_total", "Number of events without geo information", "reason")
+}
+
+func (p *Plugin) Stop() {
+	for _, db := range p.databases {
+		db.release()
+	}
+}
+
+func (p *Plugin) Do(event *pipeline.Event) pipeline.ActionResult {
+	node := event.Root.Dig(p.config.Field_...)
+	if node == nil {
+		p.missesMetric.WithLabelValues(missReasonNoIP).Inc()
+		return pipeline.ActionPass
+	}
+
+	ip := net.ParseIP(node.AsString())
+	if ip == nil {
+		p.missesMetric.WithLabelValues(missReasonNoIP).Inc()
+		return pipeline.ActionPass
+	}
+	if ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsUnspecified() {
+		p.missesMetric.WithLabelValues(missReasonPrivate).Inc()
+		return pipeline.ActionPass
+	}
+
+	p.record = record{}
+	found := false
+	for _, db := range p.databases {
+		ok, err := db.lookup(ip, &p.record)
+		if err != nil {
+			p.missesMetric.WithLabelValues(missReasonError).Inc()
+			p.logger.Errorf("can't lookup ip %s in geoip database %s: %s", ip.String(), db.path, err.Error())
+			return pipeline.ActionPass
+		}
+		found = found || ok
+	}
+	if !found {
+		p.missesMetric.WithLabelValues(missReasonNotFound).Inc()
+		return pipeline.ActionPass
+	}
+
+	rec := &p.record
+	if rec.Country.ISOCode != "" {
+		p.setString(event, p.config.CountryField_, rec.Country.ISOCode)
+	}
+	if rec.City.Names.En != "" {
+		p.setString(event, p.config.CityField_, rec.City.Names.En)
+	}
+	if rec.Location.Latitude != nil {
+		p.createField(event, p.config.LatField_).MutateToFloat(*rec.Location.Latitude)
+	}
+	if rec.Location.Longitude != nil {
+		p.createField(event, p.config.LonField_).MutateToFloat(*rec.Location.Longitude)
+	}
+	if rec.ASN != 0 {
+		p.createField(event, p.config.ASNField_).MutateToInt(int(rec.ASN))
+	}
+	if rec.ASOrg != "" {
+		p.setString(event, p.config.ASOrgField_, rec.ASOrg)
+	}
+
+	return pipeline.ActionPass
+}
+
+func (p *Plugin) setString(event *pipeline.Event, field []string, value string) {
+	p.createField(event, field).MutateToString(value)
+}
+
+func (p *Plugin) createField(event *pipeline.Event, field []string) *insaneJSON.Node {
+	if len(field) == 0 {
+		return nil
+	}
+	return pipeline.CreateNestedField(event.Root, field)
+}
@@ -0,0 +1,269 @@
+package geoip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ozontech/file.d/pipeline"
+	"github.com/ozontech/file.d/test"
+	"github.com/stretchr/testify/require"
+)
+
+// mmdbNetwork is the network of the test database and its data, the data is the map of strings, floats, uints and maps
+type mmdbNetwork struct {
+	cidr string
+	data map[string]any
+}
+
+// writeMMDB writes the IPv4 database in the MaxMind DB format with 32 bits records
+func writeMMDB(t *testing.T, path string, networks []mmdbNetwork) {
+	type node struct {
+		// records are node indexes, -1 is empty and -2-i is the data of the i-th network
+		records [2]int
+	}
+	nodes := []node{{records: [2]int{-1, -1}}}
+
+	data := &bytes.Buffer{}
+	offsets := make([]int, 0, len(networks))
+	for i, n := range networks {
+		offsets = append(offsets, data.Len())
+		encodeMMDBValue(data, n.data)
+
+		_, ipNet, err := net.ParseCIDR(n.cidr)
+		require.NoError(t, err)
+		ip := ipNet.IP.To4()
+		ones, _ := ipNet.Mask.Size()
+
+		cur := 0
+		for bit := 0; bit < ones; bit++ {
+			side := int(ip[bit/8]>>(7-bit%8)) & 1
+			if bit == ones-1 {
+				nodes[cur].records[side] = -2 - i
+				break
+			}
+			if nodes[cur].records[side] < 0 {
+				nodes = append(nodes, node{records: [2]int{-1, -1}})
+				nodes[cur].records[side] = len(nodes) - 1
+			}
+			cur = nodes[cur].records[side]
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	nodeCount := len(nodes)
+	for _, n := range nodes {
+		for _, r := range n.records {
+			value := r
+			switch {
+			case r == -1:
+				value = nodeCount
+			case r < -1:
+				value = nodeCount + 16 + offsets[-2-r]
+			}
+			_ = binary.Write(buf, binary.BigEndian, uint32(value))
+		}
+	}
+	buf.Write(make([]byte, 16))
+	buf.Write(data.Bytes())
+	buf.WriteString("\xab\xcd\xefMaxMind.com")
+	encodeMMDBValue(buf, map[string]any{
+		"binary_format_major_version": uint16(2),
+		"binary_format_minor_version": uint16(0),
+		"build_epoch":                 uint64(time.Now().Unix()),
+		"database_type":               "Test",
+		"description":                 map[string]any{"en": "Test"},
+		"ip_version":                  uint16(4),
+		"languages":                   []string{"en"},
+		"node_count":                  uint32(nodeCount),
+		"record_size":                 uint16(32),
+	})
+
+	require.NoError(t, os.WriteFile(path, buf.Bytes(), 0o644))
+}
+
+func encodeMMDBControl(buf *bytes.Buffer, typ int, size int) {
+	control := byte(0)
+	if typ <= 7 {
+		control = byte(typ << 5)
+	}
+	if size < 29 {
+		control |= byte(size)
+	} else {
+		control |= 29
+	}
+	buf.WriteByte(control)
+	if typ > 7 {
+		buf.WriteByte(byte(typ - 7))
+	}
+	if size >= 29 {
+		buf.WriteByte(byte(size - 29))
+	}
+}
+
+func encodeMMDBUint(buf *bytes.Buffer, typ int, value uint64) {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, value)
+	b = bytes.TrimLeft(b, "\x00")
+	encodeMMDBControl(buf, typ, len(b))
+	buf.Write(b)
+}
+
+func encodeMMDBValue(buf *bytes.Buffer, value any) {
+	switch v := value.(type) {
+	case string:
+		encodeMMDBControl(buf, 2, len(v))
+		buf.WriteString(v)
+	case float64:
+		encodeMMDBControl(buf, 3, 8)
+		_ = binary.Write(buf, binary.BigEndian, math.Float64bits(v))
+	case uint16:
+		encodeMMDBUint(buf, 5, uint64(v))
+	case uint32:
+		encodeMMDBUint(buf, 6, uint64(v))
+	case uint64:
+		encodeMMDBUint(buf, 9, v)
+	case []string:
+		encodeMMDBControl(buf, 11, len(v))
+		for _, s := range v {
+			encodeMMDBValue(buf, s)
+		}
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		encodeMMDBControl(buf, 7, len(keys))
+		for _, k := range keys {
+			encodeMMDBValue(buf, k)
+			encodeMMDBValue(buf, v[k])
+		}
+	default:
+		panic("unsupported mmdb value")
+	}
+}
+
+func cityNetwork(cidr string, country string, city string, lat float64, lon float64) mmdbNetwork {
+	return mmdbNetwork{cidr: cidr, data: map[string]any{
+		"country":  map[string]any{"iso_code": country},
+		"city":     map[string]any{"names": map[string]any{"en": city, "de": city + "-de"}},
+		"location": map[string]any{"latitude": lat, "longitude": lon},
+	}}
+}
+
+func asnNetwork(cidr string, asn uint32, org string) mmdbNetwork {
+	return mmdbNetwork{cidr: cidr, data: map[string]any{
+		"autonomous_system_number":       asn,
+		"autonomous_system_organization": org,
+	}}
+}
+
+func runEvents(t *testing.T, config *Config, events []string) []string {
+	test.NewConfig(config, nil)
+	p, input, output := test.NewPipelineMock(test.NewActionPluginStaticInfo(factory, config, pipeline.MatchModeAnd, nil, false))
+
+	wg := &sync.WaitGroup{}
+	wg.Add(len(events))
+	outEvents := make([]string, 0, len(events))
+	output.SetOutFn(func(e *pipeline.Event) {
+		outEvents = append(outEvents, e.Root.EncodeToString())
+		wg.Done()
+	})
+
+	for _, e := range events {
+		input.In(0, "test.log", 0, []byte(e))
+	}
+
+	wg.Wait()
+	p.Stop()
+	return outEvents
+}
+
+func TestGeoIP(t *testing.T) {
+	dir := t.TempDir()
+	cityDB := filepath.Join(dir, "city.mmdb")
+	asnDB := filepath.Join(dir, "asn.mmdb")
+	writeMMDB(t, cityDB, []mmdbNetwork{
+		cityNetwork("81.2.69.0/24", "GB", "London", 51.5, -0.25),
+		cityNetwork("89.160.20.112/28", "SE", "Linköping", 58.4167, 15.6167),
+	})
+	writeMMDB(t, asnDB, []mmdbNetwork{
+		asnNetwork("81.2.64.0/18", 20712, "Andrews & Arnold Ltd"),
+		asnNetwork("1.0.0.0/24", 13335, "Cloudflare"),
+	})
+
+	config := &Config{
+		Field:        "ip",
+		Databases:    []string{cityDB, asnDB},
+		CountryField: "geo.country",
+		CityField:    "geo.city",
+		LatField:     "geo.lat",
+		LonField:     "geo.lon",
+		ASNField:     "geo.asn",
+		ASOrgField:   "geo.as_org",
+	}
+	out := runEvents(t, config, []string{
+		`{"ip":"81.2.69.142"}`,
+		`{"ip":"89.160.20.120"}`,
+		`{"ip":"1.0.0.1"}`,
+		`{"ip":"10.1.2.3"}`,
+		`{"ip":"127.0.0.1"}`,
+		`{"ip":"8.8.8.8"}`,
+		`{"ip":"not ip"}`,
+		`{"message":"no ip"}`,
+	})
+
+	require.Equal(t, []string{
+		`{"ip":"81.2.69.142","geo":{"country":"GB","city":"London","lat":51.5,"lon":-0.25,"asn":20712,"as_org":"Andrews & Arnold Ltd"}}`,
+		`{"ip":"89.160.20.120","geo":{"country":"SE","city":"Linköping","lat":58.4167,"lon":15.6167}}`,
+		`{"ip":"1.0.0.1","geo":{"asn":13335,"as_org":"Cloudflare"}}`,
+		`{"ip":"10.1.2.3"}`,
+		`{"ip":"127.0.0.1"}`,
+		`{"ip":"8.8.8.8"}`,
+		`{"ip":"not ip"}`,
+		`{"message":"no ip"}`,
+	}, out)
+}
+
+func TestDatabaseReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "city.mmdb")
+	writeMMDB(t, path, []mmdbNetwork{cityNetwork("81.2.69.0/24", "GB", "London", 51.5, -0.25)})
+
+	db, err := acquireDatabase(path, 10*time.Millisecond, test.NewEmptyOutputPluginParams().Logger)
+	require.NoError(t, err)
+	defer db.release()
+
+	// the database is shared
+	same, err := acquireDatabase(path, 10*time.Millisecond, test.NewEmptyOutputPluginParams().Logger)
+	require.NoError(t, err)
+	require.Same(t, db, same)
+	same.release()
+
+	rec := &record{}
+	ok, err := db.lookup(net.ParseIP("81.2.69.142"), rec)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "London", rec.City.Names.En)
+
+	// the broken file is ignored
+	require.NoError(t, os.WriteFile(path, []byte("broken"), 0o644))
+	time.Sleep(50 * time.Millisecond)
+	ok, err = db.lookup(net.ParseIP("81.2.69.142"), rec)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	writeMMDB(t, path, []mmdbNetwork{cityNetwork("81.2.69.0/24", "GB", "Manchester", 53.5, -2.25)})
+	require.Eventually(t, func() bool {
+		rec := &record{}
+		ok, err := db.lookup(net.ParseIP("81.2.69.142"), rec)
+		return err == nil && ok && rec.City.Names.En == "Manchester"
+	}, 5*time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,334 @@
+package grok
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/ozontech/file.d/cfg"
+	"github.com/ozontech/file.d/fd"
+	"github.com/ozontech/file.d/metric"
+	"github.com/ozontech/file.d/pipeline"
+	"github.com/prometheus/client_golang/prometheus"
+	insaneJSON "github.com/vitkovskii/insane-json"
+	"go.uber.org/zap"
+)
+
+/*{ introduction
+It parses the text logs, e.g. the nginx or the apache access logs, by the grok patterns.
+The pattern is the regular expression with the references to the named patterns: `%{NAME}`, `%{NAME:field}` or `%{NAME:field:type}`,
+the referenced text is put to the `field` converted to the `type`: `int` or `float`.
+The built-in patterns are the common ones, e.g. `IPORHOST`, `HTTPDATE`, `TIMESTAMP_ISO8601`, `LOGLEVEL`,
+`COMMONAPACHELOG` or `COMBINEDAPACHELOG`, and `custom_patterns` adds the new ones or overrides the built-in ones.
+
+The patterns are tried in order, the fields of the first matched one are put to the event root or to `target_field`.
+The patterns are compiled to the regular expressions once and shared by the processors.
+
+**Example:**
+```yaml
+pipelines:
+  example_pipeline:
+    ...
+    actions:
+    - type: grok
+      field: message
+      patterns:
+        - '%{COMBINEDAPACHELOG}'
+        - '%{TIMESTAMP_ISO8601:time} %{LOGLEVEL:level} \[%{SERVICE:service}\] %{GREEDYDATA:message}'
+      custom_patterns:
+        SERVICE: '[a-z-]+'
+    ...
+```
+
+The event:
+```json
+{"message":"2024-01-02 15:04:05 WARN [billing] payment is retried"}
+```
+
+Will be transformed to:
+```json
+{"time":"2024-01-02 15:04:05","level":"WARN","service":"billing","message":"payment is retried"}
+```
+}*/
+
+const (
+	onFailureTag     = "tag"
+	onFailureDiscard = "discard"
+
+	typeInt   = "int"
+	typeFloat = "float"
+
+	maxPatternDepth = 32
+)
+
+var (
+	errNotString = errors.New("value isn't a string")
+	errNoMatch   = errors.New("value doesn't match any pattern")
+
+	// referenceRe matches `%{NAME}`, `%{NAME:field}` and `%{NAME:field:type}`
+	referenceRe = regexp.MustCompile(`%\{(\w+)(?::([^:}]+))?(?::(\w+))?\}`)
+
+	// compiled caches the expressions by the expanded patterns, so the processors share them
+	compiled sync.Map
+)
+
+type Plugin struct {
+	config   *Config
+	patterns []pattern
+
+	errorReporter *pipeline.ErrorReporter
+
+	// plugin metrics
+	failedMetric *prometheus.CounterVec
+}
+
+type pattern struct {
+	re     *regexp.Regexp
+	fields []patternField
+}
+
+type patternField struct {
+	// group is the index of the group of the expression
+	group int
+	path  []string
+	typ   string
+}
+
+// ! config-params
+// ^ config-params
+type Config struct {
+	// > @3@4@5@6
+	// >
+	// > The event field to parse. Must be a string.
+	Field  cfg.FieldSelector `json:"field" parse:"selector" default:"message"` // *
+	Field_ []string
+
+	// > @3@4@5@6
+	// >
+	// > The list of the patterns tried in order.
+	Patterns []string `json:"patterns" required:"true" slice:"true"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The named patterns which can be referenced by the patterns, they override the built-in ones.
+	CustomPatterns map[string]string `json:"custom_patterns"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The field to put the parsed fields to. If it's empty, the fields are put to the event root.
+	TargetField  cfg.FieldSelector `json:"target_field" parse:"selector"` // *
+	TargetField_ []string
+
+	// > @3@4@5@6
+	// >
+	// > If set, the parsed field is kept unless the pattern puts the field with the same name, otherwise it's removed.
+	KeepOrigin bool `json:"keep_origin"` // *
+
+	// > @3@4@5@6
+	// >
+	// > What to do if the value doesn't match any pattern:
+	// > * `leave` – keep the event as is
+	// > * `tag` – put the error to `error_field`
+	// > * `discard` – drop the event
+	OnFailure string `json:"on_failure" default:"tag" options:"leave|tag|discard"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The field to put the error to if `on_failure` is `tag`.
+	ErrorField  cfg.FieldSelector `json:"error_field" default:"grok_error" parse:"selector"` // *
+	ErrorField_ []string
+}
+
+func init() {
+	fd.DefaultPluginRegistry.RegisterAction(&pipeline.PluginStaticInfo{
+		Type:    "grok",
+		Factory: factory,
+	})
+}
+
+func factory() (pipeline.AnyPlugin, pipeline.AnyConfig) {
+	return &Plugin{}, &Config{}
+}
+
+func (p *Plugin) Start(config pipeline.AnyConfig, params *pipeline.ActionPluginParams) {
+	p.config = config.(*Config)
+	p.errorReporter = params.ErrorReporter
+	p.registerMetrics(params.MetricCtl)
+	logger := params.Logger.Desugar()
+
+	if len(p.config.Patterns) == 0 {
+		logger.Fatal("patterns should be set")
+	}
+
+	p.patterns = make([]pattern, 0, len(p.config.Patterns))
+	for _, expr := range p.config.Patterns {
+		pt, err := compilePattern(expr, p.config.CustomPatterns, p.config.TargetField_)
+		if err != nil {
+			logger.Fatal("can't compile pattern", zap.String("pattern", expr), zap.Error(err))
+		}
+		p.patterns = append(p.patterns, pt)
+	}
+}
+
+func (p *Plugin) registerMetrics(ctl *metric.Ctl) {
+	p.failedMetric = ctl.RegisterCounter("action_grok_failed_total", "Number of field values which don't match any pattern")
+}
+
+func (p *Plugin) Stop() {
+}
+
+func (p *Plugin) Do(event *pipeline.Event) pipeline.ActionResult {
+	node := event.Root.Dig(p.config.Field_...)
+	if node == nil {
+		return pipeline.ActionPass
+	}
+	if !node.IsString() {
+		return p.fail(event, errNotString)
+	}
+
+	value := node.AsString()
+	for i := range p.patterns {
+		pt := &p.patterns[i]
+		index := pt.re.FindStringSubmatchIndex(value)
+		if index == nil {
+			continue
+		}
+
+		if !p.config.KeepOrigin {
+			node.Suicide()
+		}
+		for j := range pt.fields {
+			f := &pt.fields[j]
+			begin, end := index[f.group*2], index[f.group*2+1]
+			if begin < 0 {
+				continue
+			}
+			setField(pipeline.CreateNestedField(event.Root, f.path), value[begin:end], f.typ)
+		}
+		return pipeline.ActionPass
+	}
+
+	return p.fail(event, errNoMatch)
+}
+
+func (p *Plugin) fail(event *pipeline.Event, err error) pipeline.ActionResult {
+	p.failedMetric.WithLabelValues().Inc()
+	p.errorReporter.Report("can't parse grok: "+err.Error(), event)
+
+	switch p.config.OnFailure {
+	case onFailureDiscard:
+		return pipeline.ActionDiscard
+	case onFailureTag:
+		pipeline.CreateNestedField(event.Root, p.config.ErrorField_).MutateToString("can't parse grok: " + err.Error())
+	}
+	return pipeline.ActionPass
+}
+
+// setField puts the value converted to the type, the value is put as the string if it can't be converted
+func setField(node *insaneJSON.Node, value, typ string) {
+	switch typ {
+	case typeInt:
+		if n, err := strconv.Atoi(value); err == nil {
+			node.MutateToInt(n)
+			return
+		}
+	case typeFloat:
+		if n, err := strconv.ParseFloat(value, 64); err == nil {
+			node.MutateToFloat(n)
+			return
+		}
+	}
+	node.MutateToString(value)
+}
+
+// compilePattern expands the references of the pattern and compiles it or takes the compiled one from the cache
+func compilePattern(expr string, custom map[string]string, targetPath []string) (pattern, error) {
+	c := &patternCompiler{custom: custom}
+	expanded, err := c.expand(expr, 0)
+	if err != nil {
+		return pattern{}, err
+	}
+
+	var re *regexp.Regexp
+	if cached, ok := compiled.Load(expanded); ok {
+		re = cached.(*regexp.Regexp)
+	} else {
+		if re, err = regexp.Compile(expanded); err != nil {
+			return pattern{}, err
+		}
+		compiled.Store(expanded, re)
+	}
+
+	fields := make([]patternField, 0, len(c.fields))
+	for i, f := range c.fields {
+		path := append(append([]string(nil), targetPath...), cfg.ParseFieldSelector(f.name)...)
+		fields = append(fields, patternField{
+			group: re.SubexpIndex(groupName(i)),
+			path:  path,
+			typ:   f.typ,
+		})
+	}
+	return pattern{re: re, fields: fields}, nil
+}
+
+type patternCompiler struct {
+	custom map[string]string
+	fields []struct{ name, typ string }
+}
+
+func groupName(i int) string {
+	return "f" + strconv.Itoa(i)
+}
+
+// expand replaces the references by the groups of the referenced patterns,
+// the named references are the named groups, so the fields can have any names
+func (c *patternCompiler) expand(expr string, depth int) (string, error) {
+	if depth > maxPatternDepth {
+		return "", fmt.Errorf("patterns are nested deeper than %d, they may be recursive", maxPatternDepth)
+	}
+
+	var b strings.Builder
+	last := 0
+	for _, m := range referenceRe.FindAllStringSubmatchIndex(expr, -1) {
+		b.WriteString(expr[last:m[0]])
+		last = m[1]
+
+		name := expr[m[2]:m[3]]
+		sub, ok := c.custom[name]
+		if !ok {
+			if sub, ok = builtinPatterns[name]; !ok {
+				return "", fmt.Errorf("unknown pattern %q", name)
+			}
+		}
+
+		field, typ := "", ""
+		if m[4] >= 0 {
+			field = expr[m[4]:m[5]]
+		}
+		if m[6] >= 0 {
+			typ = expr[m[6]:m[7]]
+			if typ != typeInt && typ != typeFloat {
+				return "", fmt.Errorf("unknown type %q of field %q", typ, field)
+			}
+		}
+
+		if field == "" {
+			b.WriteString("(?:")
+		} else {
+			b.WriteString("(?P<" + groupName(len(c.fields)) + ">")
+			c.fields = append(c.fields, struct{ name, typ string }{field, typ})
+		}
+		expanded, err := c.expand(sub, depth+1)
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(expanded)
+		b.WriteString(")")
+	}
+	b.WriteString(expr[last:])
+
+	return b.String(), nil
+}
@@ -0,0 +1,108 @@
+package grok
+
+import (
+	"testing"
+
+	"github.com/ozontech/file.d/pipeline"
+	"github.com/ozontech/file.d/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	insaneJSON "github.com/vitkovskii/insane-json"
+)
+
+func TestGrok(t *testing.T) {
+	patterns := []string{
+		`%{COMBINEDAPACHELOG}`,
+		`%{TIMESTAMP_ISO8601:time} %{LOGLEVEL:level} \[%{SERVICE:service}\] %{GREEDYDATA:message}`,
+	}
+	custom := map[string]string{"SERVICE": `[a-z-]+`}
+
+	cases := []struct {
+		name   string
+		config *Config
+		in     string
+		out    string
+		result pipeline.ActionResult
+	}{
+		{
+			name:   "apache",
+			config: &Config{Patterns: patterns, CustomPatterns: custom},
+			in:     `{"message":"127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] \"GET /apache_pb.gif HTTP/1.0\" 200 2326 \"http://www.example.com/start.html\" \"Mozilla/4.08\""}`,
+			out: `{"clientip":"127.0.0.1","ident":"-","auth":"frank","timestamp":"10/Oct/2000:13:55:36 -0700","verb":"GET",` +
+				`"request":"/apache_pb.gif","httpversion":"1.0","response":200,"bytes":2326,` +
+				`"referrer":"\"http://www.example.com/start.html\"","agent":"\"Mozilla/4.08\""}`,
+		},
+		{
+			name:   "second_pattern",
+			config: &Config{Patterns: patterns, CustomPatterns: custom},
+			in:     `{"message":"2024-01-02 15:04:05 WARN [billing] payment is retried"}`,
+			out:    `{"time":"2024-01-02 15:04:05","level":"WARN","service":"billing","message":"payment is retried"}`,
+		},
+		{
+			name: "target_and_types",
+			config: &Config{
+				Field:       "log",
+				Patterns:    []string{`took %{NUMBER:duration.ms:float}ms( retries=%{INT:retries:int})?`},
+				TargetField: "parsed",
+				KeepOrigin:  true,
+			},
+			in:  `{"log":"request took 12.5ms"}`,
+			out: `{"log":"request took 12.5ms","parsed":{"duration":{"ms":12.5}}}`,
+		},
+		{
+			name:   "tag",
+			config: &Config{Patterns: patterns, CustomPatterns: custom},
+			in:     `{"message":"unknown format"}`,
+			out:    `{"message":"unknown format","grok_error":"can't parse grok: value doesn't match any pattern"}`,
+		},
+		{
+			name:   "discard",
+			config: &Config{Patterns: patterns, CustomPatterns: custom, OnFailure: onFailureDiscard},
+			in:     `{"message":"unknown format"}`,
+			out:    `{"message":"unknown format"}`,
+			result: pipeline.ActionDiscard,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			test.NewConfig(tt.config, nil)
+			p := &Plugin{}
+			p.Start(tt.config, test.NewEmptyActionPluginParams())
+
+			root, err := insaneJSON.DecodeString(tt.in)
+			require.NoError(t, err)
+			defer insaneJSON.Release(root)
+
+			result := p.Do(&pipeline.Event{Root: root})
+			assert.Equal(t, tt.result, result)
+			assert.Equal(t, tt.out, root.EncodeToString())
+		})
+	}
+}
+
+func TestCompilePattern(t *testing.T) {
+	first, err := compilePattern(`%{IP:ip}`, nil, nil)
+	require.NoError(t, err)
+	second, err := compilePattern(`%{IP:ip}`, nil, []string{"target"})
+	require.NoError(t, err)
+	assert.Same(t, first.re, second.re, "compiled pattern isn't cached")
+	assert.Equal(t, []string{"target", "ip"}, second.fields[0].path)
+
+	for _, expr := range []string{
+		`%{UNKNOWN}`,
+		`%{INT:n:bool}`,
+		`%{LOOP}`,
+		`%{INT:n}(`,
+	} {
+		_, err := compilePattern(expr, map[string]string{"LOOP": `a%{LOOP}`}, nil)
+		assert.Error(t, err, expr)
+	}
+}
+
+func TestBuiltinPatterns(t *testing.T) {
+	for name := range builtinPatterns {
+		_, err := compilePattern(`%{`+name+`}`, nil, nil)
+		assert.NoError(t, err, name)
+	}
+}
@@ -2,10 +2,13 @@ package join
 
 import (
 	"regexp"
+	"time"
 
 	"github.com/ozontech/file.d/cfg"
 	"github.com/ozontech/file.d/fd"
+	"github.com/ozontech/file.d/metric"
 	"github.com/ozontech/file.d/pipeline"
+	"github.com/prometheus/client_golang/prometheus"
 	"go.uber.org/zap"
 )
 
@@ -31,6 +34,23 @@ pipelines:
         stream: stderr // apply only for events which was written to stderr to save CPU time
     ...
 ```
+
+**Example of joining Java stack traces by the start pattern only**:
+```yaml
+pipelines:
+  example_pipeline:
+    ...
+    actions:
+    - type: join
+      field: message
+      start: '/^\d{4}-\d{2}-\d{2}/'
+      max_lines: 500
+      max_event_size: 65536
+      flush_timeout: 2s
+    ...
+```
+If `continue` isn't set, all the events which don't match `start` are joined to the previous one.
+The group is passed on when the next group starts, the `flush_timeout` expires or the pipeline is stopped.
 }*/
 
 /*{ understanding
@@ -71,8 +91,12 @@ type Plugin struct {
 	buff         []byte
 	maxEventSize int
 	negate       bool
+	lines        int
 
 	logger *zap.SugaredLogger
+
+	// plugin metrics
+	joinedLinesMetric *prometheus.CounterVec
 }
 
 // ! config-params
@@ -93,7 +117,8 @@ type Config struct {
 	// > @3@4@5@6
 	// >
 	// > A regexp which will continue the join sequence.
-	Continue  cfg.Regexp `json:"continue" required:"true" parse:"regexp"` // *
+	// > If it isn't set, all the events which don't match `start` continue the sequence.
+	Continue  cfg.Regexp `json:"continue"` // *
 	Continue_ *regexp.Regexp
 
 	// > @3@4@5@6
@@ -105,12 +130,26 @@ type Config struct {
 	// >
 	// > Negate match logic for Continue (lets you implement negative lookahead while joining lines)
 	Negate bool `json:"negate" default:"false"` // *
+
+	// > @3@4@5@6
+	// >
+	// > Max number of the events in the join sequence. If it is set and the sequence reaches the limit,
+	// > the resulted event is passed on and the next event starts the new sequence.
+	MaxLines int `json:"max_lines" default:"0"` // *
+
+	// > @3@4@5@6
+	// >
+	// > How long to wait for the next event of the sequence before passing on the resulted event.
+	// > If it isn't set, the `event_timeout` of the pipeline is used.
+	FlushTimeout  cfg.Duration `json:"flush_timeout" parse:"duration"` // *
+	FlushTimeout_ time.Duration
 }
 
 func init() {
 	fd.DefaultPluginRegistry.RegisterAction(&pipeline.PluginStaticInfo{
-		Type:    "join",
-		Factory: factory,
+		Type:           "join",
+		Factory:        factory,
+		StreamRequired: true,
 	})
 }
 
@@ -126,11 +165,29 @@ func (p *Plugin) Start(config pipeline.AnyConfig, params *pipeline.ActionPluginP
 	p.maxEventSize = p.config.MaxEventSize
 	p.negate = p.config.Negate
 	p.logger = params.Logger
+	p.registerMetrics(params.MetricCtl)
+
+	if p.config.Continue_ == nil && p.config.Continue != "" {
+		re, err := cfg.CompileRegex(string(p.config.Continue))
+		if err != nil {
+			p.logger.Fatalf("can't compile continue regexp: %s", err.Error())
+		}
+		p.config.Continue_ = re
+	}
+}
+
+func (p *Plugin) registerMetrics(ctl *metric.Ctl) {
+	p.joinedLinesMetric = ctl.RegisterCounter("action_join_lines_total", "Number of events joined to the previous ones")
 }
 
 func (p *Plugin) Stop() {
 }
 
+// HoldTimeout makes the pipeline pass the timeout event after the flush timeout.
+func (p *Plugin) HoldTimeout() time.Duration {
+	return p.config.FlushTimeout_
+}
+
 func (p *Plugin) flush() {
 	event := p.initial
 	p.initial = nil
@@ -169,26 +226,33 @@ func (p *Plugin) Do(event *pipeline.Event) pipeline.ActionResult {
 		firstOK = p.config.Start_.MatchString(value)
 	}
 
-	if firstOK {
+	// the sequence which reached the limit is passed on, and the event starts the new one
+	if firstOK || p.isJoining && p.config.MaxLines > 0 && p.lines >= p.config.MaxLines {
 		if p.isJoining {
 			p.flush()
 		}
 
 		p.initial = event
 		p.isJoining = true
+		p.lines = 1
 		p.buff = append(p.buff[:0], value...)
 		return pipeline.ActionHold
 	}
 
 	if p.isJoining {
-		nextOK := p.config.Continue_.MatchString(value)
-		if p.negate {
-			nextOK = !nextOK
+		nextOK := true
+		if p.config.Continue_ != nil {
+			nextOK = p.config.Continue_.MatchString(value)
+			if p.negate {
+				nextOK = !nextOK
+			}
 		}
 		if nextOK {
 			if p.maxEventSize == 0 || len(p.buff) < p.maxEventSize {
 				p.buff = append(p.buff, value...)
 			}
+			p.lines++
+			p.joinedLinesMetric.WithLabelValues().Inc()
 			return pipeline.ActionCollapse
 		}
 	}
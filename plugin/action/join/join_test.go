@@ -3,6 +3,7 @@ package join
 import (
 	"fmt"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -306,3 +307,95 @@ func TestJoinAfterNilNode(t *testing.T) {
 		})
 	}
 }
+
+func TestJoinFlush(t *testing.T) {
+	lines := []string{
+		`{"log":"2023-01-01 Exception in thread main"}`,
+		`{"log":"\tat com.example.A.run"}`,
+		`{"log":"\tat com.example.B.run"}`,
+		`{"log":"\tat com.example.C.run"}`,
+		`{"log":"2023-01-02 Exception in thread worker"}`,
+		`{"log":"\tat com.example.D.run"}`,
+	}
+
+	cases := []struct {
+		name         string
+		maxLines     int
+		flushTimeout string
+		stop         bool
+		expLogs      []string
+	}{
+		{
+			name:         "flush_timeout",
+			flushTimeout: "100ms",
+			expLogs: []string{
+				"2023-01-01 Exception in thread main\tat com.example.A.run\tat com.example.B.run\tat com.example.C.run",
+				"2023-01-02 Exception in thread worker\tat com.example.D.run",
+			},
+		},
+		{
+			name:         "max_lines",
+			maxLines:     3,
+			flushTimeout: "100ms",
+			expLogs: []string{
+				"2023-01-01 Exception in thread main\tat com.example.A.run\tat com.example.B.run",
+				"\tat com.example.C.run",
+				"2023-01-02 Exception in thread worker\tat com.example.D.run",
+			},
+		},
+		{
+			name: "stop",
+			stop: true,
+			expLogs: []string{
+				"2023-01-01 Exception in thread main\tat com.example.A.run\tat com.example.B.run\tat com.example.C.run",
+				"2023-01-02 Exception in thread worker\tat com.example.D.run",
+			},
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &Config{
+				Field:        "log",
+				Start:        `/^\d{4}-\d{2}-\d{2}/`,
+				MaxLines:     tt.maxLines,
+				FlushTimeout: cfg.Duration(tt.flushTimeout),
+			}
+			require.NoError(t, cfg.Parse(config, nil))
+
+			p, input, output := test.NewPipelineMock(
+				test.NewActionPluginStaticInfo(factory, config, pipeline.MatchModeAnd, nil, false),
+			)
+
+			mu := sync.Mutex{}
+			logs := make([]string, 0)
+			output.SetOutFn(func(e *pipeline.Event) {
+				mu.Lock()
+				logs = append(logs, e.Root.Dig("log").AsString())
+				mu.Unlock()
+			})
+			outLogs := func() []string {
+				mu.Lock()
+				defer mu.Unlock()
+				return append([]string(nil), logs...)
+			}
+
+			for i, line := range lines {
+				input.In(0, "test.log", int64(i), []byte(line))
+			}
+
+			if tt.stop {
+				// the last sequence is held until the pipeline event timeout, so only the first one is passed
+				assert.Eventually(t, func() bool { return len(outLogs()) == 1 }, 5*time.Second, 10*time.Millisecond)
+				p.Stop()
+			}
+
+			assert.Eventually(t, func() bool { return len(outLogs()) == len(tt.expLogs) }, 5*time.Second, 10*time.Millisecond)
+			assert.Equal(t, tt.expLogs, outLogs())
+
+			if !tt.stop {
+				p.Stop()
+			}
+		})
+	}
+}
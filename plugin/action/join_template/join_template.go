@@ -69,8 +69,9 @@ type Config struct {
 
 func init() {
 	fd.DefaultPluginRegistry.RegisterAction(&pipeline.PluginStaticInfo{
-		Type:    "join_template",
-		Factory: factory,
+		Type:           "join_template",
+		Factory:        factory,
+		StreamRequired: true,
 	})
 }
 
@@ -12,7 +12,8 @@ If the decoded JSON isn't an object, the event will be skipped.
 }*/
 
 type Plugin struct {
-	config *Config
+	config        *Config
+	errorReporter *pipeline.ErrorReporter
 }
 
 // ! config-params
@@ -41,8 +42,9 @@ func factory() (pipeline.AnyPlugin, pipeline.AnyConfig) {
 	return &Plugin{}, &Config{}
 }
 
-func (p *Plugin) Start(config pipeline.AnyConfig, _ *pipeline.ActionPluginParams) {
+func (p *Plugin) Start(config pipeline.AnyConfig, params *pipeline.ActionPluginParams) {
 	p.config = config.(*Config)
+	p.errorReporter = params.ErrorReporter
 }
 
 func (p *Plugin) Stop() {
@@ -56,6 +58,7 @@ func (p *Plugin) Do(event *pipeline.Event) pipeline.ActionResult {
 
 	node, err := event.SubparseJSON(jsonNode.AsBytes())
 	if err != nil {
+		p.errorReporter.Report("can't decode json: "+err.Error(), event)
 		return pipeline.ActionPass
 	}
 
@@ -0,0 +1,197 @@
+package limit_field_size
+
+import (
+	"unicode/utf8"
+
+	"github.com/ozontech/file.d/cfg"
+	"github.com/ozontech/file.d/fd"
+	"github.com/ozontech/file.d/metric"
+	"github.com/ozontech/file.d/pipeline"
+	"github.com/prometheus/client_golang/prometheus"
+	insaneJSON "github.com/vitkovskii/insane-json"
+)
+
+/*{ introduction
+It truncates or drops the string values which are larger than the threshold,
+so the single huge field, e.g. the stack trace or the base64 blob, doesn't break the output with the document size limit.
+The values of the provided fields are checked, or all string values of the event if `fields` is empty.
+
+**Example:**
+```yaml
+pipelines:
+  example_pipeline:
+    ...
+    actions:
+    - type: limit_field_size
+      fields:
+        - message
+        - stack_trace
+      max_size: 10 KB
+      marker: "...[truncated]"
+      original_size_suffix: _original_size
+    ...
+```
+The event `{"message":"<20 KB>"}` becomes `{"message":"<10 KB>...[truncated]","message_original_size":20480}`.
+}*/
+
+const (
+	modeTruncate = "truncate"
+	modeDrop     = "drop"
+)
+
+type Plugin struct {
+	config *Config
+	fields [][]string
+
+	found []foundValue
+
+	//  plugin metrics
+
+	limitedMetric *prometheus.CounterVec
+}
+
+// foundValue is the value larger than the threshold
+type foundValue struct {
+	// parent is nil for the array elements
+	parent *insaneJSON.Node
+	name   string
+	label  string
+	value  *insaneJSON.Node
+}
+
+// ! config-params
+// ^ config-params
+type Config struct {
+	// > @3@4@5@6
+	// >
+	// > The list of the fields to check. If it's empty, all string values of the event are checked.
+	// > The strings of the arrays are checked too.
+	Fields []string `json:"fields" slice:"true"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The max size of the string value in bytes.
+	MaxSize  string `json:"max_size" default:"64 KB" parse:"data_unit"` // *
+	MaxSize_ uint
+
+	// > @3@4@5@6
+	// >
+	// > What to do with the larger values:
+	// > * `truncate` – cut the value to `max_size` bytes keeping the UTF-8 characters whole and append `marker`
+	// > * `drop` – remove the field or the array element
+	Mode string `json:"mode" default:"truncate" options:"truncate|drop"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The string appended to the truncated value.
+	Marker string `json:"marker"` // *
+
+	// > @3@4@5@6
+	// >
+	// > If set, the original size of the value is put to the field with the name of the limited field and the suffix, e.g. `message_original_size`.
+	// > The size isn't put for the array elements.
+	OriginalSizeSuffix string `json:"original_size_suffix"` // *
+}
+
+func init() {
+	fd.DefaultPluginRegistry.RegisterAction(&pipeline.PluginStaticInfo{
+		Type:    "limit_field_size",
+		Factory: factory,
+	})
+}
+
+func factory() (pipeline.AnyPlugin, pipeline.AnyConfig) {
+	return &Plugin{}, &Config{}
+}
+
+func (p *Plugin) Start(config pipeline.AnyConfig, params *pipeline.ActionPluginParams) {
+	p.config = config.(*Config)
+	p.registerMetrics(params.MetricCtl)
+
+	p.fields = make([][]string, 0, len(p.config.Fields))
+	for _, field := range p.config.Fields {
+		p.fields = append(p.fields, cfg.ParseFieldSelector(field))
+	}
+}
+
+func (p *Plugin) registerMetrics(ctl *metric.Ctl) {
+	p.limitedMetric = ctl.RegisterCounter("action_limit_field_size_limited_total", "Number of values truncated or dropped by the size", "field")
+}
+
+func (p *Plugin) Stop() {
+}
+
+func (p *Plugin) Do(event *pipeline.Event) pipeline.ActionResult {
+	// the values are found first since the objects are changed while limiting
+	p.found = p.found[:0]
+	if len(p.fields) == 0 {
+		p.find(event.Root.Node, nil, "", "")
+	}
+	for i, path := range p.fields {
+		node := event.Root.Dig(path...)
+		if node == nil {
+			continue
+		}
+		parent := event.Root.Dig(path[:len(path)-1]...)
+		if !parent.IsObject() {
+			parent = nil
+		}
+		p.find(node, parent, path[len(path)-1], p.config.Fields[i])
+	}
+
+	for _, f := range p.found {
+		p.limit(event, f)
+	}
+
+	return pipeline.ActionPass
+}
+
+// find adds the large strings of the node to the list, the label is the field name if it's empty.
+func (p *Plugin) find(node, parent *insaneJSON.Node, name, label string) {
+	switch {
+	case node.IsObject():
+		for _, field := range node.AsFields() {
+			p.find(field.AsFieldValue(), node, field.AsString(), label)
+		}
+	case node.IsArray():
+		for _, elem := range node.AsArray() {
+			p.find(elem, nil, name, label)
+		}
+	case node.IsString():
+		if uint(len(node.AsString())) <= p.config.MaxSize_ {
+			return
+		}
+		if label == "" {
+			label = name
+		}
+		p.found = append(p.found, foundValue{parent: parent, name: name, label: label, value: node})
+	}
+}
+
+func (p *Plugin) limit(event *pipeline.Event, f foundValue) {
+	value := f.value.AsString()
+	p.limitedMetric.WithLabelValues(f.label).Inc()
+
+	if p.config.Mode == modeDrop {
+		f.value.Suicide()
+	} else {
+		size := int(p.config.MaxSize_)
+		// the cut UTF-8 character is removed
+		for size > 0 && !utf8.RuneStart(value[size]) {
+			size--
+		}
+
+		l := len(event.Buf)
+		event.Buf = append(event.Buf, value[:size]...)
+		event.Buf = append(event.Buf, p.config.Marker...)
+		f.value.MutateToString(pipeline.ByteToStringUnsafe(event.Buf[l:]))
+	}
+
+	if p.config.OriginalSizeSuffix == "" || f.parent == nil {
+		return
+	}
+	l := len(event.Buf)
+	event.Buf = append(event.Buf, f.name...)
+	event.Buf = append(event.Buf, p.config.OriginalSizeSuffix...)
+	f.parent.AddFieldNoAlloc(event.Root, pipeline.ByteToStringUnsafe(event.Buf[l:])).MutateToInt(len(value))
+}
@@ -0,0 +1,66 @@
+package limit_field_size
+
+import (
+	"testing"
+
+	"github.com/ozontech/file.d/pipeline"
+	"github.com/ozontech/file.d/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	insaneJSON "github.com/vitkovskii/insane-json"
+)
+
+func TestLimitFieldSize(t *testing.T) {
+	cases := []struct {
+		name   string
+		config *Config
+		in     string
+		out    string
+	}{
+		{
+			name:   "fields",
+			config: &Config{Fields: []string{"msg", "err.trace"}, MaxSize: "4 b", Marker: "..."},
+			in:     `{"msg":"123456","err":{"trace":"abcdef"},"other":"abcdef","short":"ab"}`,
+			out:    `{"msg":"1234...","err":{"trace":"abcd..."},"other":"abcdef","short":"ab"}`,
+		},
+		{
+			name:   "all_strings",
+			config: &Config{MaxSize: "4 b"},
+			in:     `{"msg":"123456","err":{"trace":"abcdef"},"tags":["abcdef","ab"],"n":1234567}`,
+			out:    `{"msg":"1234","err":{"trace":"abcd"},"tags":["abcd","ab"],"n":1234567}`,
+		},
+		{
+			name:   "utf8",
+			config: &Config{Fields: []string{"msg"}, MaxSize: "4 b"},
+			in:     `{"msg":"абв"}`,
+			out:    `{"msg":"аб"}`,
+		},
+		{
+			name:   "drop",
+			config: &Config{MaxSize: "4 b", Mode: modeDrop},
+			in:     `{"msg":"123456","tags":["abcdef","ab"],"level":"info"}`,
+			out:    `{"level":"info","tags":["ab"]}`,
+		},
+		{
+			name:   "original_size",
+			config: &Config{MaxSize: "4 b", OriginalSizeSuffix: "_size"},
+			in:     `{"msg":"123456","err":{"trace":"abcdef"},"tags":["abcdef"]}`,
+			out:    `{"msg":"1234","err":{"trace":"abcd","trace_size":6},"tags":["abcd"],"msg_size":6}`,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			test.NewConfig(tt.config, nil)
+			p := &Plugin{}
+			p.Start(tt.config, test.NewEmptyActionPluginParams())
+
+			root, err := insaneJSON.DecodeString(tt.in)
+			require.NoError(t, err)
+			defer insaneJSON.Release(root)
+
+			p.Do(&pipeline.Event{Root: root})
+			assert.Equal(t, tt.out, root.EncodeToString())
+		})
+	}
+}
@@ -0,0 +1,198 @@
+package lookup
+
+import (
+	"time"
+
+	"github.com/ozontech/file.d/cfg"
+	"github.com/ozontech/file.d/fd"
+	"github.com/ozontech/file.d/metric"
+	"github.com/ozontech/file.d/pipeline"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+/*{ introduction
+It enriches the event with the values of the lookup table by the key from the event field.
+The table is loaded from the CSV or JSON file to the memory once, it's shared by all plugin instances
+and is reloaded when the file is changed.
+
+The CSV file should have the header, the key column is the first one if `key_column` isn't set.
+The JSON file should be the object of the keys, the values are the strings or the objects of the columns.
+The string values are in the `value` column. The empty values are considered missing.
+
+**Example:**
+```yaml
+pipelines:
+  example_pipeline:
+    ...
+    actions:
+    - type: lookup
+      file: /etc/file.d/services.csv
+      field: service_id
+      targets:
+        - column: team
+          field: owner.team
+          default: unknown
+        - column: channel
+          field: owner.channel
+    ...
+```
+
+The `services.csv`:
+```
+id,team,channel
+svc-1,payments,#payments-alerts
+```
+
+The event `{"service_id":"svc-1"}` will be transformed to:
+```json
+{"service_id":"svc-1","owner":{"team":"payments","channel":"#payments-alerts"}}
+```
+}*/
+
+type Plugin struct {
+	config  *Config
+	logger  *zap.SugaredLogger
+	table   *table
+	targets []target
+
+	// plugin metrics
+	missesMetric *prometheus.CounterVec
+}
+
+type target struct {
+	column string
+	field  []string
+	def    string
+}
+
+// ! config-params
+// ^ config-params
+type Config struct {
+	// > @3@4@5@6
+	// >
+	// > The path to the lookup table file.
+	File string `json:"file" required:"true"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The format of the file.
+	Format string `json:"format" default:"csv" options:"csv|json"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The key column of the CSV file. If it's empty, the first column is used.
+	KeyColumn string `json:"key_column"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The event field which contains the key.
+	Field  cfg.FieldSelector `json:"field" parse:"selector" required:"true"` // *
+	Field_ []string
+
+	// > @3@4@5@6
+	// >
+	// > The list of the fields to set. It's a list of objects, each of them has the fields:
+	// > * `column` – the column of the table, it's `value` for the string values of JSON file
+	// > * `field` – the event field to put the value to, e.g. `owner.team`
+	// > * `default` – the value to set if the key or the value is missing, the field isn't set if it's empty
+	Targets []TargetConfig `json:"targets" required:"true" slice:"true"` // *
+
+	// > @3@4@5@6
+	// >
+	// > How often to check if the file is changed. Zero disables reloading.
+	ReloadInterval  cfg.Duration `json:"reload_interval" default:"1m" parse:"duration"` // *
+	ReloadInterval_ time.Duration
+}
+
+type TargetConfig struct {
+	Column  string `json:"column" required:"true"`
+	Field   string `json:"field" required:"true"`
+	Default string `json:"default"`
+}
+
+func init() {
+	fd.DefaultPluginRegistry.RegisterAction(&pipeline.PluginStaticInfo{
+		Type:    "lookup",
+		Factory: factory,
+	})
+}
+
+func factory() (pipeline.AnyPlugin, pipeline.AnyConfig) {
+	return &Plugin{}, &Config{}
+}
+
+func (p *Plugin) Start(config pipeline.AnyConfig, params *pipeline.ActionPluginParams) {
+	p.config = config.(*Config)
+	p.logger = params.Logger
+	p.registerMetrics(params.MetricCtl)
+
+	if len(p.config.Targets) == 0 {
+		p.logger.Fatalf("targets should be set")
+	}
+	p.targets = make([]target, 0, len(p.config.Targets))
+	for _, t := range p.config.Targets {
+		p.targets = append(p.targets, target{
+			column: t.Column,
+			field:  cfg.ParseFieldSelector(t.Field),
+			def:    t.Default,
+		})
+	}
+
+	key := tableKey{path: p.config.File, format: p.config.Format, keyColumn: p.config.KeyColumn}
+	t, err := acquireTable(key, p.config.ReloadInterval_, p.logger)
+	if err != nil {
+		p.logger.Fatalf("can't load lookup table %s: %s", p.config.File, err.Error())
+	}
+	p.table = t
+}
+
+func (p *Plugin) registerMetrics(ctl *metric.Ctl) {
+	p.missesMetric = ctl.RegisterCounter("action_lookup_misses_total", "Number of events which keys aren't found in the lookup table")
+}
+
+func (p *Plugin) Stop() {
+	p.table.release()
+}
+
+func (p *Plugin) Do(event *pipeline.Event) pipeline.ActionResult {
+	node := event.Root.Dig(p.config.Field_...)
+	if node == nil {
+		p.missesMetric.WithLabelValues().Inc()
+		p.setDefaults(event)
+		return pipeline.ActionPass
+	}
+
+	// the data is loaded once per event to not mix the values of the reloaded table
+	d := p.table.data.Load()
+	key := node.AsString()
+	if _, has := d.rows[key]; !has {
+		p.missesMetric.WithLabelValues().Inc()
+		p.setDefaults(event)
+		return pipeline.ActionPass
+	}
+
+	for i := range p.targets {
+		t := &p.targets[i]
+		value, ok := d.value(key, t.column)
+		if !ok {
+			value = t.def
+		}
+		p.set(event, t.field, value)
+	}
+
+	return pipeline.ActionPass
+}
+
+func (p *Plugin) setDefaults(event *pipeline.Event) {
+	for i := range p.targets {
+		p.set(event, p.targets[i].field, p.targets[i].def)
+	}
+}
+
+func (p *Plugin) set(event *pipeline.Event, field []string, value string) {
+	if value == "" {
+		return
+	}
+	pipeline.CreateNestedField(event.Root, field).MutateToString(value)
+}
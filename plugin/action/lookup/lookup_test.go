@@ -0,0 +1,130 @@
+package lookup
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ozontech/file.d/pipeline"
+	"github.com/ozontech/file.d/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	insaneJSON "github.com/vitkovskii/insane-json"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+}
+
+func TestLookup(t *testing.T) {
+	dir := t.TempDir()
+	csvPath := filepath.Join(dir, "services.csv")
+	writeFile(t, csvPath, "team,id,channel\npayments,svc-1,#payments\nsearch,svc-2,\n")
+	jsonPath := filepath.Join(dir, "services.json")
+	writeFile(t, jsonPath, `{"svc-1":{"team":"payments","port":8080},"svc-2":"search"}`)
+
+	cases := []struct {
+		name   string
+		config *Config
+		in     []string
+		out    []string
+	}{
+		{
+			name: "csv",
+			config: &Config{
+				File:      csvPath,
+				KeyColumn: "id",
+				Field:     "service",
+				Targets: []TargetConfig{
+					{Column: "team", Field: "owner.team", Default: "unknown"},
+					{Column: "channel", Field: "owner.channel"},
+				},
+			},
+			in: []string{
+				`{"service":"svc-1"}`,
+				`{"service":"svc-2"}`,
+				`{"service":"svc-3"}`,
+				`{"message":"no service"}`,
+			},
+			out: []string{
+				`{"service":"svc-1","owner":{"team":"payments","channel":"#payments"}}`,
+				`{"service":"svc-2","owner":{"team":"search"}}`,
+				`{"service":"svc-3","owner":{"team":"unknown"}}`,
+				`{"message":"no service","owner":{"team":"unknown"}}`,
+			},
+		},
+		{
+			name: "json",
+			config: &Config{
+				File:   jsonPath,
+				Format: formatJSON,
+				Field:  "service",
+				Targets: []TargetConfig{
+					{Column: "team", Field: "team"},
+					{Column: "port", Field: "port"},
+					{Column: jsonValueColumn, Field: "value"},
+				},
+			},
+			in: []string{
+				`{"service":"svc-1"}`,
+				`{"service":"svc-2"}`,
+			},
+			out: []string{
+				`{"service":"svc-1","team":"payments","port":"8080"}`,
+				`{"service":"svc-2","value":"search"}`,
+			},
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			test.NewConfig(tt.config, nil)
+			p := &Plugin{}
+			p.Start(tt.config, test.NewEmptyActionPluginParams())
+			defer p.Stop()
+
+			for i, in := range tt.in {
+				root, err := insaneJSON.DecodeString(in)
+				require.NoError(t, err)
+
+				p.Do(&pipeline.Event{Root: root})
+				assert.Equal(t, tt.out[i], root.EncodeToString())
+				insaneJSON.Release(root)
+			}
+		})
+	}
+}
+
+func TestTableReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "services.csv")
+	writeFile(t, path, "id,team\nsvc-1,payments\n")
+
+	key := tableKey{path: path, format: formatCSV}
+	table, err := acquireTable(key, 10*time.Millisecond, test.NewEmptyOutputPluginParams().Logger)
+	require.NoError(t, err)
+	defer table.release()
+
+	// the table is shared
+	same, err := acquireTable(key, 10*time.Millisecond, test.NewEmptyOutputPluginParams().Logger)
+	require.NoError(t, err)
+	require.Same(t, table, same)
+	same.release()
+
+	value, ok := table.value("svc-1", "team")
+	require.True(t, ok)
+	require.Equal(t, "payments", value)
+
+	// the broken file is ignored
+	writeFile(t, path, "id,team\n\"svc-1,payments\n")
+	time.Sleep(50 * time.Millisecond)
+	value, ok = table.value("svc-1", "team")
+	require.True(t, ok)
+	require.Equal(t, "payments", value)
+
+	writeFile(t, path, "id,team\nsvc-1,billing\n")
+	require.Eventually(t, func() bool {
+		value, ok := table.value("svc-1", "team")
+		return ok && value == "billing"
+	}, 5*time.Second, 10*time.Millisecond)
+}
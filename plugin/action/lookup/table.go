@@ -0,0 +1,264 @@
+package lookup
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	formatCSV  = "csv"
+	formatJSON = "json"
+
+	// jsonValueColumn is the column of the scalar values of json tables
+	jsonValueColumn = "value"
+)
+
+var (
+	// tables are shared across all plugin instances by the file path and the format
+	tables   = map[tableKey]*table{}
+	tablesMu = &sync.Mutex{}
+
+	errNoColumns = errors.New("there are no columns in the header")
+)
+
+type tableKey struct {
+	path      string
+	format    string
+	keyColumn string
+}
+
+// data is the loaded content of the file, it's replaced as a whole on reload
+type data struct {
+	columns map[string]int
+	rows    map[string][]string
+}
+
+// value returns the value of the column in the row of the key, the empty values are missing
+func (d *data) value(key, column string) (string, bool) {
+	row, ok := d.rows[key]
+	if !ok {
+		return "", false
+	}
+	i, ok := d.columns[column]
+	if !ok || i >= len(row) {
+		return "", false
+	}
+	return row[i], row[i] != ""
+}
+
+// table is the lookup file loaded to the memory, it's reloaded when the file is changed
+type table struct {
+	key    tableKey
+	data   atomic.Pointer[data]
+	logger *zap.SugaredLogger
+
+	// refs is the number of plugin instances using the table
+	refs    int
+	modTime time.Time
+	size    int64
+	stopCh  chan struct{}
+}
+
+// acquireTable returns the shared table of the file, the first call loads it
+func acquireTable(key tableKey, reloadInterval time.Duration, logger *zap.SugaredLogger) (*table, error) {
+	tablesMu.Lock()
+	defer tablesMu.Unlock()
+
+	if t, has := tables[key]; has {
+		t.refs++
+		return t, nil
+	}
+
+	t := &table{
+		key:    key,
+		logger: logger,
+		refs:   1,
+		stopCh: make(chan struct{}),
+	}
+	if err := t.load(); err != nil {
+		return nil, err
+	}
+	tables[key] = t
+
+	if reloadInterval > 0 {
+		go t.watch(reloadInterval)
+	}
+
+	return t, nil
+}
+
+func (t *table) release() {
+	tablesMu.Lock()
+	defer tablesMu.Unlock()
+
+	t.refs--
+	if t.refs > 0 {
+		return
+	}
+	close(t.stopCh)
+	delete(tables, t.key)
+}
+
+// load parses the whole file, so the previous data can be used by lookups while the new one is loaded
+func (t *table) load() error {
+	stat, err := os.Stat(t.key.path)
+	if err != nil {
+		return err
+	}
+	content, err := os.ReadFile(t.key.path)
+	if err != nil {
+		return err
+	}
+
+	var d *data
+	if t.key.format == formatJSON {
+		d, err = parseJSON(content)
+	} else {
+		d, err = parseCSV(content, t.key.keyColumn)
+	}
+	if err != nil {
+		return err
+	}
+
+	t.data.Store(d)
+	t.modTime = stat.ModTime()
+	t.size = stat.Size()
+	return nil
+}
+
+func (t *table) watch(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			t.reloadIfChanged()
+		case <-t.stopCh:
+			return
+		}
+	}
+}
+
+func (t *table) reloadIfChanged() {
+	stat, err := os.Stat(t.key.path)
+	if err != nil {
+		t.logger.Errorf("can't stat lookup table %s: %s", t.key.path, err.Error())
+		return
+	}
+	if stat.ModTime().Equal(t.modTime) && stat.Size() == t.size {
+		return
+	}
+
+	if err := t.load(); err != nil {
+		t.logger.Errorf("can't reload lookup table %s, the previous one is used: %s", t.key.path, err.Error())
+		return
+	}
+	t.logger.Infof("lookup table %s is reloaded", t.key.path)
+}
+
+func (t *table) value(key, column string) (string, bool) {
+	return t.data.Load().value(key, column)
+}
+
+// parseCSV reads the csv with the header, the first column is the key if the key column isn't set
+func parseCSV(content []byte, keyColumn string) (*data, error) {
+	r := csv.NewReader(bytes.NewReader(content))
+	r.FieldsPerRecord = -1
+
+	header, err := r.Read()
+	if err == io.EOF {
+		return nil, errNoColumns
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	d := &data{
+		columns: make(map[string]int, len(header)),
+		rows:    make(map[string][]string),
+	}
+	for i, column := range header {
+		d.columns[column] = i
+	}
+
+	keyIndex := 0
+	if keyColumn != "" {
+		i, ok := d.columns[keyColumn]
+		if !ok {
+			return nil, fmt.Errorf("there is no key column %q in the header", keyColumn)
+		}
+		keyIndex = i
+	}
+
+	for {
+		row, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if keyIndex >= len(row) {
+			continue
+		}
+		d.rows[row[keyIndex]] = row
+	}
+
+	return d, nil
+}
+
+// parseJSON reads the object of the keys, the values are the strings or the objects of the columns
+func parseJSON(content []byte) (*data, error) {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(content, &raw); err != nil {
+		return nil, err
+	}
+
+	d := &data{
+		columns: map[string]int{jsonValueColumn: 0},
+		rows:    make(map[string][]string, len(raw)),
+	}
+	for key, value := range raw {
+		var columns map[string]json.RawMessage
+		if err := json.Unmarshal(value, &columns); err != nil {
+			d.rows[key] = []string{jsonString(value)}
+			continue
+		}
+
+		row := make([]string, len(d.columns))
+		for column, columnValue := range columns {
+			i, ok := d.columns[column]
+			if !ok {
+				i = len(d.columns)
+				d.columns[column] = i
+			}
+			for len(row) <= i {
+				row = append(row, "")
+			}
+			row[i] = jsonString(columnValue)
+		}
+		d.rows[key] = row
+	}
+
+	return d, nil
+}
+
+// jsonString returns the string value as is and other values as json
+func jsonString(value json.RawMessage) string {
+	var s string
+	if err := json.Unmarshal(value, &s); err == nil {
+		return s
+	}
+	return string(value)
+}
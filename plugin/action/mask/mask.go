@@ -1,7 +1,11 @@
 package mask
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
 	"regexp"
+	"strconv"
 	"strings"
 	"unicode/utf8"
 
@@ -33,10 +37,75 @@ pipelines:
     ...
 ```
 
+The named groups of the expression can be processed separately with `group_actions`,
+e.g. to keep the last digits of the card number and to hash the domain of the email:
+```yaml
+pipelines:
+  example_pipeline:
+    ...
+    actions:
+    - type: mask
+      masks:
+      - name: card
+        re: "\b(?P<head>\d{4}-\d{4}-\d{4}-)(?P<tail>\d{4})\b"
+        group_actions:
+          tail:
+            action: keep
+      - name: email
+        re: "(?P<user>[\w.+-]+)@(?P<domain>[\w.-]+)"
+        hash_salt: "some_salt"
+        group_actions:
+          user:
+            action: replace
+            value: "<user>"
+          domain:
+            action: hash
+    ...
+```
+
+The regular expression alone can't tell the card number from any other number of the same length.
+The `luhn` and `iban` detectors check the checksum of the matches, so the numeric IDs aren't masked.
+If the expression isn't set, the detector masks the matches of the default one:
+```yaml
+pipelines:
+  example_pipeline:
+    ...
+    actions:
+    - type: mask
+      masks:
+      - name: card
+        detector: luhn
+        re: "\b(?P<head>(?:\d[ -]?){9,15})(?P<tail>\d{4})\b"
+        group_actions:
+          tail:
+            action: keep
+      - name: iban
+        detector: iban
+    ...
+```
+The `action_mask_detector_matches_total` metric counts the masked matches by the `detector` label
+and the `action_mask_detector_rejected_total` one counts the matches rejected by the checksum.
+
 }*/
 
 const (
 	substitution = byte('*')
+
+	groupActionMask    = "mask"
+	groupActionKeep    = "keep"
+	groupActionReplace = "replace"
+	groupActionHash    = "hash"
+
+	defaultHashLength = 16
+
+	detectorRegex = "regex"
+	detectorLuhn  = "luhn"
+	detectorIBAN  = "iban"
+
+	// defaultLuhnRe matches 13-19 digits, which may be separated by spaces or dashes
+	defaultLuhnRe = `\b(\d(?:[ -]?\d){12,18})\b`
+	// defaultIBANRe matches the country code, the check digits and up to 30 alphanumeric characters, which may be separated by spaces
+	defaultIBANRe = `\b([A-Z]{2}\d{2}(?: ?[A-Z0-9]){11,30})\b`
 )
 
 type Plugin struct {
@@ -51,9 +120,16 @@ type Plugin struct {
 	valueNodes []*insaneJSON.Node
 	logger     *zap.Logger
 
+	// hasher and hashBuf are reused by the hash group action
+	hasher  hash.Hash
+	hashBuf []byte
+	// eventMasks marks the masks applied to the current event
+	eventMasks []bool
+
 	//  plugin metrics
 
-	maskAppliedMetric *prometheus.CounterVec
+	maskAppliedMetric  *prometheus.CounterVec
+	maskedEventsMetric *prometheus.CounterVec
 }
 
 // ! config-params
@@ -86,6 +162,11 @@ type Config struct {
 }
 
 type Mask struct {
+	// > @3@4@5@6
+	// >
+	// > The name of the mask for the `action_mask_masked_events_total` metric. If it's empty, the index of the mask is used.
+	Name string `json:"name"` // *
+
 	// > @3@4@5@6
 	// >
 	// > List of matching rules to filter out events before checking regular expression for masking.
@@ -97,11 +178,48 @@ type Mask struct {
 	Re  string `json:"re" default:""` // *
 	Re_ *regexp.Regexp
 
+	// > @3@4@5@6
+	// >
+	// > The detector validating the matches of the expression before masking:
+	// > * `regex` – masks all the matches
+	// > * `luhn` – masks the matches with the valid Luhn checksum, e.g. the card numbers
+	// > * `iban` – masks the matches with the valid IBAN checksum
+	// >
+	// > The separators of the match are ignored by the checksum. If `re` is empty, the detector uses the default expression
+	// > and masks the whole match unless `groups` or `group_actions` are set.
+	Detector string `json:"detector" default:"regex" options:"regex|luhn|iban"` // *
+	// validate_ returns false if the match is rejected by the detector
+	validate_ func(match []byte) bool
+
 	// > @3@4@5@6
 	// >
 	// > Groups are numbers of masking groups in expression, zero for mask all expression.
 	Groups []int `json:"groups"` // *
 
+	// > @3@4@5@6
+	// >
+	// > The actions for the named groups of the expression, it can't be used with `groups`.
+	// > Each action is the object with the `action` and the `value` fields:
+	// > * `mask` – masks the group as `groups` do, the named groups which aren't listed are masked too
+	// > * `keep` – keeps the group as is
+	// > * `replace` – replaces the group with the `value`
+	// > * `hash` – replaces the group with the hex of salted sha256 truncated to `hash_length`
+	// >
+	// > The text of the match out of the named groups is kept.
+	GroupActions map[string]GroupAction `json:"group_actions"` // *
+	// groupActions_ contains the action for each group of the expression by its number
+	groupActions_ []GroupAction
+
+	// > @3@4@5@6
+	// >
+	// > The salt prepended to the value by the `hash` group action.
+	HashSalt string `json:"hash_salt"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The number of the hex characters of the hash kept by the `hash` group action. If it's zero, 16 characters are kept.
+	HashLength int `json:"hash_length"` // *
+
 	// > @3@4@5@6
 	// >
 	// > MaxCount limits the number of masked symbols in the masked output, if zero, no limit is set.
@@ -136,6 +254,14 @@ type Mask struct {
 
 	// mask metric
 	appliedMetric *prometheus.CounterVec
+
+	detectorMatchesMetric  prometheus.Counter
+	detectorRejectedMetric prometheus.Counter
+}
+
+type GroupAction struct {
+	Action string `json:"action"`
+	Value  string `json:"value"`
 }
 
 func init() {
@@ -179,6 +305,7 @@ func compileMasks(masks []Mask, logger *zap.Logger) []Mask {
 }
 
 func compileMask(m *Mask, logger *zap.Logger) {
+	compileDetector(m, logger)
 	if m.Re == "" && len(m.MatchRules) == 0 {
 		logger.Fatal("mask must have either nonempty regex or ruleset, or both")
 	}
@@ -190,6 +317,7 @@ func compileMask(m *Mask, logger *zap.Logger) {
 		}
 		m.Re_ = re
 		m.Groups = verifyGroupNumbers(m.Groups, re.NumSubexp(), logger)
+		compileGroupActions(m, logger)
 	}
 	for i, matchRule := range m.MatchRules {
 		if len(matchRule.Rules) == 0 {
@@ -204,6 +332,126 @@ func compileMask(m *Mask, logger *zap.Logger) {
 	}
 }
 
+func compileDetector(m *Mask, logger *zap.Logger) {
+	switch m.Detector {
+	case "", detectorRegex:
+		m.Detector = detectorRegex
+		return
+	case detectorLuhn:
+		m.validate_ = isLuhnValid
+		if m.Re == "" {
+			m.Re = defaultLuhnRe
+		}
+	case detectorIBAN:
+		m.validate_ = isIBANValid
+		if m.Re == "" {
+			m.Re = defaultIBANRe
+		}
+	default:
+		logger.Fatal("wrong detector", zap.String("detector", m.Detector))
+	}
+
+	// the default expression masks the whole match
+	if (m.Re == defaultLuhnRe || m.Re == defaultIBANRe) && len(m.Groups) == 0 && len(m.GroupActions) == 0 {
+		m.Groups = []int{0}
+	}
+}
+
+// isLuhnValid checks the Luhn checksum of the digits of the match, the other symbols are ignored
+func isLuhnValid(match []byte) bool {
+	sum := 0
+	digits := 0
+	for i := len(match) - 1; i >= 0; i-- {
+		c := match[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int(c - '0')
+		if digits%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		digits++
+	}
+	return digits >= 2 && sum%10 == 0
+}
+
+// isIBANValid checks the ISO 13616 checksum of the match, the spaces are ignored
+func isIBANValid(match []byte) bool {
+	const minLen, maxLen = 15, 34
+
+	iban := make([]byte, 0, maxLen)
+	for _, c := range match {
+		if c == ' ' {
+			continue
+		}
+		if len(iban) == maxLen {
+			return false
+		}
+		iban = append(iban, c)
+	}
+	if len(iban) < minLen {
+		return false
+	}
+
+	// the country code and the check digits are moved to the end,
+	// the letters are replaced with the numbers 10-35 and the result modulo 97 must be 1
+	mod := 0
+	for i := range iban {
+		c := iban[(i+4)%len(iban)]
+		switch {
+		case c >= '0' && c <= '9':
+			mod = (mod*10 + int(c-'0')) % 97
+		case c >= 'A' && c <= 'Z':
+			mod = (mod*100 + int(c-'A') + 10) % 97
+		default:
+			return false
+		}
+	}
+	return mod == 1
+}
+
+func compileGroupActions(m *Mask, logger *zap.Logger) {
+	if len(m.GroupActions) == 0 {
+		return
+	}
+	if len(m.Groups) != 0 {
+		logger.Fatal("groups and group_actions can't be used together", zap.String("re", m.Re))
+	}
+
+	if m.HashLength == 0 {
+		m.HashLength = defaultHashLength
+	}
+	if m.HashLength < 0 || m.HashLength > sha256.Size*2 {
+		logger.Fatal("wrong hash length", zap.Int("hash_length", m.HashLength))
+	}
+
+	names := m.Re_.SubexpNames()
+	m.groupActions_ = make([]GroupAction, len(names))
+	for name, action := range m.GroupActions {
+		i := m.Re_.SubexpIndex(name)
+		if i < 0 {
+			logger.Fatal("there is no such named group", zap.String("group", name), zap.String("re", m.Re))
+		}
+		switch action.Action {
+		case groupActionMask, groupActionKeep, groupActionReplace, groupActionHash:
+		default:
+			logger.Fatal("wrong group action", zap.String("group", name), zap.String("action", action.Action))
+		}
+		m.groupActions_[i] = action
+	}
+
+	// named groups which aren't listed are masked
+	for i, name := range names {
+		if name != "" && m.groupActions_[i].Action == "" {
+			m.groupActions_[i].Action = groupActionMask
+		}
+	}
+}
+
 func isGroupsUnique(groups []int) bool {
 	uniqueGrp := make(map[int]struct{}, len(groups))
 	var exists struct{}
@@ -248,12 +496,17 @@ func (p *Plugin) Start(config pipeline.AnyConfig, params *pipeline.ActionPluginP
 	p.maskBuf = make([]byte, 0, params.PipelineSettings.AvgEventSize)
 	p.sourceBuf = make([]byte, 0, params.PipelineSettings.AvgEventSize)
 	p.valueNodes = make([]*insaneJSON.Node, 0)
+	p.hasher = sha256.New()
+	p.eventMasks = make([]bool, len(p.config.Masks))
 	p.logger = params.Logger.Desugar()
 	p.config.Masks = compileMasks(p.config.Masks, p.logger)
 	p.registerMetrics(params.MetricCtl)
 }
 
 func (p *Plugin) registerMetrics(ctl *metric.Ctl) {
+	p.maskedEventsMetric = ctl.RegisterCounter("action_mask_masked_events_total", "Number of events masked by the mask", "mask")
+	detectorMatches := ctl.RegisterCounter("action_mask_detector_matches_total", "Number of matches masked by the detector", "detector")
+	detectorRejected := ctl.RegisterCounter("action_mask_detector_rejected_total", "Number of matches rejected by the detector checksum", "detector")
 	p.maskAppliedMetric = p.makeMetric(ctl,
 		p.config.AppliedMetricName,
 		"Number of times mask plugin found the provided pattern",
@@ -261,6 +514,8 @@ func (p *Plugin) registerMetrics(ctl *metric.Ctl) {
 	)
 	for i := range p.config.Masks {
 		mask := &p.config.Masks[i]
+		mask.detectorMatchesMetric = detectorMatches.WithLabelValues(mask.Detector)
+		mask.detectorRejectedMetric = detectorRejected.WithLabelValues(mask.Detector)
 		if mask.MetricName == p.config.AppliedMetricName {
 			p.logger.Error(
 				"mask cannot have metric with the same name as the plugin",
@@ -347,9 +602,19 @@ func (p *Plugin) maskValue(mask *Mask, value, buf []byte) ([]byte, bool) {
 	}
 
 	indexes := mask.Re_.FindAllSubmatchIndex(value, -1)
+	if mask.validate_ != nil {
+		indexes = p.validateMatches(mask, value, indexes)
+	}
 	if len(indexes) == 0 {
 		return buf, false
 	}
+	if mask.detectorMatchesMetric != nil {
+		mask.detectorMatchesMetric.Add(float64(len(indexes)))
+	}
+	if len(mask.groupActions_) != 0 {
+		return p.applyGroupActions(mask, value, buf, indexes), true
+	}
+
 	// special case, groups can be an empty slice,
 	// but the mask is considered as applied for accounting metrics
 	if len(mask.Groups) == 0 {
@@ -374,6 +639,64 @@ func (p *Plugin) maskValue(mask *Mask, value, buf []byte) ([]byte, bool) {
 	return value, true
 }
 
+// validateMatches drops the matches rejected by the detector
+func (p *Plugin) validateMatches(mask *Mask, value []byte, indexes [][]int) [][]int {
+	valid := indexes[:0]
+	for _, index := range indexes {
+		if !mask.validate_(value[index[0]:index[1]]) {
+			if mask.detectorRejectedMetric != nil {
+				mask.detectorRejectedMetric.Inc()
+			}
+			continue
+		}
+		valid = append(valid, index)
+	}
+	return valid
+}
+
+// applyGroupActions builds the value with the actions applied to the named groups of the matches
+func (p *Plugin) applyGroupActions(mask *Mask, value, buf []byte, indexes [][]int) []byte {
+	buf = buf[:0]
+
+	last := 0
+	for _, index := range indexes {
+		for grp := 1; grp < len(mask.groupActions_); grp++ {
+			begin, end := index[grp*2], index[grp*2+1]
+			action := &mask.groupActions_[grp]
+			// unnamed, not participating and nested groups are skipped
+			if action.Action == "" || begin < 0 || begin < last {
+				continue
+			}
+
+			buf = append(buf, value[last:begin]...)
+			switch action.Action {
+			case groupActionMask:
+				buf, _ = p.appendMask(mask, buf, value, begin, end)
+			case groupActionKeep:
+				buf = append(buf, value[begin:end]...)
+			case groupActionReplace:
+				buf = append(buf, action.Value...)
+			case groupActionHash:
+				buf = p.appendHash(mask, buf, value[begin:end])
+			}
+			last = end
+		}
+	}
+
+	return append(buf, value[last:]...)
+}
+
+func (p *Plugin) appendHash(mask *Mask, dst, src []byte) []byte {
+	p.hasher.Reset()
+	_, _ = p.hasher.Write([]byte(mask.HashSalt))
+	_, _ = p.hasher.Write(src)
+	p.hashBuf = p.hasher.Sum(p.hashBuf[:0])
+
+	var hexBuf [sha256.Size * 2]byte
+	hex.Encode(hexBuf[:], p.hashBuf)
+	return append(dst, hexBuf[:mask.HashLength]...)
+}
+
 func getValueNodeList(currentNode *insaneJSON.Node, valueNodes []*insaneJSON.Node) []*insaneJSON.Node {
 	switch {
 	case currentNode.IsField():
@@ -399,6 +722,7 @@ func (p *Plugin) Do(event *pipeline.Event) pipeline.ActionResult {
 	maskApplied := false
 	locApplied := false
 
+	clear(p.eventMasks)
 	p.valueNodes = p.valueNodes[:0]
 	p.valueNodes = getValueNodeList(root, p.valueNodes)
 	for _, v := range p.valueNodes {
@@ -412,6 +736,7 @@ func (p *Plugin) Do(event *pipeline.Event) pipeline.ActionResult {
 			if !locApplied {
 				continue
 			}
+			p.eventMasks[i] = true
 			if mask.AppliedField != "" {
 				event.Root.AddFieldNoAlloc(event.Root, mask.AppliedField).MutateToString(mask.AppliedValue)
 			}
@@ -424,6 +749,17 @@ func (p *Plugin) Do(event *pipeline.Event) pipeline.ActionResult {
 		v.MutateToString(string(p.maskBuf))
 	}
 
+	for i, applied := range p.eventMasks {
+		if !applied {
+			continue
+		}
+		name := p.config.Masks[i].Name
+		if name == "" {
+			name = strconv.Itoa(i)
+		}
+		p.maskedEventsMetric.WithLabelValues(name).Inc()
+	}
+
 	if p.config.MaskAppliedField != "" && maskApplied {
 		event.Root.AddFieldNoAlloc(event.Root, p.config.MaskAppliedField).MutateToString(p.config.MaskAppliedValue)
 	}
@@ -9,6 +9,7 @@ import (
 	"github.com/ozontech/file.d/cfg/matchrule"
 	"github.com/ozontech/file.d/pipeline"
 	"github.com/ozontech/file.d/test"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	insaneJSON "github.com/vitkovskii/insane-json"
@@ -188,6 +189,148 @@ func TestMaskAddExtraField(t *testing.T) {
 	assert.Equal(t, expOutput, event.Root.EncodeToString())
 }
 
+func TestMaskGroupActions(t *testing.T) {
+	suits := []struct {
+		name     string
+		mask     Mask
+		input    string
+		expected string
+	}{
+		{
+			name: "keep",
+			mask: Mask{
+				Re: `\b(?P<head>\d{4}-\d{4}-\d{4}-)(?P<tail>\d{4})\b`,
+				GroupActions: map[string]GroupAction{
+					"tail": {Action: groupActionKeep},
+				},
+			},
+			input:    `{"card":"card 5408-7430-0756-2004 is used"}`,
+			expected: `{"card":"card ***************2004 is used"}`,
+		},
+		{
+			name: "replace_and_hash",
+			mask: Mask{
+				Re:         `(?P<user>[\w.+-]+)@(?P<domain>[\w.-]+)`,
+				HashSalt:   "salt",
+				HashLength: 8,
+				GroupActions: map[string]GroupAction{
+					"user":   {Action: groupActionReplace, Value: "<user>"},
+					"domain": {Action: groupActionHash},
+				},
+			},
+			input:    `{"email":"a.b@example.com, c@example.com"}`,
+			expected: `{"email":"<user>@1853bf04, <user>@1853bf04"}`,
+		},
+		{
+			name: "nested_and_optional",
+			mask: Mask{
+				Re:          `(?P<outer>id=(?P<inner>\d+))(?P<opt>!)?`,
+				ReplaceWord: "<id>",
+				GroupActions: map[string]GroupAction{
+					"inner": {Action: groupActionKeep},
+				},
+			},
+			input:    `{"msg":"id=1 and id=2!"}`,
+			expected: `{"msg":"<id> and <id><id>"}`,
+		},
+	}
+
+	for _, tCase := range suits {
+		t.Run(tCase.name, func(t *testing.T) {
+			root, err := insaneJSON.DecodeString(tCase.input)
+			require.NoError(t, err)
+			defer insaneJSON.Release(root)
+
+			config := test.NewConfig(&Config{Masks: []Mask{tCase.mask}}, nil)
+			var plugin Plugin
+			plugin.Start(config, test.NewEmptyActionPluginParams())
+
+			plugin.Do(&pipeline.Event{Root: root})
+			assert.Equal(t, tCase.expected, root.EncodeToString())
+		})
+	}
+}
+
+func TestMaskDetectors(t *testing.T) {
+	suits := []struct {
+		name     string
+		mask     Mask
+		input    string
+		expected string
+		matches  float64
+		rejected float64
+	}{
+		{
+			name:     "luhn",
+			mask:     Mask{Detector: detectorLuhn},
+			input:    `{"msg":"card 4111 1111 1111 1111, order 1234567812345678"}`,
+			expected: `{"msg":"card *******************, order 1234567812345678"}`,
+			matches:  1,
+			rejected: 1,
+		},
+		{
+			name: "luhn_group_actions",
+			mask: Mask{
+				Detector: detectorLuhn,
+				Re:       `\b(?P<head>(?:\d[ -]?){9,15})(?P<tail>\d{4})\b`,
+				GroupActions: map[string]GroupAction{
+					"tail": {Action: groupActionKeep},
+				},
+			},
+			input:    `{"msg":"card 5408-7430-0756-2004"}`,
+			expected: `{"msg":"card ***************2004"}`,
+			matches:  1,
+		},
+		{
+			name:     "iban",
+			mask:     Mask{Detector: detectorIBAN, ReplaceWord: "<iban>", Groups: []int{0}},
+			input:    `{"msg":"to GB82 WEST 1234 5698 7654 32 from DE89370400440532013000, not GB82WEST12345698765433"}`,
+			expected: `{"msg":"to <iban> from <iban>, not GB82WEST12345698765433"}`,
+			matches:  2,
+			rejected: 1,
+		},
+		{
+			name:     "regex",
+			mask:     Mask{Re: `(\d{16})`, Groups: []int{0}},
+			input:    `{"msg":"order 1234567812345678"}`,
+			expected: `{"msg":"order ****************"}`,
+			matches:  1,
+		},
+	}
+
+	for _, tCase := range suits {
+		t.Run(tCase.name, func(t *testing.T) {
+			root, err := insaneJSON.DecodeString(tCase.input)
+			require.NoError(t, err)
+			defer insaneJSON.Release(root)
+
+			config := test.NewConfig(&Config{Masks: []Mask{tCase.mask}}, nil)
+			var plugin Plugin
+			plugin.Start(config, test.NewEmptyActionPluginParams())
+
+			plugin.Do(&pipeline.Event{Root: root})
+			assert.Equal(t, tCase.expected, root.EncodeToString())
+
+			mask := &plugin.config.Masks[0]
+			assert.Equal(t, tCase.matches, testutil.ToFloat64(mask.detectorMatchesMetric))
+			assert.Equal(t, tCase.rejected, testutil.ToFloat64(mask.detectorRejectedMetric))
+		})
+	}
+}
+
+func TestChecksums(t *testing.T) {
+	assert.True(t, isLuhnValid([]byte("4111-1111-1111-1111")))
+	assert.True(t, isLuhnValid([]byte("79927398713")))
+	assert.False(t, isLuhnValid([]byte("79927398710")))
+	assert.False(t, isLuhnValid([]byte("0")))
+
+	assert.True(t, isIBANValid([]byte("GB82 WEST 1234 5698 7654 32")))
+	assert.True(t, isIBANValid([]byte("NL91ABNA0417164300")))
+	assert.False(t, isIBANValid([]byte("NL91ABNA0417164301")))
+	assert.False(t, isIBANValid([]byte("NL91abna0417164300")), "lower case isn't valid")
+	assert.False(t, isIBANValid([]byte("NL91")))
+}
+
 func TestGroupNumbers(t *testing.T) {
 	suits := []struct {
 		name     string
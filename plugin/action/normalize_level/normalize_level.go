@@ -0,0 +1,184 @@
+package normalize_level
+
+import (
+	"strings"
+
+	"github.com/ozontech/file.d/cfg"
+	"github.com/ozontech/file.d/fd"
+	"github.com/ozontech/file.d/metric"
+	"github.com/ozontech/file.d/pipeline"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+/*{ introduction
+It maps the different spellings of the log level, e.g. `WARN`, `warning`, `W` or `40`,
+to the canonical level: `trace`, `debug`, `info`, `warn`, `error` or `fatal`,
+so the levels can be filtered and routed consistently downstream.
+The level is matched case-insensitively, the built-in mapping covers the common names,
+the single letters, the RFC-5424 numbers (0–7) and the bunyan/pino numbers (10–60).
+It can also write the numeric severity of the level according to the OpenTelemetry severity numbers:
+`trace` – 1, `debug` – 5, `info` – 9, `warn` – 13, `error` – 17, `fatal` – 21.
+
+**Example:**
+```yaml
+pipelines:
+  example_pipeline:
+    ...
+    actions:
+    - type: normalize_level
+      field: lvl
+      target_field: level
+      severity_field: severity
+      default_level: info
+      mapping:
+        audit: info
+        severe: error
+    ...
+```
+The event `{"lvl":"WARNING"}` becomes `{"lvl":"WARNING","level":"warn","severity":13}`.
+
+The `action_normalize_level_unknown_total` metric counts the events with the unknown or missing level.
+}*/
+
+const (
+	levelTrace = "trace"
+	levelDebug = "debug"
+	levelInfo  = "info"
+	levelWarn  = "warn"
+	levelError = "error"
+	levelFatal = "fatal"
+)
+
+// severities are the OpenTelemetry severity numbers of the canonical levels
+var severities = map[string]int{
+	levelTrace: 1,
+	levelDebug: 5,
+	levelInfo:  9,
+	levelWarn:  13,
+	levelError: 17,
+	levelFatal: 21,
+}
+
+// defaultMapping maps the lower case spellings of the levels to the canonical ones
+var defaultMapping = map[string]string{
+	"trace": levelTrace, "trc": levelTrace, "t": levelTrace, "verbose": levelTrace, "finest": levelTrace, "10": levelTrace,
+
+	"debug": levelDebug, "dbg": levelDebug, "d": levelDebug, "fine": levelDebug, "finer": levelDebug, "7": levelDebug, "20": levelDebug,
+
+	"info": levelInfo, "information": levelInfo, "informational": levelInfo, "inf": levelInfo, "i": levelInfo,
+	"notice": levelInfo, "5": levelInfo, "6": levelInfo, "30": levelInfo,
+
+	"warn": levelWarn, "warning": levelWarn, "wrn": levelWarn, "w": levelWarn, "4": levelWarn, "40": levelWarn,
+
+	"error": levelError, "err": levelError, "e": levelError, "3": levelError, "50": levelError,
+
+	"fatal": levelFatal, "f": levelFatal, "critical": levelFatal, "crit": levelFatal, "alert": levelFatal,
+	"emergency": levelFatal, "emerg": levelFatal, "panic": levelFatal, "dpanic": levelFatal,
+	"0": levelFatal, "1": levelFatal, "2": levelFatal, "60": levelFatal,
+}
+
+type Plugin struct {
+	config  *Config
+	mapping map[string]string
+
+	//  plugin metrics
+
+	unknownMetric prometheus.Counter
+}
+
+// ! config-params
+// ^ config-params
+type Config struct {
+	// > @3@4@5@6
+	// >
+	// > The name of the event field containing the level.
+	Field  cfg.FieldSelector `json:"field" parse:"selector" default:"level"` // *
+	Field_ []string
+
+	// > @3@4@5@6
+	// >
+	// > The name of the event field to put the canonical level to. If empty, the `field` is overwritten.
+	TargetField  cfg.FieldSelector `json:"target_field" parse:"selector"` // *
+	TargetField_ []string
+
+	// > @3@4@5@6
+	// >
+	// > The name of the event field to put the numeric severity of the level to. If empty, the severity isn't put.
+	SeverityField  cfg.FieldSelector `json:"severity_field" parse:"selector"` // *
+	SeverityField_ []string
+
+	// > @3@4@5@6
+	// >
+	// > The additional spellings of the levels mapped to the canonical ones, they override the built-in mapping.
+	Mapping map[string]string `json:"mapping"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The canonical level of the events with the unknown or missing level. If empty, such events are left as is.
+	DefaultLevel string `json:"default_level"` // *
+}
+
+func init() {
+	fd.DefaultPluginRegistry.RegisterAction(&pipeline.PluginStaticInfo{
+		Type:    "normalize_level",
+		Factory: factory,
+	})
+}
+
+func factory() (pipeline.AnyPlugin, pipeline.AnyConfig) {
+	return &Plugin{}, &Config{}
+}
+
+func (p *Plugin) Start(config pipeline.AnyConfig, params *pipeline.ActionPluginParams) {
+	p.config = config.(*Config)
+	p.registerMetrics(params.MetricCtl)
+	logger := params.Logger.Desugar()
+
+	if len(p.config.TargetField_) == 0 {
+		p.config.TargetField_ = p.config.Field_
+	}
+	if _, ok := severities[p.config.DefaultLevel]; !ok && p.config.DefaultLevel != "" {
+		logger.Fatal("wrong default level", zap.String("default_level", p.config.DefaultLevel))
+	}
+
+	p.mapping = make(map[string]string, len(defaultMapping)+len(p.config.Mapping))
+	for from, to := range defaultMapping {
+		p.mapping[from] = to
+	}
+	for from, to := range p.config.Mapping {
+		if _, ok := severities[to]; !ok {
+			logger.Fatal("level is mapped to the wrong level", zap.String("level", from), zap.String("mapped", to))
+		}
+		p.mapping[strings.ToLower(strings.TrimSpace(from))] = to
+	}
+}
+
+func (p *Plugin) registerMetrics(ctl *metric.Ctl) {
+	p.unknownMetric = ctl.RegisterCounter("action_normalize_level_unknown_total", "Number of events with the unknown or missing level").WithLabelValues()
+}
+
+func (p *Plugin) Stop() {
+}
+
+func (p *Plugin) Do(event *pipeline.Event) pipeline.ActionResult {
+	level := ""
+	if node := event.Root.Dig(p.config.Field_...); node != nil {
+		level = p.mapping[strings.ToLower(strings.TrimSpace(node.AsString()))]
+	}
+
+	if level == "" {
+		p.unknownMetric.Inc()
+		if p.config.DefaultLevel == "" {
+			return pipeline.ActionPass
+		}
+		level = p.config.DefaultLevel
+	}
+
+	pipeline.CreateNestedField(event.Root, p.config.TargetField_).MutateToString(level)
+	if len(p.config.SeverityField_) != 0 {
+		pipeline.CreateNestedField(event.Root, p.config.SeverityField_).MutateToInt(severities[level])
+	}
+
+	return pipeline.ActionPass
+}
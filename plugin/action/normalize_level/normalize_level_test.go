@@ -0,0 +1,67 @@
+package normalize_level
+
+import (
+	"testing"
+
+	"github.com/ozontech/file.d/pipeline"
+	"github.com/ozontech/file.d/test"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	insaneJSON "github.com/vitkovskii/insane-json"
+)
+
+func TestNormalizeLevel(t *testing.T) {
+	cases := []struct {
+		name    string
+		config  *Config
+		in      []string
+		out     []string
+		unknown float64
+	}{
+		{
+			name:   "overwrite",
+			config: &Config{},
+			in:     []string{`{"level":"WARNING"}`, `{"level":" W "}`, `{"level":40}`, `{"level":"3"}`, `{"level":"Critical"}`},
+			out:    []string{`{"level":"warn"}`, `{"level":"warn"}`, `{"level":"warn"}`, `{"level":"error"}`, `{"level":"fatal"}`},
+		},
+		{
+			name:   "target_and_severity",
+			config: &Config{Field: "log.lvl", TargetField: "level", SeverityField: "severity"},
+			in:     []string{`{"log":{"lvl":"trc"}}`, `{"log":{"lvl":30}}`},
+			out:    []string{`{"log":{"lvl":"trc"},"level":"trace","severity":1}`, `{"log":{"lvl":30},"level":"info","severity":9}`},
+		},
+		{
+			name:    "mapping",
+			config:  &Config{Mapping: map[string]string{"Audit": levelInfo, "w": levelError}},
+			in:      []string{`{"level":"AUDIT"}`, `{"level":"w"}`, `{"level":"unknown"}`},
+			out:     []string{`{"level":"info"}`, `{"level":"error"}`, `{"level":"unknown"}`},
+			unknown: 1,
+		},
+		{
+			name:    "default",
+			config:  &Config{DefaultLevel: levelInfo, SeverityField: "severity"},
+			in:      []string{`{"level":"unknown"}`, `{"message":"no level"}`, `{"level":"debug"}`},
+			out:     []string{`{"level":"info","severity":9}`, `{"message":"no level","level":"info","severity":9}`, `{"level":"debug","severity":5}`},
+			unknown: 2,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			test.NewConfig(tt.config, nil)
+			p := &Plugin{}
+			p.Start(tt.config, test.NewEmptyActionPluginParams())
+
+			for i, in := range tt.in {
+				root, err := insaneJSON.DecodeString(in)
+				require.NoError(t, err)
+
+				p.Do(&pipeline.Event{Root: root})
+				assert.Equal(t, tt.out[i], root.EncodeToString())
+				insaneJSON.Release(root)
+			}
+			assert.Equal(t, tt.unknown, testutil.ToFloat64(p.unknownMetric))
+		})
+	}
+}
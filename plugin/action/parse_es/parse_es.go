@@ -12,10 +12,11 @@ It parses HTTP input using Elasticsearch `/_bulk` API format. It converts source
 }*/
 
 type Plugin struct {
-	logger      *zap.SugaredLogger
-	passNext    bool
-	discardNext bool
-	isStrict    bool
+	logger        *zap.SugaredLogger
+	errorReporter *pipeline.ErrorReporter
+	passNext      bool
+	discardNext   bool
+	isStrict      bool
 }
 
 type Config struct{}
@@ -33,6 +34,7 @@ func factory() (pipeline.AnyPlugin, pipeline.AnyConfig) {
 
 func (p *Plugin) Start(_ pipeline.AnyConfig, params *pipeline.ActionPluginParams) {
 	p.logger = params.Logger
+	p.errorReporter = params.ErrorReporter
 	p.isStrict = params.PipelineSettings.IsStrict
 }
 
@@ -82,6 +84,7 @@ func (p *Plugin) Do(event *pipeline.Event) pipeline.ActionResult {
 
 	// If request invalid skip bad event.
 	p.logger.Error("wrong ES input format, expected action, got: %s", root.EncodeToString())
+	p.errorReporter.Report("wrong ES input format, expected action", event)
 
 	return pipeline.ActionDiscard
 }
@@ -0,0 +1,330 @@
+package parse_logfmt
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/ozontech/file.d/cfg"
+	"github.com/ozontech/file.d/fd"
+	"github.com/ozontech/file.d/metric"
+	"github.com/ozontech/file.d/pipeline"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+/*{ introduction
+It parses the logfmt string of the event field, e.g. `level=info msg="request done" dur=3ms`, into the fields.
+The values can be quoted, the quoted values can contain the escape sequences of the Go strings.
+The bare keys without the values, e.g. `cached`, are set to `true`.
+The fields are put to the event root or to `target_field`, the existing fields are overwritten.
+The malformed string isn't parsed at all.
+
+**Example:**
+```yaml
+pipelines:
+  example_pipeline:
+    ...
+    actions:
+    - type: parse_logfmt
+      field: message
+      infer_types: true
+      on_failure: tag
+    ...
+```
+
+The event:
+```json
+{"message":"level=info msg=\"request done\" dur=3ms status=200 cached"}
+```
+
+Will be transformed to:
+```json
+{"level":"info","msg":"request done","dur":"3ms","status":200,"cached":true}
+```
+}*/
+
+const (
+	onFailureTag     = "tag"
+	onFailureDiscard = "discard"
+)
+
+var (
+	errNotString       = errors.New("value isn't a string")
+	errEmptyKey        = errors.New("key is empty")
+	errQuoteInKey      = errors.New("key contains quote")
+	errUnterminated    = errors.New("quoted value isn't terminated")
+	errQuoteInBare     = errors.New("unquoted value contains quote")
+	errNoSpaceAfterEnd = errors.New("quoted value isn't followed by space")
+)
+
+type Plugin struct {
+	config *Config
+	pairs  []pair
+
+	errorReporter *pipeline.ErrorReporter
+
+	// plugin metrics
+	failedMetric *prometheus.CounterVec
+}
+
+// pair is the parsed key and value, the value is empty for the bare key
+type pair struct {
+	key    string
+	value  string
+	quoted bool
+	bare   bool
+}
+
+// ! config-params
+// ^ config-params
+type Config struct {
+	// > @3@4@5@6
+	// >
+	// > The event field to parse. Must be a string.
+	Field  cfg.FieldSelector `json:"field" parse:"selector" default:"message"` // *
+	Field_ []string
+
+	// > @3@4@5@6
+	// >
+	// > The field to put the parsed fields to. If it's empty, the fields are put to the event root.
+	TargetField  cfg.FieldSelector `json:"target_field" parse:"selector"` // *
+	TargetField_ []string
+
+	// > @3@4@5@6
+	// >
+	// > A prefix to add to the keys.
+	Prefix string `json:"prefix"` // *
+
+	// > @3@4@5@6
+	// >
+	// > If set, the parsed field is kept, otherwise it's removed.
+	KeepOrigin bool `json:"keep_origin"` // *
+
+	// > @3@4@5@6
+	// >
+	// > If set, the unquoted numbers and `true`/`false` are put as the JSON numbers and booleans, otherwise all values are strings.
+	InferTypes bool `json:"infer_types"` // *
+
+	// > @3@4@5@6
+	// >
+	// > What to do if the value can't be parsed:
+	// > * `leave` – keep the event as is
+	// > * `tag` – put the error to `error_field`
+	// > * `discard` – drop the event
+	OnFailure string `json:"on_failure" default:"leave" options:"leave|tag|discard"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The field to put the error to if `on_failure` is `tag`.
+	ErrorField  cfg.FieldSelector `json:"error_field" default:"parse_logfmt_error" parse:"selector"` // *
+	ErrorField_ []string
+}
+
+func init() {
+	fd.DefaultPluginRegistry.RegisterAction(&pipeline.PluginStaticInfo{
+		Type:    "parse_logfmt",
+		Factory: factory,
+	})
+}
+
+func factory() (pipeline.AnyPlugin, pipeline.AnyConfig) {
+	return &Plugin{}, &Config{}
+}
+
+func (p *Plugin) Start(config pipeline.AnyConfig, params *pipeline.ActionPluginParams) {
+	p.config = config.(*Config)
+	p.errorReporter = params.ErrorReporter
+	p.registerMetrics(params.MetricCtl)
+}
+
+func (p *Plugin) registerMetrics(ctl *metric.Ctl) {
+	p.failedMetric = ctl.RegisterCounter("action_parse_logfmt_failed_total", "Number of field values which can't be parsed as logfmt")
+}
+
+func (p *Plugin) Stop() {
+}
+
+func (p *Plugin) Do(event *pipeline.Event) pipeline.ActionResult {
+	node := event.Root.Dig(p.config.Field_...)
+	if node == nil {
+		return pipeline.ActionPass
+	}
+	if !node.IsString() {
+		return p.fail(event, errNotString)
+	}
+
+	var err error
+	p.pairs, err = parse(p.pairs[:0], node.AsString())
+	if err != nil {
+		return p.fail(event, err)
+	}
+
+	if !p.config.KeepOrigin {
+		node.Suicide()
+	}
+
+	target := event.Root.Node
+	if len(p.config.TargetField_) != 0 {
+		target = pipeline.CreateNestedField(event.Root, p.config.TargetField_)
+	}
+
+	for i := range p.pairs {
+		pr := &p.pairs[i]
+
+		key := pr.key
+		if p.config.Prefix != "" {
+			l := len(event.Buf)
+			event.Buf = append(event.Buf, p.config.Prefix...)
+			event.Buf = append(event.Buf, key...)
+			key = pipeline.ByteToStringUnsafe(event.Buf[l:])
+		}
+
+		field := target.AddFieldNoAlloc(event.Root, key)
+		switch {
+		case pr.bare:
+			field.MutateToBool(true)
+		case p.config.InferTypes && !pr.quoted && (pr.value == "true" || pr.value == "false"):
+			field.MutateToBool(pr.value == "true")
+		case p.config.InferTypes && !pr.quoted && isNumber(pr.value):
+			field.MutateToJSON(event.Root, pr.value)
+		default:
+			field.MutateToString(pr.value)
+		}
+	}
+
+	return pipeline.ActionPass
+}
+
+func (p *Plugin) fail(event *pipeline.Event, err error) pipeline.ActionResult {
+	p.failedMetric.WithLabelValues().Inc()
+	p.errorReporter.Report("can't parse logfmt: "+err.Error(), event)
+
+	switch p.config.OnFailure {
+	case onFailureDiscard:
+		return pipeline.ActionDiscard
+	case onFailureTag:
+		pipeline.CreateNestedField(event.Root, p.config.ErrorField_).MutateToString("can't parse logfmt: " + err.Error())
+	}
+	return pipeline.ActionPass
+}
+
+// parse appends the pairs of the logfmt string
+func parse(pairs []pair, s string) ([]pair, error) {
+	pos := 0
+	for {
+		for pos < len(s) && isSpace(s[pos]) {
+			pos++
+		}
+		if pos == len(s) {
+			return pairs, nil
+		}
+
+		begin := pos
+		for pos < len(s) && !isSpace(s[pos]) && s[pos] != '=' {
+			if s[pos] == '"' {
+				return pairs, fmt.Errorf("%w at position %d", errQuoteInKey, pos)
+			}
+			pos++
+		}
+		if pos == begin {
+			return pairs, fmt.Errorf("%w at position %d", errEmptyKey, begin)
+		}
+		pr := pair{key: s[begin:pos]}
+
+		if pos == len(s) || s[pos] != '=' {
+			pr.bare = true
+			pairs = append(pairs, pr)
+			continue
+		}
+		pos++
+
+		if pos < len(s) && s[pos] == '"' {
+			var err error
+			pr.quoted = true
+			if pr.value, pos, err = parseQuoted(s, pos); err != nil {
+				return pairs, err
+			}
+			pairs = append(pairs, pr)
+			continue
+		}
+
+		begin = pos
+		for pos < len(s) && !isSpace(s[pos]) {
+			if s[pos] == '"' {
+				return pairs, fmt.Errorf("%w at position %d", errQuoteInBare, pos)
+			}
+			pos++
+		}
+		pr.value = s[begin:pos]
+		pairs = append(pairs, pr)
+	}
+}
+
+// parseQuoted returns the unquoted value starting at the position and the position after it
+func parseQuoted(s string, pos int) (string, int, error) {
+	begin := pos
+	escaped := false
+	for pos++; pos < len(s) && s[pos] != '"'; pos++ {
+		if s[pos] == '\\' {
+			escaped = true
+			pos++
+		}
+	}
+	if pos >= len(s) {
+		return "", 0, fmt.Errorf("%w at position %d", errUnterminated, begin)
+	}
+	pos++
+	if pos < len(s) && !isSpace(s[pos]) {
+		return "", 0, fmt.Errorf("%w at position %d", errNoSpaceAfterEnd, pos)
+	}
+
+	if !escaped {
+		return s[begin+1 : pos-1], pos, nil
+	}
+	value, err := strconv.Unquote(s[begin:pos])
+	if err != nil {
+		return "", 0, fmt.Errorf("wrong quoted value at position %d: %w", begin, err)
+	}
+	return value, pos, nil
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+// isNumber checks that the string is the JSON number
+func isNumber(s string) bool {
+	s = strings.TrimPrefix(s, "-")
+	digits := func() int {
+		n := 0
+		for n < len(s) && s[n] >= '0' && s[n] <= '9' {
+			n++
+		}
+		s = s[n:]
+		return n
+	}
+
+	if len(s) > 1 && s[0] == '0' && s[1] >= '0' && s[1] <= '9' {
+		return false
+	}
+	if digits() == 0 {
+		return false
+	}
+	if len(s) > 0 && s[0] == '.' {
+		s = s[1:]
+		if digits() == 0 {
+			return false
+		}
+	}
+	if len(s) > 0 && (s[0] == 'e' || s[0] == 'E') {
+		s = s[1:]
+		if len(s) > 0 && (s[0] == '+' || s[0] == '-') {
+			s = s[1:]
+		}
+		if digits() == 0 {
+			return false
+		}
+	}
+	return s == ""
+}
@@ -0,0 +1,104 @@
+package parse_logfmt
+
+import (
+	"testing"
+
+	"github.com/ozontech/file.d/pipeline"
+	"github.com/ozontech/file.d/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	insaneJSON "github.com/vitkovskii/insane-json"
+)
+
+func TestParseLogfmt(t *testing.T) {
+	cases := []struct {
+		name   string
+		config *Config
+		in     string
+		out    string
+		result pipeline.ActionResult
+	}{
+		{
+			name:   "infer_types",
+			config: &Config{InferTypes: true},
+			in:     `{"message":"level=info msg=\"request done\" dur=3ms status=200 ratio=-0.5e2 cached ok=false id=007"}`,
+			out:    `{"level":"info","msg":"request done","dur":"3ms","status":200,"ratio":-0.5e2,"cached":true,"ok":false,"id":"007"}`,
+		},
+		{
+			name:   "strings",
+			config: &Config{},
+			in:     `{"message":"status=200 ok=true quoted=\"42\""}`,
+			out:    `{"status":"200","ok":"true","quoted":"42"}`,
+		},
+		{
+			name:   "escapes_and_empty",
+			config: &Config{Field: "log", TargetField: "parsed", KeepOrigin: true, Prefix: "lf_"},
+			in:     `{"log":"  msg=\"say \\\"hi\\\"\\n\"\tempty= path=/a=b  "}`,
+			out:    `{"log":"  msg=\"say \\\"hi\\\"\\n\"\tempty= path=/a=b  ","parsed":{"lf_msg":"say \"hi\"\n","lf_empty":"","lf_path":"/a=b"}}`,
+		},
+		{
+			name:   "overwrite",
+			config: &Config{},
+			in:     `{"message":"level=error","level":"info"}`,
+			out:    `{"level":"error"}`,
+		},
+		{
+			name:   "unterminated_leave",
+			config: &Config{OnFailure: "leave"},
+			in:     `{"message":"level=info msg=\"oops"}`,
+			out:    `{"message":"level=info msg=\"oops"}`,
+		},
+		{
+			name:   "tag",
+			config: &Config{OnFailure: onFailureTag},
+			in:     `{"message":"=value"}`,
+			out:    `{"message":"=value","parse_logfmt_error":"can't parse logfmt: key is empty at position 0"}`,
+		},
+		{
+			name:   "discard",
+			config: &Config{OnFailure: onFailureDiscard},
+			in:     `{"message":"msg=a\"b"}`,
+			out:    `{"message":"msg=a\"b"}`,
+			result: pipeline.ActionDiscard,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			test.NewConfig(tt.config, nil)
+			p := &Plugin{}
+			p.Start(tt.config, test.NewEmptyActionPluginParams())
+
+			root, err := insaneJSON.DecodeString(tt.in)
+			require.NoError(t, err)
+			defer insaneJSON.Release(root)
+
+			result := p.Do(&pipeline.Event{Root: root})
+			assert.Equal(t, tt.result, result)
+			assert.Equal(t, tt.out, root.EncodeToString())
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, s := range []string{
+		`a="unterminated`,
+		`a="x"b`,
+		`a=b"c`,
+		`a"b=c`,
+		`a="\q"`,
+		` =b`,
+	} {
+		_, err := parse(nil, s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestIsNumber(t *testing.T) {
+	for _, s := range []string{"0", "-1", "12.5", "1e10", "1.5E-3"} {
+		assert.True(t, isNumber(s), s)
+	}
+	for _, s := range []string{"", "-", "01", "1.", ".5", "1e", "+1", "0x1f", "3ms", "NaN"} {
+		assert.False(t, isNumber(s), s)
+	}
+}
@@ -0,0 +1,137 @@
+package parse_time
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ozontech/file.d/pipeline"
+)
+
+const (
+	formatUnix   = "unix"
+	formatUnixMs = "unix_ms"
+	formatUnixUs = "unix_us"
+	formatUnixNs = "unix_ns"
+
+	formatISO8601 = "iso8601"
+)
+
+var (
+	errWrongEpoch = errors.New("wrong epoch time")
+
+	// iso8601Layouts are tried in the order, the fraction of the second is parsed even if the layout doesn't have it
+	iso8601Layouts = []string{
+		"2006-01-02T15:04:05Z07:00",
+		"2006-01-02T15:04:05Z0700",
+		"2006-01-02T15:04:05",
+		"2006-01-02",
+	}
+)
+
+// format is the parsed format name, it's either the epoch with the unit or the list of layouts
+type format struct {
+	unit    time.Duration
+	layouts []string
+
+	// location is used to format the time, nil means UTC
+	location *time.Location
+}
+
+// parseFormat parses the alias or the Go reference layout
+func parseFormat(name string) format {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case formatUnix, pipeline.UnixTime:
+		return format{unit: time.Second}
+	case formatUnixMs:
+		return format{unit: time.Millisecond}
+	case formatUnixUs:
+		return format{unit: time.Microsecond}
+	case formatUnixNs:
+		return format{unit: time.Nanosecond}
+	case formatISO8601:
+		return format{layouts: iso8601Layouts}
+	}
+
+	layout, err := pipeline.ParseFormatName(name)
+	if err != nil {
+		// to support custom formats
+		layout = name
+	}
+	return format{layouts: []string{layout}}
+}
+
+func (f format) isEpoch() bool {
+	return f.unit != 0
+}
+
+// parse parses the value, the location is used if the value doesn't have the offset
+func (f format) parse(value string, loc *time.Location) (time.Time, error) {
+	if f.isEpoch() {
+		return parseEpoch(value, f.unit)
+	}
+
+	var err error
+	for _, layout := range f.layouts {
+		var t time.Time
+		t, err = time.ParseInLocation(layout, value, loc)
+		if err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, err
+}
+
+// format formats the time, epochs are returned as int64
+func (f format) format(t time.Time) (string, int64) {
+	if !f.isEpoch() {
+		if f.location == nil {
+			return t.UTC().Format(f.layouts[0]), 0
+		}
+		return t.In(f.location).Format(f.layouts[0]), 0
+	}
+
+	perSecond := int64(time.Second / f.unit)
+	return "", t.Unix()*perSecond + int64(t.Nanosecond())/int64(f.unit)
+}
+
+// parseEpoch parses integer, fractional and exponential epochs, e.g. `1700000000`, `1700000000.123` or `1.7e9`
+func parseEpoch(value string, unit time.Duration) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if strings.ContainsAny(value, "eE") {
+		f, err := strconv.ParseFloat(value, 64)
+		nanos := f * float64(unit)
+		if err != nil || math.IsNaN(nanos) || nanos < math.MinInt64 || nanos >= math.MaxInt64 {
+			return time.Time{}, errWrongEpoch
+		}
+		return time.Unix(0, int64(nanos)), nil
+	}
+
+	whole, fraction, _ := strings.Cut(value, ".")
+	n, err := strconv.ParseInt(whole, 10, 64)
+	if err != nil {
+		return time.Time{}, errWrongEpoch
+	}
+
+	// the fraction of the unit in nanoseconds of the unit
+	var fractionNanos int64
+	if fraction != "" {
+		if len(fraction) > 9 {
+			fraction = fraction[:9]
+		}
+		fractionNanos, err = strconv.ParseInt(fraction+strings.Repeat("0", 9-len(fraction)), 10, 64)
+		if err != nil || fractionNanos < 0 {
+			return time.Time{}, errWrongEpoch
+		}
+		if strings.HasPrefix(whole, "-") {
+			fractionNanos = -fractionNanos
+		}
+	}
+
+	perSecond := int64(time.Second / unit)
+	sec := n / perSecond
+	nsec := n%perSecond*int64(unit) + fractionNanos*int64(unit)/int64(time.Second)
+	return time.Unix(sec, nsec), nil
+}
@@ -0,0 +1,198 @@
+package parse_time
+
+import (
+	"strings"
+	"time"
+
+	"github.com/ozontech/file.d/cfg"
+	"github.com/ozontech/file.d/fd"
+	"github.com/ozontech/file.d/metric"
+	"github.com/ozontech/file.d/pipeline"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+/*{ introduction
+It parses the time field trying the formats in the order until one of them succeeds,
+then puts the normalized time to the target field.
+
+Formats can be Go reference layouts (see https://pkg.go.dev/time#Parse) or aliases:
+* `ansic|unixdate|rubydate|rfc822|rfc822z|rfc850|rfc1123|rfc1123z|rfc3339|rfc3339nano|kitchen|stamp|stampmilli|stampmicro|stampnano|nginx_errorlog` – the layouts of the `time` package and nginx
+* `iso8601` – `2006-01-02T15:04:05` with the optional fraction of the second and the optional offset, or `2006-01-02`
+* `unix` (or `unixtime`), `unix_ms`, `unix_us`, `unix_ns` – the epoch in seconds, milliseconds, microseconds or nanoseconds,
+the value can be a number or a string, fractional and exponential values are supported
+
+**Example:**
+```yaml
+pipelines:
+  example_pipeline:
+    ...
+    actions:
+    - type: parse_time
+      field: ts
+      formats: [rfc3339nano, unix_ms, "02/Jan/2006:15:04:05"]
+      timezone: Europe/Moscow
+      target_field: time
+      target_format: rfc3339nano
+      error_field: parse_time_error
+    ...
+```
+
+The events:
+```json
+{"ts":"2023-11-14T22:13:20.5+03:00"}
+{"ts":1700000000500}
+{"ts":"15/Nov/2023:01:13:20"}
+```
+
+Will be transformed to:
+```json
+{"ts":"2023-11-14T22:13:20.5+03:00","time":"2023-11-14T19:13:20.5Z"}
+{"ts":1700000000500,"time":"2023-11-14T22:13:20.5Z"}
+{"ts":"15/Nov/2023:01:13:20","time":"2023-11-14T22:13:20Z"}
+```
+}*/
+
+type Plugin struct {
+	config   *Config
+	logger   *zap.SugaredLogger
+	formats  []format
+	target   format
+	location *time.Location
+
+	// plugin metrics
+	failedMetric *prometheus.CounterVec
+}
+
+// ! config-params
+// ^ config-params
+type Config struct {
+	// > @3@4@5@6
+	// >
+	// > The event field which contains the time.
+	Field  cfg.FieldSelector `json:"field" parse:"selector" default:"time"` // *
+	Field_ []string
+
+	// > @3@4@5@6
+	// >
+	// > The list of formats to try in the order, see the introduction for available aliases.
+	Formats []string `json:"formats" default:"rfc3339nano,rfc3339"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The time zone of the values without the offset, e.g. `Europe/Moscow` or `Local`.
+	Timezone string `json:"timezone" default:"UTC"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The field to put the parsed time to. If it's empty, the time is put to `field`.
+	TargetField  cfg.FieldSelector `json:"target_field" parse:"selector"` // *
+	TargetField_ []string
+
+	// > @3@4@5@6
+	// >
+	// > The format of the parsed time. It's a layout, a layout alias or the epoch alias, epochs are put as numbers.
+	// > The time is converted to `target_timezone`.
+	TargetFormat string `json:"target_format" default:"rfc3339nano"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The time zone to convert the parsed time to.
+	TargetTimezone string `json:"target_timezone" default:"UTC"` // *
+
+	// > @3@4@5@6
+	// >
+	// > Remove `field` if no format fits, otherwise the original value is kept.
+	RemoveOnFail bool `json:"remove_on_fail" default:"false"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The field to put the error to if no format fits. If it's empty, the error isn't put.
+	ErrorField  cfg.FieldSelector `json:"error_field" parse:"selector"` // *
+	ErrorField_ []string
+}
+
+func init() {
+	fd.DefaultPluginRegistry.RegisterAction(&pipeline.PluginStaticInfo{
+		Type:    "parse_time",
+		Factory: factory,
+	})
+}
+
+func factory() (pipeline.AnyPlugin, pipeline.AnyConfig) {
+	return &Plugin{}, &Config{}
+}
+
+func (p *Plugin) Start(config pipeline.AnyConfig, params *pipeline.ActionPluginParams) {
+	p.config = config.(*Config)
+	p.logger = params.Logger
+	p.registerMetrics(params.MetricCtl)
+
+	if len(p.config.Formats) == 0 {
+		p.logger.Fatalf("formats should be set")
+	}
+	p.formats = make([]format, 0, len(p.config.Formats))
+	for _, name := range p.config.Formats {
+		p.formats = append(p.formats, parseFormat(name))
+	}
+	p.target = parseFormat(p.config.TargetFormat)
+
+	var err error
+	p.location, err = time.LoadLocation(p.config.Timezone)
+	if err != nil {
+		p.logger.Fatalf("can't load timezone %q: %s", p.config.Timezone, err.Error())
+	}
+	targetLocation, err := time.LoadLocation(p.config.TargetTimezone)
+	if err != nil {
+		p.logger.Fatalf("can't load target timezone %q: %s", p.config.TargetTimezone, err.Error())
+	}
+	p.target.location = targetLocation
+
+	if len(p.config.TargetField_) == 0 {
+		p.config.TargetField_ = p.config.Field_
+	}
+}
+
+func (p *Plugin) registerMetrics(ctl *metric.Ctl) {
+	p.failedMetric = ctl.RegisterCounter("action_parse_time_failed_total", "Number of time values which don't fit any format")
+}
+
+func (p *Plugin) Stop() {
+}
+
+func (p *Plugin) Do(event *pipeline.Event) pipeline.ActionResult {
+	node := event.Root.Dig(p.config.Field_...)
+	if node == nil {
+		return pipeline.ActionPass
+	}
+
+	if node.IsString() || node.IsNumber() {
+		value := node.AsString()
+		for _, f := range p.formats {
+			t, err := f.parse(value, p.location)
+			if err != nil {
+				continue
+			}
+
+			target := pipeline.CreateNestedField(event.Root, p.config.TargetField_)
+			s, epoch := p.target.format(t)
+			if p.target.isEpoch() {
+				target.MutateToInt64(epoch)
+			} else {
+				target.MutateToString(s)
+			}
+			return pipeline.ActionPass
+		}
+	}
+
+	p.failedMetric.WithLabelValues().Inc()
+	if len(p.config.ErrorField_) != 0 {
+		message := "can't parse time " + node.EncodeToString() + " with formats " + strings.Join(p.config.Formats, ", ")
+		pipeline.CreateNestedField(event.Root, p.config.ErrorField_).MutateToString(message)
+	}
+	if p.config.RemoveOnFail {
+		node.Suicide()
+	}
+
+	return pipeline.ActionPass
+}
@@ -0,0 +1,145 @@
+package parse_time
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ozontech/file.d/pipeline"
+	"github.com/ozontech/file.d/test"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseEpoch(t *testing.T) {
+	cases := []struct {
+		value string
+		unit  time.Duration
+		want  time.Time
+		isErr bool
+	}{
+		{value: "1700000000", unit: time.Second, want: time.Unix(1700000000, 0)},
+		{value: "1700000000.25", unit: time.Second, want: time.Unix(1700000000, 250000000)},
+		{value: "1.7e9", unit: time.Second, want: time.Unix(1700000000, 0)},
+		{value: "-1.5", unit: time.Second, want: time.Unix(-2, 500000000)},
+		{value: "1700000000123", unit: time.Millisecond, want: time.Unix(1700000000, 123000000)},
+		{value: "1700000000123.5", unit: time.Millisecond, want: time.Unix(1700000000, 123500000)},
+		{value: "1700000000123456", unit: time.Microsecond, want: time.Unix(1700000000, 123456000)},
+		{value: "1700000000123456789", unit: time.Nanosecond, want: time.Unix(1700000000, 123456789)},
+		{value: "abc", unit: time.Second, isErr: true},
+		{value: "1.-5", unit: time.Second, isErr: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.value, func(t *testing.T) {
+			got, err := parseEpoch(tc.value, tc.unit)
+			if tc.isErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.True(t, tc.want.Equal(got), "want %s, got %s", tc.want, got)
+		})
+	}
+}
+
+func TestFormat(t *testing.T) {
+	ts := time.Date(2023, 11, 14, 22, 13, 20, 123456789, time.UTC)
+
+	_, epoch := parseFormat("unix").format(ts)
+	require.Equal(t, int64(1700000000), epoch)
+	_, epoch = parseFormat("unix_ms").format(ts)
+	require.Equal(t, int64(1700000000123), epoch)
+	_, epoch = parseFormat("unix_ns").format(ts)
+	require.Equal(t, int64(1700000000123456789), epoch)
+
+	s, _ := parseFormat("rfc3339").format(ts)
+	require.Equal(t, "2023-11-14T22:13:20Z", s)
+	s, _ = parseFormat("2006-01-02").format(ts)
+	require.Equal(t, "2023-11-14", s)
+
+	for _, value := range []string{"2023-11-14T22:13:20.123Z", "2023-11-14T22:13:20+0000", "2023-11-14T22:13:20", "2023-11-14"} {
+		_, err := parseFormat("iso8601").parse(value, time.UTC)
+		require.NoError(t, err, value)
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	config := test.NewConfig(&Config{
+		Field:          "ts",
+		Formats:        []string{"rfc3339nano", "unix_ms", "02/Jan/2006:15:04:05"},
+		Timezone:       "Europe/Moscow",
+		TargetField:    "time",
+		TargetTimezone: "UTC",
+		ErrorField:     "error",
+	}, nil)
+	p, input, output := test.NewPipelineMock(test.NewActionPluginStaticInfo(factory, config, pipeline.MatchModeAnd, nil, false))
+
+	events := []string{
+		`{"ts":"2023-11-14T22:13:20.5+03:00"}`,
+		`{"ts":1700000000500}`,
+		`{"ts":"1700000000500"}`,
+		`{"ts":"15/Nov/2023:01:13:20"}`,
+		`{"ts":"yesterday"}`,
+		`{"ts":{"a":1}}`,
+		`{"message":"no time"}`,
+	}
+
+	wg := &sync.WaitGroup{}
+	wg.Add(len(events))
+	outEvents := make([]string, 0, len(events))
+	output.SetOutFn(func(e *pipeline.Event) {
+		outEvents = append(outEvents, e.Root.EncodeToString())
+		wg.Done()
+	})
+
+	for _, e := range events {
+		input.In(0, "test.log", 0, []byte(e))
+	}
+
+	wg.Wait()
+	p.Stop()
+
+	require.Equal(t, []string{
+		`{"ts":"2023-11-14T22:13:20.5+03:00","time":"2023-11-14T19:13:20.5Z"}`,
+		`{"ts":1700000000500,"time":"2023-11-14T22:13:20.5Z"}`,
+		`{"ts":"1700000000500","time":"2023-11-14T22:13:20.5Z"}`,
+		`{"ts":"15/Nov/2023:01:13:20","time":"2023-11-14T22:13:20Z"}`,
+		`{"ts":"yesterday","error":"can't parse time \"yesterday\" with formats rfc3339nano, unix_ms, 02/Jan/2006:15:04:05"}`,
+		`{"ts":{"a":1},"error":"can't parse time {\"a\":1} with formats rfc3339nano, unix_ms, 02/Jan/2006:15:04:05"}`,
+		`{"message":"no time"}`,
+	}, outEvents)
+}
+
+func TestParseTimeInPlace(t *testing.T) {
+	config := test.NewConfig(&Config{
+		Formats:      []string{"iso8601"},
+		TargetFormat: "unix",
+		RemoveOnFail: true,
+	}, nil)
+	p, input, output := test.NewPipelineMock(test.NewActionPluginStaticInfo(factory, config, pipeline.MatchModeAnd, nil, false))
+
+	events := []string{
+		`{"time":"2023-11-14T22:13:20"}`,
+		`{"time":"14.11.2023","message":"wrong"}`,
+	}
+
+	wg := &sync.WaitGroup{}
+	wg.Add(len(events))
+	outEvents := make([]string, 0, len(events))
+	output.SetOutFn(func(e *pipeline.Event) {
+		outEvents = append(outEvents, e.Root.EncodeToString())
+		wg.Done()
+	})
+
+	for _, e := range events {
+		input.In(0, "test.log", 0, []byte(e))
+	}
+
+	wg.Wait()
+	p.Stop()
+
+	require.Equal(t, []string{
+		`{"time":1700000000}`,
+		`{"message":"wrong"}`,
+	}, outEvents)
+}
@@ -0,0 +1,259 @@
+package parse_uri
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+
+	"github.com/ozontech/file.d/cfg"
+	"github.com/ozontech/file.d/fd"
+	"github.com/ozontech/file.d/metric"
+	"github.com/ozontech/file.d/pipeline"
+	"github.com/prometheus/client_golang/prometheus"
+	insaneJSON "github.com/vitkovskii/insane-json"
+)
+
+/*{ introduction
+It parses the URI of the event field, e.g. the request of the access log, into the fields:
+* `scheme` and `host` – if the URI is absolute
+* `path` – the decoded path
+* `query` – the object of the decoded query params, the repeated params are put as the arrays
+* `fragment` – if the URI has it
+
+The fields are put to the event root or to `target_field`, the existing fields are overwritten.
+The params from `promote_params` are also put next to the fields, so they can be used by the routes or the aggregations directly.
+
+**Example:**
+```yaml
+pipelines:
+  example_pipeline:
+    ...
+    actions:
+    - type: parse_uri
+      field: request
+      target_field: uri
+      keep_origin: true
+      promote_params: [utm_source]
+    ...
+```
+
+The event:
+```json
+{"request":"/search/red%20shoes?q=shoes&size=40&size=41&utm_source=mail#top"}
+```
+
+Will be transformed to:
+```json
+{
+  "request":"/search/red%20shoes?q=shoes&size=40&size=41&utm_source=mail#top",
+  "uri":{
+    "path":"/search/red shoes",
+    "query":{"q":"shoes","size":["40","41"],"utm_source":"mail"},
+    "fragment":"top",
+    "utm_source":"mail"
+  }
+}
+```
+}*/
+
+const (
+	onFailureTag     = "tag"
+	onFailureDiscard = "discard"
+)
+
+var errNotString = errors.New("value isn't a string")
+
+type Plugin struct {
+	config *Config
+	params []param
+
+	errorReporter *pipeline.ErrorReporter
+
+	// plugin metrics
+	failedMetric *prometheus.CounterVec
+}
+
+type param struct {
+	key   string
+	value string
+}
+
+// ! config-params
+// ^ config-params
+type Config struct {
+	// > @3@4@5@6
+	// >
+	// > The event field to parse. Must be a string.
+	Field  cfg.FieldSelector `json:"field" parse:"selector" default:"request"` // *
+	Field_ []string
+
+	// > @3@4@5@6
+	// >
+	// > The field to put the parsed fields to. If it's empty, the fields are put to the event root.
+	TargetField  cfg.FieldSelector `json:"target_field" parse:"selector"` // *
+	TargetField_ []string
+
+	// > @3@4@5@6
+	// >
+	// > The query params to put next to the parsed fields. The first value is put if the param is repeated.
+	PromoteParams []string `json:"promote_params" slice:"true"` // *
+
+	// > @3@4@5@6
+	// >
+	// > If set, the parsed field is kept unless it's overwritten by the parsed fields, otherwise it's removed.
+	KeepOrigin bool `json:"keep_origin"` // *
+
+	// > @3@4@5@6
+	// >
+	// > What to do if the value isn't the valid URI:
+	// > * `leave` – keep the event as is
+	// > * `tag` – put the error to `error_field`
+	// > * `discard` – drop the event
+	OnFailure string `json:"on_failure" default:"leave" options:"leave|tag|discard"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The field to put the error to if `on_failure` is `tag`.
+	ErrorField  cfg.FieldSelector `json:"error_field" default:"parse_uri_error" parse:"selector"` // *
+	ErrorField_ []string
+}
+
+func init() {
+	fd.DefaultPluginRegistry.RegisterAction(&pipeline.PluginStaticInfo{
+		Type:    "parse_uri",
+		Factory: factory,
+	})
+}
+
+func factory() (pipeline.AnyPlugin, pipeline.AnyConfig) {
+	return &Plugin{}, &Config{}
+}
+
+func (p *Plugin) Start(config pipeline.AnyConfig, params *pipeline.ActionPluginParams) {
+	p.config = config.(*Config)
+	p.errorReporter = params.ErrorReporter
+	p.registerMetrics(params.MetricCtl)
+}
+
+func (p *Plugin) registerMetrics(ctl *metric.Ctl) {
+	p.failedMetric = ctl.RegisterCounter("action_parse_uri_failed_total", "Number of field values which can't be parsed as URI")
+}
+
+func (p *Plugin) Stop() {
+}
+
+func (p *Plugin) Do(event *pipeline.Event) pipeline.ActionResult {
+	node := event.Root.Dig(p.config.Field_...)
+	if node == nil {
+		return pipeline.ActionPass
+	}
+	if !node.IsString() {
+		return p.fail(event, errNotString)
+	}
+
+	u, err := url.Parse(node.AsString())
+	if err != nil {
+		return p.fail(event, err)
+	}
+	p.params, err = parseQuery(p.params[:0], u.RawQuery)
+	if err != nil {
+		return p.fail(event, err)
+	}
+
+	if !p.config.KeepOrigin {
+		node.Suicide()
+	}
+
+	target := event.Root.Node
+	if len(p.config.TargetField_) != 0 {
+		target = pipeline.CreateNestedField(event.Root, p.config.TargetField_)
+	}
+
+	if u.Scheme != "" {
+		setField(event.Root, target, "scheme").MutateToString(u.Scheme)
+	}
+	if u.Host != "" {
+		setField(event.Root, target, "host").MutateToString(u.Host)
+	}
+	setField(event.Root, target, "path").MutateToString(u.Path)
+
+	query := setField(event.Root, target, "query").MutateToObject()
+	for i := range p.params {
+		addParam(event.Root, query, &p.params[i])
+	}
+
+	if u.Fragment != "" {
+		setField(event.Root, target, "fragment").MutateToString(u.Fragment)
+	}
+
+	for _, name := range p.config.PromoteParams {
+		for i := range p.params {
+			if p.params[i].key == name {
+				setField(event.Root, target, name).MutateToString(p.params[i].value)
+				break
+			}
+		}
+	}
+
+	return pipeline.ActionPass
+}
+
+func (p *Plugin) fail(event *pipeline.Event, err error) pipeline.ActionResult {
+	p.failedMetric.WithLabelValues().Inc()
+	p.errorReporter.Report("can't parse uri: "+err.Error(), event)
+
+	switch p.config.OnFailure {
+	case onFailureDiscard:
+		return pipeline.ActionDiscard
+	case onFailureTag:
+		pipeline.CreateNestedField(event.Root, p.config.ErrorField_).MutateToString("can't parse uri: " + err.Error())
+	}
+	return pipeline.ActionPass
+}
+
+// setField returns the existing field of the object or adds the new one
+func setField(root *insaneJSON.Root, object *insaneJSON.Node, name string) *insaneJSON.Node {
+	if field := object.Dig(name); field != nil {
+		return field
+	}
+	return object.AddFieldNoAlloc(root, name)
+}
+
+// addParam puts the param to the query object, the values of the repeated param are put to the array
+func addParam(root *insaneJSON.Root, query *insaneJSON.Node, pr *param) {
+	field := query.Dig(pr.key)
+	if field == nil {
+		query.AddFieldNoAlloc(root, pr.key).MutateToString(pr.value)
+		return
+	}
+
+	if !field.IsArray() {
+		first := field.AsString()
+		field.MutateToArray()
+		field.AddElementNoAlloc(root).MutateToString(first)
+	}
+	field.AddElementNoAlloc(root).MutateToString(pr.value)
+}
+
+// parseQuery appends the decoded params of the query keeping their order, unlike url.ParseQuery
+func parseQuery(params []param, query string) ([]param, error) {
+	for query != "" {
+		var pair string
+		pair, query, _ = strings.Cut(query, "&")
+		if pair == "" {
+			continue
+		}
+
+		key, value, _ := strings.Cut(pair, "=")
+		key, err := url.QueryUnescape(key)
+		if err != nil {
+			return params, err
+		}
+		value, err = url.QueryUnescape(value)
+		if err != nil {
+			return params, err
+		}
+		params = append(params, param{key: key, value: value})
+	}
+	return params, nil
+}
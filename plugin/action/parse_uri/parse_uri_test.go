@@ -0,0 +1,76 @@
+package parse_uri
+
+import (
+	"testing"
+
+	"github.com/ozontech/file.d/pipeline"
+	"github.com/ozontech/file.d/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	insaneJSON "github.com/vitkovskii/insane-json"
+)
+
+func TestParseURI(t *testing.T) {
+	cases := []struct {
+		name   string
+		config *Config
+		in     string
+		out    string
+		result pipeline.ActionResult
+	}{
+		{
+			name:   "request",
+			config: &Config{TargetField: "uri", KeepOrigin: true, PromoteParams: []string{"utm_source", "missing"}},
+			in:     `{"request":"/search/red%20shoes?q=shoes&size=40&size=41&utm_source=mail#top"}`,
+			out: `{"request":"/search/red%20shoes?q=shoes&size=40&size=41&utm_source=mail#top","uri":{"path":"/search/red shoes",` +
+				`"query":{"q":"shoes","size":["40","41"],"utm_source":"mail"},"fragment":"top","utm_source":"mail"}}`,
+		},
+		{
+			name:   "absolute",
+			config: &Config{Field: "url"},
+			in:     `{"url":"https://example.com:8080/a?x=1+2&&flag&e=","path":"old"}`,
+			out:    `{"path":"/a","scheme":"https","host":"example.com:8080","query":{"x":"1 2","flag":"","e":""}}`,
+		},
+		{
+			name:   "no_query",
+			config: &Config{},
+			in:     `{"request":"/health"}`,
+			out:    `{"path":"/health","query":{}}`,
+		},
+		{
+			name:   "invalid_leave",
+			config: &Config{},
+			in:     `{"request":"/a?x=%zz"}`,
+			out:    `{"request":"/a?x=%zz"}`,
+		},
+		{
+			name:   "invalid_tag",
+			config: &Config{OnFailure: onFailureTag},
+			in:     `{"request":"/a%"}`,
+			out:    `{"request":"/a%","parse_uri_error":"can't parse uri: parse \"/a%\": invalid URL escape \"%\""}`,
+		},
+		{
+			name:   "not_string_discard",
+			config: &Config{OnFailure: onFailureDiscard},
+			in:     `{"request":42}`,
+			out:    `{"request":42}`,
+			result: pipeline.ActionDiscard,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			test.NewConfig(tt.config, nil)
+			p := &Plugin{}
+			p.Start(tt.config, test.NewEmptyActionPluginParams())
+
+			root, err := insaneJSON.DecodeString(tt.in)
+			require.NoError(t, err)
+			defer insaneJSON.Release(root)
+
+			result := p.Do(&pipeline.Event{Root: root})
+			assert.Equal(t, tt.result, result)
+			assert.Equal(t, tt.out, root.EncodeToString())
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package parse_user_agent
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+)
+
+var (
+	// caches are shared across the plugin instances of all pipeline processors by the config
+	caches   = map[*Config]*cache{}
+	cachesMu = &sync.Mutex{}
+)
+
+type entry struct {
+	key string
+	ua  *userAgent
+}
+
+// cache keeps the recently parsed user agents, the least recently used one is evicted when the size is reached
+type cache struct {
+	maxSize int
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List
+
+	// refs is the number of plugin instances using the cache
+	refs int
+}
+
+// acquireCache returns the shared cache of the config, the first call creates it
+func acquireCache(config *Config) *cache {
+	cachesMu.Lock()
+	defer cachesMu.Unlock()
+
+	if c, has := caches[config]; has {
+		c.refs++
+		return c
+	}
+
+	c := &cache{
+		maxSize: config.CacheSize,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+		refs:    1,
+	}
+	caches[config] = c
+
+	return c
+}
+
+func (c *cache) release(config *Config) {
+	cachesMu.Lock()
+	defer cachesMu.Unlock()
+
+	c.refs--
+	if c.refs > 0 {
+		return
+	}
+	delete(caches, config)
+}
+
+// get returns the parsed user agent, the second value is true if it's taken from the cache
+func (c *cache) get(s string) (*userAgent, bool) {
+	c.mu.Lock()
+	if el, has := c.entries[s]; has {
+		c.order.MoveToBack(el)
+		c.mu.Unlock()
+		return el.Value.(*entry).ua, true
+	}
+	c.mu.Unlock()
+
+	// the string is cloned since it points to the event buffer, the parsed fields point to the clone
+	key := strings.Clone(s)
+	ua := parse(key)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, has := c.entries[key]; has {
+		return ua, false
+	}
+	if len(c.entries) >= c.maxSize {
+		front := c.order.Front()
+		delete(c.entries, front.Value.(*entry).key)
+		c.order.Remove(front)
+	}
+	c.entries[key] = c.order.PushBack(&entry{key: key, ua: ua})
+
+	return ua, false
+}
@@ -0,0 +1,163 @@
+package parse_user_agent
+
+import (
+	"github.com/ozontech/file.d/cfg"
+	"github.com/ozontech/file.d/fd"
+	"github.com/ozontech/file.d/metric"
+	"github.com/ozontech/file.d/pipeline"
+	"github.com/prometheus/client_golang/prometheus"
+	insaneJSON "github.com/vitkovskii/insane-json"
+)
+
+/*{ introduction
+It parses the user agent of the event field into the fields:
+* `browser` and `browser_version` – e.g. `Chrome` and `120.0.0.0`, the bots are put as the browsers, e.g. `Googlebot`
+* `os` and `os_version` – e.g. `Windows` and `10`
+* `device` – `desktop`, `mobile`, `tablet` or `bot`
+
+The fields which can't be detected are set to `default_value`.
+The fields are put to the event root or to `target_field`, the existing fields are overwritten.
+
+The user agents are parsed by the embedded rules, which cover the popular browsers, operating systems and bots.
+The user agents repeat a lot in the real traffic, so the recently parsed ones are kept in the cache shared by the processors.
+The cache hit rate can be calculated from the `action_parse_user_agent_cache_total` metric.
+
+**Example:**
+```yaml
+pipelines:
+  example_pipeline:
+    ...
+    actions:
+    - type: parse_user_agent
+      field: agent
+      target_field: ua
+      keep_origin: true
+    ...
+```
+
+The event:
+```json
+{"agent":"Mozilla/5.0 (iPhone; CPU iPhone OS 17_1 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.1 Mobile/15E148 Safari/604.1"}
+```
+
+Will be transformed to:
+```json
+{
+  "agent":"Mozilla/5.0 (iPhone; CPU iPhone OS 17_1 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.1 Mobile/15E148 Safari/604.1",
+  "ua":{"browser":"Safari","browser_version":"17.1","os":"iOS","os_version":"17.1","device":"mobile"}
+}
+```
+}*/
+
+type Plugin struct {
+	config *Config
+	cache  *cache
+
+	// plugin metrics
+	cacheMetric *prometheus.CounterVec
+}
+
+// ! config-params
+// ^ config-params
+type Config struct {
+	// > @3@4@5@6
+	// >
+	// > The event field to parse. Must be a string.
+	Field  cfg.FieldSelector `json:"field" parse:"selector" default:"user_agent"` // *
+	Field_ []string
+
+	// > @3@4@5@6
+	// >
+	// > The field to put the parsed fields to. If it's empty, the fields are put to the event root.
+	TargetField  cfg.FieldSelector `json:"target_field" parse:"selector"` // *
+	TargetField_ []string
+
+	// > @3@4@5@6
+	// >
+	// > If set, the parsed field is kept unless it's overwritten by the parsed fields, otherwise it's removed.
+	KeepOrigin bool `json:"keep_origin"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The value of the fields which can't be detected.
+	DefaultValue string `json:"default_value" default:"unknown"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The max number of the cached user agents. The least recently used one is evicted when the limit is reached.
+	CacheSize int `json:"cache_size" default:"10000"` // *
+}
+
+func init() {
+	fd.DefaultPluginRegistry.RegisterAction(&pipeline.PluginStaticInfo{
+		Type:    "parse_user_agent",
+		Factory: factory,
+	})
+}
+
+func factory() (pipeline.AnyPlugin, pipeline.AnyConfig) {
+	return &Plugin{}, &Config{}
+}
+
+func (p *Plugin) Start(config pipeline.AnyConfig, params *pipeline.ActionPluginParams) {
+	p.config = config.(*Config)
+	p.registerMetrics(params.MetricCtl)
+
+	if p.config.CacheSize <= 0 {
+		params.Logger.Fatalf("cache_size should be positive")
+	}
+
+	p.cache = acquireCache(p.config)
+}
+
+func (p *Plugin) registerMetrics(ctl *metric.Ctl) {
+	p.cacheMetric = ctl.RegisterCounter("action_parse_user_agent_cache_total", "Number of user agent cache lookups by the result: hit or miss", "result")
+}
+
+func (p *Plugin) Stop() {
+	p.cache.release(p.config)
+}
+
+func (p *Plugin) Do(event *pipeline.Event) pipeline.ActionResult {
+	node := event.Root.Dig(p.config.Field_...)
+	if node == nil || !node.IsString() {
+		return pipeline.ActionPass
+	}
+
+	ua, hit := p.cache.get(node.AsString())
+	if hit {
+		p.cacheMetric.WithLabelValues("hit").Inc()
+	} else {
+		p.cacheMetric.WithLabelValues("miss").Inc()
+	}
+
+	if !p.config.KeepOrigin {
+		node.Suicide()
+	}
+
+	target := event.Root.Node
+	if len(p.config.TargetField_) != 0 {
+		target = pipeline.CreateNestedField(event.Root, p.config.TargetField_)
+	}
+
+	p.setField(event.Root, target, "browser", ua.browser)
+	p.setField(event.Root, target, "browser_version", ua.browserVersion)
+	p.setField(event.Root, target, "os", ua.os)
+	p.setField(event.Root, target, "os_version", ua.osVersion)
+	p.setField(event.Root, target, "device", ua.device)
+
+	return pipeline.ActionPass
+}
+
+// setField puts the value to the field of the object, the empty value is replaced by the default one
+func (p *Plugin) setField(root *insaneJSON.Root, object *insaneJSON.Node, name, value string) {
+	if value == "" {
+		value = p.config.DefaultValue
+	}
+
+	field := object.Dig(name)
+	if field == nil {
+		field = object.AddFieldNoAlloc(root, name)
+	}
+	field.MutateToString(value)
+}
@@ -0,0 +1,128 @@
+package parse_user_agent
+
+import (
+	"testing"
+
+	"github.com/ozontech/file.d/pipeline"
+	"github.com/ozontech/file.d/test"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	insaneJSON "github.com/vitkovskii/insane-json"
+)
+
+func TestParse(t *testing.T) {
+	cases := []struct {
+		in  string
+		out userAgent
+	}{
+		{
+			in:  "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36",
+			out: userAgent{"Chrome", "120.0.0.0", "Windows", "10", deviceDesktop},
+		},
+		{
+			in:  "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36 Edg/120.0.2210.91",
+			out: userAgent{"Edge", "120.0.2210.91", "Windows", "10", deviceDesktop},
+		},
+		{
+			in:  "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.1 Safari/605.1.15",
+			out: userAgent{"Safari", "17.1", "macOS", "10.15.7", deviceDesktop},
+		},
+		{
+			in:  "Mozilla/5.0 (X11; Ubuntu; Linux x86_64; rv:121.0) Gecko/20100101 Firefox/121.0",
+			out: userAgent{"Firefox", "121.0", "Linux", "", deviceDesktop},
+		},
+		{
+			in:  "Mozilla/5.0 (iPhone; CPU iPhone OS 17_1 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.1 Mobile/15E148 Safari/604.1",
+			out: userAgent{"Safari", "17.1", "iOS", "17.1", deviceMobile},
+		},
+		{
+			in:  "Mozilla/5.0 (Linux; Android 14; SM-S918B) AppleWebKit/537.36 (KHTML, like Gecko) SamsungBrowser/23.0 Chrome/115.0.0.0 Mobile Safari/537.36",
+			out: userAgent{"Samsung Internet", "23.0", "Android", "14", deviceMobile},
+		},
+		{
+			in:  "Mozilla/5.0 (Linux; Android 13; SM-X700) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36",
+			out: userAgent{"Chrome", "120.0.0.0", "Android", "13", deviceTablet},
+		},
+		{
+			in:  "Mozilla/5.0 (Windows NT 6.1; WOW64; Trident/7.0; rv:11.0) like Gecko",
+			out: userAgent{"IE", "11.0", "Windows", "7", deviceDesktop},
+		},
+		{
+			in:  "Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)",
+			out: userAgent{browser: "Googlebot", browserVersion: "2.1", device: deviceBot},
+		},
+		{
+			in:  "curl/8.4.0",
+			out: userAgent{browser: "curl", browserVersion: "8.4.0", device: deviceBot},
+		},
+		{
+			in:  "SomeCrawler",
+			out: userAgent{browser: "Bot", device: deviceBot},
+		},
+		{
+			in:  "-",
+			out: userAgent{},
+		},
+	}
+
+	for _, tt := range cases {
+		assert.Equal(t, tt.out, *parse(tt.in), tt.in)
+	}
+}
+
+func TestParseUserAgent(t *testing.T) {
+	config := &Config{TargetField: "ua", CacheSize: 2}
+	test.NewConfig(config, nil)
+	p := &Plugin{}
+	p.Start(config, test.NewEmptyActionPluginParams())
+	defer p.Stop()
+
+	do := func(in string) string {
+		root, err := insaneJSON.DecodeString(in)
+		require.NoError(t, err)
+		defer insaneJSON.Release(root)
+
+		assert.Equal(t, pipeline.ActionPass, p.Do(&pipeline.Event{Root: root}))
+		return root.EncodeToString()
+	}
+
+	assert.Equal(t,
+		`{"ua":{"browser":"curl","browser_version":"8.4.0","os":"unknown","os_version":"unknown","device":"bot"}}`,
+		do(`{"user_agent":"curl/8.4.0"}`),
+	)
+	assert.Equal(t,
+		`{"ua":{"browser":"unknown","browser_version":"unknown","os":"unknown","os_version":"unknown","device":"unknown"}}`,
+		do(`{"user_agent":"-"}`),
+	)
+	assert.Equal(t,
+		`{"ua":{"browser":"curl","browser_version":"8.4.0","os":"unknown","os_version":"unknown","device":"bot"}}`,
+		do(`{"user_agent":"curl/8.4.0"}`),
+	)
+	assert.Equal(t, `{"user_agent":1}`, do(`{"user_agent":1}`))
+
+	assert.Equal(t, float64(1), testutil.ToFloat64(p.cacheMetric.WithLabelValues("hit")))
+	assert.Equal(t, float64(2), testutil.ToFloat64(p.cacheMetric.WithLabelValues("miss")))
+}
+
+func TestCacheEviction(t *testing.T) {
+	config := &Config{CacheSize: 2}
+	c := acquireCache(config)
+	defer c.release(config)
+
+	_, hit := c.get("a")
+	assert.False(t, hit)
+	_, hit = c.get("b")
+	assert.False(t, hit)
+	_, hit = c.get("a")
+	assert.True(t, hit)
+
+	// b is the least recently used one
+	_, hit = c.get("c")
+	assert.False(t, hit)
+	_, hit = c.get("a")
+	assert.True(t, hit)
+	_, hit = c.get("b")
+	assert.False(t, hit)
+	assert.Len(t, c.entries, 2)
+}
@@ -0,0 +1,125 @@
+package parse_user_agent
+
+import (
+	"regexp"
+	"strings"
+)
+
+const (
+	deviceBot     = "bot"
+	deviceMobile  = "mobile"
+	deviceTablet  = "tablet"
+	deviceDesktop = "desktop"
+)
+
+// userAgent is the parsed user agent, the empty fields aren't detected
+type userAgent struct {
+	browser        string
+	browserVersion string
+	os             string
+	osVersion      string
+	device         string
+}
+
+// rule detects the name by the expression, the first group of the expression is the version
+type rule struct {
+	name string
+	re   *regexp.Regexp
+}
+
+// the rules are checked in order, so the more specific ones go first,
+// e.g. Edge and Opera user agents contain Chrome and Safari tokens
+var (
+	botRules = []rule{
+		{"Googlebot", regexp.MustCompile(`Googlebot(?:-\w+)?/([\d.]+)`)},
+		{"Bingbot", regexp.MustCompile(`bingbot/([\d.]+)`)},
+		{"YandexBot", regexp.MustCompile(`YandexBot/([\d.]+)`)},
+		{"DuckDuckBot", regexp.MustCompile(`DuckDuckBot/([\d.]+)`)},
+		{"Baiduspider", regexp.MustCompile(`Baiduspider(?:-\w+)?/([\d.]+)`)},
+		{"Facebook", regexp.MustCompile(`facebookexternalhit/([\d.]+)`)},
+		{"curl", regexp.MustCompile(`^curl/([\d.]+)`)},
+		{"Wget", regexp.MustCompile(`^Wget/([\d.]+)`)},
+		{"Python Requests", regexp.MustCompile(`^python-requests/([\d.]+)`)},
+		{"Go HTTP Client", regexp.MustCompile(`^Go-http-client/([\d.]+)`)},
+		{"Bot", regexp.MustCompile(`(?i)(?:bot|crawler|spider)\b(?:/([\d.]+))?`)},
+	}
+
+	browserRules = []rule{
+		{"Edge", regexp.MustCompile(`(?:Edge?|EdgA|EdgiOS)/([\d.]+)`)},
+		{"Opera", regexp.MustCompile(`(?:OPR|Opera)/([\d.]+)`)},
+		{"Yandex Browser", regexp.MustCompile(`YaBrowser/([\d.]+)`)},
+		{"Samsung Internet", regexp.MustCompile(`SamsungBrowser/([\d.]+)`)},
+		{"Firefox", regexp.MustCompile(`(?:Firefox|FxiOS)/([\d.]+)`)},
+		{"Chrome", regexp.MustCompile(`(?:Chrome|CriOS)/([\d.]+)`)},
+		{"Safari", regexp.MustCompile(`Version/([\d.]+).*Safari/`)},
+		{"IE", regexp.MustCompile(`MSIE ([\d.]+)|Trident/.*rv:([\d.]+)`)},
+	}
+
+	osRules = []rule{
+		{"Windows", regexp.MustCompile(`Windows NT ([\d.]+)`)},
+		{"iOS", regexp.MustCompile(`(?:iPhone|iPad|iPod).*? OS ([\d_]+)`)},
+		{"Android", regexp.MustCompile(`Android ([\d.]+)`)},
+		{"Chrome OS", regexp.MustCompile(`CrOS \w+ ([\d.]+)`)},
+		{"macOS", regexp.MustCompile(`Mac OS X ([\d_.]+)`)},
+		{"Linux", regexp.MustCompile(`Linux()`)},
+	}
+
+	// windowsVersions maps the NT versions to the marketing ones
+	windowsVersions = map[string]string{
+		"10.0": "10",
+		"6.3":  "8.1",
+		"6.2":  "8",
+		"6.1":  "7",
+		"6.0":  "Vista",
+		"5.1":  "XP",
+	}
+)
+
+// match returns the name and the version of the first matched rule
+func match(rules []rule, s string) (string, string) {
+	for _, r := range rules {
+		m := r.re.FindStringSubmatch(s)
+		if m == nil {
+			continue
+		}
+		for _, version := range m[1:] {
+			if version != "" {
+				return r.name, version
+			}
+		}
+		return r.name, ""
+	}
+	return "", ""
+}
+
+func parse(s string) *userAgent {
+	ua := &userAgent{}
+
+	if ua.browser, ua.browserVersion = match(botRules, s); ua.browser != "" {
+		ua.device = deviceBot
+		return ua
+	}
+
+	ua.browser, ua.browserVersion = match(browserRules, s)
+	ua.os, ua.osVersion = match(osRules, s)
+	switch ua.os {
+	case "Windows":
+		if v, has := windowsVersions[ua.osVersion]; has {
+			ua.osVersion = v
+		}
+	case "iOS", "macOS":
+		ua.osVersion = strings.ReplaceAll(ua.osVersion, "_", ".")
+	}
+
+	switch {
+	case strings.Contains(s, "iPad") || strings.Contains(s, "Tablet") ||
+		ua.os == "Android" && !strings.Contains(s, "Mobile"):
+		ua.device = deviceTablet
+	case strings.Contains(s, "Mobile") || strings.Contains(s, "iPhone"):
+		ua.device = deviceMobile
+	case ua.os != "":
+		ua.device = deviceDesktop
+	}
+
+	return ua
+}
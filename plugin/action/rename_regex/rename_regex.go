@@ -0,0 +1,188 @@
+package rename_regex
+
+import (
+	"bytes"
+	"regexp"
+	"strconv"
+
+	"github.com/ozontech/file.d/cfg"
+	"github.com/ozontech/file.d/fd"
+	"github.com/ozontech/file.d/pipeline"
+	insaneJSON "github.com/vitkovskii/insane-json"
+)
+
+/*{ introduction
+It renames the keys of the event matching the regular expressions. The matched parts of the key are replaced with the template,
+which can refer to the capture groups of the expression. Only the first matching rule is applied to the key. The keys of the root or of the provided field are renamed.
+
+**Example:**
+```yaml
+pipelines:
+  example_pipeline:
+    ...
+    actions:
+    - type: rename_regex
+      rules:
+        - re: "/^k8s_(.*)$/"
+          template: "$1"
+        - re: "/[A-Z]/"
+          case: lower
+      on_collision: suffix
+    ...
+```
+It transforms `{"k8s_pod":"p","Level":"info","level":"debug"}` into `{"pod":"p","level_1":"info","level":"debug"}`.
+}*/
+
+const (
+	onCollisionSkip      = "skip"
+	onCollisionOverwrite = "overwrite"
+	onCollisionSuffix    = "suffix"
+
+	caseLower = "lower"
+	caseUpper = "upper"
+)
+
+type Plugin struct {
+	config *Config
+
+	fields []*insaneJSON.Node
+	buf    []byte
+}
+
+// ! config-params
+// ^ config-params
+type Config struct {
+	// > @3@4@5@6
+	// >
+	// > The list of the rename rules. It's a list of objects, each of them has the fields:
+	// > * `re` – the regular expression for the key, it should be surrounded by `/`
+	// > * `template` – the replacement of the matched parts of the key, e.g. `$1` or `${name}`, the default is `$0` to keep them
+	// > * `case` – the case of the new key: `as_is`, `lower` or `upper`
+	Rules []RuleConfig `json:"rules" required:"true" slice:"true"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The object which keys should be renamed. If it's empty, the keys of the root are renamed.
+	Field  cfg.FieldSelector `json:"field" parse:"selector"` // *
+	Field_ []string
+
+	// > @3@4@5@6
+	// >
+	// > What to do if the new key is already taken:
+	// > * `skip` – keep the key as is
+	// > * `overwrite` – replace the existing field
+	// > * `suffix` – add the first free suffix `_1`, `_2`, ... to the new key
+	OnCollision string `json:"on_collision" default:"skip" options:"skip|overwrite|suffix"` // *
+}
+
+type RuleConfig struct {
+	Re       cfg.Regexp `json:"re" required:"true" parse:"regexp"`
+	Re_      *regexp.Regexp
+	Template string `json:"template" default:"$0"`
+	Case     string `json:"case" default:"as_is" options:"as_is|lower|upper"`
+}
+
+func init() {
+	fd.DefaultPluginRegistry.RegisterAction(&pipeline.PluginStaticInfo{
+		Type:    "rename_regex",
+		Factory: factory,
+	})
+}
+
+func factory() (pipeline.AnyPlugin, pipeline.AnyConfig) {
+	return &Plugin{}, &Config{}
+}
+
+func (p *Plugin) Start(config pipeline.AnyConfig, params *pipeline.ActionPluginParams) {
+	p.config = config.(*Config)
+
+	if len(p.config.Rules) == 0 {
+		params.Logger.Fatalf("rules should be set")
+	}
+}
+
+func (p *Plugin) Stop() {
+}
+
+func (p *Plugin) Do(event *pipeline.Event) pipeline.ActionResult {
+	node := event.Root.Dig(p.config.Field_...)
+	if !node.IsObject() {
+		return pipeline.ActionPass
+	}
+
+	// fields are copied since the object is changed while renaming
+	p.fields = append(p.fields[:0], node.AsFields()...)
+	for _, field := range p.fields {
+		key := field.AsString()
+		// the field could be overwritten by the renamed one
+		if node.Dig(key) != field.AsFieldValue() {
+			continue
+		}
+
+		name, ok := p.newName(key)
+		if !ok || name == key {
+			continue
+		}
+
+		if existing := node.Dig(name); existing != nil {
+			switch p.config.OnCollision {
+			case onCollisionSkip:
+				continue
+			case onCollisionOverwrite:
+				existing.Suicide()
+			case onCollisionSuffix:
+				name = p.freeName(node, name)
+			}
+		}
+
+		l := len(event.Buf)
+		event.Buf = append(event.Buf, name...)
+		field.MutateToField(pipeline.ByteToStringUnsafe(event.Buf[l:]))
+	}
+
+	return pipeline.ActionPass
+}
+
+// newName returns the new key by the first matching rule, the name is valid until the next call.
+func (p *Plugin) newName(key string) (string, bool) {
+	for i := range p.config.Rules {
+		rule := &p.config.Rules[i]
+		matches := rule.Re_.FindAllStringSubmatchIndex(key, -1)
+		if matches == nil {
+			continue
+		}
+
+		p.buf = p.buf[:0]
+		last := 0
+		for _, match := range matches {
+			p.buf = append(p.buf, key[last:match[0]]...)
+			p.buf = rule.Re_.ExpandString(p.buf, rule.Template, key, match)
+			last = match[1]
+		}
+		p.buf = append(p.buf, key[last:]...)
+		switch rule.Case {
+		case caseLower:
+			p.buf = bytes.ToLower(p.buf)
+		case caseUpper:
+			p.buf = bytes.ToUpper(p.buf)
+		}
+
+		// the empty key isn't allowed to not lose the field
+		return pipeline.ByteToStringUnsafe(p.buf), len(p.buf) != 0
+	}
+
+	return "", false
+}
+
+// freeName returns the name with the first suffix which isn't taken in the object.
+func (p *Plugin) freeName(node *insaneJSON.Node, name string) string {
+	l := len(name)
+	buf := append(make([]byte, 0, l+4), name...)
+	for i := 1; ; i++ {
+		buf = append(buf[:l], '_')
+		buf = strconv.AppendInt(buf, int64(i), 10)
+		if node.Dig(pipeline.ByteToStringUnsafe(buf)) == nil {
+			return pipeline.ByteToStringUnsafe(buf)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package rename_regex
+
+import (
+	"testing"
+
+	"github.com/ozontech/file.d/pipeline"
+	"github.com/ozontech/file.d/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	insaneJSON "github.com/vitkovskii/insane-json"
+)
+
+func TestRenameRegex(t *testing.T) {
+	prefixRule := RuleConfig{Re: "/^k8s_(.*)$/", Template: "$1"}
+	lowerRule := RuleConfig{Re: "/[A-Z]/", Case: caseLower}
+
+	cases := []struct {
+		name   string
+		config *Config
+		in     string
+		out    string
+	}{
+		{
+			name:   "template",
+			config: &Config{Rules: []RuleConfig{prefixRule}},
+			in:     `{"k8s_pod":"p","k8s_ns":"n","k8s":"k"}`,
+			out:    `{"pod":"p","ns":"n","k8s":"k"}`,
+		},
+		{
+			name:   "named_groups",
+			config: &Config{Rules: []RuleConfig{{Re: "/^(?P<a>\\w+)-(?P<b>\\w+)$/", Template: "${b}_${a}", Case: caseUpper}}},
+			in:     `{"x-y":1}`,
+			out:    `{"Y_X":1}`,
+		},
+		{
+			name:   "first_rule",
+			config: &Config{Rules: []RuleConfig{prefixRule, lowerRule}},
+			in:     `{"k8s_Pod":"p","Level":"info"}`,
+			out:    `{"Pod":"p","level":"info"}`,
+		},
+		{
+			name:   "field",
+			config: &Config{Rules: []RuleConfig{lowerRule}, Field: "meta"},
+			in:     `{"A":1,"meta":{"B":2}}`,
+			out:    `{"A":1,"meta":{"b":2}}`,
+		},
+		{
+			name:   "empty_name",
+			config: &Config{Rules: []RuleConfig{prefixRule}},
+			in:     `{"k8s_":1}`,
+			out:    `{"k8s_":1}`,
+		},
+		{
+			name:   "collision_skip",
+			config: &Config{Rules: []RuleConfig{lowerRule}, OnCollision: onCollisionSkip},
+			in:     `{"Level":"info","level":"debug"}`,
+			out:    `{"Level":"info","level":"debug"}`,
+		},
+		{
+			name:   "collision_overwrite",
+			config: &Config{Rules: []RuleConfig{lowerRule}, OnCollision: onCollisionOverwrite},
+			in:     `{"Level":"info","x":1,"level":"debug"}`,
+			out:    `{"level":"info","x":1}`,
+		},
+		{
+			name:   "collision_suffix",
+			config: &Config{Rules: []RuleConfig{lowerRule}, OnCollision: onCollisionSuffix},
+			in:     `{"Level":"info","LEVEL":"warn","level":"debug","level_1":"error"}`,
+			out:    `{"level_2":"info","level_3":"warn","level":"debug","level_1":"error"}`,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			test.NewConfig(tt.config, nil)
+			p := &Plugin{}
+			p.Start(tt.config, test.NewEmptyActionPluginParams())
+
+			root, err := insaneJSON.DecodeString(tt.in)
+			require.NoError(t, err)
+			defer insaneJSON.Release(root)
+
+			p.Do(&pipeline.Event{Root: root})
+			assert.Equal(t, tt.out, root.EncodeToString())
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package sample
+
+import (
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/ozontech/file.d/cfg"
+	"github.com/ozontech/file.d/fd"
+	"github.com/ozontech/file.d/metric"
+	"github.com/ozontech/file.d/pipeline"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+/*{ introduction
+It keeps the `rate` part of the events and discards others.
+If `key_field` is set, the events are sampled by the hash of its value, so the events with the same key are either all kept or all discarded.
+Otherwise, every event is kept with the `rate` probability.
+
+The discarded events aren't passed to the output, the input offsets move forward with the next committed events as for any discarded event.
+The kept events can be marked with the rate in `rate_field` to scale counts downstream.
+
+**Example:**
+```yaml
+pipelines:
+  example_pipeline:
+    ...
+    actions:
+    - type: sample
+      rate: 0.1
+      key_field: trace_id
+      rate_field: sample_rate
+    ...
+```
+}*/
+
+type Plugin struct {
+	config *Config
+	rand   *rand.Rand
+
+	// threshold is the max hash of the kept key
+	threshold uint64
+
+	// plugin metrics
+	droppedMetric *prometheus.CounterVec
+}
+
+// ! config-params
+// ^ config-params
+type Config struct {
+	// > @3@4@5@6
+	// >
+	// > The part of the events to keep, it should be in `(0, 1]`.
+	Rate float64 `json:"rate" required:"true"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The event field to sample by. If it's empty, the events are sampled randomly.
+	// > The events without the field are sampled as the ones with the empty key.
+	KeyField  cfg.FieldSelector `json:"key_field" parse:"selector"` // *
+	KeyField_ []string
+
+	// > @3@4@5@6
+	// >
+	// > The field to put the `rate` to for the kept events. If it's empty, the events aren't changed.
+	RateField  cfg.FieldSelector `json:"rate_field" parse:"selector"` // *
+	RateField_ []string
+}
+
+func init() {
+	fd.DefaultPluginRegistry.RegisterAction(&pipeline.PluginStaticInfo{
+		Type:    "sample",
+		Factory: factory,
+	})
+}
+
+func factory() (pipeline.AnyPlugin, pipeline.AnyConfig) {
+	return &Plugin{}, &Config{}
+}
+
+func (p *Plugin) Start(config pipeline.AnyConfig, params *pipeline.ActionPluginParams) {
+	p.config = config.(*Config)
+	p.registerMetrics(params.MetricCtl)
+
+	if p.config.Rate <= 0 || p.config.Rate > 1 {
+		params.Logger.Fatalf("rate should be in (0, 1], got=%v", p.config.Rate)
+	}
+
+	p.rand = rand.New(rand.NewSource(time.Now().UnixNano()))
+	p.threshold = math.MaxUint64
+	if p.config.Rate < 1 {
+		p.threshold = uint64(p.config.Rate * math.MaxUint64)
+	}
+}
+
+func (p *Plugin) registerMetrics(ctl *metric.Ctl) {
+	p.droppedMetric = ctl.RegisterCounter("action_sample_dropped_total", "Number of events discarded by sampling")
+}
+
+func (p *Plugin) Stop() {
+}
+
+func (p *Plugin) Do(event *pipeline.Event) pipeline.ActionResult {
+	if !p.isKept(event) {
+		p.droppedMetric.WithLabelValues().Inc()
+		return pipeline.ActionDiscard
+	}
+
+	if len(p.config.RateField_) != 0 {
+		pipeline.CreateNestedField(event.Root, p.config.RateField_).MutateToFloat(p.config.Rate)
+	}
+	return pipeline.ActionPass
+}
+
+func (p *Plugin) isKept(event *pipeline.Event) bool {
+	if len(p.config.KeyField_) == 0 {
+		return p.rand.Float64() < p.config.Rate
+	}
+
+	key := event.Root.Dig(p.config.KeyField_...).AsString()
+	return xxhash.Sum64String(key) <= p.threshold
+}
@@ -0,0 +1,66 @@
+package sample
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/ozontech/file.d/pipeline"
+	"github.com/ozontech/file.d/test"
+	"github.com/stretchr/testify/require"
+	insaneJSON "github.com/vitkovskii/insane-json"
+)
+
+func newPlugin(t *testing.T, config *Config) *Plugin {
+	test.NewConfig(config, nil)
+	p := &Plugin{}
+	p.Start(config, test.NewEmptyActionPluginParams())
+	t.Cleanup(p.Stop)
+	return p
+}
+
+func do(t *testing.T, p *Plugin, json string) (pipeline.ActionResult, string) {
+	root, err := insaneJSON.DecodeString(json)
+	require.NoError(t, err)
+	defer insaneJSON.Release(root)
+
+	result := p.Do(&pipeline.Event{Root: root})
+	return result, root.EncodeToString()
+}
+
+func TestSampleRandom(t *testing.T) {
+	p := newPlugin(t, &Config{Rate: 0.3})
+
+	kept := 0
+	for i := 0; i < 10000; i++ {
+		if result, _ := do(t, p, `{"msg":"debug"}`); result == pipeline.ActionPass {
+			kept++
+		}
+	}
+	require.InDelta(t, 3000, kept, 300, "wrong kept events count")
+}
+
+func TestSampleKey(t *testing.T) {
+	p := newPlugin(t, &Config{Rate: 0.5, KeyField: "trace.id"})
+
+	kept := 0
+	for i := 0; i < 1000; i++ {
+		event := fmt.Sprintf(`{"trace":{"id":"%d"}}`, i)
+		first, _ := do(t, p, event)
+		for j := 0; j < 3; j++ {
+			result, _ := do(t, p, event)
+			require.Equal(t, first, result, "events with the same key should be sampled the same way")
+		}
+		if first == pipeline.ActionPass {
+			kept++
+		}
+	}
+	require.InDelta(t, 500, kept, 100, "wrong kept keys count")
+}
+
+func TestSampleRateField(t *testing.T) {
+	p := newPlugin(t, &Config{Rate: 1, RateField: "meta.sample_rate"})
+
+	result, out := do(t, p, `{"msg":"debug"}`)
+	require.Equal(t, pipeline.ActionPass, result)
+	require.Equal(t, `{"msg":"debug","meta":{"sample_rate":1}}`, out)
+}
@@ -0,0 +1,199 @@
+package set_if
+
+import (
+	"encoding/json"
+	"sort"
+
+	"github.com/ozontech/file.d/cfg"
+	"github.com/ozontech/file.d/fd"
+	"github.com/ozontech/file.d/pipeline"
+	"go.uber.org/zap"
+)
+
+/*{ introduction
+It sets the fields of the event if it matches the conditions. The conditions are the same as the `match_fields`
+of the actions: the values, the lists of the values or the regexps, and they are combined by the `match_mode`.
+The rules are evaluated in order, the existing fields are overwritten.
+
+**Example:**
+```yaml
+pipelines:
+  example_pipeline:
+    ...
+    actions:
+    - type: set_if
+      rules:
+        - match_fields:
+            status: /^5\d\d$/
+          set:
+            priority: high
+            alert: true
+        - match_fields:
+            status: /^4\d\d$/
+          set:
+            priority: medium
+        - set:
+            priority: low
+    ...
+```
+The event `{"status":503}` will be transformed to `{"status":503,"alert":true,"priority":"high"}`.
+The last rule has no conditions, so it matches any event and sets the default priority.
+}*/
+
+type Plugin struct {
+	config *Config
+	logger *zap.SugaredLogger
+	rules  []rule
+}
+
+type rule struct {
+	conditions pipeline.MatchConditions
+	mode       pipeline.MatchMode
+	expr       *pipeline.MatchExpr
+	invert     bool
+	fields     []field
+}
+
+type field struct {
+	path  []string
+	value string
+}
+
+type mode int
+
+const (
+	modeFirst mode = iota
+	modeAll
+)
+
+// ! config-params
+// ^ config-params
+type Config struct {
+	// > @3@4@5@6
+	// >
+	// > The list of the rules. It's a list of objects, each of them has the fields:
+	// > * `match_fields` – the conditions in the format of the actions `match_fields`, a rule without the conditions
+	// > matches any event in the `and` modes
+	// > * `match_mode` – the mode of the conditions: `and`, `or`, `and_prefix` or `or_prefix`, it's `and` by default
+	// > * `match_expr` – the boolean expression used instead of the `match_fields`,
+	// > e.g. `(status>=500 AND env=prod) OR level=fatal`
+	// > * `match_invert` – whether to invert the match
+	// > * `set` – the fields to set, the keys are the field selectors, e.g. `alert.priority`,
+	// > and the values are any JSON values
+	Rules []RuleConfig `json:"rules" required:"true" slice:"true"` // *
+
+	// > @3@4@5@6
+	// >
+	// > Which rules are applied:
+	// > * `first` – only the first matched rule
+	// > * `all` – all the matched rules, so the next rules overwrite the fields of the previous ones
+	Mode  string `json:"mode" default:"first" options:"first|all"` // *
+	Mode_ mode
+}
+
+type RuleConfig struct {
+	MatchFields map[string]any `json:"match_fields"`
+	MatchMode   string         `json:"match_mode" default:"and" options:"and|or|and_prefix|or_prefix"`
+	MatchMode_  pipeline.MatchMode
+	MatchExpr   string         `json:"match_expr"`
+	MatchInvert bool           `json:"match_invert"`
+	Set         map[string]any `json:"set" required:"true"`
+}
+
+func init() {
+	fd.DefaultPluginRegistry.RegisterAction(&pipeline.PluginStaticInfo{
+		Type:    "set_if",
+		Factory: factory,
+	})
+}
+
+func factory() (pipeline.AnyPlugin, pipeline.AnyConfig) {
+	return &Plugin{}, &Config{}
+}
+
+func (p *Plugin) Start(config pipeline.AnyConfig, params *pipeline.ActionPluginParams) {
+	p.config = config.(*Config)
+	p.logger = params.Logger
+
+	if len(p.config.Rules) == 0 {
+		p.logger.Fatalf("rules should be set")
+	}
+
+	p.rules = make([]rule, 0, len(p.config.Rules))
+	for i, rc := range p.config.Rules {
+		conditions, err := fd.ParseMatchConditions(rc.MatchFields)
+		if err != nil {
+			p.logger.Fatalf("can't parse conditions of rule %d: %s", i, err.Error())
+		}
+		var expr *pipeline.MatchExpr
+		if rc.MatchExpr != "" {
+			if len(conditions) != 0 {
+				p.logger.Fatalf("match_fields and match_expr of rule %d can't be used together", i)
+			}
+			if expr, err = pipeline.ParseMatchExpr(rc.MatchExpr); err != nil {
+				p.logger.Fatalf("can't parse match expression of rule %d: %s", i, err.Error())
+			}
+		}
+		if len(rc.Set) == 0 {
+			p.logger.Fatalf("fields to set of rule %d should be set", i)
+		}
+
+		// the fields are sorted to set them in the same order for all events
+		keys := make([]string, 0, len(rc.Set))
+		for key := range rc.Set {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		fields := make([]field, 0, len(keys))
+		for _, key := range keys {
+			value, err := json.Marshal(rc.Set[key])
+			if err != nil {
+				p.logger.Fatalf("can't encode value of field %s of rule %d: %s", key, i, err.Error())
+			}
+			fields = append(fields, field{
+				path:  cfg.ParseFieldSelector(key),
+				value: string(value),
+			})
+		}
+
+		p.rules = append(p.rules, rule{
+			conditions: conditions,
+			mode:       rc.MatchMode_,
+			expr:       expr,
+			invert:     rc.MatchInvert,
+			fields:     fields,
+		})
+	}
+}
+
+// match checks the event by the expression if it's set, otherwise by the conditions, the invert isn't applied
+func (r *rule) match(event *pipeline.Event) bool {
+	if r.expr != nil {
+		return r.expr.Match(event)
+	}
+	return r.conditions.Match(event, r.mode)
+}
+
+func (p *Plugin) Stop() {
+}
+
+func (p *Plugin) Do(event *pipeline.Event) pipeline.ActionResult {
+	for i := range p.rules {
+		r := &p.rules[i]
+		if r.match(event) == r.invert {
+			continue
+		}
+
+		for j := range r.fields {
+			f := &r.fields[j]
+			pipeline.CreateNestedField(event.Root, f.path).MutateToJSON(event.Root, f.value)
+		}
+
+		if p.config.Mode_ == modeFirst {
+			break
+		}
+	}
+
+	return pipeline.ActionPass
+}
@@ -0,0 +1,88 @@
+package set_if
+
+import (
+	"testing"
+
+	"github.com/ozontech/file.d/pipeline"
+	"github.com/ozontech/file.d/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	insaneJSON "github.com/vitkovskii/insane-json"
+)
+
+func TestSetIf(t *testing.T) {
+	rules := []RuleConfig{
+		{
+			MatchExpr: `status>=500 AND env=prod`,
+			Set:       map[string]any{"priority": "critical"},
+		},
+		{
+			MatchFields: map[string]any{"status": `/^5\d\d$/`},
+			Set:         map[string]any{"priority": "high", "alert.enabled": true},
+		},
+		{
+			MatchFields: map[string]any{"status": `/^4\d\d$/`, "path": []any{"/login", "/logout"}},
+			MatchMode:   "or",
+			Set:         map[string]any{"priority": "medium"},
+		},
+		{
+			MatchFields: map[string]any{"service": "debug"},
+			MatchInvert: true,
+			Set:         map[string]any{"priority": "low", "retries": 3},
+		},
+	}
+
+	cases := []struct {
+		name string
+		mode string
+		in   string
+		out  string
+	}{
+		{
+			name: "first_match",
+			in:   `{"status":503,"service":"api"}`,
+			out:  `{"status":503,"service":"api","alert":{"enabled":true},"priority":"high"}`,
+		},
+		{
+			name: "match_expr",
+			in:   `{"status":502,"env":"prod","service":"api"}`,
+			out:  `{"status":502,"env":"prod","service":"api","priority":"critical"}`,
+		},
+		{
+			name: "or_mode",
+			in:   `{"status":200,"path":"/login"}`,
+			out:  `{"status":200,"path":"/login","priority":"medium"}`,
+		},
+		{
+			name: "invert",
+			in:   `{"status":200,"service":"api"}`,
+			out:  `{"status":200,"service":"api","priority":"low","retries":3}`,
+		},
+		{
+			name: "no_match",
+			in:   `{"status":200,"service":"debug"}`,
+			out:  `{"status":200,"service":"debug"}`,
+		},
+		{
+			name: "all_match",
+			mode: "all",
+			in:   `{"status":503,"service":"api","priority":"none"}`,
+			out:  `{"status":503,"service":"api","priority":"low","alert":{"enabled":true},"retries":3}`,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			config := test.NewConfig(&Config{Rules: rules, Mode: tt.mode}, nil)
+			p := &Plugin{}
+			p.Start(config, test.NewEmptyActionPluginParams())
+
+			root, err := insaneJSON.DecodeString(tt.in)
+			require.NoError(t, err)
+			defer insaneJSON.Release(root)
+
+			p.Do(&pipeline.Event{Root: root})
+			assert.Equal(t, tt.out, root.EncodeToString())
+		})
+	}
+}
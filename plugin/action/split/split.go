@@ -0,0 +1,163 @@
+package split
+
+import (
+	"github.com/ozontech/file.d/cfg"
+	"github.com/ozontech/file.d/fd"
+	"github.com/ozontech/file.d/metric"
+	"github.com/ozontech/file.d/pipeline"
+	"github.com/prometheus/client_golang/prometheus"
+	insaneJSON "github.com/vitkovskii/insane-json"
+)
+
+/*{ introduction
+It splits the array of the event field into the separate events, one per element.
+The next actions and the output receive only the elements, the original event is committed after all of them,
+so the input offset doesn't move forward until the whole array is delivered.
+
+The element objects become the events as is, other elements are put to the field with the last name of `field`.
+The `parent_fields` are copied from the original event to every element unless the element has the same field.
+The event with the empty array is dropped.
+
+**Example:**
+```yaml
+pipelines:
+  example_pipeline:
+    ...
+    actions:
+    - type: split
+      field: events
+      parent_fields:
+      - host
+    ...
+```
+
+The event:
+```json
+{"host":"node-1","events":[{"msg":"first"},{"msg":"second"}]}
+```
+
+Will be split into:
+```json
+{"msg":"first","host":"node-1"}
+{"msg":"second","host":"node-1"}
+```
+}*/
+
+const (
+	onNotArrayPass    = "pass"
+	onNotArrayDiscard = "discard"
+)
+
+type Plugin struct {
+	config       *Config
+	controller   pipeline.ActionPluginController
+	parentFields [][]string
+
+	nodes []*insaneJSON.Node
+	buf   []byte
+
+	// plugin metrics
+	notArrayMetric *prometheus.CounterVec
+	childrenMetric *prometheus.CounterVec
+}
+
+// ! config-params
+// ^ config-params
+type Config struct {
+	// > @3@4@5@6
+	// >
+	// > The field with the array to split.
+	Field  cfg.FieldSelector `json:"field" parse:"selector" required:"true"` // *
+	Field_ []string
+
+	// > @3@4@5@6
+	// >
+	// > The list of the fields to copy from the original event to every element.
+	ParentFields []string `json:"parent_fields" slice:"true"` // *
+
+	// > @3@4@5@6
+	// >
+	// > What to do if the field is missing or isn't an array:
+	// > * `pass` – pass the event as is
+	// > * `discard` – drop the event
+	OnNotArray string `json:"on_not_array" default:"pass" options:"pass|discard"` // *
+}
+
+func init() {
+	fd.DefaultPluginRegistry.RegisterAction(&pipeline.PluginStaticInfo{
+		Type:    "split",
+		Factory: factory,
+	})
+}
+
+func factory() (pipeline.AnyPlugin, pipeline.AnyConfig) {
+	return &Plugin{}, &Config{}
+}
+
+func (p *Plugin) Start(config pipeline.AnyConfig, params *pipeline.ActionPluginParams) {
+	p.config = config.(*Config)
+	p.controller = params.Controller
+	p.registerMetrics(params.MetricCtl)
+
+	p.parentFields = make([][]string, 0, len(p.config.ParentFields))
+	for _, field := range p.config.ParentFields {
+		p.parentFields = append(p.parentFields, cfg.ParseFieldSelector(field))
+	}
+}
+
+func (p *Plugin) registerMetrics(ctl *metric.Ctl) {
+	p.notArrayMetric = ctl.RegisterCounter("action_split_not_array_total", "Number of events without the array to split")
+	p.childrenMetric = ctl.RegisterCounter("action_split_children_total", "Number of events made of the array elements")
+}
+
+func (p *Plugin) Stop() {
+}
+
+func (p *Plugin) Do(event *pipeline.Event) pipeline.ActionResult {
+	node := event.Root.Dig(p.config.Field_...)
+	if node == nil || !node.IsArray() {
+		p.notArrayMetric.WithLabelValues().Inc()
+		if p.config.OnNotArray == onNotArrayDiscard {
+			return pipeline.ActionDiscard
+		}
+		return pipeline.ActionPass
+	}
+
+	name := p.config.Field_[len(p.config.Field_)-1]
+	p.nodes = p.nodes[:0]
+	for _, elem := range node.AsArray() {
+		if !elem.IsObject() {
+			// the element is wrapped into the object with the single field in place
+			p.buf = elem.Encode(p.buf[:0])
+			elem.MutateToObject()
+			elem.AddFieldNoAlloc(event.Root, name).MutateToJSON(event.Root, pipeline.ByteToStringUnsafe(p.buf))
+		}
+		p.copyParentFields(event.Root, elem)
+		p.nodes = append(p.nodes, elem)
+	}
+	p.childrenMetric.WithLabelValues().Add(float64(len(p.nodes)))
+
+	p.controller.Spawn(event, p.nodes)
+	return pipeline.ActionBreak
+}
+
+func (p *Plugin) copyParentFields(root *insaneJSON.Root, elem *insaneJSON.Node) {
+	for _, path := range p.parentFields {
+		value := root.Dig(path...)
+		if value == nil || elem.Dig(path...) != nil {
+			continue
+		}
+
+		// the same as pipeline.CreateNestedField, but for the element
+		curr := elem
+		for i, name := range path {
+			curr = curr.AddFieldNoAlloc(root, name)
+			if i != len(path)-1 && !curr.IsObject() {
+				curr.MutateToObject()
+			}
+		}
+		// the value is copied through the encoding, since the node can't be shared between several elements
+		p.buf = value.Encode(p.buf[:0])
+		curr.MutateToJSON(root, pipeline.ByteToStringUnsafe(p.buf))
+	}
+}
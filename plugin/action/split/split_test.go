@@ -0,0 +1,145 @@
+package split
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/ozontech/file.d/pipeline"
+	"github.com/ozontech/file.d/test"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/atomic"
+)
+
+func TestSplit(t *testing.T) {
+	cases := []struct {
+		name   string
+		config *Config
+		in     string
+		out    []string
+	}{
+		{
+			name:   "objects",
+			config: &Config{Field: "events"},
+			in:     `{"events":[{"msg":"first"},{"msg":"second"}]}`,
+			out:    []string{`{"msg":"first"}`, `{"msg":"second"}`},
+		},
+		{
+			name:   "parent fields",
+			config: &Config{Field: "data.events", ParentFields: []string{"host", "k8s.pod", "msg"}},
+			in:     `{"host":"node-1","k8s":{"pod":"app"},"msg":"parent","data":{"events":[{"msg":"first"},{"level":"info"}]}}`,
+			out: []string{
+				`{"msg":"first","host":"node-1","k8s":{"pod":"app"}}`,
+				`{"level":"info","host":"node-1","k8s":{"pod":"app"},"msg":"parent"}`,
+			},
+		},
+		{
+			name:   "scalars",
+			config: &Config{Field: "data.values", ParentFields: []string{"host"}},
+			in:     `{"host":"node-1","data":{"values":[1,"two",[3]]}}`,
+			out: []string{
+				`{"values":1,"host":"node-1"}`,
+				`{"values":"two","host":"node-1"}`,
+				`{"values":[3],"host":"node-1"}`,
+			},
+		},
+		{
+			name:   "not array pass",
+			config: &Config{Field: "events"},
+			in:     `{"events":"first"}`,
+			out:    []string{`{"events":"first"}`},
+		},
+		{
+			name:   "missing pass",
+			config: &Config{Field: "events"},
+			in:     `{"msg":"first"}`,
+			out:    []string{`{"msg":"first"}`},
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			test.NewConfig(tt.config, nil)
+			p, input, output := test.NewPipelineMock(test.NewActionPluginStaticInfo(factory, tt.config, pipeline.MatchModeAnd, nil, false))
+
+			wg := &sync.WaitGroup{}
+			wg.Add(len(tt.out))
+			outEvents := make([]string, 0, len(tt.out))
+			output.SetOutFn(func(e *pipeline.Event) {
+				outEvents = append(outEvents, e.Root.EncodeToString())
+				wg.Done()
+			})
+
+			input.In(0, "test.log", 0, []byte(tt.in))
+
+			wg.Wait()
+			p.Stop()
+
+			require.Equal(t, tt.out, outEvents)
+		})
+	}
+}
+
+func TestSplitCommit(t *testing.T) {
+	cases := []struct {
+		name     string
+		config   *Config
+		in       []string
+		outCount int
+		// discarded events aren't committed to the input
+		commitCount int
+	}{
+		{
+			name:        "children",
+			config:      &Config{Field: "events"},
+			in:          []string{`{"events":[{"a":1},{"a":2},{"a":3}]}`, `{"events":[{"a":4}]}`},
+			outCount:    4,
+			commitCount: 2,
+		},
+		{
+			name:        "empty array",
+			config:      &Config{Field: "events"},
+			in:          []string{`{"events":[]}`, `{"events":[{"a":1}]}`},
+			outCount:    1,
+			commitCount: 2,
+		},
+		{
+			name:        "not array discard",
+			config:      &Config{Field: "events", OnNotArray: "discard"},
+			in:          []string{`{"events":{}}`, `{"events":[{"a":1}]}`},
+			outCount:    1,
+			commitCount: 1,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			test.NewConfig(tt.config, nil)
+			p, input, output := test.NewPipelineMock(test.NewActionPluginStaticInfo(factory, tt.config, pipeline.MatchModeAnd, nil, false))
+
+			outCount := atomic.Int32{}
+			output.SetOutFn(func(e *pipeline.Event) {
+				require.True(t, e.IsChild(), "only children should be passed to the output")
+				outCount.Inc()
+			})
+
+			// the parents are committed once, the children aren't committed
+			wg := &sync.WaitGroup{}
+			wg.Add(tt.commitCount)
+			committed := make([]int64, 0, tt.commitCount)
+			input.SetCommitFn(func(e *pipeline.Event) {
+				committed = append(committed, e.Offset)
+				wg.Done()
+			})
+
+			for i, e := range tt.in {
+				input.In(0, "test.log", int64(i), []byte(e))
+			}
+
+			wg.Wait()
+			p.Stop()
+
+			require.Equal(t, int32(tt.outCount), outCount.Load())
+			require.Len(t, committed, tt.commitCount)
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package throttle
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ozontech/file.d/pipeline"
+)
+
+// leakyBucketLimiter is filled by the events and leaks by the limit per interval continuously,
+// the event is allowed while the bucket isn't overflowed.
+type leakyBucketLimiter struct {
+	limit    complexLimit
+	interval time.Duration
+
+	mu       sync.Mutex
+	level    float64
+	lastLeak time.Time
+
+	// nowFn is passed to create limiters and required for test purposes
+	nowFn func() time.Time
+}
+
+// NewLeakyBucketLimiter returns leaky bucket limiter instance.
+func NewLeakyBucketLimiter(interval time.Duration, limit complexLimit, nowFn func() time.Time) *leakyBucketLimiter {
+	return &leakyBucketLimiter{
+		limit:    limit,
+		interval: interval,
+		nowFn:    nowFn,
+	}
+}
+
+func (l *leakyBucketLimiter) sync() {
+
+}
+
+func (l *leakyBucketLimiter) isAllowed(event *pipeline.Event, _ time.Time) bool {
+	// limit value fast check without races
+	limit := atomic.LoadInt64(&l.limit.value)
+	if limit < 0 {
+		return true
+	}
+
+	cost := float64(eventCost(event, l.limit.kind))
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.nowFn()
+	if elapsed := now.Sub(l.lastLeak); elapsed > 0 {
+		l.level -= float64(limit) * float64(elapsed) / float64(l.interval)
+		if l.level < 0 {
+			l.level = 0
+		}
+		l.lastLeak = now
+	}
+
+	if l.level+cost > float64(limit) {
+		return false
+	}
+	l.level += cost
+
+	return true
+}
+
+func (l *leakyBucketLimiter) setNowFn(fn func() time.Time) {
+	l.mu.Lock()
+	l.nowFn = fn
+	l.mu.Unlock()
+}
@@ -0,0 +1,36 @@
+package throttle
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ozontech/file.d/pipeline"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLeakyBucketLimiter(t *testing.T) {
+	now := time.Now()
+	lim := NewLeakyBucketLimiter(time.Second, complexLimit{value: 10, kind: "count"}, func() time.Time {
+		return now
+	})
+
+	require.Equal(t, 10, allowedCount(lim, 20, now))
+
+	// the half of the bucket is leaked
+	now = now.Add(time.Millisecond * 500)
+	require.Equal(t, 5, allowedCount(lim, 20, now))
+
+	now = now.Add(time.Hour)
+	require.Equal(t, 10, allowedCount(lim, 20, now), "bucket shouldn't leak below zero")
+}
+
+func TestLeakyBucketLimiterSize(t *testing.T) {
+	now := time.Now()
+	lim := NewLeakyBucketLimiter(time.Second, complexLimit{value: 100, kind: "size"}, func() time.Time {
+		return now
+	})
+
+	require.True(t, lim.isAllowed(&pipeline.Event{Size: 60}, now))
+	require.False(t, lim.isAllowed(&pipeline.Event{Size: 60}, now))
+	require.True(t, lim.isAllowed(&pipeline.Event{Size: 40}, now))
+}
@@ -27,6 +27,12 @@ type redisClient interface {
 	SetNX(key string, value any, expiration time.Duration) *redis.BoolCmd
 	Get(key string) *redis.StringCmd
 	Ping() *redis.StatusCmd
+
+	// scripting is used by the token bucket limiter
+	Eval(script string, keys []string, args ...any) *redis.Cmd
+	EvalSha(sha1 string, keys []string, args ...any) *redis.Cmd
+	ScriptExists(hashes ...string) *redis.BoolSliceCmd
+	ScriptLoad(script string) *redis.StringCmd
 }
 
 type limiter interface {
@@ -54,12 +60,18 @@ func newLimiterWithGen(lim limiter, gen int64) *limiterWithGen {
 type limiterConfig struct {
 	ctx               context.Context
 	backend           string
+	kind              string
 	redisClient       redisClient
 	pipeline          string
 	throttleField     string
 	bucketInterval    time.Duration
 	bucketsCount      int
 	limiterValueField string
+
+	// token bucket settings
+	tokenLease        int64
+	fallback          string
+	redisAvailability *redisAvailability
 }
 
 // limitersMapConfig configuration of limiters map.
@@ -118,6 +130,11 @@ func newLimitersMap(lmCfg limitersMapConfig, redisOpts *redis.Options) *limiters
 				lm.logger.Fatal(msg)
 			}
 			lm.logger.Error(msg)
+			if lm.limiterCfg.backend == redisTokenBucketBackend {
+				lm.limiterCfg.redisAvailability.markFailed(time.Now())
+				lm.logger.Warnf("limiters will use %q fallback for %s", lm.limiterCfg.fallback, lm.limiterCfg.redisAvailability.retryInterval)
+				return lm
+			}
 			lm.logger.Warnf(
 				"sync with redis won't start until successful connect, reconnection attempts will happen every %s",
 				redisReconnectInterval,
@@ -235,7 +252,27 @@ func (l *limitersMap) getNewLimiter(throttleKey, keyLimitOverride string, rule *
 			l.limiterCfg.limiterValueField,
 			l.nowFn,
 		)
+	case redisTokenBucketBackend:
+		return NewTokenBucketLimiter(
+			l.limiterCfg.redisClient,
+			l.limiterCfg.redisAvailability,
+			l.limiterCfg.pipeline,
+			l.limiterCfg.throttleField,
+			throttleKey,
+			l.limiterCfg.bucketInterval,
+			l.limiterCfg.bucketsCount,
+			rule.limit,
+			l.limiterCfg.tokenLease,
+			l.limiterCfg.fallback,
+			l.nowFn,
+		)
 	case inMemoryBackend:
+		switch l.limiterCfg.kind {
+		case slidingWindowLimiterKind:
+			return NewSlidingWindowLimiter(l.limiterCfg.bucketInterval, rule.limit, l.nowFn)
+		case leakyBucketLimiterKind:
+			return NewLeakyBucketLimiter(l.limiterCfg.bucketInterval, rule.limit, l.nowFn)
+		}
 		return NewInMemoryLimiter(l.limiterCfg.bucketInterval, l.limiterCfg.bucketsCount, rule.limit, l.nowFn)
 	default:
 		l.logger.Panicf("unknown limiter backend: %s", l.limiterCfg.backend)
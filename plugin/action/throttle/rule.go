@@ -11,6 +11,14 @@ type complexLimit struct {
 	kind  string
 }
 
+// eventCost returns the part of the limit the event takes.
+func eventCost(event *pipeline.Event, kind string) int64 {
+	if kind == "size" {
+		return int64(event.Size)
+	}
+	return 1
+}
+
 type rule struct {
 	fields      []string // sorted list of used keys is used for combining limiter key.
 	values      []string // values to check against. order is the same as for keys.
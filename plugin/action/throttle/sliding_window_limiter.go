@@ -0,0 +1,90 @@
+package throttle
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ozontech/file.d/pipeline"
+)
+
+type windowEntry struct {
+	ts   time.Time
+	cost int64
+}
+
+// slidingWindowLimiter keeps the log of the allowed events within the last interval,
+// so there are no bursts at the window boundaries as for the fixed buckets.
+type slidingWindowLimiter struct {
+	limit    complexLimit
+	interval time.Duration
+
+	mu sync.Mutex
+	// entries are ordered by the time, the first one is the oldest
+	entries []windowEntry
+	total   int64
+
+	// nowFn is passed to create limiters and required for test purposes
+	nowFn func() time.Time
+}
+
+// NewSlidingWindowLimiter returns sliding window log limiter instance.
+func NewSlidingWindowLimiter(interval time.Duration, limit complexLimit, nowFn func() time.Time) *slidingWindowLimiter {
+	return &slidingWindowLimiter{
+		limit:    limit,
+		interval: interval,
+		nowFn:    nowFn,
+	}
+}
+
+func (l *slidingWindowLimiter) sync() {
+
+}
+
+func (l *slidingWindowLimiter) isAllowed(event *pipeline.Event, _ time.Time) bool {
+	// limit value fast check without races
+	limit := atomic.LoadInt64(&l.limit.value)
+	if limit < 0 {
+		return true
+	}
+
+	cost := eventCost(event, l.limit.kind)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.nowFn()
+	l.evict(now)
+
+	if l.total+cost > limit {
+		return false
+	}
+	l.entries = append(l.entries, windowEntry{ts: now, cost: cost})
+	l.total += cost
+
+	return true
+}
+
+// evict removes the entries older than the interval.
+// Not thread safe - use external lock!
+func (l *slidingWindowLimiter) evict(now time.Time) {
+	border := now.Add(-l.interval)
+
+	n := 0
+	for n < len(l.entries) && !l.entries[n].ts.After(border) {
+		l.total -= l.entries[n].cost
+		n++
+	}
+	if n == 0 {
+		return
+	}
+
+	// the entries are moved to the start to reuse the memory
+	l.entries = l.entries[:copy(l.entries, l.entries[n:])]
+}
+
+func (l *slidingWindowLimiter) setNowFn(fn func() time.Time) {
+	l.mu.Lock()
+	l.nowFn = fn
+	l.mu.Unlock()
+}
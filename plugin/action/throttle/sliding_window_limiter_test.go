@@ -0,0 +1,33 @@
+package throttle
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSlidingWindowLimiter(t *testing.T) {
+	now := time.Now()
+	lim := NewSlidingWindowLimiter(time.Second, complexLimit{value: 10, kind: "count"}, func() time.Time {
+		return now
+	})
+
+	now = now.Add(time.Millisecond * 900)
+	require.Equal(t, 10, allowedCount(lim, 20, now))
+
+	// the fixed window would allow the burst here
+	now = now.Add(time.Millisecond * 200)
+	require.Equal(t, 0, allowedCount(lim, 20, now), "events of the last interval should be counted")
+
+	now = now.Add(time.Millisecond * 800)
+	require.Equal(t, 10, allowedCount(lim, 20, now), "events out of the interval should be evicted")
+	require.Len(t, lim.entries, 10)
+}
+
+func TestSlidingWindowLimiterNoLimit(t *testing.T) {
+	lim := NewSlidingWindowLimiter(time.Second, complexLimit{value: -1, kind: "count"}, time.Now)
+
+	require.Equal(t, 100, allowedCount(lim, 100, time.Now()))
+	require.Empty(t, lim.entries)
+}
@@ -25,8 +25,13 @@ var (
 )
 
 const (
-	redisBackend    = "redis"
-	inMemoryBackend = "memory"
+	redisBackend            = "redis"
+	redisTokenBucketBackend = "redis_token_bucket"
+	inMemoryBackend         = "memory"
+
+	fixedWindowLimiterKind   = "fixed_window"
+	slidingWindowLimiterKind = "sliding_window"
+	leakyBucketLimiterKind   = "leaky_bucket"
 )
 
 /*{ introduction
@@ -86,7 +91,20 @@ type Config struct {
 	// >
 	// > Defines kind of backend. When redis backend is chosen and if by any reason plugin cannot connect to redis,
 	// > limiters will not start syncing with redis until successful reconnect.
-	LimiterBackend string `json:"limiter_backend" default:"memory" options:"memory|redis"` // *
+	// > The `redis_token_bucket` backend enforces the limit across all file.d instances with the token bucket in redis:
+	// > the bucket holds up to the limit of tokens and is refilled by the limit per `bucket_interval`.
+	// > The event time isn't taken into account by this backend.
+	LimiterBackend string `json:"limiter_backend" default:"memory" options:"memory|redis|redis_token_bucket"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The algorithm of the limiter:
+	// > * `fixed_window` – counts the events in the buckets of `bucket_interval`, the bursts up to the double limit are possible at the bucket boundaries
+	// > * `sliding_window` – keeps the log of the events within the last `bucket_interval`, it takes the memory proportional to the limit
+	// > * `leaky_bucket` – the bucket of the limit size leaks by the limit per `bucket_interval` continuously
+	// >
+	// > The `sliding_window` and `leaky_bucket` are supported by the `memory` backend only, they don't use the event time.
+	LimiterKind string `json:"limiter_kind" default:"fixed_window" options:"fixed_window|sliding_window|leaky_bucket"` // *
 
 	// > @3@4@5@6
 	// >
@@ -179,6 +197,26 @@ type RedisBackendConfig struct {
 	// > (e.g. if set to "limit", values must be of kind `{"limit":"<int>",...}`).
 	// > If not set limiter values are considered as non-json data.
 	LimiterValueField string `json:"limiter_value_field" default:""` // *
+
+	// > @3@4@5@6
+	// >
+	// > The number of tokens taken from the redis bucket at once and spent locally by the `redis_token_bucket` backend.
+	// > Tokens are bytes for the `size` limit kind. If it's 0, the lease is 1% of the limit.
+	TokenLease int64 `json:"token_lease"` // *
+
+	// > @3@4@5@6
+	// >
+	// > What the `redis_token_bucket` backend does while redis is unavailable:
+	// > * `local` – limits the events by the in-memory limiter of the instance
+	// > * `pass` – passes all events
+	// > * `discard` – discards all events
+	Fallback string `json:"fallback" default:"local" options:"local|pass|discard"` // *
+
+	// > @3@4@5@6
+	// >
+	// > How long the `redis_token_bucket` backend uses the fallback before requesting redis again after the failure.
+	RetryInterval  cfg.Duration `json:"retry_interval" parse:"duration" default:"10s"` // *
+	RetryInterval_ time.Duration
 }
 
 type RuleConfig struct {
@@ -214,11 +252,15 @@ func (p *Plugin) Start(config pipeline.AnyConfig, params *pipeline.ActionPluginP
 	}
 	p.format = format
 
+	if p.config.LimiterKind != fixedWindowLimiterKind && p.config.LimiterBackend != inMemoryBackend {
+		p.logger.Fatalf("limiter_kind %q is supported by %q backend only", p.config.LimiterKind, inMemoryBackend)
+	}
+
 	limitersMu.Lock()
 	// init limitersMap only once per pipeline
 	if _, has := limiters[p.pipeline]; !has {
 		var redisOpts *redis.Options
-		if p.config.LimiterBackend == redisBackend {
+		if p.config.LimiterBackend == redisBackend || p.config.LimiterBackend == redisTokenBucketBackend {
 			if p.config.RedisBackendCfg.WorkerCount < 1 {
 				p.logger.Fatalf("workers_count must be > 0, passed: %d", p.config.RedisBackendCfg.WorkerCount)
 			}
@@ -242,11 +284,15 @@ func (p *Plugin) Start(config pipeline.AnyConfig, params *pipeline.ActionPluginP
 			limiterCfg: &limiterConfig{
 				ctx:               p.ctx,
 				backend:           p.config.LimiterBackend,
+				kind:              p.config.LimiterKind,
 				pipeline:          p.pipeline,
 				throttleField:     string(p.config.ThrottleField),
 				bucketInterval:    p.config.BucketInterval_,
 				bucketsCount:      p.config.BucketsCount,
 				limiterValueField: p.config.RedisBackendCfg.LimiterValueField,
+				tokenLease:        p.config.RedisBackendCfg.TokenLease,
+				fallback:          p.config.RedisBackendCfg.Fallback,
+				redisAvailability: &redisAvailability{retryInterval: p.config.RedisBackendCfg.RetryInterval_},
 			},
 			mapSizeMetric: p.limitersMapSizeMetric,
 		}
@@ -0,0 +1,217 @@
+package throttle
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/ozontech/file.d/logger"
+	"github.com/ozontech/file.d/pipeline"
+)
+
+const (
+	tokenBucketKeySuffix = "bucket"
+
+	fallbackLocal   = "local"
+	fallbackPass    = "pass"
+	fallbackDiscard = "discard"
+
+	// tokenLeaseDivider defines the default lease as the part of the limit
+	tokenLeaseDivider = 100
+)
+
+var errUnexpectedScriptResult = errors.New("unexpected result of the script")
+
+// takeTokensScript refills the bucket by the time passed since the last call and takes up to the requested tokens.
+// The bucket is the hash of the tokens left and the time of the last refill in milliseconds,
+// it expires when it's full again, so the idle keys don't stay in redis.
+//
+// KEYS[1] – the bucket key
+// ARGV[1] – the capacity, ARGV[2] – tokens per millisecond, ARGV[3] – now in milliseconds, ARGV[4] – requested tokens
+var takeTokensScript = redis.NewScript(`
+local capacity = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local requested = tonumber(ARGV[4])
+
+local bucket = redis.call("HMGET", KEYS[1], "tokens", "ts")
+local tokens = tonumber(bucket[1])
+local ts = tonumber(bucket[2])
+if tokens == nil or ts == nil then
+	tokens = capacity
+	ts = now
+end
+if now > ts then
+	tokens = math.min(capacity, tokens + (now - ts) * rate)
+	ts = now
+end
+
+local granted = math.min(requested, math.floor(tokens))
+tokens = tokens - granted
+
+redis.call("HMSET", KEYS[1], "tokens", tostring(tokens), "ts", tostring(ts))
+redis.call("PEXPIRE", KEYS[1], math.ceil((capacity - tokens) / rate) + 1000)
+
+return granted
+`)
+
+// redisAvailability is shared by the token bucket limiters of the pipeline,
+// so the failed redis isn't requested by every limiter until the retry interval passes.
+type redisAvailability struct {
+	downUntil     atomic.Int64
+	retryInterval time.Duration
+}
+
+func (a *redisAvailability) isAvailable(now time.Time) bool {
+	return now.UnixNano() >= a.downUntil.Load()
+}
+
+func (a *redisAvailability) markFailed(now time.Time) {
+	a.downUntil.Store(now.Add(a.retryInterval).UnixNano())
+}
+
+// tokenBucketLimiter takes the tokens from the bucket in redis shared by all file.d instances.
+// The tokens are leased by batches and spent locally to reduce round-trips.
+type tokenBucketLimiter struct {
+	redis        redisClient
+	availability *redisAvailability
+	key          string
+
+	limit complexLimit
+	// rate is the number of tokens added to the bucket per millisecond
+	rate  float64
+	lease int64
+
+	mu     sync.Mutex
+	tokens int64
+	// nextTake is the time the redis bucket is expected to have the lease again after it's run out
+	nextTake time.Time
+
+	fallback      string
+	localFallback *inMemoryLimiter
+
+	// nowFn is passed to create limiters and required for test purposes
+	nowFn func() time.Time
+}
+
+// NewTokenBucketLimiter returns instance of token bucket limiter.
+func NewTokenBucketLimiter(
+	redis redisClient,
+	availability *redisAvailability,
+	pipelineName, throttleFieldName, throttleFieldValue string,
+	bucketInterval time.Duration,
+	bucketCount int,
+	limit complexLimit,
+	lease int64,
+	fallback string,
+	nowFn func() time.Time,
+) *tokenBucketLimiter {
+	if lease <= 0 {
+		lease = limit.value / tokenLeaseDivider
+	}
+	if lease < 1 {
+		lease = 1
+	}
+
+	return &tokenBucketLimiter{
+		redis:         redis,
+		availability:  availability,
+		key:           pipelineName + "_" + throttleFieldName + "_" + throttleFieldValue + "_" + tokenBucketKeySuffix,
+		limit:         limit,
+		rate:          float64(limit.value) / float64(bucketInterval.Milliseconds()),
+		lease:         lease,
+		fallback:      fallback,
+		localFallback: NewInMemoryLimiter(bucketInterval, bucketCount, limit, nowFn),
+		nowFn:         nowFn,
+	}
+}
+
+func (l *tokenBucketLimiter) sync() {
+
+}
+
+func (l *tokenBucketLimiter) isAllowed(event *pipeline.Event, ts time.Time) bool {
+	// negative limit disables the limiter as for in-memory one
+	if l.limit.value < 0 {
+		return true
+	}
+
+	cost := eventCost(event, l.limit.kind)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.tokens >= cost {
+		l.tokens -= cost
+		return true
+	}
+
+	now := l.nowFn()
+	if now.Before(l.nextTake) {
+		return false
+	}
+	if !l.availability.isAvailable(now) {
+		return l.fallbackIsAllowed(event, ts)
+	}
+
+	requested := l.lease
+	if cost-l.tokens > requested {
+		requested = cost - l.tokens
+	}
+	granted, err := l.take(requested, now)
+	if err != nil {
+		logger.Errorf("can't take tokens from redis bucket %s, fallback is %q: %s", l.key, l.fallback, err.Error())
+		l.availability.markFailed(now)
+		return l.fallbackIsAllowed(event, ts)
+	}
+
+	l.tokens += granted
+	if granted < requested {
+		// the bucket is run out, so don't request it until the missing tokens are refilled
+		l.nextTake = now.Add(time.Duration(float64(requested-granted) / l.rate * float64(time.Millisecond)))
+	}
+	if l.tokens < cost {
+		return false
+	}
+	l.tokens -= cost
+	return true
+}
+
+func (l *tokenBucketLimiter) take(requested int64, now time.Time) (int64, error) {
+	result, err := takeTokensScript.Run(l.redis, []string{l.key},
+		l.limit.value,
+		strconv.FormatFloat(l.rate, 'f', -1, 64),
+		now.UnixMilli(),
+		requested,
+	).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	granted, ok := result.(int64)
+	if !ok {
+		return 0, errUnexpectedScriptResult
+	}
+	return granted, nil
+}
+
+func (l *tokenBucketLimiter) fallbackIsAllowed(event *pipeline.Event, ts time.Time) bool {
+	switch l.fallback {
+	case fallbackPass:
+		return true
+	case fallbackDiscard:
+		return false
+	default:
+		return l.localFallback.isAllowed(event, ts)
+	}
+}
+
+func (l *tokenBucketLimiter) setNowFn(fn func() time.Time) {
+	l.mu.Lock()
+	l.nowFn = fn
+	l.mu.Unlock()
+	l.localFallback.setNowFn(fn)
+}
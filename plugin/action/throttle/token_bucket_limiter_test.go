@@ -0,0 +1,102 @@
+package throttle
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis"
+	"github.com/ozontech/file.d/pipeline"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestTokenBucketLimiter(client redisClient, availability *redisAvailability, limit, lease int64, fallback string, nowFn func() time.Time) *tokenBucketLimiter {
+	return NewTokenBucketLimiter(
+		client,
+		availability,
+		"test_pipeline", "k8s_pod", "pod_1",
+		time.Second,
+		1,
+		complexLimit{value: limit, kind: "count"},
+		lease,
+		fallback,
+		nowFn,
+	)
+}
+
+func newTestRedisClient(addr string) *redis.Client {
+	return redis.NewClient(&redis.Options{
+		Network:      "tcp",
+		Addr:         addr,
+		ReadTimeout:  time.Second,
+		WriteTimeout: time.Second,
+		MaxRetries:   0,
+	})
+}
+
+func allowedCount(lim limiter, events int, ts time.Time) int {
+	allowed := 0
+	for i := 0; i < events; i++ {
+		if lim.isAllowed(&pipeline.Event{}, ts) {
+			allowed++
+		}
+	}
+	return allowed
+}
+
+func TestTokenBucketLimiterShared(t *testing.T) {
+	s, err := miniredis.Run()
+	require.NoError(t, err)
+	defer s.Close()
+
+	client := newTestRedisClient(s.Addr())
+	availability := &redisAvailability{retryInterval: time.Minute}
+
+	now := time.Now()
+	nowFn := func() time.Time {
+		return now
+	}
+
+	// the limiters of two instances share the bucket
+	first := newTestTokenBucketLimiter(client, availability, 10, 3, fallbackLocal, nowFn)
+	second := newTestTokenBucketLimiter(client, availability, 10, 3, fallbackLocal, nowFn)
+
+	require.Equal(t, 6, allowedCount(first, 6, now))
+	require.Equal(t, 4, allowedCount(second, 10, now), "second instance should get the rest of the tokens")
+	require.Equal(t, 0, allowedCount(first, 10, now), "bucket should be empty")
+
+	// the half of the bucket is refilled
+	now = now.Add(time.Millisecond * 500)
+	require.Equal(t, 5, allowedCount(second, 10, now))
+}
+
+func TestTokenBucketLimiterFallback(t *testing.T) {
+	now := time.Now()
+	nowFn := func() time.Time {
+		return now
+	}
+
+	cases := []struct {
+		fallback string
+		allowed  int
+	}{
+		{fallback: fallbackLocal, allowed: 5},
+		{fallback: fallbackPass, allowed: 10},
+		{fallback: fallbackDiscard, allowed: 0},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.fallback, func(t *testing.T) {
+			s, err := miniredis.Run()
+			require.NoError(t, err)
+
+			availability := &redisAvailability{retryInterval: time.Minute}
+			lim := newTestTokenBucketLimiter(newTestRedisClient(s.Addr()), availability, 5, 1, tt.fallback, nowFn)
+			s.Close()
+
+			require.Equal(t, tt.allowed, allowedCount(lim, 10, now))
+			require.False(t, availability.isAvailable(now), "redis should be marked as failed")
+			require.True(t, availability.isAvailable(now.Add(time.Minute)), "redis should be requested after the retry interval")
+		})
+	}
+}
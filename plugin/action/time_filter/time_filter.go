@@ -0,0 +1,162 @@
+package time_filter
+
+import (
+	"time"
+
+	"github.com/ozontech/file.d/cfg"
+	"github.com/ozontech/file.d/fd"
+	"github.com/ozontech/file.d/metric"
+	"github.com/ozontech/file.d/pipeline"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+/*{ introduction
+It discards the events which time is out of the window `[now - max_past, now + max_future]`.
+It prevents the events with the garbage time from getting to the wrong time-based indices.
+
+**Example:**
+```yaml
+pipelines:
+  example_pipeline:
+    ...
+    actions:
+    - type: time_filter
+      field: ts
+      formats: [rfc3339nano, unixtime]
+      max_past: 720h
+      max_future: 24h
+      on_unparsable: discard
+    ...
+```
+}*/
+
+type Plugin struct {
+	config  *Config
+	logger  *zap.SugaredLogger
+	formats []string
+
+	// plugin metrics
+	discardedMetric *prometheus.CounterVec
+}
+
+const (
+	onUnparsablePass = iota
+	onUnparsableDiscard
+)
+
+// ! config-params
+// ^ config-params
+type Config struct {
+	// > @3@4@5@6
+	// >
+	// > The event field which contains the time.
+	Field  cfg.FieldSelector `json:"field" parse:"selector" default:"time"` // *
+	Field_ []string
+
+	// > @3@4@5@6
+	// >
+	// > The list of formats to try in the order. The items should be one of
+	// > `ansic|unixdate|rubydate|rfc822|rfc822z|rfc850|rfc1123|rfc1123z|rfc3339|rfc3339nano|kitchen|stamp|stampmilli|stampmicro|stampnano|unixtime|nginx_errorlog`
+	// > or the Go reference layouts.
+	Formats []string `json:"formats" default:"rfc3339nano rfc3339"` // *
+
+	// > @3@4@5@6
+	// >
+	// > How old the event can be. Zero disables the check.
+	MaxPast  cfg.Duration `json:"max_past" parse:"duration" default:"720h"` // *
+	MaxPast_ time.Duration
+
+	// > @3@4@5@6
+	// >
+	// > How far in the future the event can be. Zero disables the check.
+	MaxFuture  cfg.Duration `json:"max_future" parse:"duration" default:"24h"` // *
+	MaxFuture_ time.Duration
+
+	// > @3@4@5@6
+	// >
+	// > What to do with the events which time is missing or doesn't fit any format:
+	// > * `pass` – pass the event on
+	// > * `discard` – discard the event
+	OnUnparsable  string `json:"on_unparsable" default:"pass" options:"pass|discard"` // *
+	OnUnparsable_ int
+}
+
+func init() {
+	fd.DefaultPluginRegistry.RegisterAction(&pipeline.PluginStaticInfo{
+		Type:    "time_filter",
+		Factory: factory,
+	})
+}
+
+func factory() (pipeline.AnyPlugin, pipeline.AnyConfig) {
+	return &Plugin{}, &Config{}
+}
+
+func (p *Plugin) Start(config pipeline.AnyConfig, params *pipeline.ActionPluginParams) {
+	p.config = config.(*Config)
+	p.logger = params.Logger
+	p.registerMetrics(params.MetricCtl)
+
+	if len(p.config.Formats) == 0 {
+		p.logger.Fatalf("formats should be set")
+	}
+	p.formats = make([]string, 0, len(p.config.Formats))
+	for _, name := range p.config.Formats {
+		format, err := pipeline.ParseFormatName(name)
+		if err != nil {
+			format = name
+		}
+		p.formats = append(p.formats, format)
+	}
+}
+
+func (p *Plugin) registerMetrics(ctl *metric.Ctl) {
+	p.discardedMetric = ctl.RegisterCounter("action_time_filter_discarded_total",
+		"Number of events discarded because of the time",
+		"reason",
+	)
+}
+
+func (p *Plugin) Stop() {
+}
+
+func (p *Plugin) Do(event *pipeline.Event) pipeline.ActionResult {
+	t, ok := p.parse(event)
+	if !ok {
+		if p.config.OnUnparsable_ == onUnparsableDiscard {
+			p.discardedMetric.WithLabelValues("unparsable").Inc()
+			return pipeline.ActionDiscard
+		}
+		return pipeline.ActionPass
+	}
+
+	now := time.Now()
+	if p.config.MaxPast_ > 0 && t.Before(now.Add(-p.config.MaxPast_)) {
+		p.discardedMetric.WithLabelValues("past").Inc()
+		return pipeline.ActionDiscard
+	}
+	if p.config.MaxFuture_ > 0 && t.After(now.Add(p.config.MaxFuture_)) {
+		p.discardedMetric.WithLabelValues("future").Inc()
+		return pipeline.ActionDiscard
+	}
+
+	return pipeline.ActionPass
+}
+
+func (p *Plugin) parse(event *pipeline.Event) (time.Time, bool) {
+	node := event.Root.Dig(p.config.Field_...)
+	if node == nil || !node.IsString() && !node.IsNumber() {
+		return time.Time{}, false
+	}
+
+	value := node.AsString()
+	for _, format := range p.formats {
+		t, err := pipeline.ParseTime(format, value)
+		if err == nil {
+			return t, true
+		}
+	}
+
+	return time.Time{}, false
+}
@@ -0,0 +1,79 @@
+package time_filter
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ozontech/file.d/pipeline"
+	"github.com/ozontech/file.d/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	insaneJSON "github.com/vitkovskii/insane-json"
+)
+
+func TestTimeFilter(t *testing.T) {
+	now := time.Now()
+
+	cases := []struct {
+		name         string
+		onUnparsable string
+		in           string
+		result       pipeline.ActionResult
+	}{
+		{
+			name:   "in_window",
+			in:     fmt.Sprintf(`{"time":%q}`, now.Add(-time.Hour).Format(time.RFC3339Nano)),
+			result: pipeline.ActionPass,
+		},
+		{
+			name:   "unixtime",
+			in:     fmt.Sprintf(`{"time":%d}`, now.Unix()),
+			result: pipeline.ActionPass,
+		},
+		{
+			name:   "too_old",
+			in:     fmt.Sprintf(`{"time":%q}`, now.Add(-31*24*time.Hour).Format(time.RFC3339)),
+			result: pipeline.ActionDiscard,
+		},
+		{
+			name:   "too_new",
+			in:     fmt.Sprintf(`{"time":%d}`, now.Add(25*time.Hour).Unix()),
+			result: pipeline.ActionDiscard,
+		},
+		{
+			name:   "unparsable_pass",
+			in:     `{"time":"yesterday"}`,
+			result: pipeline.ActionPass,
+		},
+		{
+			name:         "unparsable_discard",
+			onUnparsable: "discard",
+			in:           `{"time":"yesterday"}`,
+			result:       pipeline.ActionDiscard,
+		},
+		{
+			name:         "missing_discard",
+			onUnparsable: "discard",
+			in:           `{"message":"ok"}`,
+			result:       pipeline.ActionDiscard,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			config := test.NewConfig(&Config{
+				Formats:      []string{"rfc3339nano", "unixtime"},
+				OnUnparsable: tt.onUnparsable,
+			}, nil)
+			p := &Plugin{}
+			p.Start(config, test.NewEmptyActionPluginParams())
+
+			root, err := insaneJSON.DecodeString(tt.in)
+			require.NoError(t, err)
+			defer insaneJSON.Release(root)
+
+			assert.Equal(t, tt.result, p.Do(&pipeline.Event{Root: root}))
+		})
+	}
+}
@@ -0,0 +1,232 @@
+package unflatten
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/ozontech/file.d/cfg"
+	"github.com/ozontech/file.d/fd"
+	"github.com/ozontech/file.d/metric"
+	"github.com/ozontech/file.d/pipeline"
+	"github.com/prometheus/client_golang/prometheus"
+	insaneJSON "github.com/vitkovskii/insane-json"
+)
+
+/*{ introduction
+It builds the nested objects from the keys joined with the separator, it's the reverse of the `flatten` action.
+Only the keys of the root or of the provided field are unflattened, the keys with the empty parts are skipped.
+
+**Example:**
+```yaml
+pipelines:
+  example_pipeline:
+    ...
+    actions:
+    - type: unflatten
+      index_arrays: true
+    ...
+```
+It transforms `{"user.name":"bob","user.tags.0":"admin","level":"info"}` into `{"user":{"name":"bob","tags":["admin"]},"level":"info"}`.
+
+The key is in conflict if its path is already taken, e.g. `{"user":"bob","user.name":"bob"}`.
+The conflicts are resolved by `on_conflict` and counted by the `action_unflatten_conflicts_total` metric.
+}*/
+
+const (
+	onConflictSkip      = "skip"
+	onConflictOverwrite = "overwrite"
+	onConflictDrop      = "drop"
+
+	// maxArrayIndex limits the array size to not blow up the event by the sparse indexes
+	maxArrayIndex = 1024
+)
+
+type Plugin struct {
+	config *Config
+
+	fields   []*insaneJSON.Node
+	segments []string
+
+	//  plugin metrics
+
+	conflictsMetric *prometheus.CounterVec
+}
+
+// ! config-params
+// ^ config-params
+type Config struct {
+	// > @3@4@5@6
+	// >
+	// > The object which keys should be unflattened. If it's empty, the keys of the root are unflattened.
+	Field  cfg.FieldSelector `json:"field" parse:"selector"` // *
+	Field_ []string
+
+	// > @3@4@5@6
+	// >
+	// > The separator of the keys of the nested objects.
+	Separator string `json:"separator" default:"."` // *
+
+	// > @3@4@5@6
+	// >
+	// > If set, the numeric parts of the keys are the indexes of the arrays, e.g. `items.0.id`.
+	// > The indexes greater than 1024 are the object keys.
+	IndexArrays bool `json:"index_arrays"` // *
+
+	// > @3@4@5@6
+	// >
+	// > What to do if the path of the key is already taken:
+	// > * `skip` – keep the key as is
+	// > * `overwrite` – replace the existing value with the unflattened one
+	// > * `drop` – remove the key from the event
+	OnConflict string `json:"on_conflict" default:"skip" options:"skip|overwrite|drop"` // *
+}
+
+func init() {
+	fd.DefaultPluginRegistry.RegisterAction(&pipeline.PluginStaticInfo{
+		Type:    "unflatten",
+		Factory: factory,
+	})
+}
+
+func factory() (pipeline.AnyPlugin, pipeline.AnyConfig) {
+	return &Plugin{}, &Config{}
+}
+
+func (p *Plugin) Start(config pipeline.AnyConfig, params *pipeline.ActionPluginParams) {
+	p.config = config.(*Config)
+	p.registerMetrics(params.MetricCtl)
+}
+
+func (p *Plugin) registerMetrics(ctl *metric.Ctl) {
+	p.conflictsMetric = ctl.RegisterCounter("action_unflatten_conflicts_total", "Number of keys which paths are already taken")
+}
+
+func (p *Plugin) Stop() {
+}
+
+func (p *Plugin) Do(event *pipeline.Event) pipeline.ActionResult {
+	node := event.Root.Dig(p.config.Field_...)
+	if !node.IsObject() {
+		return pipeline.ActionPass
+	}
+
+	// fields are copied since the object is changed while unflattening
+	p.fields = append(p.fields[:0], node.AsFields()...)
+	for _, field := range p.fields {
+		key := field.AsString()
+		if !strings.Contains(key, p.config.Separator) || !p.split(key) {
+			continue
+		}
+
+		value := field.AsFieldValue()
+		if p.unflatten(event.Root, node, value) {
+			value.Suicide()
+			continue
+		}
+
+		p.conflictsMetric.WithLabelValues().Inc()
+		if p.config.OnConflict == onConflictDrop {
+			value.Suicide()
+		}
+	}
+
+	return pipeline.ActionPass
+}
+
+// split fills the segments of the key, it returns false if any segment is empty.
+func (p *Plugin) split(key string) bool {
+	p.segments = p.segments[:0]
+	for {
+		i := strings.Index(key, p.config.Separator)
+		if i < 0 {
+			break
+		}
+		p.segments = append(p.segments, key[:i])
+		key = key[i+len(p.config.Separator):]
+	}
+	p.segments = append(p.segments, key)
+
+	for _, segment := range p.segments {
+		if segment == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// unflatten puts the value to the path of the segments, it returns false on the conflict which isn't overwritten.
+func (p *Plugin) unflatten(root *insaneJSON.Root, node, value *insaneJSON.Node) bool {
+	overwrite := p.config.OnConflict == onConflictOverwrite
+
+	cur := node
+	last := len(p.segments) - 1
+	for i, segment := range p.segments {
+		next := cur.Dig(segment)
+		if next == nil || next.IsNull() && cur.IsArray() {
+			// the null elements are the placeholders of the sparse indexes
+			next = p.addChild(root, cur, segment)
+			if next == nil {
+				return false
+			}
+		} else if i == last || !p.isContainerFor(next, p.segments[i+1]) {
+			if !overwrite {
+				return false
+			}
+		}
+
+		if i == last {
+			next.MutateToNode(value)
+			return true
+		}
+
+		if !p.isContainerFor(next, p.segments[i+1]) {
+			p.mutateToContainer(next, p.segments[i+1])
+		}
+		cur = next
+	}
+
+	return true
+}
+
+// addChild adds the null node by the segment to the container, it returns nil if it can't be added.
+func (p *Plugin) addChild(root *insaneJSON.Root, container *insaneJSON.Node, segment string) *insaneJSON.Node {
+	if container.IsObject() {
+		return container.AddFieldNoAlloc(root, segment)
+	}
+
+	index, ok := p.arrayIndex(segment)
+	if !ok {
+		return nil
+	}
+	for len(container.AsArray()) <= index {
+		container.AddElementNoAlloc(root)
+	}
+	return container.AsArray()[index]
+}
+
+func (p *Plugin) isContainerFor(node *insaneJSON.Node, segment string) bool {
+	if node.IsObject() {
+		return true
+	}
+	_, ok := p.arrayIndex(segment)
+	return ok && node.IsArray()
+}
+
+func (p *Plugin) mutateToContainer(node *insaneJSON.Node, segment string) {
+	if _, ok := p.arrayIndex(segment); ok {
+		node.MutateToArray()
+		return
+	}
+	node.MutateToObject()
+}
+
+func (p *Plugin) arrayIndex(segment string) (int, bool) {
+	if !p.config.IndexArrays {
+		return 0, false
+	}
+	index, err := strconv.Atoi(segment)
+	if err != nil || index < 0 || index > maxArrayIndex {
+		return 0, false
+	}
+	return index, true
+}
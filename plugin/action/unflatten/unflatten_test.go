@@ -0,0 +1,84 @@
+package unflatten
+
+import (
+	"testing"
+
+	"github.com/ozontech/file.d/pipeline"
+	"github.com/ozontech/file.d/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	insaneJSON "github.com/vitkovskii/insane-json"
+)
+
+func TestUnflatten(t *testing.T) {
+	cases := []struct {
+		name   string
+		config *Config
+		in     string
+		out    string
+	}{
+		{
+			name:   "nested",
+			config: &Config{},
+			in:     `{"a.b.c":1,"a.b.d":"x","a.e":true,"f":"g"}`,
+			out:    `{"a":{"b":{"c":1,"d":"x"},"e":true},"f":"g"}`,
+		},
+		{
+			name:   "separator",
+			config: &Config{Separator: "__"},
+			in:     `{"a__b":1,"c.d":2,"e__":3}`,
+			out:    `{"a":{"b":1},"c.d":2,"e__":3}`,
+		},
+		{
+			name:   "field",
+			config: &Config{Field: "meta"},
+			in:     `{"a.b":1,"meta":{"c.d":2}}`,
+			out:    `{"a.b":1,"meta":{"c":{"d":2}}}`,
+		},
+		{
+			name:   "arrays",
+			config: &Config{IndexArrays: true},
+			in:     `{"items.1.id":2,"items.0.id":1,"tags.0":"x","big.5000":1}`,
+			out:    `{"items":[{"id":1},{"id":2}],"big":{"5000":1},"tags":["x"]}`,
+		},
+		{
+			name:   "arrays_disabled",
+			config: &Config{},
+			in:     `{"tags.0":"x"}`,
+			out:    `{"tags":{"0":"x"}}`,
+		},
+		{
+			name:   "conflict_skip",
+			config: &Config{OnConflict: onConflictSkip},
+			in:     `{"a":"b","a.c":1,"d":{"e":1},"d.e":2,"d.f":3}`,
+			out:    `{"a":"b","a.c":1,"d":{"e":1,"f":3},"d.e":2}`,
+		},
+		{
+			name:   "conflict_overwrite",
+			config: &Config{OnConflict: onConflictOverwrite},
+			in:     `{"a":"b","a.c":1,"d":{"e":1},"d.e":2}`,
+			out:    `{"a":{"c":1},"d":{"e":2}}`,
+		},
+		{
+			name:   "conflict_drop",
+			config: &Config{OnConflict: onConflictDrop},
+			in:     `{"a":"b","a.c":1,"d":"e"}`,
+			out:    `{"a":"b","d":"e"}`,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			test.NewConfig(tt.config, nil)
+			p := &Plugin{}
+			p.Start(tt.config, test.NewEmptyActionPluginParams())
+
+			root, err := insaneJSON.DecodeString(tt.in)
+			require.NoError(t, err)
+			defer insaneJSON.Release(root)
+
+			p.Do(&pipeline.Event{Root: root})
+			assert.Equal(t, tt.out, root.EncodeToString())
+		})
+	}
+}
@@ -0,0 +1,197 @@
+package validate
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/ozontech/file.d/cfg"
+	"github.com/ozontech/file.d/fd"
+	"github.com/ozontech/file.d/metric"
+	"github.com/ozontech/file.d/pipeline"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/santhosh-tekuri/jsonschema/v5"
+	"go.uber.org/zap"
+)
+
+/*{ introduction
+It validates the event against the JSON Schema (draft-07 by default, another draft can be set by `$schema`).
+The schema is compiled once on start. Valid events are passed as is, invalid ones are discarded or tagged according to `on_failure`.
+
+The tag is the error message prefixed by the JSON pointer of the offending field, e.g. `/user/id: expected integer, but got string`.
+File.d pipelines have a single output, so to quarantine invalid events set `route_field` and use it in the output,
+e.g. as the topic of the `kafka` output with `use_topic_field`.
+
+**Example:**
+```yaml
+pipelines:
+  example_pipeline:
+    ...
+    actions:
+    - type: validate
+      schema_file: /etc/file.d/schemas/event.json
+      on_failure: tag
+      error_field: validation_error
+      route_field: topic
+      route_value: quarantine
+    ...
+    output:
+      type: kafka
+      default_topic: events
+      use_topic_field: true
+      topic_field: topic
+    ...
+```
+}*/
+
+const (
+	onFailureDiscard = "discard"
+
+	schemaResource = "schema.json"
+)
+
+type Plugin struct {
+	config *Config
+	logger *zap.SugaredLogger
+	schema *jsonschema.Schema
+	buf    []byte
+
+	// plugin metrics
+	invalidEventsMetric *prometheus.CounterVec
+}
+
+// ! config-params
+// ^ config-params
+type Config struct {
+	// > @3@4@5@6
+	// >
+	// > The path to the JSON Schema file. Either `schema_file` or `schema` should be set.
+	SchemaFile string `json:"schema_file"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The inline JSON Schema. Either `schema_file` or `schema` should be set.
+	Schema string `json:"schema"` // *
+
+	// > @3@4@5@6
+	// >
+	// > What to do with the invalid event:
+	// > * `tag` – put the error to `error_field` and `route_value` to `route_field`, then pass the event
+	// > * `discard` – drop the event
+	OnFailure string `json:"on_failure" default:"tag" options:"tag|discard"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The field to put the validation error to. The error contains the path of the offending field.
+	ErrorField  cfg.FieldSelector `json:"error_field" default:"validation_error" parse:"selector"` // *
+	ErrorField_ []string
+
+	// > @3@4@5@6
+	// >
+	// > The field to put `route_value` to, if it's empty nothing is put.
+	// > It's used to route invalid events to another destination of the output, e.g. the kafka topic.
+	RouteField  cfg.FieldSelector `json:"route_field" parse:"selector"` // *
+	RouteField_ []string
+
+	// > @3@4@5@6
+	// >
+	// > The value of `route_field` for invalid events.
+	RouteValue string `json:"route_value" default:"invalid"` // *
+}
+
+func init() {
+	fd.DefaultPluginRegistry.RegisterAction(&pipeline.PluginStaticInfo{
+		Type:    "validate",
+		Factory: factory,
+	})
+}
+
+func factory() (pipeline.AnyPlugin, pipeline.AnyConfig) {
+	return &Plugin{}, &Config{}
+}
+
+func (p *Plugin) Start(config pipeline.AnyConfig, params *pipeline.ActionPluginParams) {
+	p.config = config.(*Config)
+	p.logger = params.Logger
+	p.registerMetrics(params.MetricCtl)
+
+	schema, err := compileSchema(p.config.SchemaFile, p.config.Schema)
+	if err != nil {
+		p.logger.Fatalf("can't compile json schema: %s", err.Error())
+	}
+	p.schema = schema
+}
+
+func (p *Plugin) registerMetrics(ctl *metric.Ctl) {
+	p.invalidEventsMetric = ctl.RegisterCounter("action_validate_invalid_events_total", "Number of events not matching the schema", "on_failure")
+}
+
+func compileSchema(file string, inline string) (*jsonschema.Schema, error) {
+	if (file == "") == (inline == "") {
+		return nil, errors.New("either schema_file or schema should be set")
+	}
+
+	compiler := jsonschema.NewCompiler()
+	compiler.Draft = jsonschema.Draft7
+	if file != "" {
+		return compiler.Compile(file)
+	}
+
+	if err := compiler.AddResource(schemaResource, strings.NewReader(inline)); err != nil {
+		return nil, err
+	}
+	return compiler.Compile(schemaResource)
+}
+
+func (p *Plugin) Stop() {
+}
+
+func (p *Plugin) Do(event *pipeline.Event) pipeline.ActionResult {
+	p.buf = event.Root.Encode(p.buf[:0])
+
+	decoder := json.NewDecoder(bytes.NewReader(p.buf))
+	decoder.UseNumber()
+	var doc any
+	if err := decoder.Decode(&doc); err != nil {
+		return p.fail(event, "/: "+err.Error())
+	}
+
+	err := p.schema.Validate(doc)
+	if err == nil {
+		return pipeline.ActionPass
+	}
+
+	var validationErr *jsonschema.ValidationError
+	if !errors.As(err, &validationErr) {
+		return p.fail(event, "/: "+err.Error())
+	}
+	return p.fail(event, formatError(validationErr))
+}
+
+func (p *Plugin) fail(event *pipeline.Event, message string) pipeline.ActionResult {
+	p.invalidEventsMetric.WithLabelValues(p.config.OnFailure).Inc()
+	if p.config.OnFailure == onFailureDiscard {
+		return pipeline.ActionDiscard
+	}
+
+	pipeline.CreateNestedField(event.Root, p.config.ErrorField_).MutateToString(message)
+	if len(p.config.RouteField_) != 0 {
+		pipeline.CreateNestedField(event.Root, p.config.RouteField_).MutateToString(p.config.RouteValue)
+	}
+	return pipeline.ActionPass
+}
+
+// formatError returns the first leaf error prefixed by the path of the offending field,
+// the leaf is the most specific one, e.g. the type mismatch of the nested field instead of the root `allOf` failure
+func formatError(err *jsonschema.ValidationError) string {
+	for len(err.Causes) != 0 {
+		err = err.Causes[0]
+	}
+
+	path := err.InstanceLocation
+	if path == "" {
+		path = "/"
+	}
+	return path + ": " + err.Message
+}
@@ -0,0 +1,106 @@
+package validate
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/ozontech/file.d/pipeline"
+	"github.com/ozontech/file.d/test"
+	"github.com/stretchr/testify/require"
+)
+
+const testSchema = `{
+	"type": "object",
+	"required": ["level", "user"],
+	"properties": {
+		"level": {"enum": ["info", "error"]},
+		"user": {
+			"type": "object",
+			"required": ["id"],
+			"properties": {
+				"id": {"type": "integer"}
+			}
+		}
+	}
+}`
+
+func runEvents(t *testing.T, config *Config, events []string, outCount int) []string {
+	test.NewConfig(config, nil)
+	p, input, output := test.NewPipelineMock(test.NewActionPluginStaticInfo(factory, config, pipeline.MatchModeAnd, nil, false))
+
+	wg := &sync.WaitGroup{}
+	wg.Add(outCount)
+	outEvents := make([]string, 0, len(events))
+	output.SetOutFn(func(e *pipeline.Event) {
+		outEvents = append(outEvents, e.Root.EncodeToString())
+		wg.Done()
+	})
+
+	for _, e := range events {
+		input.In(0, "test.log", 0, []byte(e))
+	}
+
+	wg.Wait()
+	p.Stop()
+	return outEvents
+}
+
+func TestValidateTag(t *testing.T) {
+	config := &Config{
+		Schema:     testSchema,
+		ErrorField: "meta.error",
+		RouteField: "meta.topic",
+		RouteValue: "quarantine",
+	}
+	out := runEvents(t, config, []string{
+		`{"level":"info","user":{"id":1}}`,
+		`{"level":"info","user":{"id":"1"}}`,
+		`{"level":"debug","user":{"id":1}}`,
+		`{"level":"error"}`,
+		`{"level":"error","user":{"id":1.5},"meta":{"host":"a"}}`,
+	}, 5)
+
+	require.Equal(t, []string{
+		`{"level":"info","user":{"id":1}}`,
+		`{"level":"info","user":{"id":"1"},"meta":{"error":"/user/id: expected integer, but got string","topic":"quarantine"}}`,
+		`{"level":"debug","user":{"id":1},"meta":{"error":"/level: value must be one of \"info\", \"error\"","topic":"quarantine"}}`,
+		`{"level":"error","meta":{"error":"/: missing properties: 'user'","topic":"quarantine"}}`,
+		`{"level":"error","user":{"id":1.5},"meta":{"host":"a","error":"/user/id: expected integer, but got number","topic":"quarantine"}}`,
+	}, out)
+}
+
+func TestValidateDiscard(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "schema.json")
+	require.NoError(t, os.WriteFile(path, []byte(testSchema), 0o644))
+
+	config := &Config{
+		SchemaFile: path,
+		OnFailure:  "discard",
+	}
+	out := runEvents(t, config, []string{
+		`{"level":"info","user":{"id":1}}`,
+		`{"level":"info","user":{"id":"1"}}`,
+		`{"level":"error","user":{"id":2}}`,
+	}, 2)
+
+	require.Equal(t, []string{
+		`{"level":"info","user":{"id":1}}`,
+		`{"level":"error","user":{"id":2}}`,
+	}, out)
+}
+
+func TestCompileSchema(t *testing.T) {
+	_, err := compileSchema("", "")
+	require.Error(t, err)
+
+	_, err = compileSchema("schema.json", testSchema)
+	require.Error(t, err)
+
+	_, err = compileSchema("", `{"type": "unknown"}`)
+	require.Error(t, err)
+
+	_, err = compileSchema("", testSchema)
+	require.NoError(t, err)
+}
@@ -0,0 +1,119 @@
+package file
+
+import (
+	"github.com/ozontech/file.d/decoder"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	criFullTag      = "F"
+	criTruncatedTag = criFullTag + ":" + decoder.CRITruncatedTag
+)
+
+// criPartialLine is the CRI line of the stream which is being joined from partial chunks
+type criPartialLine struct {
+	stream []byte
+	// header is the time and the stream of the first chunk
+	header []byte
+	log    []byte
+	// isTruncated is set when the line is already passed, so the remaining chunks are skipped
+	isTruncated bool
+}
+
+// criJoiner joins CRI lines split by the container runtime into partial chunks
+type criJoiner struct {
+	maxLineSize int
+	buf         []byte
+
+	joinedMetric    prometheus.Counter
+	truncatedMetric prometheus.Counter
+}
+
+// join returns the line which should be passed to the pipeline, partial chunks are kept in the job until the full one is read.
+// The returned line is valid until the next call.
+func (c *criJoiner) join(job *Job, line []byte) ([]byte, bool) {
+	row, err := decoder.DecodeCRI(line)
+	// the pipeline reports the wrong format
+	if err != nil {
+		return line, true
+	}
+
+	index := -1
+	for i := range job.criLines {
+		if string(job.criLines[i].stream) == string(row.Stream) {
+			index = i
+			break
+		}
+	}
+
+	// most of the lines aren't split
+	if index == -1 && !row.IsPartial {
+		return line, true
+	}
+
+	if index == -1 {
+		index = len(job.criLines)
+		if index < cap(job.criLines) {
+			job.criLines = job.criLines[:index+1]
+		} else {
+			job.criLines = append(job.criLines, criPartialLine{})
+		}
+		partial := &job.criLines[index]
+		partial.stream = append(partial.stream[:0], row.Stream...)
+		partial.header = append(partial.header[:0], line[:len(row.Time)+len(row.Stream)+2]...)
+		partial.log = partial.log[:0]
+		partial.isTruncated = false
+	}
+	partial := &job.criLines[index]
+
+	if partial.isTruncated {
+		if !row.IsPartial {
+			c.release(job, index)
+		}
+		return nil, false
+	}
+
+	partial.log = append(partial.log, row.Log...)
+	size := len(partial.log)
+	// the new line of the full chunk isn't the part of the log
+	if !row.IsPartial && size > 0 && partial.log[size-1] == '\n' {
+		size--
+	}
+	if size > c.maxLineSize {
+		c.truncatedMetric.Inc()
+		out := c.build(partial.header, criTruncatedTag, partial.log[:c.maxLineSize], true)
+		if row.IsPartial {
+			partial.isTruncated = true
+		} else {
+			c.release(job, index)
+		}
+		return out, true
+	}
+
+	if row.IsPartial {
+		return nil, false
+	}
+
+	c.joinedMetric.Inc()
+	out := c.build(partial.header, criFullTag, partial.log, false)
+	c.release(job, index)
+	return out, true
+}
+
+func (c *criJoiner) build(header []byte, tag string, log []byte, shouldAddNewLine bool) []byte {
+	c.buf = append(c.buf[:0], header...)
+	c.buf = append(c.buf, tag...)
+	c.buf = append(c.buf, ' ')
+	c.buf = append(c.buf, log...)
+	if shouldAddNewLine {
+		c.buf = append(c.buf, '\n')
+	}
+	return c.buf
+}
+
+// release removes the joined line of the stream, buffers are kept for the next lines
+func (c *criJoiner) release(job *Job, index int) {
+	last := len(job.criLines) - 1
+	job.criLines[index], job.criLines[last] = job.criLines[last], job.criLines[index]
+	job.criLines = job.criLines[:last]
+}
@@ -0,0 +1,120 @@
+package file
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCRIJoin(t *testing.T) {
+	tests := []struct {
+		name        string
+		maxLineSize int
+		in          []string
+		out         []string
+		joined      float64
+		truncated   float64
+	}{
+		{
+			name:        "full",
+			maxLineSize: 100,
+			in:          []string{"2016-10-06T00:17:09Z stdout F full\n"},
+			out:         []string{"2016-10-06T00:17:09Z stdout F full\n"},
+		},
+		{
+			name:        "partial",
+			maxLineSize: 100,
+			in: []string{
+				"2016-10-06T00:17:09Z stdout P first \n",
+				"2016-10-06T00:17:10Z stdout P second \n",
+				"2016-10-06T00:17:11Z stdout F third\n",
+				"2016-10-06T00:17:12Z stdout F next\n",
+			},
+			out: []string{
+				"2016-10-06T00:17:09Z stdout F first second third\n",
+				"2016-10-06T00:17:12Z stdout F next\n",
+			},
+			joined: 1,
+		},
+		{
+			name:        "streams",
+			maxLineSize: 100,
+			in: []string{
+				"2016-10-06T00:17:09Z stdout P out1 \n",
+				"2016-10-06T00:17:10Z stderr P err1 \n",
+				"2016-10-06T00:17:11Z stderr F err2\n",
+				"2016-10-06T00:17:12Z stdout F out2\n",
+			},
+			out: []string{
+				"2016-10-06T00:17:10Z stderr F err1 err2\n",
+				"2016-10-06T00:17:09Z stdout F out1 out2\n",
+			},
+			joined: 2,
+		},
+		{
+			name:        "truncated",
+			maxLineSize: 8,
+			in: []string{
+				"2016-10-06T00:17:09Z stdout P 12345\n",
+				"2016-10-06T00:17:10Z stdout P 67890\n",
+				"2016-10-06T00:17:11Z stdout P skipped\n",
+				"2016-10-06T00:17:12Z stdout F skipped\n",
+				"2016-10-06T00:17:13Z stdout P 1234\n",
+				"2016-10-06T00:17:14Z stdout F 5678\n",
+			},
+			out: []string{
+				"2016-10-06T00:17:09Z stdout F:truncated 12345678\n",
+				"2016-10-06T00:17:13Z stdout F 12345678\n",
+			},
+			joined:    1,
+			truncated: 1,
+		},
+		{
+			name:        "truncated by full",
+			maxLineSize: 8,
+			in: []string{
+				"2016-10-06T00:17:09Z stdout P 1234\n",
+				"2016-10-06T00:17:10Z stdout F 567890\n",
+				"2016-10-06T00:17:11Z stdout F next\n",
+			},
+			out: []string{
+				"2016-10-06T00:17:09Z stdout F:truncated 12345678\n",
+				"2016-10-06T00:17:11Z stdout F next\n",
+			},
+			truncated: 1,
+		},
+		{
+			name:        "wrong format",
+			maxLineSize: 100,
+			in:          []string{"not cri\n"},
+			out:         []string{"not cri\n"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			joinedMetric := prometheus.NewCounter(prometheus.CounterOpts{Name: "joined"})
+			truncatedMetric := prometheus.NewCounter(prometheus.CounterOpts{Name: "truncated"})
+			c := &criJoiner{
+				maxLineSize:     tt.maxLineSize,
+				joinedMetric:    joinedMetric,
+				truncatedMetric: truncatedMetric,
+			}
+			job := &Job{}
+
+			out := make([]string, 0)
+			for _, line := range tt.in {
+				if joined, ok := c.join(job, []byte(line)); ok {
+					out = append(out, string(joined))
+				}
+			}
+
+			require.Equal(t, tt.out, out)
+			require.Empty(t, job.criLines)
+			require.Equal(t, tt.joined, testutil.ToFloat64(joinedMetric))
+			require.Equal(t, tt.truncated, testutil.ToFloat64(truncatedMetric))
+		})
+	}
+}
@@ -0,0 +1,238 @@
+package file
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type decompression int
+
+const (
+	// ! "decompression" #1 /`([a-z]+)`/
+	decompressionOff  decompression = iota // * `off` – compressed files are read as is
+	decompressionGzip                      // * `gzip` – `gzip` files are decompressed
+	decompressionAll                       // * `all` – `gzip` and `zstd` files are decompressed
+)
+
+type codec int
+
+const (
+	codecNone codec = iota
+	codecGzip
+	codecZstd
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+func (c codec) String() string {
+	switch c {
+	case codecGzip:
+		return "gzip"
+	case codecZstd:
+		return "zstd"
+	default:
+		return "none"
+	}
+}
+
+// detectCodec detects the compression of the file by its extension or magic bytes
+func detectCodec(file *os.File, filename string, mode decompression) codec {
+	if mode == decompressionOff {
+		return codecNone
+	}
+
+	c := codecNone
+	switch filepath.Ext(filename) {
+	case ".gz":
+		c = codecGzip
+	case ".zst":
+		c = codecZstd
+	default:
+		magic := make([]byte, len(zstdMagic))
+		n, _ := file.ReadAt(magic, 0)
+		switch {
+		case bytes.HasPrefix(magic[:n], gzipMagic):
+			c = codecGzip
+		case bytes.HasPrefix(magic[:n], zstdMagic):
+			c = codecZstd
+		}
+	}
+
+	if c == codecZstd && mode != decompressionAll {
+		return codecNone
+	}
+	return c
+}
+
+// decompressor reads the compressed file, its position is the position in the decompressed data.
+// Compressed data can't be read from the middle, so to move to the position
+// the file is decompressed again from the beginning.
+type decompressor struct {
+	codec codec
+	file  *os.File
+	pos   int64
+
+	reader    io.Reader
+	gzip      *gzip.Reader
+	zstd      *zstd.Decoder
+	needReset bool
+	// isEOF is set when the file is decompressed to the end
+	isEOF bool
+	// err is returned by the next read, since the data read along with it is returned first
+	err error
+
+	readBytesMetric prometheus.Counter
+}
+
+func newDecompressor(c codec, file *os.File, readBytesMetric prometheus.Counter) *decompressor {
+	return &decompressor{
+		codec:           c,
+		file:            file,
+		needReset:       true,
+		readBytesMetric: readBytesMetric,
+	}
+}
+
+func (d *decompressor) Read(p []byte) (int, error) {
+	if d.isEOF {
+		return 0, io.EOF
+	}
+
+	if d.err != nil {
+		err := d.err
+		d.err = nil
+		d.setErr(err)
+		return 0, err
+	}
+
+	if d.needReset {
+		if err := d.reset(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := d.reader.Read(p)
+	d.pos += int64(n)
+	if err != nil {
+		if n > 0 {
+			d.err = err
+			return n, nil
+		}
+		d.setErr(err)
+	}
+
+	return n, err
+}
+
+func (d *decompressor) setErr(err error) {
+	if err == io.EOF {
+		d.isEOF = true
+		return
+	}
+	// the decompression can't be continued after the error, e.g. the file isn't completely written yet
+	d.needReset = true
+}
+
+// reset starts decompression from the beginning of the file and skips data up to the position
+func (d *decompressor) reset() error {
+	if _, err := d.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	r := &countingReader{reader: d.file, metric: d.readBytesMetric}
+
+	var err error
+	switch d.codec {
+	case codecGzip:
+		if d.gzip == nil {
+			d.gzip, err = gzip.NewReader(r)
+		} else {
+			err = d.gzip.Reset(r)
+		}
+		d.reader = d.gzip
+	case codecZstd:
+		if d.zstd == nil {
+			d.zstd, err = zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		} else {
+			err = d.zstd.Reset(r)
+		}
+		d.reader = d.zstd
+	}
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.CopyN(io.Discard, d.reader, d.pos); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+
+	d.needReset = false
+	return nil
+}
+
+// restart makes the next read to decompress the file again, it's used when the file has been changed
+func (d *decompressor) restart() {
+	d.isEOF = false
+	d.err = nil
+	d.needReset = true
+}
+
+// seek moves the position in the decompressed data, the file is used for the next read
+func (d *decompressor) seek(file *os.File, offset int64, whence int) (int64, error) {
+	d.file = file
+
+	switch whence {
+	case io.SeekStart:
+		// the file is reopened, but there is nothing to read anyway
+		if d.isEOF && offset == d.pos {
+			break
+		}
+		d.pos = offset
+		d.restart()
+	case io.SeekCurrent:
+		if offset != 0 {
+			d.pos += offset
+			d.restart()
+		}
+	case io.SeekEnd:
+		d.pos = 0
+		d.restart()
+		if _, err := io.Copy(io.Discard, d); err != nil {
+			return d.pos, err
+		}
+		if offset != 0 {
+			d.pos += offset
+			d.restart()
+		}
+	}
+
+	return d.pos, nil
+}
+
+func (d *decompressor) close() {
+	if d.zstd != nil {
+		d.zstd.Close()
+	}
+}
+
+type countingReader struct {
+	reader io.Reader
+	metric prometheus.Counter
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.metric.Add(float64(n))
+	return n, err
+}
@@ -0,0 +1,119 @@
+package file
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+)
+
+func compress(t *testing.T, c codec, data string) []byte {
+	buf := &bytes.Buffer{}
+	var w io.WriteCloser
+	switch c {
+	case codecGzip:
+		w = gzip.NewWriter(buf)
+	case codecZstd:
+		zw, err := zstd.NewWriter(buf)
+		require.NoError(t, err)
+		w = zw
+	}
+	_, err := w.Write([]byte(data))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func writeTempFile(t *testing.T, name string, data []byte) *os.File {
+	filename := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(filename, data, perm))
+	f, err := os.Open(filename)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = f.Close() })
+	return f
+}
+
+func TestDetectCodec(t *testing.T) {
+	gz := compress(t, codecGzip, "data\n")
+	zst := compress(t, codecZstd, "data\n")
+
+	tests := []struct {
+		name string
+		data []byte
+		mode decompression
+		want codec
+	}{
+		{name: "a.log.gz", data: nil, mode: decompressionGzip, want: codecGzip},
+		{name: "a.log.zst", data: nil, mode: decompressionAll, want: codecZstd},
+		{name: "a.log.zst", data: nil, mode: decompressionGzip, want: codecNone},
+		{name: "a.log.1", data: gz, mode: decompressionGzip, want: codecGzip},
+		{name: "a.log.1", data: zst, mode: decompressionAll, want: codecZstd},
+		{name: "a.log", data: []byte("data\n"), mode: decompressionAll, want: codecNone},
+		{name: "a.log.gz", data: gz, mode: decompressionOff, want: codecNone},
+	}
+
+	for _, tt := range tests {
+		f := writeTempFile(t, tt.name, tt.data)
+		require.Equal(t, tt.want, detectCodec(f, f.Name(), tt.mode), "wrong codec of %s", tt.name)
+	}
+}
+
+func TestDecompressor(t *testing.T) {
+	data := "first\nsecond\nthird\n"
+	for _, c := range []codec{codecGzip, codecZstd} {
+		t.Run(c.String(), func(t *testing.T) {
+			f := writeTempFile(t, "log", compress(t, c, data))
+			metric := prometheus.NewCounter(prometheus.CounterOpts{Name: "test"})
+			d := newDecompressor(c, f, metric)
+
+			content, err := io.ReadAll(d)
+			require.NoError(t, err)
+			require.Equal(t, data, string(content))
+
+			pos, err := d.seek(f, 0, io.SeekCurrent)
+			require.NoError(t, err)
+			require.Equal(t, int64(len(data)), pos)
+
+			// the position is in the decompressed data
+			pos, err = d.seek(f, int64(len("first\n")), io.SeekStart)
+			require.NoError(t, err)
+			require.Equal(t, int64(len("first\n")), pos)
+			content, err = io.ReadAll(d)
+			require.NoError(t, err)
+			require.Equal(t, "second\nthird\n", string(content))
+
+			pos, err = d.seek(f, 0, io.SeekEnd)
+			require.NoError(t, err)
+			require.Equal(t, int64(len(data)), pos)
+		})
+	}
+}
+
+func TestDecompressorIncompleteFile(t *testing.T) {
+	data := "first\nsecond\n"
+	gz := compress(t, codecGzip, data)
+	f := writeTempFile(t, "log.gz", gz[:len(gz)-10])
+	d := newDecompressor(codecGzip, f, prometheus.NewCounter(prometheus.CounterOpts{Name: "test"}))
+
+	_, err := io.ReadAll(d)
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	read := d.pos
+
+	// the rest of the file is written
+	w, err := os.OpenFile(f.Name(), os.O_WRONLY|os.O_APPEND, perm)
+	require.NoError(t, err)
+	_, err = w.Write(gz[len(gz)-10:])
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	d.restart()
+	content, err := io.ReadAll(d)
+	require.NoError(t, err)
+	require.Equal(t, data[read:], string(content))
+}
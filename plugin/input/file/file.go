@@ -58,6 +58,10 @@ type Plugin struct {
 
 	possibleOffsetCorruptionMetric    *prometheus.CounterVec
 	alreadyWrittenEventsSkippedMetric *prometheus.CounterVec
+	compressedReadBytesMetric         *prometheus.CounterVec
+	symlinkReResolvesMetric           *prometheus.CounterVec
+	criJoinedLinesMetric              *prometheus.CounterVec
+	criTruncatedLinesMetric           *prometheus.CounterVec
 }
 
 type persistenceMode int
@@ -143,6 +147,47 @@ type Config struct {
 	OffsetsOp  string `json:"offsets_op" default:"continue" options:"continue|tail|reset"` // *
 	OffsetsOp_ offsetsOp
 
+	// > @3@4@5@6
+	// >
+	// > An offset policy for files which don't have saved offsets, i.e. are read for the first time:
+	// > @startOffset|comment-list
+	// > > It isn't used for files on the initial scan if `offsets_op` is `tail` or `reset`, and for compressed files.
+	StartOffset  string `json:"start_offset" default:"begin" options:"begin|last_bytes|last_lines|since_time"` // *
+	StartOffset_ startOffset
+
+	// > @3@4@5@6
+	// >
+	// > The size of data read from the end of the file if `start_offset` is `last_bytes`.
+	StartOffsetBytes  string `json:"start_offset_bytes" default:"1 MB" parse:"data_unit"` // *
+	StartOffsetBytes_ uint
+
+	// > @3@4@5@6
+	// >
+	// > The number of lines read from the end of the file if `start_offset` is `last_lines`.
+	StartOffsetLines int `json:"start_offset_lines" default:"1000"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The time lines are read from if `start_offset` is `since_time`.
+	// > It's either the timestamp in `rfc3339` format, e.g. `2023-10-01T00:00:00Z`,
+	// > or the duration before the file is found, e.g. `1h`.
+	StartOffsetTime      string `json:"start_offset_time" default:"1h"` // *
+	StartOffsetTime_     time.Time
+	StartOffsetDuration_ time.Duration
+
+	// > @3@4@5@6
+	// >
+	// > The field of the JSON line which contains its time. It's used if `start_offset` is `since_time`.
+	StartOffsetTimeField  cfg.FieldSelector `json:"start_offset_time_field" default:"time" parse:"selector"` // *
+	StartOffsetTimeField_ []string
+
+	// > @3@4@5@6
+	// >
+	// > The format of the line time. It's used if `start_offset` is `since_time`.
+	// > It should be one of `ansic|unixdate|rubydate|rfc822|rfc822z|rfc850|rfc1123|rfc1123z|rfc3339|rfc3339nano|kitchen|stamp|stampmilli|stampmicro|stampnano|unixtime` or a custom Go time layout.
+	StartOffsetTimeFormat  string `json:"start_offset_time_format" default:"rfc3339nano"` // *
+	StartOffsetTimeFormat_ string
+
 	// > @3@4@5@6
 	// >
 	// > It defines how many workers will be instantiated.
@@ -170,6 +215,40 @@ type Config struct {
 	// >
 	// > It turns on watching for file modifications. Turning it on cause more CPU work, but it is more probable to catch file truncation
 	ShouldWatchChanges bool `json:"should_watch_file_changes" default:"false"` // *
+
+	// > @3@4@5@6
+	// >
+	// > It turns on following the changes of symlink targets, e.g. in k8s the symlink is re-pointed on the container restart.
+	// > When the target is changed, the previous target is read to the end, then its file is closed and the new target is read from the beginning.
+	// > The symlink which target doesn't exist for a while is resolved again on the next maintenance.
+	// > The target which is already read by its own path or by another symlink isn't read twice.
+	ShouldFollowSymlinkChanges bool `json:"should_follow_symlink_changes" default:"false"` // *
+
+	// > @3@4@5@6
+	// >
+	// > It defines which compressed files are decompressed while reading:
+	// > @decompression|comment-list
+	// >
+	// > Compressed files are detected by `.gz`/`.zst` extensions or by magic bytes.
+	// > They are expected to be written once, e.g. by the log rotation, so they are read to the end and aren't watched for appends.
+	// > > Offsets of compressed files are positions in the decompressed data,
+	// > > so to continue reading after restart the file is decompressed again from the beginning up to the offset.
+	Decompression  string `json:"decompression" default:"off" options:"off|gzip|all"` // *
+	Decompression_ decompression
+
+	// > @3@4@5@6
+	// >
+	// > It turns on joining of CRI lines which are split by the container runtime into partial (`P`) chunks followed by the full (`F`) one.
+	// > The chunks are joined before decoding, so the pipeline gets a single full line. Chunks of different streams are joined separately.
+	// > > It's turned on by the [k8s plugin](/plugin/input/k8s/README.md) for CRI runtimes.
+	ShouldJoinCRILines bool `json:"should_join_cri_lines" default:"false"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The max size of the log of the joined CRI line. If it's exceeded, the joined line is passed as is with the `truncated` tag,
+	// > the CRI decoder adds the `truncated` field to such an event. The remaining chunks of the line are skipped.
+	CRIMaxLineSize  string `json:"cri_max_line_size" default:"1 MB" parse:"data_unit"` // *
+	CRIMaxLineSize_ uint
 }
 
 func init() {
@@ -193,8 +272,9 @@ func (p *Plugin) Start(config pipeline.AnyConfig, params *pipeline.InputPluginPa
 	p.registerMetrics(params.MetricCtl)
 
 	p.config.OffsetsFileTmp = p.config.OffsetsFile + ".atomic"
+	p.parseStartOffset()
 
-	p.jobProvider = NewJobProvider(p.config, p.possibleOffsetCorruptionMetric, p.logger)
+	p.jobProvider = NewJobProvider(p.config, p.possibleOffsetCorruptionMetric, p.compressedReadBytesMetric, p.symlinkReResolvesMetric, p.logger)
 
 	ResetterRegistryInstance.AddResetter(params.PipelineName, p)
 
@@ -202,16 +282,51 @@ func (p *Plugin) Start(config pipeline.AnyConfig, params *pipeline.InputPluginPa
 	p.jobProvider.start()
 }
 
+func (p *Plugin) parseStartOffset() {
+	switch p.config.StartOffset_ {
+	case startOffsetLastLines:
+		if p.config.StartOffsetLines < 1 {
+			p.logger.Fatalf("start_offset_lines should be at least 1")
+		}
+	case startOffsetSinceTime:
+		if d, err := time.ParseDuration(p.config.StartOffsetTime); err == nil {
+			p.config.StartOffsetDuration_ = d
+		} else if t, err := time.Parse(time.RFC3339Nano, p.config.StartOffsetTime); err == nil {
+			p.config.StartOffsetTime_ = t
+		} else {
+			p.logger.Fatalf("start_offset_time %q should be a duration or a timestamp in rfc3339 format", p.config.StartOffsetTime)
+		}
+
+		format, err := pipeline.ParseFormatName(p.config.StartOffsetTimeFormat)
+		if err != nil {
+			format = p.config.StartOffsetTimeFormat
+		}
+		p.config.StartOffsetTimeFormat_ = format
+	}
+}
+
 func (p *Plugin) registerMetrics(ctl *metric.Ctl) {
 	p.possibleOffsetCorruptionMetric = ctl.RegisterCounter("input_file_possible_offset_corruptions_total", "Total number of possible offset corruptions")
 	p.alreadyWrittenEventsSkippedMetric = ctl.RegisterCounter("input_file_already_written_event_skipped_total", "Total number of skipped events that was already written")
+	p.compressedReadBytesMetric = ctl.RegisterCounter("input_file_compressed_read_bytes_total", "Total number of bytes read from compressed files", "codec")
+	p.symlinkReResolvesMetric = ctl.RegisterCounter("input_file_symlink_re_resolves_total", "Total number of symlink target changes")
+	p.criJoinedLinesMetric = ctl.RegisterCounter("input_file_cri_joined_lines_total", "Total number of CRI lines joined from partial chunks")
+	p.criTruncatedLinesMetric = ctl.RegisterCounter("input_file_cri_truncated_lines_total", "Total number of joined CRI lines truncated due to cri_max_line_size")
 }
 
 func (p *Plugin) startWorkers() {
 	p.workers = make([]*worker, p.config.WorkersCount_)
 	for i := range p.workers {
 		p.workers[i] = &worker{
-			maxEventSize: p.params.PipelineSettings.MaxEventSize,
+			maxEventSize:   p.params.PipelineSettings.MaxEventSize,
+			passLongEvents: p.params.PipelineSettings.MaxEventSizePolicy != pipeline.MaxEventSizeDrop,
+		}
+		if p.config.ShouldJoinCRILines {
+			p.workers[i].criJoiner = &criJoiner{
+				maxLineSize:     int(p.config.CRIMaxLineSize_),
+				joinedMetric:    p.criJoinedLinesMetric.WithLabelValues(),
+				truncatedMetric: p.criTruncatedLinesMetric.WithLabelValues(),
+			}
 		}
 		p.workers[i].start(p.params.Controller, p.jobProvider, p.config.ReadBufferSize, p.logger)
 	}
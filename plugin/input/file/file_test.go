@@ -80,12 +80,24 @@ func pluginConfig(opts ...string) *Config {
 		op = "reset"
 	}
 
+	decompression := ""
+	if test.Opts(opts).Has("decompress") {
+		decompression = "all"
+	}
+
 	config := &Config{
 		WatchingDir:         filesDir,
 		OffsetsFile:         filepath.Join(offsetsDir, offsetsFile),
 		PersistenceMode:     "async",
 		OffsetsOp:           op,
 		MaintenanceInterval: "5s",
+		Decompression:       decompression,
+
+		ShouldFollowSymlinkChanges: test.Opts(opts).Has("follow_symlinks"),
+	}
+	if test.Opts(opts).Has("last_lines") {
+		config.StartOffset = "last_lines"
+		config.StartOffsetLines = 2
 	}
 
 	_ = cfg.Parse(config, map[string]int{"gomaxprocs": runtime.GOMAXPROCS(0)})
@@ -481,6 +493,143 @@ func TestReadContinue(t *testing.T) {
 	}, blockSize+blockSize-processed, "dirty")
 }
 
+// TestReadCompressedContinue tests if compressed file reading works right after restart of the pipeline
+func TestReadCompressedContinue(t *testing.T) {
+	blockSize := 2000
+	stopAfter := 100
+	inputEvents := make([]string, 0)
+	outputEvents := make([]string, 0)
+	file := ""
+
+	run(&test.Case{
+		Prepare: func() {
+		},
+		Act: func(p *pipeline.Pipeline) {
+			content := strings.Builder{}
+			for x := 0; x < blockSize; x++ {
+				line := fmt.Sprintf(`{"data":"line_%d"}`, x)
+				inputEvents = append(inputEvents, line)
+				content.WriteString(line + "\n")
+			}
+
+			// the file is written outside the watching dir, it's moved there as the rotation does
+			tmp := filepath.Join(offsetsDir, "rotated.log.gz")
+			require.NoError(t, os.WriteFile(tmp, compress(t, codecGzip, content.String()), perm))
+			file = filepath.Join(filesDir, "rotated.log.gz")
+			renameFile(tmp, file)
+		},
+		Assert: func(p *pipeline.Pipeline) {
+			for i := 0; i < p.GetEventsTotal(); i++ {
+				outputEvents = append(outputEvents, p.GetEventLogItem(i))
+			}
+		},
+	}, stopAfter, "decompress")
+
+	size := 0
+	run(&test.Case{
+		Prepare: func() {
+		},
+		Act: func(p *pipeline.Pipeline) {
+		},
+		Assert: func(p *pipeline.Pipeline) {
+			for i := 0; i < p.GetEventsTotal(); i++ {
+				outputEvents = append(outputEvents, p.GetEventLogItem(i))
+			}
+
+			require.Equal(t, inputEvents, outputEvents, "wrong events")
+			for _, e := range inputEvents {
+				size += len(e) + newLine
+			}
+			assertOffsetsAreEqual(t, genOffsetsContent(file, size), getContent(getConfigByPipeline(p).OffsetsFile))
+		},
+	}, blockSize-len(outputEvents), "dirty", "decompress")
+}
+
+// TestSymlinkTargetChange tests if the new target is read after the symlink is re-pointed
+func TestSymlinkTargetChange(t *testing.T) {
+	x := atomic.NewInt32(2)
+	targetsDir := t.TempDir()
+	first := filepath.Join(targetsDir, "first.log")
+	second := filepath.Join(targetsDir, "second.log")
+	symlink := ""
+
+	run(&test.Case{
+		Prepare: func() {
+			createFile(first)
+			addString(first, `"first_1"`, true, false)
+			addString(first, `"first_2"`, true, true)
+		},
+		Act: func(p *pipeline.Pipeline) {
+			symlink = filepath.Join(filesDir, "0.log")
+			require.NoError(t, os.Symlink(first, symlink))
+			test.WaitForEvents(x)
+
+			createFile(second)
+			addString(second, `"second_1"`, true, true)
+
+			// the symlink is re-pointed atomically as the container runtime does
+			tmp := filepath.Join(targetsDir, "0.log.tmp")
+			require.NoError(t, os.Symlink(second, tmp))
+			renameFile(tmp, symlink)
+		},
+		Assert: func(p *pipeline.Pipeline) {
+			require.Equal(t, 3, p.GetEventsTotal(), "wrong events count")
+			require.Equal(t, []string{`"first_1"`, `"first_2"`, `"second_1"`},
+				[]string{p.GetEventLogItem(0), p.GetEventLogItem(1), p.GetEventLogItem(2)})
+
+			// the job of the previous target is released
+			jp := p.GetInput().(*Plugin).jobProvider
+			jp.jobsMu.RLock()
+			defer jp.jobsMu.RUnlock()
+			require.Len(t, jp.jobs, 1)
+			for _, job := range jp.jobs {
+				require.Equal(t, second, job.filename)
+				require.Equal(t, symlink, job.symlink)
+			}
+		},
+		Out: func(event *pipeline.Event) {
+			x.Dec()
+		},
+	}, 3, "follow_symlinks")
+}
+
+// TestSymlinkToTrackedFile tests if the file isn't read twice by its own path and by the symlink
+func TestSymlinkToTrackedFile(t *testing.T) {
+	run(&test.Case{
+		Prepare: func() {},
+		Act: func(p *pipeline.Pipeline) {
+			file := createTempFile()
+			addString(file, `"line_1"`, true, true)
+			require.NoError(t, os.Symlink(file, filepath.Join(filesDir, "link.log")))
+			addString(file, `"line_2"`, true, true)
+
+			time.Sleep(500 * time.Millisecond)
+		},
+		Assert: func(p *pipeline.Pipeline) {
+			require.Equal(t, 2, p.GetEventsTotal(), "wrong events count")
+		},
+	}, 2, "follow_symlinks")
+}
+
+// TestReadStartOffsetLastLines tests if only last lines of the found file are read
+func TestReadStartOffsetLastLines(t *testing.T) {
+	run(&test.Case{
+		Prepare: func() {},
+		Act: func(p *pipeline.Pipeline) {
+			tmp := filepath.Join(offsetsDir, "existing.log")
+			createFile(tmp)
+			for i := 0; i < 5; i++ {
+				addString(tmp, fmt.Sprintf(`"line_%d"`, i), true, false)
+			}
+			renameFile(tmp, filepath.Join(filesDir, "existing.log"))
+		},
+		Assert: func(p *pipeline.Pipeline) {
+			require.Equal(t, 2, p.GetEventsTotal(), "wrong events count")
+			require.Equal(t, []string{`"line_3"`, `"line_4"`}, []string{p.GetEventLogItem(0), p.GetEventLogItem(1)})
+		},
+	}, 2, "last_lines")
+}
+
 // TestOffsetsSaveSimple tests if offsets saving works right in the simple case
 func TestOffsetsSaveSimple(t *testing.T) {
 	eventCount := 5
@@ -13,6 +13,7 @@ import (
 	"github.com/ozontech/file.d/pipeline"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/rjeczalik/notify"
+	insaneJSON "github.com/vitkovskii/insane-json"
 	"go.uber.org/atomic"
 	"go.uber.org/zap"
 )
@@ -39,6 +40,10 @@ type jobProvider struct {
 
 	symlinks   map[inodeID]string
 	symlinksMu *sync.Mutex
+	// symlinkTargets holds current targets by the symlink, it's used only if symlink changes are followed
+	symlinkTargets map[string]symlinkTarget
+	// symlinkByTarget holds symlinks by the target inode to not read the target twice
+	symlinkByTarget map[inodeID]string
 
 	jobsDone *atomic.Int32
 
@@ -56,6 +61,8 @@ type jobProvider struct {
 	// provider metrics
 
 	possibleOffsetCorruptionMetric *prometheus.CounterVec
+	compressedReadBytesMetric      *prometheus.CounterVec
+	symlinkReResolvesMetric        *prometheus.CounterVec
 }
 
 type Job struct {
@@ -67,6 +74,14 @@ type Job struct {
 	curOffset int64  // offset to not call Seek() everytime
 	tail      []byte // some data of a new line read by worker, to not seek backwards to read from line start
 
+	// criLines are CRI lines which partial chunks are being joined, see should_join_cri_lines
+	criLines []criPartialLine
+
+	// decompressor is set if the file is compressed, then offsets are positions in the decompressed data
+	decompressor *decompressor
+	// compressedSize is the size of the compressed file read to the end
+	compressedSize int64
+
 	ignoreEventsLE uint64 // events with seq id less or equal than this should be ignored in terms offset commitment
 	lastEventSeq   uint64
 
@@ -83,7 +98,13 @@ type Job struct {
 }
 
 func (j *Job) seek(offset int64, whence int, hint string) int64 {
-	n, err := j.file.Seek(offset, whence)
+	var n int64
+	var err error
+	if j.decompressor != nil {
+		n, err = j.decompressor.seek(j.file, offset, whence)
+	} else {
+		n, err = j.file.Seek(offset, whence)
+	}
 	if err != nil {
 		logger.Infof("file seek error hint=%s, name=%s, err=%s", hint, j.filename, err.Error())
 	}
@@ -92,6 +113,13 @@ func (j *Job) seek(offset int64, whence int, hint string) int64 {
 	return n
 }
 
+func (j *Job) reader() io.Reader {
+	if j.decompressor != nil {
+		return j.decompressor
+	}
+	return j.file
+}
+
 type inodeID uint64
 
 type symlinkInfo struct {
@@ -99,7 +127,17 @@ type symlinkInfo struct {
 	inode    inodeID
 }
 
-func NewJobProvider(config *Config, possibleOffsetCorruptionMetric *prometheus.CounterVec, sugLogger *zap.SugaredLogger) *jobProvider {
+type symlinkTarget struct {
+	filename string
+	inode    inodeID
+	sourceID pipeline.SourceID
+}
+
+func NewJobProvider(
+	config *Config,
+	possibleOffsetCorruptionMetric, compressedReadBytesMetric, symlinkReResolvesMetric *prometheus.CounterVec,
+	sugLogger *zap.SugaredLogger,
+) *jobProvider {
 	jp := &jobProvider{
 		config:   config,
 		offsetDB: newOffsetDB(config.OffsetsFile, config.OffsetsFileTmp),
@@ -110,8 +148,10 @@ func NewJobProvider(config *Config, possibleOffsetCorruptionMetric *prometheus.C
 		jobsChan: make(chan *Job, config.MaxFiles),
 		jobsLog:  make([]string, 0, 16),
 
-		symlinks:   make(map[inodeID]string),
-		symlinksMu: &sync.Mutex{},
+		symlinks:        make(map[inodeID]string),
+		symlinksMu:      &sync.Mutex{},
+		symlinkTargets:  make(map[string]symlinkTarget),
+		symlinkByTarget: make(map[inodeID]string),
 
 		offsetsCommitted: &atomic.Int64{},
 
@@ -121,6 +161,8 @@ func NewJobProvider(config *Config, possibleOffsetCorruptionMetric *prometheus.C
 
 		logger:                         sugLogger,
 		possibleOffsetCorruptionMetric: possibleOffsetCorruptionMetric,
+		compressedReadBytesMetric:      compressedReadBytesMetric,
+		symlinkReResolvesMetric:        symlinkReResolvesMetric,
 	}
 
 	jp.watcher = NewWatcher(
@@ -229,6 +271,11 @@ func (jp *jobProvider) processNotification(e notify.Event, filename string, stat
 		return
 	}
 
+	// the file is already read by the symlink
+	if jp.config.ShouldFollowSymlinkChanges && jp.isSymlinkTarget(getInode(stat)) {
+		return
+	}
+
 	jp.refreshFile(stat, filename, "", isWrite)
 }
 
@@ -241,6 +288,12 @@ func (jp *jobProvider) addSymlink(inode inodeID, filename string) {
 func (jp *jobProvider) refreshSymlink(symlink string, inode inodeID, isWrite bool) {
 	filename, err := filepath.EvalSymlinks(symlink)
 	if err != nil {
+		// the symlink may be dangling for a while during the rotation, so it's resolved again later
+		if _, lstatErr := os.Lstat(symlink); lstatErr == nil && jp.config.ShouldFollowSymlinkChanges {
+			jp.logger.Infof("symlink %s is dangling: %s", symlink, err.Error())
+			return
+		}
+
 		jp.logger.Warnf("symlink have been removed %s", symlink)
 
 		jp.symlinksMu.Lock()
@@ -261,9 +314,87 @@ func (jp *jobProvider) refreshSymlink(symlink string, inode inodeID, isWrite boo
 		return
 	}
 
+	if jp.config.ShouldFollowSymlinkChanges && !jp.resolveSymlinkTarget(symlink, filename, stat) {
+		return
+	}
+
 	jp.refreshFile(stat, filename, symlink, isWrite)
 }
 
+// resolveSymlinkTarget remembers the current target of the symlink and releases the job of the previous one.
+// It returns false if the target shouldn't be read by the symlink.
+func (jp *jobProvider) resolveSymlinkTarget(symlink string, filename string, stat os.FileInfo) bool {
+	inode := getInode(stat)
+	sourceID := sourceIDByStat(stat, symlink)
+
+	jp.symlinksMu.Lock()
+	defer jp.symlinksMu.Unlock()
+
+	// the target is already read by another symlink or by its own path
+	if other, has := jp.symlinkByTarget[inode]; has && other != symlink {
+		return false
+	}
+	jp.jobsMu.RLock()
+	_, has := jp.jobs[sourceIDByStat(stat, "")]
+	jp.jobsMu.RUnlock()
+	if has {
+		return false
+	}
+
+	prev, has := jp.symlinkTargets[symlink]
+	if has && prev.sourceID != sourceID {
+		// the previous target is read to the end before the job is released, so try again later
+		if !jp.releaseJob(prev.sourceID) {
+			return false
+		}
+
+		delete(jp.symlinkByTarget, prev.inode)
+		jp.symlinkReResolvesMetric.WithLabelValues().Inc()
+		jp.logger.Infof("symlink %s target is changed from %s to %s", symlink, prev.filename, filename)
+	}
+
+	jp.symlinkTargets[symlink] = symlinkTarget{
+		filename: filename,
+		inode:    inode,
+		sourceID: sourceID,
+	}
+	jp.symlinkByTarget[inode] = symlink
+
+	return true
+}
+
+func (jp *jobProvider) isSymlinkTarget(inode inodeID) bool {
+	jp.symlinksMu.Lock()
+	defer jp.symlinksMu.Unlock()
+
+	_, has := jp.symlinkByTarget[inode]
+	return has
+}
+
+// releaseJob closes the file and deletes the job if it's done, it returns false if the job isn't done.
+func (jp *jobProvider) releaseJob(sourceID pipeline.SourceID) bool {
+	jp.jobsMu.RLock()
+	job, has := jp.jobs[sourceID]
+	jp.jobsMu.RUnlock()
+
+	if !has {
+		return true
+	}
+
+	job.mu.Lock()
+	if !job.isDone {
+		job.mu.Unlock()
+		return false
+	}
+
+	if err := job.file.Close(); err != nil {
+		jp.logger.Errorf("can't close file %s: %s", job.filename, err.Error())
+	}
+	jp.deleteJobAndUnlock(job)
+
+	return true
+}
+
 func (jp *jobProvider) refreshFile(stat os.FileInfo, filename string, symlink string, isWrite bool) {
 	sourceID := sourceIDByStat(stat, symlink)
 	jp.jobsMu.RLock()
@@ -289,6 +420,11 @@ func (jp *jobProvider) refreshFile(stat os.FileInfo, filename string, symlink st
 }
 
 func (jp *jobProvider) checkFileWasTruncated(job *Job, size int64) {
+	// offsets of compressed files can't be compared with the file size
+	if job.decompressor != nil {
+		return
+	}
+
 	lastOffset := job.seek(0, io.SeekCurrent, "check file truncation")
 
 	if lastOffset > size {
@@ -328,12 +464,17 @@ func (jp *jobProvider) addJob(file *os.File, stat os.FileInfo, filename string,
 		mu: &sync.Mutex{},
 	}
 
+	if c := detectCodec(file, filename, jp.config.Decompression_); c != codecNone {
+		job.decompressor = newDecompressor(c, file, jp.compressedReadBytesMetric.WithLabelValues(c.String()))
+		jp.logger.Infof("file %s is compressed by %s, it will be decompressed", filename, c)
+	}
+
 	// set curOffset
 	job.seek(0, io.SeekCurrent, "add job")
 
 	// load saved offsets only on start phase
 	if jp.isStarted.Load() {
-		jp.initJobOffset(offsetsOpReset, job)
+		jp.initStartOffset(job)
 	} else {
 		jp.initJobOffset(jp.config.OffsetsOp_, job)
 	}
@@ -373,7 +514,8 @@ func (jp *jobProvider) initJobOffset(operation offsetsOp, job *Job) {
 	case offsetsOpTail:
 		offset := job.seek(0, io.SeekEnd, "job initialization")
 
-		if offset == 0 {
+		// compressed files are completely written, so the end is the end of the line
+		if offset == 0 || job.decompressor != nil {
 			return
 		}
 
@@ -391,7 +533,7 @@ func (jp *jobProvider) initJobOffset(operation offsetsOp, job *Job) {
 			jp.logger.Panicf("can't instantiate job, no streams in source %d:%q", job.sourceID, job.filename)
 		}
 		if !has {
-			job.seek(0, io.SeekStart, "job initialization")
+			jp.initStartOffset(job)
 			return
 		}
 
@@ -409,6 +551,63 @@ func (jp *jobProvider) initJobOffset(operation offsetsOp, job *Job) {
 	}
 }
 
+// initStartOffset sets the offset of the file which is read for the first time according to start_offset.
+func (jp *jobProvider) initStartOffset(job *Job) {
+	if jp.config.StartOffset_ == startOffsetBegin || job.decompressor != nil {
+		job.seek(0, io.SeekStart, "job initialization")
+		return
+	}
+
+	stat, err := job.file.Stat()
+	if err != nil {
+		jp.logger.Errorf("can't stat file %s, it will be read from the beginning: %s", job.filename, err.Error())
+		job.seek(0, io.SeekStart, "job initialization")
+		return
+	}
+	size := stat.Size()
+
+	offset := int64(0)
+	buf := make([]byte, startOffsetBufferSize)
+	switch jp.config.StartOffset_ {
+	case startOffsetLastBytes:
+		if n := int64(jp.config.StartOffsetBytes_); size > n {
+			// the data starts in the middle of the line, so skip data to the next line,
+			// the previous byte is read too, so the line isn't skipped if the data starts at the line beginning
+			offset = size - n - 1
+			job.shouldSkip.Store(true)
+		}
+	case startOffsetLastLines:
+		offset, err = lastLinesOffset(job.file, size, jp.config.StartOffsetLines, buf)
+	case startOffsetSinceTime:
+		since := jp.config.StartOffsetTime_
+		if jp.config.StartOffsetDuration_ != 0 {
+			since = time.Now().Add(-jp.config.StartOffsetDuration_)
+		}
+
+		root := insaneJSON.Spawn()
+		offset, err = sinceTimeOffset(job.file, size, since, func(line []byte) (time.Time, bool) {
+			if root.DecodeBytes(line) != nil {
+				return time.Time{}, false
+			}
+			node := root.Dig(jp.config.StartOffsetTimeField_...)
+			if node == nil {
+				return time.Time{}, false
+			}
+			t, err := pipeline.ParseTime(jp.config.StartOffsetTimeFormat_, node.AsString())
+			return t, err == nil
+		}, buf)
+		insaneJSON.Release(root)
+	}
+	if err != nil {
+		jp.logger.Errorf("can't find start offset of file %s, it will be read from the beginning: %s", job.filename, err.Error())
+		offset = 0
+		job.shouldSkip.Store(false)
+	}
+
+	jp.logger.Infof("file %s is read for the first time, start offset=%d, size=%d", job.filename, offset, size)
+	job.seek(offset, io.SeekStart, "job initialization")
+}
+
 // tryResumeJob job should be already locked and it'll be unlocked.
 func (jp *jobProvider) tryResumeJobAndUnlock(job *Job, filename string) {
 	jp.logger.Debugf("job for %d:%s resumed", job.sourceID, job.filename)
@@ -602,7 +801,15 @@ func (jp *jobProvider) maintenanceJob(job *Job) int {
 
 	offset := job.seek(0, io.SeekCurrent, "maintenance")
 
-	if stat.Size() != offset {
+	// compressed files are resumed only if they have been changed, e.g. they weren't completely written
+	if job.decompressor != nil && stat.Size() != job.compressedSize {
+		job.decompressor.restart()
+		jp.tryResumeJobAndUnlock(job, filename)
+
+		return maintenanceResultResumed
+	}
+
+	if job.decompressor == nil && stat.Size() != offset {
 		jp.tryResumeJobAndUnlock(job, filename)
 
 		return maintenanceResultResumed
@@ -664,6 +871,9 @@ func (jp *jobProvider) deleteJobAndUnlock(job *Job) {
 	}
 	sourceID := job.sourceID
 	filename := job.filename
+	if job.decompressor != nil {
+		job.decompressor.close()
+	}
 	job.mu.Unlock()
 
 	jp.jobsMu.Lock()
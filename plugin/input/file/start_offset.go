@@ -0,0 +1,139 @@
+package file
+
+import (
+	"bytes"
+	"io"
+	"time"
+)
+
+type startOffset int
+
+const (
+	// ! "startOffset" #1 /`([a-z_]+)`/
+	startOffsetBegin     startOffset = iota // * `begin` – reads the file from the beginning
+	startOffsetLastBytes                    // * `last_bytes` – reads `start_offset_bytes` from the end of the file, the first incomplete line is skipped
+	startOffsetLastLines                    // * `last_lines` – reads `start_offset_lines` lines from the end of the file
+	startOffsetSinceTime                    // * `since_time` – reads lines starting from the first one which time is after `start_offset_time`
+)
+
+const (
+	// startOffsetBufferSize is the size of the buffer to search the start offset,
+	// longer lines are skipped by the time search
+	startOffsetBufferSize = 64 * 1024
+	// maxSearchSteps bounds the number of lines read by the time search
+	maxSearchSteps = 64
+)
+
+// lastLinesOffset returns the offset of the line which is n-th from the end,
+// the last line may be incomplete
+func lastLinesOffset(r io.ReaderAt, size int64, n int, buf []byte) (int64, error) {
+	pos := size
+	if size > 0 {
+		if _, err := r.ReadAt(buf[:1], size-1); err != nil {
+			return 0, err
+		}
+		// the new line of the last line
+		if buf[0] == '\n' {
+			pos--
+		}
+	}
+
+	count := 0
+	for pos > 0 {
+		chunk := min(int64(len(buf)), pos)
+		if _, err := r.ReadAt(buf[:chunk], pos-chunk); err != nil {
+			return 0, err
+		}
+		for i := chunk - 1; i >= 0; i-- {
+			if buf[i] != '\n' {
+				continue
+			}
+			count++
+			if count == n {
+				return pos - chunk + i + 1, nil
+			}
+		}
+		pos -= chunk
+	}
+
+	return 0, nil
+}
+
+// sinceTimeOffset returns the offset of the first line which time isn't before the since time.
+// Lines are expected to be ordered by time, lines which time can't be parsed are considered as older.
+// The binary search is bounded by maxSearchSteps, if it's exceeded the offset is before the line.
+func sinceTimeOffset(r io.ReaderAt, size int64, since time.Time, lineTime func([]byte) (time.Time, bool), buf []byte) (int64, error) {
+	lo, hi := int64(0), size
+	for step := 0; step < maxSearchSteps && lo < hi; step++ {
+		mid := lo + (hi-lo)/2
+		start, err := nextLineStart(r, size, mid, buf)
+		if err != nil {
+			return 0, err
+		}
+
+		found := false
+		for start < hi && step < maxSearchSteps {
+			line, next, err := readLine(r, size, start, buf)
+			if err != nil {
+				return 0, err
+			}
+			if t, ok := lineTime(line); ok {
+				found = true
+				if t.Before(since) {
+					lo = next
+				} else {
+					hi = mid
+				}
+				break
+			}
+			start = next
+			step++
+		}
+		if !found {
+			hi = mid
+		}
+	}
+
+	return nextLineStart(r, size, lo, buf)
+}
+
+// nextLineStart returns the offset of the first line which starts at the pos or after it
+func nextLineStart(r io.ReaderAt, size int64, pos int64, buf []byte) (int64, error) {
+	if pos == 0 {
+		return 0, nil
+	}
+
+	// the line starts at the pos if the previous byte is the new line
+	pos--
+	for pos < size {
+		n, err := r.ReadAt(buf[:min(int64(len(buf)), size-pos)], pos)
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+		if i := bytes.IndexByte(buf[:n], '\n'); i != -1 {
+			return pos + int64(i) + 1, nil
+		}
+		pos += int64(n)
+	}
+
+	return size, nil
+}
+
+// readLine returns the line starting at the offset without the new line and the offset of the next line.
+// The line is nil if it's longer than the buffer, the last incomplete line is returned as is.
+func readLine(r io.ReaderAt, size int64, start int64, buf []byte) ([]byte, int64, error) {
+	n, err := r.ReadAt(buf[:min(int64(len(buf)), size-start)], start)
+	if err != nil && err != io.EOF {
+		return nil, 0, err
+	}
+
+	if i := bytes.IndexByte(buf[:n], '\n'); i != -1 {
+		return buf[:i], start + int64(i) + 1, nil
+	}
+	if start+int64(n) == size {
+		return buf[:n], size, nil
+	}
+
+	next, err := nextLineStart(r, size, start+int64(n), buf)
+	return nil, next, err
+}
@@ -0,0 +1,89 @@
+package file
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLastLinesOffset(t *testing.T) {
+	tests := []struct {
+		data string
+		n    int
+		want string
+	}{
+		{data: "a\nbb\nccc\n", n: 1, want: "ccc\n"},
+		{data: "a\nbb\nccc\n", n: 2, want: "bb\nccc\n"},
+		{data: "a\nbb\nccc\n", n: 5, want: "a\nbb\nccc\n"},
+		{data: "a\nbb\nccc", n: 1, want: "ccc"},
+		{data: "a\nbb\nccc", n: 2, want: "bb\nccc"},
+		{data: "", n: 1, want: ""},
+	}
+
+	for _, tt := range tests {
+		for _, bufSize := range []int{1, 2, 1024} {
+			r := strings.NewReader(tt.data)
+			offset, err := lastLinesOffset(r, int64(len(tt.data)), tt.n, make([]byte, bufSize))
+			require.NoError(t, err)
+			require.Equal(t, tt.want, tt.data[offset:], "wrong offset of %q, n=%d, buf=%d", tt.data, tt.n, bufSize)
+		}
+	}
+}
+
+func TestSinceTimeOffset(t *testing.T) {
+	base := time.Date(2023, 10, 1, 0, 0, 0, 0, time.UTC)
+	lines := make([]string, 0)
+	for i := 0; i < 1000; i++ {
+		if i%7 == 0 {
+			lines = append(lines, "not a json")
+			continue
+		}
+		line := fmt.Sprintf(`{"time":"%s","n":%d}`, base.Add(time.Duration(i)*time.Second).Format(time.RFC3339), i)
+		lines = append(lines, line)
+	}
+	data := strings.Join(lines, "\n") + "\n"
+
+	lineTime := func(line []byte) (time.Time, bool) {
+		s := string(line)
+		i := strings.Index(s, `"time":"`)
+		if i == -1 {
+			return time.Time{}, false
+		}
+		t, err := time.Parse(time.RFC3339, s[i+8:i+8+20])
+		return t, err == nil
+	}
+
+	for _, from := range []int{0, 1, 8, 500, 998, 999} {
+		since := base.Add(time.Duration(from) * time.Second)
+		for _, bufSize := range []int{64, 1024} {
+			offset, err := sinceTimeOffset(strings.NewReader(data), int64(len(data)), since, lineTime, make([]byte, bufSize))
+			require.NoError(t, err)
+
+			rest := data[offset:]
+			want := from
+			// the unparsed line before the first matching one is read too
+			if from%7 == 1 {
+				want--
+			}
+			require.True(t, strings.HasPrefix(rest, lines[want]+"\n"), "wrong offset for %d, buf=%d: %.40q", from, bufSize, rest)
+		}
+	}
+
+	// all lines are older
+	offset, err := sinceTimeOffset(strings.NewReader(data), int64(len(data)), base.Add(time.Hour), lineTime, make([]byte, 1024))
+	require.NoError(t, err)
+	require.Equal(t, int64(len(data)), offset)
+}
+
+func TestNextLineStart(t *testing.T) {
+	data := "a\nbb\n" + strings.Repeat("c", 100) + "\nd"
+	for pos, want := range map[int]int{0: 0, 1: 2, 2: 2, 3: 5, 5: 5, 6: 106, 106: 106, 107: len(data)} {
+		got, err := nextLineStart(strings.NewReader(data), int64(len(data)), int64(pos), make([]byte, 8))
+		require.NoError(t, err)
+		require.Equal(t, int64(want), got, "wrong line start for "+strconv.Itoa(pos))
+	}
+}
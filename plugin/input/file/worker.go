@@ -4,13 +4,21 @@ import (
 	"bytes"
 	"io"
 	"os"
+	"time"
 
 	"github.com/ozontech/file.d/pipeline"
 	"go.uber.org/zap"
 )
 
+// limitedSourceBackoff is the pause of the worker if all the jobs it gets are over the source inflight limit
+const limitedSourceBackoff = 10 * time.Millisecond
+
 type worker struct {
 	maxEventSize int
+	// passLongEvents passes the events exceeding the maxEventSize to the pipeline, so it applies the max event size policy
+	passLongEvents bool
+	// criJoiner is set if CRI partial lines should be joined
+	criJoiner *criJoiner
 }
 
 type inputer interface {
@@ -18,6 +26,7 @@ type inputer interface {
 	In(sourceID pipeline.SourceID, sourceName string, offset int64, data []byte, isNewSource bool) uint64
 	IncReadOps()
 	IncMaxEventSizeExceeded()
+	IsSourceLimited(sourceID pipeline.SourceID) bool
 }
 
 func (w *worker) start(inputController inputer, jobProvider *jobProvider, readBufferSize int, logger *zap.SugaredLogger) {
@@ -36,6 +45,8 @@ func (w *worker) work(controller inputer, jobProvider *jobProvider, readBufferSi
 		}
 		job.mu.Lock()
 		file := job.file
+		reader := job.reader()
+		isCompressed := job.decompressor != nil
 		isDone := job.isDone
 		isVirgin := job.isVirgin
 		sourceID := job.sourceID
@@ -52,6 +63,7 @@ func (w *worker) work(controller inputer, jobProvider *jobProvider, readBufferSi
 		}
 
 		isEOFReached := false
+		isLimited := false
 		readTotal := int64(0)
 		scanned := int64(0)
 
@@ -60,13 +72,26 @@ func (w *worker) work(controller inputer, jobProvider *jobProvider, readBufferSi
 		// the end of the message can be added later and will be read in this iteration
 		accumBuf = append(accumBuf[:0], job.tail...)
 		for {
-			n, err := file.Read(readBuf)
+			// the events of the source are stuck in the pipeline, so the other files are read meanwhile
+			if controller.IsSourceLimited(sourceID) {
+				isLimited = true
+				break
+			}
+
+			n, err := reader.Read(readBuf)
 			controller.IncReadOps()
 			// if we read to end of file it's time to check truncation etc and process next job
 			if err == io.EOF || n == 0 {
 				isEOFReached = true
 				break
 			}
+			// the compressed file may be not completely written yet or corrupted,
+			// it's read again after it's changed
+			if err != nil && isCompressed {
+				logger.Errorf("compressed file %d:%s read error, %s", sourceID, sourceName, err.Error())
+				isEOFReached = true
+				break
+			}
 			if err != nil {
 				logger.Fatalf("file %d:%s read error, %s read=%d", sourceID, sourceName, err.Error(), n)
 			}
@@ -92,7 +117,7 @@ func (w *worker) work(controller inputer, jobProvider *jobProvider, readBufferSi
 				scanned += pos + 1
 
 				// check if the event fits into the max size, otherwise skip the event
-				if shouldCheckMax && len(accumBuf)+len(line) > w.maxEventSize {
+				if shouldCheckMax && !w.passLongEvents && len(accumBuf)+len(line) > w.maxEventSize {
 					controller.IncMaxEventSizeExceeded()
 					skipLine = true
 				}
@@ -109,7 +134,13 @@ func (w *worker) work(controller inputer, jobProvider *jobProvider, readBufferSi
 						inBuf = accumBuf
 					}
 
-					job.lastEventSeq = controller.In(sourceID, sourceName, lastOffset+scanned, inBuf, isVirgin)
+					shouldPass := true
+					if w.criJoiner != nil {
+						inBuf, shouldPass = w.criJoiner.join(job, inBuf)
+					}
+					if shouldPass {
+						job.lastEventSeq = controller.In(sourceID, sourceName, lastOffset+scanned, inBuf, isVirgin)
+					}
 				}
 				// restore the line buffer
 				accumBuf = accumBuf[:0]
@@ -136,6 +167,11 @@ func (w *worker) work(controller inputer, jobProvider *jobProvider, readBufferSi
 			// put job in the end of queue.
 			jobProvider.continueJob(job)
 		}
+
+		// don't spin if the limited jobs are the only ones
+		if isLimited && readTotal == 0 {
+			time.Sleep(limitedSourceBackoff)
+		}
 	}
 }
 
@@ -145,10 +181,15 @@ func (w *worker) processEOF(file *os.File, job *Job, jobProvider *jobProvider, t
 		return err
 	}
 
-	// files truncated from time to time, after logs from file was processed.
-	// Position > stat.Size() means that data was truncated and
-	// caret pointer must be moved to start of file.
-	if totalOffset > stat.Size() {
+	// compressed files aren't truncated, remember the size read to detect changes
+	if job.decompressor != nil {
+		job.mu.Lock()
+		job.compressedSize = stat.Size()
+		job.mu.Unlock()
+	} else if totalOffset > stat.Size() {
+		// files truncated from time to time, after logs from file was processed.
+		// Position > stat.Size() means that data was truncated and
+		// caret pointer must be moved to start of file.
 		jobProvider.truncateJob(job)
 	}
 
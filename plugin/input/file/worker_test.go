@@ -20,12 +20,18 @@ import (
 
 type inputerMock struct {
 	gotData []string
+	// sourceLimit is the number of the events after which the source is limited, zero means no limit
+	sourceLimit int
 }
 
 func (i *inputerMock) IncReadOps() {}
 
 func (i *inputerMock) IncMaxEventSizeExceeded() {}
 
+func (i *inputerMock) IsSourceLimited(_ pipeline.SourceID) bool {
+	return i.sourceLimit != 0 && len(i.gotData) >= i.sourceLimit
+}
+
 func (i *inputerMock) In(_ pipeline.SourceID, _ string, _ int64, data []byte, _ bool) uint64 {
 	i.gotData = append(i.gotData, string(data))
 	return 0
@@ -42,6 +48,7 @@ func TestWorkerWork(t *testing.T) {
 	tests := []struct {
 		name           string
 		maxEventSize   int
+		passLongEvents bool
 		inFile         string
 		readBufferSize int
 		expData        string
@@ -67,6 +74,14 @@ func TestWorkerWork(t *testing.T) {
 			readBufferSize: 1024,
 			expData:        "",
 		},
+		{
+			name:           "should_ok_and_pass_long_line",
+			maxEventSize:   2,
+			passLongEvents: true,
+			inFile:         "abc\n",
+			readBufferSize: 1024,
+			expData:        "abc\n",
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -94,7 +109,9 @@ func TestWorkerWork(t *testing.T) {
 			}
 			ctl := metric.New("test", prometheus.NewRegistry())
 			possibleOffsetCorruptionMetric := ctl.RegisterCounter("worker", "help_test")
-			jp := NewJobProvider(&Config{}, possibleOffsetCorruptionMetric, &zap.SugaredLogger{})
+			compressedReadBytesMetric := ctl.RegisterCounter("worker_compressed", "help_test", "codec")
+			symlinkReResolvesMetric := ctl.RegisterCounter("worker_symlink", "help_test")
+			jp := NewJobProvider(&Config{}, possibleOffsetCorruptionMetric, compressedReadBytesMetric, symlinkReResolvesMetric, &zap.SugaredLogger{})
 			jp.jobsChan = make(chan *Job, 2)
 			jp.jobs = map[pipeline.SourceID]*Job{
 				1: job,
@@ -102,7 +119,7 @@ func TestWorkerWork(t *testing.T) {
 			jp.jobsChan <- job
 			jp.jobsChan <- nil
 
-			w := &worker{maxEventSize: tt.maxEventSize}
+			w := &worker{maxEventSize: tt.maxEventSize, passLongEvents: tt.passLongEvents}
 			inputer := inputerMock{}
 
 			w.work(&inputer, jp, tt.readBufferSize, zap.L().Sugar().With("fd"))
@@ -223,7 +240,9 @@ func TestWorkerWorkMultiData(t *testing.T) {
 
 			ctl := metric.New("test", prometheus.NewRegistry())
 			possibleOffsetCorruptionMetric := ctl.RegisterCounter("worker", "help_test")
-			jp := NewJobProvider(&Config{}, possibleOffsetCorruptionMetric, &zap.SugaredLogger{})
+			compressedReadBytesMetric := ctl.RegisterCounter("worker_compressed", "help_test", "codec")
+			symlinkReResolvesMetric := ctl.RegisterCounter("worker_symlink", "help_test")
+			jp := NewJobProvider(&Config{}, possibleOffsetCorruptionMetric, compressedReadBytesMetric, symlinkReResolvesMetric, &zap.SugaredLogger{})
 			jp.jobsChan = make(chan *Job, 2)
 			jp.jobs = map[pipeline.SourceID]*Job{
 				1: job,
@@ -238,3 +257,48 @@ func TestWorkerWorkMultiData(t *testing.T) {
 		})
 	}
 }
+
+func TestWorkerWorkSourceLimited(t *testing.T) {
+	f, err := os.CreateTemp("/tmp", "worker_test")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+
+	_, _ = fmt.Fprint(f, "abc\ndef\nghi\n")
+	_, err = f.Seek(0, io.SeekStart)
+	require.NoError(t, err)
+
+	job := &Job{
+		file:       f,
+		shouldSkip: *atomic.NewBool(false),
+		offsets:    sliceMap{},
+		mu:         &sync.Mutex{},
+	}
+
+	ctl := metric.New("test", prometheus.NewRegistry())
+	possibleOffsetCorruptionMetric := ctl.RegisterCounter("worker", "help_test")
+	compressedReadBytesMetric := ctl.RegisterCounter("worker_compressed", "help_test", "codec")
+	symlinkReResolvesMetric := ctl.RegisterCounter("worker_symlink", "help_test")
+	jp := NewJobProvider(&Config{}, possibleOffsetCorruptionMetric, compressedReadBytesMetric, symlinkReResolvesMetric, &zap.SugaredLogger{})
+	jp.jobsChan = make(chan *Job, 2)
+	jp.jobs = map[pipeline.SourceID]*Job{
+		1: job,
+	}
+	jp.jobsChan <- job
+	jp.jobsChan <- nil
+
+	w := &worker{}
+	inputer := inputerMock{sourceLimit: 1}
+	w.work(&inputer, jp, 5, zap.L().Sugar().With("fd"))
+
+	assert.Equal(t, []string{"abc\n"}, inputer.gotData, "limited source is read")
+	assert.False(t, job.isDone)
+	require.Equal(t, job, <-jp.jobsChan, "limited job isn't put in the end of queue")
+
+	// the rest of the file is read after the events are committed
+	inputer.sourceLimit = 0
+	jp.jobsChan <- job
+	jp.jobsChan <- nil
+	w.work(&inputer, jp, 5, zap.L().Sugar().With("fd"))
+
+	assert.Equal(t, []string{"abc\n", "def\n", "ghi\n"}, inputer.gotData)
+}
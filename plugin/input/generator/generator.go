@@ -0,0 +1,187 @@
+package generator
+
+import (
+	"context"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/ozontech/file.d/cfg"
+	"github.com/ozontech/file.d/decoder"
+	"github.com/ozontech/file.d/fd"
+	"github.com/ozontech/file.d/pipeline"
+	"go.uber.org/zap"
+)
+
+/*{ introduction
+It generates synthetic events from the template, it's intended for load testing of pipelines and outputs.
+Events are generated at the `rate` until `count` events are generated or `duration` passes,
+then the plugin stops generating, but the pipeline keeps running.
+
+The template is the event text with the placeholders which are replaced by the generated values:
+* `${time}`, `${time:FORMAT}` – the current time, by default in `rfc3339nano` format.
+The format is one of `ansic|unixdate|rubydate|rfc822|rfc822z|rfc850|rfc1123|rfc1123z|rfc3339|rfc3339nano|kitchen|stamp|stampmilli|stampmicro|stampnano|unixtime`
+or a custom Go time layout.
+* `${counter}` – the number of the event starting from zero.
+* `${int:MAX}`, `${int:MIN:MAX}` – the random integer from `MIN` to `MAX` inclusive, `MIN` is zero by default.
+* `${string:LENGTH}`, `${string:MIN:MAX}` – the random alphanumeric string of `LENGTH` chars or from `MIN` to `MAX` chars,
+so the size of events can vary.
+* `${choice:A|B|C}` – one of the values.
+* `${id:CARDINALITY}` – the random 16 hex digits id, there are at most `CARDINALITY` distinct ids.
+* `${uuid}` – the random UUID.
+
+Values are inserted as is, so strings should be quoted in the JSON template.
+If the template is the JSON object, the pipeline `auto` decoder is `json`.
+
+> Events are committed right away, they aren't saved anywhere.
+
+**Example**
+```yaml
+pipelines:
+  example_pipeline:
+    input:
+      type: generator
+      template: '{"time":"${time}","level":"${choice:info|info|info|warn|error}","service":"svc-${int:1:20}","trace_id":"${id:100000}","request_id":"${uuid}","seq":${counter},"message":"${string:20:500}"}'
+      rate: 10000
+      duration: 10m
+      seed: 42
+    output:
+      type: devnull
+```
+}*/
+
+const (
+	inPluginType = "generator"
+	sourceName   = "generator"
+)
+
+type Plugin struct {
+	config     *Config
+	logger     *zap.SugaredLogger
+	controller pipeline.InputPluginController
+	parts      []templatePart
+	cancel     context.CancelFunc
+}
+
+// ! config-params
+// ^ config-params
+type Config struct {
+	// > @3@4@5@6
+	// >
+	// > The template of the event, see the placeholders above.
+	Template string `json:"template" required:"true"` // *
+
+	// > @3@4@5@6
+	// >
+	// > How many events are generated per second. If it's zero, events are generated as fast as the pipeline accepts them.
+	Rate int `json:"rate" default:"1000"` // *
+
+	// > @3@4@5@6
+	// >
+	// > How many events are generated in total. If it's zero, the count isn't limited.
+	Count int64 `json:"count" default:"0"` // *
+
+	// > @3@4@5@6
+	// >
+	// > How long events are generated. If it's zero, the duration isn't limited.
+	Duration  cfg.Duration `json:"duration" default:"0" parse:"duration"` // *
+	Duration_ time.Duration
+
+	// > @3@4@5@6
+	// >
+	// > The seed of the random values, the same seed gives the same events except the time.
+	// > If it's zero, the seed is random.
+	Seed int64 `json:"seed" default:"0"` // *
+}
+
+func init() {
+	fd.DefaultPluginRegistry.RegisterInput(&pipeline.PluginStaticInfo{
+		Type:    inPluginType,
+		Factory: Factory,
+	})
+}
+
+func Factory() (pipeline.AnyPlugin, pipeline.AnyConfig) {
+	return &Plugin{}, &Config{}
+}
+
+func (p *Plugin) Start(config pipeline.AnyConfig, params *pipeline.InputPluginParams) {
+	p.controller = params.Controller
+	p.logger = params.Logger
+	p.config = config.(*Config)
+
+	if p.config.Rate < 0 {
+		p.logger.Fatalf("rate can't be negative")
+	}
+
+	parts, err := parseTemplate(p.config.Template)
+	if err != nil {
+		p.logger.Fatalf("can't parse template: %s", err.Error())
+	}
+	p.parts = parts
+
+	seed := p.config.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+
+	if strings.HasPrefix(strings.TrimSpace(p.config.Template), "{") {
+		p.controller.SuggestDecoder(decoder.JSON)
+	}
+	p.controller.UseSpread()
+	p.controller.DisableStreams()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	p.cancel = cancel
+
+	go p.generate(ctx, rand.New(rand.NewSource(seed)))
+}
+
+func (p *Plugin) generate(ctx context.Context, rnd *rand.Rand) {
+	start := time.Now()
+	timer := time.NewTimer(0)
+	<-timer.C
+	defer timer.Stop()
+
+	out := make([]byte, 0)
+	counter := int64(0)
+	for ; p.config.Count == 0 || counter < p.config.Count; counter++ {
+		// the event is due by the rate since the start, so the generator catches up after delays
+		if p.config.Rate > 0 {
+			due := start.Add(time.Duration(float64(counter) / float64(p.config.Rate) * float64(time.Second)))
+			if wait := time.Until(due); wait > 0 {
+				timer.Reset(wait)
+				select {
+				case <-ctx.Done():
+					return
+				case <-timer.C:
+				}
+			}
+		}
+		if ctx.Err() != nil {
+			return
+		}
+
+		now := time.Now()
+		if p.config.Duration_ > 0 && now.Sub(start) >= p.config.Duration_ {
+			break
+		}
+
+		out = render(out[:0], p.parts, rnd, now, counter)
+		p.controller.In(0, sourceName, counter, out, false)
+	}
+
+	p.logger.Infof("generator has finished: %d events are generated in %s", counter, time.Since(start).String())
+}
+
+func (p *Plugin) Stop() {
+	p.cancel()
+}
+
+func (p *Plugin) Commit(_ *pipeline.Event) {
+}
+
+// PassEvent decides pass or discard event.
+func (p *Plugin) PassEvent(_ *pipeline.Event) bool {
+	return true
+}
@@ -0,0 +1,155 @@
+package generator
+
+import (
+	"math/rand"
+	"regexp"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ozontech/file.d/decoder"
+	"github.com/ozontech/file.d/pipeline"
+	"github.com/ozontech/file.d/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type controller struct {
+	mu      sync.Mutex
+	events  []string
+	offsets []int64
+	decoder decoder.DecoderType
+}
+
+func (c *controller) In(_ pipeline.SourceID, _ string, offset int64, data []byte, _ bool) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.events = append(c.events, string(data))
+	c.offsets = append(c.offsets, offset)
+	return uint64(len(c.events))
+}
+
+func (c *controller) received() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.events...)
+}
+
+func (c *controller) UseSpread()      {}
+func (c *controller) DisableStreams() {}
+func (c *controller) SuggestDecoder(t decoder.DecoderType) {
+	c.decoder = t
+}
+func (c *controller) IncReadOps()                              {}
+func (c *controller) IncMaxEventSizeExceeded()                 {}
+func (c *controller) IsSourceLimited(_ pipeline.SourceID) bool { return false }
+
+func startPlugin(t *testing.T, config *Config) *controller {
+	test.NewConfig(config, nil)
+
+	ctl := &controller{}
+	p := &Plugin{}
+	p.Start(config, test.NewEmptyInputPluginParams(ctl))
+	t.Cleanup(p.Stop)
+
+	return ctl
+}
+
+func TestGenerateCount(t *testing.T) {
+	ctl := startPlugin(t, &Config{
+		Template: `{"seq":${counter},"level":"${choice:info|error}"}`,
+		Rate:     100,
+		Count:    10,
+	})
+
+	start := time.Now()
+	require.Eventually(t, func() bool {
+		return len(ctl.received()) == 10
+	}, 5*time.Second, 10*time.Millisecond)
+	// the last event is due after 90ms
+	assert.GreaterOrEqual(t, time.Since(start), 80*time.Millisecond)
+
+	// no events are generated after the count is reached
+	time.Sleep(50 * time.Millisecond)
+
+	ctl.mu.Lock()
+	defer ctl.mu.Unlock()
+
+	require.Len(t, ctl.events, 10)
+	for i, e := range ctl.events {
+		assert.Regexp(t, `^\{"seq":`+strconv.Itoa(i)+`,"level":"(info|error)"\}$`, e)
+		assert.Equal(t, int64(i), ctl.offsets[i])
+	}
+	assert.Equal(t, decoder.JSON, ctl.decoder)
+}
+
+func TestGenerateDuration(t *testing.T) {
+	ctl := startPlugin(t, &Config{
+		Template: "message ${string:10}",
+		Rate:     1000,
+		Duration: "50ms",
+	})
+
+	time.Sleep(200 * time.Millisecond)
+	events := ctl.received()
+	assert.NotEmpty(t, events)
+	assert.LessOrEqual(t, len(events), 51)
+
+	ctl.mu.Lock()
+	defer ctl.mu.Unlock()
+	assert.Equal(t, decoder.NO, ctl.decoder)
+}
+
+func TestRender(t *testing.T) {
+	parts, err := parseTemplate(`${time:unixtime} ${time:2006-01-02} ${int:5} ${int:-3:-1} ${string:2:4} ${id:3} ${uuid}`)
+	require.NoError(t, err)
+
+	now := time.Date(2024, 5, 1, 10, 15, 0, 0, time.UTC)
+	re := regexp.MustCompile(`^1714558500 2024-05-01 [0-5] -[1-3] [a-zA-Z0-9]{2,4} ([0-9a-f]{16}) [0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+
+	rnd := rand.New(rand.NewSource(1))
+	ids := map[string]struct{}{}
+	rendered := make([]string, 0)
+	for i := 0; i < 100; i++ {
+		out := string(render(nil, parts, rnd, now, int64(i)))
+		match := re.FindStringSubmatch(out)
+		require.NotNil(t, match, out)
+		ids[match[1]] = struct{}{}
+		rendered = append(rendered, out)
+	}
+	assert.Len(t, ids, 3)
+
+	// the same seed gives the same events
+	rnd = rand.New(rand.NewSource(1))
+	for i := 0; i < 100; i++ {
+		assert.Equal(t, rendered[i], string(render(nil, parts, rnd, now, int64(i))))
+	}
+}
+
+func TestParseTemplate(t *testing.T) {
+	for _, template := range []string{
+		"",
+		"${unknown}",
+		"${counter",
+		"${int:a}",
+		"${int:5:1}",
+		"${int:-9223372036854775808:9223372036854775807}",
+		"${string:0}",
+		"${choice:}",
+		"${id:0}",
+	} {
+		_, err := parseTemplate(template)
+		assert.Error(t, err, template)
+	}
+
+	parts, err := parseTemplate("a ${time:rfc3339} b ${choice:x|y:z}")
+	require.NoError(t, err)
+	assert.Equal(t, []templatePart{
+		{kind: partLiteral, literal: "a "},
+		{kind: partTime, timeFormat: time.RFC3339},
+		{kind: partLiteral, literal: " b "},
+		{kind: partChoice, choices: []string{"x", "y:z"}},
+	}, parts)
+}
@@ -0,0 +1,192 @@
+package generator
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ozontech/file.d/cfg"
+	"github.com/ozontech/file.d/pipeline"
+)
+
+type partKind int
+
+const (
+	partLiteral partKind = iota
+	partTime
+	partCounter
+	partInt
+	partString
+	partChoice
+	partID
+	partUUID
+)
+
+const (
+	alphanumeric = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+	hexDigits    = "0123456789abcdef"
+)
+
+// templatePart is the literal part of the template or the generated value of the `${kind:args}` part
+type templatePart struct {
+	kind    partKind
+	literal string
+
+	// timeFormat is the layout of partTime
+	timeFormat string
+	// min and max are the bounds of partInt and the length of partString, the cardinality of partID is max
+	min int64
+	max int64
+	// choices are the values of partChoice
+	choices []string
+}
+
+// parseTemplate splits the template to the literals and the `${kind:args}` parts
+func parseTemplate(s string) ([]templatePart, error) {
+	parts := make([]templatePart, 0)
+	err := cfg.SplitSubstitution(s, func(literal string) {
+		parts = append(parts, templatePart{kind: partLiteral, literal: literal})
+	}, func(placeholder string) error {
+		part, err := parsePart(placeholder)
+		if err != nil {
+			return fmt.Errorf("wrong placeholder %q: %w", placeholder, err)
+		}
+		parts = append(parts, part)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(parts) == 0 {
+		return nil, fmt.Errorf("template is empty")
+	}
+	return parts, nil
+}
+
+func parsePart(s string) (templatePart, error) {
+	kind, args, _ := strings.Cut(s, ":")
+	switch kind {
+	case "time":
+		format := time.RFC3339Nano
+		if args != "" {
+			var err error
+			if format, err = pipeline.ParseFormatName(args); err != nil {
+				// the custom layout is used as is
+				format = args
+			}
+		}
+		return templatePart{kind: partTime, timeFormat: format}, nil
+	case "counter":
+		return templatePart{kind: partCounter}, nil
+	case "int":
+		if !strings.Contains(args, ":") {
+			args = "0:" + args
+		}
+		from, to, err := parseBounds(args, math.MinInt64)
+		if err == nil && (to-from < 0 || to-from == math.MaxInt64) {
+			err = fmt.Errorf("range %d:%d is too wide", from, to)
+		}
+		return templatePart{kind: partInt, min: from, max: to}, err
+	case "string":
+		from, to, err := parseBounds(args, 1)
+		return templatePart{kind: partString, min: from, max: to}, err
+	case "choice":
+		if args == "" {
+			return templatePart{}, fmt.Errorf("choice has no values")
+		}
+		return templatePart{kind: partChoice, choices: strings.Split(args, "|")}, nil
+	case "id":
+		cardinality, err := strconv.ParseInt(args, 10, 64)
+		if err != nil || cardinality < 1 {
+			return templatePart{}, fmt.Errorf("id cardinality should be a positive number")
+		}
+		return templatePart{kind: partID, max: cardinality}, nil
+	case "uuid":
+		return templatePart{kind: partUUID}, nil
+	default:
+		return templatePart{}, fmt.Errorf("unknown kind %q", kind)
+	}
+}
+
+// parseBounds parses `n` or `from:to` args, the bounds can't be less than the lowest
+func parseBounds(args string, lowest int64) (int64, int64, error) {
+	first, second, isRange := strings.Cut(args, ":")
+	from, err := strconv.ParseInt(first, 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("wrong bound %q", first)
+	}
+	to := from
+	if isRange {
+		if to, err = strconv.ParseInt(second, 10, 64); err != nil {
+			return 0, 0, fmt.Errorf("wrong bound %q", second)
+		}
+	}
+	if from < lowest || to < from {
+		return 0, 0, fmt.Errorf("wrong bounds %d:%d", from, to)
+	}
+	return from, to, nil
+}
+
+// render appends the template with the values generated for the event
+func render(out []byte, parts []templatePart, rnd *rand.Rand, now time.Time, counter int64) []byte {
+	for i := range parts {
+		part := &parts[i]
+		switch part.kind {
+		case partLiteral:
+			out = append(out, part.literal...)
+		case partTime:
+			if part.timeFormat == pipeline.UnixTime {
+				out = strconv.AppendInt(out, now.Unix(), 10)
+			} else {
+				out = now.AppendFormat(out, part.timeFormat)
+			}
+		case partCounter:
+			out = strconv.AppendInt(out, counter, 10)
+		case partInt:
+			out = strconv.AppendInt(out, part.min+rnd.Int63n(part.max-part.min+1), 10)
+		case partString:
+			length := part.min + rnd.Int63n(part.max-part.min+1)
+			for j := int64(0); j < length; j++ {
+				out = append(out, alphanumeric[rnd.Intn(len(alphanumeric))])
+			}
+		case partChoice:
+			out = append(out, part.choices[rnd.Intn(len(part.choices))]...)
+		case partID:
+			// the same index always gives the same id, so there are at most max distinct ids
+			out = appendHex(out, mix(uint64(rnd.Int63n(part.max))), 16)
+		case partUUID:
+			hi, lo := rnd.Uint64(), rnd.Uint64()
+			// version 4 and variant 1 bits
+			hi = hi&^(0xf<<12) | 4<<12
+			lo = lo&^(0x3<<62) | 0x2<<62
+			out = appendHex(out, hi>>32, 8)
+			out = append(out, '-')
+			out = appendHex(out, hi>>16, 4)
+			out = append(out, '-')
+			out = appendHex(out, hi, 4)
+			out = append(out, '-')
+			out = appendHex(out, lo>>48, 4)
+			out = append(out, '-')
+			out = appendHex(out, lo, 12)
+		}
+	}
+	return out
+}
+
+// appendHex appends the lowest digits of v
+func appendHex(out []byte, v uint64, digits int) []byte {
+	for shift := (digits - 1) * 4; shift >= 0; shift -= 4 {
+		out = append(out, hexDigits[v>>shift&0xf])
+	}
+	return out
+}
+
+// mix is the splitmix64 finalizer, it spreads the sequential numbers over the whole range
+func mix(v uint64) uint64 {
+	v = (v ^ v>>30) * 0xbf58476d1ce4e5b9
+	v = (v ^ v>>27) * 0x94d049bb133111eb
+	return v ^ v>>31
+}
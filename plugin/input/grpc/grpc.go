@@ -0,0 +1,425 @@
+package grpc
+
+import (
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"sort"
+	"sync"
+
+	"github.com/ozontech/file.d/fd"
+	"github.com/ozontech/file.d/metric"
+	"github.com/ozontech/file.d/pipeline"
+	"github.com/ozontech/file.d/plugin/input/grpc/logpb"
+	"github.com/ozontech/file.d/xtls"
+	"github.com/prometheus/client_golang/prometheus"
+	insaneJSON "github.com/vitkovskii/insane-json"
+	"go.uber.org/atomic"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	_ "google.golang.org/grpc/encoding/gzip" // registers the gzip compressor
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+/*{ introduction
+It runs the gRPC server which receives logs by the streaming RPC `filed.input.v1.LogService/Push`,
+see [logs.proto](/plugin/input/grpc/logpb/logs.proto). Each record of the stream becomes the event.
+
+> It guarantees "at-least-once delivery" if the client resends records which aren't committed:
+> the server answers with the number of the stream records committed by the pipeline
+> and finishes the stream with `OK` status only after all records are committed.
+> The server doesn't read the stream while `max_in_flight` records aren't committed, so clients get the backpressure.
+
+If `metadata_field` is set, the record metadata is added to this field of the event, the payload should be the JSON object then.
+
+**Example**
+```yaml
+pipelines:
+  example_pipeline:
+    input:
+      type: grpc
+      address: ":50051"
+      metadata_field: meta
+    output:
+      type: stdout
+```
+}*/
+
+const (
+	inPluginType = "grpc"
+)
+
+var errStreamClosed = errors.New("stream is closed")
+
+type Plugin struct {
+	logpb.UnimplementedLogServiceServer
+
+	config     *Config
+	logger     *zap.SugaredLogger
+	controller pipeline.InputPluginController
+	server     *grpc.Server
+	listener   net.Listener
+
+	offset *atomic.Int64
+	// records holds records by the event offset until the event is committed
+	records   map[int64]streamRecord
+	recordsMu *sync.Mutex
+	sourceSeq *atomic.Uint64
+
+	// plugin metrics
+
+	streamsMetric prometheus.Gauge
+	errorsMetric  prometheus.Counter
+}
+
+// ! config-params
+// ^ config-params
+type Config struct {
+	// > @3@4@5@6
+	// >
+	// > An address to listen to. Omit ip/host to listen all network interfaces. E.g. `:50051`
+	Address string `json:"address" default:":50051"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The max size of the received message.
+	MaxMessageSize  string `json:"max_message_size" default:"4 MB" parse:"data_unit"` // *
+	MaxMessageSize_ uint
+
+	// > @3@4@5@6
+	// >
+	// > The max number of concurrent streams of the connection.
+	MaxConcurrentStreams int `json:"max_concurrent_streams" default:"100"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The max number of records of the stream which aren't committed yet.
+	// > The stream isn't read when it's reached.
+	MaxInFlight int `json:"max_in_flight" default:"1024"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The event field to put the record metadata. If it's empty, metadata is ignored.
+	MetadataField string `json:"metadata_field" default:""` // *
+
+	// > @3@4@5@6
+	// >
+	// > CA certificate in PEM encoding. This can be a path or the content of the certificate.
+	// > If both ca_cert and private_key are set, the server starts accepting connections in TLS mode.
+	CACert string `json:"ca_cert" default:""` // *
+
+	// > @3@4@5@6
+	// >
+	// > CA private key in PEM encoding. This can be a path or the content of the key.
+	// > If both ca_cert and private_key are set, the server starts accepting connections in TLS mode.
+	PrivateKey string `json:"private_key" default:""` // *
+
+	// > @3@4@5@6
+	// >
+	// > CA certificate in PEM encoding or a path to verify client certificates.
+	// > If it's set, clients must present certificates signed by it (mTLS).
+	ClientCACert string `json:"client_ca_cert" default:""` // *
+}
+
+func init() {
+	fd.DefaultPluginRegistry.RegisterInput(&pipeline.PluginStaticInfo{
+		Type:    inPluginType,
+		Factory: Factory,
+	})
+}
+
+func Factory() (pipeline.AnyPlugin, pipeline.AnyConfig) {
+	return &Plugin{}, &Config{}
+}
+
+func (p *Plugin) Start(config pipeline.AnyConfig, params *pipeline.InputPluginParams) {
+	p.config = config.(*Config)
+	p.logger = params.Logger
+	p.controller = params.Controller
+	p.registerMetrics(params.MetricCtl)
+
+	if p.config.MaxInFlight <= 0 {
+		p.logger.Fatalf("max_in_flight should be greater than 0")
+	}
+
+	p.offset = atomic.NewInt64(0)
+	p.records = make(map[int64]streamRecord)
+	p.recordsMu = &sync.Mutex{}
+	p.sourceSeq = atomic.NewUint64(0)
+
+	p.controller.DisableStreams()
+
+	options := []grpc.ServerOption{
+		grpc.MaxRecvMsgSize(int(p.config.MaxMessageSize_)),
+		grpc.MaxConcurrentStreams(uint32(p.config.MaxConcurrentStreams)),
+	}
+	if p.config.CACert != "" || p.config.PrivateKey != "" {
+		options = append(options, grpc.Creds(credentials.NewTLS(p.buildTLSConfig())))
+	} else if p.config.ClientCACert != "" {
+		p.logger.Fatalf("client_ca_cert requires ca_cert and private_key")
+	}
+	p.server = grpc.NewServer(options...)
+	logpb.RegisterLogServiceServer(p.server, p)
+
+	listener, err := net.Listen("tcp", p.config.Address)
+	if err != nil {
+		p.logger.Fatalf("can't listen %s: %s", p.config.Address, err.Error())
+	}
+	p.listener = listener
+
+	go func() {
+		if err := p.server.Serve(listener); err != nil && !errors.Is(err, grpc.ErrServerStopped) {
+			p.logger.Fatalf("input plugin grpc serving error: %s", err.Error())
+		}
+	}()
+}
+
+func (p *Plugin) buildTLSConfig() *tls.Config {
+	b := xtls.NewConfigBuilder()
+	if err := b.AppendX509KeyPair(p.config.CACert, p.config.PrivateKey); err != nil {
+		p.logger.Fatalf("can't append certificate: %s", err.Error())
+	}
+	if p.config.ClientCACert != "" {
+		if err := b.AppendCARoot(p.config.ClientCACert); err != nil {
+			p.logger.Fatalf("can't append client CA cert: %s", err.Error())
+		}
+	}
+
+	tlsConfig := b.Build()
+	if p.config.ClientCACert != "" {
+		tlsConfig.ClientCAs = tlsConfig.RootCAs
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return tlsConfig
+}
+
+func (p *Plugin) registerMetrics(ctl *metric.Ctl) {
+	p.streamsMetric = ctl.RegisterGauge("input_grpc_streams", "Number of gRPC streams in progress").WithLabelValues()
+	p.errorsMetric = ctl.RegisterCounter("input_grpc_errors", "Number of gRPC streams finished with an error").WithLabelValues()
+}
+
+// streamRecord is the record of the stream by its sequence number
+type streamRecord struct {
+	stream *stream
+	seq    uint64
+}
+
+type stream struct {
+	mu   *sync.Mutex
+	cond *sync.Cond
+	// received is the number of records passed to the pipeline
+	received uint64
+	// committed is the number of records committed in order
+	committed uint64
+	// done holds records committed out of order
+	done   map[uint64]struct{}
+	closed bool
+	// notify is signaled when committed grows
+	notify chan struct{}
+}
+
+func newStream() *stream {
+	s := &stream{
+		mu:     &sync.Mutex{},
+		done:   make(map[uint64]struct{}),
+		notify: make(chan struct{}, 1),
+	}
+	s.cond = sync.NewCond(s.mu)
+	return s
+}
+
+// reserve waits for room and returns the sequence number of the next record
+func (s *stream) reserve(maxInFlight int) (uint64, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for !s.closed && s.received-s.committed >= uint64(maxInFlight) {
+		s.cond.Wait()
+	}
+	if s.closed {
+		return 0, false
+	}
+
+	s.received++
+	return s.received, true
+}
+
+func (s *stream) commit(seq uint64) {
+	s.mu.Lock()
+	s.done[seq] = struct{}{}
+	for {
+		if _, has := s.done[s.committed+1]; !has {
+			break
+		}
+		delete(s.done, s.committed+1)
+		s.committed++
+	}
+	s.cond.Broadcast()
+	s.mu.Unlock()
+
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (s *stream) state() (received, committed uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.received, s.committed
+}
+
+func (s *stream) close() {
+	s.mu.Lock()
+	s.closed = true
+	s.cond.Broadcast()
+	s.mu.Unlock()
+}
+
+// Push passes the records of the stream to the pipeline and answers with the number of the committed records,
+// the stream is finished with OK status after all records are committed
+func (p *Plugin) Push(srv logpb.LogService_PushServer) error {
+	p.streamsMetric.Inc()
+	defer p.streamsMetric.Dec()
+
+	sourceName := ""
+	if peer, ok := peer.FromContext(srv.Context()); ok {
+		sourceName = peer.Addr.String()
+	}
+
+	s := newStream()
+	defer s.close()
+
+	readErr := make(chan error, 1)
+	go func() {
+		readErr <- p.readRecords(srv, s, sourceName)
+	}()
+
+	var (
+		err      error
+		readDone = readErr
+		sent     = uint64(0)
+		resp     = &logpb.PushResponse{}
+	)
+	for {
+		select {
+		case <-srv.Context().Done():
+			return srv.Context().Err()
+		case err = <-readDone:
+			readDone = nil
+		case <-s.notify:
+		}
+
+		received, committed := s.state()
+		if committed > sent {
+			resp.Committed = committed
+			if err := srv.Send(resp); err != nil {
+				return err
+			}
+			sent = committed
+		}
+
+		if readDone == nil && (err != nil || committed == received) {
+			break
+		}
+	}
+
+	if err != nil {
+		p.errorsMetric.Inc()
+		p.logger.Errorf("grpc stream from %s is failed: %s", sourceName, err.Error())
+		return err
+	}
+	return nil
+}
+
+// readRecords passes records of the stream to the pipeline until the stream is ended
+func (p *Plugin) readRecords(srv logpb.LogService_PushServer, s *stream, sourceName string) error {
+	sourceID := pipeline.SourceID(p.sourceSeq.Inc())
+	var event []byte
+	rec := &logpb.LogRecord{}
+	root := insaneJSON.Spawn()
+	defer insaneJSON.Release(root)
+
+	for {
+		if err := srv.RecvMsg(rec); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+
+		seq, ok := s.reserve(p.config.MaxInFlight)
+		if !ok {
+			return status.Error(codes.Unavailable, errStreamClosed.Error())
+		}
+
+		// the record is stored before In, since the event may be committed before In returns
+		offset := p.offset.Inc()
+		p.recordsMu.Lock()
+		p.records[offset] = streamRecord{stream: s, seq: seq}
+		p.recordsMu.Unlock()
+
+		event = p.appendEvent(event[:0], rec, root)
+		seqID := p.controller.In(sourceID, sourceName, offset, event, false)
+		// the pipeline has dropped the record, so it won't be committed
+		if seqID == pipeline.EventSeqIDError {
+			p.commit(offset)
+		}
+	}
+}
+
+// appendEvent appends the payload, metadata is added to the payload if it's the JSON object
+func (p *Plugin) appendEvent(out []byte, rec *logpb.LogRecord, root *insaneJSON.Root) []byte {
+	if p.config.MetadataField == "" || len(rec.Metadata) == 0 {
+		return append(out, rec.Payload...)
+	}
+
+	if err := root.DecodeBytes(rec.Payload); err != nil || !root.IsObject() {
+		return append(out, rec.Payload...)
+	}
+
+	keys := make([]string, 0, len(rec.Metadata))
+	for k := range rec.Metadata {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	meta := root.AddFieldNoAlloc(root, p.config.MetadataField).MutateToObject()
+	for _, k := range keys {
+		meta.AddFieldNoAlloc(root, k).MutateToString(rec.Metadata[k])
+	}
+
+	return root.Encode(out)
+}
+
+func (p *Plugin) commit(offset int64) {
+	p.recordsMu.Lock()
+	r, has := p.records[offset]
+	delete(p.records, offset)
+	p.recordsMu.Unlock()
+
+	if !has {
+		p.logger.Errorf("no grpc record for the committed event, offset=%d", offset)
+		return
+	}
+	r.stream.commit(r.seq)
+}
+
+func (p *Plugin) Stop() {
+	p.server.Stop()
+}
+
+func (p *Plugin) Commit(event *pipeline.Event) {
+	p.commit(event.Offset)
+}
+
+// PassEvent decides pass or discard event.
+func (p *Plugin) PassEvent(_ *pipeline.Event) bool {
+	return true
+}
@@ -0,0 +1,139 @@
+package grpc
+
+import (
+	"context"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ozontech/file.d/decoder"
+	"github.com/ozontech/file.d/pipeline"
+	"github.com/ozontech/file.d/plugin/input/grpc/logpb"
+	"github.com/ozontech/file.d/test"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/status"
+)
+
+type controller struct {
+	mu     sync.Mutex
+	events []*pipeline.Event
+}
+
+func (c *controller) In(_ pipeline.SourceID, _ string, offset int64, data []byte, _ bool) uint64 {
+	if string(data) == "drop" {
+		return pipeline.EventSeqIDError
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.events = append(c.events, &pipeline.Event{Offset: offset, SourceName: string(data)})
+	return uint64(len(c.events))
+}
+
+func (c *controller) received() []*pipeline.Event {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]*pipeline.Event(nil), c.events...)
+}
+
+func (c *controller) UseSpread()                               {}
+func (c *controller) DisableStreams()                          {}
+func (c *controller) SuggestDecoder(_ decoder.DecoderType)     {}
+func (c *controller) IncReadOps()                              {}
+func (c *controller) IncMaxEventSizeExceeded()                 {}
+func (c *controller) IsSourceLimited(_ pipeline.SourceID) bool { return false }
+
+func startPlugin(t *testing.T, config *Config) (*Plugin, *controller, logpb.LogServiceClient) {
+	ctl := &controller{}
+	params := test.NewEmptyInputPluginParams(ctl)
+
+	config.Address = "127.0.0.1:0"
+	p := &Plugin{}
+	p.Start(test.NewConfig(config, nil), params)
+	t.Cleanup(p.Stop)
+
+	conn, err := grpc.NewClient(p.listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+	return p, ctl, logpb.NewLogServiceClient(conn)
+}
+
+func TestPush(t *testing.T) {
+	p, ctl, client := startPlugin(t, &Config{MaxInFlight: 2, MetadataField: "meta"})
+
+	stream, err := client.Push(context.Background())
+	require.NoError(t, err)
+
+	send := func(payload string, metadata map[string]string) {
+		require.NoError(t, stream.Send(&logpb.LogRecord{Payload: []byte(payload), Metadata: metadata}))
+	}
+	send(`{"a":1}`, map[string]string{"service": "api", "host": "h1"})
+	send("drop", nil)
+	send("raw", map[string]string{"service": "api"})
+
+	// the window is full after the first two records
+	require.Eventually(t, func() bool {
+		return len(ctl.received()) == 1
+	}, time.Second*5, time.Millisecond*10)
+	time.Sleep(100 * time.Millisecond)
+	require.Len(t, ctl.received(), 1)
+	require.Equal(t, `{"a":1,"meta":{"host":"h1","service":"api"}}`, ctl.received()[0].SourceName)
+
+	p.Commit(ctl.received()[0])
+	resp, err := stream.Recv()
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), resp.Committed)
+
+	require.Eventually(t, func() bool {
+		return len(ctl.received()) == 2
+	}, time.Second*5, time.Millisecond*10)
+	// metadata isn't added to the payload which isn't the JSON object
+	require.Equal(t, "raw", ctl.received()[1].SourceName)
+
+	require.NoError(t, stream.CloseSend())
+	p.Commit(ctl.received()[1])
+	resp, err = stream.Recv()
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), resp.Committed)
+
+	_, err = stream.Recv()
+	require.Equal(t, io.EOF, err)
+}
+
+func TestPushGzip(t *testing.T) {
+	p, ctl, client := startPlugin(t, &Config{})
+
+	stream, err := client.Push(context.Background(), grpc.UseCompressor(gzip.Name))
+	require.NoError(t, err)
+	require.NoError(t, stream.Send(&logpb.LogRecord{Payload: []byte("zipped")}))
+	require.NoError(t, stream.CloseSend())
+
+	require.Eventually(t, func() bool {
+		return len(ctl.received()) == 1
+	}, time.Second*5, time.Millisecond*10)
+	require.Equal(t, "zipped", ctl.received()[0].SourceName)
+	p.Commit(ctl.received()[0])
+
+	resp, err := stream.Recv()
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), resp.Committed)
+	_, err = stream.Recv()
+	require.Equal(t, io.EOF, err)
+}
+
+func TestPushErrors(t *testing.T) {
+	_, _, client := startPlugin(t, &Config{MaxMessageSize: "10 b"})
+
+	stream, err := client.Push(context.Background())
+	require.NoError(t, err)
+	require.NoError(t, stream.Send(&logpb.LogRecord{Payload: []byte("too long message")}))
+	_, err = stream.Recv()
+	require.Equal(t, codes.ResourceExhausted, status.Code(err))
+}
@@ -0,0 +1,154 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.4.0
+// - protoc             (unknown)
+// source: plugin/input/grpc/logpb/logs.proto
+
+package logpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.62.0 or later.
+const _ = grpc.SupportPackageIsVersion8
+
+const (
+	LogService_Push_FullMethodName = "/filed.input.v1.LogService/Push"
+)
+
+// LogServiceClient is the client API for LogService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// LogService receives logs pushed to the file.d grpc input plugin.
+type LogServiceClient interface {
+	// Push streams records, each record becomes the event.
+	// The server answers with the number of records committed by the pipeline,
+	// it's sent each time it grows, so clients may limit records which aren't committed yet.
+	// The stream is finished with OK status after all records are committed.
+	Push(ctx context.Context, opts ...grpc.CallOption) (LogService_PushClient, error)
+}
+
+type logServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewLogServiceClient(cc grpc.ClientConnInterface) LogServiceClient {
+	return &logServiceClient{cc}
+}
+
+func (c *logServiceClient) Push(ctx context.Context, opts ...grpc.CallOption) (LogService_PushClient, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &LogService_ServiceDesc.Streams[0], LogService_Push_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &logServicePushClient{ClientStream: stream}
+	return x, nil
+}
+
+type LogService_PushClient interface {
+	Send(*LogRecord) error
+	Recv() (*PushResponse, error)
+	grpc.ClientStream
+}
+
+type logServicePushClient struct {
+	grpc.ClientStream
+}
+
+func (x *logServicePushClient) Send(m *LogRecord) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *logServicePushClient) Recv() (*PushResponse, error) {
+	m := new(PushResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// LogServiceServer is the server API for LogService service.
+// All implementations must embed UnimplementedLogServiceServer
+// for forward compatibility
+//
+// LogService receives logs pushed to the file.d grpc input plugin.
+type LogServiceServer interface {
+	// Push streams records, each record becomes the event.
+	// The server answers with the number of records committed by the pipeline,
+	// it's sent each time it grows, so clients may limit records which aren't committed yet.
+	// The stream is finished with OK status after all records are committed.
+	Push(LogService_PushServer) error
+	mustEmbedUnimplementedLogServiceServer()
+}
+
+// UnimplementedLogServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedLogServiceServer struct {
+}
+
+func (UnimplementedLogServiceServer) Push(LogService_PushServer) error {
+	return status.Errorf(codes.Unimplemented, "method Push not implemented")
+}
+func (UnimplementedLogServiceServer) mustEmbedUnimplementedLogServiceServer() {}
+
+// UnsafeLogServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to LogServiceServer will
+// result in compilation errors.
+type UnsafeLogServiceServer interface {
+	mustEmbedUnimplementedLogServiceServer()
+}
+
+func RegisterLogServiceServer(s grpc.ServiceRegistrar, srv LogServiceServer) {
+	s.RegisterService(&LogService_ServiceDesc, srv)
+}
+
+func _LogService_Push_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(LogServiceServer).Push(&logServicePushServer{ServerStream: stream})
+}
+
+type LogService_PushServer interface {
+	Send(*PushResponse) error
+	Recv() (*LogRecord, error)
+	grpc.ServerStream
+}
+
+type logServicePushServer struct {
+	grpc.ServerStream
+}
+
+func (x *logServicePushServer) Send(m *PushResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *logServicePushServer) Recv() (*LogRecord, error) {
+	m := new(LogRecord)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// LogService_ServiceDesc is the grpc.ServiceDesc for LogService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var LogService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "filed.input.v1.LogService",
+	HandlerType: (*LogServiceServer)(nil),
+	Methods:     []grpc.MethodDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Push",
+			Handler:       _LogService_Push_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "plugin/input/grpc/logpb/logs.proto",
+}
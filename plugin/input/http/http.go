@@ -1,6 +1,7 @@
 package http
 
 import (
+	"errors"
 	"io"
 	"net/http"
 	"strings"
@@ -8,6 +9,7 @@ import (
 	"time"
 
 	"github.com/klauspost/compress/gzip"
+	"github.com/ozontech/file.d/cfg"
 	"github.com/ozontech/file.d/fd"
 	"github.com/ozontech/file.d/metric"
 	"github.com/ozontech/file.d/pipeline"
@@ -23,9 +25,11 @@ Also, it emulates some protocols to allow receiving events from a wide range of
 E.g. `file.d` may pretend to be Elasticsearch allows clients to send events using Elasticsearch protocol.
 So you can use Elasticsearch filebeat output plugin to send data to `file.d`.
 
-> ⚠ Currently event commitment mechanism isn't implemented for this plugin.
-> Plugin answers with HTTP code `OK 200` right after it has read all the request body.
-> It doesn't wait until events are committed.
+The request body is read as a stream, so clients may send newline-delimited JSON by a long-lived chunked request.
+Each line becomes the event as soon as it's read. The body isn't read faster than the pipeline accepts events.
+
+> ⚠ By default the plugin answers with HTTP code `OK 200` right after it has read all the request body.
+> It doesn't wait until events are committed unless `should_wait_commit` is set.
 
 **Example:**
 Emulating elastic through http:
@@ -79,6 +83,11 @@ const (
 	readBufDefaultLen = 16 * 1024
 )
 
+var (
+	errNotCommitted   = errors.New("events aren't committed")
+	errCommitTimedOut = errors.New("events aren't committed within the timeout")
+)
+
 type Plugin struct {
 	mu sync.Mutex
 
@@ -92,6 +101,9 @@ type Plugin struct {
 
 	sourceIDs []pipeline.SourceID
 	sourceSeq pipeline.SourceID
+	// requests are bulk requests waiting for commit by their source ids
+	requests  map[pipeline.SourceID]*bulkRequest
+	isStopped bool
 
 	gzipReaderPool sync.Pool
 	readBuffs      sync.Pool
@@ -103,6 +115,7 @@ type Plugin struct {
 	failedAuthTotal       prometheus.Counter
 	errorsTotal           prometheus.Counter
 	bulkRequestsDoneTotal prometheus.Counter
+	oversizedLinesTotal   prometheus.Counter
 	requestsInProgress    prometheus.Gauge
 	processBulkSeconds    prometheus.Observer
 }
@@ -137,6 +150,27 @@ type Config struct {
 	// > If both ca_cert and private_key are set, the server starts accepting connections in TLS mode.
 	PrivateKey string `json:"private_key" default:""` // *
 
+	// > @3@4@5@6
+	// >
+	// > If set, the plugin answers with HTTP code `OK 200` only after all events of the request are committed.
+	// > Events discarded by actions are considered committed.
+	// > If the plugin is stopped before, it answers with `503 Service Unavailable`,
+	// > if events aren't committed within the `wait_commit_timeout`, it answers with `504 Gateway Timeout`,
+	// > so the client can resend the request.
+	ShouldWaitCommit bool `json:"should_wait_commit" default:"false"` // *
+
+	// > @3@4@5@6
+	// >
+	// > How long the request waits for the commit of its events if `should_wait_commit` is set.
+	WaitCommitTimeout  cfg.Duration `json:"wait_commit_timeout" default:"30s" parse:"duration"` // *
+	WaitCommitTimeout_ time.Duration
+
+	// > @3@4@5@6
+	// >
+	// > The max size of the line of the request body. Longer lines are skipped. Zero means no limit.
+	MaxLineSize  string `json:"max_line_size" default:"10 MB" parse:"data_unit"` // *
+	MaxLineSize_ uint
+
 	// > @3@4@5@6
 	// >
 	// > Auth config.
@@ -198,6 +232,7 @@ func (p *Plugin) Start(config pipeline.AnyConfig, params *pipeline.InputPluginPa
 	p.controller = params.Controller
 	p.controller.DisableStreams()
 	p.sourceIDs = make([]pipeline.SourceID, 0)
+	p.requests = make(map[pipeline.SourceID]*bulkRequest)
 
 	p.server = &http.Server{
 		Addr:    p.config.Address,
@@ -214,6 +249,7 @@ func (p *Plugin) registerMetrics(ctl *metric.Ctl) {
 	p.requestsInProgress = ctl.RegisterGauge("requests_in_progress", "").WithLabelValues()
 	p.processBulkSeconds = ctl.RegisterHistogram("process_bulk_seconds", "", metric.SecondsBucketsDetailed).WithLabelValues()
 	p.errorsTotal = ctl.RegisterCounter("input_http_errors", "Total http errors").WithLabelValues()
+	p.oversizedLinesTotal = ctl.RegisterCounter("input_http_oversized_lines_total", "Total lines skipped due to max_line_size").WithLabelValues()
 
 	if p.config.Auth.Strategy_ != StrategyDisabled {
 		httpAuthTotal := ctl.RegisterCounter("http_auth_success_total", "", "secret_name")
@@ -272,9 +308,111 @@ func (p *Plugin) getSourceID() pipeline.SourceID {
 	return x
 }
 
-func (p *Plugin) putSourceID(x pipeline.SourceID) {
+// bulkRequest counts events of the request to answer after they're committed
+type bulkRequest struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+	// received is the number of events passed to the pipeline
+	received   int64
+	committed  int64
+	isStopped  bool
+	isTimedOut bool
+	// isAbandoned is set if the request is answered before its events are committed
+	isAbandoned bool
+}
+
+func newBulkRequest() *bulkRequest {
+	r := &bulkRequest{}
+	r.cond = sync.NewCond(&r.mu)
+	return r
+}
+
+// reserve returns the offset of the next event
+func (r *bulkRequest) reserve() int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.received++
+	return r.received
+}
+
+// commit counts the committed event, it returns true if the request is abandoned and all its events are committed
+func (r *bulkRequest) commit() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.committed++
+	r.cond.Broadcast()
+	return r.isAbandoned && r.committed >= r.received
+}
+
+// wait waits until all events are committed, the plugin is stopped or the timeout expires
+func (r *bulkRequest) wait(timeout time.Duration) error {
+	timer := time.AfterFunc(timeout, func() {
+		r.mu.Lock()
+		r.isTimedOut = true
+		r.cond.Broadcast()
+		r.mu.Unlock()
+	})
+	defer timer.Stop()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for !r.isStopped && !r.isTimedOut && r.committed < r.received {
+		r.cond.Wait()
+	}
+	switch {
+	case r.committed >= r.received:
+		return nil
+	case r.isStopped:
+		return errNotCommitted
+	default:
+		return errCommitTimedOut
+	}
+}
+
+// abandon marks the request as answered, it returns false if all events are already committed
+func (r *bulkRequest) abandon() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.isAbandoned = r.committed < r.received
+	return r.isAbandoned
+}
+
+func (r *bulkRequest) stop() {
+	r.mu.Lock()
+	r.isStopped = true
+	r.cond.Broadcast()
+	r.mu.Unlock()
+}
+
+func (p *Plugin) addRequest(sourceID pipeline.SourceID) *bulkRequest {
+	r := newBulkRequest()
+
 	p.mu.Lock()
-	p.sourceIDs = append(p.sourceIDs, x)
+	defer p.mu.Unlock()
+
+	p.requests[sourceID] = r
+	if p.isStopped {
+		r.stop()
+	}
+	return r
+}
+
+func (p *Plugin) getRequest(sourceID pipeline.SourceID) *bulkRequest {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.requests[sourceID]
+}
+
+// releaseRequest forgets the request and returns its source id to reuse
+func (p *Plugin) releaseRequest(sourceID pipeline.SourceID) {
+	p.mu.Lock()
+	delete(p.requests, sourceID)
+	p.sourceIDs = append(p.sourceIDs, sourceID)
 	p.mu.Unlock()
 }
 
@@ -368,6 +506,14 @@ func (p *Plugin) serveBulk(w http.ResponseWriter, r *http.Request) {
 
 	if err := p.processBulk(reader); err != nil {
 		p.errorsTotal.Inc()
+		if errors.Is(err, errNotCommitted) {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		if errors.Is(err, errCommitTimedOut) {
+			http.Error(w, err.Error(), http.StatusGatewayTimeout)
+			return
+		}
 		p.logger.Error("http input read error", zap.Error(err))
 		http.Error(w, "http input read error", http.StatusBadRequest)
 		return
@@ -387,7 +533,17 @@ func (p *Plugin) processBulk(r io.Reader) error {
 	defer p.eventBuffs.Put(&eventBuff)
 
 	sourceID := p.getSourceID()
-	defer p.putSourceID(sourceID)
+	var request *bulkRequest
+	if p.config.ShouldWaitCommit {
+		request = p.addRequest(sourceID)
+	}
+	defer func() {
+		// the request with uncommitted events is released after they're committed,
+		// so they aren't counted by the next request with the same source id
+		if request == nil || !request.abandon() {
+			p.releaseRequest(sourceID)
+		}
+	}()
 
 	for {
 		n, err := r.Read(readBuff)
@@ -406,10 +562,16 @@ func (p *Plugin) processBulk(r io.Reader) error {
 		eventBuff = p.processChunk(sourceID, readBuff[:0], eventBuff, true)
 	}
 
+	if request != nil {
+		return request.wait(p.config.WaitCommitTimeout_)
+	}
+
 	return nil
 }
 
 func (p *Plugin) processChunk(sourceID pipeline.SourceID, readBuff []byte, eventBuff []byte, isLastChunk bool) []byte {
+	request := p.getRequest(sourceID)
+
 	pos := 0   // current position
 	nlPos := 0 // new line position
 	for pos < len(readBuff) {
@@ -419,11 +581,11 @@ func (p *Plugin) processChunk(sourceID pipeline.SourceID, readBuff []byte, event
 		}
 
 		if len(eventBuff) != 0 {
-			eventBuff = append(eventBuff, readBuff[nlPos:pos]...)
-			_ = p.controller.In(sourceID, "http", int64(pos), eventBuff, true)
+			eventBuff = p.appendLine(eventBuff, readBuff[nlPos:pos])
+			p.in(request, sourceID, int64(pos), eventBuff)
 			eventBuff = eventBuff[:0]
 		} else {
-			_ = p.controller.In(sourceID, "http", int64(pos), readBuff[nlPos:pos], true)
+			p.in(request, sourceID, int64(pos), readBuff[nlPos:pos])
 		}
 
 		pos++
@@ -432,20 +594,74 @@ func (p *Plugin) processChunk(sourceID pipeline.SourceID, readBuff []byte, event
 
 	if isLastChunk {
 		// flush buffers if we can't find the newline character
-		_ = p.controller.In(sourceID, "http", int64(pos), append(eventBuff, readBuff[nlPos:]...), true)
+		p.in(request, sourceID, int64(pos), p.appendLine(eventBuff, readBuff[nlPos:]))
 		eventBuff = eventBuff[:0]
 	} else {
-		eventBuff = append(eventBuff, readBuff[nlPos:]...)
+		eventBuff = p.appendLine(eventBuff, readBuff[nlPos:])
 	}
 
 	return eventBuff
 }
 
+// appendLine appends the part of the line to the buffer.
+// The line longer than max_line_size is skipped anyway, so only max_line_size+1 bytes of it are kept.
+func (p *Plugin) appendLine(eventBuff []byte, data []byte) []byte {
+	maxLineSize := int(p.config.MaxLineSize_)
+	if maxLineSize > 0 && len(eventBuff)+len(data) > maxLineSize {
+		data = data[:max(0, maxLineSize+1-len(eventBuff))]
+	}
+	return append(eventBuff, data...)
+}
+
+// in passes the line to the pipeline, the offset is the number of the event in the request if the request waits for commit
+func (p *Plugin) in(request *bulkRequest, sourceID pipeline.SourceID, offset int64, line []byte) {
+	if p.config.MaxLineSize_ > 0 && len(line) > int(p.config.MaxLineSize_) {
+		p.oversizedLinesTotal.Inc()
+		return
+	}
+
+	if request == nil {
+		_ = p.controller.In(sourceID, "http", offset, line, true)
+		return
+	}
+
+	if seqID := p.controller.In(sourceID, "http", request.reserve(), line, true); seqID == pipeline.EventSeqIDError {
+		_ = request.commit()
+	}
+}
+
 func (p *Plugin) Stop() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.isStopped = true
+	for _, r := range p.requests {
+		r.stop()
+	}
 }
 
-func (p *Plugin) Commit(_ *pipeline.Event) {
-	// todo: don't reply with OK till all events in request will be committed
+func (p *Plugin) Commit(event *pipeline.Event) {
+	p.done(event)
+}
+
+// Discard counts the event discarded by actions as committed, so the request doesn't wait for it.
+func (p *Plugin) Discard(event *pipeline.Event) {
+	p.done(event)
+}
+
+func (p *Plugin) done(event *pipeline.Event) {
+	if !p.config.ShouldWaitCommit {
+		return
+	}
+
+	request := p.getRequest(event.SourceID)
+	if request == nil {
+		p.logger.Error("no http request for the committed event", zap.Int64("offset", event.Offset))
+		return
+	}
+	if request.commit() {
+		p.releaseRequest(event.SourceID)
+	}
 }
 
 // PassEvent decides pass or discard event.
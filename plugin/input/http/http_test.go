@@ -18,6 +18,7 @@ import (
 	"github.com/ozontech/file.d/cfg"
 	"github.com/ozontech/file.d/pipeline"
 	"github.com/ozontech/file.d/test"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -589,3 +590,174 @@ func TestGzip(t *testing.T) {
 		})
 	}
 }
+
+func TestServeWaitCommit(t *testing.T) {
+	t.Parallel()
+	p, _, output := test.NewPipelineMock(nil, "passive")
+	input := getInputInfo(&Config{Address: ":0", ShouldWaitCommit: true})
+	p.SetInput(input)
+
+	release := make(chan struct{})
+	outEvents := make(chan string, 2)
+	output.SetOutFn(func(event *pipeline.Event) {
+		<-release
+		outEvents <- event.Root.EncodeToString()
+	})
+	p.Start()
+	defer p.Stop()
+
+	resp := httptest.NewRecorder()
+	doneCh := make(chan struct{})
+	go func() {
+		input.Plugin.(*Plugin).ServeHTTP(resp, httptest.NewRequest(http.MethodPost, "/logger", strings.NewReader(`{"a":"1"}`+"\n"+`{"b":"2"}`+"\n")))
+		close(doneCh)
+	}()
+
+	// the answer waits for the commit
+	select {
+	case <-doneCh:
+		t.Fatal("request is done before commit")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(release)
+	<-doneCh
+	require.Equal(t, http.StatusOK, resp.Result().StatusCode)
+	require.Equal(t, `{"a":"1"}`, <-outEvents)
+	require.Equal(t, `{"b":"2"}`, <-outEvents)
+}
+
+func TestServeStopBeforeCommit(t *testing.T) {
+	t.Parallel()
+	p, _, output := test.NewPipelineMock(nil, "passive")
+	input := getInputInfo(&Config{Address: ":0", ShouldWaitCommit: true})
+	p.SetInput(input)
+
+	release := make(chan struct{})
+	output.SetOutFn(func(event *pipeline.Event) {
+		<-release
+	})
+	p.Start()
+	defer p.Stop()
+	defer close(release)
+
+	resp := httptest.NewRecorder()
+	doneCh := make(chan struct{})
+	go func() {
+		input.Plugin.(*Plugin).ServeHTTP(resp, httptest.NewRequest(http.MethodPost, "/logger", strings.NewReader(`{"a":"1"}`+"\n")))
+		close(doneCh)
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	input.Plugin.(*Plugin).Stop()
+	<-doneCh
+	require.Equal(t, http.StatusServiceUnavailable, resp.Result().StatusCode)
+}
+
+func TestServeMaxLineSize(t *testing.T) {
+	t.Parallel()
+	p, _, output := test.NewPipelineMock(nil, "passive")
+	input := getInputInfo(&Config{Address: ":0", MaxLineSize: "12 b", ShouldWaitCommit: true})
+	p.SetInput(input)
+
+	mu := sync.Mutex{}
+	outEvents := make([]string, 0)
+	output.SetOutFn(func(event *pipeline.Event) {
+		mu.Lock()
+		outEvents = append(outEvents, event.Root.EncodeToString())
+		mu.Unlock()
+	})
+	p.Start()
+	defer p.Stop()
+
+	// the long line is split between reads
+	reader := NewPartialReader([]byte(`{"a":"1"}` + "\n" + `{"long":"`))
+	doneCh := make(chan struct{})
+	resp := httptest.NewRecorder()
+	go func() {
+		input.Plugin.(*Plugin).ServeHTTP(resp, httptest.NewRequest(http.MethodPost, "/logger", reader))
+		close(doneCh)
+	}()
+	reader.WaitRead()
+	reader.AppendBody(strings.Repeat("a", 100)+`"}`+"\n"+`{"b":"2"}`+"\n"+`{"c":"too long"}`, true)
+	<-doneCh
+
+	require.Equal(t, http.StatusOK, resp.Result().StatusCode)
+	mu.Lock()
+	defer mu.Unlock()
+	require.Equal(t, []string{`{"a":"1"}`, `{"b":"2"}`}, outEvents)
+	require.Equal(t, float64(2), testutil.ToFloat64(input.Plugin.(*Plugin).oversizedLinesTotal))
+}
+
+type discardAction struct{}
+
+func (a *discardAction) Start(_ pipeline.AnyConfig, _ *pipeline.ActionPluginParams) {}
+func (a *discardAction) Stop()                                                        {}
+func (a *discardAction) Do(_ *pipeline.Event) pipeline.ActionResult {
+	return pipeline.ActionDiscard
+}
+
+func TestServeWaitCommitDiscarded(t *testing.T) {
+	t.Parallel()
+	conds := pipeline.MatchConditions{{Field: []string{"discard"}, Values: []string{"true"}}}
+	factory := func() (pipeline.AnyPlugin, pipeline.AnyConfig) {
+		return &discardAction{}, nil
+	}
+	p, _, output := test.NewPipelineMock(test.NewActionPluginStaticInfo(factory, nil, pipeline.MatchModeAnd, conds, false), "passive")
+	input := getInputInfo(&Config{Address: ":0", ShouldWaitCommit: true, WaitCommitTimeout: "5s"})
+	p.SetInput(input)
+
+	outEvents := make(chan string, 2)
+	output.SetOutFn(func(event *pipeline.Event) {
+		outEvents <- event.Root.EncodeToString()
+	})
+	p.Start()
+	defer p.Stop()
+
+	// the discarded event doesn't block the answer
+	resp := httptest.NewRecorder()
+	body := `{"a":"1"}` + "\n" + `{"discard":"true"}` + "\n" + `{"b":"2"}` + "\n"
+	input.Plugin.(*Plugin).ServeHTTP(resp, httptest.NewRequest(http.MethodPost, "/logger", strings.NewReader(body)))
+
+	require.Equal(t, http.StatusOK, resp.Result().StatusCode)
+	require.Equal(t, `{"a":"1"}`, <-outEvents)
+	require.Equal(t, `{"b":"2"}`, <-outEvents)
+}
+
+func TestServeWaitCommitTimeout(t *testing.T) {
+	t.Parallel()
+	p, _, output := test.NewPipelineMock(nil, "passive")
+	input := getInputInfo(&Config{Address: ":0", ShouldWaitCommit: true, WaitCommitTimeout: "50ms"})
+	p.SetInput(input)
+
+	release := make(chan struct{})
+	output.SetOutFn(func(event *pipeline.Event) {
+		if strings.Contains(event.Root.EncodeToString(), "slow") {
+			<-release
+		}
+	})
+	p.Start()
+	defer p.Stop()
+
+	plugin := input.Plugin.(*Plugin)
+	resp := httptest.NewRecorder()
+	plugin.ServeHTTP(resp, httptest.NewRequest(http.MethodPost, "/logger", strings.NewReader(`{"a":"slow"}`+"\n")))
+	require.Equal(t, http.StatusGatewayTimeout, resp.Result().StatusCode)
+
+	// the source id of the timed out request isn't reused until its events are committed
+	plugin.mu.Lock()
+	require.Len(t, plugin.requests, 1)
+	require.Empty(t, plugin.sourceIDs)
+	plugin.mu.Unlock()
+
+	close(release)
+	require.Eventually(t, func() bool {
+		plugin.mu.Lock()
+		defer plugin.mu.Unlock()
+		return len(plugin.requests) == 0 && len(plugin.sourceIDs) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	resp = httptest.NewRecorder()
+	plugin.ServeHTTP(resp, httptest.NewRequest(http.MethodPost, "/logger", strings.NewReader(`{"b":"2"}`+"\n")))
+	require.Equal(t, http.StatusOK, resp.Result().StatusCode)
+}
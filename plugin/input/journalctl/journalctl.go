@@ -4,8 +4,10 @@ package journalctl
 
 import (
 	"strings"
-	"sync/atomic"
+	"sync"
+	"time"
 
+	"github.com/ozontech/file.d/cfg"
 	"github.com/ozontech/file.d/fd"
 	"github.com/ozontech/file.d/metric"
 	"github.com/ozontech/file.d/offset"
@@ -16,16 +18,29 @@ import (
 
 /*{ introduction
 Reads `journalctl` output.
+
+The cursor of the last committed event is saved to the offsets file, so after restart the reading continues after it.
+The file is written to a temporary one and then renamed, so it's never partially written.
+If the entry of the cursor is rotated or vacuumed, the reading continues from the next available one.
+If the journal can't be read after the saved cursor, e.g. the cursor belongs to another journal,
+the plugin logs a warning and reads from the earliest available entry.
 }*/
 
 type Plugin struct {
 	params        *pipeline.InputPluginParams
 	config        *Config
 	reader        *journalReader
-	offInfo       atomic.Pointer[offsetInfo]
 	currentOffset int64
 	logger        *zap.Logger
 
+	offInfoMu sync.Mutex
+	offInfo   offsetInfo
+	// committedOffset is the offset of the last committed event, events committed out of order don't move the cursor back
+	committedOffset int64
+	isChanged       bool
+	stopCh          chan struct{}
+	stopWg          sync.WaitGroup
+
 	//  plugin metrics
 
 	offsetErrorsMetric        *prometheus.CounterVec
@@ -50,6 +65,15 @@ type Config struct {
 	// >> Have a look at https://man7.org/linux/man-pages/man1/journalctl.1.html
 	JournalArgs []string `json:"journal_args" default:"-f -a"` // *
 
+	// > @3@4@5@6
+	// >
+	// > How often the cursor of committed events is saved to the offsets file.
+	// > A longer interval means fewer writes, but more events are read again after a crash.
+	// > If it's zero the cursor is saved after each committed event.
+	// > > The cursor is always saved on the graceful stop.
+	CheckpointInterval  cfg.Duration `json:"checkpoint_interval" default:"1s" parse:"duration"` // *
+	CheckpointInterval_ time.Duration
+
 	// for testing mostly
 	MaxLines int `json:"max_lines"`
 }
@@ -87,24 +111,61 @@ func (p *Plugin) Start(config pipeline.AnyConfig, params *pipeline.InputPluginPa
 	p.logger = params.Logger.Desugar()
 	p.registerMetrics(params.MetricCtl)
 
-	offInfo := &offsetInfo{}
-	if err := offset.LoadYAML(p.config.OffsetsFile, offInfo); err != nil {
+	p.offInfo = offsetInfo{}
+	if err := offset.LoadYAML(p.config.OffsetsFile, &p.offInfo); err != nil {
 		p.offsetErrorsMetric.WithLabelValues().Inc()
 		p.logger.Error("can't load offset file", zap.Error(err))
 	}
-	p.offInfo.Store(offInfo)
+	p.committedOffset = -1
 
 	readConfig := &journalReaderConfig{
 		output:   p,
-		cursor:   offInfo.Cursor,
+		cursor:   p.offInfo.Cursor,
+		args:     p.config.JournalArgs,
 		maxLines: p.config.MaxLines,
 		logger:   p.logger,
 	}
 	p.reader = newJournalReader(readConfig, p.readerErrorsMetric)
-	p.reader.args = append(p.reader.args, p.config.JournalArgs...)
 	if err := p.reader.start(); err != nil {
 		p.logger.Fatal("failure during start", zap.Error(err))
 	}
+
+	p.stopCh = make(chan struct{})
+	if p.config.CheckpointInterval_ > 0 {
+		p.stopWg.Add(1)
+		go p.checkpoint()
+	}
+}
+
+// checkpoint periodically saves the cursor if it has been changed
+func (p *Plugin) checkpoint() {
+	defer p.stopWg.Done()
+
+	ticker := time.NewTicker(p.config.CheckpointInterval_)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			p.saveOffsets(false)
+		case <-p.stopCh:
+			return
+		}
+	}
+}
+
+func (p *Plugin) saveOffsets(force bool) {
+	p.offInfoMu.Lock()
+	defer p.offInfoMu.Unlock()
+
+	if !p.isChanged && !force {
+		return
+	}
+	if err := offset.SaveYAML(p.config.OffsetsFile, p.offInfo); err != nil {
+		p.offsetErrorsMetric.WithLabelValues().Inc()
+		p.logger.Error("can't save offset file", zap.Error(err))
+		return
+	}
+	p.isChanged = false
 }
 
 func (p *Plugin) registerMetrics(ctl *metric.Ctl) {
@@ -120,21 +181,29 @@ func (p *Plugin) Stop() {
 		p.logger.Error("can't stop journalctl cmd", zap.Error(err))
 	}
 
-	offsets := *p.offInfo.Load()
-	if err := offset.SaveYAML(p.config.OffsetsFile, offsets); err != nil {
-		p.offsetErrorsMetric.WithLabelValues().Inc()
-		p.logger.Error("can't save offset file", zap.Error(err))
-	}
+	close(p.stopCh)
+	p.stopWg.Wait()
+	p.saveOffsets(true)
 }
 
 func (p *Plugin) Commit(event *pipeline.Event) {
-	offInfo := *p.offInfo.Load()
-	offInfo.set(strings.Clone(event.Root.Dig("__CURSOR").AsString()))
-	p.offInfo.Store(&offInfo)
+	cursor := event.Root.Dig("__CURSOR").AsString()
+	if cursor == "" {
+		return
+	}
 
-	if err := offset.SaveYAML(p.config.OffsetsFile, offInfo); err != nil {
-		p.offsetErrorsMetric.WithLabelValues().Inc()
-		p.logger.Error("can't save offset file", zap.Error(err))
+	p.offInfoMu.Lock()
+	if event.Offset <= p.committedOffset {
+		p.offInfoMu.Unlock()
+		return
+	}
+	p.committedOffset = event.Offset
+	p.offInfo.set(strings.Clone(cursor))
+	p.isChanged = true
+	p.offInfoMu.Unlock()
+
+	if p.config.CheckpointInterval_ == 0 {
+		p.saveOffsets(false)
 	}
 }
 
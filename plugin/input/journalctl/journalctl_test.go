@@ -3,16 +3,21 @@
 package journalctl
 
 import (
+	"os"
 	"path/filepath"
 	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/ozontech/file.d/cfg"
+	"github.com/ozontech/file.d/offset"
 	"github.com/ozontech/file.d/pipeline"
 	"github.com/ozontech/file.d/plugin/output/devnull"
 	"github.com/ozontech/file.d/test"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	insaneJSON "github.com/vitkovskii/insane-json"
 )
 
 func setInput(p *pipeline.Pipeline, config *Config) {
@@ -107,3 +112,129 @@ func TestOffsets(t *testing.T) {
 		assert.Equal(t, 1, cnt)
 	}
 }
+
+// fakeJournalctl puts journalctl to the PATH which prints entries with cursors from 1 to 5.
+// It fails for unknown cursors like the real one fails for cursors of another journal.
+func fakeJournalctl(t *testing.T) {
+	dir := t.TempDir()
+	script := `#!/bin/sh
+from=1
+while [ $# -gt 0 ]; do
+	if [ "$1" = "--after-cursor" ]; then
+		case "$2" in
+			c[1-5]) from=$((${2#c} + 1)) ;;
+			*) echo "Failed to seek to cursor: Invalid argument" >&2; exit 1 ;;
+		esac
+	fi
+	shift
+done
+i=$from
+while [ $i -le 5 ]; do
+	echo "{\"__CURSOR\":\"c$i\",\"MESSAGE\":\"message $i\"}"
+	i=$((i + 1))
+done
+sleep 60
+`
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "journalctl"), []byte(script), 0o755))
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func setPlugin(p *pipeline.Pipeline, config *Config) *Plugin {
+	plugin := &Plugin{}
+	p.SetInput(&pipeline.InputPluginInfo{
+		PluginStaticInfo: &pipeline.PluginStaticInfo{
+			Config: config,
+		},
+		PluginRuntimeInfo: &pipeline.PluginRuntimeInfo{
+			Plugin: plugin,
+		},
+	})
+	return plugin
+}
+
+func readCursors(t *testing.T, config *Config, count int) []string {
+	p := test.NewPipeline(nil, "passive")
+	plugin := setPlugin(p, config)
+
+	mu := sync.Mutex{}
+	cursors := make([]string, 0)
+	setOutput(p, func(event *pipeline.Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		cursors = append(cursors, strings.Clone(event.Root.Dig("__CURSOR").AsString()))
+	})
+
+	p.Start()
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(cursors) >= count
+	}, 5*time.Second, 10*time.Millisecond)
+	// the output is called before the commit
+	require.Eventually(t, func() bool {
+		plugin.offInfoMu.Lock()
+		defer plugin.offInfoMu.Unlock()
+		return plugin.committedOffset == int64(count-1)
+	}, 5*time.Second, 10*time.Millisecond)
+	p.Stop()
+
+	mu.Lock()
+	defer mu.Unlock()
+	return cursors
+}
+
+func TestCheckpoint(t *testing.T) {
+	fakeJournalctl(t)
+	offsetPath := filepath.Join(t.TempDir(), "offset.yaml")
+	config := &Config{OffsetsFile: offsetPath, CheckpointInterval: "1h", MaxLines: 3}
+	require.NoError(t, cfg.Parse(config, nil))
+
+	require.Equal(t, []string{"c1", "c2", "c3"}, readCursors(t, config, 3))
+	offInfo := offsetInfo{}
+	require.NoError(t, offset.LoadYAML(offsetPath, &offInfo))
+	require.Equal(t, "c3", offInfo.Cursor)
+
+	// reading continues after the saved cursor
+	config.MaxLines = 0
+	require.Equal(t, []string{"c4", "c5"}, readCursors(t, config, 2))
+}
+
+func TestCheckpointEachCommit(t *testing.T) {
+	fakeJournalctl(t)
+	offsetPath := filepath.Join(t.TempDir(), "offset.yaml")
+	config := &Config{OffsetsFile: offsetPath, CheckpointInterval: "0s"}
+	require.NoError(t, cfg.Parse(config, nil))
+
+	p := test.NewPipeline(nil, "passive")
+	plugin := setPlugin(p, config)
+	setOutput(p, func(event *pipeline.Event) {})
+	p.Start()
+	defer p.Stop()
+
+	savedCursor := func() string {
+		offInfo := offsetInfo{}
+		require.NoError(t, offset.LoadYAML(offsetPath, &offInfo))
+		return offInfo.Cursor
+	}
+	// the cursor is saved without waiting for the stop
+	require.Eventually(t, func() bool {
+		return savedCursor() == "c5"
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// the event committed out of order doesn't move the cursor back
+	event := &pipeline.Event{Root: insaneJSON.Spawn(), Offset: 0}
+	defer insaneJSON.Release(event.Root)
+	require.NoError(t, event.Root.DecodeString(`{"__CURSOR":"c1"}`))
+	plugin.Commit(event)
+	require.Equal(t, "c5", savedCursor())
+}
+
+func TestFallbackToEarliest(t *testing.T) {
+	fakeJournalctl(t)
+	offsetPath := filepath.Join(t.TempDir(), "offset.yaml")
+	require.NoError(t, offset.SaveYAML(offsetPath, offsetInfo{Cursor: "vacuumed"}))
+	config := &Config{OffsetsFile: offsetPath}
+	require.NoError(t, cfg.Parse(config, nil))
+
+	require.Equal(t, []string{"c1", "c2", "c3", "c4", "c5"}, readCursors(t, config, 5))
+}
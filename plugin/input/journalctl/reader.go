@@ -7,6 +7,7 @@ import (
 	"io"
 	"os/exec"
 	"strings"
+	"sync"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"go.uber.org/zap"
@@ -15,14 +16,17 @@ import (
 type journalReaderConfig struct {
 	output   io.Writer
 	cursor   string
+	args     []string
 	logger   *zap.Logger
 	maxLines int
 }
 
 type journalReader struct {
 	config *journalReaderConfig
-	cmd    *exec.Cmd
-	args   []string
+
+	mu        sync.Mutex
+	cmd       *exec.Cmd
+	isStopped bool
 
 	// reader metrics
 	readerErrorsMetric *prometheus.CounterVec
@@ -32,15 +36,6 @@ func (r *journalReader) readLines(rd io.Reader, config *journalReaderConfig) {
 	reader := bufio.NewReaderSize(rd, 1024*1024*10) // max message size
 	totalLines := 0
 
-	// cursor will point to the last message, that we sent
-	// so we'll skip it, because we already sent it
-	if config.cursor != "" {
-		_, _, err := reader.ReadLine()
-		if err != nil {
-			r.config.logger.Fatal(err.Error())
-		}
-	}
-
 	for {
 		bytes, err := reader.ReadBytes('\n')
 		if err == io.EOF {
@@ -62,27 +57,66 @@ func (r *journalReader) readLines(rd io.Reader, config *journalReaderConfig) {
 			break
 		}
 	}
+
+	if totalLines == 0 && config.cursor != "" {
+		r.fallback()
+	}
+}
+
+// fallback restarts journalctl from the earliest available entry if it can't read from the cursor,
+// e.g. the cursor is malformed or belongs to another journal
+func (r *journalReader) fallback() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.isStopped {
+		return
+	}
+	err := r.cmd.Wait()
+	if err == nil {
+		return
+	}
+
+	r.config.logger.Warn("can't read journal after the cursor, reading from the earliest available entry",
+		zap.String("cursor", r.config.cursor), zap.Error(err))
+	r.config.cursor = ""
+	if err := r.run(); err != nil {
+		r.readerErrorsMetric.WithLabelValues().Inc()
+		r.config.logger.Error("can't restart journalctl", zap.Error(err))
+	}
 }
 
 func newJournalReader(config *journalReaderConfig, readerErrorsCounter *prometheus.CounterVec) *journalReader {
-	res := &journalReader{
+	return &journalReader{
 		config:             config,
 		readerErrorsMetric: readerErrorsCounter,
 	}
-	res.args = []string{
+}
+
+func (r *journalReader) args() []string {
+	args := []string{
 		"-o", "json",
 	}
-	if config.cursor != "" {
-		res.args = append(res.args, "-c", config.cursor)
+	// if the entry of the cursor is rotated or vacuumed, journalctl starts from the next available one
+	if r.config.cursor != "" {
+		args = append(args, "--after-cursor", r.config.cursor)
 	} else {
-		res.args = append(res.args, "-n", "all")
+		args = append(args, "-n", "all")
 	}
-	return res
+	return append(args, r.config.args...)
 }
 
 func (r *journalReader) start() error {
-	r.config.logger.Info(`running journalctl`, zap.String("args", strings.Join(r.args, " ")))
-	r.cmd = exec.Command("journalctl", r.args...)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.run()
+}
+
+func (r *journalReader) run() error {
+	args := r.args()
+	r.config.logger.Info(`running journalctl`, zap.String("args", strings.Join(args, " ")))
+	r.cmd = exec.Command("journalctl", args...)
 
 	out, err := r.cmd.StdoutPipe()
 	if err != nil {
@@ -99,5 +133,9 @@ func (r *journalReader) start() error {
 }
 
 func (r *journalReader) stop() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.isStopped = true
 	return r.cmd.Process.Kill()
 }
@@ -58,6 +58,9 @@ type Config struct {
 	// >
 	// > Docker splits long logs by 16kb chunks. The plugin joins them back, but if an event is longer than this value in bytes, it will be split after all.
 	// > > Due to the optimization process it's not a strict rule. Events may be split even if they won't exceed the limit.
+	// >
+	// > For CRI runtimes partial lines are joined by the [file plugin](/plugin/input/file/README.md) before decoding,
+	// > their max size is set by `cri_max_line_size` of the `file_config`.
 	SplitEventSize int `json:"split_event_size" default:"1000000"` // *
 
 	// > @3@4@5@6
@@ -107,8 +110,9 @@ func init() {
 		},
 	})
 	fd.DefaultPluginRegistry.RegisterAction(&pipeline.PluginStaticInfo{
-		Type:    "k8s-multiline",
-		Factory: MultilineActionFactory,
+		Type:           "k8s-multiline",
+		Factory:        MultilineActionFactory,
+		StreamRequired: true,
 	})
 }
 
@@ -136,6 +140,8 @@ func (p *Plugin) Start(config pipeline.AnyConfig, params *pipeline.InputPluginPa
 		p.params.Controller.SuggestDecoder(decoder.JSON)
 	} else {
 		p.params.Controller.SuggestDecoder(decoder.CRI)
+		// CRI runtimes split long lines into partial chunks, join them before decoding
+		p.config.FileConfig.ShouldJoinCRILines = true
 	}
 
 	p.fp.Start(&p.config.FileConfig, params)
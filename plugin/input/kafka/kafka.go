@@ -48,6 +48,12 @@ type Plugin struct {
 	controller    pipeline.InputPluginController
 	idByTopic     map[string]int
 
+	// static assignment
+	client           sarama.Client
+	consumer         sarama.Consumer
+	offsetManager    sarama.OffsetManager
+	partitionOffsets map[pipeline.SourceID]sarama.PartitionOffsetManager
+
 	// plugin metrics
 
 	commitErrorsMetric  *prometheus.CounterVec
@@ -83,6 +89,23 @@ type Config struct {
 	// > * *`newest`* - set offset to the newest message
 	// > * *`oldest`* - set offset to the oldest message
 	Offset string `json:"offset" default:"newest" options:"oldest|newest"` // *
+
+	// > @3@4@5@6
+	// >
+	// > How partitions are assigned to the instance:
+	// > @assignmentMode|comment-list
+	// >
+	// > The static mode avoids rebalancing when many instances are deployed at once,
+	// > but instances should be configured with different partitions.
+	AssignmentMode  string `json:"assignment_mode" default:"group" options:"group|static"` // *
+	AssignmentMode_ assignmentMode
+
+	// > @3@4@5@6
+	// >
+	// > The list of partitions to read from if `assignment_mode` is `static`.
+	// > Each item is either `topic:partition` or `topic:first-last` range of partitions, e.g. `[topic1:0, topic2:4-7]`.
+	// > Topics should be listed in `topics`.
+	Partitions []string `json:"partitions" slice:"true"` // *
 }
 
 func init() {
@@ -109,10 +132,15 @@ func (p *Plugin) Start(config pipeline.AnyConfig, params *pipeline.InputPluginPa
 
 	ctx, cancel := context.WithCancel(context.Background())
 	p.cancel = cancel
-	p.consumerGroup = p.newConsumerGroup()
 	p.controller.UseSpread()
 	p.controller.DisableStreams()
 
+	if p.config.AssignmentMode_ == assignmentModeStatic {
+		p.startStatic(ctx)
+		return
+	}
+
+	p.consumerGroup = p.newConsumerGroup()
 	go p.consume(ctx)
 }
 
@@ -141,6 +169,17 @@ func (p *Plugin) Stop() {
 }
 
 func (p *Plugin) Commit(event *pipeline.Event) {
+	if p.config.AssignmentMode_ == assignmentModeStatic {
+		pom, has := p.partitionOffsets[event.SourceID]
+		if !has {
+			p.commitErrorsMetric.WithLabelValues().Inc()
+			p.logger.Errorf("no kafka partition for event commit")
+			return
+		}
+		pom.MarkOffset(event.Offset+1, "")
+		return
+	}
+
 	if p.session == nil {
 		p.commitErrorsMetric.WithLabelValues().Inc()
 		p.logger.Errorf("no kafka consumer session for event commit")
@@ -150,7 +189,7 @@ func (p *Plugin) Commit(event *pipeline.Event) {
 	p.session.MarkOffset(p.config.Topics[index], partition, event.Offset+1, "")
 }
 
-func (p *Plugin) newConsumerGroup() sarama.ConsumerGroup {
+func (p *Plugin) newSaramaConfig() *sarama.Config {
 	config := sarama.NewConfig()
 	config.Consumer.Group.Rebalance.Strategy = sarama.BalanceStrategyRoundRobin
 	config.Version = sarama.V0_10_2_0
@@ -165,6 +204,11 @@ func (p *Plugin) newConsumerGroup() sarama.ConsumerGroup {
 		p.logger.Fatalf("unexpected value of the offset field: %s", p.config.Offset)
 	}
 
+	return config
+}
+
+func (p *Plugin) newConsumerGroup() sarama.ConsumerGroup {
+	config := p.newSaramaConfig()
 	consumerGroup, err := sarama.NewConsumerGroup(p.config.Brokers, p.config.ConsumerGroup, config)
 	if err != nil {
 		p.logger.Fatalf("can't create kafka consumer: %s", err.Error())
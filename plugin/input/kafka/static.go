@@ -0,0 +1,148 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/Shopify/sarama"
+	"github.com/ozontech/file.d/pipeline"
+)
+
+type assignmentMode int
+
+const (
+	// ! "assignmentMode" #1 /`([a-z]+)`/
+	assignmentModeGroup  assignmentMode = iota // * `group` – partitions are assigned by the consumer group, they're rebalanced when instances join or leave the group
+	assignmentModeStatic                       // * `static` – the instance consumes partitions listed in `partitions`, offsets are committed to the consumer group without joining it
+)
+
+type topicPartition struct {
+	topic     string
+	partition int32
+}
+
+// parsePartitions parses `topic:partition` and `topic:first-last` items, topics should be known
+func parsePartitions(items []string, idByTopic map[string]int) ([]topicPartition, error) {
+	partitions := make([]topicPartition, 0, len(items))
+	seen := make(map[topicPartition]bool, len(items))
+	for _, item := range items {
+		pos := strings.LastIndexByte(item, ':')
+		if pos == -1 {
+			return nil, fmt.Errorf("partition %q should be in the topic:partition format", item)
+		}
+		topic := item[:pos]
+		if _, has := idByTopic[topic]; !has {
+			return nil, fmt.Errorf("topic of partition %q isn't listed in topics", item)
+		}
+
+		first, last, isRange := strings.Cut(item[pos+1:], "-")
+		from, err := strconv.ParseInt(first, 10, 32)
+		if err != nil || from < 0 {
+			return nil, fmt.Errorf("wrong partition number of %q", item)
+		}
+		to := from
+		if isRange {
+			to, err = strconv.ParseInt(last, 10, 32)
+			if err != nil || to < from {
+				return nil, fmt.Errorf("wrong partition range of %q", item)
+			}
+		}
+
+		for partition := from; partition <= to; partition++ {
+			tp := topicPartition{topic: topic, partition: int32(partition)}
+			if seen[tp] {
+				return nil, fmt.Errorf("partition %d of topic %q is listed twice", partition, topic)
+			}
+			seen[tp] = true
+			partitions = append(partitions, tp)
+		}
+	}
+
+	return partitions, nil
+}
+
+// startStatic consumes the partitions listed in the config bypassing the consumer group rebalancing
+func (p *Plugin) startStatic(ctx context.Context) {
+	partitions, err := parsePartitions(p.config.Partitions, p.idByTopic)
+	if err != nil {
+		p.logger.Fatalf("can't parse partitions: %s", err.Error())
+	}
+	if len(partitions) == 0 {
+		p.logger.Fatalf("partitions should be set if assignment_mode is static")
+	}
+
+	config := p.newSaramaConfig()
+	config.Consumer.Return.Errors = true
+	p.client, err = sarama.NewClient(p.config.Brokers, config)
+	if err != nil {
+		p.logger.Fatalf("can't create kafka client: %s", err.Error())
+	}
+
+	p.offsetManager, err = sarama.NewOffsetManagerFromClient(p.config.ConsumerGroup, p.client)
+	if err != nil {
+		p.logger.Fatalf("can't create kafka offset manager: %s", err.Error())
+	}
+
+	p.consumer, err = sarama.NewConsumerFromClient(p.client)
+	if err != nil {
+		p.logger.Fatalf("can't create kafka consumer: %s", err.Error())
+	}
+
+	p.partitionOffsets = make(map[pipeline.SourceID]sarama.PartitionOffsetManager, len(partitions))
+	for _, tp := range partitions {
+		pom, err := p.offsetManager.ManagePartition(tp.topic, tp.partition)
+		if err != nil {
+			p.logger.Fatalf("can't get offset of partition %d of topic %q: %s", tp.partition, tp.topic, err.Error())
+		}
+		p.partitionOffsets[assembleSourceID(p.idByTopic[tp.topic], tp.partition)] = pom
+
+		offset, _ := pom.NextOffset()
+		pc, err := p.consumer.ConsumePartition(tp.topic, tp.partition, offset)
+		if errors.Is(err, sarama.ErrOffsetOutOfRange) {
+			p.logger.Warnf("committed offset %d of partition %d of topic %q is out of range, the initial offset is used", offset, tp.partition, tp.topic)
+			pc, err = p.consumer.ConsumePartition(tp.topic, tp.partition, config.Consumer.Offsets.Initial)
+		}
+		if err != nil {
+			p.logger.Fatalf("can't consume partition %d of topic %q: %s", tp.partition, tp.topic, err.Error())
+		}
+
+		go p.consumePartition(pc)
+	}
+
+	p.logger.Infof("kafka input reading %d static partitions: %s", len(partitions), strings.Join(p.config.Partitions, ","))
+
+	go func() {
+		<-ctx.Done()
+		if err := p.consumer.Close(); err != nil {
+			p.logger.Errorf("can't close kafka consumer: %s", err.Error())
+		}
+		// the offset manager commits the marked offsets on close
+		for _, pom := range p.partitionOffsets {
+			pom.AsyncClose()
+		}
+		if err := p.offsetManager.Close(); err != nil {
+			p.commitErrorsMetric.WithLabelValues().Inc()
+			p.logger.Errorf("can't close kafka offset manager: %s", err.Error())
+		}
+		if err := p.client.Close(); err != nil {
+			p.logger.Errorf("can't close kafka client: %s", err.Error())
+		}
+	}()
+}
+
+func (p *Plugin) consumePartition(pc sarama.PartitionConsumer) {
+	go func() {
+		for err := range pc.Errors() {
+			p.consumeErrorsMetric.WithLabelValues().Inc()
+			p.logger.Errorf("can't consume from kafka: %s", err.Error())
+		}
+	}()
+
+	for message := range pc.Messages() {
+		sourceID := assembleSourceID(p.idByTopic[message.Topic], message.Partition)
+		_ = p.controller.In(sourceID, "kafka", message.Offset, message.Value, true)
+	}
+}
@@ -0,0 +1,125 @@
+package kafka
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/ozontech/file.d/decoder"
+	"github.com/ozontech/file.d/pipeline"
+	"github.com/ozontech/file.d/test"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePartitions(t *testing.T) {
+	idByTopic := map[string]int{"logs": 0, "ns:logs": 1}
+
+	partitions, err := parsePartitions([]string{"logs:0", "logs:3-5", "ns:logs:1"}, idByTopic)
+	require.NoError(t, err)
+	require.Equal(t, []topicPartition{
+		{topic: "logs", partition: 0},
+		{topic: "logs", partition: 3},
+		{topic: "logs", partition: 4},
+		{topic: "logs", partition: 5},
+		{topic: "ns:logs", partition: 1},
+	}, partitions)
+
+	for _, items := range [][]string{
+		{"logs"},
+		{"other:0"},
+		{"logs:a"},
+		{"logs:-1"},
+		{"logs:5-3"},
+		{"logs:1-b"},
+		{"logs:0", "logs:0-1"},
+	} {
+		_, err := parsePartitions(items, idByTopic)
+		require.Error(t, err, "no error for %v", items)
+	}
+}
+
+type controller struct {
+	mu     sync.Mutex
+	events []*pipeline.Event
+}
+
+func (c *controller) In(sourceID pipeline.SourceID, _ string, offset int64, data []byte, _ bool) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.events = append(c.events, &pipeline.Event{SourceID: sourceID, Offset: offset, SourceName: string(data)})
+	return uint64(len(c.events))
+}
+
+func (c *controller) received() []*pipeline.Event {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]*pipeline.Event(nil), c.events...)
+}
+
+func (c *controller) UseSpread()                               {}
+func (c *controller) DisableStreams()                          {}
+func (c *controller) SuggestDecoder(_ decoder.DecoderType)     {}
+func (c *controller) IncReadOps()                              {}
+func (c *controller) IncMaxEventSizeExceeded()                 {}
+func (c *controller) IsSourceLimited(_ pipeline.SourceID) bool { return false }
+
+func TestStaticAssignment(t *testing.T) {
+	broker := sarama.NewMockBroker(t, 1)
+	defer broker.Close()
+
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetLeader("logs", 0, broker.BrokerID()).
+			SetLeader("logs", 1, broker.BrokerID()),
+		"FindCoordinatorRequest": sarama.NewMockFindCoordinatorResponse(t).
+			SetCoordinator(sarama.CoordinatorGroup, "file-d", broker),
+		"OffsetFetchRequest": sarama.NewMockOffsetFetchResponse(t).
+			SetOffset("file-d", "logs", 1, 5, "", sarama.ErrNoError),
+		"OffsetRequest": sarama.NewMockOffsetResponse(t).
+			SetOffset("logs", 1, sarama.OffsetOldest, 0).
+			SetOffset("logs", 1, sarama.OffsetNewest, 7),
+		"FetchRequest": sarama.NewMockFetchResponse(t, 1).
+			SetMessage("logs", 1, 5, sarama.StringEncoder(`{"n":5}`)).
+			SetMessage("logs", 1, 6, sarama.StringEncoder(`{"n":6}`)).
+			SetHighWaterMark("logs", 1, 7),
+		"OffsetCommitRequest": sarama.NewMockOffsetCommitResponse(t),
+	})
+
+	config := &Config{
+		Brokers:        []string{broker.Addr()},
+		Topics:         []string{"logs"},
+		AssignmentMode: "static",
+		Partitions:     []string{"logs:1"},
+	}
+	ctl := &controller{}
+	p := &Plugin{}
+	p.Start(test.NewConfig(config, nil), test.NewEmptyInputPluginParams(ctl))
+
+	// reading starts from the committed offset
+	require.Eventually(t, func() bool {
+		return len(ctl.received()) >= 2
+	}, 5*time.Second, 10*time.Millisecond)
+	events := ctl.received()
+	require.Equal(t, `{"n":5}`, events[0].SourceName)
+	require.Equal(t, int64(5), events[0].Offset)
+	require.Equal(t, assembleSourceID(0, 1), events[0].SourceID)
+
+	p.Commit(events[1])
+	p.Stop()
+
+	// the marked offset is committed on stop
+	require.Eventually(t, func() bool {
+		for _, rr := range broker.History() {
+			req, ok := rr.Request.(*sarama.OffsetCommitRequest)
+			if !ok || req.ConsumerGroup != "file-d" {
+				continue
+			}
+			if offset, _, err := req.Offset("logs", 1); err == nil && offset == 7 {
+				return true
+			}
+		}
+		return false
+	}, 5*time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,526 @@
+package mqtt
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/ozontech/file.d/cfg"
+	"github.com/ozontech/file.d/fd"
+	"github.com/ozontech/file.d/metric"
+	"github.com/ozontech/file.d/pipeline"
+	"github.com/ozontech/file.d/xtls"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/atomic"
+	"go.uber.org/zap"
+)
+
+/*{ introduction
+It subscribes to MQTT topics by the [Eclipse Paho](https://github.com/eclipse/paho.mqtt.golang) client
+and reads messages as events, it supports MQTT 3.1.1.
+The topic of the message is added to the event field `topic_field`.
+
+> For QoS 1 and 2 it guarantees "at-least-once delivery":
+> the message is acknowledged (PUBACK or PUBREC) only after its event is committed.
+> Acknowledgments are sent in the order messages are received, as MQTT requires.
+
+Use `clean_session: false` and the unique `client_id`,
+so the broker keeps the session and messages while file.d is down.
+
+**Example**
+```yaml
+pipelines:
+  example_pipeline:
+    input:
+      type: mqtt
+      brokers: [tcp://mqtt-1:1883]
+      topics: [sensors/+/telemetry]
+      client_id: file-d-1
+      qos: 1
+    output:
+      type: stdout
+```
+}*/
+
+const (
+	inPluginType    = "mqtt"
+	topicActionType = "mqtt-topic"
+
+	protocolVersion = 4 // MQTT 3.1.1
+
+	minReconnectBackoff = 100 * time.Millisecond
+	maxReconnectBackoff = 10 * time.Second
+
+	// roomCheckInterval is how often the connection is checked while waiting for room
+	roomCheckInterval = 100 * time.Millisecond
+
+	subscriptionFailure = 0x80
+)
+
+var errConnectionLost = errors.New("connection is lost")
+
+type Plugin struct {
+	config     *Config
+	logger     *zap.SugaredLogger
+	controller pipeline.InputPluginController
+	tlsConfig  *tls.Config
+	brokers    []string
+	cancel     context.CancelFunc
+
+	offset *atomic.Int64
+	// inFlight holds messages by the event offset until they are acknowledged,
+	// it's guarded by inFlightMu as well as session and ackFrom
+	inFlight   map[int64]*inFlightMessage
+	inFlightMu *sync.Mutex
+	// room is signaled when the acknowledged messages leave inFlight
+	room chan struct{}
+	// session is the current connection, messages of the previous connections aren't acknowledged
+	session *session
+	// ackFrom is the offset of the oldest message which isn't acknowledged yet
+	ackFrom int64
+
+	// plugin metrics
+
+	connectErrorsMetric *prometheus.CounterVec
+	ackErrorsMetric     *prometheus.CounterVec
+}
+
+type inFlightMessage struct {
+	msg       mqtt.Message
+	committed bool
+}
+
+// session is the client of the single connection, since the client sends the acknowledgments
+// of the previous connection by the next one. The acknowledgments are sent by the separate goroutine,
+// since they block while the connection is broken.
+type session struct {
+	client mqtt.Client
+	// lost is closed when the connection is lost, err is the reason
+	lost chan struct{}
+	err  error
+
+	// acks are the messages to acknowledge in order, they're guarded by the inFlightMu
+	acks      []mqtt.Message
+	ackSignal chan struct{}
+}
+
+// ! config-params
+// ^ config-params
+type Config struct {
+	// > @3@4@5@6
+	// >
+	// > MQTT broker URLs, they are used in turn to connect and reconnect, e.g. `tcp://localhost:1883`.
+	// > Schemes `ssl`, `tls` and `mqtts` enable TLS.
+	Brokers []string `json:"brokers" required:"true"` // *
+
+	// > @3@4@5@6
+	// >
+	// > Topic filters to subscribe to, wildcards `+` and `#` are allowed.
+	Topics []string `json:"topics" required:"true"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The event field to put the message topic. If it's empty, the topic isn't added.
+	TopicField string `json:"topic_field" default:"mqtt_topic"` // *
+
+	// > @3@4@5@6
+	// >
+	// > QoS of subscriptions, one of `0`, `1` or `2`.
+	QoS int `json:"qos" default:"1"` // *
+
+	// > @3@4@5@6
+	// >
+	// > MQTT client identifier, it should be unique for the broker.
+	ClientID string `json:"client_id" default:"file-d"` // *
+
+	// > @3@4@5@6
+	// >
+	// > If set, the broker discards the session on disconnect,
+	// > otherwise subscriptions and not acknowledged messages are kept until reconnect.
+	CleanSession bool `json:"clean_session" default:"false"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The max number of received messages which aren't acknowledged yet.
+	// > The plugin stops reading from the broker when it's reached,
+	// > so the connection is reestablished by the keep alive timeout if the events aren't committed for long.
+	MaxInFlight int `json:"max_in_flight" default:"1024"` // *
+
+	// > @3@4@5@6
+	// >
+	// > Keep alive interval of the connection.
+	KeepAlive  cfg.Duration `json:"keep_alive" default:"30s" parse:"duration"` // *
+	KeepAlive_ time.Duration
+
+	// > @3@4@5@6
+	// >
+	// > User name for the authentication.
+	Username string `json:"username"` // *
+
+	// > @3@4@5@6
+	// >
+	// > Password for the authentication.
+	Password string `json:"password"` // *
+
+	// > @3@4@5@6
+	// >
+	// > If set, the connection uses TLS.
+	TLSEnabled bool `json:"tls_enabled" default:"false"` // *
+
+	// > @3@4@5@6
+	// >
+	// > CA certificate in PEM encoding or a path to the file to verify the broker certificate.
+	CACert string `json:"ca_cert"` // *
+
+	// > @3@4@5@6
+	// >
+	// > Client certificate in PEM encoding or a path to the file for the mutual TLS.
+	ClientCert string `json:"client_cert"` // *
+
+	// > @3@4@5@6
+	// >
+	// > Client private key in PEM encoding or a path to the file for the mutual TLS.
+	ClientKey string `json:"client_key"` // *
+}
+
+func init() {
+	fd.DefaultPluginRegistry.RegisterInput(&pipeline.PluginStaticInfo{
+		Type:              inPluginType,
+		Factory:           Factory,
+		AdditionalActions: []string{topicActionType},
+	})
+	fd.DefaultPluginRegistry.RegisterAction(&pipeline.PluginStaticInfo{
+		Type:    topicActionType,
+		Factory: TopicActionFactory,
+	})
+}
+
+func Factory() (pipeline.AnyPlugin, pipeline.AnyConfig) {
+	return &Plugin{}, &Config{}
+}
+
+func (p *Plugin) Start(config pipeline.AnyConfig, params *pipeline.InputPluginParams) {
+	p.controller = params.Controller
+	p.logger = params.Logger
+	p.config = config.(*Config)
+	p.registerMetrics(params.MetricCtl)
+
+	if p.config.QoS < 0 || p.config.QoS > 2 {
+		p.logger.Fatalf("qos should be 0, 1 or 2")
+	}
+	if p.config.MaxInFlight <= 0 {
+		p.logger.Fatalf("max_in_flight should be greater than 0")
+	}
+	if p.config.ClientID == "" && !p.config.CleanSession {
+		p.logger.Fatalf("client_id should be set for the persistent session")
+	}
+
+	if p.config.TLSEnabled || p.config.CACert != "" || p.config.ClientCert != "" {
+		b := xtls.NewConfigBuilder()
+		if p.config.CACert != "" {
+			if err := b.AppendCARoot(p.config.CACert); err != nil {
+				p.logger.Fatalf("can't append CA root: %s", err.Error())
+			}
+		}
+		if p.config.ClientCert != "" {
+			if err := b.AppendX509KeyPair(p.config.ClientCert, p.config.ClientKey); err != nil {
+				p.logger.Fatalf("can't append client certificate: %s", err.Error())
+			}
+		}
+		p.tlsConfig = b.Build()
+	}
+
+	for _, broker := range p.config.Brokers {
+		u, err := p.brokerURL(broker)
+		if err != nil {
+			p.logger.Fatalf("wrong broker: %s", err.Error())
+		}
+		p.brokers = append(p.brokers, u)
+	}
+
+	p.offset = atomic.NewInt64(0)
+	p.inFlight = make(map[int64]*inFlightMessage, p.config.MaxInFlight)
+	p.inFlightMu = &sync.Mutex{}
+	p.room = make(chan struct{}, 1)
+
+	p.controller.UseSpread()
+	p.controller.DisableStreams()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	p.cancel = cancel
+
+	go p.run(ctx)
+}
+
+func (p *Plugin) registerMetrics(ctl *metric.Ctl) {
+	p.connectErrorsMetric = ctl.RegisterCounter("input_mqtt_connect_errors", "Number of MQTT connection errors")
+	p.ackErrorsMetric = ctl.RegisterCounter("input_mqtt_ack_errors", "Number of MQTT acknowledgments which aren't sent since the connection is lost")
+}
+
+// run keeps the connection and reconnects with backoff if it's broken.
+// After the stop it keeps the connection to acknowledge committed messages, but new messages are ignored
+func (p *Plugin) run(ctx context.Context) {
+	backoff := minReconnectBackoff
+	for i := 0; ; i++ {
+		broker := p.brokers[i%len(p.brokers)]
+		s := p.newSession(ctx, broker)
+
+		// messages of the previous connection are delivered again by the broker
+		p.inFlightMu.Lock()
+		if p.session != nil {
+			p.ackErrorsMetric.WithLabelValues().Add(float64(len(p.session.acks)))
+		}
+		p.session = s
+		p.ackFrom = p.offset.Load() + 1
+		clear(p.inFlight)
+		p.inFlightMu.Unlock()
+
+		err := p.connect(s)
+		if err == nil {
+			p.logger.Infof("connected to mqtt broker %s", broker)
+			backoff = minReconnectBackoff
+			go p.sendAcks(s)
+
+			<-s.lost
+			err = s.err
+		}
+
+		if ctx.Err() != nil {
+			return
+		}
+
+		p.connectErrorsMetric.WithLabelValues().Inc()
+		p.logger.Errorf("mqtt connection to %s failed, reconnecting in %s: %s", broker, backoff, err.Error())
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, maxReconnectBackoff)
+	}
+}
+
+// brokerURL sets the default port and the TLS scheme if the TLS is enabled, since the client uses TLS by the scheme only
+func (p *Plugin) brokerURL(broker string) (string, error) {
+	u, err := url.Parse(broker)
+	if err != nil || u.Host == "" {
+		return "", fmt.Errorf("can't parse broker url %q", broker)
+	}
+
+	switch u.Scheme {
+	case "ssl", "tls", "mqtts":
+	case "tcp", "mqtt":
+		if p.tlsConfig != nil {
+			u.Scheme = "ssl"
+		}
+	default:
+		return "", fmt.Errorf("unknown scheme of broker url %q", broker)
+	}
+
+	if u.Port() == "" {
+		port := "1883"
+		if u.Scheme == "ssl" || u.Scheme == "tls" || u.Scheme == "mqtts" {
+			port = "8883"
+		}
+		u.Host = net.JoinHostPort(u.Hostname(), port)
+	}
+
+	return u.String(), nil
+}
+
+func (p *Plugin) newSession(ctx context.Context, broker string) *session {
+	s := &session{
+		lost:      make(chan struct{}),
+		ackSignal: make(chan struct{}, 1),
+	}
+
+	opts := mqtt.NewClientOptions().
+		AddBroker(broker).
+		SetProtocolVersion(protocolVersion).
+		SetClientID(p.config.ClientID).
+		SetUsername(p.config.Username).
+		SetPassword(p.config.Password).
+		SetCleanSession(p.config.CleanSession).
+		SetKeepAlive(p.config.KeepAlive_).
+		SetTLSConfig(p.tlsConfig).
+		SetAutoReconnect(false).
+		SetAutoAckDisabled(true).
+		// the handler is called in the order of the messages, it blocks the client while there is no room
+		SetOrderMatters(true).
+		SetDefaultPublishHandler(func(_ mqtt.Client, msg mqtt.Message) {
+			p.handle(ctx, s, msg)
+		}).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			s.err = err
+			close(s.lost)
+		})
+	s.client = mqtt.NewClient(opts)
+
+	return s
+}
+
+// connect connects and subscribes to the topics, the messages of the persistent session may arrive before the subscription
+func (p *Plugin) connect(s *session) error {
+	token := s.client.Connect()
+	if token.Wait(); token.Error() != nil {
+		return token.Error()
+	}
+
+	filters := make(map[string]byte, len(p.config.Topics))
+	for _, topic := range p.config.Topics {
+		filters[topic] = byte(p.config.QoS)
+	}
+	sub := s.client.SubscribeMultiple(filters, nil)
+	if sub.Wait(); sub.Error() != nil {
+		s.client.Disconnect(0)
+		return fmt.Errorf("can't subscribe: %w", sub.Error())
+	}
+	for topic, code := range sub.(*mqtt.SubscribeToken).Result() {
+		if code == subscriptionFailure {
+			s.client.Disconnect(0)
+			return fmt.Errorf("subscription to %q is rejected", topic)
+		}
+	}
+
+	return nil
+}
+
+// waitForRoom returns false if the connection is lost or isn't current
+func (p *Plugin) waitForRoom(ctx context.Context, s *session) bool {
+	for {
+		if !s.client.IsConnectionOpen() {
+			return false
+		}
+
+		p.inFlightMu.Lock()
+		current := p.session == s
+		// after the stop new messages are ignored, so there is always room for them
+		hasRoom := ctx.Err() != nil || len(p.inFlight) < p.config.MaxInFlight
+		p.inFlightMu.Unlock()
+
+		if !current {
+			return false
+		}
+		if hasRoom {
+			return true
+		}
+
+		select {
+		case <-p.room:
+		case <-time.After(roomCheckInterval):
+		}
+	}
+}
+
+func (p *Plugin) handle(ctx context.Context, s *session, msg mqtt.Message) {
+	// the message isn't acknowledged, so it will be delivered again
+	if !p.waitForRoom(ctx, s) || ctx.Err() != nil {
+		return
+	}
+
+	// the message is stored before In, since the event may be committed before In returns
+	offset := p.offset.Inc()
+	p.inFlightMu.Lock()
+	if p.session != s {
+		p.inFlightMu.Unlock()
+		return
+	}
+	p.inFlight[offset] = &inFlightMessage{msg: msg}
+	p.inFlightMu.Unlock()
+
+	seqID := p.controller.In(0, msg.Topic(), offset, msg.Payload(), false)
+	// the pipeline has dropped the message, so it won't be committed
+	if seqID == pipeline.EventSeqIDError {
+		p.commit(offset)
+	}
+}
+
+// commit marks the message committed and queues the acknowledgments of committed messages in the order they are received
+func (p *Plugin) commit(offset int64) {
+	p.inFlightMu.Lock()
+	defer p.inFlightMu.Unlock()
+
+	if offset < p.ackFrom {
+		return
+	}
+
+	m, has := p.inFlight[offset]
+	if !has {
+		p.logger.Errorf("no mqtt message for the committed event, offset=%d", offset)
+		return
+	}
+	m.committed = true
+
+	queued := false
+	for {
+		m, has := p.inFlight[p.ackFrom]
+		if !has || !m.committed {
+			break
+		}
+		delete(p.inFlight, p.ackFrom)
+		p.ackFrom++
+
+		if m.msg.Qos() == 0 {
+			continue
+		}
+		p.session.acks = append(p.session.acks, m.msg)
+		queued = true
+	}
+
+	if queued {
+		select {
+		case p.session.ackSignal <- struct{}{}:
+		default:
+		}
+	}
+	select {
+	case p.room <- struct{}{}:
+	default:
+	}
+}
+
+// sendAcks sends the queued acknowledgments of the session until its connection is lost
+func (p *Plugin) sendAcks(s *session) {
+	for {
+		select {
+		case <-s.lost:
+			return
+		case <-s.ackSignal:
+		}
+
+		p.inFlightMu.Lock()
+		acks := s.acks
+		s.acks = nil
+		p.inFlightMu.Unlock()
+
+		for _, msg := range acks {
+			msg.Ack()
+		}
+	}
+}
+
+func (p *Plugin) Stop() {
+	p.cancel()
+
+	// wake up the handler waiting for room
+	select {
+	case p.room <- struct{}{}:
+	default:
+	}
+}
+
+func (p *Plugin) Commit(event *pipeline.Event) {
+	p.commit(event.Offset)
+}
+
+// PassEvent decides pass or discard event.
+func (p *Plugin) PassEvent(_ *pipeline.Event) bool {
+	return true
+}
@@ -0,0 +1,340 @@
+package mqtt
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ozontech/file.d/decoder"
+	"github.com/ozontech/file.d/pipeline"
+	"github.com/ozontech/file.d/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	insaneJSON "github.com/vitkovskii/insane-json"
+)
+
+type controller struct {
+	mu     sync.Mutex
+	events []*pipeline.Event
+}
+
+func (c *controller) In(_ pipeline.SourceID, sourceName string, offset int64, data []byte, _ bool) uint64 {
+	if string(data) == "drop" {
+		return pipeline.EventSeqIDError
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.events = append(c.events, &pipeline.Event{Offset: offset, SourceName: sourceName + ":" + string(data)})
+	return uint64(len(c.events))
+}
+
+func (c *controller) received() []*pipeline.Event {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]*pipeline.Event(nil), c.events...)
+}
+
+func (c *controller) UseSpread()                               {}
+func (c *controller) DisableStreams()                          {}
+func (c *controller) SuggestDecoder(_ decoder.DecoderType)     {}
+func (c *controller) IncReadOps()                              {}
+func (c *controller) IncMaxEventSizeExceeded()                 {}
+func (c *controller) IsSourceLimited(_ pipeline.SourceID) bool { return false }
+
+// MQTT 3.1.1 control packet types
+const (
+	packetConnect   = 1
+	packetConnack   = 2
+	packetPublish   = 3
+	packetPuback    = 4
+	packetPubrec    = 5
+	packetPubrel    = 6
+	packetPubcomp   = 7
+	packetSubscribe = 8
+	packetSuback    = 9
+	packetPingreq   = 12
+	packetPingresp  = 13
+)
+
+type packet struct {
+	kind  byte
+	flags byte
+	body  []byte
+}
+
+type publish struct {
+	topic   string
+	id      uint16
+	qos     byte
+	payload []byte
+}
+
+func readPacket(r *bufio.Reader) (*packet, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+
+	size := 0
+	for shift := 0; ; shift += 7 {
+		if shift == 28 {
+			return nil, errors.New("malformed mqtt packet")
+		}
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		size |= int(b&0x7F) << shift
+		if b&0x80 == 0 {
+			break
+		}
+	}
+
+	p := &packet{kind: header >> 4, flags: header & 0x0F, body: make([]byte, size)}
+	if _, err := io.ReadFull(r, p.body); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func appendPacket(buf []byte, kind, flags byte, body []byte) []byte {
+	buf = append(buf, kind<<4|flags)
+	size := len(body)
+	for {
+		b := byte(size & 0x7F)
+		size >>= 7
+		if size > 0 {
+			b |= 0x80
+		}
+		buf = append(buf, b)
+		if size == 0 {
+			break
+		}
+	}
+	return append(buf, body...)
+}
+
+func appendString(buf []byte, s string) []byte {
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(s)))
+	return append(buf, s...)
+}
+
+func encodePublish(m publish) []byte {
+	body := appendString(nil, m.topic)
+	if m.qos > 0 {
+		body = binary.BigEndian.AppendUint16(body, m.id)
+	}
+	body = append(body, m.payload...)
+	return appendPacket(nil, packetPublish, m.qos<<1, body)
+}
+
+// fakeBroker serves the single connection, it publishes messages after the subscription
+// and records acknowledgments of the client
+type fakeBroker struct {
+	t        *testing.T
+	listener net.Listener
+	messages []publish
+
+	mu      sync.Mutex
+	connect []byte
+	topics  []string
+	acks    []ack
+}
+
+type ack struct {
+	kind byte
+	id   uint16
+}
+
+func newFakeBroker(t *testing.T, messages []publish) *fakeBroker {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	b := &fakeBroker{t: t, listener: l, messages: messages}
+	go b.serve()
+	return b
+}
+
+func (b *fakeBroker) url() string {
+	return "tcp://" + b.listener.Addr().String()
+}
+
+func (b *fakeBroker) close() {
+	_ = b.listener.Close()
+}
+
+func (b *fakeBroker) getAcks() []ack {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]ack(nil), b.acks...)
+}
+
+func (b *fakeBroker) serve() {
+	c, err := b.listener.Accept()
+	if err != nil {
+		return
+	}
+	defer func() {
+		_ = c.Close()
+	}()
+
+	r := bufio.NewReader(c)
+	for {
+		p, err := readPacket(r)
+		if err != nil {
+			return
+		}
+
+		switch p.kind {
+		case packetConnect:
+			b.mu.Lock()
+			b.connect = p.body
+			b.mu.Unlock()
+			_, _ = c.Write(appendPacket(nil, packetConnack, 0, []byte{0, 0}))
+		case packetSubscribe:
+			assert.Equal(b.t, byte(0x02), p.flags)
+			body := p.body[2:]
+			codes := []byte{}
+			b.mu.Lock()
+			for len(body) > 0 {
+				n := int(binary.BigEndian.Uint16(body))
+				b.topics = append(b.topics, string(body[2:2+n]))
+				codes = append(codes, body[2+n])
+				body = body[3+n:]
+			}
+			b.mu.Unlock()
+			_, _ = c.Write(appendPacket(nil, packetSuback, 0, append(p.body[:2:2], codes...)))
+
+			for _, m := range b.messages {
+				_, _ = c.Write(encodePublish(m))
+			}
+		case packetPuback, packetPubrec, packetPubcomp:
+			if !assert.Len(b.t, p.body, 2) {
+				return
+			}
+			id := binary.BigEndian.Uint16(p.body)
+			b.mu.Lock()
+			b.acks = append(b.acks, ack{kind: p.kind, id: id})
+			b.mu.Unlock()
+			if p.kind == packetPubrec {
+				_, _ = c.Write(appendPacket(nil, packetPubrel, 0x02, binary.BigEndian.AppendUint16(nil, id)))
+			}
+		case packetPingreq:
+			_, _ = c.Write(appendPacket(nil, packetPingresp, 0, nil))
+		}
+	}
+}
+
+func TestPlugin(t *testing.T) {
+	broker := newFakeBroker(t, []publish{
+		{topic: "sensors/1", id: 1, qos: 1, payload: []byte("m1")},
+		{topic: "sensors/1", id: 2, qos: 1, payload: []byte("drop")},
+		{topic: "sensors/2", id: 3, qos: 2, payload: []byte("m3")},
+	})
+	defer broker.close()
+
+	config := test.NewConfig(&Config{
+		Brokers:     []string{broker.url()},
+		Topics:      []string{"sensors/+", "alerts/#"},
+		QoS:         2,
+		ClientID:    "client",
+		Username:    "user",
+		MaxInFlight: 2,
+	}, nil)
+	ctl := &controller{}
+
+	p := &Plugin{}
+	p.Start(config, test.NewEmptyInputPluginParams(ctl))
+	defer p.Stop()
+
+	// the window is full after the first two messages
+	require.Eventually(t, func() bool {
+		return len(ctl.received()) == 1
+	}, time.Second*5, time.Millisecond*10)
+	time.Sleep(100 * time.Millisecond)
+	require.Len(t, ctl.received(), 1)
+	// the dropped message waits for the first one, since acks are ordered
+	require.Empty(t, broker.getAcks())
+
+	broker.mu.Lock()
+	require.ElementsMatch(t, []string{"sensors/+", "alerts/#"}, broker.topics)
+	want := appendString(nil, "MQTT")
+	want = append(want, protocolVersion, 0x80, 0, 30)
+	want = appendString(want, "client")
+	want = appendString(want, "user")
+	require.Equal(t, want, broker.connect)
+	broker.mu.Unlock()
+
+	p.Commit(ctl.received()[0])
+	require.Eventually(t, func() bool {
+		return len(ctl.received()) == 2
+	}, time.Second, time.Millisecond*10)
+	require.Equal(t, []ack{{packetPuback, 1}, {packetPuback, 2}}, broker.getAcks())
+
+	events := ctl.received()
+	require.Equal(t, "sensors/1:m1", events[0].SourceName)
+	require.Equal(t, "sensors/2:m3", events[1].SourceName)
+
+	p.Commit(events[1])
+	require.Eventually(t, func() bool {
+		return len(broker.getAcks()) == 4
+	}, time.Second, time.Millisecond*10)
+	require.Equal(t, []ack{{packetPuback, 1}, {packetPuback, 2}, {packetPubrec, 3}, {packetPubcomp, 3}}, broker.getAcks())
+}
+
+func TestPluginReconnect(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := l.Addr().String()
+	require.NoError(t, l.Close())
+
+	config := test.NewConfig(&Config{
+		Brokers:      []string{"tcp://" + addr},
+		Topics:       []string{"sensors/+"},
+		QoS:          0,
+		CleanSession: true,
+	}, nil)
+	ctl := &controller{}
+
+	p := &Plugin{}
+	p.Start(config, test.NewEmptyInputPluginParams(ctl))
+	defer p.Stop()
+
+	// the broker starts after the first connection attempt fails
+	time.Sleep(50 * time.Millisecond)
+	l, err = net.Listen("tcp", addr)
+	require.NoError(t, err)
+	broker := &fakeBroker{t: t, listener: l, messages: []publish{
+		{topic: "sensors/1", payload: []byte("m1")},
+	}}
+	go broker.serve()
+	defer broker.close()
+
+	require.Eventually(t, func() bool {
+		return len(ctl.received()) == 1
+	}, time.Second*5, time.Millisecond*10)
+
+	// QoS 0 messages aren't acknowledged
+	p.Commit(ctl.received()[0])
+	time.Sleep(50 * time.Millisecond)
+	require.Empty(t, broker.getAcks())
+}
+
+func TestTopicAction(t *testing.T) {
+	a := &TopicAction{}
+	a.Start(test.NewConfig(&Config{Brokers: []string{"tcp://localhost"}, Topics: []string{"#"}}, nil), nil)
+
+	root, err := insaneJSON.DecodeString(`{"value":1}`)
+	require.NoError(t, err)
+	defer insaneJSON.Release(root)
+
+	event := &pipeline.Event{Root: root, SourceName: "sensors/1"}
+	require.Equal(t, pipeline.ActionPass, a.Do(event))
+	require.Equal(t, `{"value":1,"mqtt_topic":"sensors/1"}`, root.EncodeToString())
+}
@@ -0,0 +1,31 @@
+package mqtt
+
+import (
+	"github.com/ozontech/file.d/pipeline"
+)
+
+// TopicAction puts the message topic, which is the event source name, to the event field.
+// It runs right after the input with the input config.
+type TopicAction struct {
+	config *Config
+}
+
+func TopicActionFactory() (pipeline.AnyPlugin, pipeline.AnyConfig) {
+	return &TopicAction{}, &Config{}
+}
+
+func (a *TopicAction) Start(config pipeline.AnyConfig, _ *pipeline.ActionPluginParams) {
+	a.config = config.(*Config)
+}
+
+func (a *TopicAction) Stop() {
+}
+
+func (a *TopicAction) Do(event *pipeline.Event) pipeline.ActionResult {
+	if a.config.TopicField == "" || !event.Root.IsObject() {
+		return pipeline.ActionPass
+	}
+
+	event.Root.AddFieldNoAlloc(event.Root, a.config.TopicField).MutateToString(event.SourceName)
+	return pipeline.ActionPass
+}
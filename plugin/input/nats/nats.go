@@ -0,0 +1,419 @@
+package nats
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/nats-io/nkeys"
+	"github.com/ozontech/file.d/cfg"
+	"github.com/ozontech/file.d/fd"
+	"github.com/ozontech/file.d/metric"
+	"github.com/ozontech/file.d/pipeline"
+	"github.com/ozontech/file.d/xtls"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/atomic"
+	"go.uber.org/zap"
+)
+
+/*{ introduction
+It reads events from NATS subject or JetStream consumer.
+
+Without the `stream` it's a core NATS subscription, so there are no delivery guarantees:
+messages received while file.d is down are lost.
+
+With the `stream` it reads from the durable JetStream pull consumer.
+> It guarantees "at-least-once delivery": the message is acked only after its event is committed,
+> so after restart the consumer resumes from the last acked message
+> and messages which aren't acked within the `ack_wait` are delivered again.
+
+**Example**
+```yaml
+pipelines:
+  example_pipeline:
+    input:
+      type: nats
+      servers: [nats://nats-1:4222, nats://nats-2:4222]
+      stream: LOGS
+      subject: logs.>
+      consumer: file-d
+    output:
+      type: stdout
+```
+}*/
+
+const (
+	inPluginType = "nats"
+
+	minReconnectBackoff = 100 * time.Millisecond
+	maxReconnectBackoff = 5 * time.Second
+	apiTimeout          = 5 * time.Second
+)
+
+type Plugin struct {
+	config     *Config
+	logger     *zap.SugaredLogger
+	controller pipeline.InputPluginController
+	cancel     context.CancelFunc
+
+	conn    *nats.Conn
+	sub     *nats.Subscription
+	consume jetstream.ConsumeContext
+
+	offset *atomic.Int64
+	// inFlight holds JetStream messages by the event offset until the event is committed
+	inFlight   map[int64]jetstream.Msg
+	inFlightMu *sync.Mutex
+
+	// plugin metrics
+
+	connectErrorsMetric *prometheus.CounterVec
+	ackErrorsMetric     *prometheus.CounterVec
+}
+
+// ! config-params
+// ^ config-params
+type Config struct {
+	// > @3@4@5@6
+	// >
+	// > NATS server URLs, they are used in turn to connect and reconnect, e.g. `nats://localhost:4222`.
+	Servers []string `json:"servers" required:"true"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The subject to subscribe to. Wildcards are allowed.
+	// > It's required for core NATS, for JetStream it's used as the consumer filter subject.
+	Subject string `json:"subject"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The queue group of the core NATS subscription, so messages are distributed between file.d instances.
+	QueueGroup string `json:"queue_group"` // *
+
+	// > @3@4@5@6
+	// >
+	// > JetStream stream name, if it's set, messages are read from the JetStream consumer.
+	Stream string `json:"stream"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The name of the durable JetStream consumer, it's created if it doesn't exist.
+	Consumer string `json:"consumer" default:"file-d"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The max number of JetStream messages which aren't committed yet.
+	MaxInFlight int `json:"max_in_flight" default:"1024"` // *
+
+	// > @3@4@5@6
+	// >
+	// > How long the JetStream consumer waits for the message ack.
+	// > After this timeout the message is delivered again.
+	AckWait  cfg.Duration `json:"ack_wait" default:"30s" parse:"duration"` // *
+	AckWait_ time.Duration
+
+	// > @3@4@5@6
+	// >
+	// > How long the pull request waits for JetStream messages.
+	PullExpires  cfg.Duration `json:"pull_expires" default:"5s" parse:"duration"` // *
+	PullExpires_ time.Duration
+
+	// > @3@4@5@6
+	// >
+	// > User for the authentication.
+	User string `json:"user"` // *
+
+	// > @3@4@5@6
+	// >
+	// > Password for the authentication.
+	Password string `json:"password"` // *
+
+	// > @3@4@5@6
+	// >
+	// > Token for the authentication.
+	Token string `json:"token"` // *
+
+	// > @3@4@5@6
+	// >
+	// > User NKey seed for the authentication, it starts with `SU`.
+	NkeySeed string `json:"nkey_seed"` // *
+
+	// > @3@4@5@6
+	// >
+	// > If set, the connection is upgraded to TLS. It's upgraded anyway if the server requires it.
+	TLSEnabled bool `json:"tls_enabled" default:"false"` // *
+
+	// > @3@4@5@6
+	// >
+	// > CA certificate in PEM encoding or a path to the file to verify the server certificate.
+	CACert string `json:"ca_cert"` // *
+}
+
+func init() {
+	fd.DefaultPluginRegistry.RegisterInput(&pipeline.PluginStaticInfo{
+		Type:    inPluginType,
+		Factory: Factory,
+	})
+}
+
+func Factory() (pipeline.AnyPlugin, pipeline.AnyConfig) {
+	return &Plugin{}, &Config{}
+}
+
+func (p *Plugin) Start(config pipeline.AnyConfig, params *pipeline.InputPluginParams) {
+	p.controller = params.Controller
+	p.logger = params.Logger
+	p.config = config.(*Config)
+	p.registerMetrics(params.MetricCtl)
+
+	if p.config.Stream == "" && p.config.Subject == "" {
+		p.logger.Fatalf("subject should be set if stream isn't set")
+	}
+	if p.config.MaxInFlight <= 0 {
+		p.logger.Fatalf("max_in_flight should be greater than 0")
+	}
+
+	options, err := p.connectOptions()
+	if err != nil {
+		p.logger.Fatal(err.Error())
+	}
+
+	p.offset = atomic.NewInt64(0)
+	p.inFlight = make(map[int64]jetstream.Msg, p.config.MaxInFlight)
+	p.inFlightMu = &sync.Mutex{}
+
+	p.controller.UseSpread()
+	p.controller.DisableStreams()
+
+	// the connection is established in the background and it's reconnected forever
+	p.conn, err = nats.Connect(strings.Join(p.config.Servers, ","), options...)
+	if err != nil {
+		p.logger.Fatalf("can't create nats connection: %s", err.Error())
+	}
+
+	if p.config.Stream == "" {
+		if p.config.QueueGroup != "" {
+			p.sub, err = p.conn.QueueSubscribe(p.config.Subject, p.config.QueueGroup, p.handleCore)
+		} else {
+			p.sub, err = p.conn.Subscribe(p.config.Subject, p.handleCore)
+		}
+		if err != nil {
+			p.logger.Fatalf("can't subscribe to nats subject: %s", err.Error())
+		}
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	p.cancel = cancel
+	go p.runJetStream(ctx)
+}
+
+// connectOptions returns the options of the connection with the credentials and the reconnect backoff
+func (p *Plugin) connectOptions() ([]nats.Option, error) {
+	options := []nats.Option{
+		nats.Name("file.d"),
+		nats.DontRandomize(),
+		nats.MaxReconnects(-1),
+		nats.RetryOnFailedConnect(true),
+		nats.CustomReconnectDelay(func(attempts int) time.Duration {
+			return min(minReconnectBackoff<<min(attempts, 10), maxReconnectBackoff)
+		}),
+		nats.ConnectHandler(func(c *nats.Conn) {
+			p.logger.Infof("connected to nats server %s", c.ConnectedUrlRedacted())
+		}),
+		nats.ReconnectHandler(func(c *nats.Conn) {
+			p.logger.Infof("reconnected to nats server %s", c.ConnectedUrlRedacted())
+		}),
+		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
+			if err == nil {
+				return
+			}
+			p.connectErrorsMetric.WithLabelValues().Inc()
+			p.logger.Errorf("nats connection is broken, reconnecting: %s", err.Error())
+		}),
+	}
+
+	if p.config.User != "" {
+		options = append(options, nats.UserInfo(p.config.User, p.config.Password))
+	}
+	if p.config.Token != "" {
+		options = append(options, nats.Token(p.config.Token))
+	}
+	if p.config.NkeySeed != "" {
+		option, err := nkeyOption(p.config.NkeySeed)
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, option)
+	}
+
+	if p.config.TLSEnabled || p.config.CACert != "" {
+		b := xtls.NewConfigBuilder()
+		if p.config.CACert != "" {
+			if err := b.AppendCARoot(p.config.CACert); err != nil {
+				return nil, fmt.Errorf("can't append CA root: %w", err)
+			}
+		}
+		options = append(options, nats.Secure(b.Build()))
+	}
+
+	return options, nil
+}
+
+// nkeyOption returns the option which signs the server nonce by the user seed
+func nkeyOption(seed string) (nats.Option, error) {
+	kp, err := nkeys.FromSeed([]byte(seed))
+	if err != nil {
+		return nil, fmt.Errorf("can't parse nkey seed: %w", err)
+	}
+	public, err := kp.PublicKey()
+	if err != nil {
+		return nil, fmt.Errorf("can't parse nkey seed: %w", err)
+	}
+	if !nkeys.IsValidPublicUserKey(public) {
+		return nil, errors.New("nkey seed isn't a user seed")
+	}
+	return nats.Nkey(public, kp.Sign), nil
+}
+
+func (p *Plugin) registerMetrics(ctl *metric.Ctl) {
+	p.connectErrorsMetric = ctl.RegisterCounter("input_nats_connect_errors", "Number of NATS connection errors")
+	p.ackErrorsMetric = ctl.RegisterCounter("input_nats_ack_errors", "Number of NATS JetStream ack errors")
+}
+
+func (p *Plugin) handleCore(m *nats.Msg) {
+	_ = p.controller.In(0, m.Subject, p.offset.Inc(), m.Data, false)
+}
+
+// runJetStream creates the durable consumer and starts consuming, it's retried with backoff
+// since the connection may be not established yet
+func (p *Plugin) runJetStream(ctx context.Context) {
+	backoff := minReconnectBackoff
+	for {
+		err := p.startConsumer(ctx)
+		if err == nil || ctx.Err() != nil {
+			return
+		}
+
+		p.connectErrorsMetric.WithLabelValues().Inc()
+		p.logger.Errorf("can't start nats jetstream consumer, retrying in %s: %s", backoff, err.Error())
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, maxReconnectBackoff)
+	}
+}
+
+func (p *Plugin) startConsumer(ctx context.Context) error {
+	js, err := jetstream.New(p.conn)
+	if err != nil {
+		return err
+	}
+
+	apiCtx, cancel := context.WithTimeout(ctx, apiTimeout)
+	defer cancel()
+
+	consumer, err := js.CreateOrUpdateConsumer(apiCtx, p.config.Stream, jetstream.ConsumerConfig{
+		Durable:       p.config.Consumer,
+		AckPolicy:     jetstream.AckExplicitPolicy,
+		AckWait:       p.config.AckWait_,
+		MaxAckPending: p.config.MaxInFlight,
+		FilterSubject: p.config.Subject,
+		DeliverPolicy: jetstream.DeliverAllPolicy,
+	})
+	if err != nil {
+		return fmt.Errorf("can't create jetstream consumer: %w", err)
+	}
+
+	// the server doesn't deliver more than MaxAckPending messages which aren't acked
+	consume, err := consumer.Consume(p.handleJetStream,
+		jetstream.PullMaxMessages(p.config.MaxInFlight),
+		jetstream.PullExpiry(p.config.PullExpires_),
+		jetstream.ConsumeErrHandler(func(_ jetstream.ConsumeContext, err error) {
+			p.logger.Errorf("nats jetstream consumer error: %s", err.Error())
+		}),
+	)
+	if err != nil {
+		return fmt.Errorf("can't consume jetstream messages: %w", err)
+	}
+
+	p.inFlightMu.Lock()
+	defer p.inFlightMu.Unlock()
+	// the input is stopped while the consumer is created
+	if ctx.Err() != nil {
+		consume.Stop()
+		return nil
+	}
+	p.consume = consume
+	return nil
+}
+
+func (p *Plugin) handleJetStream(m jetstream.Msg) {
+	offset := p.offset.Inc()
+	p.inFlightMu.Lock()
+	p.inFlight[offset] = m
+	p.inFlightMu.Unlock()
+
+	seqID := p.controller.In(0, p.config.Stream, offset, m.Data(), false)
+	// the pipeline has dropped the message, so it won't be committed
+	if seqID == pipeline.EventSeqIDError {
+		p.ack(offset)
+	}
+}
+
+func (p *Plugin) Stop() {
+	if p.config.Stream == "" {
+		if err := p.sub.Unsubscribe(); err != nil {
+			p.logger.Errorf("can't unsubscribe from nats subject: %s", err.Error())
+		}
+		p.conn.Close()
+		return
+	}
+
+	p.cancel()
+	p.inFlightMu.Lock()
+	if p.consume != nil {
+		p.consume.Stop()
+	}
+	p.inFlightMu.Unlock()
+	// acks of events committed after the stop are sent through the connection,
+	// the messages which aren't acked are delivered again after the ack wait
+}
+
+func (p *Plugin) Commit(event *pipeline.Event) {
+	if p.config.Stream == "" {
+		return
+	}
+	p.ack(event.Offset)
+}
+
+// ack forgets the message and acks it, if it fails the message will be delivered again after the ack wait
+func (p *Plugin) ack(offset int64) {
+	p.inFlightMu.Lock()
+	m, has := p.inFlight[offset]
+	delete(p.inFlight, offset)
+	p.inFlightMu.Unlock()
+
+	if !has {
+		p.logger.Errorf("no nats message for the committed event, offset=%d", offset)
+		return
+	}
+
+	if err := m.Ack(); err != nil {
+		p.ackErrorsMetric.WithLabelValues().Inc()
+		p.logger.Errorf("can't ack nats message: %s", err.Error())
+	}
+}
+
+// PassEvent decides pass or discard event.
+func (p *Plugin) PassEvent(_ *pipeline.Event) bool {
+	return true
+}
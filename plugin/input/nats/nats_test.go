@@ -0,0 +1,189 @@
+package nats
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/nats-io/nkeys"
+	"github.com/ozontech/file.d/decoder"
+	"github.com/ozontech/file.d/pipeline"
+	"github.com/ozontech/file.d/test"
+	"github.com/stretchr/testify/require"
+)
+
+type controller struct {
+	mu     sync.Mutex
+	events []*pipeline.Event
+}
+
+func (c *controller) In(_ pipeline.SourceID, sourceName string, offset int64, data []byte, _ bool) uint64 {
+	if string(data) == "drop" {
+		return pipeline.EventSeqIDError
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.events = append(c.events, &pipeline.Event{Offset: offset, SourceName: sourceName + ":" + string(data)})
+	return uint64(len(c.events))
+}
+
+func (c *controller) received() []*pipeline.Event {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]*pipeline.Event(nil), c.events...)
+}
+
+func (c *controller) UseSpread()                               {}
+func (c *controller) DisableStreams()                          {}
+func (c *controller) SuggestDecoder(_ decoder.DecoderType)     {}
+func (c *controller) IncReadOps()                              {}
+func (c *controller) IncMaxEventSizeExceeded()                 {}
+func (c *controller) IsSourceLimited(_ pipeline.SourceID) bool { return false }
+
+// runServer runs the embedded NATS server with JetStream
+func runServer(t *testing.T, opts *server.Options) *server.Server {
+	opts.Host = "127.0.0.1"
+	opts.Port = -1
+	opts.NoLog = true
+	opts.JetStream = true
+	opts.StoreDir = t.TempDir()
+
+	s, err := server.NewServer(opts)
+	require.NoError(t, err)
+	go s.Start()
+	require.True(t, s.ReadyForConnections(5*time.Second), "nats server isn't ready")
+	t.Cleanup(s.Shutdown)
+	return s
+}
+
+func connect(t *testing.T, s *server.Server, options ...nats.Option) *nats.Conn {
+	nc, err := nats.Connect(s.ClientURL(), options...)
+	require.NoError(t, err)
+	t.Cleanup(nc.Close)
+	return nc
+}
+
+func TestPluginCore(t *testing.T) {
+	seed, public := newUserNkey(t)
+	s := runServer(t, &server.Options{Nkeys: []*server.NkeyUser{{Nkey: public}}})
+
+	config := test.NewConfig(&Config{
+		Servers:  []string{s.ClientURL()},
+		Subject:  "logs",
+		NkeySeed: seed,
+	}, nil)
+	ctl := &controller{}
+
+	p := &Plugin{}
+	p.Start(config, test.NewEmptyInputPluginParams(ctl))
+	defer p.Stop()
+
+	require.Eventually(t, func() bool {
+		return s.NumSubscriptions() > 0
+	}, time.Second*5, time.Millisecond*10, "plugin isn't subscribed")
+
+	nc := connect(t, s, nats.Nkey(public, func(nonce []byte) ([]byte, error) {
+		kp, err := nkeys.FromSeed([]byte(seed))
+		if err != nil {
+			return nil, err
+		}
+		return kp.Sign(nonce)
+	}))
+	require.NoError(t, nc.Publish("logs", []byte("m1")))
+	require.NoError(t, nc.Publish("logs", []byte("m2")))
+
+	require.Eventually(t, func() bool {
+		return len(ctl.received()) == 2
+	}, time.Second*5, time.Millisecond*10)
+
+	events := ctl.received()
+	require.Equal(t, "logs:m1", events[0].SourceName)
+	require.Equal(t, "logs:m2", events[1].SourceName)
+}
+
+func newUserNkey(t *testing.T) (string, string) {
+	kp, err := nkeys.CreateUser()
+	require.NoError(t, err)
+	seed, err := kp.Seed()
+	require.NoError(t, err)
+	public, err := kp.PublicKey()
+	require.NoError(t, err)
+	return string(seed), public
+}
+
+func TestNkeyOption(t *testing.T) {
+	seed, _ := newUserNkey(t)
+	_, err := nkeyOption(seed)
+	require.NoError(t, err)
+
+	kp, err := nkeys.CreateAccount()
+	require.NoError(t, err)
+	accountSeed, err := kp.Seed()
+	require.NoError(t, err)
+	_, err = nkeyOption(string(accountSeed))
+	require.Error(t, err)
+
+	_, err = nkeyOption("SUAINVALID")
+	require.Error(t, err)
+}
+
+func TestPluginJetStream(t *testing.T) {
+	s := runServer(t, &server.Options{})
+	js, err := jetstream.New(connect(t, s))
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	stream, err := js.CreateStream(ctx, jetstream.StreamConfig{Name: "LOGS", Subjects: []string{"logs.>"}})
+	require.NoError(t, err)
+	for _, data := range []string{"m1", "drop", "m3"} {
+		_, err := js.Publish(ctx, "logs.api", []byte(data))
+		require.NoError(t, err)
+	}
+
+	config := test.NewConfig(&Config{
+		Servers:     []string{s.ClientURL()},
+		Stream:      "LOGS",
+		Subject:     "logs.>",
+		MaxInFlight: 8,
+		PullExpires: "1s",
+	}, nil)
+	ctl := &controller{}
+
+	p := &Plugin{}
+	p.Start(config, test.NewEmptyInputPluginParams(ctl))
+	defer p.Stop()
+
+	// the dropped message is acked right away, others wait for the commit
+	require.Eventually(t, func() bool {
+		return len(ctl.received()) == 2
+	}, time.Second*5, time.Millisecond*10)
+	consumer, err := stream.Consumer(ctx, "file-d")
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		info, err := consumer.Info(ctx)
+		return err == nil && info.NumAckPending == 2
+	}, time.Second, time.Millisecond*10)
+
+	info, err := consumer.Info(ctx)
+	require.NoError(t, err)
+	require.Equal(t, jetstream.AckExplicitPolicy, info.Config.AckPolicy)
+	require.Equal(t, "logs.>", info.Config.FilterSubject)
+	require.Equal(t, 8, info.Config.MaxAckPending)
+
+	events := ctl.received()
+	require.Equal(t, "LOGS:m1", events[0].SourceName)
+	require.Equal(t, "LOGS:m3", events[1].SourceName)
+
+	for _, e := range events {
+		p.Commit(e)
+	}
+	require.Eventually(t, func() bool {
+		info, err := consumer.Info(ctx)
+		return err == nil && info.NumAckPending == 0 && info.AckFloor.Stream == 3
+	}, time.Second, time.Millisecond*10)
+}
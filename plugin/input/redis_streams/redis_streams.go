@@ -0,0 +1,574 @@
+package redis_streams
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/ozontech/file.d/cfg"
+	"github.com/ozontech/file.d/decoder"
+	"github.com/ozontech/file.d/fd"
+	"github.com/ozontech/file.d/metric"
+	"github.com/ozontech/file.d/pipeline"
+	"github.com/prometheus/client_golang/prometheus"
+	insaneJSON "github.com/vitkovskii/insane-json"
+	"go.uber.org/zap"
+)
+
+/*{ introduction
+It reads entries of the Redis stream using the consumer group, each entry becomes the event.
+By default, the event is the JSON object of the entry fields.
+If `payload_field` is set, the value of this entry field is the event,
+it's decoded according to the pipeline `decoder` setting.
+
+> It guarantees "at-least-once delivery": the entry is acknowledged by `XACK` only after its event is committed.
+> Entries delivered to the consumer before the restart, but not acknowledged, are read again on start.
+> Entries which aren't acknowledged for `claim_idle` by any consumer of the group, e.g. the consumer is dead,
+> are claimed by `XAUTOCLAIM` and read again, it requires Redis 6.2 or newer.
+
+**Example**
+```yaml
+pipelines:
+  example_pipeline:
+    input:
+      type: redis_streams
+      address: localhost:6379
+      stream: logs
+      group: file-d
+      consumers_count: 4
+      id_field: redis_id
+    output:
+      type: stdout
+```
+}*/
+
+const (
+	inPluginType = "redis_streams"
+
+	ackFlushInterval = 100 * time.Millisecond
+	readErrorBackoff = time.Second
+
+	// readPending is the ID to read entries delivered to the consumer but not acknowledged
+	readPending = "0"
+	readNew     = ">"
+	claimStart  = "0-0"
+)
+
+var errMalformedReply = errors.New("malformed XAUTOCLAIM reply")
+
+type Plugin struct {
+	config     *Config
+	logger     *zap.SugaredLogger
+	controller pipeline.InputPluginController
+	client     *redis.Client
+	consumer   string
+	cancel     context.CancelFunc
+
+	offset int64
+	// inFlight holds entry IDs by the event offset until the event is committed
+	inFlight map[int64]string
+	// inFlightIDs prevents reading entries which are in the pipeline again, e.g. by claiming
+	inFlightIDs  map[string]struct{}
+	inFlightMu   *sync.Mutex
+	inFlightCond *sync.Cond
+	// reserved is the number of entries which may be returned by reads in progress
+	reserved int
+	acks     chan string
+
+	// plugin metrics
+
+	readErrorsMetric *prometheus.CounterVec
+	ackErrorsMetric  *prometheus.CounterVec
+	claimedMetric    *prometheus.CounterVec
+}
+
+// ! config-params
+// ^ config-params
+type Config struct {
+	// > @3@4@5@6
+	// >
+	// > The address of the Redis server. Format: HOST:PORT.
+	Address string `json:"address" default:"localhost:6379"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The password of the Redis server.
+	Password string `json:"password"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The Redis database number.
+	DB int `json:"db" default:"0"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The key of the stream to read from.
+	Stream string `json:"stream" required:"true"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The consumer group name. The group is created if it doesn't exist.
+	Group string `json:"group" default:"file-d"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The ID of the stream entry the created group starts from.
+	// > `$` means only new entries, `0` means the whole stream.
+	StartID string `json:"start_id" default:"$"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The consumer name, it should be unique in the group and stable between restarts.
+	// > By default, it's the hostname.
+	// > If `consumers_count` is greater than one, the consumer index is added to the name, e.g. `host-0`.
+	Consumer string `json:"consumer"` // *
+
+	// > @3@4@5@6
+	// >
+	// > How many consumers read the stream concurrently.
+	ConsumersCount  cfg.Expression `json:"consumers_count" default:"1" parse:"expression"` // *
+	ConsumersCount_ int
+
+	// > @3@4@5@6
+	// >
+	// > The max number of entries returned by one read.
+	BatchSize int `json:"batch_size" default:"100"` // *
+
+	// > @3@4@5@6
+	// >
+	// > How long the read waits for new entries.
+	BlockTimeout  cfg.Duration `json:"block_timeout" default:"5s" parse:"duration"` // *
+	BlockTimeout_ time.Duration
+
+	// > @3@4@5@6
+	// >
+	// > The min time the entry isn't acknowledged to claim it from its consumer.
+	// > It should be much greater than the time of the event processing, otherwise entries are read twice.
+	// > If it's zero, entries aren't claimed.
+	ClaimIdle  cfg.Duration `json:"claim_idle" default:"5m" parse:"duration"` // *
+	ClaimIdle_ time.Duration
+
+	// > @3@4@5@6
+	// >
+	// > How often entries are claimed.
+	ClaimInterval  cfg.Duration `json:"claim_interval" default:"1m" parse:"duration"` // *
+	ClaimInterval_ time.Duration
+
+	// > @3@4@5@6
+	// >
+	// > The max number of read entries which aren't committed yet.
+	// > Consumers wait when it's reached.
+	MaxInFlight int `json:"max_in_flight" default:"1024"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The event field to put the entry ID. If it's empty, the ID isn't added.
+	// > If `payload_field` is set, the ID is added only if the payload is the JSON object.
+	IDField string `json:"id_field"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The entry field which value is the event. If it's empty or the entry doesn't have the field,
+	// > the event is the JSON object of all entry fields.
+	PayloadField string `json:"payload_field"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The timeout of Redis requests except blocking reads.
+	Timeout  cfg.Duration `json:"timeout" default:"5s" parse:"duration"` // *
+	Timeout_ time.Duration
+}
+
+func init() {
+	fd.DefaultPluginRegistry.RegisterInput(&pipeline.PluginStaticInfo{
+		Type:    inPluginType,
+		Factory: Factory,
+	})
+}
+
+func Factory() (pipeline.AnyPlugin, pipeline.AnyConfig) {
+	return &Plugin{}, &Config{}
+}
+
+func (p *Plugin) Start(config pipeline.AnyConfig, params *pipeline.InputPluginParams) {
+	p.controller = params.Controller
+	p.logger = params.Logger
+	p.config = config.(*Config)
+	p.registerMetrics(params.MetricCtl)
+
+	if p.config.ConsumersCount_ < 1 {
+		p.logger.Fatalf("consumers_count should be at least 1")
+	}
+	if p.config.BatchSize < 1 {
+		p.logger.Fatalf("batch_size should be at least 1")
+	}
+	if p.config.MaxInFlight < p.config.BatchSize {
+		p.logger.Fatalf("max_in_flight should be greater or equal to batch_size")
+	}
+	if p.config.BlockTimeout_ < time.Millisecond {
+		p.logger.Fatalf("block_timeout should be at least 1ms")
+	}
+
+	p.consumer = p.config.Consumer
+	if p.consumer == "" {
+		host, err := os.Hostname()
+		if err != nil {
+			p.logger.Fatalf("can't get hostname for the consumer name: %s", err.Error())
+		}
+		p.consumer = host
+	}
+
+	p.client = redis.NewClient(&redis.Options{
+		Network:      "tcp",
+		Addr:         p.config.Address,
+		Password:     p.config.Password,
+		DB:           p.config.DB,
+		ReadTimeout:  p.config.Timeout_,
+		WriteTimeout: p.config.Timeout_,
+		// each consumer holds the connection while the read blocks
+		PoolSize: p.config.ConsumersCount_ + 2,
+	})
+
+	p.inFlight = make(map[int64]string, p.config.MaxInFlight)
+	p.inFlightIDs = make(map[string]struct{}, p.config.MaxInFlight)
+	p.inFlightMu = &sync.Mutex{}
+	p.inFlightCond = sync.NewCond(p.inFlightMu)
+	p.acks = make(chan string, p.config.MaxInFlight)
+
+	if p.config.PayloadField == "" {
+		p.controller.SuggestDecoder(decoder.JSON)
+	}
+	p.controller.UseSpread()
+	p.controller.DisableStreams()
+
+	p.createGroup()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	p.cancel = cancel
+
+	for i := 0; i < p.config.ConsumersCount_; i++ {
+		go p.consume(ctx, pipeline.SourceID(i), p.consumerName(i))
+	}
+	if p.config.ClaimIdle_ > 0 {
+		go p.claim(ctx, pipeline.SourceID(p.config.ConsumersCount_), p.consumerName(0))
+	}
+	// events are committed after the input is stopped, so acknowledging isn't stopped
+	go p.ack()
+}
+
+func (p *Plugin) registerMetrics(ctl *metric.Ctl) {
+	p.readErrorsMetric = ctl.RegisterCounter("input_redis_streams_read_errors", "Number of Redis stream read errors")
+	p.ackErrorsMetric = ctl.RegisterCounter("input_redis_streams_ack_errors", "Number of Redis stream acknowledge errors")
+	p.claimedMetric = ctl.RegisterCounter("input_redis_streams_claimed", "Number of Redis stream entries claimed from other consumers")
+}
+
+func (p *Plugin) consumerName(i int) string {
+	if p.config.ConsumersCount_ == 1 {
+		return p.consumer
+	}
+	return p.consumer + "-" + strconv.Itoa(i)
+}
+
+func (p *Plugin) createGroup() {
+	err := p.client.XGroupCreateMkStream(p.config.Stream, p.config.Group, p.config.StartID).Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		p.logger.Errorf("can't create redis stream group %q: %s", p.config.Group, err.Error())
+	}
+}
+
+func (p *Plugin) consume(ctx context.Context, sourceID pipeline.SourceID, consumer string) {
+	root := insaneJSON.Spawn()
+	defer insaneJSON.Release(root)
+
+	// pending entries are read first, they are left by the previous run of the consumer
+	id := readPending
+	for {
+		if !p.reserve(ctx) {
+			return
+		}
+
+		streams, err := p.client.XReadGroup(&redis.XReadGroupArgs{
+			Group:    p.config.Group,
+			Consumer: consumer,
+			Streams:  []string{p.config.Stream, id},
+			Count:    int64(p.config.BatchSize),
+			Block:    p.config.BlockTimeout_,
+		}).Result()
+		if err != nil && err != redis.Nil {
+			p.emit(sourceID, nil, root)
+			if ctx.Err() != nil {
+				return
+			}
+			p.readErrorsMetric.WithLabelValues().Inc()
+			p.logger.Errorf("can't read redis stream %q: %s", p.config.Stream, err.Error())
+			if strings.HasPrefix(err.Error(), "NOGROUP") {
+				p.createGroup()
+			}
+			time.Sleep(readErrorBackoff)
+			continue
+		}
+
+		var messages []redis.XMessage
+		if len(streams) > 0 {
+			messages = streams[0].Messages
+		}
+		if id != readNew {
+			if len(messages) == 0 {
+				id = readNew
+			} else {
+				id = messages[len(messages)-1].ID
+			}
+		}
+
+		p.emit(sourceID, messages, root)
+	}
+}
+
+func (p *Plugin) claim(ctx context.Context, sourceID pipeline.SourceID, consumer string) {
+	root := insaneJSON.Spawn()
+	defer insaneJSON.Release(root)
+
+	ticker := time.NewTicker(p.config.ClaimInterval_)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		start := claimStart
+		for {
+			if !p.reserve(ctx) {
+				return
+			}
+
+			reply, err := p.client.Do(
+				"xautoclaim", p.config.Stream, p.config.Group, consumer,
+				int64(p.config.ClaimIdle_/time.Millisecond), start, "count", p.config.BatchSize,
+			).Result()
+			var messages []redis.XMessage
+			if err == nil {
+				start, messages, err = parseAutoClaim(reply)
+			}
+			if err != nil {
+				p.emit(sourceID, nil, root)
+				if ctx.Err() != nil {
+					return
+				}
+				p.readErrorsMetric.WithLabelValues().Inc()
+				p.logger.Errorf("can't claim entries of redis stream %q: %s", p.config.Stream, err.Error())
+				break
+			}
+
+			p.claimedMetric.WithLabelValues().Add(float64(p.emit(sourceID, messages, root)))
+			if start == claimStart {
+				break
+			}
+		}
+	}
+}
+
+// parseAutoClaim parses the reply of XAUTOCLAIM: the next start ID, entries and,
+// since Redis 7, IDs of deleted entries which are ignored
+func parseAutoClaim(reply interface{}) (string, []redis.XMessage, error) {
+	parts, ok := reply.([]interface{})
+	if !ok || len(parts) < 2 {
+		return "", nil, errMalformedReply
+	}
+	start, ok := parts[0].(string)
+	if !ok {
+		return "", nil, errMalformedReply
+	}
+	entries, ok := parts[1].([]interface{})
+	if !ok {
+		return "", nil, errMalformedReply
+	}
+
+	messages := make([]redis.XMessage, 0, len(entries))
+	for _, e := range entries {
+		entry, ok := e.([]interface{})
+		if !ok || len(entry) != 2 {
+			return "", nil, errMalformedReply
+		}
+		id, ok := entry[0].(string)
+		if !ok {
+			return "", nil, errMalformedReply
+		}
+
+		msg := redis.XMessage{ID: id}
+		// fields of the deleted entry are nil
+		if fields, ok := entry[1].([]interface{}); ok {
+			msg.Values = make(map[string]interface{}, len(fields)/2)
+			for i := 0; i+1 < len(fields); i += 2 {
+				k, _ := fields[i].(string)
+				msg.Values[k] = fields[i+1]
+			}
+		}
+		messages = append(messages, msg)
+	}
+
+	return start, messages, nil
+}
+
+// reserve waits until there is room for entries of the next read,
+// so the pipeline backpressure stops reading
+func (p *Plugin) reserve(ctx context.Context) bool {
+	p.inFlightMu.Lock()
+	defer p.inFlightMu.Unlock()
+
+	for ctx.Err() == nil && len(p.inFlight)+p.reserved+p.config.BatchSize > p.config.MaxInFlight {
+		p.inFlightCond.Wait()
+	}
+	if ctx.Err() != nil {
+		return false
+	}
+
+	p.reserved += p.config.BatchSize
+	return true
+}
+
+// emit releases the reservation and passes entries to the pipeline, it returns the number of passed entries
+func (p *Plugin) emit(sourceID pipeline.SourceID, messages []redis.XMessage, root *insaneJSON.Root) int {
+	// entries are stored before In, since the event may be committed before In returns
+	p.inFlightMu.Lock()
+	p.reserved -= p.config.BatchSize
+	offset := p.offset
+	n := 0
+	var deleted []string
+	for _, m := range messages {
+		if _, has := p.inFlightIDs[m.ID]; has {
+			continue
+		}
+		// only entries deleted from the stream have no fields, there is nothing to read
+		if m.Values == nil {
+			deleted = append(deleted, m.ID)
+			continue
+		}
+		messages[n] = m
+		n++
+		p.inFlight[offset+int64(n)] = m.ID
+		p.inFlightIDs[m.ID] = struct{}{}
+	}
+	p.offset += int64(n)
+	p.inFlightCond.Broadcast()
+	p.inFlightMu.Unlock()
+
+	for _, id := range deleted {
+		p.acks <- id
+	}
+
+	var buf []byte
+	for i, m := range messages[:n] {
+		buf = p.appendEvent(buf[:0], &m, root)
+		seqID := p.controller.In(sourceID, p.config.Stream, offset+int64(i)+1, buf, false)
+		// the pipeline has dropped the entry, so it won't be committed
+		if seqID == pipeline.EventSeqIDError {
+			p.done(offset + int64(i) + 1)
+		}
+	}
+
+	return n
+}
+
+func (p *Plugin) appendEvent(out []byte, m *redis.XMessage, root *insaneJSON.Root) []byte {
+	if p.config.PayloadField != "" {
+		if payload, ok := m.Values[p.config.PayloadField].(string); ok {
+			if p.config.IDField == "" {
+				return append(out, payload...)
+			}
+			if err := root.DecodeString(payload); err != nil || !root.IsObject() {
+				return append(out, payload...)
+			}
+			root.AddFieldNoAlloc(root, p.config.IDField).MutateToString(m.ID)
+			return root.Encode(out)
+		}
+	}
+
+	keys := make([]string, 0, len(m.Values))
+	for k := range m.Values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	root.MutateToObject()
+	for _, k := range keys {
+		v, _ := m.Values[k].(string)
+		root.AddFieldNoAlloc(root, k).MutateToString(v)
+	}
+	if p.config.IDField != "" {
+		root.AddFieldNoAlloc(root, p.config.IDField).MutateToString(m.ID)
+	}
+	return root.Encode(out)
+}
+
+// done forgets the entry and schedules its acknowledgement
+func (p *Plugin) done(offset int64) {
+	p.inFlightMu.Lock()
+	id, has := p.inFlight[offset]
+	delete(p.inFlight, offset)
+	delete(p.inFlightIDs, id)
+	p.inFlightCond.Broadcast()
+	p.inFlightMu.Unlock()
+
+	if !has {
+		p.logger.Errorf("no redis stream entry for the committed event, offset=%d", offset)
+		return
+	}
+
+	p.acks <- id
+}
+
+func (p *Plugin) ack() {
+	ticker := time.NewTicker(ackFlushInterval)
+	defer ticker.Stop()
+
+	ids := make([]string, 0, p.config.BatchSize)
+	flush := func() {
+		if len(ids) == 0 {
+			return
+		}
+		if err := p.client.XAck(p.config.Stream, p.config.Group, ids...).Err(); err != nil {
+			p.ackErrorsMetric.WithLabelValues().Inc()
+			p.logger.Errorf("can't acknowledge redis stream entries, they will be read again: %s", err.Error())
+		}
+		ids = ids[:0]
+	}
+
+	for {
+		select {
+		case id := <-p.acks:
+			ids = append(ids, id)
+			if len(ids) == p.config.BatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+func (p *Plugin) Stop() {
+	p.cancel()
+
+	// wake up consumers waiting for room
+	p.inFlightMu.Lock()
+	p.inFlightCond.Broadcast()
+	p.inFlightMu.Unlock()
+}
+
+func (p *Plugin) Commit(event *pipeline.Event) {
+	p.done(event.Offset)
+}
+
+// PassEvent decides pass or discard event.
+func (p *Plugin) PassEvent(_ *pipeline.Event) bool {
+	return true
+}
@@ -0,0 +1,211 @@
+package redis_streams
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis"
+	"github.com/ozontech/file.d/decoder"
+	"github.com/ozontech/file.d/pipeline"
+	"github.com/ozontech/file.d/test"
+	"github.com/stretchr/testify/require"
+)
+
+type controller struct {
+	mu     sync.Mutex
+	events []*pipeline.Event
+}
+
+func (c *controller) In(_ pipeline.SourceID, _ string, offset int64, data []byte, _ bool) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.events = append(c.events, &pipeline.Event{Offset: offset, SourceName: string(data)})
+	return uint64(len(c.events))
+}
+
+func (c *controller) received() []*pipeline.Event {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]*pipeline.Event(nil), c.events...)
+}
+
+func (c *controller) UseSpread()                               {}
+func (c *controller) DisableStreams()                          {}
+func (c *controller) SuggestDecoder(_ decoder.DecoderType)     {}
+func (c *controller) IncReadOps()                              {}
+func (c *controller) IncMaxEventSizeExceeded()                 {}
+func (c *controller) IsSourceLimited(_ pipeline.SourceID) bool { return false }
+
+func startPlugin(t *testing.T, config *Config) (*Plugin, *controller) {
+	ctl := &controller{}
+	params := test.NewEmptyInputPluginParams(ctl)
+
+	p := &Plugin{}
+	p.Start(test.NewConfig(config, nil), params)
+	t.Cleanup(p.Stop)
+	return p, ctl
+}
+
+func newRedis(t *testing.T) (*miniredis.Miniredis, *redis.Client) {
+	s := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: s.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+	return s, client
+}
+
+func add(t *testing.T, client *redis.Client, values map[string]interface{}) string {
+	id, err := client.XAdd(&redis.XAddArgs{Stream: "logs", Values: values}).Result()
+	require.NoError(t, err)
+	return id
+}
+
+func pending(t *testing.T, client *redis.Client) int64 {
+	info, err := client.XPending("logs", "file-d").Result()
+	require.NoError(t, err)
+	return info.Count
+}
+
+func TestPlugin(t *testing.T) {
+	s, client := newRedis(t)
+
+	first := add(t, client, map[string]interface{}{"message": "first", "level": "info"})
+	second := add(t, client, map[string]interface{}{"message": "second"})
+	add(t, client, map[string]interface{}{"message": "third"})
+
+	p, ctl := startPlugin(t, &Config{
+		Address:        s.Addr(),
+		Stream:         "logs",
+		StartID:        "0",
+		Consumer:       "test",
+		ConsumersCount: "2",
+		BatchSize:      2,
+		MaxInFlight:    2,
+		BlockTimeout:   "50ms",
+		IDField:        "id",
+	})
+
+	// consumers wait for room after two entries
+	require.Eventually(t, func() bool {
+		return len(ctl.received()) == 2
+	}, time.Second*5, time.Millisecond*10)
+	time.Sleep(200 * time.Millisecond)
+	events := ctl.received()
+	require.Len(t, events, 2)
+	require.ElementsMatch(t, []string{
+		`{"level":"info","message":"first","id":"` + first + `"}`,
+		`{"message":"second","id":"` + second + `"}`,
+	}, []string{events[0].SourceName, events[1].SourceName})
+	require.Equal(t, int64(2), pending(t, client))
+
+	p.Commit(events[0])
+	p.Commit(events[1])
+	require.Eventually(t, func() bool {
+		return len(ctl.received()) == 3
+	}, time.Second*5, time.Millisecond*10)
+
+	p.Commit(ctl.received()[2])
+	require.Eventually(t, func() bool {
+		return pending(t, client) == 0
+	}, time.Second*5, time.Millisecond*10)
+}
+
+func TestPluginPending(t *testing.T) {
+	s, client := newRedis(t)
+	require.NoError(t, client.XGroupCreateMkStream("logs", "file-d", "$").Err())
+	add(t, client, map[string]interface{}{"data": `{"message":"pending"}`})
+	require.NoError(t, client.XReadGroup(&redis.XReadGroupArgs{
+		Group: "file-d", Consumer: "test", Streams: []string{"logs", ">"}, Block: -1,
+	}).Err())
+	id := add(t, client, map[string]interface{}{"data": `{"message":"new"}`})
+	add(t, client, map[string]interface{}{"other": "raw"})
+
+	p, ctl := startPlugin(t, &Config{
+		Address:      s.Addr(),
+		Stream:       "logs",
+		Consumer:     "test",
+		BlockTimeout: "50ms",
+		ClaimIdle:    "0s",
+		IDField:      "id",
+		PayloadField: "data",
+	})
+
+	require.Eventually(t, func() bool {
+		return len(ctl.received()) == 3
+	}, time.Second*5, time.Millisecond*10)
+	events := ctl.received()
+	// the entry left by the previous run is read first
+	require.Contains(t, events[0].SourceName, `"message":"pending"`)
+	require.Equal(t, `{"message":"new","id":"`+id+`"}`, events[1].SourceName)
+	// the entry without the payload field is the JSON object of its fields
+	require.Contains(t, events[2].SourceName, `"other":"raw"`)
+
+	for _, e := range events {
+		p.Commit(e)
+	}
+	require.Eventually(t, func() bool {
+		return pending(t, client) == 0
+	}, time.Second*5, time.Millisecond*10)
+}
+
+func TestPluginClaim(t *testing.T) {
+	s, client := newRedis(t)
+	require.NoError(t, client.XGroupCreateMkStream("logs", "file-d", "$").Err())
+	id := add(t, client, map[string]interface{}{"message": "stuck"})
+	require.NoError(t, client.XReadGroup(&redis.XReadGroupArgs{
+		Group: "file-d", Consumer: "dead", Streams: []string{"logs", ">"}, Block: -1,
+	}).Err())
+
+	p, ctl := startPlugin(t, &Config{
+		Address:       s.Addr(),
+		Stream:        "logs",
+		Consumer:      "test",
+		BlockTimeout:  "50ms",
+		ClaimIdle:     "1m",
+		ClaimInterval: "50ms",
+	})
+
+	time.Sleep(200 * time.Millisecond)
+	require.Empty(t, ctl.received())
+
+	s.SetTime(time.Now().Add(time.Hour))
+	require.Eventually(t, func() bool {
+		return len(ctl.received()) == 1
+	}, time.Second*5, time.Millisecond*10)
+	require.Equal(t, `{"message":"stuck"}`, ctl.received()[0].SourceName)
+
+	// the entry in the pipeline isn't claimed again
+	s.SetTime(time.Now().Add(2 * time.Hour))
+	time.Sleep(200 * time.Millisecond)
+	require.Len(t, ctl.received(), 1)
+
+	p.Commit(ctl.received()[0])
+	require.Eventually(t, func() bool {
+		return pending(t, client) == 0
+	}, time.Second*5, time.Millisecond*10)
+
+	entries, err := client.XRange("logs", id, id).Result()
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+}
+
+func TestParseAutoClaim(t *testing.T) {
+	start, messages, err := parseAutoClaim([]interface{}{
+		"1-0",
+		[]interface{}{
+			[]interface{}{"0-1", []interface{}{"k", "v"}},
+			[]interface{}{"0-2", nil},
+		},
+		[]interface{}{},
+	})
+	require.NoError(t, err)
+	require.Equal(t, "1-0", start)
+	require.Equal(t, []redis.XMessage{
+		{ID: "0-1", Values: map[string]interface{}{"k": "v"}},
+		{ID: "0-2"},
+	}, messages)
+
+	_, _, err = parseAutoClaim([]interface{}{"0-0"})
+	require.ErrorIs(t, err, errMalformedReply)
+}
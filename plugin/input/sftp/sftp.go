@@ -0,0 +1,565 @@
+package sftp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/ozontech/file.d/cfg"
+	"github.com/ozontech/file.d/fd"
+	"github.com/ozontech/file.d/metric"
+	"github.com/ozontech/file.d/offset"
+	"github.com/ozontech/file.d/pipeline"
+	"github.com/pkg/sftp"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+/*{ introduction
+It polls the directory of the SFTP server and reads lines of the files matching the patterns as events,
+so logs can be collected from the systems which expose them only by SFTP.
+
+Each poll lists the directory and reads the files from the last read offset to the end.
+The last line is read only when it's finished by the newline.
+Files are tracked by the remote path. If the file is shrunk, it's considered rotated and read from the start.
+If the rotated file is renamed to the name matching the patterns, it's read again,
+so the patterns should match only the active file or the files which aren't renamed, e.g. `app-*.log`.
+
+> It guarantees "at-least-once delivery": the offset of the line is saved to the `offsets_file` only after the event is committed.
+> Offsets are saved after each poll and on the stop, so lines committed after that are read again after the restart.
+
+**Example**
+```yaml
+pipelines:
+  example_pipeline:
+    input:
+      type: sftp
+      address: legacy-host:22
+      user: logs
+      private_key: /etc/file.d/id_ed25519
+      known_hosts: /etc/file.d/known_hosts
+      dir: /var/log/app
+      patterns: ["*.log"]
+      poll_interval: 30s
+      offsets_file: /data/sftp-offsets.yaml
+    output:
+      type: stdout
+```
+}*/
+
+const (
+	inPluginType  = "sftp"
+	readChunkSize = 32 << 10
+)
+
+type Plugin struct {
+	config     *Config
+	logger     *zap.SugaredLogger
+	controller pipeline.InputPluginController
+	sshConfig  *ssh.ClientConfig
+	client     *client
+	readBuf    []byte
+	cancel     context.CancelFunc
+
+	mu           *sync.Mutex
+	files        map[string]*remoteFile
+	lastSourceID pipeline.SourceID
+	offset       int64
+	// inFlight holds the read lines by the event offset until the event is committed
+	inFlight   map[int64]*inFlightLine
+	commitFrom int64
+
+	saveMu  *sync.Mutex
+	stopped bool
+
+	// plugin metrics
+
+	pollErrorsMetric   *prometheus.CounterVec
+	offsetErrorsMetric *prometheus.CounterVec
+	rotatedMetric      *prometheus.CounterVec
+}
+
+// remoteFile is the file read from the start, the rotated file is the new remoteFile
+type remoteFile struct {
+	path     string
+	sourceID pipeline.SourceID
+	// read is the end of the last emitted line, it's used only by the reader
+	read int64
+	// committed is the end of the last line committed in order, it's guarded by the plugin mutex
+	committed int64
+}
+
+type inFlightLine struct {
+	file      *remoteFile
+	end       int64
+	committed bool
+}
+
+// client is the SFTP session over the SSH connection,
+// the deadline of the connection is set before the requests, so the hung server doesn't block the poll
+type client struct {
+	netConn   net.Conn
+	sshClient *ssh.Client
+	sftp      *sftp.Client
+	timeout   time.Duration
+}
+
+type state struct {
+	Files map[string]int64 `json:"files"`
+}
+
+// ! config-params
+// ^ config-params
+type Config struct {
+	// > @3@4@5@6
+	// >
+	// > The address of the SFTP server. Format: HOST:PORT.
+	Address string `json:"address" required:"true"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The SSH user.
+	User string `json:"user" required:"true"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The password of the user.
+	Password string `json:"password"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The path to the private key file of the user, it's used before the password.
+	PrivateKey string `json:"private_key"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The passphrase of the encrypted private key.
+	PrivateKeyPassphrase string `json:"private_key_passphrase"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The path to the `known_hosts` file to verify the host key of the server.
+	// > It's required unless `insecure_ignore_host_key` is set.
+	KnownHosts string `json:"known_hosts"` // *
+
+	// > @3@4@5@6
+	// >
+	// > Don't verify the host key of the server if `known_hosts` isn't set.
+	// > It makes the connection vulnerable to the man-in-the-middle attack, so use it only for testing.
+	InsecureIgnoreHostKey bool `json:"insecure_ignore_host_key" default:"false"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The remote directory to read files from, it isn't read recursively.
+	Dir string `json:"dir" required:"true"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The glob patterns of the file names, e.g. `*.log`. If it's empty, all files are read.
+	Patterns []string `json:"patterns"` // *
+
+	// > @3@4@5@6
+	// >
+	// > How often the directory is listed and files are read.
+	PollInterval  cfg.Duration `json:"poll_interval" default:"10s" parse:"duration"` // *
+	PollInterval_ time.Duration
+
+	// > @3@4@5@6
+	// >
+	// > The filename to store offsets of the remote files.
+	// > > It's a `yaml` file. You can modify it manually.
+	OffsetsFile string `json:"offsets_file" required:"true"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The timeout of the connection and SFTP requests.
+	Timeout  cfg.Duration `json:"timeout" default:"30s" parse:"duration"` // *
+	Timeout_ time.Duration
+}
+
+func init() {
+	fd.DefaultPluginRegistry.RegisterInput(&pipeline.PluginStaticInfo{
+		Type:    inPluginType,
+		Factory: Factory,
+	})
+}
+
+func Factory() (pipeline.AnyPlugin, pipeline.AnyConfig) {
+	return &Plugin{}, &Config{}
+}
+
+func (p *Plugin) Start(config pipeline.AnyConfig, params *pipeline.InputPluginParams) {
+	p.controller = params.Controller
+	p.logger = params.Logger
+	p.config = config.(*Config)
+	p.registerMetrics(params.MetricCtl)
+
+	if p.config.PollInterval_ <= 0 {
+		p.logger.Fatalf("poll_interval should be greater than 0")
+	}
+	for _, pattern := range p.config.Patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			p.logger.Fatalf("wrong pattern %q: %s", pattern, err.Error())
+		}
+	}
+
+	sshConfig, err := p.newSSHConfig()
+	if err != nil {
+		p.logger.Fatalf("can't create ssh config: %s", err.Error())
+	}
+	p.sshConfig = sshConfig
+	p.readBuf = make([]byte, readChunkSize)
+
+	p.mu = &sync.Mutex{}
+	p.saveMu = &sync.Mutex{}
+	p.files = make(map[string]*remoteFile)
+	p.inFlight = make(map[int64]*inFlightLine)
+	p.commitFrom = 1
+
+	st := &state{}
+	if err := offset.LoadYAML(p.config.OffsetsFile, st); err != nil {
+		p.offsetErrorsMetric.WithLabelValues().Inc()
+		p.logger.Fatalf("can't load offsets file: %s", err.Error())
+	}
+	for filePath, off := range st.Files {
+		p.addFile(filePath, off)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	p.cancel = cancel
+
+	go p.run(ctx)
+}
+
+func (p *Plugin) registerMetrics(ctl *metric.Ctl) {
+	p.pollErrorsMetric = ctl.RegisterCounter("input_sftp_poll_errors", "Number of SFTP poll errors")
+	p.offsetErrorsMetric = ctl.RegisterCounter("input_sftp_offset_errors", "Number of errors occurred when saving/loading offset")
+	p.rotatedMetric = ctl.RegisterCounter("input_sftp_rotated_files", "Number of SFTP files read from the start since they are shrunk")
+}
+
+func (p *Plugin) newSSHConfig() (*ssh.ClientConfig, error) {
+	config := &ssh.ClientConfig{
+		User:    p.config.User,
+		Timeout: p.config.Timeout_,
+	}
+
+	if p.config.PrivateKey != "" {
+		key, err := os.ReadFile(p.config.PrivateKey)
+		if err != nil {
+			return nil, err
+		}
+		var signer ssh.Signer
+		if p.config.PrivateKeyPassphrase != "" {
+			signer, err = ssh.ParsePrivateKeyWithPassphrase(key, []byte(p.config.PrivateKeyPassphrase))
+		} else {
+			signer, err = ssh.ParsePrivateKey(key)
+		}
+		if err != nil {
+			return nil, err
+		}
+		config.Auth = append(config.Auth, ssh.PublicKeys(signer))
+	}
+	if p.config.Password != "" {
+		config.Auth = append(config.Auth, ssh.Password(p.config.Password))
+	}
+
+	if p.config.KnownHosts == "" {
+		if !p.config.InsecureIgnoreHostKey {
+			return nil, errors.New("known_hosts isn't set, set insecure_ignore_host_key to skip the host key verification")
+		}
+		p.logger.Warnf("insecure_ignore_host_key is set, the host key of the sftp server isn't verified")
+		config.HostKeyCallback = ssh.InsecureIgnoreHostKey()
+		return config, nil
+	}
+
+	callback, err := knownhosts.New(p.config.KnownHosts)
+	if err != nil {
+		return nil, err
+	}
+	config.HostKeyCallback = callback
+	return config, nil
+}
+
+func (p *Plugin) run(ctx context.Context) {
+	ticker := time.NewTicker(p.config.PollInterval_)
+	defer ticker.Stop()
+
+	for {
+		if err := p.poll(ctx); err != nil && ctx.Err() == nil {
+			p.pollErrorsMetric.WithLabelValues().Inc()
+			p.logger.Errorf("can't poll sftp directory %q: %s", p.config.Dir, err.Error())
+			if p.client != nil {
+				p.client.close()
+				p.client = nil
+			}
+		}
+		p.save()
+
+		select {
+		case <-ctx.Done():
+			if p.client != nil {
+				p.client.close()
+			}
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// poll lists the directory and reads the new lines of the matching files
+func (p *Plugin) poll(ctx context.Context) error {
+	if p.client == nil {
+		c, err := dial(p.config.Address, p.sshConfig, p.config.Timeout_)
+		if err != nil {
+			return err
+		}
+		p.logger.Infof("connected to sftp server %s", p.config.Address)
+		p.client = c
+	}
+
+	// the connection is idle between the polls
+	defer p.client.clearDeadline()
+
+	p.client.setDeadline()
+	infos, err := p.client.sftp.ReadDir(p.config.Dir)
+	if err != nil {
+		return err
+	}
+
+	listed := make(map[string]struct{}, len(infos))
+	for _, info := range infos {
+		if !info.Mode().IsRegular() || !p.match(info.Name()) {
+			continue
+		}
+		filePath := path.Join(p.config.Dir, info.Name())
+		listed[filePath] = struct{}{}
+
+		f := p.file(filePath, info.Size())
+		if info.Size() == f.read {
+			continue
+		}
+		if err := p.readFile(ctx, f); err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+	}
+
+	// offsets of the removed files aren't needed anymore
+	p.mu.Lock()
+	for filePath := range p.files {
+		if _, has := listed[filePath]; !has {
+			delete(p.files, filePath)
+		}
+	}
+	p.mu.Unlock()
+
+	return nil
+}
+
+func (p *Plugin) match(name string) bool {
+	if len(p.config.Patterns) == 0 {
+		return true
+	}
+	for _, pattern := range p.config.Patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// file returns the tracked file or the new one if the file isn't tracked or it's shrunk
+func (p *Plugin) file(filePath string, size int64) *remoteFile {
+	p.mu.Lock()
+	f, has := p.files[filePath]
+	p.mu.Unlock()
+
+	switch {
+	case !has:
+		return p.addFile(filePath, 0)
+	case size < f.read:
+		p.rotatedMetric.WithLabelValues().Inc()
+		p.logger.Infof("sftp file %q is shrunk, it's read from the start", filePath)
+		return p.addFile(filePath, 0)
+	default:
+		return f
+	}
+}
+
+func (p *Plugin) addFile(filePath string, off int64) *remoteFile {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.lastSourceID++
+	f := &remoteFile{path: filePath, sourceID: p.lastSourceID, read: off, committed: off}
+	p.files[filePath] = f
+	return f
+}
+
+// readFile reads the file from the last read offset to the end and emits the finished lines
+func (p *Plugin) readFile(ctx context.Context, f *remoteFile) error {
+	p.client.setDeadline()
+	file, err := p.client.sftp.Open(f.path)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = file.Close() }()
+
+	if _, err := file.Seek(f.read, io.SeekStart); err != nil {
+		return err
+	}
+
+	// buf holds the unfinished line
+	buf := make([]byte, 0)
+	for ctx.Err() == nil {
+		p.client.setDeadline()
+		n, err := file.Read(p.readBuf)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+		if n == 0 {
+			return nil
+		}
+
+		buf = append(buf, p.readBuf[:n]...)
+		start := 0
+		for {
+			i := bytes.IndexByte(buf[start:], '\n')
+			if i < 0 {
+				break
+			}
+			f.read += int64(i + 1)
+			p.emit(f, buf[start:start+i])
+			start += i + 1
+		}
+		// the unfinished line is moved to the start of the buffer
+		buf = append(buf[:0], buf[start:]...)
+	}
+	return nil
+}
+
+func dial(address string, config *ssh.ClientConfig, timeout time.Duration) (*client, error) {
+	netConn, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		return nil, err
+	}
+	c := &client{netConn: netConn, timeout: timeout}
+	c.setDeadline()
+
+	sshConn, chans, reqs, err := ssh.NewClientConn(netConn, address, config)
+	if err != nil {
+		_ = netConn.Close()
+		return nil, err
+	}
+	c.sshClient = ssh.NewClient(sshConn, chans, reqs)
+
+	if c.sftp, err = sftp.NewClient(c.sshClient); err != nil {
+		c.close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *client) setDeadline() {
+	_ = c.netConn.SetDeadline(time.Now().Add(c.timeout))
+}
+
+func (c *client) clearDeadline() {
+	_ = c.netConn.SetDeadline(time.Time{})
+}
+
+func (c *client) close() {
+	if c.sftp != nil {
+		_ = c.sftp.Close()
+	}
+	_ = c.sshClient.Close()
+}
+
+func (p *Plugin) emit(f *remoteFile, line []byte) {
+	// the line is stored before In, since the event may be committed before In returns
+	p.mu.Lock()
+	p.offset++
+	off := p.offset
+	p.inFlight[off] = &inFlightLine{file: f, end: f.read}
+	p.mu.Unlock()
+
+	seqID := p.controller.In(f.sourceID, f.path, off, line, false)
+	// the pipeline has dropped the line, so it won't be committed
+	if seqID == pipeline.EventSeqIDError {
+		p.commit(off)
+	}
+}
+
+// commit marks the line committed and moves the committed offsets of the files in the order lines are read
+func (p *Plugin) commit(off int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	l, has := p.inFlight[off]
+	if !has {
+		p.logger.Errorf("no sftp line for the committed event, offset=%d", off)
+		return
+	}
+	l.committed = true
+
+	for {
+		l, has := p.inFlight[p.commitFrom]
+		if !has || !l.committed {
+			break
+		}
+		delete(p.inFlight, p.commitFrom)
+		p.commitFrom++
+		l.file.committed = l.end
+	}
+}
+
+// save saves the committed offsets of the tracked files
+func (p *Plugin) save() {
+	p.saveMu.Lock()
+	defer p.saveMu.Unlock()
+
+	// the reader may still be running after the stop, the offsets file isn't touched anymore
+	if p.stopped {
+		return
+	}
+
+	st := &state{Files: make(map[string]int64)}
+	p.mu.Lock()
+	for filePath, f := range p.files {
+		st.Files[filePath] = f.committed
+	}
+	p.mu.Unlock()
+
+	if err := offset.SaveYAML(p.config.OffsetsFile, st); err != nil {
+		p.offsetErrorsMetric.WithLabelValues().Inc()
+		p.logger.Errorf("can't save offsets file: %s", err.Error())
+	}
+}
+
+func (p *Plugin) Stop() {
+	p.cancel()
+	p.save()
+
+	p.saveMu.Lock()
+	p.stopped = true
+	p.saveMu.Unlock()
+}
+
+func (p *Plugin) Commit(event *pipeline.Event) {
+	p.commit(event.Offset)
+}
+
+// PassEvent decides pass or discard event.
+func (p *Plugin) PassEvent(_ *pipeline.Event) bool {
+	return true
+}
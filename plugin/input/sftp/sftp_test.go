@@ -0,0 +1,306 @@
+package sftp
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ozontech/file.d/decoder"
+	"github.com/ozontech/file.d/offset"
+	"github.com/ozontech/file.d/pipeline"
+	"github.com/ozontech/file.d/test"
+	"github.com/pkg/sftp"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// fakeServer is the SSH server with the SFTP subsystem serving the files of the temporary directory
+type fakeServer struct {
+	listener   net.Listener
+	config     *ssh.ServerConfig
+	dir        string
+	knownHosts string
+
+	mu    sync.Mutex
+	auths []string
+}
+
+func newFakeServer(t *testing.T, clientKey ssh.PublicKey) *fakeServer {
+	_, hostKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	hostSigner, err := ssh.NewSignerFromKey(hostKey)
+	require.NoError(t, err)
+
+	s := &fakeServer{dir: t.TempDir()}
+	s.config = &ssh.ServerConfig{
+		PasswordCallback: func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if conn.User() != "logs" || string(password) != "secret" {
+				return nil, io.EOF
+			}
+			s.mu.Lock()
+			s.auths = append(s.auths, "password")
+			s.mu.Unlock()
+			return nil, nil
+		},
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if clientKey == nil || string(key.Marshal()) != string(clientKey.Marshal()) {
+				return nil, io.EOF
+			}
+			s.mu.Lock()
+			s.auths = append(s.auths, "publickey")
+			s.mu.Unlock()
+			return nil, nil
+		},
+	}
+	s.config.AddHostKey(hostSigner)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = l.Close() })
+	s.listener = l
+
+	s.knownHosts = filepath.Join(t.TempDir(), "known_hosts")
+	line := knownhosts.Line([]string{l.Addr().String()}, hostSigner.PublicKey())
+	require.NoError(t, os.WriteFile(s.knownHosts, []byte(line+"\n"), 0o600))
+
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(c)
+		}
+	}()
+	return s
+}
+
+func (s *fakeServer) setFile(t *testing.T, name, content string) {
+	require.NoError(t, os.WriteFile(filepath.Join(s.dir, name), []byte(content), 0o600))
+}
+
+func (s *fakeServer) serve(c net.Conn) {
+	_, chans, reqs, err := ssh.NewServerConn(c, s.config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+
+	for newChannel := range chans {
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			for req := range requests {
+				_ = req.Reply(req.Type == "subsystem", nil)
+				if req.Type != "subsystem" {
+					continue
+				}
+				server, err := sftp.NewServer(channel, sftp.ReadOnly())
+				if err != nil {
+					_ = channel.Close()
+					return
+				}
+				go func() {
+					_ = server.Serve()
+					_ = server.Close()
+				}()
+			}
+		}()
+	}
+}
+
+type controller struct {
+	mu      sync.Mutex
+	events  []string
+	offsets []int64
+	sources []string
+}
+
+func (c *controller) In(_ pipeline.SourceID, sourceName string, offset int64, data []byte, _ bool) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.events = append(c.events, string(data))
+	c.offsets = append(c.offsets, offset)
+	c.sources = append(c.sources, sourceName)
+	return uint64(len(c.events))
+}
+
+func (c *controller) received() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.events...)
+}
+
+func (c *controller) UseSpread()                               {}
+func (c *controller) DisableStreams()                          {}
+func (c *controller) SuggestDecoder(_ decoder.DecoderType)     {}
+func (c *controller) IncReadOps()                              {}
+func (c *controller) IncMaxEventSizeExceeded()                 {}
+func (c *controller) IsSourceLimited(_ pipeline.SourceID) bool { return false }
+
+func startPlugin(t *testing.T, s *fakeServer, config *Config) (*Plugin, *controller) {
+	config.Address = s.listener.Addr().String()
+	config.User = "logs"
+	config.Dir = s.dir
+	if !config.InsecureIgnoreHostKey && config.KnownHosts == "" {
+		config.KnownHosts = s.knownHosts
+	}
+	config.PollInterval = "20ms"
+	test.NewConfig(config, nil)
+
+	ctl := &controller{}
+	p := &Plugin{}
+	p.Start(config, test.NewEmptyInputPluginParams(ctl))
+
+	return p, ctl
+}
+
+func waitEvents(t *testing.T, ctl *controller, count int) {
+	require.Eventually(t, func() bool {
+		return len(ctl.received()) >= count
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestReadFiles(t *testing.T) {
+	s := newFakeServer(t, nil)
+	s.setFile(t, "app.log", "first\nsecond\nthi")
+	s.setFile(t, "app.txt", "skipped\n")
+	// the directories are skipped
+	require.NoError(t, os.Mkdir(filepath.Join(s.dir, "archive.log"), 0o700))
+
+	offsetsFile := filepath.Join(t.TempDir(), "offsets.yaml")
+	p, ctl := startPlugin(t, s, &Config{
+		Password:    "secret",
+		Patterns:    []string{"*.log"},
+		OffsetsFile: offsetsFile,
+	})
+	t.Cleanup(p.Stop)
+
+	waitEvents(t, ctl, 2)
+
+	// the unfinished line is read after it's finished
+	s.setFile(t, "app.log", "first\nsecond\nthird\nfourth\n")
+	waitEvents(t, ctl, 4)
+
+	// only the first two lines are committed
+	p.Commit(&pipeline.Event{Offset: 2})
+	p.Commit(&pipeline.Event{Offset: 1})
+	p.Commit(&pipeline.Event{Offset: 4})
+
+	// the shrunk file is read from the start
+	s.setFile(t, "app.log", "rotated\n")
+	waitEvents(t, ctl, 5)
+
+	ctl.mu.Lock()
+	assert.Equal(t, []string{"first", "second", "third", "fourth", "rotated"}, ctl.events)
+	assert.Equal(t, []int64{1, 2, 3, 4, 5}, ctl.offsets)
+	assert.Equal(t, filepath.Join(s.dir, "app.log"), ctl.sources[0])
+	ctl.mu.Unlock()
+
+	p.Stop()
+	st := &state{}
+	require.NoError(t, offset.LoadYAML(offsetsFile, st))
+	// the line of the rotated file isn't committed
+	assert.Equal(t, map[string]int64{filepath.Join(s.dir, "app.log"): 0}, st.Files)
+	assert.Equal(t, float64(1), testutil.ToFloat64(p.rotatedMetric.WithLabelValues()))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	assert.Equal(t, []string{"password"}, s.auths)
+}
+
+func TestCommittedOffsets(t *testing.T) {
+	s := newFakeServer(t, nil)
+	s.setFile(t, "app.log", "first\nsecond\nthird\n")
+
+	offsetsFile := filepath.Join(t.TempDir(), "offsets.yaml")
+	p, ctl := startPlugin(t, s, &Config{
+		Password:    "secret",
+		OffsetsFile: offsetsFile,
+	})
+	waitEvents(t, ctl, 3)
+
+	p.Commit(&pipeline.Event{Offset: 1})
+	p.Commit(&pipeline.Event{Offset: 3})
+	p.Stop()
+
+	st := &state{}
+	require.NoError(t, offset.LoadYAML(offsetsFile, st))
+	assert.Equal(t, map[string]int64{filepath.Join(s.dir, "app.log"): int64(len("first\n"))}, st.Files)
+
+	// the lines after the committed offset are read again after the restart
+	p, ctl = startPlugin(t, s, &Config{
+		Password:    "secret",
+		OffsetsFile: offsetsFile,
+	})
+	t.Cleanup(p.Stop)
+	waitEvents(t, ctl, 2)
+
+	assert.Equal(t, []string{"second", "third"}, ctl.received())
+}
+
+func TestPrivateKey(t *testing.T) {
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	sshPub, err := ssh.NewPublicKey(pub)
+	require.NoError(t, err)
+	block, err := ssh.MarshalPrivateKeyWithPassphrase(key, "", []byte("phrase"))
+	require.NoError(t, err)
+
+	keyFile := filepath.Join(t.TempDir(), "id_ed25519")
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(block), 0o600))
+
+	s := newFakeServer(t, sshPub)
+	s.setFile(t, "app.log", "line\n")
+
+	p, ctl := startPlugin(t, s, &Config{
+		PrivateKey:           keyFile,
+		PrivateKeyPassphrase: "phrase",
+		OffsetsFile:          filepath.Join(t.TempDir(), "offsets.yaml"),
+	})
+	t.Cleanup(p.Stop)
+	waitEvents(t, ctl, 1)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	assert.Equal(t, []string{"publickey"}, s.auths)
+}
+
+func TestHostKey(t *testing.T) {
+	s := newFakeServer(t, nil)
+	s.setFile(t, "app.log", "line\n")
+
+	// the known_hosts of the other server
+	other := newFakeServer(t, nil)
+	p, ctl := startPlugin(t, s, &Config{
+		Password:    "secret",
+		KnownHosts:  other.knownHosts,
+		OffsetsFile: filepath.Join(t.TempDir(), "offsets.yaml"),
+	})
+	require.Eventually(t, func() bool {
+		return testutil.ToFloat64(p.pollErrorsMetric.WithLabelValues()) > 0
+	}, 5*time.Second, 10*time.Millisecond)
+	p.Stop()
+	assert.Empty(t, ctl.received(), "lines are read from the server with the unknown host key")
+
+	p, ctl = startPlugin(t, s, &Config{
+		Password:              "secret",
+		InsecureIgnoreHostKey: true,
+		OffsetsFile:           filepath.Join(t.TempDir(), "offsets.yaml"),
+	})
+	t.Cleanup(p.Stop)
+	waitEvents(t, ctl, 1)
+}
@@ -0,0 +1,169 @@
+package sqs
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+)
+
+const (
+	service      = "sqs"
+	targetPrefix = "AmazonSQS."
+	contentType  = "application/x-amz-json-1.0"
+
+	// maxBatchEntries is the limit of entries per batch request of SQS API
+	maxBatchEntries = 10
+)
+
+// client implements the part of SQS API used by the plugin over the AWS JSON protocol
+type client struct {
+	http     *http.Client
+	endpoint string
+	region   string
+	queueURL string
+	creds    aws.Credentials
+	signer   *v4.Signer
+	now      func() time.Time
+}
+
+type message struct {
+	MessageID     string `json:"MessageId"`
+	ReceiptHandle string `json:"ReceiptHandle"`
+	Body          string `json:"Body"`
+}
+
+type receiveRequest struct {
+	QueueURL            string `json:"QueueUrl"`
+	MaxNumberOfMessages int    `json:"MaxNumberOfMessages"`
+	WaitTimeSeconds     int    `json:"WaitTimeSeconds"`
+	VisibilityTimeout   int    `json:"VisibilityTimeout"`
+}
+
+type receiveResponse struct {
+	Messages []message `json:"Messages"`
+}
+
+type batchEntry struct {
+	ID                string `json:"Id"`
+	ReceiptHandle     string `json:"ReceiptHandle"`
+	VisibilityTimeout *int   `json:"VisibilityTimeout,omitempty"`
+}
+
+type batchRequest struct {
+	QueueURL string       `json:"QueueUrl"`
+	Entries  []batchEntry `json:"Entries"`
+}
+
+type batchResponse struct {
+	Failed []struct {
+		ID      string `json:"Id"`
+		Code    string `json:"Code"`
+		Message string `json:"Message"`
+	} `json:"Failed"`
+}
+
+type apiError struct {
+	Type    string `json:"__type"`
+	Message string `json:"message"`
+}
+
+func (c *client) receive(ctx context.Context, maxMessages int, wait, visibility time.Duration) ([]message, error) {
+	resp := receiveResponse{}
+	err := c.call(ctx, "ReceiveMessage", receiveRequest{
+		QueueURL:            c.queueURL,
+		MaxNumberOfMessages: maxMessages,
+		WaitTimeSeconds:     int(wait / time.Second),
+		VisibilityTimeout:   int(visibility / time.Second),
+	}, &resp)
+
+	return resp.Messages, err
+}
+
+// deleteBatch deletes up to maxBatchEntries messages and returns the error if some of them aren't deleted
+func (c *client) deleteBatch(ctx context.Context, receipts []string) error {
+	return c.callBatch(ctx, "DeleteMessageBatch", receipts, nil)
+}
+
+// changeVisibilityBatch sets the visibility timeout of up to maxBatchEntries messages
+func (c *client) changeVisibilityBatch(ctx context.Context, receipts []string, visibility time.Duration) error {
+	timeout := int(visibility / time.Second)
+	return c.callBatch(ctx, "ChangeMessageVisibilityBatch", receipts, &timeout)
+}
+
+func (c *client) callBatch(ctx context.Context, action string, receipts []string, visibility *int) error {
+	req := batchRequest{
+		QueueURL: c.queueURL,
+		Entries:  make([]batchEntry, 0, len(receipts)),
+	}
+	for i, receipt := range receipts {
+		req.Entries = append(req.Entries, batchEntry{
+			ID:                fmt.Sprint(i),
+			ReceiptHandle:     receipt,
+			VisibilityTimeout: visibility,
+		})
+	}
+
+	resp := batchResponse{}
+	if err := c.call(ctx, action, req, &resp); err != nil {
+		return err
+	}
+	if len(resp.Failed) != 0 {
+		f := resp.Failed[0]
+		return fmt.Errorf("%d of %d entries failed, first one is %s: %s", len(resp.Failed), len(receipts), f.Code, f.Message)
+	}
+
+	return nil
+}
+
+func (c *client) call(ctx context.Context, action string, in, out any) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("X-Amz-Target", targetPrefix+action)
+	if err := c.sign(ctx, req, body); err != nil {
+		return err
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		apiErr := apiError{}
+		_ = json.Unmarshal(respBody, &apiErr)
+		return fmt.Errorf("%s failed with status %d: %s %s", action, resp.StatusCode, apiErr.Type, apiErr.Message)
+	}
+
+	return json.Unmarshal(respBody, out)
+}
+
+// sign adds AWS Signature Version 4 headers to the request, all headers set before are signed
+func (c *client) sign(ctx context.Context, req *http.Request, body []byte) error {
+	bodyHash := sha256.Sum256(body)
+	return c.signer.SignHTTP(ctx, c.creds, req, hex.EncodeToString(bodyHash[:]), service, c.region, c.now())
+}
@@ -0,0 +1,90 @@
+package sqs
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeSQS struct {
+	mu       sync.Mutex
+	messages []message
+	deleted  []string
+	extended []string
+}
+
+func (f *fakeSQS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=key/") {
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write([]byte(`{"__type":"com.amazonaws.sqs#InvalidClientTokenId","message":"no credentials"}`))
+		return
+	}
+
+	switch r.Header.Get("X-Amz-Target") {
+	case "AmazonSQS.ReceiveMessage":
+		req := receiveRequest{}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		n := min(req.MaxNumberOfMessages, len(f.messages))
+		_ = json.NewEncoder(w).Encode(receiveResponse{Messages: f.messages[:n]})
+		f.messages = f.messages[n:]
+	case "AmazonSQS.DeleteMessageBatch":
+		req := batchRequest{}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		for _, e := range req.Entries {
+			f.deleted = append(f.deleted, e.ReceiptHandle)
+		}
+		_, _ = w.Write([]byte(`{}`))
+	case "AmazonSQS.ChangeMessageVisibilityBatch":
+		req := batchRequest{}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		for _, e := range req.Entries {
+			f.extended = append(f.extended, e.ReceiptHandle)
+		}
+		_, _ = w.Write([]byte(`{}`))
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+func TestClient(t *testing.T) {
+	fake := &fakeSQS{messages: []message{{ReceiptHandle: "r1", Body: "b1"}, {ReceiptHandle: "r2", Body: "b2"}}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	c := &client{
+		http:     server.Client(),
+		endpoint: server.URL,
+		region:   "us-east-1",
+		queueURL: server.URL + "/1/queue",
+		creds:    aws.Credentials{AccessKeyID: "key", SecretAccessKey: "secret"},
+		signer:   v4.NewSigner(),
+		now:      time.Now,
+	}
+
+	ctx := context.Background()
+	messages, err := c.receive(ctx, 10, 0, time.Second)
+	require.NoError(t, err)
+	require.Equal(t, fake.messages, []message{})
+	require.Len(t, messages, 2)
+
+	require.NoError(t, c.changeVisibilityBatch(ctx, []string{"r1"}, time.Second))
+	require.NoError(t, c.deleteBatch(ctx, []string{"r1", "r2"}))
+	require.Equal(t, []string{"r1"}, fake.extended)
+	require.Equal(t, []string{"r1", "r2"}, fake.deleted)
+
+	c.creds.AccessKeyID = "wrong"
+	_, err = c.receive(ctx, 10, 0, time.Second)
+	require.ErrorContains(t, err, "InvalidClientTokenId")
+}
@@ -0,0 +1,412 @@
+package sqs
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/ozontech/file.d/cfg"
+	"github.com/ozontech/file.d/fd"
+	"github.com/ozontech/file.d/metric"
+	"github.com/ozontech/file.d/pipeline"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/atomic"
+	"go.uber.org/zap"
+)
+
+/*{ introduction
+It reads events from the AWS SQS queue using long polling, each message body is the event.
+The body is decoded according to the pipeline `decoder` setting, e.g. `json` or `raw`.
+> It guarantees "at-least-once delivery": the message is deleted from the queue only after its event is committed,
+> so until then the message may be received again after the visibility timeout.
+> The visibility timeout of messages which aren't committed yet is extended in the background.
+
+Credentials are taken from the config or from `AWS_ACCESS_KEY_ID`, `AWS_SECRET_ACCESS_KEY`
+and `AWS_SESSION_TOKEN` environment variables if config values are empty.
+
+**Example**
+```yaml
+pipelines:
+  example_pipeline:
+    input:
+      type: sqs
+      queue_url: https://sqs.eu-west-1.amazonaws.com/123456789012/logs
+      region: eu-west-1
+      receivers_count: 4
+    output:
+      type: stdout
+```
+}*/
+
+const (
+	inPluginType = "sqs"
+
+	deleteFlushInterval = 100 * time.Millisecond
+	deleteStopTimeout   = 5 * time.Second
+	receiveErrorBackoff = time.Second
+	// visibilityTicks is how many times the visibility is checked during the visibility timeout
+	visibilityTicks = 4
+)
+
+type Plugin struct {
+	config     *Config
+	logger     *zap.SugaredLogger
+	controller pipeline.InputPluginController
+	client     *client
+	cancel     context.CancelFunc
+
+	offset *atomic.Int64
+	// inFlight holds messages by the event offset until the event is committed
+	inFlight     map[int64]*inFlightMessage
+	inFlightMu   *sync.Mutex
+	inFlightCond *sync.Cond
+	// reserved is the number of messages which may be returned by receive requests in progress
+	reserved int
+	deletes  chan string
+	deleteWg *sync.WaitGroup
+
+	// plugin metrics
+
+	receiveErrorsMetric    *prometheus.CounterVec
+	deleteErrorsMetric     *prometheus.CounterVec
+	visibilityErrorsMetric *prometheus.CounterVec
+}
+
+type inFlightMessage struct {
+	receipt    string
+	extendedAt time.Time
+}
+
+// ! config-params
+// ^ config-params
+type Config struct {
+	// > @3@4@5@6
+	// >
+	// > The URL of the queue to read from.
+	QueueURL string `json:"queue_url" required:"true"` // *
+
+	// > @3@4@5@6
+	// >
+	// > AWS region of the queue.
+	Region string `json:"region" default:"us-east-1"` // *
+
+	// > @3@4@5@6
+	// >
+	// > SQS API endpoint, e.g. `http://localhost:4566` for localstack.
+	// > By default, it's the scheme and the host of the `queue_url`.
+	Endpoint string `json:"endpoint"` // *
+
+	// > @3@4@5@6
+	// >
+	// > AWS access key ID.
+	AccessKey string `json:"access_key"` // *
+
+	// > @3@4@5@6
+	// >
+	// > AWS secret access key.
+	SecretKey string `json:"secret_key"` // *
+
+	// > @3@4@5@6
+	// >
+	// > AWS session token for temporary credentials.
+	SessionToken string `json:"session_token"` // *
+
+	// > @3@4@5@6
+	// >
+	// > How many goroutines receive messages concurrently.
+	ReceiversCount  cfg.Expression `json:"receivers_count" default:"1" parse:"expression"` // *
+	ReceiversCount_ int
+
+	// > @3@4@5@6
+	// >
+	// > The max number of messages received by one request, SQS allows up to 10.
+	MaxMessages int `json:"max_messages" default:"10"` // *
+
+	// > @3@4@5@6
+	// >
+	// > How long the receive request waits for messages, SQS allows up to 20s.
+	WaitTime  cfg.Duration `json:"wait_time" default:"20s" parse:"duration"` // *
+	WaitTime_ time.Duration
+
+	// > @3@4@5@6
+	// >
+	// > For how long received messages are hidden from other consumers.
+	// > If the event isn't committed within the half of this timeout, it's extended,
+	// > the visibility is checked 4 times per the timeout.
+	VisibilityTimeout  cfg.Duration `json:"visibility_timeout" default:"30s" parse:"duration"` // *
+	VisibilityTimeout_ time.Duration
+
+	// > @3@4@5@6
+	// >
+	// > The max number of received messages which aren't committed yet.
+	// > Receivers wait when it's reached.
+	MaxInFlight int `json:"max_in_flight" default:"1000"` // *
+}
+
+func init() {
+	fd.DefaultPluginRegistry.RegisterInput(&pipeline.PluginStaticInfo{
+		Type:    inPluginType,
+		Factory: Factory,
+	})
+}
+
+func Factory() (pipeline.AnyPlugin, pipeline.AnyConfig) {
+	return &Plugin{}, &Config{}
+}
+
+func (p *Plugin) Start(config pipeline.AnyConfig, params *pipeline.InputPluginParams) {
+	p.controller = params.Controller
+	p.logger = params.Logger
+	p.config = config.(*Config)
+	p.registerMetrics(params.MetricCtl)
+
+	if p.config.MaxMessages < 1 || p.config.MaxMessages > maxBatchEntries {
+		p.logger.Fatalf("max_messages should be in [1, %d]", maxBatchEntries)
+	}
+	if p.config.MaxInFlight < p.config.MaxMessages {
+		p.logger.Fatalf("max_in_flight should be greater or equal to max_messages")
+	}
+	if p.config.VisibilityTimeout_ < time.Second {
+		p.logger.Fatalf("visibility_timeout should be at least 1s")
+	}
+
+	p.client = p.newClient()
+	p.offset = atomic.NewInt64(0)
+	p.inFlight = make(map[int64]*inFlightMessage, p.config.MaxInFlight)
+	p.inFlightMu = &sync.Mutex{}
+	p.inFlightCond = sync.NewCond(p.inFlightMu)
+	p.deletes = make(chan string, p.config.MaxInFlight)
+	p.deleteWg = &sync.WaitGroup{}
+
+	p.controller.UseSpread()
+	p.controller.DisableStreams()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	p.cancel = cancel
+
+	for i := 0; i < p.config.ReceiversCount_; i++ {
+		go p.receive(ctx, pipeline.SourceID(i))
+	}
+	go p.extendVisibility(ctx)
+	p.deleteWg.Add(1)
+	go p.delete(ctx)
+}
+
+func (p *Plugin) registerMetrics(ctl *metric.Ctl) {
+	p.receiveErrorsMetric = ctl.RegisterCounter("input_sqs_receive_errors", "Number of SQS receive errors")
+	p.deleteErrorsMetric = ctl.RegisterCounter("input_sqs_delete_errors", "Number of SQS delete errors")
+	p.visibilityErrorsMetric = ctl.RegisterCounter("input_sqs_visibility_errors", "Number of SQS visibility timeout change errors")
+}
+
+func (p *Plugin) newClient() *client {
+	endpoint := p.config.Endpoint
+	if endpoint == "" {
+		u, err := url.Parse(p.config.QueueURL)
+		if err != nil || u.Host == "" {
+			p.logger.Fatalf("can't parse queue url %q", p.config.QueueURL)
+		}
+		endpoint = u.Scheme + "://" + u.Host + "/"
+	}
+
+	return &client{
+		// the receive request is held by SQS up to the wait time
+		http:     &http.Client{Timeout: p.config.WaitTime_ + 10*time.Second},
+		endpoint: endpoint,
+		region:   p.config.Region,
+		queueURL: p.config.QueueURL,
+		creds: aws.Credentials{
+			AccessKeyID:     valueOrEnv(p.config.AccessKey, "AWS_ACCESS_KEY_ID"),
+			SecretAccessKey: valueOrEnv(p.config.SecretKey, "AWS_SECRET_ACCESS_KEY"),
+			SessionToken:    valueOrEnv(p.config.SessionToken, "AWS_SESSION_TOKEN"),
+		},
+		signer: v4.NewSigner(),
+		now:    time.Now,
+	}
+}
+
+func valueOrEnv(value, env string) string {
+	if value != "" {
+		return value
+	}
+	return os.Getenv(env)
+}
+
+func (p *Plugin) receive(ctx context.Context, sourceID pipeline.SourceID) {
+	for {
+		if !p.reserve(ctx) {
+			return
+		}
+
+		// the visibility timeout starts before the messages are returned
+		receivedAt := time.Now()
+		messages, err := p.client.receive(ctx, p.config.MaxMessages, p.config.WaitTime_, p.config.VisibilityTimeout_)
+
+		// messages are stored before In, since the event may be committed before In returns
+		offset := p.offset.Add(int64(len(messages))) - int64(len(messages))
+		p.inFlightMu.Lock()
+		p.reserved -= p.config.MaxMessages
+		for i := range messages {
+			p.inFlight[offset+int64(i)+1] = &inFlightMessage{receipt: messages[i].ReceiptHandle, extendedAt: receivedAt}
+		}
+		p.inFlightCond.Broadcast()
+		p.inFlightMu.Unlock()
+
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			p.receiveErrorsMetric.WithLabelValues().Inc()
+			p.logger.Errorf("can't receive messages from sqs: %s", err.Error())
+			time.Sleep(receiveErrorBackoff)
+			continue
+		}
+
+		for i := range messages {
+			msgOffset := offset + int64(i) + 1
+			seqID := p.controller.In(sourceID, inPluginType, msgOffset, []byte(messages[i].Body), false)
+			// the pipeline has dropped the message, so it won't be committed
+			if seqID == pipeline.EventSeqIDError {
+				p.done(msgOffset)
+			}
+		}
+	}
+}
+
+// reserve waits until there is room for the messages of the next receive request,
+// so the pipeline backpressure stops receiving
+func (p *Plugin) reserve(ctx context.Context) bool {
+	p.inFlightMu.Lock()
+	defer p.inFlightMu.Unlock()
+
+	for ctx.Err() == nil && len(p.inFlight)+p.reserved+p.config.MaxMessages > p.config.MaxInFlight {
+		p.inFlightCond.Wait()
+	}
+	if ctx.Err() != nil {
+		return false
+	}
+
+	p.reserved += p.config.MaxMessages
+	return true
+}
+
+// done forgets the message and schedules its deletion from the queue
+func (p *Plugin) done(offset int64) {
+	p.inFlightMu.Lock()
+	m, has := p.inFlight[offset]
+	delete(p.inFlight, offset)
+	p.inFlightCond.Broadcast()
+	p.inFlightMu.Unlock()
+
+	if !has {
+		p.logger.Errorf("no sqs message for the committed event, offset=%d", offset)
+		return
+	}
+
+	p.deletes <- m.receipt
+}
+
+// delete deletes messages of the committed events from the queue in batches until the plugin is stopped,
+// messages of the events committed after the stop are received again
+func (p *Plugin) delete(ctx context.Context) {
+	defer p.deleteWg.Done()
+
+	ticker := time.NewTicker(deleteFlushInterval)
+	defer ticker.Stop()
+
+	receipts := make([]string, 0, maxBatchEntries)
+	flush := func(ctx context.Context) {
+		if len(receipts) == 0 {
+			return
+		}
+		if err := p.client.deleteBatch(ctx, receipts); err != nil {
+			p.deleteErrorsMetric.WithLabelValues().Inc()
+			p.logger.Errorf("can't delete messages from sqs, they will be received again: %s", err.Error())
+		}
+		receipts = receipts[:0]
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			// the plugin context is cancelled, so the pending receipts are deleted with the own timeout
+			stopCtx, cancel := context.WithTimeout(context.Background(), deleteStopTimeout)
+			for len(p.deletes) > 0 {
+				receipts = append(receipts, <-p.deletes)
+				if len(receipts) == maxBatchEntries {
+					flush(stopCtx)
+				}
+			}
+			flush(stopCtx)
+			cancel()
+			return
+		case receipt := <-p.deletes:
+			receipts = append(receipts, receipt)
+			if len(receipts) == maxBatchEntries {
+				flush(ctx)
+			}
+		case <-ticker.C:
+			flush(ctx)
+		}
+	}
+}
+
+// extendVisibility extends the visibility timeout of messages which expire before the next tick with the margin of one tick,
+// so the message is extended when the half of its visibility timeout has passed at the latest
+func (p *Plugin) extendVisibility(ctx context.Context) {
+	interval := p.config.VisibilityTimeout_ / visibilityTicks
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	receipts := make([]string, 0, p.config.MaxInFlight)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		now := time.Now()
+		receipts = receipts[:0]
+		p.inFlightMu.Lock()
+		for _, m := range p.inFlight {
+			if m.extendedAt.Add(p.config.VisibilityTimeout_).Sub(now) < 2*interval {
+				m.extendedAt = now
+				receipts = append(receipts, m.receipt)
+			}
+		}
+		p.inFlightMu.Unlock()
+
+		for i := 0; i < len(receipts); i += maxBatchEntries {
+			batch := receipts[i:min(i+maxBatchEntries, len(receipts))]
+			if err := p.client.changeVisibilityBatch(ctx, batch, p.config.VisibilityTimeout_); err != nil {
+				p.visibilityErrorsMetric.WithLabelValues().Inc()
+				p.logger.Errorf("can't extend visibility timeout of sqs messages: %s", err.Error())
+			}
+		}
+	}
+}
+
+func (p *Plugin) Stop() {
+	p.cancel()
+
+	// wake up receivers waiting for room
+	p.inFlightMu.Lock()
+	p.inFlightCond.Broadcast()
+	p.inFlightMu.Unlock()
+
+	p.deleteWg.Wait()
+}
+
+func (p *Plugin) Commit(event *pipeline.Event) {
+	p.done(event.Offset)
+}
+
+// PassEvent decides pass or discard event.
+func (p *Plugin) PassEvent(_ *pipeline.Event) bool {
+	return true
+}
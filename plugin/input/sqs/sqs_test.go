@@ -0,0 +1,131 @@
+package sqs
+
+import (
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ozontech/file.d/decoder"
+	"github.com/ozontech/file.d/pipeline"
+	"github.com/ozontech/file.d/test"
+	"github.com/stretchr/testify/require"
+)
+
+type controller struct {
+	mu     sync.Mutex
+	events []*pipeline.Event
+}
+
+func (c *controller) In(_ pipeline.SourceID, _ string, offset int64, data []byte, _ bool) uint64 {
+	if string(data) == "drop" {
+		return pipeline.EventSeqIDError
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.events = append(c.events, &pipeline.Event{Offset: offset, SourceName: string(data)})
+	return uint64(len(c.events))
+}
+
+func (c *controller) received() []*pipeline.Event {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]*pipeline.Event(nil), c.events...)
+}
+
+func (c *controller) UseSpread()                               {}
+func (c *controller) DisableStreams()                          {}
+func (c *controller) SuggestDecoder(_ decoder.DecoderType)     {}
+func (c *controller) IncReadOps()                              {}
+func (c *controller) IncMaxEventSizeExceeded()                 {}
+func (c *controller) IsSourceLimited(_ pipeline.SourceID) bool { return false }
+
+func TestPlugin(t *testing.T) {
+	fake := &fakeSQS{messages: []message{
+		{ReceiptHandle: "r1", Body: "b1"},
+		{ReceiptHandle: "r2", Body: "drop"},
+		{ReceiptHandle: "r3", Body: "b3"},
+	}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	config := test.NewConfig(&Config{
+		QueueURL:          server.URL + "/1/queue",
+		AccessKey:         "key",
+		SecretKey:         "secret",
+		WaitTime:          "0s",
+		VisibilityTimeout: "2s",
+		MaxMessages:       2,
+		MaxInFlight:       3,
+	}, nil)
+	ctl := &controller{}
+	params := test.NewEmptyInputPluginParams(ctl)
+
+	p := &Plugin{}
+	p.Start(config, params)
+	defer p.Stop()
+
+	// the dropped message is deleted right away, others wait for the commit
+	require.Eventually(t, func() bool {
+		return len(ctl.received()) == 2
+	}, time.Second*5, time.Millisecond*10)
+	require.Eventually(t, func() bool {
+		fake.mu.Lock()
+		defer fake.mu.Unlock()
+		return len(fake.deleted) == 1 && fake.deleted[0] == "r2"
+	}, time.Second, time.Millisecond*10)
+
+	// not committed messages are extended
+	require.Eventually(t, func() bool {
+		fake.mu.Lock()
+		defer fake.mu.Unlock()
+		return len(fake.extended) >= 2
+	}, time.Second*5, time.Millisecond*10)
+
+	for _, e := range ctl.received() {
+		p.Commit(e)
+	}
+	require.Eventually(t, func() bool {
+		fake.mu.Lock()
+		defer fake.mu.Unlock()
+		return len(fake.deleted) == 3
+	}, time.Second, time.Millisecond*10)
+	fake.mu.Lock()
+	require.ElementsMatch(t, []string{"r1", "r2", "r3"}, fake.deleted)
+	fake.mu.Unlock()
+}
+
+func TestStopDeletesCommitted(t *testing.T) {
+	fake := &fakeSQS{messages: []message{
+		{ReceiptHandle: "r1", Body: "b1"},
+		{ReceiptHandle: "r2", Body: "b2"},
+	}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	config := test.NewConfig(&Config{
+		QueueURL:          server.URL + "/1/queue",
+		AccessKey:         "key",
+		SecretKey:         "secret",
+		WaitTime:          "0s",
+		VisibilityTimeout: "30s",
+		MaxMessages:       2,
+		MaxInFlight:       2,
+	}, nil)
+	ctl := &controller{}
+	p := &Plugin{}
+	p.Start(config, test.NewEmptyInputPluginParams(ctl))
+
+	require.Eventually(t, func() bool {
+		return len(ctl.received()) == 2
+	}, time.Second*5, time.Millisecond*10)
+
+	// the committed message is deleted on the stop without waiting for the flush interval
+	p.Commit(ctl.received()[0])
+	p.Stop()
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	require.Equal(t, []string{"r1"}, fake.deleted)
+}
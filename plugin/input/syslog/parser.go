@@ -0,0 +1,347 @@
+package syslog
+
+import (
+	"bytes"
+	"strconv"
+	"unicode/utf8"
+)
+
+const (
+	maxPriority  = 191
+	nilValue     = "-"
+	rfc3164TSLen = len("Jan _2 15:04:05")
+)
+
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+type param struct {
+	name  string
+	value string
+}
+
+type element struct {
+	id     string
+	params []param
+}
+
+// message is the parsed syslog message, empty fields are omitted in the event
+type message struct {
+	priority       int
+	version        int
+	timestamp      []byte
+	hostname       []byte
+	appName        []byte
+	procID         []byte
+	msgID          []byte
+	structuredData []element
+	msg            []byte
+}
+
+func (m *message) reset() {
+	*m = message{structuredData: m.structuredData[:0]}
+}
+
+// parse parses RFC5424 or RFC3164 message, it returns false if the message has no valid priority
+// or it looks like RFC5424 but has wrong header
+func parse(m *message, data []byte) bool {
+	m.reset()
+
+	priority, rest, ok := parsePriority(data)
+	if !ok {
+		return false
+	}
+	m.priority = priority
+
+	// RFC5424 has the version right after the priority
+	if len(rest) > 1 && rest[0] >= '1' && rest[0] <= '9' && (rest[1] == ' ' || rest[1] >= '0' && rest[1] <= '9') {
+		return parseRFC5424(m, rest)
+	}
+
+	parseRFC3164(m, rest)
+	return true
+}
+
+func parsePriority(data []byte) (int, []byte, bool) {
+	if len(data) < 3 || data[0] != '<' {
+		return 0, nil, false
+	}
+
+	end := bytes.IndexByte(data[:min(len(data), 5)], '>')
+	if end < 2 {
+		return 0, nil, false
+	}
+
+	priority, err := strconv.Atoi(string(data[1:end]))
+	if err != nil || priority < 0 || priority > maxPriority {
+		return 0, nil, false
+	}
+
+	return priority, data[end+1:], true
+}
+
+// parseRFC5424 parses VERSION SP TIMESTAMP SP HOSTNAME SP APP-NAME SP PROCID SP MSGID SP STRUCTURED-DATA [SP MSG]
+func parseRFC5424(m *message, data []byte) bool {
+	fields := [6][]byte{}
+	for i := range fields {
+		var ok bool
+		fields[i], data, ok = nextField(data)
+		if !ok {
+			return false
+		}
+	}
+
+	version, err := strconv.Atoi(string(fields[0]))
+	if err != nil {
+		return false
+	}
+	m.version = version
+	m.timestamp = nilOr(fields[1])
+	m.hostname = nilOr(fields[2])
+	m.appName = nilOr(fields[3])
+	m.procID = nilOr(fields[4])
+	m.msgID = nilOr(fields[5])
+
+	data, ok := parseStructuredData(m, data)
+	if !ok {
+		return false
+	}
+
+	if len(data) > 0 {
+		if data[0] != ' ' {
+			return false
+		}
+		m.msg = bytes.TrimPrefix(data[1:], utf8BOM)
+	}
+
+	return true
+}
+
+func nextField(data []byte) (field, rest []byte, ok bool) {
+	end := bytes.IndexByte(data, ' ')
+	if end <= 0 {
+		return nil, nil, false
+	}
+
+	return data[:end], data[end+1:], true
+}
+
+func nilOr(field []byte) []byte {
+	if string(field) == nilValue {
+		return nil
+	}
+	return field
+}
+
+// parseStructuredData parses "-" or elements like [id name="value" ...], values may contain escaped '"', '\' and ']'
+func parseStructuredData(m *message, data []byte) ([]byte, bool) {
+	if len(data) == 0 {
+		return nil, false
+	}
+	if data[0] == '-' {
+		return data[1:], true
+	}
+
+	for len(data) > 0 && data[0] == '[' {
+		data = data[1:]
+		end := bytes.IndexAny(data, " ]")
+		if end <= 0 {
+			return nil, false
+		}
+
+		e := element{id: string(data[:end])}
+		data = data[end:]
+		for len(data) > 0 && data[0] == ' ' {
+			data = data[1:]
+			eq := bytes.IndexByte(data, '=')
+			if eq <= 0 || len(data) < eq+2 || data[eq+1] != '"' {
+				return nil, false
+			}
+
+			p := param{name: string(data[:eq])}
+			var ok bool
+			p.value, data, ok = parseParamValue(data[eq+2:])
+			if !ok {
+				return nil, false
+			}
+			e.params = append(e.params, p)
+		}
+
+		if len(data) == 0 || data[0] != ']' {
+			return nil, false
+		}
+		data = data[1:]
+		m.structuredData = append(m.structuredData, e)
+	}
+
+	if len(m.structuredData) == 0 {
+		return nil, false
+	}
+
+	return data, true
+}
+
+func parseParamValue(data []byte) (string, []byte, bool) {
+	value := make([]byte, 0, len(data))
+	for i := 0; i < len(data); i++ {
+		switch c := data[i]; c {
+		case '"':
+			return string(value), data[i+1:], true
+		case '\\':
+			if i+1 < len(data) && (data[i+1] == '"' || data[i+1] == '\\' || data[i+1] == ']') {
+				i++
+				c = data[i]
+			}
+			value = append(value, c)
+		default:
+			value = append(value, c)
+		}
+	}
+
+	return "", nil, false
+}
+
+// parseRFC3164 parses TIMESTAMP SP HOSTNAME SP TAG[PID]: MSG,
+// since the format isn't strict, the rest is the message if the header isn't recognized
+func parseRFC3164(m *message, data []byte) {
+	if !isRFC3164Timestamp(data) {
+		m.msg = data
+		return
+	}
+	m.timestamp = data[:rfc3164TSLen]
+	data = bytes.TrimLeft(data[rfc3164TSLen:], " ")
+
+	if hostname, rest, ok := nextField(data); ok && !bytes.HasSuffix(hostname, []byte{':'}) {
+		m.hostname = hostname
+		data = rest
+	}
+
+	tagEnd := bytes.IndexAny(data, "[: ")
+	if tagEnd > 0 {
+		tag := data[:tagEnd]
+		rest := data[tagEnd:]
+		if rest[0] == '[' {
+			if end := bytes.IndexByte(rest, ']'); end > 0 {
+				m.procID = rest[1:end]
+				rest = rest[end+1:]
+			}
+		}
+		if len(rest) > 0 && rest[0] == ':' {
+			m.appName = tag
+			data = bytes.TrimPrefix(rest[1:], []byte{' '})
+		}
+	}
+
+	m.msg = data
+}
+
+// isRFC3164Timestamp checks the timestamp like "Oct 11 22:14:15"
+func isRFC3164Timestamp(data []byte) bool {
+	if len(data) < rfc3164TSLen {
+		return false
+	}
+	ts := data[:rfc3164TSLen]
+
+	isDigit := func(c byte) bool { return c >= '0' && c <= '9' }
+	return isLetter(ts[0]) && isLetter(ts[1]) && isLetter(ts[2]) && ts[3] == ' ' &&
+		(ts[4] == ' ' || isDigit(ts[4])) && isDigit(ts[5]) && ts[6] == ' ' &&
+		isDigit(ts[7]) && isDigit(ts[8]) && ts[9] == ':' &&
+		isDigit(ts[10]) && isDigit(ts[11]) && ts[12] == ':' &&
+		isDigit(ts[13]) && isDigit(ts[14])
+}
+
+func isLetter(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// appendJSON appends the event of the message
+func appendJSON(out []byte, m *message) []byte {
+	out = append(out, `{"priority":`...)
+	out = strconv.AppendInt(out, int64(m.priority), 10)
+	out = append(out, `,"facility":`...)
+	out = strconv.AppendInt(out, int64(m.priority/8), 10)
+	out = append(out, `,"severity":`...)
+	out = strconv.AppendInt(out, int64(m.priority%8), 10)
+	if m.version != 0 {
+		out = append(out, `,"version":`...)
+		out = strconv.AppendInt(out, int64(m.version), 10)
+	}
+
+	out = appendField(out, "timestamp", m.timestamp)
+	out = appendField(out, "hostname", m.hostname)
+	out = appendField(out, "app_name", m.appName)
+	out = appendField(out, "procid", m.procID)
+	out = appendField(out, "msgid", m.msgID)
+
+	if len(m.structuredData) != 0 {
+		out = append(out, `,"structured_data":{`...)
+		for i, e := range m.structuredData {
+			if i != 0 {
+				out = append(out, ',')
+			}
+			out = appendString(out, e.id)
+			out = append(out, ":{"...)
+			for j, p := range e.params {
+				if j != 0 {
+					out = append(out, ',')
+				}
+				out = appendString(out, p.name)
+				out = append(out, ':')
+				out = appendString(out, p.value)
+			}
+			out = append(out, '}')
+		}
+		out = append(out, '}')
+	}
+
+	out = appendField(out, "message", m.msg)
+	return append(out, '}')
+}
+
+func appendField(out []byte, name string, value []byte) []byte {
+	if len(value) == 0 {
+		return out
+	}
+
+	out = append(out, ',', '"')
+	out = append(out, name...)
+	out = append(out, '"', ':')
+	return appendString(out, string(value))
+}
+
+// appendString appends JSON string, invalid UTF-8 is replaced with U+FFFD
+func appendString(out []byte, s string) []byte {
+	const hex = "0123456789abcdef"
+
+	out = append(out, '"')
+	for i := 0; i < len(s); {
+		c := s[i]
+		if c >= utf8.RuneSelf {
+			r, size := utf8.DecodeRuneInString(s[i:])
+			if r == utf8.RuneError && size == 1 {
+				out = append(out, "\ufffd"...)
+			} else {
+				out = append(out, s[i:i+size]...)
+			}
+			i += size
+			continue
+		}
+
+		switch {
+		case c == '"' || c == '\\':
+			out = append(out, '\\', c)
+		case c == '\n':
+			out = append(out, '\\', 'n')
+		case c == '\r':
+			out = append(out, '\\', 'r')
+		case c == '\t':
+			out = append(out, '\\', 't')
+		case c < 0x20:
+			out = append(out, '\\', 'u', '0', '0', hex[c>>4], hex[c&0x0F])
+		default:
+			out = append(out, c)
+		}
+		i++
+	}
+
+	return append(out, '"')
+}
@@ -0,0 +1,84 @@
+package syslog
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	cases := []struct {
+		name string
+		in   string
+		out  string
+		ok   bool
+	}{
+		{
+			name: "rfc5424",
+			in:   `<165>1 2003-10-11T22:14:15.003Z mymachine.example.com evntslog - ID47 [exampleSDID@32473 iut="3" eventSource="Application" eventID="1011"][meta x="a\"b\]c\\"] ` + "\xEF\xBB\xBF" + `An application event log entry...`,
+			out:  `{"priority":165,"facility":20,"severity":5,"version":1,"timestamp":"2003-10-11T22:14:15.003Z","hostname":"mymachine.example.com","app_name":"evntslog","msgid":"ID47","structured_data":{"exampleSDID@32473":{"iut":"3","eventSource":"Application","eventID":"1011"},"meta":{"x":"a\"b]c\\"}},"message":"An application event log entry..."}`,
+			ok:   true,
+		},
+		{
+			name: "rfc5424_nil_values",
+			in:   `<34>1 - - - - - -`,
+			out:  `{"priority":34,"facility":4,"severity":2,"version":1}`,
+			ok:   true,
+		},
+		{
+			name: "rfc5424_element_without_params",
+			in:   `<34>1 2003-10-11T22:14:15Z host app 123 - [origin] msg`,
+			out:  `{"priority":34,"facility":4,"severity":2,"version":1,"timestamp":"2003-10-11T22:14:15Z","hostname":"host","app_name":"app","procid":"123","structured_data":{"origin":{}},"message":"msg"}`,
+			ok:   true,
+		},
+		{
+			name: "rfc5424_wrong_structured_data",
+			in:   `<34>1 2003-10-11T22:14:15Z host app 123 - [origin x=1] msg`,
+		},
+		{
+			name: "rfc5424_short_header",
+			in:   `<34>1 2003-10-11T22:14:15Z host`,
+		},
+		{
+			name: "rfc3164",
+			in:   `<34>Oct 11 22:14:15 mymachine su[123]: 'su root' failed for lonvick on /dev/pts/8`,
+			out:  `{"priority":34,"facility":4,"severity":2,"timestamp":"Oct 11 22:14:15","hostname":"mymachine","app_name":"su","procid":"123","message":"'su root' failed for lonvick on /dev/pts/8"}`,
+			ok:   true,
+		},
+		{
+			name: "rfc3164_without_hostname",
+			in:   "<13>Feb  5 17:32:18 sshd: Accepted publickey\ttab",
+			out:  `{"priority":13,"facility":1,"severity":5,"timestamp":"Feb  5 17:32:18","app_name":"sshd","message":"Accepted publickey\ttab"}`,
+			ok:   true,
+		},
+		{
+			name: "rfc3164_without_header",
+			in:   `<0>just a message`,
+			out:  `{"priority":0,"facility":0,"severity":0,"message":"just a message"}`,
+			ok:   true,
+		},
+		{
+			name: "no_priority",
+			in:   `Oct 11 22:14:15 mymachine su: failed`,
+		},
+		{
+			name: "wrong_priority",
+			in:   `<192>Oct 11 22:14:15 mymachine su: failed`,
+		},
+	}
+
+	m := &message{}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			ok := parse(m, []byte(tc.in))
+			require.Equal(t, tc.ok, ok)
+			if ok {
+				require.Equal(t, tc.out, string(appendJSON(nil, m)))
+			}
+		})
+	}
+}
+
+func TestAppendString(t *testing.T) {
+	require.Equal(t, `"a\"\\\n\r\t\u0001ж�"`, string(appendString(nil, "a\"\\\n\r\t\x01ж\xff")))
+}
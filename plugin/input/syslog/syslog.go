@@ -0,0 +1,347 @@
+package syslog
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"slices"
+	"sync"
+
+	"github.com/ozontech/file.d/decoder"
+	"github.com/ozontech/file.d/fd"
+	"github.com/ozontech/file.d/metric"
+	"github.com/ozontech/file.d/pipeline"
+	"github.com/ozontech/file.d/xtls"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/atomic"
+	"go.uber.org/zap"
+)
+
+/*{ introduction
+It receives syslog messages over UDP and TCP, it parses RFC5424 and RFC3164 messages into events like:
+```json
+{
+  "priority": 165,
+  "facility": 20,
+  "severity": 5,
+  "version": 1,
+  "timestamp": "2003-10-11T22:14:15.003Z",
+  "hostname": "mymachine.example.com",
+  "app_name": "evntslog",
+  "procid": "1234",
+  "msgid": "ID47",
+  "structured_data": {"origin": {"ip": "192.0.2.1", "software": "evntslog"}},
+  "message": "An application event log entry..."
+}
+```
+Absent fields are omitted. TCP messages may be framed by the octet counting or by the new line, both are detected automatically.
+If the message can't be parsed, the event contains the raw message in the `fallback_field`.
+
+> ⚠ Delivery isn't guaranteed, since there are no acknowledgments in the syslog protocol.
+
+**Example**
+```yaml
+pipelines:
+  example_pipeline:
+    input:
+      type: syslog
+      udp_address: ":514"
+      tcp_address: ":514"
+    output:
+      type: stdout
+```
+}*/
+
+const (
+	inPluginType = "syslog"
+
+	maxUDPMessageSize = 64 * 1024
+	// maxFrameSize limits the octet-counting frame and the line of the new line framing
+	maxFrameSize = 1024 * 1024
+)
+
+var (
+	errTooLongFrame     = errors.New("syslog frame is too long")
+	errWrongFrameLength = errors.New("wrong syslog frame length")
+)
+
+type Plugin struct {
+	config     *Config
+	logger     *zap.SugaredLogger
+	controller pipeline.InputPluginController
+
+	udpConn     net.PacketConn
+	tcpListener net.Listener
+	conns       map[net.Conn]struct{}
+	connsMu     *sync.Mutex
+	stopped     *atomic.Bool
+	sourceSeq   *atomic.Uint64
+
+	// plugin metrics
+
+	parseErrorsMetric *prometheus.CounterVec
+	readErrorsMetric  *prometheus.CounterVec
+}
+
+// ! config-params
+// ^ config-params
+type Config struct {
+	// > @3@4@5@6
+	// >
+	// > UDP address to listen to, e.g. `:514`. If it's `off`, UDP isn't used.
+	UDPAddress string `json:"udp_address" default:":514"` // *
+
+	// > @3@4@5@6
+	// >
+	// > TCP address to listen to, e.g. `:514`. If it's `off`, TCP isn't used.
+	TCPAddress string `json:"tcp_address" default:":514"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The event field for the raw message which can't be parsed.
+	FallbackField string `json:"fallback_field" default:"raw_message" required:"true"` // *
+
+	// > @3@4@5@6
+	// >
+	// > CA certificate in PEM encoding. This can be a path or the content of the certificate.
+	// > If both ca_cert and private_key are set, the TCP listener accepts connections in TLS mode.
+	CACert string `json:"ca_cert" default:""` // *
+
+	// > @3@4@5@6
+	// >
+	// > CA private key in PEM encoding. This can be a path or the content of the key.
+	// > If both ca_cert and private_key are set, the TCP listener accepts connections in TLS mode.
+	PrivateKey string `json:"private_key" default:""` // *
+}
+
+func init() {
+	fd.DefaultPluginRegistry.RegisterInput(&pipeline.PluginStaticInfo{
+		Type:    inPluginType,
+		Factory: Factory,
+	})
+}
+
+func Factory() (pipeline.AnyPlugin, pipeline.AnyConfig) {
+	return &Plugin{}, &Config{}
+}
+
+func (p *Plugin) Start(config pipeline.AnyConfig, params *pipeline.InputPluginParams) {
+	p.config = config.(*Config)
+	p.logger = params.Logger
+	p.controller = params.Controller
+	p.registerMetrics(params.MetricCtl)
+
+	p.conns = make(map[net.Conn]struct{})
+	p.connsMu = &sync.Mutex{}
+	p.stopped = atomic.NewBool(false)
+	p.sourceSeq = atomic.NewUint64(0)
+
+	// events are JSON encoded by the plugin
+	p.controller.SuggestDecoder(decoder.JSON)
+	p.controller.DisableStreams()
+
+	if p.config.UDPAddress == "off" && p.config.TCPAddress == "off" {
+		p.logger.Fatalf("udp_address or tcp_address should be set")
+	}
+
+	if p.config.UDPAddress != "off" {
+		conn, err := net.ListenPacket("udp", p.config.UDPAddress)
+		if err != nil {
+			p.logger.Fatalf("can't listen udp %s: %s", p.config.UDPAddress, err.Error())
+		}
+		p.udpConn = conn
+		go p.serveUDP()
+	}
+
+	if p.config.TCPAddress != "off" {
+		listener, err := net.Listen("tcp", p.config.TCPAddress)
+		if err != nil {
+			p.logger.Fatalf("can't listen tcp %s: %s", p.config.TCPAddress, err.Error())
+		}
+		if p.config.CACert != "" || p.config.PrivateKey != "" {
+			b := xtls.NewConfigBuilder()
+			if err := b.AppendX509KeyPair(p.config.CACert, p.config.PrivateKey); err != nil {
+				p.logger.Fatalf("can't append certificate: %s", err.Error())
+			}
+			listener = tls.NewListener(listener, b.Build())
+		}
+		p.tcpListener = listener
+		go p.serveTCP()
+	}
+}
+
+func (p *Plugin) registerMetrics(ctl *metric.Ctl) {
+	p.parseErrorsMetric = ctl.RegisterCounter("input_syslog_parse_errors", "Number of syslog messages which can't be parsed")
+	p.readErrorsMetric = ctl.RegisterCounter("input_syslog_read_errors", "Number of syslog read errors")
+}
+
+func (p *Plugin) serveUDP() {
+	buf := make([]byte, maxUDPMessageSize)
+	event := make([]byte, 0, maxUDPMessageSize)
+	m := &message{}
+	for {
+		n, addr, err := p.udpConn.ReadFrom(buf)
+		if err != nil {
+			if p.stopped.Load() {
+				return
+			}
+			p.readErrorsMetric.WithLabelValues().Inc()
+			p.logger.Errorf("can't read syslog udp message: %s", err.Error())
+			continue
+		}
+
+		event = p.in(0, addr.String(), buf[:n], event, m)
+	}
+}
+
+func (p *Plugin) serveTCP() {
+	for {
+		conn, err := p.tcpListener.Accept()
+		if err != nil {
+			if p.stopped.Load() {
+				return
+			}
+			p.readErrorsMetric.WithLabelValues().Inc()
+			p.logger.Errorf("can't accept syslog tcp connection: %s", err.Error())
+			continue
+		}
+
+		p.connsMu.Lock()
+		p.conns[conn] = struct{}{}
+		p.connsMu.Unlock()
+
+		go p.serveConn(conn)
+	}
+}
+
+func (p *Plugin) serveConn(conn net.Conn) {
+	defer func() {
+		p.connsMu.Lock()
+		delete(p.conns, conn)
+		p.connsMu.Unlock()
+		_ = conn.Close()
+	}()
+
+	sourceID := pipeline.SourceID(p.sourceSeq.Inc())
+	sourceName := conn.RemoteAddr().String()
+	r := bufio.NewReader(conn)
+	event := make([]byte, 0, 1024)
+	m := &message{}
+	var frame []byte
+	for {
+		var err error
+		frame, err = readFrame(r, frame[:0])
+		if err != nil {
+			if err != io.EOF && !p.stopped.Load() {
+				p.readErrorsMetric.WithLabelValues().Inc()
+				p.logger.Errorf("can't read syslog tcp message from %s: %s", sourceName, err.Error())
+			}
+			return
+		}
+
+		event = p.in(sourceID, sourceName, frame, event, m)
+	}
+}
+
+// readFrame reads the octet-counting frame like "<len> <message>" or the message ended by the new line
+func readFrame(r *bufio.Reader, frame []byte) ([]byte, error) {
+	first, err := r.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+
+	if first[0] >= '1' && first[0] <= '9' {
+		size := 0
+		for {
+			c, err := r.ReadByte()
+			if err != nil {
+				return nil, err
+			}
+			if c == ' ' {
+				break
+			}
+			if c < '0' || c > '9' {
+				return nil, errWrongFrameLength
+			}
+			size = size*10 + int(c-'0')
+			if size > maxFrameSize {
+				return nil, errTooLongFrame
+			}
+		}
+
+		frame = slices.Grow(frame, size)[:size]
+		if _, err := io.ReadFull(r, frame); err != nil {
+			return nil, err
+		}
+		return frame, nil
+	}
+
+	for {
+		line, err := r.ReadSlice('\n')
+		frame = append(frame, line...)
+		if len(frame) > maxFrameSize {
+			return nil, errTooLongFrame
+		}
+		if err == nil {
+			return frame, nil
+		}
+		if !errors.Is(err, bufio.ErrBufferFull) {
+			// the last message may be not ended by the new line
+			if err == io.EOF && len(frame) != 0 {
+				return frame, nil
+			}
+			return nil, err
+		}
+	}
+}
+
+// in passes the parsed message or the raw one if it can't be parsed, it returns the event buffer to reuse
+func (p *Plugin) in(sourceID pipeline.SourceID, sourceName string, data, event []byte, m *message) []byte {
+	data = bytes.TrimRight(data, "\r\n\x00")
+	if len(data) == 0 {
+		return event
+	}
+
+	event = event[:0]
+	if parse(m, data) {
+		event = appendJSON(event, m)
+	} else {
+		p.parseErrorsMetric.WithLabelValues().Inc()
+		event = append(event, '{')
+		event = appendString(event, p.config.FallbackField)
+		event = append(event, ':')
+		event = appendString(event, string(data))
+		event = append(event, '}')
+	}
+
+	_ = p.controller.In(sourceID, sourceName, 0, event, false)
+	return event
+}
+
+func (p *Plugin) Stop() {
+	p.stopped.Store(true)
+
+	if p.udpConn != nil {
+		_ = p.udpConn.Close()
+	}
+	if p.tcpListener != nil {
+		_ = p.tcpListener.Close()
+	}
+
+	p.connsMu.Lock()
+	for conn := range p.conns {
+		_ = conn.Close()
+	}
+	p.connsMu.Unlock()
+}
+
+func (p *Plugin) Commit(_ *pipeline.Event) {
+}
+
+// PassEvent decides pass or discard event.
+func (p *Plugin) PassEvent(_ *pipeline.Event) bool {
+	return true
+}
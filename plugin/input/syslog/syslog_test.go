@@ -0,0 +1,114 @@
+package syslog
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ozontech/file.d/decoder"
+	"github.com/ozontech/file.d/pipeline"
+	"github.com/ozontech/file.d/test"
+	"github.com/stretchr/testify/require"
+)
+
+type controller struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (c *controller) In(_ pipeline.SourceID, _ string, _ int64, data []byte, _ bool) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.events = append(c.events, string(data))
+	return uint64(len(c.events))
+}
+
+func (c *controller) received() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.events...)
+}
+
+func (c *controller) UseSpread()                               {}
+func (c *controller) DisableStreams()                          {}
+func (c *controller) SuggestDecoder(_ decoder.DecoderType)     {}
+func (c *controller) IncReadOps()                              {}
+func (c *controller) IncMaxEventSizeExceeded()                 {}
+func (c *controller) IsSourceLimited(_ pipeline.SourceID) bool { return false }
+
+func startPlugin(t *testing.T, config *Config) (*Plugin, *controller) {
+	ctl := &controller{}
+	params := test.NewEmptyInputPluginParams(ctl)
+
+	p := &Plugin{}
+	p.Start(test.NewConfig(config, nil), params)
+	t.Cleanup(p.Stop)
+	return p, ctl
+}
+
+func TestPluginUDP(t *testing.T) {
+	p, ctl := startPlugin(t, &Config{UDPAddress: "127.0.0.1:0", TCPAddress: "off"})
+
+	conn, err := net.Dial("udp", p.udpConn.LocalAddr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.Write([]byte("<13>Feb  5 17:32:18 host app: hello\n"))
+	require.NoError(t, err)
+	_, err = conn.Write([]byte("malformed"))
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		return len(ctl.received()) == 2
+	}, time.Second*5, time.Millisecond*10)
+	require.Equal(t, []string{
+		`{"priority":13,"facility":1,"severity":5,"timestamp":"Feb  5 17:32:18","hostname":"host","app_name":"app","message":"hello"}`,
+		`{"raw_message":"malformed"}`,
+	}, ctl.received())
+}
+
+func TestPluginTCP(t *testing.T) {
+	p, ctl := startPlugin(t, &Config{UDPAddress: "off", TCPAddress: "127.0.0.1:0"})
+
+	conn, err := net.Dial("tcp", p.tcpListener.Addr().String())
+	require.NoError(t, err)
+
+	// octet counting and new line framing may be mixed
+	msg := "<34>1 - host app - - - multi\nline"
+	_, err = conn.Write([]byte(strconv.Itoa(len(msg)) + " " + msg + "<13>first\r\n<13>second\n"))
+	require.NoError(t, err)
+	_, err = conn.Write([]byte("<13>last without new line"))
+	require.NoError(t, err)
+	require.NoError(t, conn.Close())
+
+	require.Eventually(t, func() bool {
+		return len(ctl.received()) == 4
+	}, time.Second*5, time.Millisecond*10)
+	require.Equal(t, []string{
+		`{"priority":34,"facility":4,"severity":2,"version":1,"hostname":"host","app_name":"app","message":"multi\nline"}`,
+		`{"priority":13,"facility":1,"severity":5,"message":"first"}`,
+		`{"priority":13,"facility":1,"severity":5,"message":"second"}`,
+		`{"priority":13,"facility":1,"severity":5,"message":"last without new line"}`,
+	}, ctl.received())
+}
+
+func TestReadFrameTooLong(t *testing.T) {
+	p, _ := startPlugin(t, &Config{UDPAddress: "off", TCPAddress: "127.0.0.1:0"})
+
+	conn, err := net.Dial("tcp", p.tcpListener.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.Write([]byte("99999999 <13>x"))
+	require.NoError(t, err)
+
+	// the connection is closed by the plugin
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = conn.Read(make([]byte, 1))
+	require.Error(t, err)
+	var netErr net.Error
+	require.False(t, errors.As(err, &netErr) && netErr.Timeout())
+}
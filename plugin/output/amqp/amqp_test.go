@@ -0,0 +1,461 @@
+package amqp
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ozontech/file.d/pipeline"
+	"github.com/ozontech/file.d/test"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	insaneJSON "github.com/vitkovskii/insane-json"
+)
+
+// the methods of the AMQP 0-9-1 used by the fake broker, the class is the high word
+const (
+	methodConnectionStart   = 10<<16 | 10
+	methodConnectionStartOk = 10<<16 | 11
+	methodConnectionTune    = 10<<16 | 30
+	methodConnectionTuneOk  = 10<<16 | 31
+	methodConnectionOpen    = 10<<16 | 40
+	methodConnectionOpenOk  = 10<<16 | 41
+	methodConnectionClose   = 10<<16 | 50
+	methodConnectionCloseOk = 10<<16 | 51
+	methodChannelOpen       = 20<<16 | 10
+	methodChannelOpenOk     = 20<<16 | 11
+	methodBasicPublish      = 60<<16 | 40
+	methodBasicAck          = 60<<16 | 80
+	methodBasicNack         = 60<<16 | 120
+	methodConfirmSelect     = 85<<16 | 10
+	methodConfirmSelectOk   = 85<<16 | 11
+
+	frameMethod    = 1
+	frameHeader    = 2
+	frameBody      = 3
+	frameHeartbeat = 8
+	frameEnd       = 0xCE
+
+	propContentType = 1 << 15
+)
+
+type frame struct {
+	kind    byte
+	channel uint16
+	payload []byte
+}
+
+func readFrame(r *bufio.Reader) (frame, error) {
+	header := make([]byte, 7)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return frame{}, err
+	}
+	payload := make([]byte, binary.BigEndian.Uint32(header[3:])+1)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return frame{}, err
+	}
+	if payload[len(payload)-1] != frameEnd {
+		return frame{}, errors.New("wrong frame end")
+	}
+	return frame{kind: header[0], channel: binary.BigEndian.Uint16(header[1:]), payload: payload[:len(payload)-1]}, nil
+}
+
+func writeFrame(w io.Writer, kind byte, channel uint16, payload []byte) {
+	out := []byte{kind}
+	out = binary.BigEndian.AppendUint16(out, channel)
+	out = binary.BigEndian.AppendUint32(out, uint32(len(payload)))
+	out = append(out, payload...)
+	out = append(out, frameEnd)
+	_, _ = w.Write(out)
+}
+
+// decoder reads the fields of the method or the content header
+type decoder struct {
+	b []byte
+}
+
+func (d *decoder) take(n int) []byte {
+	if n > len(d.b) {
+		n = len(d.b)
+	}
+	v := d.b[:n]
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *decoder) octet() byte {
+	if v := d.take(1); len(v) == 1 {
+		return v[0]
+	}
+	return 0
+}
+
+func (d *decoder) short() uint16 {
+	if v := d.take(2); len(v) == 2 {
+		return binary.BigEndian.Uint16(v)
+	}
+	return 0
+}
+
+func (d *decoder) long() uint32 {
+	if v := d.take(4); len(v) == 4 {
+		return binary.BigEndian.Uint32(v)
+	}
+	return 0
+}
+
+func (d *decoder) longlong() uint64 {
+	if v := d.take(8); len(v) == 8 {
+		return binary.BigEndian.Uint64(v)
+	}
+	return 0
+}
+
+func (d *decoder) shortstr() string {
+	return string(d.take(int(d.octet())))
+}
+
+func (d *decoder) longstr() string {
+	return string(d.take(int(d.long())))
+}
+
+func method(f frame) (uint32, *decoder) {
+	if f.kind != frameMethod {
+		return 0, nil
+	}
+	d := &decoder{b: f.payload}
+	return d.long(), d
+}
+
+// encoder appends the fields of the method
+type encoder struct {
+	out []byte
+}
+
+func (e *encoder) octet(v byte) { e.out = append(e.out, v) }
+
+func (e *encoder) short(v uint16) { e.out = binary.BigEndian.AppendUint16(e.out, v) }
+
+func (e *encoder) long(v uint32) { e.out = binary.BigEndian.AppendUint32(e.out, v) }
+
+func (e *encoder) longlong(v uint64) { e.out = binary.BigEndian.AppendUint64(e.out, v) }
+
+func (e *encoder) shortstr(v string) {
+	e.octet(byte(len(v)))
+	e.out = append(e.out, v...)
+}
+
+func (e *encoder) longstr(v string) {
+	e.long(uint32(len(v)))
+	e.out = append(e.out, v...)
+}
+
+type message struct {
+	exchange     string
+	routingKey   string
+	contentType  string
+	deliveryMode byte
+	body         string
+}
+
+// fakeBroker acks the messages, it nacks the messages containing "nack" on the first attempt
+// and drops the connection without the confirm on the messages containing "drop" on the first attempt
+type fakeBroker struct {
+	listener net.Listener
+	frameMax int
+
+	mu       sync.Mutex
+	conns    int
+	auth     string
+	vhost    string
+	messages []message
+	attempts map[string]int
+}
+
+func newFakeBroker(t *testing.T, frameMax int) *fakeBroker {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = l.Close() })
+
+	b := &fakeBroker{listener: l, frameMax: frameMax, attempts: map[string]int{}}
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go b.serve(c)
+		}
+	}()
+	return b
+}
+
+func (b *fakeBroker) send(c net.Conn, channel uint16, m uint32, args func(e *encoder)) {
+	e := &encoder{}
+	e.long(m)
+	if args != nil {
+		args(e)
+	}
+	writeFrame(c, frameMethod, channel, e.out)
+}
+
+// expect reads the frames until the method, the heartbeats are skipped
+func (b *fakeBroker) expect(r *bufio.Reader, m uint32) *decoder {
+	for {
+		f, err := readFrame(r)
+		if err != nil {
+			return nil
+		}
+		if f.kind == frameHeartbeat {
+			continue
+		}
+		got, d := method(f)
+		if got != m {
+			return nil
+		}
+		return d
+	}
+}
+
+func (b *fakeBroker) serve(c net.Conn) {
+	defer func() { _ = c.Close() }()
+
+	r := bufio.NewReader(c)
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(r, header); err != nil || string(header) != "AMQP\x00\x00\x09\x01" {
+		return
+	}
+
+	b.mu.Lock()
+	b.conns++
+	b.mu.Unlock()
+
+	b.send(c, 0, methodConnectionStart, func(e *encoder) {
+		e.octet(0)
+		e.octet(9)
+		e.long(0)
+		e.longstr("PLAIN AMQPLAIN")
+		e.longstr("en_US")
+	})
+	d := b.expect(r, methodConnectionStartOk)
+	if d == nil {
+		return
+	}
+	// the client properties
+	d.take(int(d.long()))
+	d.shortstr()
+	auth := d.longstr()
+
+	b.send(c, 0, methodConnectionTune, func(e *encoder) {
+		e.short(2047)
+		e.long(uint32(b.frameMax))
+		e.short(0)
+	})
+	if b.expect(r, methodConnectionTuneOk) == nil {
+		return
+	}
+	if d = b.expect(r, methodConnectionOpen); d == nil {
+		return
+	}
+	vhost := d.shortstr()
+
+	b.mu.Lock()
+	b.auth = auth
+	b.vhost = vhost
+	b.mu.Unlock()
+
+	b.send(c, 0, methodConnectionOpenOk, func(e *encoder) { e.shortstr("") })
+	if b.expect(r, methodChannelOpen) == nil {
+		return
+	}
+	b.send(c, 1, methodChannelOpenOk, func(e *encoder) { e.longstr("") })
+	if b.expect(r, methodConfirmSelect) == nil {
+		return
+	}
+	b.send(c, 1, methodConfirmSelectOk, nil)
+
+	tag := uint64(0)
+	for {
+		f, err := readFrame(r)
+		if err != nil {
+			return
+		}
+		if f.kind == frameHeartbeat {
+			continue
+		}
+		m, d := method(f)
+		if m == methodConnectionClose {
+			b.send(c, 0, methodConnectionCloseOk, nil)
+			return
+		}
+		if m != methodBasicPublish {
+			return
+		}
+
+		msg := message{}
+		d.short()
+		msg.exchange = d.shortstr()
+		msg.routingKey = d.shortstr()
+
+		if f, err = readFrame(r); err != nil || f.kind != frameHeader {
+			return
+		}
+		d = &decoder{b: f.payload}
+		d.short()
+		d.short()
+		size := int(d.longlong())
+		flags := d.short()
+		if flags&propContentType != 0 {
+			msg.contentType = d.shortstr()
+		}
+		msg.deliveryMode = d.octet()
+
+		body := make([]byte, 0, size)
+		for len(body) < size {
+			if f, err = readFrame(r); err != nil || f.kind != frameBody {
+				return
+			}
+			body = append(body, f.payload...)
+		}
+		msg.body = string(body)
+		tag++
+
+		b.mu.Lock()
+		b.attempts[msg.body]++
+		first := b.attempts[msg.body] == 1
+		b.messages = append(b.messages, msg)
+		b.mu.Unlock()
+
+		switch {
+		case first && strings.Contains(msg.body, "drop"):
+			return
+		case first && strings.Contains(msg.body, "nack"):
+			b.send(c, 1, methodBasicNack, func(e *encoder) {
+				e.longlong(tag)
+				e.octet(0)
+			})
+		default:
+			b.send(c, 1, methodBasicAck, func(e *encoder) {
+				e.longlong(tag)
+				e.octet(0)
+			})
+		}
+	}
+}
+
+func startPlugin(t *testing.T, config *Config, params *pipeline.OutputPluginParams) (*Plugin, *fakeBroker) {
+	broker := newFakeBroker(t, 4096)
+
+	config.Servers = []string{"amqp://user:secret@" + broker.listener.Addr().String() + "/logs"}
+	p := &Plugin{}
+	test.StartOutputPlugin(t, p, config, params)
+
+	return p, broker
+}
+
+type controller struct {
+	mu      sync.Mutex
+	commits []string
+}
+
+func (c *controller) Commit(event *pipeline.Event) {
+	c.mu.Lock()
+	c.commits = append(c.commits, event.Root.EncodeToString())
+	c.mu.Unlock()
+}
+
+func (c *controller) Error(err string) {
+	panic(err)
+}
+
+func TestOutConfirms(t *testing.T) {
+	ctl := &controller{}
+	params := test.NewEmptyOutputPluginParams()
+	params.Controller = ctl
+	p, broker := startPlugin(t, &Config{
+		Exchange:          "logs",
+		RoutingKey:        "${service}.${level}",
+		BatchSize:         "3",
+		BatchFlushTimeout: "1h",
+		Retention:         "10ms",
+	}, params)
+
+	large := `{"service":"b","message":"` + strings.Repeat("x", 10000) + `"}`
+	events := []string{`{"service":"a","level":"info","message":"acked"}`, `{"service":"a","message":"nack"}`, large}
+	for _, e := range events {
+		root, err := insaneJSON.DecodeString(e)
+		require.NoError(t, err)
+		p.Out(&pipeline.Event{Root: root})
+	}
+
+	require.Eventually(t, func() bool {
+		ctl.mu.Lock()
+		defer ctl.mu.Unlock()
+		return len(ctl.commits) == len(events)
+	}, 5*time.Second, 10*time.Millisecond)
+
+	broker.mu.Lock()
+	defer broker.mu.Unlock()
+
+	assert.Equal(t, "\x00user\x00secret", broker.auth)
+	assert.Equal(t, "logs", broker.vhost)
+	assert.Equal(t, 1, broker.conns)
+
+	// only the nacked message is published again
+	require.Len(t, broker.messages, 4)
+	assert.Equal(t, message{
+		exchange:     "logs",
+		routingKey:   "a.info",
+		contentType:  "application/json",
+		deliveryMode: 2,
+		body:         events[0],
+	}, broker.messages[0])
+	assert.Equal(t, "a.", broker.messages[1].routingKey)
+	assert.Equal(t, large, broker.messages[2].body)
+	assert.Equal(t, events[1], broker.messages[3].body)
+	assert.Equal(t, float64(1), testutil.ToFloat64(p.nackedMetric.WithLabelValues()))
+}
+
+func TestOutReconnect(t *testing.T) {
+	p, broker := startPlugin(t, &Config{}, test.NewEmptyOutputPluginParams())
+
+	workerData := pipeline.WorkerData(nil)
+	batch := test.NewBatch(t, `{"message":"drop"}`, `{"message":"ok"}`)
+	assert.Error(t, p.out(&workerData, batch))
+	assert.NoError(t, p.out(&workerData, batch))
+
+	broker.mu.Lock()
+	defer broker.mu.Unlock()
+
+	assert.Equal(t, 2, broker.conns)
+	assert.Len(t, broker.messages, 3)
+	assert.Equal(t, float64(1), testutil.ToFloat64(p.publishErrorsMetric.WithLabelValues()))
+}
+
+func TestOutRaw(t *testing.T) {
+	p, broker := startPlugin(t, &Config{
+		Format:       "raw",
+		DeliveryMode: "transient",
+	}, test.NewEmptyOutputPluginParams())
+
+	workerData := pipeline.WorkerData(nil)
+	batch := test.NewBatch(t, `{"message":"text"}`, `{"message":{"a":1}}`, `{"level":"info"}`)
+	require.NoError(t, p.out(&workerData, batch))
+
+	broker.mu.Lock()
+	defer broker.mu.Unlock()
+
+	require.Len(t, broker.messages, 3)
+	assert.Equal(t, "text", broker.messages[0].body)
+	assert.Equal(t, "text/plain", broker.messages[0].contentType)
+	assert.Equal(t, byte(1), broker.messages[0].deliveryMode)
+	assert.Equal(t, `{"a":1}`, broker.messages[1].body)
+	assert.Equal(t, `{"level":"info"}`, broker.messages[2].body)
+}
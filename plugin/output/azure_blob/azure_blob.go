@@ -0,0 +1,354 @@
+// Package azure_blob is an output plugin that uploads events to Azure Blob Storage.
+package azure_blob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+	"github.com/ozontech/file.d/cfg"
+	"github.com/ozontech/file.d/fd"
+	"github.com/ozontech/file.d/metric"
+	"github.com/ozontech/file.d/pipeline"
+	"github.com/ozontech/file.d/plugin/output/internal/objectstore"
+	"github.com/ozontech/file.d/xtls"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+/*{ introduction
+It uploads events to the block blobs of Azure Blob Storage. The events are written in the NDJSON format,
+each batch is uploaded as the blob per path, so the blob is rolled over when the batch reaches
+`batch_size` or `batch_size_bytes`, or after `batch_flush_timeout` like the file of the `s3` output.
+The blob which is larger than `block_size` is uploaded by the blocks.
+
+The path of the blob is `path_template` rendered for the events, the blob name is
+`<path>/<unix_nano>_<random>.log` with the `.gz` or the `.zst` extension if the compression is set.
+The events are committed only after their blobs are uploaded, so there is no local buffer like in the `s3` output.
+
+The plugin authenticates by:
+* `connection_string` – the account key or the shared access signature of the storage account connection string
+* `sas_token` – the shared access signature of the container
+* `managed_identity` – the token of the managed identity
+
+The blobs are uploaded by the Azure SDK, its retries are disabled since the batches are retried by the plugin.
+
+**Example:**
+```yaml
+pipelines:
+  example_pipeline:
+    ...
+    output:
+      type: azure_blob
+      connection_string: "DefaultEndpointsProtocol=https;AccountName=logs;AccountKey=...;EndpointSuffix=core.windows.net"
+      container: archive
+      path_template: "${service}/%Y/%m/%d/%H"
+      time_field: ts
+      compression: zstd
+    ...
+```
+The event `{"service":"api","ts":"2024-05-01T10:15:00Z"}` is uploaded to the blob like
+`archive/api/2024/05/01/10/1714558500000000000_3f2a9c1d.log.zst`.
+}*/
+
+const (
+	outPluginType = "azure_blob"
+)
+
+const (
+	authConnectionString = iota
+	authSASToken
+	authManagedIdentity
+)
+
+type Plugin struct {
+	config     *Config
+	logger     *zap.SugaredLogger
+	batcher    *pipeline.Batcher
+	controller pipeline.OutputPluginController
+
+	client   *container.Client
+	template *objectstore.Template
+
+	// plugin metrics
+
+	uploadedBlobsMetric *prometheus.CounterVec
+	uploadErrorsMetric  *prometheus.CounterVec
+	droppedEventsMetric *prometheus.CounterVec
+}
+
+// ! config-params
+// ^ config-params
+type Config struct {
+	// > @3@4@5@6
+	// >
+	// > How to authenticate:
+	// > * `connection_string` – by the account key or the shared access signature of `connection_string`
+	// > * `sas_token` – by `sas_token`, `account_url` should be set
+	// > * `managed_identity` – by the token of the managed identity, `account_url` should be set
+	Auth  string `json:"auth" default:"connection_string" options:"connection_string|sas_token|managed_identity"` // *
+	Auth_ int
+
+	// > @3@4@5@6
+	// >
+	// > The connection string of the storage account, e.g.
+	// > `DefaultEndpointsProtocol=https;AccountName=logs;AccountKey=...;EndpointSuffix=core.windows.net`.
+	// > `UseDevelopmentStorage=true` connects to the local Azurite emulator.
+	ConnectionString string `json:"connection_string"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The URL of the Blob service for `sas_token` and `managed_identity`, e.g. `https://logs.blob.core.windows.net`.
+	AccountURL string `json:"account_url"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The shared access signature, e.g. `sv=2021-08-06&ss=b&srt=co&sp=wc&se=...&sig=...`.
+	// > It should allow to create and write the blobs.
+	SASToken string `json:"sas_token"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The client id of the user-assigned managed identity. If it's empty, the system-assigned one is used.
+	// > The token endpoint is detected by the environment like `IDENTITY_ENDPOINT` of App Service,
+	// > otherwise it's the instance metadata service.
+	ManagedIdentityClientID string `json:"managed_identity_client_id"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The container to upload the blobs to. It isn't created by the plugin.
+	Container string `json:"container" required:"true"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The path of the blobs, the `${field}` parts are replaced by the values of the event fields and
+	// > `%Y`, `%m`, `%d`, `%H`, `%M`, `%S` are replaced by the UTC time of the event, `%%` is `%`.
+	// > The slashes and the control characters of the values are replaced by `_`, the missing values are `_`.
+	// > The events of the batch are grouped into the blobs by the rendered path.
+	PathTemplate string `json:"path_template" default:"%Y/%m/%d/%H"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The event field which contains the time for the path. If it's empty or the event time can't be parsed,
+	// > the time of the upload is used.
+	TimeField  cfg.FieldSelector `json:"time_field" parse:"selector"` // *
+	TimeField_ []string
+
+	// > @3@4@5@6
+	// >
+	// > The format of `time_field`, it's one of
+	// > `ansic|unixdate|rubydate|rfc822|rfc822z|rfc850|rfc1123|rfc1123z|rfc3339|rfc3339nano|kitchen|stamp|stampmilli|stampmicro|stampnano|unixtime|nginx_errorlog`
+	// > or the Go reference layout.
+	TimeFormat string `json:"time_format" default:"rfc3339nano"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The compression of the blobs.
+	Compression string `json:"compression" default:"gzip" options:"none|gzip|zstd"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The max size of the blob uploaded by the one request in bytes, the larger blobs are uploaded by the blocks of this size.
+	BlockSize  cfg.Expression `json:"block_size" default:"4194304" parse:"expression"` // *
+	BlockSize_ int
+
+	// > @3@4@5@6
+	// >
+	// > The CA certificate to verify the Blob service, it's a PEM content or a path to the file.
+	CACert string `json:"ca_cert"` // *
+
+	// > @3@4@5@6
+	// >
+	// > Skip the verification of the Blob service certificate.
+	InsecureSkipVerify bool `json:"insecure_skip_verify" default:"false"` // *
+
+	// > @3@4@5@6
+	// >
+	// > How many workers will be instantiated to send batches.
+	WorkersCount  cfg.Expression `json:"workers_count" default:"gomaxprocs*4" parse:"expression"` // *
+	WorkersCount_ int
+
+	// > @3@4@5@6
+	// >
+	// > Client timeout when uploads the blob.
+	RequestTimeout  cfg.Duration `json:"request_timeout" default:"1m" parse:"duration"` // *
+	RequestTimeout_ time.Duration
+
+	// > @3@4@5@6
+	// >
+	// > A maximum quantity of events to pack into one batch.
+	BatchSize  cfg.Expression `json:"batch_size" default:"capacity/4" parse:"expression"` // *
+	BatchSize_ int
+
+	// > @3@4@5@6
+	// >
+	// > A minimum size of events in a batch to send.
+	// > If both batch_size and batch_size_bytes are set, they will work together.
+	BatchSizeBytes  cfg.Expression `json:"batch_size_bytes" default:"0" parse:"expression"` // *
+	BatchSizeBytes_ int
+
+	// > @3@4@5@6
+	// >
+	// > After this timeout the batch will be sent even if batch isn't completed.
+	BatchFlushTimeout  cfg.Duration `json:"batch_flush_timeout" default:"10s" parse:"duration"` // *
+	BatchFlushTimeout_ time.Duration
+
+	// > @3@4@5@6
+	// >
+	// > Retries of the upload.
+	Retry int `json:"retry" default:"10"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The delay before the first retry, it's doubled after each retry.
+	Retention  cfg.Duration `json:"retention" default:"1s" parse:"duration"` // *
+	Retention_ time.Duration
+
+	// > @3@4@5@6
+	// >
+	// > The max delay between the retries.
+	MaxRetention  cfg.Duration `json:"max_retention" default:"1m" parse:"duration"` // *
+	MaxRetention_ time.Duration
+
+	// > @3@4@5@6
+	// >
+	// > Exit with the non-zero code if the batch isn't uploaded after all the retries, otherwise the batch is dropped.
+	FatalOnFailedInsert bool `json:"fatal_on_failed_insert" default:"false"` // *
+}
+
+type data struct {
+	blobs *objectstore.Objects
+}
+
+func init() {
+	fd.DefaultPluginRegistry.RegisterOutput(&pipeline.PluginStaticInfo{
+		Type:    outPluginType,
+		Factory: Factory,
+	})
+}
+
+func Factory() (pipeline.AnyPlugin, pipeline.AnyConfig) {
+	return &Plugin{}, &Config{}
+}
+
+func (p *Plugin) Start(config pipeline.AnyConfig, params *pipeline.OutputPluginParams) {
+	p.controller = params.Controller
+	p.logger = params.Logger
+	p.config = config.(*Config)
+	p.registerMetrics(params.MetricCtl)
+
+	if err := p.prepare(); err != nil {
+		p.logger.Fatal(err.Error())
+	}
+
+	p.batcher = pipeline.NewBatcher(pipeline.BatcherOptions{
+		PipelineName:   params.PipelineName,
+		OutputType:     outPluginType,
+		OutFn:          p.out,
+		Controller:     p.controller,
+		Workers:        p.config.WorkersCount_,
+		BatchSizeCount: p.config.BatchSize_,
+		BatchSizeBytes: p.config.BatchSizeBytes_,
+		FlushTimeout:   p.config.BatchFlushTimeout_,
+		MaxEventAge:    params.PipelineSettings.MaxEventAge,
+		CircuitBreaker: params.PipelineSettings.CircuitBreaker,
+		Health:         params.Health,
+		ErrorReporter:  params.ErrorReporter,
+		DeadLetter:     params.DeadLetter,
+		MetricCtl:      params.MetricCtl,
+		Compression:    pipeline.BatchCompression(p.config.Compression),
+		Retry: pipeline.BatcherRetry{
+			MaxRetries:        p.config.Retry,
+			InitialBackoff:    p.config.Retention_,
+			BackoffMultiplier: 2,
+			MaxBackoff:        p.config.MaxRetention_,
+		},
+		FatalOnFailedInsert: p.config.FatalOnFailedInsert,
+	})
+
+	p.batcher.Start(context.TODO())
+}
+
+// prepare parses the path template and creates the client of the container with the credential of the auth
+func (p *Plugin) prepare() error {
+	template, err := objectstore.NewTemplate(p.config.PathTemplate, p.config.TimeField_, p.config.TimeFormat)
+	if err != nil {
+		return err
+	}
+	p.template = template
+
+	if p.config.BlockSize_ <= 0 {
+		return errors.New("block_size should be positive")
+	}
+
+	httpClient, err := xtls.NewHTTPClient(p.config.CACert, p.config.InsecureSkipVerify, p.config.RequestTimeout_)
+	if err != nil {
+		return fmt.Errorf("can't create client: %w", err)
+	}
+
+	p.client, err = p.newContainerClient(httpClient)
+	return err
+}
+
+func (p *Plugin) registerMetrics(ctl *metric.Ctl) {
+	p.uploadedBlobsMetric = ctl.RegisterCounter("output_azure_blob_uploaded_blobs_total", "Number of uploaded blobs")
+	p.uploadErrorsMetric = ctl.RegisterCounter("output_azure_blob_upload_errors_total", "Number of failed uploads which are retried")
+	p.droppedEventsMetric = ctl.RegisterCounter("output_azure_blob_dropped_events_total", "Number of events rejected by the Blob service")
+}
+
+func (p *Plugin) Stop() {
+	p.batcher.Stop()
+}
+
+func (p *Plugin) Out(event *pipeline.Event) {
+	p.batcher.Add(event)
+}
+
+func (p *Plugin) out(workerData *pipeline.WorkerData, batch *pipeline.Batch) error {
+	if *workerData == nil {
+		*workerData = &data{
+			blobs: objectstore.NewObjects(p.template),
+		}
+	}
+
+	data := (*workerData).(*data)
+	for _, b := range data.blobs.Group(batch) {
+		err := p.uploadBlob(data, batch, b)
+		if err == nil {
+			p.uploadedBlobsMetric.WithLabelValues().Inc()
+			continue
+		}
+
+		if !isRetryable(err) {
+			p.droppedEventsMetric.WithLabelValues().Add(float64(len(b.Events)))
+			reason := fmt.Sprintf("blob is rejected by azure container=%s path=%s: %s", p.config.Container, b.Path, err.Error())
+			for _, event := range b.Events {
+				batch.MarkRejected(event, reason)
+			}
+			continue
+		}
+
+		p.uploadErrorsMetric.WithLabelValues().Inc()
+		p.logger.Errorf("can't upload blob to azure container=%s path=%s: %s", p.config.Container, b.Path, err.Error())
+		for _, event := range b.Events {
+			batch.MarkFailed(event)
+		}
+	}
+
+	data.blobs.Release()
+
+	return nil
+}
+
+func (p *Plugin) uploadBlob(data *data, batch *pipeline.Batch, b *objectstore.Object) error {
+	body, err := batch.Compress(b.Body)
+	if err != nil {
+		return fmt.Errorf("can't compress blob: %w", err)
+	}
+
+	extension, contentType := objectstore.Format(batch.Compression())
+	client := p.client.NewBlockBlobClient(data.blobs.Name(b, extension))
+	return upload(context.Background(), client, body, contentType, p.config.BlockSize_)
+}
@@ -0,0 +1,275 @@
+package azure_blob
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+	"github.com/ozontech/file.d/cfg"
+	"github.com/ozontech/file.d/pipeline"
+	"github.com/ozontech/file.d/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeBlobService stores the blobs uploaded by the Put Blob and the Put Block List requests
+type fakeBlobService struct {
+	mu       sync.Mutex
+	blobs    map[string][]byte
+	types    map[string]string
+	blocks   map[string][]byte
+	requests []*http.Request
+	// statuses are the statuses of the next responses, then the requests are handled
+	statuses []int
+}
+
+func newFakeBlobService(t *testing.T, statuses ...int) (*fakeBlobService, string) {
+	f := &fakeBlobService{
+		blobs:    make(map[string][]byte),
+		types:    make(map[string]string),
+		blocks:   make(map[string][]byte),
+		statuses: statuses,
+	}
+	// the SDK sends the bearer tokens only by TLS
+	server := httptest.NewTLSServer(f)
+	t.Cleanup(server.Close)
+	return f, server.URL
+}
+
+func (f *fakeBlobService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	body, _ := io.ReadAll(r.Body)
+	f.requests = append(f.requests, r)
+	if len(f.statuses) > 0 {
+		status := f.statuses[0]
+		f.statuses = f.statuses[1:]
+		w.Header().Set("x-ms-error-code", "FakeError")
+		w.WriteHeader(status)
+		return
+	}
+
+	name := r.URL.Path
+	switch r.URL.Query().Get("comp") {
+	case "block":
+		f.blocks[name+"#"+r.URL.Query().Get("blockid")] = body
+	case "blocklist":
+		list := struct {
+			Latest []string `xml:"Latest"`
+		}{}
+		if err := xml.Unmarshal(body, &list); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		blob := make([]byte, 0)
+		for _, id := range list.Latest {
+			blob = append(blob, f.blocks[name+"#"+id]...)
+		}
+		f.blobs[name] = blob
+		f.types[name] = r.Header.Get("x-ms-blob-content-type")
+	default:
+		if r.Header.Get("x-ms-blob-type") != "BlockBlob" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		f.blobs[name] = body
+		f.types[name] = r.Header.Get("x-ms-blob-content-type")
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+// uploaded returns the uploaded blobs by the path without the blob name
+func (f *fakeBlobService) uploaded(t *testing.T, compression string) map[string]string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	result := make(map[string]string)
+	for name, blob := range f.blobs {
+		dir := name[:strings.LastIndexByte(name, '/')]
+		switch compression {
+		case "gzip":
+			assert.True(t, strings.HasSuffix(name, ".log.gz"), name)
+			r, err := gzip.NewReader(bytes.NewReader(blob))
+			require.NoError(t, err)
+			blob, err = io.ReadAll(r)
+			require.NoError(t, err)
+		case "zstd":
+			assert.True(t, strings.HasSuffix(name, ".log.zst"), name)
+			r, err := zstd.NewReader(bytes.NewReader(blob))
+			require.NoError(t, err)
+			blob, err = io.ReadAll(r)
+			require.NoError(t, err)
+			r.Close()
+		default:
+			assert.True(t, strings.HasSuffix(name, ".log"), name)
+		}
+		result[dir] += string(blob)
+	}
+	return result
+}
+
+func TestUpload(t *testing.T) {
+	cases := []struct {
+		name        string
+		compression string
+		blockSize   cfg.Expression
+		wantType    string
+	}{
+		{name: "none", compression: "none", wantType: "application/x-ndjson"},
+		{name: "gzip", compression: "gzip", wantType: "application/gzip"},
+		{name: "zstd", compression: "zstd", wantType: "application/zstd"},
+		{name: "blocks", compression: "none", blockSize: "16", wantType: "application/x-ndjson"},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			service, endpoint := newFakeBlobService(t)
+			p := &Plugin{}
+			test.StartOutputPlugin(t, p, &Config{
+				ConnectionString:   "BlobEndpoint=" + endpoint + "/account;AccountName=account;AccountKey=a2V5",
+				Container:          "logs",
+				PathTemplate:       "/${service}/%Y/%m/%d/%H/",
+				TimeField:          "ts",
+				Compression:        tt.compression,
+				BlockSize:          tt.blockSize,
+				InsecureSkipVerify: true,
+			}, nil)
+
+			workerData := pipeline.WorkerData(nil)
+			require.NoError(t, p.out(&workerData, test.NewCompressedBatch(t, pipeline.BatchCompression(tt.compression),
+				`{"service":"api","ts":"2024-05-01T10:15:00Z","message":"first"}`,
+				`{"service":"db/main","ts":"2024-05-01T10:20:00Z","message":"second"}`,
+				`{"service":"api","ts":"2024-05-01T11:00:00+03:00","message":"third"}`,
+			)))
+
+			assert.Equal(t, map[string]string{
+				"/account/logs/api/2024/05/01/08":     `{"service":"api","ts":"2024-05-01T11:00:00+03:00","message":"third"}` + "\n",
+				"/account/logs/api/2024/05/01/10":     `{"service":"api","ts":"2024-05-01T10:15:00Z","message":"first"}` + "\n",
+				"/account/logs/db_main/2024/05/01/10": `{"service":"db/main","ts":"2024-05-01T10:20:00Z","message":"second"}` + "\n",
+			}, service.uploaded(t, tt.compression))
+			for _, contentType := range service.types {
+				assert.Equal(t, tt.wantType, contentType)
+			}
+			for _, r := range service.requests {
+				assert.True(t, strings.HasPrefix(r.Header.Get("Authorization"), "SharedKey account:"))
+				assert.NotEmpty(t, r.Header.Get("x-ms-version"))
+			}
+			if tt.blockSize != "" {
+				assert.Greater(t, len(service.requests), 3)
+			}
+		})
+	}
+}
+
+func TestUploadErrors(t *testing.T) {
+	service, endpoint := newFakeBlobService(t, http.StatusServiceUnavailable, http.StatusNotFound)
+	p := &Plugin{}
+	test.StartOutputPlugin(t, p, &Config{
+		Auth:               "sas_token",
+		AccountURL:         endpoint,
+		SASToken:           "?sv=2021-08-06&sig=c2ln",
+		Container:          "logs",
+		PathTemplate:       "${service}",
+		Compression:        "none",
+		InsecureSkipVerify: true,
+	}, nil)
+
+	batch := test.NewBatch(t,
+		`{"service":"api","message":"retried"}`,
+		`{"service":"db","message":"dropped"}`,
+		`{"service":"web","message":"uploaded"}`,
+	)
+	workerData := pipeline.WorkerData(nil)
+	require.NoError(t, p.out(&workerData, batch))
+
+	assert.Equal(t, map[string]string{
+		"/logs/web": `{"service":"web","message":"uploaded"}` + "\n",
+	}, service.uploaded(t, "none"))
+	for _, r := range service.requests {
+		assert.Equal(t, "2021-08-06", r.URL.Query().Get("sv"))
+		assert.Equal(t, "c2ln", r.URL.Query().Get("sig"))
+		assert.Empty(t, r.Header.Get("Authorization"))
+	}
+
+	// only the events of the blob failed with the retryable status are sent again
+	failed := test.NewBatch(t, `{"service":"api","message":"retried"}`)
+	require.NoError(t, p.out(&workerData, failed))
+	assert.Equal(t, map[string]string{
+		"/logs/api": `{"service":"api","message":"retried"}` + "\n",
+		"/logs/web": `{"service":"web","message":"uploaded"}` + "\n",
+	}, service.uploaded(t, "none"))
+}
+
+func TestManagedIdentity(t *testing.T) {
+	tokenRequests := make([]url.Values, 0)
+	identity := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "secret", r.Header.Get("X-IDENTITY-HEADER"))
+		tokenRequests = append(tokenRequests, r.URL.Query())
+		expiresOn := strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)
+		_, _ = w.Write([]byte(`{"access_token":"token","expires_on":"` + expiresOn + `","token_type":"Bearer"}`))
+	}))
+	t.Cleanup(identity.Close)
+	// the token endpoint of App Service
+	t.Setenv("IDENTITY_ENDPOINT", identity.URL)
+	t.Setenv("IDENTITY_HEADER", "secret")
+
+	service, endpoint := newFakeBlobService(t, http.StatusServiceUnavailable)
+	p := &Plugin{}
+	test.StartOutputPlugin(t, p, &Config{
+		Auth:                    "managed_identity",
+		AccountURL:              endpoint,
+		ManagedIdentityClientID: "client",
+		Container:               "logs",
+		PathTemplate:            "archive",
+		Compression:             "none",
+		InsecureSkipVerify:      true,
+	}, nil)
+
+	workerData := pipeline.WorkerData(nil)
+	// the token is cached by the retries
+	require.NoError(t, p.out(&workerData, test.NewBatch(t, `{"message":"first"}`)))
+	require.NoError(t, p.out(&workerData, test.NewBatch(t, `{"message":"first"}`)))
+	require.NoError(t, p.out(&workerData, test.NewBatch(t, `{"message":"second"}`)))
+
+	assert.Equal(t, map[string]string{
+		"/logs/archive": `{"message":"first"}` + "\n" + `{"message":"second"}` + "\n",
+	}, sortLines(service.uploaded(t, "none")))
+	require.Len(t, tokenRequests, 1)
+	assert.Equal(t, "https://storage.azure.com", tokenRequests[0].Get("resource"))
+	assert.Equal(t, "client", tokenRequests[0].Get("client_id"))
+	for _, r := range service.requests {
+		assert.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+	}
+}
+
+func TestDevelopmentStorage(t *testing.T) {
+	p := &Plugin{}
+	test.StartOutputPlugin(t, p, &Config{
+		ConnectionString: "UseDevelopmentStorage=true",
+		Container:        "logs",
+	}, nil)
+
+	assert.Equal(t, "http://127.0.0.1:10000/devstoreaccount1/logs", p.client.URL())
+}
+
+// sortLines sorts the lines of the blobs since the order of the blobs with the same path is random
+func sortLines(blobs map[string]string) map[string]string {
+	for path, content := range blobs {
+		lines := strings.SplitAfter(content, "\n")
+		sort.Strings(lines)
+		blobs[path] = strings.Join(lines, "")
+	}
+	return blobs
+}
@@ -0,0 +1,117 @@
+package azure_blob
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/streaming"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+)
+
+const (
+	// devStorageConnectionString is the connection string of the Azurite emulator with its well-known credentials,
+	// the SDK doesn't support `UseDevelopmentStorage=true`
+	devStorageConnectionString = "DefaultEndpointsProtocol=http;AccountName=devstoreaccount1;" +
+		"AccountKey=Eby8vdM02xNOcqFlqUwJPLlmEtlCDXJ1OUzFT50uSRZ6IFsuFq2UVErCz4I6tq/K1SZFPTOtr/KBHBeksoGMGw==;" +
+		"BlobEndpoint=http://127.0.0.1:10000/devstoreaccount1"
+)
+
+// newContainerClient creates the client of the container with the credential of the auth,
+// the requests are sent by the http client and they aren't retried since the batcher retries them
+func (p *Plugin) newContainerClient(httpClient *http.Client) (*container.Client, error) {
+	options := &container.ClientOptions{
+		ClientOptions: azcore.ClientOptions{
+			Transport: httpClient,
+			Retry:     policy.RetryOptions{MaxRetries: -1},
+		},
+	}
+
+	switch p.config.Auth_ {
+	case authSASToken:
+		if p.config.AccountURL == "" {
+			return nil, errors.New("account_url should be set")
+		}
+		return container.NewClientWithNoCredential(p.containerURL()+"?"+strings.TrimPrefix(p.config.SASToken, "?"), options)
+	case authManagedIdentity:
+		if p.config.AccountURL == "" {
+			return nil, errors.New("account_url should be set")
+		}
+		identityOptions := &azidentity.ManagedIdentityCredentialOptions{
+			ClientOptions: azcore.ClientOptions{Transport: httpClient},
+		}
+		if p.config.ManagedIdentityClientID != "" {
+			identityOptions.ID = azidentity.ClientID(p.config.ManagedIdentityClientID)
+		}
+		credential, err := azidentity.NewManagedIdentityCredential(identityOptions)
+		if err != nil {
+			return nil, fmt.Errorf("can't create managed identity credential: %w", err)
+		}
+		return container.NewClient(p.containerURL(), credential, options)
+	default:
+		connectionString := p.config.ConnectionString
+		if strings.EqualFold(strings.Trim(connectionString, "; "), "UseDevelopmentStorage=true") {
+			connectionString = devStorageConnectionString
+		}
+		client, err := container.NewClientFromConnectionString(connectionString, p.config.Container, options)
+		if err != nil {
+			return nil, fmt.Errorf("wrong connection string: %w", err)
+		}
+		return client, nil
+	}
+}
+
+func (p *Plugin) containerURL() string {
+	return strings.TrimSuffix(p.config.AccountURL, "/") + "/" + p.config.Container
+}
+
+// upload uploads the body by the one Put Blob request or by the Put Block requests
+// and the Put Block List one if the body is larger than the block size
+func upload(ctx context.Context, client *blockblob.Client, body []byte, contentType string, blockSize int) error {
+	headers := &blob.HTTPHeaders{BlobContentType: &contentType}
+	if len(body) <= blockSize {
+		_, err := client.Upload(ctx, streaming.NopCloser(bytes.NewReader(body)), &blockblob.UploadOptions{
+			HTTPHeaders: headers,
+		})
+		return err
+	}
+
+	ids := make([]string, 0, (len(body)+blockSize-1)/blockSize)
+	for i := 0; len(body) > 0; i++ {
+		block := body[:min(blockSize, len(body))]
+		body = body[len(block):]
+
+		// the ids must be of the same length within the blob
+		id := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%06d", i)))
+		if _, err := client.StageBlock(ctx, id, streaming.NopCloser(bytes.NewReader(block)), nil); err != nil {
+			return fmt.Errorf("can't put block %d: %w", i, err)
+		}
+		ids = append(ids, id)
+	}
+
+	_, err := client.CommitBlockList(ctx, ids, &blockblob.CommitBlockListOptions{HTTPHeaders: headers})
+	if err != nil {
+		return fmt.Errorf("can't put block list: %w", err)
+	}
+	return nil
+}
+
+// isRetryable returns whether the upload which failed with the error should be retried,
+// the errors without the response such as the network or the credential ones are retried
+func isRetryable(err error) bool {
+	var respErr *azcore.ResponseError
+	if !errors.As(err, &respErr) {
+		return true
+	}
+	return respErr.StatusCode == http.StatusRequestTimeout || respErr.StatusCode == http.StatusTooManyRequests ||
+		respErr.StatusCode >= http.StatusInternalServerError
+}
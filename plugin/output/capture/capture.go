@@ -0,0 +1,175 @@
+// Package capture is an output plugin that keeps events in memory to check them in tests.
+package capture
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/ozontech/file.d/cfg"
+	"github.com/ozontech/file.d/fd"
+	"github.com/ozontech/file.d/pipeline"
+)
+
+/*{ introduction
+It provides an API to test pipelines and other plugins end-to-end.
+Unlike `devnull` it passes events through the batcher the same way as real outputs do
+and keeps every batch it receives as serialized events.
+}*/
+
+const (
+	outPluginType = "capture"
+)
+
+type Plugin struct {
+	config  *Config
+	batcher *pipeline.Batcher
+
+	mu      *sync.Mutex
+	cond    *sync.Cond
+	batches [][]string
+	events  int
+}
+
+// ! config-params
+// ^ config-params
+type Config struct {
+	// > @3@4@5@6
+	// >
+	// > How many workers will be instantiated to send batches.
+	WorkersCount  cfg.Expression `json:"workers_count" default:"1" parse:"expression"` // *
+	WorkersCount_ int
+
+	// > @3@4@5@6
+	// >
+	// > A maximum quantity of events to pack into one batch.
+	BatchSize  cfg.Expression `json:"batch_size" default:"capacity/4" parse:"expression"` // *
+	BatchSize_ int
+
+	// > @3@4@5@6
+	// >
+	// > A minimum size of events in a batch to send.
+	// > If both batch_size and batch_size_bytes are set, they will work together.
+	BatchSizeBytes  cfg.Expression `json:"batch_size_bytes" default:"0" parse:"expression"` // *
+	BatchSizeBytes_ int
+
+	// > @3@4@5@6
+	// >
+	// > After this timeout the batch will be sent even if batch isn't completed.
+	BatchFlushTimeout  cfg.Duration `json:"batch_flush_timeout" default:"200ms" parse:"duration"` // *
+	BatchFlushTimeout_ time.Duration
+}
+
+func init() {
+	fd.DefaultPluginRegistry.RegisterOutput(&pipeline.PluginStaticInfo{
+		Type:    outPluginType,
+		Factory: Factory,
+	})
+}
+
+func Factory() (pipeline.AnyPlugin, pipeline.AnyConfig) {
+	return &Plugin{}, &Config{}
+}
+
+func (p *Plugin) Start(config pipeline.AnyConfig, params *pipeline.OutputPluginParams) {
+	p.config = config.(*Config)
+	p.mu = &sync.Mutex{}
+	p.cond = sync.NewCond(p.mu)
+
+	p.batcher = pipeline.NewBatcher(pipeline.BatcherOptions{
+		PipelineName:   params.PipelineName,
+		OutputType:     outPluginType,
+		OutFn:          p.out,
+		Controller:     params.Controller,
+		Workers:        p.config.WorkersCount_,
+		BatchSizeCount: p.config.BatchSize_,
+		BatchSizeBytes: p.config.BatchSizeBytes_,
+		FlushTimeout:   p.config.BatchFlushTimeout_,
+		MaxEventAge:    params.PipelineSettings.MaxEventAge,
+		CircuitBreaker: params.PipelineSettings.CircuitBreaker,
+		Health:         params.Health,
+		ErrorReporter:  params.ErrorReporter,
+		DeadLetter:     params.DeadLetter,
+		MetricCtl:      params.MetricCtl,
+	})
+
+	p.batcher.Start(context.TODO())
+}
+
+func (p *Plugin) Stop() {
+	p.batcher.Stop()
+}
+
+func (p *Plugin) Out(event *pipeline.Event) {
+	p.batcher.Add(event)
+}
+
+func (p *Plugin) out(_ *pipeline.WorkerData, batch *pipeline.Batch) error {
+	// events are reused after the commit, so they are kept serialized
+	events := make([]string, 0, batch.Len())
+	batch.ForEach(func(event *pipeline.Event) bool {
+		events = append(events, event.Root.EncodeToString())
+		return true
+	})
+
+	p.mu.Lock()
+	p.batches = append(p.batches, events)
+	p.events += len(events)
+	p.cond.Broadcast()
+	p.mu.Unlock()
+
+	return nil
+}
+
+// ! fn-list
+// ^ fn-list
+
+// > It returns serialized events of every received batch in the order batches are sent.
+func (p *Plugin) Batches() [][]string { // *
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	batches := make([][]string, len(p.batches))
+	copy(batches, p.batches)
+	return batches
+}
+
+// > It returns serialized events of all received batches.
+func (p *Plugin) Events() []string { // *
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	events := make([]string, 0, p.events)
+	for _, batch := range p.batches {
+		events = append(events, batch...)
+	}
+	return events
+}
+
+// > It waits until at least n events are received and returns false if the timeout is exceeded.
+func (p *Plugin) WaitForEvents(n int, timeout time.Duration) bool { // *
+	expired := false
+	timer := time.AfterFunc(timeout, func() {
+		p.mu.Lock()
+		expired = true
+		p.cond.Broadcast()
+		p.mu.Unlock()
+	})
+	defer timer.Stop()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for p.events < n && !expired {
+		p.cond.Wait()
+	}
+	return p.events >= n
+}
+
+// > It forgets all received batches.
+func (p *Plugin) Reset() { // *
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.batches = nil
+	p.events = 0
+}
@@ -0,0 +1,52 @@
+package capture
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ozontech/file.d/pipeline"
+	"github.com/ozontech/file.d/test"
+	"github.com/stretchr/testify/require"
+	insaneJSON "github.com/vitkovskii/insane-json"
+	"go.uber.org/atomic"
+)
+
+type controller struct {
+	commits atomic.Int32
+}
+
+func (c *controller) Commit(_ *pipeline.Event) {
+	c.commits.Inc()
+}
+
+func (c *controller) Error(err string) {
+	panic(err)
+}
+
+func TestCapture(t *testing.T) {
+	config := test.NewConfig(&Config{BatchSize: "2", BatchFlushTimeout: "10ms"}, nil).(*Config)
+	ctl := &controller{}
+	params := test.NewEmptyOutputPluginParams()
+	params.Controller = ctl
+
+	p := &Plugin{}
+	p.Start(config, params)
+
+	events := []string{`{"a":1}`, `{"a":2}`, `{"a":3}`}
+	for _, e := range events {
+		root, err := insaneJSON.DecodeString(e)
+		require.NoError(t, err)
+		p.Out(&pipeline.Event{Root: root})
+	}
+
+	require.True(t, p.WaitForEvents(len(events), time.Second))
+	require.False(t, p.WaitForEvents(len(events)+1, time.Millisecond*50))
+	p.Stop()
+
+	require.Equal(t, events, p.Events())
+	require.Equal(t, [][]string{events[:2], events[2:]}, p.Batches())
+	require.Equal(t, int32(len(events)), ctl.commits.Load())
+
+	p.Reset()
+	require.Empty(t, p.Events())
+}
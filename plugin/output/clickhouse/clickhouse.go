@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"net"
+	"strconv"
 	"strings"
 	"time"
 
@@ -26,6 +27,11 @@ It sends the event batches to Clickhouse database using
 [Native protocol](https://clickhouse.com/docs/en/interfaces/tcp/).
 
 File.d uses low level Go client - [ch-go](https://github.com/ClickHouse/ch-go) to provide these features.
+
+The batches are inserted synchronously by default, so every batch creates the part of the table.
+If `async_insert` is set, ClickHouse buffers the inserted data and writes many batches as one part.
+The batch is committed after ClickHouse writes the buffer to the table if `wait_for_async_insert` is set,
+otherwise right after ClickHouse accepts the batch to the buffer.
 }*/
 
 const (
@@ -45,7 +51,10 @@ type Plugin struct {
 	ctx        context.Context
 	cancelFunc context.CancelFunc
 
-	query string
+	query   string
+	columns []Column
+	// querySettings are the settings of the insert query, e.g. the settings of the async inserts
+	querySettings []ch.Setting
 
 	// TODO: support shards
 	instances []Clickhouse
@@ -153,7 +162,19 @@ type Config struct {
 	// > * Array(String)
 	// >
 	// > If you need more types, please, create an issue.
-	Columns []Column `json:"columns" required:"true"` // *
+	// >
+	// > If `infer_columns` is set, the columns can be omitted or set without the types.
+	Columns []Column `json:"columns"` // *
+
+	// > @3@4@5@6
+	// >
+	// > If set, the schema of the table is got by the `DESCRIBE TABLE` query on start and the event fields are mapped
+	// > to the columns with the same names. If `columns` are empty, all the columns of the table except the ones
+	// > with the `DEFAULT`, `MATERIALIZED`, `ALIAS` or `EPHEMERAL` expressions are inserted, these columns are filled by Clickhouse.
+	// > Otherwise, only the configured columns are inserted, their types are taken from the table
+	// > and File.d fails on start if a column doesn't exist in the table or its type differs from the table one.
+	// > The missing fields are inserted as `NULL` to the `Nullable` columns and as the zero values to the others.
+	InferColumns bool `json:"infer_columns" default:"false"` // *
 
 	// > @3@4@5@6
 	// >
@@ -205,6 +226,34 @@ type Config struct {
 	InsertTimeout  cfg.Duration `json:"insert_timeout" default:"10s" parse:"duration"` // *
 	InsertTimeout_ time.Duration
 
+	// > @3@4@5@6
+	// >
+	// > If set, the batches are inserted with the `async_insert=1` setting, so ClickHouse buffers the data of the inserts
+	// > and writes it to the table as one part, it reduces the number of parts created by the small batches.
+	// > > Check out [asynchronous inserts](https://clickhouse.com/docs/en/optimize/asynchronous-inserts) for details.
+	AsyncInsert bool `json:"async_insert" default:"false"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The `wait_for_async_insert` setting of the async inserts, it defines when the batch is committed:
+	// > * `true` – after ClickHouse writes the buffer to the table, so the failed inserts are retried
+	// > * `false` – right after ClickHouse accepts the batch to the buffer, it's faster, but the events are lost
+	// > if the buffer isn't written to the table, e.g. ClickHouse is restarted before that
+	WaitForAsyncInsert bool `json:"wait_for_async_insert" default:"true"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The max time ClickHouse buffers the data of the async inserts, the `async_insert_busy_timeout_ms` setting.
+	// > If it's zero, the setting of the server is used. If `wait_for_async_insert` is set, it should be less than `insert_timeout`.
+	AsyncInsertBusyTimeout  cfg.Duration `json:"async_insert_busy_timeout" default:"0" parse:"duration"` // *
+	AsyncInsertBusyTimeout_ time.Duration
+
+	// > @3@4@5@6
+	// >
+	// > The max size of the buffered data of the async inserts in bytes, the `async_insert_max_data_size` setting.
+	// > If it's zero, the setting of the server is used.
+	AsyncInsertMaxDataSize int `json:"async_insert_max_data_size" default:"0"` // *
+
 	// > @3@4@5@6
 	// >
 	// > Max connections in the connection pool.
@@ -294,13 +343,17 @@ func (p *Plugin) Start(config pipeline.AnyConfig, params *pipeline.OutputPluginP
 	if p.config.InsertTimeout_ < 1 {
 		p.logger.Fatal("'db_request_timeout' can't be <1")
 	}
+	if p.config.AsyncInsert && p.config.WaitForAsyncInsert && p.config.AsyncInsertBusyTimeout_ >= p.config.InsertTimeout_ {
+		p.logger.Fatal("'async_insert_busy_timeout' should be less than 'insert_timeout' to wait for async inserts")
+	}
+	p.querySettings = p.asyncInsertSettings()
+	if p.config.AsyncInsert && !p.config.WaitForAsyncInsert {
+		p.logger.Warn("async inserts aren't waited, so the events are committed before they are written to the table")
+	}
 
-	schema, err := inferInsaneColInputs(p.config.Columns)
-	if err != nil {
-		p.logger.Fatal("invalid database schema", zap.Error(err))
+	if !p.config.InferColumns && len(p.config.Columns) == 0 {
+		p.logger.Fatal("'columns' can't be empty if 'infer_columns' isn't set")
 	}
-	input := inputFromColumns(schema)
-	p.query = input.Into(p.config.Table)
 
 	switch p.config.InsertStrategy {
 	case "round_robin":
@@ -360,6 +413,17 @@ func (p *Plugin) Start(config pipeline.AnyConfig, params *pipeline.OutputPluginP
 		p.instances = append(p.instances, pool)
 	}
 
+	p.columns = p.config.Columns
+	if p.config.InferColumns {
+		p.columns = p.inferColumns()
+	}
+	schema, err := inferInsaneColInputs(p.columns)
+	if err != nil {
+		p.logger.Fatal("invalid database schema", zap.Error(err))
+	}
+	input := inputFromColumns(schema)
+	p.query = input.Into(p.config.Table)
+
 	p.batcher = pipeline.NewBatcher(pipeline.BatcherOptions{
 		PipelineName:   params.PipelineName,
 		OutputType:     outPluginType,
@@ -369,6 +433,11 @@ func (p *Plugin) Start(config pipeline.AnyConfig, params *pipeline.OutputPluginP
 		BatchSizeCount: p.config.BatchSize_,
 		BatchSizeBytes: p.config.BatchSizeBytes_,
 		FlushTimeout:   p.config.BatchFlushTimeout_,
+		MaxEventAge:    params.PipelineSettings.MaxEventAge,
+		CircuitBreaker: params.PipelineSettings.CircuitBreaker,
+		Health:         params.Health,
+		ErrorReporter:  params.ErrorReporter,
+		DeadLetter:     params.DeadLetter,
 		MetricCtl:      params.MetricCtl,
 	})
 
@@ -398,10 +467,10 @@ func (d data) reset() {
 	}
 }
 
-func (p *Plugin) out(workerData *pipeline.WorkerData, batch *pipeline.Batch) {
+func (p *Plugin) out(workerData *pipeline.WorkerData, batch *pipeline.Batch) error {
 	if *workerData == nil {
 		// we don't check the error, schema already validated in the Start
-		columns, _ := inferInsaneColInputs(p.config.Columns)
+		columns, _ := inferInsaneColInputs(p.columns)
 		input := inputFromColumns(columns)
 		*workerData = data{
 			cols:  columns,
@@ -455,6 +524,32 @@ func (p *Plugin) out(workerData *pipeline.WorkerData, batch *pipeline.Batch) {
 			zap.Int("retries", p.config.Retry),
 			zap.String("table", p.config.Table))
 	}
+
+	return nil
+}
+
+// inferColumns gets the columns by the schema of the table from the first available instance
+func (p *Plugin) inferColumns() []Column {
+	var err error
+	for _, clickhouse := range p.instances {
+		var table []tableColumn
+		ctx, cancel := context.WithTimeout(p.ctx, p.config.InsertTimeout_)
+		table, err = describeTable(ctx, clickhouse, p.config.Table)
+		cancel()
+		if err != nil {
+			p.logger.Error("can't describe the table", zap.Error(err), zap.String("table", p.config.Table))
+			continue
+		}
+
+		columns, err := columnsFromTable(p.config.Columns, table)
+		if err != nil {
+			p.logger.Fatal("columns don't match the table schema", zap.Error(err), zap.String("table", p.config.Table))
+		}
+		return columns
+	}
+
+	p.logger.Fatal("can't infer columns", zap.Error(err), zap.String("table", p.config.Table))
+	return nil
 }
 
 func (p *Plugin) do(clickhouse Clickhouse, queryInput proto.Input) error {
@@ -464,11 +559,41 @@ func (p *Plugin) do(clickhouse Clickhouse, queryInput proto.Input) error {
 	defer cancel()
 
 	return clickhouse.Do(ctx, ch.Query{
-		Body:  p.query,
-		Input: queryInput,
+		Body:     p.query,
+		Input:    queryInput,
+		Settings: p.querySettings,
 	})
 }
 
+// asyncInsertSettings returns the query settings of the async inserts if they are enabled
+func (p *Plugin) asyncInsertSettings() []ch.Setting {
+	if !p.config.AsyncInsert {
+		return nil
+	}
+
+	wait := "0"
+	if p.config.WaitForAsyncInsert {
+		wait = "1"
+	}
+	settings := []ch.Setting{
+		{Key: "async_insert", Value: "1", Important: true},
+		{Key: "wait_for_async_insert", Value: wait, Important: true},
+	}
+	if p.config.AsyncInsertBusyTimeout_ > 0 {
+		settings = append(settings, ch.Setting{
+			Key:   "async_insert_busy_timeout_ms",
+			Value: strconv.FormatInt(p.config.AsyncInsertBusyTimeout_.Milliseconds(), 10),
+		})
+	}
+	if p.config.AsyncInsertMaxDataSize > 0 {
+		settings = append(settings, ch.Setting{
+			Key:   "async_insert_max_data_size",
+			Value: strconv.Itoa(p.config.AsyncInsertMaxDataSize),
+		})
+	}
+	return settings
+}
+
 func (p *Plugin) getInstance(requestID int64, retry int) Clickhouse {
 	var instanceIdx int
 	switch p.config.InsertStrategy_ {
@@ -1,11 +1,15 @@
 package clickhouse
 
 import (
+	"context"
 	"math/rand"
 	"testing"
+	"time"
 
+	"github.com/ClickHouse/ch-go"
 	"github.com/golang/mock/gomock"
 	mockclickhouse "github.com/ozontech/file.d/plugin/output/clickhouse/mock"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -133,3 +137,73 @@ func Test_addrWithDefaultPort(t *testing.T) {
 		assert.Equal(t, tt.want, addrWithDefaultPort(tt.addr, defaultPort))
 	}
 }
+
+func TestPlugin_asyncInsertSettings(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name   string
+		config *Config
+		want   []ch.Setting
+	}{
+		{
+			name:   "sync",
+			config: &Config{WaitForAsyncInsert: true},
+			want:   nil,
+		},
+		{
+			name:   "async wait",
+			config: &Config{AsyncInsert: true, WaitForAsyncInsert: true},
+			want: []ch.Setting{
+				{Key: "async_insert", Value: "1", Important: true},
+				{Key: "wait_for_async_insert", Value: "1", Important: true},
+			},
+		},
+		{
+			name: "async no wait with buffer settings",
+			config: &Config{
+				AsyncInsert:             true,
+				AsyncInsertBusyTimeout_: 2 * time.Second,
+				AsyncInsertMaxDataSize:  1048576,
+			},
+			want: []ch.Setting{
+				{Key: "async_insert", Value: "1", Important: true},
+				{Key: "wait_for_async_insert", Value: "0", Important: true},
+				{Key: "async_insert_busy_timeout_ms", Value: "2000"},
+				{Key: "async_insert_max_data_size", Value: "1048576"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			p := &Plugin{config: tt.config}
+			assert.Equal(t, tt.want, p.asyncInsertSettings())
+		})
+	}
+}
+
+func TestPlugin_doWithSettings(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	instance := mockclickhouse.NewMockClickhouse(ctrl)
+
+	p := &Plugin{
+		ctx:    context.Background(),
+		config: &Config{AsyncInsert: true, WaitForAsyncInsert: true, InsertTimeout_: time.Second},
+		query:  "INSERT INTO logs VALUES",
+	}
+	p.querySettings = p.asyncInsertSettings()
+	p.queriesCountMetric = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "queries"}, nil)
+
+	instance.EXPECT().Do(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, query ch.Query) error {
+		assert.Equal(t, "INSERT INTO logs VALUES", query.Body)
+		assert.Equal(t, p.querySettings, query.Settings)
+		return nil
+	})
+	assert.NoError(t, p.do(instance, nil))
+}
@@ -23,17 +23,17 @@ func inferInsaneColInputs(columns []Column) ([]InsaneColumn, error) {
 	insaneColumns := make([]InsaneColumn, 0, len(columns))
 	for _, col := range columns {
 		if col.Type == "" {
-			return nil, fmt.Errorf("empty column type")
+			return nil, fmt.Errorf("empty type of column %q", col.Name)
 		}
 
 		auto := proto.ColAuto{}
 		if err := auto.Infer(proto.ColumnType(col.Type)); err != nil {
-			return nil, fmt.Errorf("auto infer: %w", err)
+			return nil, fmt.Errorf("auto infer of column %q: %w", col.Name, err)
 		}
 
 		insaneCol, err := insaneInfer(auto)
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("column %q: %w", col.Name, err)
 		}
 
 		insaneColumns = append(insaneColumns, InsaneColumn{
@@ -0,0 +1,104 @@
+package clickhouse
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/ClickHouse/ch-go"
+	"github.com/ClickHouse/ch-go/proto"
+)
+
+// tableColumn is the column of the table described by the DESCRIBE TABLE query
+type tableColumn struct {
+	name string
+	typ  string
+	// defaultKind is empty or DEFAULT, MATERIALIZED, ALIAS, EPHEMERAL
+	defaultKind string
+}
+
+// insertable returns whether the value of the column is taken from the events,
+// the columns with the default expressions are filled by Clickhouse unless they are set in the config
+func (c tableColumn) insertable() bool {
+	return c.defaultKind == "" || c.defaultKind == "DEFAULT"
+}
+
+func describeTable(ctx context.Context, clickhouse Clickhouse, table string) ([]tableColumn, error) {
+	var results proto.Results
+	err := clickhouse.Do(ctx, ch.Query{
+		Body:   "DESCRIBE TABLE " + table,
+		Result: results.Auto(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return parseTableColumns(results)
+}
+
+func parseTableColumns(results proto.Results) ([]tableColumn, error) {
+	columns := map[string]*proto.ColStr{"name": nil, "type": nil, "default_type": nil}
+	for _, result := range results {
+		if _, ok := columns[result.Name]; !ok {
+			continue
+		}
+		col, ok := result.Data.(*proto.ColStr)
+		if !ok {
+			return nil, fmt.Errorf("column %q of the table description isn't string", result.Name)
+		}
+		columns[result.Name] = col
+	}
+	for name, col := range columns {
+		if col == nil {
+			return nil, fmt.Errorf("column %q of the table description is missing", name)
+		}
+	}
+
+	tableColumns := make([]tableColumn, 0, results.Rows())
+	for i := 0; i < results.Rows(); i++ {
+		tableColumns = append(tableColumns, tableColumn{
+			name:        columns["name"].Row(i),
+			typ:         columns["type"].Row(i),
+			defaultKind: columns["default_type"].Row(i),
+		})
+	}
+	return tableColumns, nil
+}
+
+// columnsFromTable returns the columns to insert: the configured columns checked against the table
+// or the columns of the table without the default expressions if there are no configured ones.
+// The type of the configured column is taken from the table if it's empty.
+func columnsFromTable(configured []Column, table []tableColumn) ([]Column, error) {
+	if len(table) == 0 {
+		return nil, errors.New("table has no columns")
+	}
+
+	if len(configured) == 0 {
+		columns := make([]Column, 0, len(table))
+		for _, col := range table {
+			if col.defaultKind == "" {
+				columns = append(columns, Column{Name: col.name, Type: col.typ})
+			}
+		}
+		return columns, nil
+	}
+
+	byName := make(map[string]tableColumn, len(table))
+	for _, col := range table {
+		byName[col.name] = col
+	}
+
+	columns := make([]Column, 0, len(configured))
+	for _, col := range configured {
+		tableCol, ok := byName[col.Name]
+		switch {
+		case !ok:
+			return nil, fmt.Errorf("column %q doesn't exist in the table", col.Name)
+		case !tableCol.insertable():
+			return nil, fmt.Errorf("column %q is %s and can't be inserted", col.Name, tableCol.defaultKind)
+		case col.Type != "" && col.Type != tableCol.typ:
+			return nil, fmt.Errorf("column %q has type %q, but the type in the table is %q", col.Name, col.Type, tableCol.typ)
+		}
+		columns = append(columns, Column{Name: col.Name, Type: tableCol.typ})
+	}
+	return columns, nil
+}
@@ -0,0 +1,104 @@
+package clickhouse
+
+import (
+	"testing"
+
+	"github.com/ClickHouse/ch-go/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTableColumns(t *testing.T) {
+	t.Parallel()
+
+	strCol := func(values ...string) *proto.ColStr {
+		col := &proto.ColStr{}
+		for _, v := range values {
+			col.Append(v)
+		}
+		return col
+	}
+
+	columns, err := parseTableColumns(proto.Results{
+		{Name: "name", Data: strCol("ts", "message", "level")},
+		{Name: "type", Data: strCol("DateTime64(3)", "String", "LowCardinality(String)")},
+		{Name: "default_type", Data: strCol("DEFAULT", "", "")},
+		{Name: "default_expression", Data: strCol("now64()", "", "")},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []tableColumn{
+		{name: "ts", typ: "DateTime64(3)", defaultKind: "DEFAULT"},
+		{name: "message", typ: "String"},
+		{name: "level", typ: "LowCardinality(String)"},
+	}, columns)
+
+	_, err = parseTableColumns(proto.Results{
+		{Name: "name", Data: strCol("ts")},
+		{Name: "type", Data: strCol("DateTime")},
+	})
+	assert.Error(t, err)
+}
+
+func TestColumnsFromTable(t *testing.T) {
+	t.Parallel()
+
+	table := []tableColumn{
+		{name: "ts", typ: "DateTime64(3)", defaultKind: "DEFAULT"},
+		{name: "message", typ: "String"},
+		{name: "level", typ: "Nullable(String)"},
+		{name: "date", typ: "Date", defaultKind: "MATERIALIZED"},
+	}
+
+	tests := []struct {
+		name       string
+		configured []Column
+		want       []Column
+		wantErr    bool
+	}{
+		{
+			name: "all columns",
+			want: []Column{
+				{Name: "message", Type: "String"},
+				{Name: "level", Type: "Nullable(String)"},
+			},
+		},
+		{
+			name:       "configured columns",
+			configured: []Column{{Name: "ts"}, {Name: "message", Type: "String"}},
+			want: []Column{
+				{Name: "ts", Type: "DateTime64(3)"},
+				{Name: "message", Type: "String"},
+			},
+		},
+		{
+			name:       "missing column",
+			configured: []Column{{Name: "service"}},
+			wantErr:    true,
+		},
+		{
+			name:       "type mismatch",
+			configured: []Column{{Name: "level", Type: "String"}},
+			wantErr:    true,
+		},
+		{
+			name:       "materialized column",
+			configured: []Column{{Name: "date"}},
+			wantErr:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			columns, err := columnsFromTable(tt.configured, table)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, columns)
+		})
+	}
+}
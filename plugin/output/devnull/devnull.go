@@ -46,7 +46,8 @@ func (p *Plugin) Stop() {
 }
 
 func (p *Plugin) Out(event *pipeline.Event) {
-	if p.outFn != nil {
+	// the parent of spawned events is only committed
+	if p.outFn != nil && !event.IsChildParent() {
 		p.outFn(event)
 	}
 
@@ -2,10 +2,14 @@ package elasticsearch
 
 import (
 	"context"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"math/rand"
 	"net/http"
+	"strings"
 	"sync"
 	"time"
 
@@ -24,16 +28,63 @@ import (
 /*{ introduction
 It sends events into Elasticsearch. It uses `_bulk` API to send events in batches.
 If a network error occurs, the batch will infinitely try to be delivered to the random endpoint.
+
+In the `data_stream` mode events are written to the data streams by the `create` operation without `_id`
+unless `id` is set, every event should have the `@timestamp` field, see `missing_timestamp` for the events which don't have it.
+
+The `_id`, `routing` and `pipeline` of the documents can be set by the templates of the event fields.
+The `_id` makes the retries of the batch idempotent: the documents are overwritten by the `index` operation,
+and the documents which already exist are skipped by the `create` one.
+
+If some documents of the batch are rejected, only they are handled by the status of the bulk response item:
+the ones rejected with `429` or `503` are sent again as the smaller batch according to `retry`,
+and the others, e.g. `400` mapping errors, are passed to the dead letter output of the pipeline or dropped if it isn't set.
+The batches which Elasticsearch rejects as a whole, e.g. with the `400` status, are handled by `retry` in the same way.
+
+**Example:**
+```yaml
+pipelines:
+  example_pipeline:
+    ...
+    output:
+      type: elasticsearch
+      endpoints: [http://elasticsearch:9200]
+      mode: data_stream
+      data_stream_format: logs-%-default
+      index_values: [service]
+    ...
+```
+The event `{"service":"API","@timestamp":"2024-05-01T10:15:00Z"}` is written to the `logs-api-default` data stream.
 }*/
 
 const (
 	outPluginType     = "elasticsearch"
 	NDJSONContentType = "application/x-ndjson"
 	retryDelay        = time.Second
+
+	timestampField = "@timestamp"
+)
+
+const (
+	modeIndex = iota
+	modeDataStream
+)
+
+const (
+	missingTimestampSet = iota
+	missingTimestampDrop
+)
+
+const (
+	onMissingFieldAuto = iota
+	onMissingFieldError
 )
 
 var (
 	strAuthorization = []byte(fasthttp.HeaderAuthorization)
+
+	// errBatchRejected is returned if Elasticsearch rejects the whole batch or its response can't be handled
+	errBatchRejected = errors.New("batch is rejected")
 )
 
 type Plugin struct {
@@ -46,14 +97,24 @@ type Plugin struct {
 	avgEventSize int
 	time         string
 	headerPrefix string
-	batcher      *pipeline.Batcher
-	controller   pipeline.OutputPluginController
-	mu           *sync.Mutex
+	indexFormat  string
+	opType       string
+
+	id         []cfg.SubstitutionOp
+	routing    []cfg.SubstitutionOp
+	pipeline   []cfg.SubstitutionOp
+	batcher    *pipeline.Batcher
+	controller pipeline.OutputPluginController
+	mu         *sync.Mutex
+	// hasDeadLetter is set if the pipeline passes the rejected documents to the dead letter output
+	hasDeadLetter bool
 
 	// plugin metrics
 
 	sendErrorMetric      *prometheus.CounterVec
 	indexingErrorsMetric *prometheus.CounterVec
+	timestampErrorMetric *prometheus.CounterVec
+	missingFieldMetric   *prometheus.CounterVec
 }
 
 // ! config-params
@@ -140,10 +201,96 @@ type Config struct {
 	// > Operation type to be used in batch requests. It can be `index` or `create`. Default is `index`.
 	// > > Check out [_bulk API doc](https://www.elastic.co/guide/en/elasticsearch/reference/current/docs-bulk.html) for details.
 	BatchOpType string `json:"batch_op_type" default:"index" options:"index|create"` // *
+
+	// > @3@4@5@6
+	// >
+	// > Where to write events:
+	// > * `index` – to the indices of `index_format` by the `batch_op_type` operation
+	// > * `data_stream` – to the data streams of `data_stream_format` by the `create` operation, `batch_op_type` is ignored
+	Mode  string `json:"mode" default:"index" options:"index|data_stream"` // *
+	Mode_ int
+
+	// > @3@4@5@6
+	// >
+	// > It defines the pattern of the data stream name in the `data_stream` mode. Use `%` character as a placeholder
+	// > for `index_values` like in `index_format`. The values are lowercased and the characters which aren't allowed
+	// > in the data stream names are replaced with `_`. E.g. if `data_stream_format="logs-%-default"` and `index_values="service"`
+	// > and event is `{"service"="My Service"}` then the data stream is `logs-my_service-default`.
+	DataStreamFormat string `json:"data_stream_format" default:"logs-file.d-default"` // *
+
+	// > @3@4@5@6
+	// >
+	// > What to do in the `data_stream` mode with the event which `@timestamp` field is missing or isn't
+	// > a date like `2024-05-01T10:15:00Z` or the epoch milliseconds:
+	// > * `set` – set `@timestamp` to the current time
+	// > * `drop` – reject the event, it's passed to the dead letter output if it's set
+	MissingTimestamp  string `json:"missing_timestamp" default:"set" options:"set|drop"` // *
+	MissingTimestamp_ int
+
+	// > @3@4@5@6
+	// >
+	// > The template of the document `_id`, the `${field}` parts are replaced by the values of the event fields,
+	// > e.g. `${request_id}` or `${service}-${trace_id}-${span_id}`. If it's empty, `_id` is generated by Elasticsearch.
+	ID string `json:"id"` // *
+
+	// > @3@4@5@6
+	// >
+	// > If set, `_id` is the hex SHA-256 hash of the rendered `id`, it's useful to build `_id` from many fields or long values.
+	IDHash bool `json:"id_hash" default:"false"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The template of the document `routing`, e.g. `${tenant_id}`.
+	Routing string `json:"routing"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The template of the ingest `pipeline` of the document, e.g. `${service}-pipeline`.
+	Pipeline string `json:"pipeline"` // *
+
+	// > @3@4@5@6
+	// >
+	// > What to do if a field of `id`, `routing` or `pipeline` is missing in the event or the rendered value is empty:
+	// > * `auto` – the parameter isn't set, so `_id` is generated, and the default routing and pipeline are used
+	// > * `error` – reject the event, so it's passed to the dead letter output of the pipeline or dropped
+	OnMissingField  string `json:"on_missing_field" default:"auto" options:"auto|error"` // *
+	OnMissingField_ int
+
+	// > @3@4@5@6
+	// >
+	// > Retries of the documents rejected with the `429` or `503` status, the batch of only these documents is sent again.
+	// > It's also applied to the batches rejected as a whole, e.g. with the `400` status or the unexpected response.
+	// > The documents are passed to the dead letter output of the pipeline or dropped after all the retries.
+	Retry int `json:"retry" default:"10"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The delay before the first retry of the rejected documents, it's doubled after each retry.
+	Retention  cfg.Duration `json:"retention" default:"1s" parse:"duration"` // *
+	Retention_ time.Duration
+
+	// > @3@4@5@6
+	// >
+	// > The max delay between the retries of the rejected documents.
+	MaxRetention  cfg.Duration `json:"max_retention" default:"1m" parse:"duration"` // *
+	MaxRetention_ time.Duration
+
+	// > @3@4@5@6
+	// >
+	// > Exit with the non-zero code if the rejected documents aren't written after all the retries
+	// > and the dead letter output of the pipeline isn't set.
+	FatalOnFailedInsert bool `json:"fatal_on_failed_insert" default:"false"` // *
+
+	// > @3@4@5@6
+	// >
+	// > Deprecated. It's ignored, use the `dead_letter` output of the pipeline instead.
+	DeadLetter map[string]any `json:"dead_letter"` // *
 }
 
 type data struct {
 	outBuf []byte
+	// events are the events of the outBuf in order, they are matched with the items of the bulk response
+	events []*pipeline.Event
 }
 
 func init() {
@@ -164,7 +311,31 @@ func (p *Plugin) Start(config pipeline.AnyConfig, params *pipeline.OutputPluginP
 	p.config = config.(*Config)
 	p.registerMetrics(params.MetricCtl)
 	p.mu = &sync.Mutex{}
-	p.headerPrefix = `{"` + p.config.BatchOpType + `":{"_index":"`
+
+	p.opType = p.config.BatchOpType
+	p.indexFormat = p.config.IndexFormat
+	if p.config.Mode_ == modeDataStream {
+		// the data streams accept only the create operation
+		p.opType = "create"
+		p.indexFormat = p.config.DataStreamFormat
+	}
+	p.headerPrefix = `{"` + p.opType + `":{"_index":"`
+
+	for _, param := range []struct {
+		name     string
+		template string
+		parts    *[]cfg.SubstitutionOp
+	}{
+		{name: "id", template: p.config.ID, parts: &p.id},
+		{name: "routing", template: p.config.Routing, parts: &p.routing},
+		{name: "pipeline", template: p.config.Pipeline, parts: &p.pipeline},
+	} {
+		parts, err := cfg.ParseSubstitution(param.template)
+		if err != nil {
+			p.logger.Fatalf("wrong %s template %q: %s", param.name, param.template, err.Error())
+		}
+		*param.parts = parts
+	}
 
 	if len(p.config.IndexValues) == 0 {
 		p.config.IndexValues = append(p.config.IndexValues, "@time")
@@ -201,6 +372,11 @@ func (p *Plugin) Start(config pipeline.AnyConfig, params *pipeline.OutputPluginP
 
 	p.authHeader = p.getAuthHeader()
 
+	if len(p.config.DeadLetter) != 0 {
+		p.logger.Warnf("dead_letter option is deprecated and ignored, use the dead_letter output of the pipeline")
+	}
+	p.hasDeadLetter = params.DeadLetter != nil
+
 	p.maintenance(nil)
 
 	p.logger.Infof("starting batcher: timeout=%d", p.config.BatchFlushTimeout_)
@@ -215,7 +391,19 @@ func (p *Plugin) Start(config pipeline.AnyConfig, params *pipeline.OutputPluginP
 		BatchSizeBytes:      p.config.BatchSizeBytes_,
 		FlushTimeout:        p.config.BatchFlushTimeout_,
 		MaintenanceInterval: time.Minute,
+		MaxEventAge:         params.PipelineSettings.MaxEventAge,
+		CircuitBreaker:      params.PipelineSettings.CircuitBreaker,
+		Health:              params.Health,
+		ErrorReporter:       params.ErrorReporter,
+		DeadLetter:          params.DeadLetter,
 		MetricCtl:           params.MetricCtl,
+		Retry: pipeline.BatcherRetry{
+			MaxRetries:        p.config.Retry,
+			InitialBackoff:    p.config.Retention_,
+			BackoffMultiplier: 2,
+			MaxBackoff:        p.config.MaxRetention_,
+		},
+		FatalOnFailedInsert: p.config.FatalOnFailedInsert,
 	})
 
 	ctx, cancel := context.WithCancel(context.Background())
@@ -235,10 +423,21 @@ func (p *Plugin) Out(event *pipeline.Event) {
 
 func (p *Plugin) registerMetrics(ctl *metric.Ctl) {
 	p.sendErrorMetric = ctl.RegisterCounter("output_elasticsearch_send_error", "Total elasticsearch send errors")
-	p.indexingErrorsMetric = ctl.RegisterCounter("output_elasticsearch_index_error", "Number of elasticsearch indexing errors")
+	p.indexingErrorsMetric = ctl.RegisterCounter("output_elasticsearch_index_error",
+		"Number of elasticsearch indexing errors by the error type and the action: retry, dead_letter or drop",
+		"type", "action",
+	)
+	p.timestampErrorMetric = ctl.RegisterCounter("output_elasticsearch_timestamp_error",
+		"Number of events without the valid @timestamp in the data stream mode",
+		"action",
+	)
+	p.missingFieldMetric = ctl.RegisterCounter("output_elasticsearch_missing_field",
+		"Number of events without the fields of the id, routing or pipeline templates",
+		"param",
+	)
 }
 
-func (p *Plugin) out(workerData *pipeline.WorkerData, batch *pipeline.Batch) {
+func (p *Plugin) out(workerData *pipeline.WorkerData, batch *pipeline.Batch) error {
 	if *workerData == nil {
 		*workerData = &data{
 			outBuf: make([]byte, 0, p.config.BatchSize_*p.avgEventSize),
@@ -252,21 +451,47 @@ func (p *Plugin) out(workerData *pipeline.WorkerData, batch *pipeline.Batch) {
 	}
 
 	data.outBuf = data.outBuf[:0]
+	data.events = data.events[:0]
 	for _, event := range batch.Events {
-		data.outBuf = p.appendEvent(data.outBuf, event)
+		if p.config.Mode_ == modeDataStream {
+			if err := p.checkTimestamp(event); err != nil {
+				batch.MarkRejected(event, err.Error())
+				continue
+			}
+		}
+		outBuf, err := p.appendEvent(data.outBuf, event)
+		data.outBuf = outBuf
+		if err != nil {
+			batch.MarkRejected(event, err.Error())
+			continue
+		}
+		data.events = append(data.events, event)
+	}
+
+	// all events are dropped
+	if len(data.events) == 0 {
+		return nil
 	}
 
 	for {
-		if err := p.send(data.outBuf); err != nil {
-			p.sendErrorMetric.WithLabelValues().Inc()
-			p.logger.Errorf("can't send to the elastic, will try other endpoint: %s", err.Error())
-		} else {
-			break
+		err := p.send(data.outBuf, data.events, batch)
+		if err == nil {
+			return nil
+		}
+
+		p.sendErrorMetric.WithLabelValues().Inc()
+		// the batch is retried by the batcher, so it's passed to the dead letter output after the retries
+		if errors.Is(err, errBatchRejected) {
+			p.logger.Errorf("batch is rejected by the elastic: %s", err.Error())
+			return err
 		}
+		p.logger.Errorf("can't send to the elastic, will try other endpoint: %s", err.Error())
 	}
 }
 
-func (p *Plugin) send(body []byte) error {
+// send sends the body of the events, the failed ones are marked in the batch.
+// It returns errBatchRejected if the batch shouldn't be sent to the other endpoint.
+func (p *Plugin) send(body []byte, events []*pipeline.Event, batch *pipeline.Batch) error {
 	req := fasthttp.AcquireRequest()
 	defer fasthttp.ReleaseRequest(req)
 	resp := fasthttp.AcquireResponse()
@@ -287,52 +512,130 @@ func (p *Plugin) send(body []byte) error {
 	respContent := resp.Body()
 
 	if statusCode := resp.Header.StatusCode(); statusCode < http.StatusOK || statusCode > http.StatusAccepted {
-		time.Sleep(retryDelay)
-		return fmt.Errorf("response status from %s isn't OK: status=%d, body=%s", endpoint.String(), statusCode, string(respContent))
+		if statusCode == http.StatusTooManyRequests || statusCode >= http.StatusInternalServerError {
+			time.Sleep(retryDelay)
+			return fmt.Errorf("response status from %s isn't OK: status=%d, body=%s", endpoint.String(), statusCode, string(respContent))
+		}
+		return fmt.Errorf("%w: status=%d, body=%s", errBatchRejected, statusCode, string(respContent))
 	}
 
 	root, err := insaneJSON.DecodeBytes(respContent)
 	if err != nil {
-		return fmt.Errorf("wrong response from %s: %s", endpoint.String(), err.Error())
+		return fmt.Errorf("%w: wrong response from %s: %s", errBatchRejected, endpoint.String(), err.Error())
 	}
 	defer insaneJSON.Release(root)
 
 	if root.Dig("errors").AsBool() {
-		errors := 0
-		for _, node := range root.Dig("items").AsArray() {
-			errNode := node.Dig("index", "error")
-			if errNode != nil {
-				errors += 1
-				p.logger.Errorf("indexing error: %s", errNode.EncodeToString())
-			}
+		return p.handleItems(root.Dig("items").AsArray(), events, batch)
+	}
+
+	return nil
+}
+
+// handleItems handles the failed items of the bulk response, the items are in the order of the events:
+// the retriable ones are marked as failed to be sent again and the others are marked as rejected
+func (p *Plugin) handleItems(items []*insaneJSON.Node, events []*pipeline.Event, batch *pipeline.Batch) error {
+	if len(items) != len(events) {
+		return fmt.Errorf("%w: can't match bulk response items with events: items=%d, events=%d", errBatchRejected, len(items), len(events))
+	}
+
+	action := "drop"
+	if p.hasDeadLetter {
+		action = "dead_letter"
+	}
+	// the items are objects with the operation key, e.g. `{"create":{"status":400,"error":{...}}}`
+	for i, node := range items {
+		errNode := node.Dig(p.opType, "error")
+		if errNode == nil {
+			continue
 		}
 
-		if errors != 0 {
-			p.indexingErrorsMetric.WithLabelValues().Add(float64(errors))
+		status := node.Dig(p.opType, "status").AsInt()
+		errType := errNode.Dig("type").AsString()
+		switch {
+		case status == http.StatusConflict && len(p.id) != 0:
+			// the document with the same _id is already written by the previous attempt
+			continue
+		case status == http.StatusTooManyRequests || status == http.StatusServiceUnavailable:
+			p.indexingErrorsMetric.WithLabelValues(errType, "retry").Inc()
+			batch.MarkFailed(events[i])
+			continue
 		}
 
-		p.controller.Error("some events from batch aren't written")
+		p.indexingErrorsMetric.WithLabelValues(errType, action).Inc()
+		batch.MarkRejected(events[i], fmt.Sprintf("indexing error: status=%d, index=%s, error=%s",
+			status, node.Dig(p.opType, "_index").AsString(), errNode.EncodeToString()))
 	}
 
 	return nil
 }
 
-func (p *Plugin) appendEvent(outBuf []byte, event *pipeline.Event) []byte {
+// appendEvent appends the bulk action and the document,
+// it returns the error and doesn't append anything if the event should be dropped
+func (p *Plugin) appendEvent(outBuf []byte, event *pipeline.Event) ([]byte, error) {
+	start := len(outBuf)
+
 	// index command
 	outBuf = p.appendIndexName(outBuf, event)
-	outBuf = append(outBuf, '\n')
+	for _, param := range [...]struct {
+		name  string
+		parts []cfg.SubstitutionOp
+		hash  bool
+	}{
+		{name: "_id", parts: p.id, hash: p.config.IDHash},
+		{name: "routing", parts: p.routing},
+		{name: "pipeline", parts: p.pipeline},
+	} {
+		var err error
+		outBuf, err = p.appendParam(outBuf, event, param.name, param.parts, param.hash)
+		if err != nil {
+			return outBuf[:start], err
+		}
+	}
+	outBuf = append(outBuf, "}}\n"...)
 
 	// document
 	outBuf, _ = event.Encode(outBuf)
 	outBuf = append(outBuf, '\n')
 
-	return outBuf
+	return outBuf, nil
+}
+
+// appendParam appends the parameter of the bulk action rendered by the template if the template is set
+func (p *Plugin) appendParam(outBuf []byte, event *pipeline.Event, name string, parts []cfg.SubstitutionOp, hash bool) ([]byte, error) {
+	if len(parts) == 0 {
+		return outBuf, nil
+	}
+
+	start := len(outBuf)
+	outBuf = append(outBuf, `,"`...)
+	outBuf = append(outBuf, name...)
+	outBuf = append(outBuf, `":"`...)
+	valueStart := len(outBuf)
+
+	outBuf, ok := cfg.AppendSubstitution(outBuf, parts, event.Root, cfg.SubstitutionEscapeAll)
+	if !ok || len(outBuf) == valueStart {
+		p.missingFieldMetric.WithLabelValues(name).Inc()
+		if p.config.OnMissingField_ == onMissingFieldError {
+			return outBuf[:start], fmt.Errorf("%s isn't set since the field is missing", name)
+		}
+		return outBuf[:start], nil
+	}
+
+	if hash {
+		sum := sha256.Sum256(outBuf[valueStart:])
+		var encoded [sha256.Size * 2]byte
+		hex.Encode(encoded[:], sum[:])
+		outBuf = append(outBuf[:valueStart], encoded[:]...)
+	}
+
+	return append(outBuf, '"'), nil
 }
 
 func (p *Plugin) appendIndexName(outBuf []byte, event *pipeline.Event) []byte {
 	outBuf = append(outBuf, p.headerPrefix...)
 	replacements := 0
-	for _, c := range pipeline.StringToByteUnsafe(p.config.IndexFormat) {
+	for _, c := range pipeline.StringToByteUnsafe(p.indexFormat) {
 		if c != '%' {
 			outBuf = append(outBuf, c)
 			continue
@@ -351,13 +654,81 @@ func (p *Plugin) appendIndexName(outBuf []byte, event *pipeline.Event) []byte {
 			if value == "" {
 				value = "not_set"
 			}
-			outBuf = append(outBuf, value...)
+			if p.config.Mode_ == modeDataStream {
+				outBuf = appendDataStreamValue(outBuf, value)
+			} else {
+				outBuf = append(outBuf, value...)
+			}
 		}
 	}
-	outBuf = append(outBuf, "\"}}"...)
+	outBuf = append(outBuf, '"')
 	return outBuf
 }
 
+// appendDataStreamValue appends the lowercased value, the characters which aren't allowed
+// in the data stream names and the ones which can't be in the JSON string as is are replaced with `_`
+func appendDataStreamValue(outBuf []byte, value string) []byte {
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case c >= 'A' && c <= 'Z':
+			c += 'a' - 'A'
+		case c <= ' ' || c >= 0x7f || strings.IndexByte(`\/*?"<>|,#:`, c) >= 0:
+			c = '_'
+		}
+		outBuf = append(outBuf, c)
+	}
+	return outBuf
+}
+
+// checkTimestamp returns the error if the event should be dropped since its @timestamp field isn't valid,
+// the missing timestamp is set to the current time if it's configured
+func (p *Plugin) checkTimestamp(event *pipeline.Event) error {
+	node := event.Root.Dig(timestampField)
+	if isValidTimestamp(node) {
+		return nil
+	}
+
+	if p.config.MissingTimestamp_ == missingTimestampDrop {
+		p.timestampErrorMetric.WithLabelValues("drop").Inc()
+		return fmt.Errorf("event doesn't have valid %s field for the data stream", timestampField)
+	}
+
+	p.timestampErrorMetric.WithLabelValues("set").Inc()
+	if node == nil {
+		node = event.Root.AddFieldNoAlloc(event.Root, timestampField)
+	}
+	node.MutateToString(time.Now().UTC().Format(time.RFC3339Nano))
+	return nil
+}
+
+// timestampLayouts are the layouts of the string timestamps accepted by the default date format of the data streams
+var timestampLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02",
+}
+
+// isValidTimestamp checks that the node is the epoch milliseconds or the date in the ISO 8601 format
+func isValidTimestamp(node *insaneJSON.Node) bool {
+	switch {
+	case node == nil:
+		return false
+	case node.IsNumber():
+		return true
+	case !node.IsString():
+		return false
+	}
+
+	value := node.AsString()
+	for _, layout := range timestampLayouts {
+		if _, err := time.Parse(layout, value); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
 func (p *Plugin) maintenance(_ *pipeline.WorkerData) {
 	p.mu.Lock()
 	p.time = time.Now().Format(p.config.TimeFormat)
@@ -1,12 +1,20 @@
 package elasticsearch
 
 import (
+	"crypto/sha256"
 	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	insaneJSON "github.com/vitkovskii/insane-json"
+	"go.uber.org/atomic"
 
 	"github.com/ozontech/file.d/cfg"
 	"github.com/ozontech/file.d/logger"
@@ -34,7 +42,8 @@ func TestAppendEvent(t *testing.T) {
 	root, _ := insaneJSON.DecodeBytes([]byte(`{"field_a":"AAAA","field_b":"BBBB"}`))
 	defer insaneJSON.Release(root)
 
-	result := p.appendEvent(nil, &pipeline.Event{Root: root})
+	result, err := p.appendEvent(nil, &pipeline.Event{Root: root})
+	require.NoError(t, err)
 
 	expected := fmt.Sprintf("%s\n%s\n", `{"index":{"_index":"test-6666-66-66-index-AAAA-BBBB"}}`, `{"field_a":"AAAA","field_b":"BBBB"}`)
 	assert.Equal(t, expected, string(result), "wrong request content")
@@ -61,7 +70,8 @@ func TestAppendEventWithIndexOpType(t *testing.T) {
 	root, _ := insaneJSON.DecodeBytes([]byte(`{"field_a":"AAAA","field_b":"BBBB"}`))
 	defer insaneJSON.Release(root)
 
-	result := p.appendEvent(nil, &pipeline.Event{Root: root})
+	result, err := p.appendEvent(nil, &pipeline.Event{Root: root})
+	require.NoError(t, err)
 
 	expected := fmt.Sprintf("%s\n%s\n", `{"index":{"_index":"test-6666-66-66-index-AAAA-BBBB"}}`, `{"field_a":"AAAA","field_b":"BBBB"}`)
 	assert.Equal(t, expected, string(result), "wrong request content")
@@ -88,7 +98,8 @@ func TestAppendEventWithCreateOpType(t *testing.T) {
 	root, _ := insaneJSON.DecodeBytes([]byte(`{"field_a":"AAAA","field_b":"BBBB"}`))
 	defer insaneJSON.Release(root)
 
-	result := p.appendEvent(nil, &pipeline.Event{Root: root})
+	result, err := p.appendEvent(nil, &pipeline.Event{Root: root})
+	require.NoError(t, err)
 
 	expected := fmt.Sprintf("%s\n%s\n", `{"create":{"_index":"test-6666-66-66-index-AAAA-BBBB"}}`, `{"field_a":"AAAA","field_b":"BBBB"}`)
 	assert.Equal(t, expected, string(result), "wrong request content")
@@ -126,3 +137,323 @@ func TestConfig(t *testing.T) {
 		assert.Equal(t, results[i], p.endpoints[i].String())
 	}
 }
+
+func TestAppendEventDataStream(t *testing.T) {
+	p := &Plugin{}
+	config := &Config{
+		Endpoints:        []string{"test"},
+		Mode:             "data_stream",
+		DataStreamFormat: "logs-%-%",
+		IndexValues:      []string{"service", "env"},
+		BatchSize:        "1",
+		BatchOpType:      "index",
+	}
+	test.NewConfig(config, map[string]int{"gomaxprocs": 1})
+
+	p.Start(config, test.NewEmptyOutputPluginParams())
+
+	root, _ := insaneJSON.DecodeBytes([]byte(`{"service":"My Service/API","@timestamp":"2024-05-01T10:15:00Z"}`))
+	defer insaneJSON.Release(root)
+
+	result, err := p.appendEvent(nil, &pipeline.Event{Root: root})
+	require.NoError(t, err)
+
+	expected := fmt.Sprintf("%s\n%s\n", `{"create":{"_index":"logs-my_service_api-not_set"}}`, `{"service":"My Service/API","@timestamp":"2024-05-01T10:15:00Z"}`)
+	assert.Equal(t, expected, string(result), "wrong request content")
+}
+
+func TestCheckTimestamp(t *testing.T) {
+	cases := []struct {
+		name             string
+		event            string
+		missingTimestamp string
+		wantOK           bool
+		wantValue        string
+	}{
+		{name: "rfc3339", event: `{"@timestamp":"2024-05-01T10:15:00.123+03:00"}`, wantOK: true, wantValue: "2024-05-01T10:15:00.123+03:00"},
+		{name: "no_zone", event: `{"@timestamp":"2024-05-01T10:15:00"}`, wantOK: true, wantValue: "2024-05-01T10:15:00"},
+		{name: "date", event: `{"@timestamp":"2024-05-01"}`, wantOK: true, wantValue: "2024-05-01"},
+		{name: "epoch_millis", event: `{"@timestamp":1714558500000}`, wantOK: true, wantValue: "1714558500000"},
+		{name: "missing_drop", event: `{"message":"test"}`, missingTimestamp: "drop"},
+		{name: "invalid_drop", event: `{"@timestamp":"yesterday"}`, missingTimestamp: "drop"},
+		{name: "object_drop", event: `{"@timestamp":{}}`, missingTimestamp: "drop"},
+		{name: "missing_set", event: `{"message":"test"}`, missingTimestamp: "set", wantOK: true},
+		{name: "invalid_set", event: `{"@timestamp":"yesterday"}`, missingTimestamp: "set", wantOK: true},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &Plugin{}
+			config := &Config{
+				Endpoints:        []string{"test"},
+				Mode:             "data_stream",
+				MissingTimestamp: tt.missingTimestamp,
+				BatchSize:        "1",
+			}
+			test.NewConfig(config, map[string]int{"gomaxprocs": 1})
+			p.Start(config, test.NewEmptyOutputPluginParams())
+
+			root, err := insaneJSON.DecodeString(tt.event)
+			require.NoError(t, err)
+			defer insaneJSON.Release(root)
+
+			ok := p.checkTimestamp(&pipeline.Event{Root: root}) == nil
+			assert.Equal(t, tt.wantOK, ok)
+			if !ok {
+				return
+			}
+
+			value := root.Dig(timestampField).AsString()
+			if tt.wantValue != "" {
+				assert.Equal(t, tt.wantValue, value)
+				return
+			}
+			ts, err := time.Parse(time.RFC3339Nano, value)
+			require.NoError(t, err)
+			assert.WithinDuration(t, time.Now(), ts, time.Minute)
+		})
+	}
+}
+
+func TestOutDataStream(t *testing.T) {
+	bodies := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies <- string(body)
+		_, _ = w.Write([]byte(`{"took":1,"errors":false,"items":[{"create":{"_index":".ds-logs-api-default-2024.05.01-000001","status":201}}]}`))
+	}))
+	defer server.Close()
+
+	p := &Plugin{}
+	config := &Config{
+		Endpoints:        []string{server.URL},
+		Mode:             "data_stream",
+		DataStreamFormat: "logs-%-default",
+		IndexValues:      []string{"service"},
+		MissingTimestamp: "drop",
+		BatchSize:        "1",
+	}
+	test.NewConfig(config, map[string]int{"gomaxprocs": 1})
+	p.Start(config, test.NewEmptyOutputPluginParams())
+	defer p.Stop()
+
+	events := []string{`{"service":"api","@timestamp":1714558500000}`, `{"service":"api"}`}
+	batch := &pipeline.Batch{}
+	for _, e := range events {
+		root, err := insaneJSON.DecodeString(e)
+		require.NoError(t, err)
+		defer insaneJSON.Release(root)
+		batch.Events = append(batch.Events, &pipeline.Event{Root: root})
+	}
+
+	workerData := pipeline.WorkerData(nil)
+	require.NoError(t, p.out(&workerData, batch))
+
+	// the event without @timestamp is dropped and there is no _id
+	assert.Equal(t, `{"create":{"_index":"logs-api-default"}}`+"\n"+`{"service":"api","@timestamp":1714558500000}`+"\n", <-bodies)
+}
+
+func TestAppendEventParams(t *testing.T) {
+	const event = `{"request_id":"r\"1","service":"api","tenant":{"id":7}}`
+
+	cases := []struct {
+		name    string
+		config  *Config
+		want    string
+		wantErr bool
+	}{
+		{
+			name:   "id",
+			config: &Config{ID: "${request_id}"},
+			want:   `{"index":{"_index":"test","_id":"r\"1"}}`,
+		},
+		{
+			name:   "id_hash",
+			config: &Config{ID: "${service}-${request_id}", IDHash: true},
+			want:   `{"index":{"_index":"test","_id":"` + fmt.Sprintf("%x", sha256.Sum256([]byte(`api-r\"1`))) + `"}}`,
+		},
+		{
+			name:   "routing_pipeline",
+			config: &Config{Routing: "${tenant.id}", Pipeline: "${service}-pipeline"},
+			want:   `{"index":{"_index":"test","routing":"7","pipeline":"api-pipeline"}}`,
+		},
+		{
+			name:   "missing_auto",
+			config: &Config{ID: "${trace_id}", Routing: "${tenant.id}"},
+			want:   `{"index":{"_index":"test","routing":"7"}}`,
+		},
+		{
+			name:    "missing_error",
+			config:  &Config{ID: "${request_id}", Routing: "${tenant.name}", OnMissingField: "error"},
+			wantErr: true,
+		},
+		{
+			name:   "data_stream",
+			config: &Config{ID: "${request_id}", Mode: "data_stream", DataStreamFormat: "test"},
+			want:   `{"create":{"_index":"test","_id":"r\"1"}}`,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &Plugin{}
+			tt.config.Endpoints = []string{"test"}
+			tt.config.IndexFormat = "test"
+			tt.config.BatchSize = "1"
+			test.NewConfig(tt.config, map[string]int{"gomaxprocs": 1})
+			p.Start(tt.config, test.NewEmptyOutputPluginParams())
+
+			root, err := insaneJSON.DecodeString(event)
+			require.NoError(t, err)
+			defer insaneJSON.Release(root)
+
+			result, err := p.appendEvent([]byte("prev\n"), &pipeline.Event{Root: root})
+			if tt.wantErr {
+				assert.Error(t, err)
+				assert.Equal(t, "prev\n", string(result))
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, "prev\n"+tt.want+"\n"+event+"\n", string(result))
+		})
+	}
+}
+
+func TestSendDuplicates(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"took":1,"errors":true,"items":[` +
+			`{"create":{"_index":"test","_id":"1","status":201}},` +
+			`{"create":{"_index":"test","_id":"2","status":409,"error":{"type":"version_conflict_engine_exception","reason":"[2]: version conflict, document already exists"}}}` +
+			`]}`))
+	}))
+	defer server.Close()
+
+	p := &Plugin{}
+	config := &Config{
+		Endpoints:   []string{server.URL},
+		IndexFormat: "test",
+		BatchOpType: "create",
+		ID:          "${id}",
+		BatchSize:   "1",
+	}
+	test.NewConfig(config, map[string]int{"gomaxprocs": 1})
+	p.Start(config, test.NewEmptyOutputPluginParams())
+	defer p.Stop()
+
+	// the conflict isn't the error since the document is written by the previous attempt,
+	// otherwise the nil controller is called
+	events := []*pipeline.Event{{}, {}}
+	assert.NoError(t, p.send([]byte(`{"create":{"_index":"test","_id":"1"}}`+"\n"+`{"id":1}`+"\n"), events, &pipeline.Batch{}))
+}
+
+type controller struct {
+	mu      sync.Mutex
+	commits []string
+}
+
+func (c *controller) Commit(event *pipeline.Event) {
+	c.mu.Lock()
+	c.commits = append(c.commits, event.Root.EncodeToString())
+	c.mu.Unlock()
+}
+
+func (c *controller) Error(err string) {
+	panic(err)
+}
+
+func TestOutFailedItems(t *testing.T) {
+	var mu sync.Mutex
+	bodies := make([]string, 0)
+	attempts := make(map[string]int)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		bodies = append(bodies, string(body))
+
+		items := make([]string, 0)
+		lines := strings.Split(strings.TrimSpace(string(body)), "\n")
+		for i := 1; i < len(lines); i += 2 {
+			attempts[lines[i]]++
+			switch {
+			case strings.Contains(lines[i], "mapping"):
+				items = append(items, `{"index":{"_index":"test","status":400,"error":{"type":"mapper_parsing_exception","reason":"failed to parse"}}}`)
+			case strings.Contains(lines[i], "rejected") && attempts[lines[i]] == 1:
+				items = append(items, `{"index":{"_index":"test","status":429,"error":{"type":"es_rejected_execution_exception","reason":"queue is full"}}}`)
+			default:
+				items = append(items, `{"index":{"_index":"test","status":201}}`)
+			}
+		}
+		_, _ = w.Write([]byte(`{"took":1,"errors":true,"items":[` + strings.Join(items, ",") + `]}`))
+	}))
+	defer server.Close()
+
+	config := &Config{
+		Endpoints:         []string{server.URL},
+		IndexFormat:       "test",
+		BatchSize:         "3",
+		BatchFlushTimeout: "1h",
+		Retention:         "10ms",
+	}
+	test.NewConfig(config, map[string]int{"gomaxprocs": 1, "capacity": 64})
+	ctl := &controller{}
+	params := test.NewEmptyOutputPluginParams()
+	params.Controller = ctl
+	deadLetter := test.NewDeadLetter(params)
+
+	p := &Plugin{}
+	p.Start(config, params)
+
+	events := []string{`{"message":"written"}`, `{"message":"rejected"}`, `{"message":"mapping"}`}
+	for _, e := range events {
+		root, err := insaneJSON.DecodeString(e)
+		require.NoError(t, err)
+		p.Out(&pipeline.Event{Root: root})
+	}
+
+	p.Stop()
+
+	// only the document rejected with 429 is sent again, the mapping error is passed to the dead letter output
+	assert.Equal(t, []string{
+		"{\"index\":{\"_index\":\"test\"}}\n" + events[0] + "\n{\"index\":{\"_index\":\"test\"}}\n" + events[1] +
+			"\n{\"index\":{\"_index\":\"test\"}}\n" + events[2] + "\n",
+		"{\"index\":{\"_index\":\"test\"}}\n" + events[1] + "\n",
+	}, bodies)
+	require.Len(t, deadLetter.Events(), 1)
+	dead, err := insaneJSON.DecodeString(deadLetter.Events()[0])
+	require.NoError(t, err)
+	defer insaneJSON.Release(dead)
+	assert.Equal(t, "mapping", dead.Dig("message").AsString())
+	assert.Equal(t, "elasticsearch", dead.Dig(pipeline.DeadLetterField, "output").AsString())
+	assert.Equal(t, events, ctl.commits)
+}
+
+func TestOutRejectedBatch(t *testing.T) {
+	requests := atomic.Int32{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Inc()
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error":{"type":"illegal_argument_exception"},"status":400}`))
+	}))
+	defer server.Close()
+
+	p := &Plugin{}
+	config := &Config{
+		Endpoints: []string{server.URL},
+		BatchSize: "1",
+	}
+	test.NewConfig(config, map[string]int{"gomaxprocs": 1})
+	p.Start(config, test.NewEmptyOutputPluginParams())
+	defer p.Stop()
+
+	root, err := insaneJSON.DecodeString(`{"message":"test"}`)
+	require.NoError(t, err)
+	defer insaneJSON.Release(root)
+	batch := &pipeline.Batch{Events: []*pipeline.Event{{Root: root}}}
+
+	// the batch isn't sent to the other endpoint, it's retried by the batcher
+	workerData := pipeline.WorkerData(nil)
+	err = p.out(&workerData, batch)
+	require.ErrorIs(t, err, errBatchRejected)
+	assert.Equal(t, int32(1), requests.Load())
+}
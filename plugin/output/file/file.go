@@ -7,18 +7,43 @@ import (
 	"path/filepath"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/ozontech/file.d/cfg"
+	"github.com/ozontech/file.d/encoder"
 	"github.com/ozontech/file.d/fd"
 	"github.com/ozontech/file.d/logger"
+	"github.com/ozontech/file.d/metric"
 	"github.com/ozontech/file.d/pipeline"
+	"github.com/prometheus/client_golang/prometheus"
 	"go.uber.org/zap"
 	"golang.org/x/net/context"
 )
 
 /*{ introduction
 It sends event batches into files.
+
+The file is rotated when `retention_interval` passes since its creation or when it reaches `max_file_size`.
+The rotated file is renamed using `time_layout`. If `compression` is set, every batch is written as the gzip member,
+so the file is the valid gzip file while it's written, and the rotated file gets the `.gz` extension.
+It's synced to the disk before the rename, `fsync: batch` also syncs the file after each batch,
+so the events are committed only when they are durable.
+
+`target_file` can contain the `${field}` templates and the `%Y`, `%m`, `%d`, `%H`, `%M`, `%S` time verbs.
+Every rendered target file is written and rotated separately. The file is also rotated when the rendered time changes,
+e.g. the files of `/var/log/${service}/app-%Y%m%d%H.log` are split by the service directories and rotated every hour:
+```yaml
+pipelines:
+  example_pipeline:
+    ...
+    output:
+      type: file
+      target_file: /var/log/${service}/app-%Y%m%d%H.log
+      max_file_size: 104857600
+      compression: gzip
+```
+The value of the field is used as one path segment: the slashes are replaced by `_`, the missing or empty value is `_`.
 }*/
 
 type Plugable interface {
@@ -28,9 +53,12 @@ type Plugable interface {
 }
 
 type Plugin struct {
-	controller     pipeline.OutputPluginController
-	logger         *zap.SugaredLogger
-	config         *Config
+	controller pipeline.OutputPluginController
+	logger     *zap.SugaredLogger
+	config     *Config
+	encoder    encoder.Encoder
+	// header is the header line of the encoder compressed like the batches
+	header         []byte
 	avgEventSize   int
 	batcher        *pipeline.Batcher
 	file           *os.File
@@ -46,16 +74,39 @@ type Plugin struct {
 	SealUpCallback func(string)
 
 	mu *sync.RWMutex
+
+	// size is the size of the current file, sizeExceeded signals the ticker to rotate the file by max_file_size
+	size         atomic.Int64
+	sizeExceeded chan struct{}
+	written      chan struct{}
+	tickerWg     sync.WaitGroup
+
+	// targetParts is set if the target file is a template, then the events are written by the partitions
+	targetParts  []targetPart
+	partitions   map[string]*partition
+	partitionsMu sync.RWMutex
+
+	rotatedMetric      *prometheus.CounterVec
+	writtenBytesMetric *prometheus.CounterVec
 }
 
 type data struct {
 	outBuf []byte
+	// parts are the events of the batch grouped by the target files
+	parts map[string]*[]byte
 }
 
 const (
 	outPluginType = "file"
 
 	fileNameSeparator = "_"
+
+	compressionGzip = "gzip"
+	fsyncBatch      = "batch"
+
+	rotateInterval = "interval"
+	rotateSize     = "size"
+	rotatePeriod   = "period"
 )
 
 // ! config-params
@@ -68,6 +119,8 @@ type Config struct {
 	// >
 	// > For example, if `target_file` is `/var/log/file-d.log`
 	// > file will be `/var/log/1893445200_file-d.log`
+	// >
+	// > It can contain the `${field}` templates and the `%Y|%m|%d|%H|%M|%S` time verbs, `%%` is `%`.
 	TargetFile string `json:"target_file" default:"/var/log/file-d.log"` // *
 
 	// > @3@4@5@6
@@ -81,6 +134,43 @@ type Config struct {
 	// > Layout is added to targetFile after sealing up. Determines result file name
 	Layout string `json:"time_layout" default:"01-02-2006_15:04:05"` // *
 
+	// > @3@4@5@6
+	// >
+	// > The size of the file in bytes to rotate it. Zero disables the rotation by size.
+	MaxFileSize  cfg.Expression `json:"max_file_size" default:"0" parse:"expression"` // *
+	MaxFileSize_ int
+
+	// > @3@4@5@6
+	// >
+	// > The compression of the batches written to the file, `gzip` adds the `.gz` extension to the rotated files.
+	Compression string `json:"compression" default:"none" options:"none|gzip"` // *
+
+	// > @3@4@5@6
+	// >
+	// > When to sync the file to the disk:
+	// > * `rotation` – before the file is rotated
+	// > * `batch` – also after each batch, the events are committed after the sync
+	Fsync string `json:"fsync" default:"rotation" options:"rotation|batch"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The format of the events in the file:
+	// > * `json` – one compact JSON per line
+	// > * `pretty_json` – indented JSON, the event takes several lines
+	// > * `logfmt` – `key=value` pairs, the nested fields are flattened by the dotted keys
+	// > * `csv` – the values of `csv_columns`, the missing fields are empty
+	Encoder string `json:"encoder" default:"json" options:"json|pretty_json|logfmt|csv"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The fields written as the CSV columns in the order of the list. It's required for the `csv` encoder.
+	CSVColumns []string `json:"csv_columns" slice:"true"` // *
+
+	// > @3@4@5@6
+	// >
+	// > If set, the names of `csv_columns` are written as the first line of every file.
+	CSVHeader bool `json:"csv_header" default:"true"` // *
+
 	// > @3@4@5@6
 	// >
 	// > How much workers will be instantiated to send batches.
@@ -128,12 +218,30 @@ func (p *Plugin) Start(config pipeline.AnyConfig, params *pipeline.OutputPluginP
 	p.controller = params.Controller
 	p.logger = params.Logger
 	p.config = config.(*Config)
+	p.registerMetrics(params.MetricCtl)
 
-	dir, file := filepath.Split(p.config.TargetFile)
-	p.targetDir = dir
-	p.fileExtension = filepath.Ext(file)
-	p.fileName = file[0 : len(file)-len(p.fileExtension)]
-	p.tsFileName = "%s" + "-" + p.fileName
+	enc, err := encoder.New(encoder.Config{
+		Type:       p.config.Encoder,
+		CSVColumns: p.config.CSVColumns,
+		CSVHeader:  p.config.CSVHeader,
+	})
+	if err != nil {
+		p.logger.Fatalf("wrong encoder config: %s", err.Error())
+	}
+	p.encoder = enc
+
+	if header := enc.Header(); len(header) > 0 {
+		header = append(append(make([]byte, 0, len(header)+1), header...), '\n')
+		p.header, err = pipeline.Compress(pipeline.BatchCompression(p.config.Compression), header)
+		if err != nil {
+			p.logger.Fatalf("can't compress header: %s", err.Error())
+		}
+	}
+
+	targetParts, isTemplate, err := parseTargetTemplate(p.config.TargetFile)
+	if err != nil {
+		p.logger.Fatalf("wrong target file %q: %s", p.config.TargetFile, err.Error())
+	}
 
 	p.batcher = pipeline.NewBatcher(pipeline.BatcherOptions{
 		PipelineName:   params.PipelineName,
@@ -144,13 +252,42 @@ func (p *Plugin) Start(config pipeline.AnyConfig, params *pipeline.OutputPluginP
 		BatchSizeCount: p.config.BatchSize_,
 		BatchSizeBytes: p.config.BatchSizeBytes_,
 		FlushTimeout:   p.config.BatchFlushTimeout_,
+		MaxEventAge:    params.PipelineSettings.MaxEventAge,
+		CircuitBreaker: params.PipelineSettings.CircuitBreaker,
+		Health:         params.Health,
+		ErrorReporter:  params.ErrorReporter,
+		DeadLetter:     params.DeadLetter,
 		MetricCtl:      params.MetricCtl,
+		Compression:    pipeline.BatchCompression(p.config.Compression),
 	})
 
-	p.mu = &sync.RWMutex{}
 	ctx, cancel := context.WithCancel(context.Background())
 	p.cancel = cancel
 
+	if isTemplate {
+		p.targetParts = targetParts
+		p.partitions = make(map[string]*partition)
+		p.recoverPartitions()
+		go p.partitionsTicker(ctx)
+	} else {
+		p.startFile(ctx)
+	}
+
+	p.batcher.Start(ctx)
+}
+
+// startFile opens the target file and starts its rotation
+func (p *Plugin) startFile(ctx context.Context) {
+	dir, file := filepath.Split(p.config.TargetFile)
+	p.targetDir = dir
+	p.fileExtension = filepath.Ext(file)
+	p.fileName = file[0 : len(file)-len(p.fileExtension)]
+	p.tsFileName = "%s" + "-" + p.fileName
+
+	p.mu = &sync.RWMutex{}
+	p.sizeExceeded = make(chan struct{}, 1)
+	p.written = make(chan struct{}, 1)
+
 	if err := os.MkdirAll(p.targetDir, os.ModePerm); err != nil {
 		p.logger.Fatalf("could not create target dir: %s, error: %s", p.targetDir, err.Error())
 	}
@@ -166,29 +303,39 @@ func (p *Plugin) Start(config pipeline.AnyConfig, params *pipeline.OutputPluginP
 		p.logger.Panic("next seal up time is nil!")
 	}
 
+	p.tickerWg.Add(1)
 	go p.fileSealUpTicker(ctx)
-
-	p.batcher.Start(ctx)
 }
 
 func (p *Plugin) Stop() {
 	// we MUST NOT close file, through p.file.Close(), fileSealUpTicker already do this duty.
 	p.batcher.Stop()
 	p.cancel()
+	p.stopPartitions()
+}
+
+func (p *Plugin) registerMetrics(ctl *metric.Ctl) {
+	p.rotatedMetric = ctl.RegisterCounter("output_file_rotated_total", "Number of rotated files", "reason")
+	p.writtenBytesMetric = ctl.RegisterCounter("output_file_written_bytes_total", "Size of events written to files in bytes")
 }
 
 func (p *Plugin) Out(event *pipeline.Event) {
 	p.batcher.Add(event)
 }
 
-func (p *Plugin) out(workerData *pipeline.WorkerData, batch *pipeline.Batch) {
+func (p *Plugin) out(workerData *pipeline.WorkerData, batch *pipeline.Batch) error {
 	if *workerData == nil {
 		*workerData = &data{
 			outBuf: make([]byte, 0, p.config.BatchSize_*p.avgEventSize),
+			parts:  make(map[string]*[]byte),
 		}
 	}
 	data := (*workerData).(*data)
 
+	if p.targetParts != nil {
+		return p.outPartitions(data, batch)
+	}
+
 	// handle to much memory consumption
 	if cap(data.outBuf) > p.config.BatchSize_*p.avgEventSize {
 		data.outBuf = make([]byte, 0, p.config.BatchSize_*p.avgEventSize)
@@ -197,20 +344,45 @@ func (p *Plugin) out(workerData *pipeline.WorkerData, batch *pipeline.Batch) {
 	outBuf := data.outBuf[:0]
 
 	for _, event := range batch.Events {
-		outBuf, _ = event.Encode(outBuf)
+		outBuf = p.encoder.Encode(outBuf, event.Root)
 		outBuf = append(outBuf, byte('\n'))
 	}
 	data.outBuf = outBuf
 
-	p.write(outBuf)
+	out, err := batch.Compress(outBuf)
+	if err != nil {
+		return fmt.Errorf("can't compress batch: %w", err)
+	}
+
+	p.write(out)
+	if p.config.Fsync == fsyncBatch {
+		p.sync()
+	}
+
+	return nil
 }
 
 func (p *Plugin) fileSealUpTicker(ctx context.Context) {
+	defer p.tickerWg.Done()
 	for {
 		timer := time.NewTimer(time.Until(p.nextSealUpTime))
 		select {
 		case <-timer.C:
-			p.sealUp()
+			if p.sealUp() {
+				p.rotatedMetric.WithLabelValues(rotateInterval).Inc()
+				continue
+			}
+			// the empty file is sealed up right after the next write
+			select {
+			case <-p.written:
+			case <-ctx.Done():
+				return
+			}
+		case <-p.sizeExceeded:
+			timer.Stop()
+			if p.sealUp() {
+				p.rotatedMetric.WithLabelValues(rotateSize).Inc()
+			}
 		case <-ctx.Done():
 			timer.Stop()
 			return
@@ -231,9 +403,31 @@ func (p *Plugin) setNextSealUpTime() {
 func (p *Plugin) write(data []byte) {
 	p.mu.RLock()
 	defer p.mu.RUnlock()
-	if _, err := p.file.Write(data); err != nil {
+	n, err := p.file.Write(data)
+	if err != nil {
 		p.logger.Fatalf("could not write into the file: %s, error: %s", p.file.Name(), err.Error())
 	}
+	p.writtenBytesMetric.WithLabelValues().Add(float64(n))
+
+	select {
+	case p.written <- struct{}{}:
+	default:
+	}
+
+	if p.config.MaxFileSize_ > 0 && p.size.Add(int64(n)) >= int64(p.config.MaxFileSize_) {
+		select {
+		case p.sizeExceeded <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (p *Plugin) sync() {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if err := p.file.Sync(); err != nil {
+		p.logger.Fatalf("could not sync the file: %s, error: %s", p.file.Name(), err.Error())
+	}
 }
 
 func (p *Plugin) createNew() {
@@ -253,34 +447,72 @@ func (p *Plugin) createNew() {
 	if err != nil {
 		p.logger.Panicf("could not open or create file: %s, error: %s", f, err.Error())
 	}
+	info, err := file.Stat()
+	if err != nil {
+		p.logger.Panicf("could not get info about file: %s, error: %s", f, err.Error())
+	}
+	size := info.Size()
+	if size == 0 && len(p.header) > 0 {
+		if _, err := file.Write(p.header); err != nil {
+			p.logger.Panicf("could not write header into file: %s, error: %s", f, err.Error())
+		}
+		size = int64(len(p.header))
+	}
 	p.file = file
+	p.size.Store(size)
+}
+
+// headerSize returns the size of the compressed header line which starts every file, such file without events is empty
+func (p *Plugin) headerSize() int64 {
+	return int64(len(p.header))
 }
 
 // sealUp manages current file: renames, closes, and creates new.
-func (p *Plugin) sealUp() {
+// It returns false if the file is empty and isn't sealed.
+func (p *Plugin) sealUp() bool {
 	info, err := p.file.Stat()
 	if err != nil {
 		p.logger.Panicf("could not get info about file: %s, error: %s", p.file.Name(), err.Error())
 	}
-	if info.Size() == 0 {
-		return
+	if info.Size() <= p.headerSize() {
+		return false
 	}
 
-	// newFileName will be like: ".var/log/log_1_01-02-2009_15:04.log
-	newFileName := filepath.Join(p.targetDir, fmt.Sprintf("%s%s%d%s%s%s", p.fileName, fileNameSeparator, p.idx, fileNameSeparator, time.Now().Format(p.config.Layout), p.fileExtension))
+	newFileName := p.sealedFileName()
 	p.rename(newFileName)
 	oldFile := p.file
 	p.mu.Lock()
 	p.createNew()
 	p.nextSealUpTime = time.Now().Add(p.config.RetentionInterval_)
 	p.mu.Unlock()
-	if err := oldFile.Close(); err != nil {
-		p.logger.Panicf("could not close file: %s, error: %s", oldFile.Name(), err.Error())
+	p.finishSealUp(oldFile, newFileName)
+	return true
+}
+
+// sealedFileName returns the name like: ".var/log/log_1_01-02-2009_15:04.log, the compressed file has the extension of the compression
+func (p *Plugin) sealedFileName() string {
+	return filepath.Join(p.targetDir, fmt.Sprintf("%s%s%d%s%s%s%s", p.fileName, fileNameSeparator, p.idx, fileNameSeparator, time.Now().Format(p.config.Layout), p.fileExtension, p.compressedExtension()))
+}
+
+// finishSealUp syncs and closes the renamed file, then passes it to the callback
+func (p *Plugin) finishSealUp(file *os.File, fileName string) {
+	if err := file.Sync(); err != nil {
+		p.logger.Panicf("could not sync file: %s, error: %s", file.Name(), err.Error())
+	}
+	if err := file.Close(); err != nil {
+		p.logger.Panicf("could not close file: %s, error: %s", file.Name(), err.Error())
 	}
-	logger.Infof("sealing file, newFileName=%s", newFileName)
+	logger.Infof("sealing file, newFileName=%s", fileName)
 	if p.SealUpCallback != nil {
-		go p.SealUpCallback(newFileName)
+		go p.SealUpCallback(fileName)
+	}
+}
+
+func (p *Plugin) compressedExtension() string {
+	if p.config.Compression == compressionGzip {
+		return ".gz"
 	}
+	return ""
 }
 
 func (p *Plugin) rename(newFileName string) {
@@ -292,7 +524,7 @@ func (p *Plugin) rename(newFileName string) {
 }
 
 func (p *Plugin) getStartIdx() int {
-	pattern := fmt.Sprintf("%s/%s%s*%s*%s", p.targetDir, p.fileName, fileNameSeparator, fileNameSeparator, p.fileExtension)
+	pattern := fmt.Sprintf("%s/%s%s*%s*%s%s", p.targetDir, p.fileName, fileNameSeparator, fileNameSeparator, p.fileExtension, p.compressedExtension())
 	matches, err := filepath.Glob(pattern)
 	if err != nil {
 		p.logger.Panic(err.Error())
@@ -300,6 +532,7 @@ func (p *Plugin) getStartIdx() int {
 	idx := -1
 	for _, v := range matches {
 		file := filepath.Base(v)
+		file = file[:len(file)-len(p.compressedExtension())]
 		i := file[len(p.fileName)+len(fileNameSeparator) : len(file)-len(p.fileExtension)-len(p.config.Layout)-len(fileNameSeparator)]
 		maxIdx, err := strconv.Atoi(i)
 		if err != nil {
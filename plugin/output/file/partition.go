@@ -0,0 +1,204 @@
+package file
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ozontech/file.d/pipeline"
+	"golang.org/x/net/context"
+)
+
+const partitionsCheckInterval = time.Second
+
+// partition writes the events of one rendered target file,
+// it's closed when the time verbs of the target file render the other period
+type partition struct {
+	plugin *Plugin
+	period string
+}
+
+// outPartitions groups the events by the target files and writes each group to its partition
+func (p *Plugin) outPartitions(data *data, batch *pipeline.Batch) error {
+	for _, buf := range data.parts {
+		*buf = (*buf)[:0]
+	}
+
+	// the partitions can't be closed while the batch is written
+	p.partitionsMu.RLock()
+	defer p.partitionsMu.RUnlock()
+
+	now := time.Now()
+	for _, event := range batch.Events {
+		data.outBuf = appendTarget(data.outBuf[:0], p.targetParts, event.Root, now)
+		buf, ok := data.parts[string(data.outBuf)]
+		if !ok {
+			buf = new([]byte)
+			data.parts[string(data.outBuf)] = buf
+		}
+		*buf = p.encoder.Encode(*buf, event.Root)
+		*buf = append(*buf, '\n')
+	}
+
+	period := string(appendPeriod(data.outBuf[:0], p.targetParts, now))
+	for target, buf := range data.parts {
+		if len(*buf) == 0 {
+			// the target isn't used anymore by the worker
+			delete(data.parts, target)
+			continue
+		}
+
+		out, err := batch.Compress(*buf)
+		if err != nil {
+			return fmt.Errorf("can't compress batch: %w", err)
+		}
+
+		part := p.getPartition(target, period)
+		part.plugin.write(out)
+		if p.config.Fsync == fsyncBatch {
+			part.plugin.sync()
+		}
+	}
+
+	return nil
+}
+
+// getPartition returns the partition of the target file, the read lock of the partitions must be held
+func (p *Plugin) getPartition(target, period string) *partition {
+	if part, ok := p.partitions[target]; ok {
+		return part
+	}
+
+	// the read lock is upgraded to create the partition, the other worker could create it meanwhile
+	p.partitionsMu.RUnlock()
+	p.partitionsMu.Lock()
+	part, ok := p.partitions[target]
+	if !ok {
+		part = p.newPartition(target, period)
+		p.partitions[target] = part
+	}
+	p.partitionsMu.Unlock()
+	p.partitionsMu.RLock()
+	return part
+}
+
+func (p *Plugin) newPartition(target, period string) *partition {
+	config := *p.config
+	config.TargetFile = target
+
+	plugin := &Plugin{
+		logger:             p.logger,
+		config:             &config,
+		encoder:            p.encoder,
+		header:             p.header,
+		SealUpCallback:     p.SealUpCallback,
+		rotatedMetric:      p.rotatedMetric,
+		writtenBytesMetric: p.writtenBytesMetric,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	plugin.cancel = cancel
+	plugin.startFile(ctx)
+
+	return &partition{plugin: plugin, period: period}
+}
+
+// recoverPartitions opens the files which were written before the restart,
+// the period of the file is rendered by its creation time, so the files of the other periods are sealed up at the first check
+func (p *Plugin) recoverPartitions() {
+	dir, file := filepath.Split(globPattern(p.targetParts))
+	pattern := dir + "*" + fileNameSeparator + file
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		p.logger.Fatalf("can't glob: pattern=%s, err=%s", pattern, err.Error())
+	}
+
+	for _, match := range matches {
+		name := filepath.Base(match)
+		ts, target, ok := strings.Cut(name, fileNameSeparator)
+		if !ok {
+			continue
+		}
+		createdAt, err := strconv.ParseInt(ts, 10, 64)
+		if err != nil {
+			continue
+		}
+		target = filepath.Join(filepath.Dir(match), target)
+		if _, ok := p.partitions[target]; ok {
+			continue
+		}
+		period := appendPeriod(nil, p.targetParts, time.Unix(createdAt, 0))
+		p.partitions[target] = p.newPartition(target, string(period))
+	}
+}
+
+func (p *Plugin) partitionsTicker(ctx context.Context) {
+	ticker := time.NewTicker(partitionsCheckInterval)
+	for {
+		select {
+		case <-ticker.C:
+			p.closeStalePartitions(time.Now())
+		case <-ctx.Done():
+			ticker.Stop()
+			return
+		}
+	}
+}
+
+// closeStalePartitions seals up the files of the previous periods
+func (p *Plugin) closeStalePartitions(now time.Time) {
+	period := string(appendPeriod(nil, p.targetParts, now))
+
+	stale := make([]*partition, 0)
+	p.partitionsMu.Lock()
+	for target, part := range p.partitions {
+		if part.period != period {
+			delete(p.partitions, target)
+			stale = append(stale, part)
+		}
+	}
+	p.partitionsMu.Unlock()
+
+	for _, part := range stale {
+		if part.plugin.close() {
+			p.rotatedMetric.WithLabelValues(rotatePeriod).Inc()
+		}
+	}
+}
+
+// stopPartitions stops the rotation of the partitions, the files are kept as is like the file of the plugin
+func (p *Plugin) stopPartitions() {
+	p.partitionsMu.Lock()
+	defer p.partitionsMu.Unlock()
+	for _, part := range p.partitions {
+		part.plugin.cancel()
+	}
+}
+
+// close stops the rotation and seals up the file, the empty file is removed.
+// It returns false if the file isn't sealed.
+func (p *Plugin) close() bool {
+	p.cancel()
+	p.tickerWg.Wait()
+
+	info, err := p.file.Stat()
+	if err != nil {
+		p.logger.Panicf("could not get info about file: %s, error: %s", p.file.Name(), err.Error())
+	}
+	if info.Size() <= p.headerSize() {
+		if err := p.file.Close(); err != nil {
+			p.logger.Panicf("could not close file: %s, error: %s", p.file.Name(), err.Error())
+		}
+		if err := os.Remove(p.file.Name()); err != nil {
+			p.logger.Errorf("could not remove empty file: %s, error: %s", p.file.Name(), err.Error())
+		}
+		return false
+	}
+
+	newFileName := p.sealedFileName()
+	p.rename(newFileName)
+	p.finishSealUp(p.file, newFileName)
+	return true
+}
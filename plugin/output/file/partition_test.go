@@ -0,0 +1,195 @@
+package file
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/ozontech/file.d/pipeline"
+	"github.com/ozontech/file.d/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	insaneJSON "github.com/vitkovskii/insane-json"
+)
+
+func TestParseTargetTemplate(t *testing.T) {
+	_, isTemplate, err := parseTargetTemplate("/var/log/file-d.log")
+	require.NoError(t, err)
+	assert.False(t, isTemplate)
+
+	parts, isTemplate, err := parseTargetTemplate("/var/log/${service}/app-%Y%m%d%H-100%%.log")
+	require.NoError(t, err)
+	assert.True(t, isTemplate)
+	assert.Equal(t, "/var/log/*/app-****-100%.log", globPattern(parts))
+
+	root, err := insaneJSON.DecodeString(`{"service":"api/v1"}`)
+	require.NoError(t, err)
+	defer insaneJSON.Release(root)
+
+	now := time.Date(2024, 5, 1, 10, 30, 0, 0, time.Local)
+	assert.Equal(t, "/var/log/api_v1/app-2024050110-100%.log", string(appendTarget(nil, parts, root, now)))
+	assert.Equal(t, "2024050110", string(appendPeriod(nil, parts, now)))
+
+	for _, s := range []string{"app-%Q.log", "app-%", "${service.log", "${}.log"} {
+		_, _, err := parseTargetTemplate(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func newTestEvents(t *testing.T, events ...string) *pipeline.Batch {
+	t.Helper()
+	batch := &pipeline.Batch{}
+	for _, e := range events {
+		root, err := insaneJSON.DecodeString(e)
+		require.NoError(t, err)
+		t.Cleanup(func() { insaneJSON.Release(root) })
+		batch.Events = append(batch.Events, &pipeline.Event{Root: root})
+	}
+	return batch
+}
+
+func TestOutPartitions(t *testing.T) {
+	dir := t.TempDir()
+	config := &Config{
+		TargetFile:  filepath.Join(dir, "${service}", "app-%Y.log"),
+		Compression: "gzip",
+		Fsync:       "batch",
+	}
+	test.NewConfig(config, map[string]int{"gomaxprocs": 1, "capacity": 64})
+
+	p := &Plugin{}
+	p.Start(config, test.NewEmptyOutputPluginParams())
+	defer p.Stop()
+
+	batch := newTestEvents(t, `{"service":"api","n":1}`, `{"service":"db","n":2}`, `{"service":"api","n":3}`)
+	batch.SetCompression(pipeline.BatchCompressionGzip)
+	workerData := pipeline.WorkerData(nil)
+	require.NoError(t, p.out(&workerData, batch))
+
+	year := time.Now().Format("2006")
+	want := map[string]string{
+		"api": `{"service":"api","n":1}` + "\n" + `{"service":"api","n":3}` + "\n",
+		"db":  `{"service":"db","n":2}` + "\n",
+	}
+	for service, content := range want {
+		matches, err := filepath.Glob(filepath.Join(dir, service, "*_app-"+year+".log"))
+		require.NoError(t, err)
+		require.Len(t, matches, 1, service)
+		// the file is compressed while it's written
+		assert.Equal(t, content, gunzip(t, matches[0]), service)
+	}
+
+	// the next year closes the files of the current one
+	p.closeStalePartitions(time.Now().AddDate(1, 0, 0))
+	assert.Empty(t, p.partitions)
+
+	for service, content := range want {
+		matches, err := filepath.Glob(filepath.Join(dir, service, "*"))
+		require.NoError(t, err)
+		require.Len(t, matches, 1, service)
+		assert.Regexp(t, `app-\d{4}_0_.+\.log\.gz$`, matches[0])
+		assert.Equal(t, content, gunzip(t, matches[0]), service)
+	}
+}
+
+func gunzip(t *testing.T, name string) string {
+	f, err := os.Open(name)
+	require.NoError(t, err)
+	defer f.Close()
+
+	zr, err := gzip.NewReader(f)
+	require.NoError(t, err)
+	data, err := io.ReadAll(zr)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func TestRecoverPartitions(t *testing.T) {
+	dir := t.TempDir()
+	createDir(t, filepath.Join(dir, "api"))
+
+	old := time.Now().AddDate(-1, 0, 0)
+	data := []byte("old\n")
+	f := createFile(t, filepath.Join(dir, "api", strconv.FormatInt(old.Unix(), 10)+"_app-"+old.Format("2006")+".log"), &data)
+	_ = f.Close()
+
+	config := &Config{TargetFile: filepath.Join(dir, "${service}", "app-%Y.log")}
+	test.NewConfig(config, map[string]int{"gomaxprocs": 1, "capacity": 64})
+
+	p := &Plugin{}
+	p.Start(config, test.NewEmptyOutputPluginParams())
+	defer p.Stop()
+	require.Len(t, p.partitions, 1)
+
+	p.closeStalePartitions(time.Now())
+	assert.Empty(t, p.partitions)
+
+	matches, err := filepath.Glob(filepath.Join(dir, "api", "app-"+old.Format("2006")+"_0_*.log"))
+	require.NoError(t, err)
+	assert.Len(t, matches, 1)
+}
+
+func TestRotateBySize(t *testing.T) {
+	dir := t.TempDir()
+	config := &Config{
+		TargetFile:        filepath.Join(dir, "app.log"),
+		RetentionInterval: "1h",
+		MaxFileSize:       "10",
+	}
+	test.NewConfig(config, map[string]int{"gomaxprocs": 1, "capacity": 64})
+
+	p := &Plugin{}
+	p.Start(config, test.NewEmptyOutputPluginParams())
+	defer p.Stop()
+
+	workerData := pipeline.WorkerData(nil)
+	require.NoError(t, p.out(&workerData, newTestEvents(t, `{"message":"rotated"}`)))
+
+	assert.Eventually(t, func() bool {
+		matches, err := filepath.Glob(filepath.Join(dir, "app_0_*.log"))
+		return err == nil && len(matches) == 1 && p.size.Load() == 0
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestOutCSV(t *testing.T) {
+	for _, compression := range []pipeline.BatchCompression{pipeline.BatchCompressionNone, pipeline.BatchCompressionGzip} {
+		t.Run(string(compression), func(t *testing.T) {
+			dir := t.TempDir()
+			config := &Config{
+				TargetFile:        filepath.Join(dir, "app.csv"),
+				RetentionInterval: "1h",
+				Encoder:           "csv",
+				CSVColumns:        []string{"level", "message"},
+				CSVHeader:         true,
+				Compression:       string(compression),
+			}
+			test.NewConfig(config, map[string]int{"gomaxprocs": 1, "capacity": 64})
+
+			p := &Plugin{}
+			p.Start(config, test.NewEmptyOutputPluginParams())
+			defer p.Stop()
+
+			// the file with the header only isn't sealed up
+			assert.False(t, p.sealUp())
+
+			batch := newTestEvents(t, `{"level":"info","message":"a, b"}`, `{"message":"no level"}`)
+			batch.SetCompression(compression)
+			workerData := pipeline.WorkerData(nil)
+			require.NoError(t, p.out(&workerData, batch))
+
+			// the compressed header is the first gzip member of the file
+			want := "level,message\ninfo,\"a, b\"\n,no level\n"
+			if compression == pipeline.BatchCompressionGzip {
+				assert.Equal(t, want, gunzip(t, p.file.Name()))
+				return
+			}
+			data, err := os.ReadFile(p.file.Name())
+			require.NoError(t, err)
+			assert.Equal(t, want, string(data))
+		})
+	}
+}
@@ -0,0 +1,162 @@
+package file
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ozontech/file.d/cfg"
+	insaneJSON "github.com/vitkovskii/insane-json"
+)
+
+// targetPart is the literal part of the target file template, the field if it's set or the time verb if it isn't zero
+type targetPart struct {
+	literal string
+	field   []string
+	verb    byte
+}
+
+// parseTargetTemplate splits the target file to the literals, the `${field}` parts and the `%Y|%m|%d|%H|%M|%S` time verbs,
+// it returns false if the target file has neither fields nor time verbs
+func parseTargetTemplate(s string) ([]targetPart, bool, error) {
+	parts := make([]targetPart, 0)
+	var verbErr error
+	err := cfg.SplitSubstitution(s, func(raw string) {
+		var err error
+		parts, err = appendTimeVerbs(parts, raw)
+		if err != nil && verbErr == nil {
+			verbErr = err
+		}
+	}, func(field string) error {
+		if field == "" {
+			return fmt.Errorf("empty field in target file: %s", s)
+		}
+		parts = append(parts, targetPart{field: cfg.ParseFieldSelector(field)})
+		return nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	if verbErr != nil {
+		return nil, false, verbErr
+	}
+
+	for i := range parts {
+		if parts[i].verb != 0 || len(parts[i].field) != 0 {
+			return parts, true, nil
+		}
+	}
+	return parts, false, nil
+}
+
+// appendTimeVerbs splits the literal part of the target file by the time verbs, `%%` is `%`
+func appendTimeVerbs(parts []targetPart, s string) ([]targetPart, error) {
+	literal := strings.Builder{}
+	flush := func() {
+		if literal.Len() > 0 {
+			parts = append(parts, targetPart{literal: literal.String()})
+			literal.Reset()
+		}
+	}
+
+	for i := 0; i < len(s); i++ {
+		if s[i] != '%' {
+			literal.WriteByte(s[i])
+			continue
+		}
+		if i+1 == len(s) {
+			return nil, fmt.Errorf("unfinished time verb: %s", s)
+		}
+		i++
+		switch s[i] {
+		case '%':
+			literal.WriteByte('%')
+		case 'Y', 'm', 'd', 'H', 'M', 'S':
+			flush()
+			parts = append(parts, targetPart{verb: s[i]})
+		default:
+			return nil, fmt.Errorf("unknown time verb %%%c: %s", s[i], s)
+		}
+	}
+	flush()
+
+	return parts, nil
+}
+
+// appendTarget appends the target file of the event, the time verbs are replaced by the local time
+func appendTarget(out []byte, parts []targetPart, root *insaneJSON.Root, t time.Time) []byte {
+	for i := range parts {
+		part := &parts[i]
+		switch {
+		case part.verb != 0:
+			out = appendTimeVerb(out, part.verb, t)
+		case len(part.field) != 0:
+			out = appendTargetValue(out, root.Dig(part.field...))
+		default:
+			out = append(out, part.literal...)
+		}
+	}
+	return out
+}
+
+// appendPeriod appends only the time verbs, the files of the same period have the same value
+func appendPeriod(out []byte, parts []targetPart, t time.Time) []byte {
+	for i := range parts {
+		if parts[i].verb != 0 {
+			out = appendTimeVerb(out, parts[i].verb, t)
+		}
+	}
+	return out
+}
+
+// globPattern replaces the fields and the time verbs by `*` to find the files of any target
+func globPattern(parts []targetPart) string {
+	b := strings.Builder{}
+	for i := range parts {
+		part := &parts[i]
+		if part.verb != 0 || len(part.field) != 0 {
+			b.WriteByte('*')
+			continue
+		}
+		b.WriteString(part.literal)
+	}
+	return b.String()
+}
+
+func appendTimeVerb(out []byte, verb byte, t time.Time) []byte {
+	switch verb {
+	case 'Y':
+		return t.AppendFormat(out, "2006")
+	case 'm':
+		return t.AppendFormat(out, "01")
+	case 'd':
+		return t.AppendFormat(out, "02")
+	case 'H':
+		return t.AppendFormat(out, "15")
+	case 'M':
+		return t.AppendFormat(out, "04")
+	default:
+		return t.AppendFormat(out, "05")
+	}
+}
+
+// appendTargetValue appends the value of the field so it's the only path segment,
+// the slashes and the control characters are replaced by `_`, the missing or empty value is `_`
+func appendTargetValue(out []byte, node *insaneJSON.Node) []byte {
+	value := ""
+	if node != nil {
+		value = node.AsString()
+	}
+	if value == "" || value == "." || value == ".." {
+		return append(out, '_')
+	}
+
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if c == '/' || c == '\\' || c < 0x20 || c == 0x7f {
+			c = '_'
+		}
+		out = append(out, c)
+	}
+	return out
+}
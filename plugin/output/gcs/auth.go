@@ -0,0 +1,238 @@
+package gcs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"golang.org/x/oauth2/jwt"
+)
+
+const (
+	storageScope = "https://www.googleapis.com/auth/devstorage.read_write"
+
+	defaultTokenURL    = "https://oauth2.googleapis.com/token"
+	defaultMetadataURL = "http://metadata.google.internal"
+	metadataTokenPath  = "/computeMetadata/v1/instance/service-accounts/default/token"
+
+	// tokenRefreshBefore is how long before the expiration the token is refreshed
+	tokenRefreshBefore = 5 * time.Minute
+)
+
+type tokenResponse struct {
+	AccessToken string      `json:"access_token"`
+	ExpiresIn   json.Number `json:"expires_in"`
+}
+
+// fetchFn gets the new access token
+type fetchFn func(ctx context.Context, client *http.Client) (*oauth2.Token, error)
+
+// credential caches the access token got by the fetch until it expires
+type credential struct {
+	client *http.Client
+	fetch  fetchFn
+
+	mu        sync.Mutex
+	token     string
+	expiresAt time.Time
+}
+
+func (c *credential) authorize(ctx context.Context, req *http.Request) error {
+	token, err := c.getToken(ctx)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return nil
+}
+
+func (c *credential) getToken(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.token != "" && time.Now().Before(c.expiresAt) {
+		return c.token, nil
+	}
+
+	token, err := c.fetch(ctx, c.client)
+	if err != nil {
+		return "", err
+	}
+	if token.AccessToken == "" {
+		return "", errors.New("access token is empty")
+	}
+	if token.Expiry.IsZero() {
+		return "", errors.New("expiry of access token is unknown")
+	}
+
+	c.token = token.AccessToken
+	c.expiresAt = token.Expiry.Add(-tokenRefreshBefore)
+	return c.token, nil
+}
+
+// reset drops the cached token, so the next request gets the new one
+func (c *credential) reset() {
+	c.mu.Lock()
+	c.token = ""
+	c.mu.Unlock()
+}
+
+// credentialsFile is the service account key or the user credentials created by `gcloud auth application-default login`
+type credentialsFile struct {
+	Type string `json:"type"`
+
+	// service_account
+	ClientEmail string `json:"client_email"`
+	TokenURI    string `json:"token_uri"`
+
+	// authorized_user
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// parseCredentials returns the fetch of the credentials file content
+func parseCredentials(content []byte) (fetchFn, error) {
+	f := credentialsFile{}
+	if err := json.Unmarshal(content, &f); err != nil {
+		return nil, fmt.Errorf("wrong credentials json: %w", err)
+	}
+	if f.TokenURI == "" {
+		f.TokenURI = defaultTokenURL
+	}
+
+	switch f.Type {
+	case "service_account":
+		if f.ClientEmail == "" {
+			return nil, errors.New("client_email of service account is empty")
+		}
+		conf, err := google.JWTConfigFromJSON(content, storageScope)
+		if err != nil {
+			return nil, fmt.Errorf("wrong service account: %w", err)
+		}
+		// the key is parsed by the first fetch, so the wrong one is found in advance
+		if block, _ := pem.Decode(conf.PrivateKey); block == nil {
+			return nil, errors.New("private key isn't PEM")
+		}
+		return serviceAccountFetch(conf), nil
+	case "authorized_user":
+		if f.RefreshToken == "" {
+			return nil, errors.New("refresh_token of authorized user is empty")
+		}
+		return authorizedUserFetch(f), nil
+	default:
+		return nil, fmt.Errorf("credentials type %q isn't supported", f.Type)
+	}
+}
+
+// serviceAccountFetch exchanges the JWT of the service account signed by the SDK for the access token
+func serviceAccountFetch(conf *jwt.Config) fetchFn {
+	return func(ctx context.Context, client *http.Client) (*oauth2.Token, error) {
+		ctx = context.WithValue(ctx, oauth2.HTTPClient, client)
+		token, err := conf.TokenSource(ctx).Token()
+		if err != nil {
+			return nil, fmt.Errorf("can't get access token: %w", err)
+		}
+		return token, nil
+	}
+}
+
+// authorizedUserFetch exchanges the refresh token of the user for the access token
+func authorizedUserFetch(f credentialsFile) fetchFn {
+	return func(ctx context.Context, client *http.Client) (*oauth2.Token, error) {
+		form := url.Values{}
+		form.Set("grant_type", "refresh_token")
+		form.Set("client_id", f.ClientID)
+		form.Set("client_secret", f.ClientSecret)
+		form.Set("refresh_token", f.RefreshToken)
+		return requestToken(ctx, client, f.TokenURI, form)
+	}
+}
+
+func requestToken(ctx context.Context, client *http.Client, tokenURL string, form url.Values) (*oauth2.Token, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("can't create token request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return doTokenRequest(client, req)
+}
+
+// metadataFetch gets the access token of the service account attached to the instance or
+// the Kubernetes service account by the workload identity
+func metadataFetch(metadataURL string) fetchFn {
+	return func(ctx context.Context, client *http.Client) (*oauth2.Token, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, metadataURL+metadataTokenPath, http.NoBody)
+		if err != nil {
+			return nil, fmt.Errorf("can't create token request: %w", err)
+		}
+		req.Header.Set("Metadata-Flavor", "Google")
+		return doTokenRequest(client, req)
+	}
+}
+
+func doTokenRequest(client *http.Client, req *http.Request) (*oauth2.Token, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("can't get access token: %w", err)
+	}
+	defer func(Body io.ReadCloser) {
+		_ = Body.Close()
+	}(resp.Body)
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err != nil {
+		return nil, fmt.Errorf("can't read access token: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("can't get access token, response status is %s: %s", resp.Status, bytes.TrimSpace(body))
+	}
+
+	token := tokenResponse{}
+	if err := json.Unmarshal(body, &token); err != nil {
+		return nil, fmt.Errorf("wrong access token response: %w", err)
+	}
+	expiresIn, err := token.ExpiresIn.Int64()
+	if err != nil {
+		return nil, fmt.Errorf("wrong expires_in of access token: %w", err)
+	}
+
+	return &oauth2.Token{
+		AccessToken: token.AccessToken,
+		Expiry:      time.Now().Add(time.Duration(expiresIn) * time.Second),
+	}, nil
+}
+
+// defaultCredentials finds the application default credentials: the file of the GOOGLE_APPLICATION_CREDENTIALS,
+// the file created by the gcloud or the metadata server
+func defaultCredentials(metadataURL string) (fetchFn, error) {
+	if path := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS"); path != "" {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("can't read GOOGLE_APPLICATION_CREDENTIALS file: %w", err)
+		}
+		return parseCredentials(content)
+	}
+
+	if dir, err := os.UserConfigDir(); err == nil {
+		content, err := os.ReadFile(filepath.Join(dir, "gcloud", "application_default_credentials.json"))
+		if err == nil {
+			return parseCredentials(content)
+		}
+	}
+
+	return metadataFetch(metadataURL), nil
+}
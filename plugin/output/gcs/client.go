@@ -0,0 +1,130 @@
+package gcs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// resumableChunkAlign is the size the chunks of the resumable upload except the last one should be the multiple of
+const resumableChunkAlign = 256 * 1024
+
+// objectClient uploads the objects with the JSON API of Cloud Storage
+type objectClient struct {
+	client     *http.Client
+	credential *credential
+	// uploadURL is the URL of the objects upload of the bucket
+	uploadURL string
+	chunkSize int
+}
+
+// statusError is the unexpected response of Cloud Storage
+type statusError struct {
+	status int
+	msg    string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("response status is %d: %s", e.status, e.msg)
+}
+
+func newObjectClient(client *http.Client, credential *credential, endpoint, bucket string, chunkSize int) *objectClient {
+	return &objectClient{
+		client:     client,
+		credential: credential,
+		uploadURL:  endpoint + "/upload/storage/v1/b/" + url.PathEscape(bucket) + "/o",
+		chunkSize:  chunkSize,
+	}
+}
+
+// upload uploads the body by the one media request or by the resumable upload
+// if the body is larger than the chunk size
+func (c *objectClient) upload(ctx context.Context, name string, body []byte, contentType string) error {
+	query := url.Values{}
+	query.Set("name", name)
+
+	if len(body) <= c.chunkSize {
+		query.Set("uploadType", "media")
+		_, err := c.do(ctx, http.MethodPost, c.uploadURL+"?"+query.Encode(), body, map[string]string{
+			"Content-Type": contentType,
+		}, http.StatusOK)
+		return err
+	}
+
+	query.Set("uploadType", "resumable")
+	resp, err := c.do(ctx, http.MethodPost, c.uploadURL+"?"+query.Encode(), nil, map[string]string{
+		"X-Upload-Content-Type":   contentType,
+		"X-Upload-Content-Length": strconv.Itoa(len(body)),
+	}, http.StatusOK)
+	if err != nil {
+		return fmt.Errorf("can't start resumable upload: %w", err)
+	}
+	session := resp.Header.Get("Location")
+	if session == "" {
+		return errors.New("resumable upload session is empty")
+	}
+
+	total := strconv.Itoa(len(body))
+	for start := 0; start < len(body); start += c.chunkSize {
+		end := min(start+c.chunkSize, len(body))
+		contentRange := "bytes " + strconv.Itoa(start) + "-" + strconv.Itoa(end-1) + "/" + total
+
+		// the intermediate chunks are acknowledged with the 308 status
+		wantStatus := http.StatusPermanentRedirect
+		if end == len(body) {
+			wantStatus = http.StatusOK
+		}
+		_, err := c.do(ctx, http.MethodPut, session, body[start:end], map[string]string{
+			"Content-Range": contentRange,
+		}, wantStatus)
+		if err != nil {
+			return fmt.Errorf("can't upload chunk %s: %w", contentRange, err)
+		}
+	}
+
+	return nil
+}
+
+// do sends the request, the created object is responded with the 200 or the 201 status
+func (c *objectClient) do(ctx context.Context, method, rawURL string, body []byte, headers map[string]string, wantStatus int) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, rawURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("can't create request: %w", err)
+	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	if err := c.credential.authorize(ctx, req); err != nil {
+		return nil, err
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("can't send request: %w", err)
+	}
+	defer func(Body io.ReadCloser) {
+		_ = Body.Close()
+	}(resp.Body)
+
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if resp.StatusCode == wantStatus || wantStatus == http.StatusOK && resp.StatusCode == http.StatusCreated {
+		return resp, nil
+	}
+	return nil, &statusError{status: resp.StatusCode, msg: string(bytes.TrimSpace(b))}
+}
+
+// isRetryable returns whether the upload which failed with the error should be retried,
+// the rejected token is retried since it's got again
+func isRetryable(err error) bool {
+	var statusErr *statusError
+	if !errors.As(err, &statusErr) {
+		return true
+	}
+	return statusErr.status == http.StatusUnauthorized || statusErr.status == http.StatusRequestTimeout ||
+		statusErr.status == http.StatusTooManyRequests || statusErr.status >= http.StatusInternalServerError
+}
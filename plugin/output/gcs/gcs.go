@@ -0,0 +1,392 @@
+// Package gcs is an output plugin that uploads events to Google Cloud Storage.
+package gcs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ozontech/file.d/cfg"
+	"github.com/ozontech/file.d/fd"
+	"github.com/ozontech/file.d/metric"
+	"github.com/ozontech/file.d/pipeline"
+	"github.com/ozontech/file.d/plugin/output/internal/objectstore"
+	"github.com/ozontech/file.d/xtls"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+/*{ introduction
+It uploads events to the objects of Google Cloud Storage. The events are written in the NDJSON format,
+each batch is uploaded as the object per path, so the object is rolled over when the batch reaches
+`batch_size` or `batch_size_bytes`, or after `batch_flush_timeout` like the file of the `s3` output.
+The object which is larger than `chunk_size` is uploaded by the resumable upload.
+
+The path of the object is `path_template` rendered for the events, the object name is
+`<path>/<unix_nano>_<random>.log` with the `.gz` or the `.zst` extension if the compression is set.
+The events are committed only after their objects are uploaded.
+
+The plugin authenticates by:
+* `adc` – the application default credentials: the file of the `GOOGLE_APPLICATION_CREDENTIALS` environment variable,
+the file created by `gcloud auth application-default login` or the metadata server
+* `service_account` – the service account key of `credentials_json`
+* `workload_identity` – the metadata server, it's the service account of the instance
+or the Kubernetes service account bound by the GKE workload identity
+
+**Example:**
+```yaml
+pipelines:
+  example_pipeline:
+    ...
+    output:
+      type: gcs
+      bucket: logs-archive
+      auth: workload_identity
+      path_template: "${service}/%Y/%m/%d/%H"
+      time_field: ts
+    ...
+```
+The event `{"service":"api","ts":"2024-05-01T10:15:00Z"}` is uploaded to the object like
+`api/2024/05/01/10/1714558500000000000_3f2a9c1d.log.gz`.
+}*/
+
+const (
+	outPluginType = "gcs"
+)
+
+const (
+	authADC = iota
+	authServiceAccount
+	authWorkloadIdentity
+)
+
+type Plugin struct {
+	config     *Config
+	logger     *zap.SugaredLogger
+	batcher    *pipeline.Batcher
+	controller pipeline.OutputPluginController
+
+	client   *objectClient
+	template *objectstore.Template
+
+	// plugin metrics
+
+	uploadedBytesMetric   *prometheus.CounterVec
+	uploadedObjectsMetric *prometheus.CounterVec
+	uploadErrorsMetric    *prometheus.CounterVec
+	droppedEventsMetric   *prometheus.CounterVec
+}
+
+// ! config-params
+// ^ config-params
+type Config struct {
+	// > @3@4@5@6
+	// >
+	// > The bucket to upload the objects to. It isn't created by the plugin.
+	Bucket string `json:"bucket" required:"true"` // *
+
+	// > @3@4@5@6
+	// >
+	// > How to authenticate:
+	// > * `adc` – by the application default credentials
+	// > * `service_account` – by the service account key of `credentials_json`
+	// > * `workload_identity` – by the token of the metadata server
+	Auth  string `json:"auth" default:"adc" options:"adc|service_account|workload_identity"` // *
+	Auth_ int
+
+	// > @3@4@5@6
+	// >
+	// > The service account key, it's a JSON content or a path to the file.
+	// > The user credentials of `gcloud auth application-default login` are supported too.
+	CredentialsJSON string `json:"credentials_json"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The URL of the metadata server. If it's empty, it's the `GCE_METADATA_HOST` environment variable
+	// > or `http://metadata.google.internal`.
+	MetadataEndpoint string `json:"metadata_endpoint"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The URL of Cloud Storage, it's changed for the emulators or the private endpoints.
+	Endpoint string `json:"endpoint" default:"https://storage.googleapis.com"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The path of the objects, the `${field}` parts are replaced by the values of the event fields and
+	// > `%Y`, `%m`, `%d`, `%H`, `%M`, `%S` are replaced by the UTC time of the event, `%%` is `%`.
+	// > The slashes and the control characters of the values are replaced by `_`, the missing values are `_`.
+	// > The events of the batch are grouped into the objects by the rendered path.
+	PathTemplate string `json:"path_template" default:"%Y/%m/%d/%H"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The event field which contains the time for the path. If it's empty or the event time can't be parsed,
+	// > the time of the upload is used.
+	TimeField  cfg.FieldSelector `json:"time_field" parse:"selector"` // *
+	TimeField_ []string
+
+	// > @3@4@5@6
+	// >
+	// > The format of `time_field`, it's one of
+	// > `ansic|unixdate|rubydate|rfc822|rfc822z|rfc850|rfc1123|rfc1123z|rfc3339|rfc3339nano|kitchen|stamp|stampmilli|stampmicro|stampnano|unixtime|nginx_errorlog`
+	// > or the Go reference layout.
+	TimeFormat string `json:"time_format" default:"rfc3339nano"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The compression of the objects.
+	Compression string `json:"compression" default:"gzip" options:"none|gzip|zstd"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The max size of the object uploaded by the one request in bytes, the larger objects are uploaded
+	// > by the resumable upload in the chunks of this size. It should be a multiple of 262144.
+	ChunkSize  cfg.Expression `json:"chunk_size" default:"8388608" parse:"expression"` // *
+	ChunkSize_ int
+
+	// > @3@4@5@6
+	// >
+	// > The CA certificate to verify Cloud Storage, it's a PEM content or a path to the file.
+	CACert string `json:"ca_cert"` // *
+
+	// > @3@4@5@6
+	// >
+	// > Skip the verification of the Cloud Storage certificate.
+	InsecureSkipVerify bool `json:"insecure_skip_verify" default:"false"` // *
+
+	// > @3@4@5@6
+	// >
+	// > How many workers will be instantiated to send batches.
+	WorkersCount  cfg.Expression `json:"workers_count" default:"gomaxprocs*4" parse:"expression"` // *
+	WorkersCount_ int
+
+	// > @3@4@5@6
+	// >
+	// > Client timeout when uploads the object.
+	RequestTimeout  cfg.Duration `json:"request_timeout" default:"1m" parse:"duration"` // *
+	RequestTimeout_ time.Duration
+
+	// > @3@4@5@6
+	// >
+	// > A maximum quantity of events to pack into one batch.
+	BatchSize  cfg.Expression `json:"batch_size" default:"capacity/4" parse:"expression"` // *
+	BatchSize_ int
+
+	// > @3@4@5@6
+	// >
+	// > A minimum size of events in a batch to send.
+	// > If both batch_size and batch_size_bytes are set, they will work together.
+	BatchSizeBytes  cfg.Expression `json:"batch_size_bytes" default:"0" parse:"expression"` // *
+	BatchSizeBytes_ int
+
+	// > @3@4@5@6
+	// >
+	// > After this timeout the batch will be sent even if batch isn't completed.
+	BatchFlushTimeout  cfg.Duration `json:"batch_flush_timeout" default:"10s" parse:"duration"` // *
+	BatchFlushTimeout_ time.Duration
+
+	// > @3@4@5@6
+	// >
+	// > Retries of the upload.
+	Retry int `json:"retry" default:"10"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The delay before the first retry, it's doubled after each retry.
+	Retention  cfg.Duration `json:"retention" default:"1s" parse:"duration"` // *
+	Retention_ time.Duration
+
+	// > @3@4@5@6
+	// >
+	// > The max delay between the retries.
+	MaxRetention  cfg.Duration `json:"max_retention" default:"1m" parse:"duration"` // *
+	MaxRetention_ time.Duration
+
+	// > @3@4@5@6
+	// >
+	// > Exit with the non-zero code if the batch isn't uploaded after all the retries, otherwise the batch is dropped.
+	FatalOnFailedInsert bool `json:"fatal_on_failed_insert" default:"false"` // *
+}
+
+type data struct {
+	objects *objectstore.Objects
+}
+
+func init() {
+	fd.DefaultPluginRegistry.RegisterOutput(&pipeline.PluginStaticInfo{
+		Type:    outPluginType,
+		Factory: Factory,
+	})
+}
+
+func Factory() (pipeline.AnyPlugin, pipeline.AnyConfig) {
+	return &Plugin{}, &Config{}
+}
+
+func (p *Plugin) Start(config pipeline.AnyConfig, params *pipeline.OutputPluginParams) {
+	p.controller = params.Controller
+	p.logger = params.Logger
+	p.config = config.(*Config)
+	p.registerMetrics(params.MetricCtl)
+
+	if err := p.prepare(); err != nil {
+		p.logger.Fatal(err.Error())
+	}
+
+	p.batcher = pipeline.NewBatcher(pipeline.BatcherOptions{
+		PipelineName:   params.PipelineName,
+		OutputType:     outPluginType,
+		OutFn:          p.out,
+		Controller:     p.controller,
+		Workers:        p.config.WorkersCount_,
+		BatchSizeCount: p.config.BatchSize_,
+		BatchSizeBytes: p.config.BatchSizeBytes_,
+		FlushTimeout:   p.config.BatchFlushTimeout_,
+		MaxEventAge:    params.PipelineSettings.MaxEventAge,
+		CircuitBreaker: params.PipelineSettings.CircuitBreaker,
+		Health:         params.Health,
+		ErrorReporter:  params.ErrorReporter,
+		DeadLetter:     params.DeadLetter,
+		MetricCtl:      params.MetricCtl,
+		Compression:    pipeline.BatchCompression(p.config.Compression),
+		Retry: pipeline.BatcherRetry{
+			MaxRetries:        p.config.Retry,
+			InitialBackoff:    p.config.Retention_,
+			BackoffMultiplier: 2,
+			MaxBackoff:        p.config.MaxRetention_,
+		},
+		FatalOnFailedInsert: p.config.FatalOnFailedInsert,
+	})
+
+	p.batcher.Start(context.TODO())
+}
+
+// prepare parses the path template and creates the client with the credential of the auth
+func (p *Plugin) prepare() error {
+	template, err := objectstore.NewTemplate(p.config.PathTemplate, p.config.TimeField_, p.config.TimeFormat)
+	if err != nil {
+		return err
+	}
+	p.template = template
+
+	if p.config.ChunkSize_ <= 0 || p.config.ChunkSize_%resumableChunkAlign != 0 {
+		return fmt.Errorf("chunk_size should be a positive multiple of %d", resumableChunkAlign)
+	}
+
+	httpClient, err := xtls.NewHTTPClient(p.config.CACert, p.config.InsecureSkipVerify, p.config.RequestTimeout_)
+	if err != nil {
+		return fmt.Errorf("can't create client: %w", err)
+	}
+
+	fetch, err := p.newFetch()
+	if err != nil {
+		return fmt.Errorf("can't create credentials: %w", err)
+	}
+
+	credential := &credential{client: httpClient, fetch: fetch}
+	p.client = newObjectClient(httpClient, credential, strings.TrimSuffix(p.config.Endpoint, "/"), p.config.Bucket, p.config.ChunkSize_)
+	return nil
+}
+
+// newFetch returns the fetch of the access token of the auth
+func (p *Plugin) newFetch() (fetchFn, error) {
+	metadataURL := p.config.MetadataEndpoint
+	if metadataURL == "" {
+		metadataURL = defaultMetadataURL
+		if host := os.Getenv("GCE_METADATA_HOST"); host != "" {
+			metadataURL = "http://" + host
+		}
+	}
+
+	switch p.config.Auth_ {
+	case authServiceAccount:
+		if p.config.CredentialsJSON == "" {
+			return nil, errors.New("credentials_json should be set for the service_account auth")
+		}
+		content := []byte(p.config.CredentialsJSON)
+		if !strings.HasPrefix(strings.TrimSpace(p.config.CredentialsJSON), "{") {
+			b, err := os.ReadFile(p.config.CredentialsJSON)
+			if err != nil {
+				return nil, fmt.Errorf("can't read credentials file: %w", err)
+			}
+			content = b
+		}
+		return parseCredentials(content)
+	case authWorkloadIdentity:
+		return metadataFetch(strings.TrimSuffix(metadataURL, "/")), nil
+	default:
+		return defaultCredentials(strings.TrimSuffix(metadataURL, "/"))
+	}
+}
+
+func (p *Plugin) registerMetrics(ctl *metric.Ctl) {
+	p.uploadedBytesMetric = ctl.RegisterCounter("output_gcs_uploaded_bytes_total", "Size of uploaded objects in bytes")
+	p.uploadedObjectsMetric = ctl.RegisterCounter("output_gcs_uploaded_objects_total", "Number of uploaded objects")
+	p.uploadErrorsMetric = ctl.RegisterCounter("output_gcs_upload_errors_total", "Number of failed uploads which are retried")
+	p.droppedEventsMetric = ctl.RegisterCounter("output_gcs_dropped_events_total", "Number of events rejected by Cloud Storage")
+}
+
+func (p *Plugin) Stop() {
+	p.batcher.Stop()
+}
+
+func (p *Plugin) Out(event *pipeline.Event) {
+	p.batcher.Add(event)
+}
+
+func (p *Plugin) out(workerData *pipeline.WorkerData, batch *pipeline.Batch) error {
+	if *workerData == nil {
+		*workerData = &data{
+			objects: objectstore.NewObjects(p.template),
+		}
+	}
+
+	data := (*workerData).(*data)
+	for _, o := range data.objects.Group(batch) {
+		size, err := p.uploadObject(data, batch, o)
+		if err == nil {
+			p.uploadedObjectsMetric.WithLabelValues().Inc()
+			p.uploadedBytesMetric.WithLabelValues().Add(float64(size))
+			continue
+		}
+
+		if !isRetryable(err) {
+			p.droppedEventsMetric.WithLabelValues().Add(float64(len(o.Events)))
+			reason := fmt.Sprintf("object is rejected by gcs bucket=%s path=%s: %s", p.config.Bucket, o.Path, err.Error())
+			for _, event := range o.Events {
+				batch.MarkRejected(event, reason)
+			}
+			continue
+		}
+
+		var statusErr *statusError
+		if errors.As(err, &statusErr) && statusErr.status == http.StatusUnauthorized {
+			p.client.credential.reset()
+		}
+		p.uploadErrorsMetric.WithLabelValues().Inc()
+		p.logger.Errorf("can't upload object to gcs bucket=%s path=%s: %s", p.config.Bucket, o.Path, err.Error())
+		for _, event := range o.Events {
+			batch.MarkFailed(event)
+		}
+	}
+
+	data.objects.Release()
+
+	return nil
+}
+
+// uploadObject compresses and uploads the object, it returns the size of the uploaded body
+func (p *Plugin) uploadObject(data *data, batch *pipeline.Batch, o *objectstore.Object) (int, error) {
+	body, err := batch.Compress(o.Body)
+	if err != nil {
+		return 0, fmt.Errorf("can't compress object: %w", err)
+	}
+
+	extension, contentType := objectstore.Format(batch.Compression())
+	return len(body), p.client.upload(context.Background(), data.objects.Name(o, extension), body, contentType)
+}
@@ -0,0 +1,366 @@
+package gcs
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/ozontech/file.d/cfg"
+	"github.com/ozontech/file.d/pipeline"
+	"github.com/ozontech/file.d/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+// fakeStorage stores the objects uploaded by the media and the resumable uploads
+type fakeStorage struct {
+	t  *testing.T
+	mu sync.Mutex
+
+	url      string
+	objects  map[string][]byte
+	types    map[string]string
+	sessions map[string]*session
+	requests []*http.Request
+	// statuses are the statuses of the next responses, then the requests are handled
+	statuses []int
+}
+
+type session struct {
+	name        string
+	contentType string
+	body        []byte
+}
+
+func newFakeStorage(t *testing.T, statuses ...int) *fakeStorage {
+	f := &fakeStorage{
+		t:        t,
+		objects:  make(map[string][]byte),
+		types:    make(map[string]string),
+		sessions: make(map[string]*session),
+		statuses: statuses,
+	}
+	server := httptest.NewServer(f)
+	t.Cleanup(server.Close)
+	f.url = server.URL
+	return f
+}
+
+func (f *fakeStorage) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	body, _ := io.ReadAll(r.Body)
+	f.requests = append(f.requests, r)
+	if len(f.statuses) > 0 {
+		status := f.statuses[0]
+		f.statuses = f.statuses[1:]
+		w.WriteHeader(status)
+		return
+	}
+
+	if strings.HasPrefix(r.URL.Path, "/session/") {
+		s := f.sessions[r.URL.Path]
+		var start, end, total int
+		_, err := fmt.Sscanf(r.Header.Get("Content-Range"), "bytes %d-%d/%d", &start, &end, &total)
+		require.NoError(f.t, err)
+		require.Equal(f.t, len(s.body), start)
+		require.Equal(f.t, end-start+1, len(body))
+		s.body = append(s.body, body...)
+		if len(s.body) < total {
+			w.Header().Set("Range", "bytes=0-"+strconv.Itoa(len(s.body)-1))
+			w.WriteHeader(http.StatusPermanentRedirect)
+			return
+		}
+		f.objects[s.name] = s.body
+		f.types[s.name] = s.contentType
+		return
+	}
+
+	require.Equal(f.t, "/upload/storage/v1/b/logs/o", r.URL.Path)
+	name := r.URL.Query().Get("name")
+	switch r.URL.Query().Get("uploadType") {
+	case "media":
+		f.objects[name] = body
+		f.types[name] = r.Header.Get("Content-Type")
+	case "resumable":
+		path := "/session/" + strconv.Itoa(len(f.sessions))
+		f.sessions[path] = &session{name: name, contentType: r.Header.Get("X-Upload-Content-Type")}
+		w.Header().Set("Location", f.url+path)
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+// uploaded returns the uploaded objects by the path without the object name
+func (f *fakeStorage) uploaded(t *testing.T) map[string]string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	result := make(map[string]string)
+	for name, object := range f.objects {
+		dir := name[:strings.LastIndexByte(name, '/')]
+		if strings.HasSuffix(name, ".gz") {
+			r, err := gzip.NewReader(bytes.NewReader(object))
+			require.NoError(t, err)
+			object, err = io.ReadAll(r)
+			require.NoError(t, err)
+		}
+		result[dir] += string(object)
+	}
+	return result
+}
+
+// newMetadataServer responds with the token and counts the requests
+func newMetadataServer(t *testing.T) (string, *int) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Google", r.Header.Get("Metadata-Flavor"))
+		assert.Equal(t, metadataTokenPath, r.URL.Path)
+		requests++
+		_, _ = w.Write([]byte(`{"access_token":"metadata-token","expires_in":3599,"token_type":"Bearer"}`))
+	}))
+	t.Cleanup(server.Close)
+	return server.URL, &requests
+}
+
+func TestUpload(t *testing.T) {
+	cases := []struct {
+		name        string
+		compression string
+		chunkSize   cfg.Expression
+		events      int
+		wantType    string
+	}{
+		{name: "media", compression: "none", events: 2, wantType: "application/x-ndjson"},
+		{name: "gzip", compression: "gzip", events: 2, wantType: "application/gzip"},
+		{name: "resumable", compression: "none", chunkSize: "262144", events: 20000, wantType: "application/x-ndjson"},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := newFakeStorage(t)
+			metadataURL, _ := newMetadataServer(t)
+			p := &Plugin{}
+			test.StartOutputPlugin(t, p, &Config{
+				Bucket:           "logs",
+				Auth:             "workload_identity",
+				MetadataEndpoint: metadataURL,
+				Endpoint:         storage.url,
+				PathTemplate:     "${service}/%Y/%m/%d/%H",
+				TimeField:        "ts",
+				Compression:      tt.compression,
+				ChunkSize:        tt.chunkSize,
+			}, nil)
+
+			events := make([]string, 0, tt.events)
+			want := map[string]string{}
+			for i := 0; i < tt.events; i++ {
+				service := []string{"api", "db"}[i%2]
+				event := fmt.Sprintf(`{"service":"%s","ts":"2024-05-01T10:15:00Z","message":"message %d"}`, service, i)
+				events = append(events, event)
+				want[service+"/2024/05/01/10"] += event + "\n"
+			}
+
+			workerData := pipeline.WorkerData(nil)
+			require.NoError(t, p.out(&workerData, test.NewCompressedBatch(t, pipeline.BatchCompression(tt.compression), events...)))
+
+			assert.Equal(t, want, storage.uploaded(t))
+			for _, contentType := range storage.types {
+				assert.Equal(t, tt.wantType, contentType)
+			}
+			for _, r := range storage.requests {
+				assert.Equal(t, "Bearer metadata-token", r.Header.Get("Authorization"))
+			}
+			if tt.chunkSize != "" {
+				assert.Len(t, storage.sessions, 2)
+			}
+		})
+	}
+}
+
+func TestUploadErrors(t *testing.T) {
+	storage := newFakeStorage(t, http.StatusServiceUnavailable, http.StatusForbidden, http.StatusUnauthorized)
+	metadataURL, tokenRequests := newMetadataServer(t)
+	p := &Plugin{}
+	test.StartOutputPlugin(t, p, &Config{
+		Bucket:           "logs",
+		Auth:             "workload_identity",
+		MetadataEndpoint: metadataURL,
+		Endpoint:         storage.url,
+		PathTemplate:     "${service}",
+		Compression:      "none",
+	}, nil)
+
+	workerData := pipeline.WorkerData(nil)
+	require.NoError(t, p.out(&workerData, test.NewBatch(t,
+		`{"service":"api","message":"retried"}`,
+		`{"service":"db","message":"dropped"}`,
+		`{"service":"web","message":"unauthorized"}`,
+	)))
+	assert.Empty(t, storage.uploaded(t))
+	assert.Equal(t, 1, *tokenRequests)
+
+	// only the events of the objects failed with the retryable status are sent again,
+	// the token is got again after the unauthorized status
+	require.NoError(t, p.out(&workerData, test.NewBatch(t,
+		`{"service":"api","message":"retried"}`,
+		`{"service":"web","message":"unauthorized"}`,
+	)))
+	assert.Equal(t, map[string]string{
+		"api": `{"service":"api","message":"retried"}` + "\n",
+		"web": `{"service":"web","message":"unauthorized"}` + "\n",
+	}, storage.uploaded(t))
+	assert.Equal(t, 2, *tokenRequests)
+}
+
+func TestServiceAccount(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	tokenRequests := 0
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		assert.Equal(t, "urn:ietf:params:oauth:grant-type:jwt-bearer", r.Form.Get("grant_type"))
+
+		parts := strings.Split(r.Form.Get("assertion"), ".")
+		require.Len(t, parts, 3)
+		signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+		require.NoError(t, err)
+		hash := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+		require.NoError(t, rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, hash[:], signature))
+
+		claimsJSON, err := base64.RawURLEncoding.DecodeString(parts[1])
+		require.NoError(t, err)
+		claims := map[string]any{}
+		require.NoError(t, json.Unmarshal(claimsJSON, &claims))
+		assert.Equal(t, "writer@project.iam.gserviceaccount.com", claims["iss"])
+		assert.Equal(t, storageScope, claims["scope"])
+
+		tokenRequests++
+		_, _ = w.Write([]byte(`{"access_token":"sa-token","expires_in":3599}`))
+	}))
+	t.Cleanup(tokenServer.Close)
+
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	credentials, err := json.Marshal(map[string]string{
+		"type":           "service_account",
+		"client_email":   "writer@project.iam.gserviceaccount.com",
+		"private_key_id": "key-id",
+		"private_key":    string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8})),
+		"token_uri":      tokenServer.URL,
+	})
+	require.NoError(t, err)
+
+	storage := newFakeStorage(t)
+	p := &Plugin{}
+	test.StartOutputPlugin(t, p, &Config{
+		Bucket:          "logs",
+		Auth:            "service_account",
+		CredentialsJSON: string(credentials),
+		Endpoint:        storage.url,
+		PathTemplate:    "archive",
+		Compression:     "none",
+	}, nil)
+
+	workerData := pipeline.WorkerData(nil)
+	require.NoError(t, p.out(&workerData, test.NewBatch(t, `{"message":"first"}`)))
+	require.NoError(t, p.out(&workerData, test.NewBatch(t, `{"message":"second"}`)))
+
+	assert.Len(t, storage.objects, 2)
+	assert.Equal(t, 1, tokenRequests)
+	for _, r := range storage.requests {
+		assert.Equal(t, "Bearer sa-token", r.Header.Get("Authorization"))
+	}
+}
+
+func TestParseCredentials(t *testing.T) {
+	cases := []struct {
+		name    string
+		content string
+		wantErr bool
+	}{
+		{
+			name:    "authorized_user",
+			content: `{"type":"authorized_user","client_id":"id","client_secret":"secret","refresh_token":"token"}`,
+		},
+		{
+			name:    "no_refresh_token",
+			content: `{"type":"authorized_user","client_id":"id"}`,
+			wantErr: true,
+		},
+		{
+			name:    "wrong_key",
+			content: `{"type":"service_account","client_email":"writer@project.iam.gserviceaccount.com","private_key":"key"}`,
+			wantErr: true,
+		},
+		{
+			name:    "external_account",
+			content: `{"type":"external_account"}`,
+			wantErr: true,
+		},
+		{
+			name:    "not_json",
+			content: `type`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseCredentials([]byte(tt.content))
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestCredentialCache(t *testing.T) {
+	fetches := 0
+	c := &credential{
+		client: http.DefaultClient,
+		fetch: func(_ context.Context, _ *http.Client) (*oauth2.Token, error) {
+			fetches++
+			return &oauth2.Token{AccessToken: "token" + strconv.Itoa(fetches), Expiry: time.Now().Add(time.Hour)}, nil
+		},
+	}
+
+	for i := 0; i < 3; i++ {
+		token, err := c.getToken(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, "token1", token)
+	}
+
+	c.reset()
+	token, err := c.getToken(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "token2", token)
+
+	// the token which expires soon is refreshed
+	c.expiresAt = time.Now().Add(-time.Second)
+	token, err = c.getToken(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "token3", token)
+}
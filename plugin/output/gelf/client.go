@@ -2,24 +2,35 @@ package gelf
 
 import (
 	"crypto/tls"
+	"encoding/binary"
 	"net"
 	"time"
 )
 
-const transportTCP string = "tcp"
+const (
+	transportTCP string = "tcp"
+	transportUDP string = "udp"
+
+	// chunkHeaderSize is the size of the magic bytes, the message id, the sequence number and the sequence count
+	chunkHeaderSize = 12
+	maxChunks       = 128
+)
+
+var chunkMagic = []byte{0x1e, 0x0f}
 
 type client struct {
 	conn    net.Conn
 	timeout time.Duration
+	chunk   []byte
 }
 
-func newClient(address string, connTimeout, writeTimeout time.Duration, useTLS bool, tlsConfig *tls.Config) (c *client, err error) {
+func newClient(transport, address string, connTimeout, writeTimeout time.Duration, useTLS bool, tlsConfig *tls.Config) (c *client, err error) {
 	c = &client{timeout: writeTimeout}
 
 	if useTLS {
-		c.conn, err = tls.DialWithDialer(&net.Dialer{Timeout: connTimeout}, transportTCP, address, tlsConfig)
+		c.conn, err = tls.DialWithDialer(&net.Dialer{Timeout: connTimeout}, transport, address, tlsConfig)
 	} else {
-		c.conn, err = net.DialTimeout(transportTCP, address, connTimeout)
+		c.conn, err = net.DialTimeout(transport, address, connTimeout)
 	}
 
 	return c, err
@@ -32,6 +43,31 @@ func (g *client) send(data []byte) (int, error) {
 	return g.conn.Write(data)
 }
 
+// sendChunked sends the message by the chunks of the GELF chunked UDP protocol,
+// the message must fit into maxChunks chunks
+func (g *client) sendChunked(message []byte, chunkSize int, id uint64) error {
+	dataSize := chunkSize - chunkHeaderSize
+	count := chunksCount(len(message), chunkSize)
+	for seq := 0; seq < count; seq++ {
+		part := message[seq*dataSize : min((seq+1)*dataSize, len(message))]
+
+		g.chunk = append(g.chunk[:0], chunkMagic...)
+		g.chunk = binary.BigEndian.AppendUint64(g.chunk, id)
+		g.chunk = append(g.chunk, byte(seq), byte(count))
+		g.chunk = append(g.chunk, part...)
+		if _, err := g.send(g.chunk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// chunksCount returns the number of the chunks to send the message of the size
+func chunksCount(size, chunkSize int) int {
+	dataSize := chunkSize - chunkHeaderSize
+	return (size + dataSize - 1) / dataSize
+}
+
 func (g *client) close() error {
 	return g.conn.Close()
 }
@@ -2,6 +2,8 @@ package gelf
 
 import (
 	"context"
+	"fmt"
+	"math/rand"
 	"strings"
 	"time"
 
@@ -16,9 +18,12 @@ import (
 
 /*{ introduction
 It sends event batches to the GELF endpoint. Transport level protocol TCP or UDP is configurable.
-> It doesn't support UDP chunking. So don't use UDP if event size may be greater than 8192.
 
-GELF messages are separated by null byte. Each message is a JSON with the following fields:
+GELF TCP messages are separated by null byte. Every GELF UDP message is sent as a datagram,
+it's gzipped if `compression` is set. The UDP message larger than `chunk_size` is split to the GELF chunks,
+the message which needs more than 128 chunks is dropped.
+
+Each message is a JSON with the following fields:
 * `version` *`string=1.1`*
 * `host` *`string`*
 * `short_message` *`string`*
@@ -35,6 +40,8 @@ Allowed characters in field names are letters, numbers, underscores, dashes, and
 
 const (
 	outPluginType = "gelf"
+
+	compressionGzip = "gzip"
 )
 
 type Plugin struct {
@@ -46,7 +53,9 @@ type Plugin struct {
 
 	// plugin metrics
 
-	sendErrorMetric *prometheus.CounterVec
+	sendErrorMetric       *prometheus.CounterVec
+	chunkedMessagesMetric *prometheus.CounterVec
+	droppedMessagesMetric *prometheus.CounterVec
 }
 
 // ! config-params
@@ -57,6 +66,25 @@ type Config struct {
 	// > An address of gelf endpoint. Format: `HOST:PORT`. E.g. `localhost:12201`.
 	Endpoint string `json:"endpoint" required:"true"` // *
 
+	// > @3@4@5@6
+	// >
+	// > Transport level protocol:
+	// > * `tcp` – the messages are separated by the null byte
+	// > * `udp` – every message is sent as a datagram, the large messages are chunked
+	Transport string `json:"transport" default:"tcp" options:"tcp|udp"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The maximum size of the UDP datagram including the 12 bytes of the chunk header.
+	// > The default fits into the MTU of the most networks, the larger size such as `8192` can be used in LAN.
+	ChunkSize  cfg.Expression `json:"chunk_size" default:"1420" parse:"expression"` // *
+	ChunkSize_ int
+
+	// > @3@4@5@6
+	// >
+	// > The compression of the UDP messages, GELF TCP doesn't support the compression.
+	Compression string `json:"compression" default:"none" options:"none|gzip"` // *
+
 	// > @3@4@5@6
 	// >
 	// > The plugin reconnects to endpoint periodically using this interval. It is useful if an endpoint is a load balancer.
@@ -159,6 +187,9 @@ type data struct {
 	outBuf    []byte
 	encodeBuf []byte
 	gelf      *client
+
+	// ends are the ends of the UDP messages in outBuf
+	ends []int
 }
 
 func init() {
@@ -191,6 +222,13 @@ func (p *Plugin) Start(config pipeline.AnyConfig, params *pipeline.OutputPluginP
 	p.config.timestampFieldFormat = format
 	p.config.levelField = pipeline.ByteToStringUnsafe(p.formatExtraField(nil, p.config.LevelField))
 
+	if p.config.Transport == transportUDP && p.config.ChunkSize_ <= chunkHeaderSize {
+		p.logger.Fatalf("'chunk_size' must be greater than %d", chunkHeaderSize)
+	}
+	if p.config.Transport == transportTCP && p.config.Compression == compressionGzip {
+		p.logger.Fatal("compression is supported only by udp transport")
+	}
+
 	p.batcher = pipeline.NewBatcher(pipeline.BatcherOptions{
 		PipelineName:        params.PipelineName,
 		OutputType:          outPluginType,
@@ -202,7 +240,13 @@ func (p *Plugin) Start(config pipeline.AnyConfig, params *pipeline.OutputPluginP
 		BatchSizeBytes:      p.config.BatchSizeBytes_,
 		FlushTimeout:        p.config.BatchFlushTimeout_,
 		MaintenanceInterval: p.config.ReconnectInterval_,
+		MaxEventAge:         params.PipelineSettings.MaxEventAge,
+		CircuitBreaker:      params.PipelineSettings.CircuitBreaker,
+		Health:              params.Health,
+		ErrorReporter:       params.ErrorReporter,
+		DeadLetter:          params.DeadLetter,
 		MetricCtl:           params.MetricCtl,
+		Compression:         pipeline.BatchCompression(p.config.Compression),
 	})
 
 	p.batcher.Start(context.TODO())
@@ -218,9 +262,11 @@ func (p *Plugin) Out(event *pipeline.Event) {
 
 func (p *Plugin) registerMetrics(ctl *metric.Ctl) {
 	p.sendErrorMetric = ctl.RegisterCounter("output_gelf_send_error", "Total GELF send errors")
+	p.chunkedMessagesMetric = ctl.RegisterCounter("output_gelf_chunked_messages_total", "Number of GELF UDP messages sent by chunks")
+	p.droppedMessagesMetric = ctl.RegisterCounter("output_gelf_dropped_messages_total", "Number of GELF UDP messages which need too many chunks and are dropped")
 }
 
-func (p *Plugin) out(workerData *pipeline.WorkerData, batch *pipeline.Batch) {
+func (p *Plugin) out(workerData *pipeline.WorkerData, batch *pipeline.Batch) error {
 	if *workerData == nil {
 		*workerData = &data{
 			outBuf:    make([]byte, 0, p.config.BatchSize_*p.avgEventSize),
@@ -236,9 +282,14 @@ func (p *Plugin) out(workerData *pipeline.WorkerData, batch *pipeline.Batch) {
 
 	outBuf := data.outBuf[:0]
 	encodeBuf := data.encodeBuf[:0]
+	data.ends = data.ends[:0]
 	for _, event := range batch.Events {
 		encodeBuf = p.formatEvent(encodeBuf, event)
 		outBuf, _ = event.Encode(outBuf)
+		if p.config.Transport == transportUDP {
+			data.ends = append(data.ends, len(outBuf))
+			continue
+		}
 		outBuf = append(outBuf, byte(0))
 	}
 	data.outBuf = outBuf
@@ -248,7 +299,7 @@ func (p *Plugin) out(workerData *pipeline.WorkerData, batch *pipeline.Batch) {
 		if data.gelf == nil {
 			p.logger.Infof("connecting to gelf address=%s", p.config.Endpoint)
 
-			gelf, err := newClient(p.config.Endpoint, p.config.ConnectionTimeout_, p.config.WriteTimeout_, false, nil)
+			gelf, err := newClient(p.config.Transport, p.config.Endpoint, p.config.ConnectionTimeout_, p.config.WriteTimeout_, false, nil)
 			if err != nil {
 				p.sendErrorMetric.WithLabelValues().Inc()
 				p.logger.Errorf("can't connect to gelf endpoint address=%s: %s", p.config.Endpoint, err.Error())
@@ -258,7 +309,7 @@ func (p *Plugin) out(workerData *pipeline.WorkerData, batch *pipeline.Batch) {
 			data.gelf = gelf
 		}
 
-		_, err := data.gelf.send(outBuf)
+		err := p.send(data, batch)
 		if err != nil {
 			p.sendErrorMetric.WithLabelValues().Inc()
 			p.logger.Errorf("can't send data to gelf address=%s, err: %s", p.config.Endpoint, err.Error())
@@ -270,6 +321,48 @@ func (p *Plugin) out(workerData *pipeline.WorkerData, batch *pipeline.Batch) {
 
 		break
 	}
+
+	return nil
+}
+
+// send sends the null byte separated TCP messages at once or the UDP messages one by one,
+// the UDP messages are compressed by the batch
+func (p *Plugin) send(data *data, batch *pipeline.Batch) error {
+	if p.config.Transport != transportUDP {
+		_, err := data.gelf.send(data.outBuf)
+		return err
+	}
+
+	start := 0
+	for _, end := range data.ends {
+		message := data.outBuf[start:end]
+		start = end
+
+		message, err := batch.Compress(message)
+		if err != nil {
+			return fmt.Errorf("can't compress message: %w", err)
+		}
+
+		if len(message) <= p.config.ChunkSize_ {
+			if _, err := data.gelf.send(message); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if chunksCount(len(message), p.config.ChunkSize_) > maxChunks {
+			p.droppedMessagesMetric.WithLabelValues().Inc()
+			p.logger.Errorf("gelf message of %d bytes needs more than %d chunks of %d bytes, it's dropped",
+				len(message), maxChunks, p.config.ChunkSize_)
+			continue
+		}
+		if err := data.gelf.sendChunked(message, p.config.ChunkSize_, rand.Uint64()); err != nil {
+			return err
+		}
+		p.chunkedMessagesMetric.WithLabelValues().Inc()
+	}
+
+	return nil
 }
 
 func (p *Plugin) maintenance(workerData *pipeline.WorkerData) {
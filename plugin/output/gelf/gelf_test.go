@@ -1,15 +1,22 @@
 package gelf
 
 import (
+	"bytes"
+	"compress/gzip"
 	"encoding/json"
+	"io"
+	"math/rand"
+	"net"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/ozontech/file.d/cfg"
 	"github.com/ozontech/file.d/logger"
 	"github.com/ozontech/file.d/pipeline"
 	"github.com/ozontech/file.d/test"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	insaneJSON "github.com/vitkovskii/insane-json"
 )
 
@@ -121,3 +128,81 @@ func TestFormatEvent(t *testing.T) {
 		assert.Equal(t, expected, resultJSON, "wrong formatted event")
 	}
 }
+
+func TestOutUDPChunked(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer conn.Close()
+
+	config := &Config{
+		Endpoint:    conn.LocalAddr().String(),
+		Transport:   transportUDP,
+		ChunkSize:   "100",
+		Compression: compressionGzip,
+	}
+	test.NewConfig(config, map[string]int{"gomaxprocs": 1, "capacity": 64})
+
+	plugin := Plugin{}
+	plugin.Start(config, test.NewEmptyOutputPluginParams())
+	defer plugin.Stop()
+
+	// the random message is barely compressed, so it's chunked
+	message := make([]byte, 500)
+	for i := range message {
+		message[i] = byte('a' + rand.Intn(26))
+	}
+	small, err := insaneJSON.DecodeString(`{"message":"small"}`)
+	require.NoError(t, err)
+	defer insaneJSON.Release(small)
+	large, err := insaneJSON.DecodeString(`{"message":"` + string(message) + `"}`)
+	require.NoError(t, err)
+	defer insaneJSON.Release(large)
+
+	batch := &pipeline.Batch{Events: []*pipeline.Event{{Root: small}, {Root: large}}}
+	batch.SetCompression(pipeline.BatchCompressionGzip)
+	workerData := pipeline.WorkerData(nil)
+	require.NoError(t, plugin.out(&workerData, batch))
+
+	read := func() []byte {
+		buf := make([]byte, 2048)
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+		n, _, err := conn.ReadFrom(buf)
+		require.NoError(t, err)
+		return buf[:n]
+	}
+	gunzip := func(data []byte) map[string]any {
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		require.NoError(t, err)
+		data, err = io.ReadAll(zr)
+		require.NoError(t, err)
+		msg := map[string]any{}
+		require.NoError(t, json.Unmarshal(data, &msg))
+		return msg
+	}
+
+	assert.Equal(t, "small", gunzip(read())["short_message"])
+
+	var id []byte
+	var chunked []byte
+	for seq := 0; ; seq++ {
+		chunk := read()
+		require.LessOrEqual(t, len(chunk), 100)
+		require.Equal(t, chunkMagic, chunk[:2])
+		if id == nil {
+			id = chunk[2:10]
+		}
+		assert.Equal(t, id, chunk[2:10])
+		assert.Equal(t, byte(seq), chunk[10])
+		chunked = append(chunked, chunk[chunkHeaderSize:]...)
+		if int(chunk[11]) == seq+1 {
+			break
+		}
+	}
+	assert.Equal(t, string(message), gunzip(chunked)["short_message"])
+}
+
+func TestChunksCount(t *testing.T) {
+	assert.Equal(t, 1, chunksCount(88, 100))
+	assert.Equal(t, 2, chunksCount(89, 100))
+	assert.Equal(t, maxChunks, chunksCount(maxChunks*1408, 1420))
+}
@@ -0,0 +1,373 @@
+// Package grpc is an output plugin that pushes events to the grpc input plugin of the other file.d.
+package grpc
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/ozontech/file.d/cfg"
+	"github.com/ozontech/file.d/fd"
+	"github.com/ozontech/file.d/metric"
+	"github.com/ozontech/file.d/pipeline"
+	"github.com/ozontech/file.d/plugin/input/grpc/logpb"
+	"github.com/ozontech/file.d/xtls"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/backoff"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/protobuf/proto"
+)
+
+/*{ introduction
+It pushes events to the gRPC server by the streaming RPC `filed.input.v1.LogService/Push`
+of the [grpc input plugin](/plugin/input/grpc/README.md), so file.d instances can be chained, e.g. edge → aggregator.
+Each batch is sent by its own stream over the shared connection, each event is the record encoded as JSON.
+
+> It guarantees "at-least-once delivery": the batch is committed after the server commits all its records.
+> The server answers with the number of the committed records, so if the stream fails,
+> only the records which aren't committed are sent again.
+
+The server doesn't read the stream while its `max_in_flight` records aren't committed,
+so the plugin gets the backpressure by HTTP/2 flow control.
+The broken connection is re-established by the gRPC client with the backoff from `retention` to `max_retention`,
+the batches which fail meanwhile are retried by the retry backoff.
+
+**Example**
+```yaml
+pipelines:
+  example_pipeline:
+    ...
+    output:
+      type: grpc
+      address: aggregator:50051
+      tls_enabled: true
+      ca_cert: /etc/file.d/ca.pem
+      client_cert: /etc/file.d/edge.pem
+      client_key: /etc/file.d/edge-key.pem
+    ...
+```
+}*/
+
+const (
+	outPluginType = "grpc"
+)
+
+type Plugin struct {
+	config     *Config
+	logger     *zap.SugaredLogger
+	batcher    *pipeline.Batcher
+	controller pipeline.OutputPluginController
+
+	conn   *grpc.ClientConn
+	client logpb.LogServiceClient
+
+	// plugin metrics
+
+	sendErrorsMetric     *prometheus.CounterVec
+	droppedRecordsMetric *prometheus.CounterVec
+}
+
+// ! config-params
+// ^ config-params
+type Config struct {
+	// > @3@4@5@6
+	// >
+	// > The address of the gRPC server, e.g. `aggregator:50051`.
+	Address string `json:"address" required:"true"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The max size of the record, the larger records are dropped. It should be the same as the server limit.
+	MaxMessageSize  string `json:"max_message_size" default:"4 MB" parse:"data_unit"` // *
+	MaxMessageSize_ uint
+
+	// > @3@4@5@6
+	// >
+	// > If set, the connection is made over TLS, otherwise it's insecure.
+	TLSEnabled bool `json:"tls_enabled" default:"false"` // *
+
+	// > @3@4@5@6
+	// >
+	// > CA certificate in PEM encoding or a path to the file to verify the server certificate.
+	CACert string `json:"ca_cert"` // *
+
+	// > @3@4@5@6
+	// >
+	// > Client certificate in PEM encoding or a path to the file, it's presented to the server for mTLS.
+	ClientCert string `json:"client_cert"` // *
+
+	// > @3@4@5@6
+	// >
+	// > Private key of the client certificate in PEM encoding or a path to the file.
+	ClientKey string `json:"client_key"` // *
+
+	// > @3@4@5@6
+	// >
+	// > If set, the server certificate isn't verified.
+	InsecureSkipVerify bool `json:"insecure_skip_verify" default:"false"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The timeout of the connection.
+	ConnectionTimeout  cfg.Duration `json:"connection_timeout" default:"5s" parse:"duration"` // *
+	ConnectionTimeout_ time.Duration
+
+	// > @3@4@5@6
+	// >
+	// > The timeout of the stream of the batch, it includes the time to wait for the commit of the records.
+	RequestTimeout  cfg.Duration `json:"request_timeout" default:"1m" parse:"duration"` // *
+	RequestTimeout_ time.Duration
+
+	// > @3@4@5@6
+	// >
+	// > How many workers will be instantiated to send batches, each worker has its own stream.
+	WorkersCount  cfg.Expression `json:"workers_count" default:"gomaxprocs*4" parse:"expression"` // *
+	WorkersCount_ int
+
+	// > @3@4@5@6
+	// >
+	// > A maximum quantity of events to pack into one batch.
+	BatchSize  cfg.Expression `json:"batch_size" default:"capacity/4" parse:"expression"` // *
+	BatchSize_ int
+
+	// > @3@4@5@6
+	// >
+	// > A minimum size of events in a batch to send.
+	// > If both batch_size and batch_size_bytes are set, they will work together.
+	BatchSizeBytes  cfg.Expression `json:"batch_size_bytes" default:"0" parse:"expression"` // *
+	BatchSizeBytes_ int
+
+	// > @3@4@5@6
+	// >
+	// > After this timeout the batch will be sent even if batch isn't completed.
+	BatchFlushTimeout  cfg.Duration `json:"batch_flush_timeout" default:"200ms" parse:"duration"` // *
+	BatchFlushTimeout_ time.Duration
+
+	// > @3@4@5@6
+	// >
+	// > Retries of the batch or its records which aren't committed.
+	Retry int `json:"retry" default:"10"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The delay before the first retry, it's doubled after each retry.
+	Retention  cfg.Duration `json:"retention" default:"1s" parse:"duration"` // *
+	Retention_ time.Duration
+
+	// > @3@4@5@6
+	// >
+	// > The max delay between the retries.
+	MaxRetention  cfg.Duration `json:"max_retention" default:"1m" parse:"duration"` // *
+	MaxRetention_ time.Duration
+
+	// > @3@4@5@6
+	// >
+	// > Exit with the non-zero code if the records aren't committed after all the retries, otherwise they are dropped.
+	FatalOnFailedInsert bool `json:"fatal_on_failed_insert" default:"false"` // *
+}
+
+type data struct {
+	records []*logpb.LogRecord
+	events  []*pipeline.Event
+}
+
+func init() {
+	fd.DefaultPluginRegistry.RegisterOutput(&pipeline.PluginStaticInfo{
+		Type:    outPluginType,
+		Factory: Factory,
+	})
+}
+
+func Factory() (pipeline.AnyPlugin, pipeline.AnyConfig) {
+	return &Plugin{}, &Config{}
+}
+
+func (p *Plugin) Start(config pipeline.AnyConfig, params *pipeline.OutputPluginParams) {
+	p.controller = params.Controller
+	p.logger = params.Logger
+	p.config = config.(*Config)
+	p.registerMetrics(params.MetricCtl)
+
+	conn, err := p.newConn()
+	if err != nil {
+		p.logger.Fatalf("can't create grpc client: %s", err.Error())
+	}
+	p.conn = conn
+	p.client = logpb.NewLogServiceClient(conn)
+
+	p.batcher = pipeline.NewBatcher(pipeline.BatcherOptions{
+		PipelineName:   params.PipelineName,
+		OutputType:     outPluginType,
+		OutFn:          p.out,
+		Controller:     p.controller,
+		Workers:        p.config.WorkersCount_,
+		BatchSizeCount: p.config.BatchSize_,
+		BatchSizeBytes: p.config.BatchSizeBytes_,
+		FlushTimeout:   p.config.BatchFlushTimeout_,
+		MaxEventAge:    params.PipelineSettings.MaxEventAge,
+		CircuitBreaker: params.PipelineSettings.CircuitBreaker,
+		Health:         params.Health,
+		ErrorReporter:  params.ErrorReporter,
+		DeadLetter:     params.DeadLetter,
+		MetricCtl:      params.MetricCtl,
+		Retry: pipeline.BatcherRetry{
+			MaxRetries:        p.config.Retry,
+			InitialBackoff:    p.config.Retention_,
+			BackoffMultiplier: 2,
+			MaxBackoff:        p.config.MaxRetention_,
+		},
+		FatalOnFailedInsert: p.config.FatalOnFailedInsert,
+	})
+
+	p.batcher.Start(context.TODO())
+}
+
+func (p *Plugin) newConn() (*grpc.ClientConn, error) {
+	options := []grpc.DialOption{
+		grpc.WithConnectParams(grpc.ConnectParams{
+			Backoff: backoff.Config{
+				BaseDelay:  p.config.Retention_,
+				Multiplier: 2,
+				Jitter:     0.2,
+				MaxDelay:   p.config.MaxRetention_,
+			},
+			MinConnectTimeout: p.config.ConnectionTimeout_,
+		}),
+		// the dead connection is detected by the pings, so the next stream is sent by the new one
+		grpc.WithKeepaliveParams(keepalive.ClientParameters{
+			Time:    30 * time.Second,
+			Timeout: 15 * time.Second,
+		}),
+		grpc.WithDefaultCallOptions(grpc.MaxCallSendMsgSize(int(p.config.MaxMessageSize_))),
+	}
+
+	if !p.config.TLSEnabled {
+		if p.config.CACert != "" || p.config.ClientCert != "" {
+			return nil, fmt.Errorf("ca_cert and client_cert require tls_enabled")
+		}
+		options = append(options, grpc.WithTransportCredentials(insecure.NewCredentials()))
+		return grpc.NewClient(p.config.Address, options...)
+	}
+
+	b := xtls.NewConfigBuilder()
+	if p.config.CACert != "" {
+		if err := b.AppendCARoot(p.config.CACert); err != nil {
+			return nil, fmt.Errorf("can't append CA root: %w", err)
+		}
+	}
+	if p.config.ClientCert != "" || p.config.ClientKey != "" {
+		if err := b.AppendX509KeyPair(p.config.ClientCert, p.config.ClientKey); err != nil {
+			return nil, fmt.Errorf("can't append client certificate: %w", err)
+		}
+	}
+	tlsConfig := b.Build()
+	tlsConfig.InsecureSkipVerify = p.config.InsecureSkipVerify
+
+	options = append(options, grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)))
+	return grpc.NewClient(p.config.Address, options...)
+}
+
+func (p *Plugin) registerMetrics(ctl *metric.Ctl) {
+	p.sendErrorsMetric = ctl.RegisterCounter("output_grpc_send_errors_total", "Number of gRPC streams which are failed")
+	p.droppedRecordsMetric = ctl.RegisterCounter("output_grpc_dropped_records_total", "Number of records larger than max_message_size which are dropped")
+}
+
+func (p *Plugin) Stop() {
+	p.batcher.Stop()
+	_ = p.conn.Close()
+}
+
+func (p *Plugin) Out(event *pipeline.Event) {
+	p.batcher.Add(event)
+}
+
+// out pushes the batch by the stream. If the stream fails and nothing is committed, the error is returned,
+// so the whole batch is retried, otherwise the events of the records which aren't committed are marked as failed.
+func (p *Plugin) out(workerData *pipeline.WorkerData, batch *pipeline.Batch) error {
+	if *workerData == nil {
+		*workerData = &data{}
+	}
+
+	data := (*workerData).(*data)
+	data.events = data.events[:0]
+	batch.ForEach(func(event *pipeline.Event) bool {
+		// the records are reused to keep the payload buffers
+		if len(data.events) == len(data.records) {
+			data.records = append(data.records, &logpb.LogRecord{})
+		}
+		record := data.records[len(data.events)]
+		record.Payload = event.Root.Encode(record.Payload[:0])
+
+		if size := proto.Size(record); uint(size) > p.config.MaxMessageSize_ {
+			p.droppedRecordsMetric.WithLabelValues().Inc()
+			batch.MarkRejected(event, fmt.Sprintf("record of %d bytes is larger than max_message_size", size))
+			return true
+		}
+		data.events = append(data.events, event)
+		return true
+	})
+
+	if len(data.events) == 0 {
+		return nil
+	}
+
+	committed, err := p.push(data.records[:len(data.events)])
+	if err == nil {
+		return nil
+	}
+
+	p.sendErrorsMetric.WithLabelValues().Inc()
+	p.logger.Errorf("can't push records to grpc server address=%s: %s", p.config.Address, err.Error())
+	if committed == 0 {
+		return err
+	}
+	for _, event := range data.events[committed:] {
+		batch.MarkFailed(event)
+	}
+
+	return nil
+}
+
+// push sends the records by the stream and returns the number of the records committed by the server
+func (p *Plugin) push(records []*logpb.LogRecord) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), p.config.RequestTimeout_)
+	defer cancel()
+
+	stream, err := p.client.Push(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	// the server stops reading the stream while its records aren't committed,
+	// so Send blocks, the responses are buffered meanwhile since they are small
+	for _, record := range records {
+		// the stream is broken, its status is returned by Recv
+		if err := stream.Send(record); err != nil {
+			break
+		}
+	}
+	_ = stream.CloseSend()
+
+	committed := uint64(0)
+	for {
+		resp, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return int(min(committed, uint64(len(records)))), err
+		}
+		committed = max(committed, resp.Committed)
+	}
+
+	n := int(min(committed, uint64(len(records))))
+	if n < len(records) {
+		return n, fmt.Errorf("server has committed %d of %d records", n, len(records))
+	}
+	return n, nil
+}
@@ -0,0 +1,176 @@
+package grpc
+
+import (
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ozontech/file.d/decoder"
+	"github.com/ozontech/file.d/pipeline"
+	ingrpc "github.com/ozontech/file.d/plugin/input/grpc"
+	"github.com/ozontech/file.d/plugin/input/grpc/logpb"
+	"github.com/ozontech/file.d/test"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	insaneJSON "github.com/vitkovskii/insane-json"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// inputController commits the events of the grpc input plugin right after they are received
+type inputController struct {
+	mu     sync.Mutex
+	input  *ingrpc.Plugin
+	events []string
+}
+
+func (c *inputController) In(_ pipeline.SourceID, _ string, offset int64, data []byte, _ bool) uint64 {
+	c.mu.Lock()
+	c.events = append(c.events, string(data))
+	n := len(c.events)
+	c.mu.Unlock()
+
+	go c.input.Commit(&pipeline.Event{Offset: offset})
+	return uint64(n)
+}
+
+func (c *inputController) received() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.events...)
+}
+
+func (c *inputController) UseSpread()                               {}
+func (c *inputController) DisableStreams()                          {}
+func (c *inputController) SuggestDecoder(_ decoder.DecoderType)     {}
+func (c *inputController) IncReadOps()                              {}
+func (c *inputController) IncMaxEventSizeExceeded()                 {}
+func (c *inputController) IsSourceLimited(_ pipeline.SourceID) bool { return false }
+
+func freeAddress(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	address := l.Addr().String()
+	require.NoError(t, l.Close())
+	return address
+}
+
+type controller struct {
+	mu      sync.Mutex
+	commits []string
+}
+
+func (c *controller) Commit(event *pipeline.Event) {
+	c.mu.Lock()
+	c.commits = append(c.commits, event.Root.EncodeToString())
+	c.mu.Unlock()
+}
+
+func (c *controller) Error(err string) {
+	panic(err)
+}
+
+// TestChain pushes the events to the grpc input plugin
+func TestChain(t *testing.T) {
+	address := freeAddress(t)
+
+	inputCtl := &inputController{input: &ingrpc.Plugin{}}
+	inputParams := test.NewEmptyInputPluginParams(inputCtl)
+	inputCtl.input.Start(test.NewConfig(&ingrpc.Config{Address: address, MaxInFlight: 2}, nil), inputParams)
+	t.Cleanup(inputCtl.input.Stop)
+
+	ctl := &controller{}
+	params := test.NewEmptyOutputPluginParams()
+	params.Controller = ctl
+	p := &Plugin{}
+	test.StartOutputPlugin(t, p, &Config{
+		Address:           address,
+		BatchSize:         "5",
+		BatchFlushTimeout: "10ms",
+	}, params)
+
+	events := make([]string, 0, 10)
+	for i := 0; i < 10; i++ {
+		events = append(events, `{"message":"event `+strconv.Itoa(i)+`"}`)
+	}
+	for _, e := range events {
+		root, err := insaneJSON.DecodeString(e)
+		require.NoError(t, err)
+		p.Out(&pipeline.Event{Root: root})
+	}
+
+	require.Eventually(t, func() bool {
+		ctl.mu.Lock()
+		defer ctl.mu.Unlock()
+		return len(ctl.commits) == len(events)
+	}, 5*time.Second, 10*time.Millisecond)
+
+	assert.ElementsMatch(t, events, inputCtl.received())
+}
+
+// fakeServer commits the records up to the committed and finishes the stream with the status
+type fakeServer struct {
+	logpb.UnimplementedLogServiceServer
+
+	mu        sync.Mutex
+	committed uint64
+	status    codes.Code
+	records   []string
+}
+
+func (s *fakeServer) Push(srv logpb.LogService_PushServer) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for {
+		record, err := srv.Recv()
+		if err != nil {
+			break
+		}
+		s.records = append(s.records, string(record.Payload))
+	}
+
+	if err := srv.Send(&logpb.PushResponse{Committed: s.committed}); err != nil {
+		return err
+	}
+	return status.Error(s.status, "pipeline is stopped")
+}
+
+func TestOutPartialCommit(t *testing.T) {
+	fake := &fakeServer{committed: 1, status: codes.Unavailable}
+	server := grpc.NewServer()
+	logpb.RegisterLogServiceServer(server, fake)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	t.Cleanup(server.Stop)
+
+	p := &Plugin{}
+	test.StartOutputPlugin(t, p, &Config{
+		Address:        listener.Addr().String(),
+		MaxMessageSize: "30 B",
+	}, test.NewEmptyOutputPluginParams())
+
+	workerData := pipeline.WorkerData(nil)
+	batch := test.NewBatch(t, `{"a":1}`, `{"b":2}`, `{"message":"too large for the limit"}`, `{"c":3}`)
+	require.NoError(t, p.out(&workerData, batch))
+
+	// nothing is committed, so the whole batch is retried
+	fake.mu.Lock()
+	fake.committed = 0
+	fake.mu.Unlock()
+	assert.Error(t, p.out(&workerData, batch))
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+
+	assert.Equal(t, []string{`{"a":1}`, `{"b":2}`, `{"c":3}`, `{"a":1}`, `{"b":2}`, `{"c":3}`}, fake.records)
+	assert.Equal(t, float64(2), testutil.ToFloat64(p.droppedRecordsMetric.WithLabelValues()))
+	assert.Equal(t, float64(2), testutil.ToFloat64(p.sendErrorsMetric.WithLabelValues()))
+}
@@ -0,0 +1,497 @@
+// Package http is an output plugin that sends events to an arbitrary HTTP endpoint.
+package http
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ozontech/file.d/cfg"
+	"github.com/ozontech/file.d/fd"
+	"github.com/ozontech/file.d/metric"
+	"github.com/ozontech/file.d/pipeline"
+	"github.com/ozontech/file.d/xtls"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+/*{ introduction
+It sends events to an arbitrary HTTP endpoint, e.g. a webhook. The body of the request is:
+* `ndjson` – the events of the batch separated by the new line
+* `json_array` – the JSON array of the events of the batch
+* `template` – the `body_template` rendered for each event, each event is sent by its own request
+
+The batch or the event is retried if the request fails or the endpoint responds with `408`, `429` or `5xx` status,
+the `Retry-After` header of the response is honored, but the delay isn't longer than `max_retention`.
+The events are committed only after the endpoint responds with `2xx` status or the retries are exhausted.
+Other statuses are logged and the batch or the event is dropped.
+
+**Example:**
+```yaml
+pipelines:
+  example_pipeline:
+    ...
+    output:
+      type: http
+      endpoint: https://hooks.example.com/alerts
+      format: template
+      body_template: '{"text":"${service}: ${message}","level":"${level}"}'
+      bearer_token: secret
+    ...
+```
+The event `{"service":"api","message":"disk is \"full\"","level":"error"}` is sent with the body
+`{"text":"api: disk is \"full\"","level":"error"}`.
+}*/
+
+const (
+	outPluginType = "http"
+)
+
+const (
+	formatNDJSON = iota
+	formatJSONArray
+	formatTemplate
+)
+
+const (
+	escapeJSON = iota
+	escapeNone
+)
+
+type Plugin struct {
+	config       *Config
+	client       *http.Client
+	logger       *zap.SugaredLogger
+	avgEventSize int
+	batcher      *pipeline.Batcher
+	controller   pipeline.OutputPluginController
+
+	template    []cfg.SubstitutionOp
+	escape      cfg.SubstitutionEscape
+	contentType string
+	authHeader  string
+	authValue   string
+
+	// plugin metrics
+
+	responsesMetric     *prometheus.CounterVec
+	retriesMetric       *prometheus.CounterVec
+	droppedEventsMetric *prometheus.CounterVec
+}
+
+// ! config-params
+// ^ config-params
+type Config struct {
+	// > @3@4@5@6
+	// >
+	// > A full URI of the endpoint. Format: `https://hooks.example.com/alerts`.
+	Endpoint string `json:"endpoint" required:"true"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The method of the requests.
+	Method string `json:"method" default:"POST" options:"POST|PUT|PATCH"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The headers which are added to the requests.
+	Headers map[string]string `json:"headers"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The body of the requests.
+	Format  string `json:"format" default:"ndjson" options:"ndjson|json_array|template"` // *
+	Format_ int
+
+	// > @3@4@5@6
+	// >
+	// > The body of the `template` format, the `${field}` parts are replaced by the values of the event fields,
+	// > e.g. `{"text":"${message}"}`. The missing fields are empty, the objects, the arrays, the numbers
+	// > and the booleans are inserted as JSON.
+	BodyTemplate string `json:"body_template"` // *
+
+	// > @3@4@5@6
+	// >
+	// > How to escape the string values of the template:
+	// > * `json` – escape the quotes, the backslashes and the control characters, so the value can be put into the JSON string
+	// > * `none` – insert as is
+	TemplateEscape  string `json:"template_escape" default:"json" options:"json|none"` // *
+	TemplateEscape_ int
+
+	// > @3@4@5@6
+	// >
+	// > The `Content-Type` header of the requests. If it's empty, it's `application/x-ndjson` for the `ndjson` format,
+	// > `application/json` for the `json_array` format and the `template` format with the `json` escaping,
+	// > and `text/plain` otherwise.
+	ContentType string `json:"content_type"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The compression of the body.
+	Compression string `json:"compression" default:"none" options:"none|gzip|zstd"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The username of the basic auth.
+	Username string `json:"username"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The password of the basic auth.
+	Password string `json:"password"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The bearer token. If it's set, the basic auth isn't used.
+	BearerToken string `json:"bearer_token"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The header to send `bearer_token` in as is, e.g. `X-API-Key`. If it's empty,
+	// > the token is sent in the `Authorization` header with the `Bearer` prefix.
+	AuthHeader string `json:"auth_header"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The CA certificate to verify the endpoint, it's a PEM content or a path to the file.
+	CACert string `json:"ca_cert"` // *
+
+	// > @3@4@5@6
+	// >
+	// > Skip the verification of the endpoint certificate.
+	InsecureSkipVerify bool `json:"insecure_skip_verify" default:"false"` // *
+
+	// > @3@4@5@6
+	// >
+	// > How many workers will be instantiated to send batches.
+	WorkersCount  cfg.Expression `json:"workers_count" default:"gomaxprocs*4" parse:"expression"` // *
+	WorkersCount_ int
+
+	// > @3@4@5@6
+	// >
+	// > Client timeout when sends requests to the endpoint.
+	RequestTimeout  cfg.Duration `json:"request_timeout" default:"10s" parse:"duration"` // *
+	RequestTimeout_ time.Duration
+
+	// > @3@4@5@6
+	// >
+	// > A maximum quantity of events to pack into one batch.
+	BatchSize  cfg.Expression `json:"batch_size" default:"capacity/4" parse:"expression"` // *
+	BatchSize_ int
+
+	// > @3@4@5@6
+	// >
+	// > A minimum size of events in a batch to send.
+	// > If both batch_size and batch_size_bytes are set, they will work together.
+	BatchSizeBytes  cfg.Expression `json:"batch_size_bytes" default:"0" parse:"expression"` // *
+	BatchSizeBytes_ int
+
+	// > @3@4@5@6
+	// >
+	// > After this timeout the batch will be sent even if batch isn't completed.
+	BatchFlushTimeout  cfg.Duration `json:"batch_flush_timeout" default:"200ms" parse:"duration"` // *
+	BatchFlushTimeout_ time.Duration
+
+	// > @3@4@5@6
+	// >
+	// > Retries of the batch sending.
+	Retry int `json:"retry" default:"10"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The delay before the first retry, it's doubled after each retry.
+	Retention  cfg.Duration `json:"retention" default:"1s" parse:"duration"` // *
+	Retention_ time.Duration
+
+	// > @3@4@5@6
+	// >
+	// > The max delay between the retries, including the one of the `Retry-After` header.
+	MaxRetention  cfg.Duration `json:"max_retention" default:"1m" parse:"duration"` // *
+	MaxRetention_ time.Duration
+
+	// > @3@4@5@6
+	// >
+	// > Exit with the non-zero code if the batch isn't sent after all the retries, otherwise the batch is dropped.
+	FatalOnFailedInsert bool `json:"fatal_on_failed_insert" default:"false"` // *
+}
+
+type data struct {
+	outBuf []byte
+}
+
+func init() {
+	fd.DefaultPluginRegistry.RegisterOutput(&pipeline.PluginStaticInfo{
+		Type:    outPluginType,
+		Factory: Factory,
+	})
+}
+
+func Factory() (pipeline.AnyPlugin, pipeline.AnyConfig) {
+	return &Plugin{}, &Config{}
+}
+
+func (p *Plugin) Start(config pipeline.AnyConfig, params *pipeline.OutputPluginParams) {
+	p.controller = params.Controller
+	p.logger = params.Logger
+	p.avgEventSize = params.PipelineSettings.AvgEventSize
+	p.config = config.(*Config)
+	p.registerMetrics(params.MetricCtl)
+
+	if err := p.prepare(); err != nil {
+		p.logger.Fatal(err.Error())
+	}
+
+	client, err := xtls.NewHTTPClient(p.config.CACert, p.config.InsecureSkipVerify, p.config.RequestTimeout_)
+	if err != nil {
+		p.logger.Fatalf("can't create client: %s", err.Error())
+	}
+	p.client = client
+
+	p.batcher = pipeline.NewBatcher(pipeline.BatcherOptions{
+		PipelineName:   params.PipelineName,
+		OutputType:     outPluginType,
+		OutFn:          p.out,
+		Controller:     p.controller,
+		Workers:        p.config.WorkersCount_,
+		BatchSizeCount: p.config.BatchSize_,
+		BatchSizeBytes: p.config.BatchSizeBytes_,
+		FlushTimeout:   p.config.BatchFlushTimeout_,
+		MaxEventAge:    params.PipelineSettings.MaxEventAge,
+		CircuitBreaker: params.PipelineSettings.CircuitBreaker,
+		Health:         params.Health,
+		ErrorReporter:  params.ErrorReporter,
+		DeadLetter:     params.DeadLetter,
+		MetricCtl:      params.MetricCtl,
+		Compression:    pipeline.BatchCompression(p.config.Compression),
+		Retry: pipeline.BatcherRetry{
+			MaxRetries:        p.config.Retry,
+			InitialBackoff:    p.config.Retention_,
+			BackoffMultiplier: 2,
+			MaxBackoff:        p.config.MaxRetention_,
+		},
+		FatalOnFailedInsert: p.config.FatalOnFailedInsert,
+	})
+
+	p.batcher.Start(context.TODO())
+}
+
+// prepare parses the template and builds the values which are the same for all the requests
+func (p *Plugin) prepare() error {
+	if p.config.Format_ == formatTemplate {
+		if p.config.BodyTemplate == "" {
+			return fmt.Errorf("body_template should be set for the template format")
+		}
+		template, err := cfg.ParseSubstitution(p.config.BodyTemplate)
+		if err != nil {
+			return fmt.Errorf("wrong body template %q: %w", p.config.BodyTemplate, err)
+		}
+		p.template = template
+		if p.config.TemplateEscape_ == escapeJSON {
+			p.escape = cfg.SubstitutionEscapeStrings
+		}
+	}
+
+	p.contentType = p.config.ContentType
+	if p.contentType == "" {
+		switch {
+		case p.config.Format_ == formatNDJSON:
+			p.contentType = "application/x-ndjson"
+		case p.config.Format_ == formatJSONArray || p.config.TemplateEscape_ == escapeJSON:
+			p.contentType = "application/json"
+		default:
+			p.contentType = "text/plain"
+		}
+	}
+
+	switch {
+	case p.config.BearerToken != "" && p.config.AuthHeader != "":
+		p.authHeader = p.config.AuthHeader
+		p.authValue = p.config.BearerToken
+	case p.config.BearerToken != "":
+		p.authHeader = "Authorization"
+		p.authValue = "Bearer " + p.config.BearerToken
+	case p.config.Username != "":
+		credentials := p.config.Username + ":" + p.config.Password
+		p.authHeader = "Authorization"
+		p.authValue = "Basic " + base64.StdEncoding.EncodeToString([]byte(credentials))
+	}
+
+	return nil
+}
+
+func (p *Plugin) registerMetrics(ctl *metric.Ctl) {
+	p.responsesMetric = ctl.RegisterCounter("output_http_responses_total",
+		"Number of responses by the status code, the failed requests have the error code",
+		"code",
+	)
+	p.retriesMetric = ctl.RegisterCounter("output_http_retries_total", "Number of failed requests which are retried")
+	p.droppedEventsMetric = ctl.RegisterCounter("output_http_dropped_events_total", "Number of events rejected by the endpoint")
+}
+
+func (p *Plugin) Stop() {
+	p.batcher.Stop()
+}
+
+func (p *Plugin) Out(event *pipeline.Event) {
+	p.batcher.Add(event)
+}
+
+func (p *Plugin) out(workerData *pipeline.WorkerData, batch *pipeline.Batch) error {
+	if *workerData == nil {
+		*workerData = &data{
+			outBuf: make([]byte, 0, p.config.BatchSize_*p.avgEventSize),
+		}
+	}
+
+	data := (*workerData).(*data)
+	// handle too much memory consumption
+	if cap(data.outBuf) > p.config.BatchSize_*p.avgEventSize {
+		data.outBuf = make([]byte, 0, p.config.BatchSize_*p.avgEventSize)
+	}
+
+	if p.config.Format_ == formatTemplate {
+		p.outEvents(data, batch)
+		return nil
+	}
+
+	data.outBuf = p.encodeBatch(data.outBuf[:0], batch)
+	retry, err := p.sendBody(data.outBuf, batch)
+	if err == nil {
+		return nil
+	}
+	if !retry {
+		p.droppedEventsMetric.WithLabelValues().Add(float64(batch.Len()))
+		reason := fmt.Sprintf("batch is rejected by http endpoint address=%s: %s", p.config.Endpoint, err.Error())
+		batch.ForEach(func(event *pipeline.Event) bool {
+			batch.MarkRejected(event, reason)
+			return true
+		})
+		return nil
+	}
+	p.retriesMetric.WithLabelValues().Inc()
+	p.logger.Errorf("can't send data to http endpoint address=%s: %s", p.config.Endpoint, err.Error())
+
+	return err
+}
+
+// outEvents sends each event by its own request, the event which isn't sent and the rest of the batch
+// are marked as failed, so the sent events aren't sent again on the retry
+func (p *Plugin) outEvents(data *data, batch *pipeline.Batch) {
+	failed := false
+	batch.ForEach(func(event *pipeline.Event) bool {
+		if failed {
+			batch.MarkFailed(event)
+			return true
+		}
+
+		data.outBuf, _ = cfg.AppendSubstitution(data.outBuf[:0], p.template, event.Root, p.escape)
+		retry, err := p.sendBody(data.outBuf, batch)
+		if err == nil {
+			return true
+		}
+		if !retry {
+			p.droppedEventsMetric.WithLabelValues().Inc()
+			batch.MarkRejected(event, fmt.Sprintf("event is rejected by http endpoint address=%s: %s", p.config.Endpoint, err.Error()))
+			return true
+		}
+
+		p.retriesMetric.WithLabelValues().Inc()
+		p.logger.Errorf("can't send data to http endpoint address=%s: %s", p.config.Endpoint, err.Error())
+		failed = true
+		batch.MarkFailed(event)
+		return true
+	})
+}
+
+func (p *Plugin) encodeBatch(out []byte, batch *pipeline.Batch) []byte {
+	if p.config.Format_ == formatJSONArray {
+		out = append(out, '[')
+	}
+	first := true
+	batch.ForEach(func(event *pipeline.Event) bool {
+		if p.config.Format_ == formatJSONArray && !first {
+			out = append(out, ',')
+		}
+		first = false
+
+		out = event.Root.Encode(out)
+		if p.config.Format_ == formatNDJSON {
+			out = append(out, '\n')
+		}
+		return true
+	})
+	if p.config.Format_ == formatJSONArray {
+		out = append(out, ']')
+	}
+	return out
+}
+
+// sendBody compresses the body and sends it, it returns whether the request should be retried if it fails
+func (p *Plugin) sendBody(body []byte, batch *pipeline.Batch) (bool, error) {
+	body, err := batch.Compress(body)
+	if err != nil {
+		return false, fmt.Errorf("can't compress body: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(context.Background(), p.config.Method, p.config.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return false, fmt.Errorf("can't create request: %w", err)
+	}
+
+	for key, value := range p.config.Headers {
+		req.Header.Set(key, value)
+	}
+	req.Header.Set("Content-Type", p.contentType)
+	if compression := batch.Compression(); compression != pipeline.BatchCompressionNone {
+		req.Header.Set("Content-Encoding", string(compression))
+	}
+	if p.authHeader != "" {
+		req.Header.Set(p.authHeader, p.authValue)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		p.responsesMetric.WithLabelValues("error").Inc()
+		return true, fmt.Errorf("can't send request: %w", err)
+	}
+	defer func(Body io.ReadCloser) {
+		_ = Body.Close()
+	}(resp.Body)
+
+	p.responsesMetric.WithLabelValues(strconv.Itoa(resp.StatusCode)).Inc()
+	if resp.StatusCode >= http.StatusOK && resp.StatusCode < http.StatusMultipleChoices {
+		// the body is read to reuse the connection
+		_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 1024))
+		return false, nil
+	}
+
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	retry := resp.StatusCode == http.StatusRequestTimeout || resp.StatusCode == http.StatusTooManyRequests ||
+		resp.StatusCode >= http.StatusInternalServerError
+	if retry {
+		if delay, ok := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()); ok {
+			batch.DelayRetry(min(delay, p.config.MaxRetention_))
+		}
+	}
+
+	return retry, fmt.Errorf("response status is %s: %s", resp.Status, bytes.TrimSpace(b))
+}
+
+// parseRetryAfter returns the delay of the Retry-After header, it's the number of seconds or the HTTP date
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(max(seconds, 0)) * time.Second, true
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return max(t.Sub(now), 0), true
+	}
+	return 0, false
+}
@@ -0,0 +1,181 @@
+package http
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ozontech/file.d/pipeline"
+	"github.com/ozontech/file.d/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type request struct {
+	method string
+	header http.Header
+	body   string
+}
+
+// startPlugin starts the plugin and the server which responds with the statuses in order, then with 200
+func startPlugin(t *testing.T, config *Config, statuses ...int) (*Plugin, chan request) {
+	requests := make(chan request, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		requests <- request{method: r.Method, header: r.Header, body: string(body)}
+
+		status := http.StatusOK
+		if len(statuses) > 0 {
+			status, statuses = statuses[0], statuses[1:]
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+
+	config.Endpoint = server.URL
+	p := &Plugin{}
+	test.StartOutputPlugin(t, p, config, nil)
+
+	return p, requests
+}
+
+func TestHTTPBatch(t *testing.T) {
+	cases := []struct {
+		name        string
+		config      *Config
+		wantMethod  string
+		wantType    string
+		wantAuth    [2]string
+		wantBody    string
+		wantHeaders map[string]string
+	}{
+		{
+			name:       "ndjson",
+			config:     &Config{Headers: map[string]string{"X-Source": "file.d"}},
+			wantMethod: http.MethodPost,
+			wantType:   "application/x-ndjson",
+			wantBody:   "{\"a\":1}\n{\"b\":\"c\"}\n",
+			wantHeaders: map[string]string{
+				"X-Source": "file.d",
+			},
+		},
+		{
+			name:       "json_array",
+			config:     &Config{Format: "json_array", Method: "PUT", Username: "user", Password: "pass"},
+			wantMethod: http.MethodPut,
+			wantType:   "application/json",
+			wantAuth:   [2]string{"Authorization", "Basic dXNlcjpwYXNz"},
+			wantBody:   `[{"a":1},{"b":"c"}]`,
+		},
+		{
+			name:       "content_type",
+			config:     &Config{Format: "json_array", ContentType: "application/vnd.api+json", BearerToken: "token"},
+			wantMethod: http.MethodPost,
+			wantType:   "application/vnd.api+json",
+			wantAuth:   [2]string{"Authorization", "Bearer token"},
+			wantBody:   `[{"a":1},{"b":"c"}]`,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			p, requests := startPlugin(t, tt.config)
+
+			data := pipeline.WorkerData(nil)
+			require.NoError(t, p.out(&data, test.NewBatch(t, `{"a":1}`, `{"b":"c"}`)))
+
+			req := <-requests
+			assert.Equal(t, tt.wantMethod, req.method)
+			assert.Equal(t, tt.wantType, req.header.Get("Content-Type"))
+			assert.Equal(t, tt.wantBody, req.body)
+			if tt.wantAuth[0] != "" {
+				assert.Equal(t, tt.wantAuth[1], req.header.Get(tt.wantAuth[0]))
+			}
+			for k, v := range tt.wantHeaders {
+				assert.Equal(t, v, req.header.Get(k))
+			}
+		})
+	}
+}
+
+func TestHTTPTemplate(t *testing.T) {
+	p, requests := startPlugin(t, &Config{
+		Format:       "template",
+		BodyTemplate: `{"text":"${service}: ${message}","count":${count},"tags":${tags}}`,
+		BearerToken:  "secret",
+		AuthHeader:   "X-API-Key",
+	}, http.StatusOK, http.StatusServiceUnavailable)
+
+	batch := test.NewBatch(t,
+		`{"service":"api","message":"disk is \"full\"\n","count":2,"tags":["a"]}`,
+		`{"service":"db","message":"timeout","count":1,"tags":[]}`,
+		`{"service":"db","message":"not sent","count":1,"tags":[]}`,
+	)
+
+	data := pipeline.WorkerData(nil)
+	require.NoError(t, p.out(&data, batch))
+
+	req := <-requests
+	assert.Equal(t, "application/json", req.header.Get("Content-Type"))
+	assert.Equal(t, "secret", req.header.Get("X-API-Key"))
+	assert.Equal(t, `{"text":"api: disk is \"full\"\n","count":2,"tags":["a"]}`, req.body)
+
+	req = <-requests
+	assert.Equal(t, `{"text":"db: timeout","count":1,"tags":[]}`, req.body)
+
+	// the rest of the batch isn't sent after the failed event
+	assert.Empty(t, requests)
+}
+
+func TestHTTPErrors(t *testing.T) {
+	cases := []struct {
+		name    string
+		status  int
+		wantErr bool
+	}{
+		{name: "unavailable", status: http.StatusServiceUnavailable, wantErr: true},
+		{name: "too_many_requests", status: http.StatusTooManyRequests, wantErr: true},
+		{name: "request_timeout", status: http.StatusRequestTimeout, wantErr: true},
+		{name: "bad_request", status: http.StatusBadRequest, wantErr: false},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			p, requests := startPlugin(t, &Config{}, tt.status)
+
+			data := pipeline.WorkerData(nil)
+			err := p.out(&data, test.NewBatch(t, `{"message":"test"}`))
+			<-requests
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2023, 11, 14, 22, 13, 20, 0, time.UTC)
+	cases := []struct {
+		value  string
+		want   time.Duration
+		wantOK bool
+	}{
+		{value: "", wantOK: false},
+		{value: "5", want: 5 * time.Second, wantOK: true},
+		{value: "-1", want: 0, wantOK: true},
+		{value: "Tue, 14 Nov 2023 22:13:50 GMT", want: 30 * time.Second, wantOK: true},
+		{value: "Tue, 14 Nov 2023 22:13:10 GMT", want: 0, wantOK: true},
+		{value: "soon", wantOK: false},
+	}
+
+	for _, tt := range cases {
+		got, ok := parseRetryAfter(tt.value, now)
+		assert.Equal(t, tt.wantOK, ok, tt.value)
+		assert.Equal(t, tt.want, got, tt.value)
+	}
+}
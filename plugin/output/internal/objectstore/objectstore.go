@@ -0,0 +1,137 @@
+// Package objectstore groups the events of the batches into the NDJSON objects of the object storage outputs.
+package objectstore
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ozontech/file.d/pipeline"
+)
+
+// Template renders the paths of the objects by the fields and the time of the events
+type Template struct {
+	parts      []pathPart
+	timeField  []string
+	timeFormat string
+}
+
+// NewTemplate parses the path template, the time of the path is taken from the time field
+// if it's set and it's parsed by the time format, otherwise it's the time of the grouping
+func NewTemplate(pathTemplate string, timeField []string, timeFormat string) (*Template, error) {
+	parts, err := parsePathTemplate(strings.Trim(pathTemplate, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("wrong path template %q: %w", pathTemplate, err)
+	}
+
+	format, err := pipeline.ParseFormatName(timeFormat)
+	if err != nil {
+		format = timeFormat
+	}
+
+	return &Template{parts: parts, timeField: timeField, timeFormat: format}, nil
+}
+
+func (t *Template) eventTime(event *pipeline.Event, now time.Time) time.Time {
+	if len(t.timeField) == 0 {
+		return now
+	}
+	node := event.Root.Dig(t.timeField...)
+	if node == nil {
+		return now
+	}
+	eventTime, err := pipeline.ParseTime(t.timeFormat, node.AsString())
+	if err != nil {
+		return now
+	}
+	return eventTime
+}
+
+// Object contains the events of the batch with the same path and their NDJSON body
+type Object struct {
+	Path   string
+	Events []*pipeline.Event
+	Body   []byte
+}
+
+// Objects groups the events of the batches into the objects, the objects are reused by the next batches,
+// so it should be owned by one worker
+type Objects struct {
+	template *Template
+	pathBuf  []byte
+	byPath   map[string]*Object
+	// order keeps the order of the objects
+	order []*Object
+	rnd   *rand.Rand
+}
+
+func NewObjects(template *Template) *Objects {
+	return &Objects{
+		template: template,
+		byPath:   make(map[string]*Object),
+		order:    make([]*Object, 0),
+		rnd:      rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// Group encodes the events of the batch into the objects by their path,
+// the objects are valid until the next call
+func (o *Objects) Group(batch *pipeline.Batch) []*Object {
+	clear(o.byPath)
+	order := o.order[:0]
+	now := time.Now()
+
+	batch.ForEach(func(event *pipeline.Event) bool {
+		o.pathBuf = appendPath(o.pathBuf[:0], o.template.parts, event.Root, o.template.eventTime(event, now))
+		object, has := o.byPath[string(o.pathBuf)]
+		if !has {
+			if len(order) < cap(order) {
+				order = order[:len(order)+1]
+				object = order[len(order)-1]
+			} else {
+				object = &Object{}
+				order = append(order, object)
+			}
+			object.Path = string(o.pathBuf)
+			object.Events = object.Events[:0]
+			object.Body = object.Body[:0]
+			o.byPath[object.Path] = object
+		}
+
+		object.Events = append(object.Events, event)
+		object.Body = event.Root.Encode(object.Body)
+		object.Body = append(object.Body, '\n')
+		return true
+	})
+	o.order = order
+
+	return order
+}
+
+// Release drops the events of the objects, since the objects are reused,
+// they shouldn't keep the events of the committed batch
+func (o *Objects) Release() {
+	for _, object := range o.order {
+		clear(object.Events)
+	}
+}
+
+// Format returns the extension and the content type of the NDJSON objects compressed by the codec
+func Format(compression pipeline.BatchCompression) (extension, contentType string) {
+	switch compression {
+	case pipeline.BatchCompressionGzip:
+		return ".log.gz", "application/gzip"
+	case pipeline.BatchCompressionZstd:
+		return ".log.zst", "application/zstd"
+	default:
+		return ".log", "application/x-ndjson"
+	}
+}
+
+// Name returns the unique name of the object `<path>/<unix_nano>_<random><extension>`
+func (o *Objects) Name(object *Object, extension string) string {
+	return object.Path + "/" + strconv.FormatInt(time.Now().UnixNano(), 10) + "_" +
+		strconv.FormatUint(uint64(o.rnd.Uint32()), 16) + extension
+}
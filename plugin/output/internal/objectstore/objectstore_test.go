@@ -0,0 +1,89 @@
+package objectstore
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ozontech/file.d/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	insaneJSON "github.com/vitkovskii/insane-json"
+)
+
+func TestParsePathTemplate(t *testing.T) {
+	root, err := insaneJSON.DecodeString(`{"service":"api","k8s":{"ns":"prod"},"bad":"a/b\n..","empty":""}`)
+	require.NoError(t, err)
+	defer insaneJSON.Release(root)
+	ts := time.Date(2024, 5, 1, 10, 15, 30, 0, time.UTC)
+
+	cases := []struct {
+		template string
+		want     string
+		wantErr  bool
+	}{
+		{template: "%Y/%m/%d/%H/%M/%S", want: "2024/05/01/10/15/30"},
+		{template: "${service}-${k8s.ns}/100%%", want: "api-prod/100%"},
+		{template: "${bad}/${empty}/${missing}", want: "a_b_../_/_"},
+		{template: "%Q", wantErr: true},
+		{template: "logs/%", wantErr: true},
+		{template: "${service", wantErr: true},
+		{template: "${}", wantErr: true},
+		{template: "", wantErr: true},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.template, func(t *testing.T) {
+			parts, err := parsePathTemplate(tt.template)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, string(appendPath(nil, parts, root, ts)))
+		})
+	}
+}
+
+func TestGroup(t *testing.T) {
+	template, err := NewTemplate("/${service}/%Y/%m/%d/", []string{"ts"}, "rfc3339")
+	require.NoError(t, err)
+	objects := NewObjects(template)
+
+	batch := test.NewBatch(t,
+		`{"service":"api","ts":"2024-05-01T10:15:00Z","n":1}`,
+		`{"service":"db","ts":"2024-05-01T10:15:00Z","n":2}`,
+		`{"service":"api","ts":"2024-05-01T23:59:59Z","n":3}`,
+		`{"service":"api","ts":"2024-05-02T00:00:00Z","n":4}`,
+	)
+
+	got := map[string]string{}
+	paths := make([]string, 0)
+	grouped := objects.Group(batch)
+	for _, o := range grouped {
+		paths = append(paths, o.Path)
+		got[o.Path] = string(o.Body)
+		assert.Len(t, o.Events, strings.Count(string(o.Body), "\n"))
+	}
+
+	// the objects are in the order of their first events
+	assert.Equal(t, []string{"api/2024/05/01", "db/2024/05/01", "api/2024/05/02"}, paths)
+	assert.Equal(t, map[string]string{
+		"api/2024/05/01": `{"service":"api","ts":"2024-05-01T10:15:00Z","n":1}` + "\n" +
+			`{"service":"api","ts":"2024-05-01T23:59:59Z","n":3}` + "\n",
+		"db/2024/05/01":  `{"service":"db","ts":"2024-05-01T10:15:00Z","n":2}` + "\n",
+		"api/2024/05/02": `{"service":"api","ts":"2024-05-02T00:00:00Z","n":4}` + "\n",
+	}, got)
+
+	objects.Release()
+	assert.Nil(t, grouped[0].Events[0], "released object keeps the event")
+
+	// the objects are reused by the next batch
+	next := objects.Group(test.NewBatch(t, `{"service":"db","ts":"2024-05-03T00:00:00Z"}`))
+	require.Len(t, next, 1)
+	assert.Same(t, grouped[0], next[0])
+	assert.Equal(t, "db/2024/05/03", next[0].Path)
+	assert.Len(t, next[0].Events, 1)
+	assert.True(t, strings.HasPrefix(objects.Name(next[0], ".log.gz"), "db/2024/05/03/"))
+	assert.True(t, strings.HasSuffix(objects.Name(next[0], ".log.gz"), ".log.gz"))
+}
@@ -0,0 +1,123 @@
+package objectstore
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ozontech/file.d/cfg"
+	insaneJSON "github.com/vitkovskii/insane-json"
+)
+
+// pathPart is the literal part of the path template, the field if it's set or the time verb if it isn't zero
+type pathPart struct {
+	literal string
+	field   []string
+	verb    byte
+}
+
+// parsePathTemplate splits the template to the literals, the `${field}` parts and the `%Y|%m|%d|%H|%M|%S` time verbs
+func parsePathTemplate(s string) ([]pathPart, error) {
+	parts := make([]pathPart, 0)
+	literal := strings.Builder{}
+	flush := func() {
+		if literal.Len() > 0 {
+			parts = append(parts, pathPart{literal: literal.String()})
+			literal.Reset()
+		}
+	}
+
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '%':
+			if i+1 == len(s) {
+				return nil, fmt.Errorf("unfinished time verb at %d", i)
+			}
+			i++
+			switch s[i] {
+			case '%':
+				literal.WriteByte('%')
+			case 'Y', 'm', 'd', 'H', 'M', 'S':
+				flush()
+				parts = append(parts, pathPart{verb: s[i]})
+			default:
+				return nil, fmt.Errorf("unknown time verb %%%c at %d", s[i], i-1)
+			}
+		case strings.HasPrefix(s[i:], "${"):
+			end := strings.IndexByte(s[i:], '}')
+			if end < 0 {
+				return nil, fmt.Errorf("unclosed field at %d", i)
+			}
+			field := s[i+2 : i+end]
+			if field == "" {
+				return nil, fmt.Errorf("empty field at %d", i)
+			}
+			flush()
+			parts = append(parts, pathPart{field: cfg.ParseFieldSelector(field)})
+			i += end
+		default:
+			literal.WriteByte(s[i])
+		}
+	}
+	flush()
+
+	if len(parts) == 0 {
+		return nil, fmt.Errorf("template is empty")
+	}
+	return parts, nil
+}
+
+// appendPath appends the path of the event, the time verbs are replaced by the UTC time
+func appendPath(out []byte, parts []pathPart, root *insaneJSON.Root, t time.Time) []byte {
+	t = t.UTC()
+	for i := range parts {
+		part := &parts[i]
+		switch {
+		case part.verb != 0:
+			out = appendTimeVerb(out, part.verb, t)
+		case len(part.field) != 0:
+			out = appendPathValue(out, root.Dig(part.field...))
+		default:
+			out = append(out, part.literal...)
+		}
+	}
+	return out
+}
+
+func appendTimeVerb(out []byte, verb byte, t time.Time) []byte {
+	switch verb {
+	case 'Y':
+		return t.AppendFormat(out, "2006")
+	case 'm':
+		return t.AppendFormat(out, "01")
+	case 'd':
+		return t.AppendFormat(out, "02")
+	case 'H':
+		return t.AppendFormat(out, "15")
+	case 'M':
+		return t.AppendFormat(out, "04")
+	default:
+		return t.AppendFormat(out, "05")
+	}
+}
+
+// appendPathValue appends the value of the field so it's the only path segment,
+// the slashes and the control characters are replaced by `_`, the missing or empty value is `_`
+func appendPathValue(out []byte, node *insaneJSON.Node) []byte {
+	value := ""
+	if node != nil {
+		value = node.AsString()
+	}
+	if value == "" || value == "." || value == ".." {
+		return append(out, '_')
+	}
+
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if c == '/' || c == '\\' || c < 0x20 || c == 0x7f {
+			c = '_'
+		}
+		out = append(out, c)
+	}
+	return out
+}
@@ -2,7 +2,11 @@ package kafka
 
 import (
 	"context"
+	"fmt"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/Shopify/sarama"
@@ -17,15 +21,72 @@ import (
 
 /*{ introduction
 It sends the event batches to kafka brokers using `sarama` lib.
+
+The message key and the headers can be set by the templates of the event fields, the key is used
+by the `hash` partitioner to write the messages with the same key to the same partition in order.
+
+**Example:**
+```yaml
+pipelines:
+  example_pipeline:
+    ...
+    output:
+      type: kafka
+      brokers: [kafka:9092]
+      default_topic: logs
+      key: ${user_id}
+      headers:
+        trace_id: ${trace_id}
+        source: file.d
+      partitioner: hash
+      missing_key: drop
+    ...
+```
+
+The delivery guarantees are defined by `acks`, `idempotent` and `transactional_id`, the stronger ones lower the throughput:
+* by default the events are committed after the leader writes the messages, the retries of the producer can duplicate them
+* the `idempotent` producer waits for all the in-sync replicas and sends one request to the broker at a time,
+so the retries don't duplicate the messages
+* with the `transactional_id` every batch is written in the transaction and the events are committed only after
+the transaction is committed, the batch which isn't written is retried as a whole and the consumers with
+the `read_committed` isolation level don't see the messages of the aborted transactions
 }*/
 
 const (
 	outPluginType = "kafka"
 )
 
+const (
+	partitionerRoundRobin = iota
+	partitionerHash
+	partitionerManual
+)
+
+const (
+	missingKeyRandom = iota
+	missingKeyDrop
+)
+
+const (
+	acksNone = iota
+	acksLeader
+	acksAll
+)
+
 type data struct {
 	messages []*sarama.ProducerMessage
 	outBuf   sarama.ByteEncoder
+
+	// producer is the transactional producer of the worker
+	producer sarama.SyncProducer
+	// producerIndex is the index of the transactional producer in the producers of the plugin
+	producerIndex int
+}
+
+// header is the message header which value is rendered by the template
+type header struct {
+	key   []byte
+	value []cfg.SubstitutionOp
 }
 
 type Plugin struct {
@@ -37,9 +98,17 @@ type Plugin struct {
 	producer sarama.SyncProducer
 	batcher  *pipeline.Batcher
 
+	// producers are the transactional producers of the workers
+	producers   []sarama.SyncProducer
+	producersMu sync.Mutex
+
+	key     []cfg.SubstitutionOp
+	headers []header
+
 	// plugin metrics
 
-	sendErrorMetric *prometheus.CounterVec
+	sendErrorMetric  *prometheus.CounterVec
+	missingKeyMetric *prometheus.CounterVec
 }
 
 // ! config-params
@@ -65,6 +134,42 @@ type Config struct {
 	// > Which event field to use as topic name. It works only if `should_use_topic_field` is set.
 	TopicField string `json:"topic_field" default:"topic"` // *
 
+	// > @3@4@5@6
+	// >
+	// > The template of the message key, the `${field}` parts are replaced by the values of the event fields,
+	// > e.g. `${user_id}` or `${service}-${trace_id}`. If it's empty, the messages are sent without the key.
+	Key string `json:"key"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The message headers, the values are the templates like the `key` or the static values,
+	// > e.g. `{"trace_id": "${trace_id}", "source": "file.d"}`. The header is skipped if its field is missing in the event.
+	Headers map[string]string `json:"headers"` // *
+
+	// > @3@4@5@6
+	// >
+	// > How the partition of the message is chosen:
+	// > * `round_robin` – the partitions are used in turn
+	// > * `hash` – by the hash of the `key`, so the messages with the same key are written to the same partition in order
+	// > * `manual` – by the number in the `partition_field` of the event
+	Partitioner  string `json:"partitioner" default:"round_robin" options:"round_robin|hash|manual"` // *
+	Partitioner_ int
+
+	// > @3@4@5@6
+	// >
+	// > The event field with the partition number for the `manual` partitioner.
+	PartitionField  cfg.FieldSelector `json:"partition_field" default:"partition" parse:"selector"` // *
+	PartitionField_ []string
+
+	// > @3@4@5@6
+	// >
+	// > What to do if the `key` is set, but its field is missing in the event or the rendered key is empty,
+	// > or the `partition_field` of the `manual` partitioner is missing or isn't a number:
+	// > * `random` – send the message without the key, the `hash` and the `manual` partitioners choose the random partition
+	// > * `drop` – drop the event
+	MissingKey  string `json:"missing_key" default:"random" options:"random|drop"` // *
+	MissingKey_ int
+
 	// > @3@4@5@6
 	// >
 	// > How many workers will be instantiated to send batches.
@@ -125,6 +230,56 @@ type Config struct {
 	// > If SaslSslEnabled, the plugin will use path to the PEM certificate.
 	SaslPem string `json:"pem_file" default:"/file.d/certs"` // *
 
+	// > @3@4@5@6
+	// >
+	// > The acknowledgement of the messages the producer waits for:
+	// > * `none` – doesn't wait, the messages can be lost
+	// > * `leader` – waits for the leader to write the messages
+	// > * `all` – waits for all the in-sync replicas to write the messages, it's the slowest and the most reliable one
+	// >
+	// > It's always `all` if `idempotent` or `transactional_id` is set.
+	Acks  string `json:"acks" default:"leader" options:"none|leader|all"` // *
+	Acks_ int
+
+	// > @3@4@5@6
+	// >
+	// > The max number of the requests to the broker which wait for the response.
+	// > It's always 1 if `idempotent` or `transactional_id` is set.
+	MaxInFlight int `json:"max_in_flight" default:"5"` // *
+
+	// > @3@4@5@6
+	// >
+	// > If set, the producer is idempotent, so the retries of the producer don't duplicate the messages.
+	Idempotent bool `json:"idempotent" default:"false"` // *
+
+	// > @3@4@5@6
+	// >
+	// > If set, every batch is written in the transaction and the events are committed after the transaction is committed.
+	// > Every worker has its own producer with the `<transactional_id>-<worker number>` id, so the id should be unique
+	// > for every file.d instance and stay the same after the restart. The transactional producer is idempotent.
+	TransactionalID string `json:"transactional_id"` // *
+
+	// > @3@4@5@6
+	// >
+	// > Retries of the batch which transaction isn't committed.
+	Retry int `json:"retry" default:"10"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The delay before the first retry of the transaction, it's doubled after each retry.
+	Retention  cfg.Duration `json:"retention" default:"1s" parse:"duration"` // *
+	Retention_ time.Duration
+
+	// > @3@4@5@6
+	// >
+	// > The max delay between the retries of the transaction.
+	MaxRetention  cfg.Duration `json:"max_retention" default:"1m" parse:"duration"` // *
+	MaxRetention_ time.Duration
+
+	// > @3@4@5@6
+	// >
+	// > Exit with the non-zero code if the transaction isn't committed after all the retries, otherwise the batch is dropped.
+	FatalOnFailedInsert bool `json:"fatal_on_failed_insert" default:"false"` // *
 }
 
 func init() {
@@ -147,7 +302,18 @@ func (p *Plugin) Start(config pipeline.AnyConfig, params *pipeline.OutputPluginP
 
 	p.logger.Infof("workers count=%d, batch size=%d", p.config.WorkersCount_, p.config.BatchSize_)
 
-	p.producer = p.newProducer()
+	if err := p.parseTemplates(); err != nil {
+		p.logger.Fatalf("wrong config: %s", err.Error())
+	}
+
+	// the transactional producers are created by the workers
+	if p.config.TransactionalID == "" {
+		producer, err := p.newProducer("")
+		if err != nil {
+			p.logger.Fatalf("can't create producer: %s", err.Error())
+		}
+		p.producer = producer
+	}
 	p.batcher = pipeline.NewBatcher(pipeline.BatcherOptions{
 		PipelineName:   params.PipelineName,
 		OutputType:     outPluginType,
@@ -157,7 +323,19 @@ func (p *Plugin) Start(config pipeline.AnyConfig, params *pipeline.OutputPluginP
 		BatchSizeCount: p.config.BatchSize_,
 		BatchSizeBytes: p.config.BatchSizeBytes_,
 		FlushTimeout:   p.config.BatchFlushTimeout_,
+		MaxEventAge:    params.PipelineSettings.MaxEventAge,
+		CircuitBreaker: params.PipelineSettings.CircuitBreaker,
+		Health:         params.Health,
+		ErrorReporter:  params.ErrorReporter,
+		DeadLetter:     params.DeadLetter,
 		MetricCtl:      params.MetricCtl,
+		Retry: pipeline.BatcherRetry{
+			MaxRetries:        p.config.Retry,
+			InitialBackoff:    p.config.Retention_,
+			BackoffMultiplier: 2,
+			MaxBackoff:        p.config.MaxRetention_,
+		},
+		FatalOnFailedInsert: p.config.FatalOnFailedInsert,
 	})
 
 	p.batcher.Start(context.TODO())
@@ -169,14 +347,51 @@ func (p *Plugin) Out(event *pipeline.Event) {
 
 func (p *Plugin) registerMetrics(ctl *metric.Ctl) {
 	p.sendErrorMetric = ctl.RegisterCounter("output_kafka_send_errors", "Total Kafka send errors")
+	p.missingKeyMetric = ctl.RegisterCounter("output_kafka_missing_key",
+		"Number of events without the key or the partition by the action: random or drop",
+		"action",
+	)
+}
+
+// parseTemplates parses the templates of the key and the headers, the headers are sorted by the keys
+func (p *Plugin) parseTemplates() error {
+	if p.config.Key != "" {
+		key, err := cfg.ParseSubstitution(p.config.Key)
+		if err != nil {
+			return fmt.Errorf("wrong key template %q: %w", p.config.Key, err)
+		}
+		p.key = key
+	}
+
+	keys := make([]string, 0, len(p.config.Headers))
+	for key := range p.config.Headers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	p.headers = p.headers[:0]
+	for _, key := range keys {
+		value, err := cfg.ParseSubstitution(p.config.Headers[key])
+		if err != nil {
+			return fmt.Errorf("wrong template of header %q: %w", key, err)
+		}
+		p.headers = append(p.headers, header{key: []byte(key), value: value})
+	}
+	return nil
 }
 
-func (p *Plugin) out(workerData *pipeline.WorkerData, batch *pipeline.Batch) {
+func (p *Plugin) out(workerData *pipeline.WorkerData, batch *pipeline.Batch) error {
 	if *workerData == nil {
-		*workerData = &data{
+		d := &data{
 			messages: make([]*sarama.ProducerMessage, p.config.BatchSize_),
 			outBuf:   make([]byte, 0, p.config.BatchSize_*p.avgEventSize),
 		}
+		if p.config.TransactionalID != "" {
+			if err := p.addTransactionalProducer(d); err != nil {
+				return err
+			}
+		}
+		*workerData = d
 	}
 
 	data := (*workerData).(*data)
@@ -187,7 +402,20 @@ func (p *Plugin) out(workerData *pipeline.WorkerData, batch *pipeline.Batch) {
 
 	outBuf := data.outBuf[:0]
 	start := 0
-	for i, event := range batch.Events {
+	count := 0
+	for _, event := range batch.Events {
+		if data.messages[count] == nil {
+			data.messages[count] = &sarama.ProducerMessage{}
+		}
+		msg := data.messages[count]
+
+		var ok bool
+		outBuf, ok = p.appendKey(outBuf, msg, event)
+		if !ok {
+			continue
+		}
+		outBuf = p.appendHeaders(outBuf, msg, event)
+
 		outBuf, start = event.Encode(outBuf)
 
 		topic := p.config.DefaultTopic
@@ -198,16 +426,23 @@ func (p *Plugin) out(workerData *pipeline.WorkerData, batch *pipeline.Batch) {
 			}
 		}
 
-		if data.messages[i] == nil {
-			data.messages[i] = &sarama.ProducerMessage{}
-		}
-		data.messages[i].Value = outBuf[start:]
-		data.messages[i].Topic = topic
+		msg.Value = outBuf[start:]
+		msg.Topic = topic
+		count++
 	}
 
 	data.outBuf = outBuf
 
-	err := p.producer.SendMessages(data.messages[:len(batch.Events)])
+	// all events are dropped
+	if count == 0 {
+		return nil
+	}
+
+	if p.config.TransactionalID != "" {
+		return p.sendTransaction(data, data.messages[:count])
+	}
+
+	err := p.producer.SendMessages(data.messages[:count])
 	if err != nil {
 		errs := err.(sarama.ProducerErrors)
 		for _, e := range errs {
@@ -216,16 +451,168 @@ func (p *Plugin) out(workerData *pipeline.WorkerData, batch *pipeline.Batch) {
 		p.sendErrorMetric.WithLabelValues().Add(float64(len(errs)))
 		p.controller.Error("some events from batch were not written")
 	}
+
+	return nil
+}
+
+// appendKey sets the key and the partition of the message, the key is stored in the out buffer,
+// it returns false if the event should be dropped since the key or the partition is missing
+func (p *Plugin) appendKey(outBuf []byte, msg *sarama.ProducerMessage, event *pipeline.Event) ([]byte, bool) {
+	msg.Key = nil
+	msg.Partition = -1
+	missing := false
+
+	if len(p.key) != 0 {
+		start := len(outBuf)
+		var ok bool
+		outBuf, ok = cfg.AppendSubstitution(outBuf, p.key, event.Root, cfg.SubstitutionEscapeNone)
+		if ok && len(outBuf) > start {
+			msg.Key = sarama.ByteEncoder(outBuf[start:])
+		} else {
+			outBuf = outBuf[:start]
+			missing = true
+		}
+	}
+
+	if p.config.Partitioner_ == partitionerManual {
+		partition, err := strconv.ParseInt(event.Root.Dig(p.config.PartitionField_...).AsString(), 10, 32)
+		if err == nil && partition >= 0 {
+			msg.Partition = int32(partition)
+		} else {
+			missing = true
+		}
+	}
+
+	if !missing {
+		return outBuf, true
+	}
+	if p.config.MissingKey_ == missingKeyDrop {
+		p.missingKeyMetric.WithLabelValues("drop").Inc()
+		return outBuf, false
+	}
+	p.missingKeyMetric.WithLabelValues("random").Inc()
+	return outBuf, true
+}
+
+// appendHeaders sets the headers of the message, the values are stored in the out buffer
+func (p *Plugin) appendHeaders(outBuf []byte, msg *sarama.ProducerMessage, event *pipeline.Event) []byte {
+	msg.Headers = msg.Headers[:0]
+	for i := range p.headers {
+		h := &p.headers[i]
+		start := len(outBuf)
+		var ok bool
+		outBuf, ok = cfg.AppendSubstitution(outBuf, h.value, event.Root, cfg.SubstitutionEscapeNone)
+		if !ok {
+			outBuf = outBuf[:start]
+			continue
+		}
+		msg.Headers = append(msg.Headers, sarama.RecordHeader{Key: h.key, Value: outBuf[start:]})
+	}
+	return outBuf
+}
+
+// sendTransaction sends the messages in the transaction, the transaction is aborted if any message isn't sent,
+// the producer is recreated if it can't be used after the error
+func (p *Plugin) sendTransaction(data *data, messages []*sarama.ProducerMessage) error {
+	err := data.producer.BeginTxn()
+	if err == nil {
+		err = data.producer.SendMessages(messages)
+		if err == nil {
+			err = data.producer.CommitTxn()
+		}
+		if err != nil && data.producer.TxnStatus()&sarama.ProducerTxnFlagInTransaction != 0 {
+			if abortErr := data.producer.AbortTxn(); abortErr != nil {
+				p.logger.Errorf("can't abort transaction: %s", abortErr.Error())
+			}
+		}
+	}
+	if err == nil {
+		return nil
+	}
+
+	p.sendErrorMetric.WithLabelValues().Add(float64(len(messages)))
+	if data.producer.TxnStatus()&sarama.ProducerTxnFlagFatalError != 0 {
+		p.resetTransactionalProducer(data)
+	}
+	return fmt.Errorf("can't write batch in transaction: %w", err)
+}
+
+// addTransactionalProducer creates the producer of the worker, the workers get the ids in order,
+// so the ids stay the same after the restart
+func (p *Plugin) addTransactionalProducer(data *data) error {
+	p.producersMu.Lock()
+	defer p.producersMu.Unlock()
+
+	index := len(p.producers)
+	producer, err := p.newProducer(p.transactionalID(index))
+	if err != nil {
+		return fmt.Errorf("can't create transactional producer: %w", err)
+	}
+	p.producers = append(p.producers, producer)
+	data.producer = producer
+	data.producerIndex = index
+	return nil
+}
+
+// resetTransactionalProducer replaces the producer which transaction state is fatal with the new one with the same id
+func (p *Plugin) resetTransactionalProducer(data *data) {
+	producer, err := p.newProducer(p.transactionalID(data.producerIndex))
+	if err != nil {
+		p.logger.Errorf("can't recreate transactional producer: %s", err.Error())
+		return
+	}
+	if err := data.producer.Close(); err != nil {
+		p.logger.Errorf("can't stop kafka producer: %s", err.Error())
+	}
+
+	p.producersMu.Lock()
+	p.producers[data.producerIndex] = producer
+	p.producersMu.Unlock()
+	data.producer = producer
+}
+
+func (p *Plugin) transactionalID(index int) string {
+	return p.config.TransactionalID + "-" + strconv.Itoa(index)
 }
 
 func (p *Plugin) Stop() {
 	p.batcher.Stop()
-	if err := p.producer.Close(); err != nil {
-		p.logger.Error("can't stop kafka producer: %s", err)
+	if p.producer != nil {
+		if err := p.producer.Close(); err != nil {
+			p.logger.Error("can't stop kafka producer: %s", err)
+		}
 	}
+
+	p.producersMu.Lock()
+	defer p.producersMu.Unlock()
+	for _, producer := range p.producers {
+		if err := producer.Close(); err != nil {
+			p.logger.Error("can't stop kafka producer: %s", err)
+		}
+	}
+}
+
+// manualPartitioner uses the partition of the message and chooses the random one if it isn't set
+type manualPartitioner struct {
+	random sarama.Partitioner
+}
+
+func newManualPartitioner(topic string) sarama.Partitioner {
+	return &manualPartitioner{random: sarama.NewRandomPartitioner(topic)}
+}
+
+func (p *manualPartitioner) Partition(message *sarama.ProducerMessage, numPartitions int32) (int32, error) {
+	if message.Partition < 0 {
+		return p.random.Partition(message, numPartitions)
+	}
+	return message.Partition, nil
+}
+
+func (p *manualPartitioner) RequiresConsistency() bool {
+	return true
 }
 
-func (p *Plugin) newProducer() sarama.SyncProducer {
+func (p *Plugin) newProducer(transactionalID string) (sarama.SyncProducer, error) {
 	config := sarama.NewConfig()
 	config.ClientID = "sasl_scram_client"
 	// kafka auth sasl
@@ -249,18 +636,43 @@ func (p *Plugin) newProducer() sarama.SyncProducer {
 		config.Net.TLS.Config = tlsCfg.Build()
 	}
 
-	config.Producer.Partitioner = sarama.NewRoundRobinPartitioner
+	switch p.config.Partitioner_ {
+	case partitionerHash:
+		config.Producer.Partitioner = sarama.NewHashPartitioner
+	case partitionerManual:
+		config.Producer.Partitioner = newManualPartitioner
+	default:
+		config.Producer.Partitioner = sarama.NewRoundRobinPartitioner
+	}
 	config.Producer.Flush.Messages = p.config.BatchSize_
 	// kafka plugin itself cares for flush frequency, but we are using batcher so disable it.
 	config.Producer.Flush.Frequency = time.Millisecond
 	config.Producer.Return.Errors = true
 	config.Producer.Return.Successes = true
 
+	switch p.config.Acks_ {
+	case acksNone:
+		config.Producer.RequiredAcks = sarama.NoResponse
+	case acksAll:
+		config.Producer.RequiredAcks = sarama.WaitForAll
+	default:
+		config.Producer.RequiredAcks = sarama.WaitForLocal
+	}
+	config.Net.MaxOpenRequests = p.config.MaxInFlight
+
+	if p.config.Idempotent || transactionalID != "" {
+		// the idempotent producer keeps the order of the messages only with these settings
+		config.Producer.Idempotent = true
+		config.Producer.RequiredAcks = sarama.WaitForAll
+		config.Net.MaxOpenRequests = 1
+	}
+	config.Producer.Transaction.ID = transactionalID
+
 	producer, err := sarama.NewSyncProducer(p.config.Brokers, config)
 	if err != nil {
-		p.logger.Fatalf("can't create producer: %s", err.Error())
+		return nil, err
 	}
 
 	p.logger.Infof("producer created with brokers %q", strings.Join(p.config.Brokers, ","))
-	return producer
+	return producer, nil
 }
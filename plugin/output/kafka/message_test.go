@@ -0,0 +1,210 @@
+package kafka
+
+import (
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/ozontech/file.d/pipeline"
+	"github.com/ozontech/file.d/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	insaneJSON "github.com/vitkovskii/insane-json"
+)
+
+// captureProducer keeps the sent messages
+type captureProducer struct {
+	sarama.SyncProducer
+	messages []sarama.ProducerMessage
+}
+
+func (c *captureProducer) SendMessages(msgs []*sarama.ProducerMessage) error {
+	for _, msg := range msgs {
+		c.messages = append(c.messages, *msg)
+	}
+	return nil
+}
+
+type message struct {
+	key       string
+	partition int32
+	headers   map[string]string
+	value     string
+}
+
+func TestOutKeyHeadersPartition(t *testing.T) {
+	events := []string{
+		`{"user":"u1","trace":"t1","partition":2}`,
+		`{"user":"u2","partition":"1"}`,
+		`{"trace":"t3"}`,
+	}
+
+	cases := []struct {
+		name   string
+		config *Config
+		want   []message
+	}{
+		{
+			name: "hash",
+			config: &Config{
+				Key:         "user-${user}",
+				Headers:     map[string]string{"trace_id": "${trace}", "source": "file.d"},
+				Partitioner: "hash",
+			},
+			want: []message{
+				{key: "user-u1", partition: -1, headers: map[string]string{"source": "file.d", "trace_id": "t1"}, value: events[0]},
+				{key: "user-u2", partition: -1, headers: map[string]string{"source": "file.d"}, value: events[1]},
+				{partition: -1, headers: map[string]string{"source": "file.d", "trace_id": "t3"}, value: events[2]},
+			},
+		},
+		{
+			name: "missing_key_drop",
+			config: &Config{
+				Key:         "${user}",
+				Partitioner: "hash",
+				MissingKey:  "drop",
+			},
+			want: []message{
+				{key: "u1", partition: -1, headers: map[string]string{}, value: events[0]},
+				{key: "u2", partition: -1, headers: map[string]string{}, value: events[1]},
+			},
+		},
+		{
+			name: "manual",
+			config: &Config{
+				Partitioner: "manual",
+			},
+			want: []message{
+				{partition: 2, headers: map[string]string{}, value: events[0]},
+				{partition: 1, headers: map[string]string{}, value: events[1]},
+				{partition: -1, headers: map[string]string{}, value: events[2]},
+			},
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.config.Brokers = []string{"kafka:9092"}
+			tt.config.DefaultTopic = "logs"
+			tt.config.BatchSize = "4"
+			test.NewConfig(tt.config, map[string]int{"gomaxprocs": 1, "capacity": 64})
+
+			producer := &captureProducer{}
+			params := test.NewEmptyOutputPluginParams()
+			p := &Plugin{config: tt.config, logger: params.Logger, avgEventSize: 16, producer: producer}
+			p.registerMetrics(params.MetricCtl)
+			require.NoError(t, p.parseTemplates())
+
+			batch := &pipeline.Batch{}
+			for _, e := range events {
+				root, err := insaneJSON.DecodeString(e)
+				require.NoError(t, err)
+				defer insaneJSON.Release(root)
+				batch.Events = append(batch.Events, &pipeline.Event{Root: root})
+			}
+
+			workerData := pipeline.WorkerData(nil)
+			require.NoError(t, p.out(&workerData, batch))
+
+			got := make([]message, 0, len(producer.messages))
+			for _, msg := range producer.messages {
+				assert.Equal(t, "logs", msg.Topic)
+				m := message{partition: msg.Partition, headers: map[string]string{}}
+				if msg.Key != nil {
+					key, _ := msg.Key.Encode()
+					m.key = string(key)
+				}
+				for _, h := range msg.Headers {
+					m.headers[string(h.Key)] = string(h.Value)
+				}
+				value, _ := msg.Value.Encode()
+				m.value = string(value)
+				got = append(got, m)
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestManualPartitioner(t *testing.T) {
+	p := newManualPartitioner("logs")
+
+	partition, err := p.Partition(&sarama.ProducerMessage{Partition: 3}, 4)
+	require.NoError(t, err)
+	assert.Equal(t, int32(3), partition)
+
+	partition, err = p.Partition(&sarama.ProducerMessage{Partition: -1}, 4)
+	require.NoError(t, err)
+	assert.True(t, partition >= 0 && partition < 4)
+}
+
+// txnProducer records the calls of the transaction
+type txnProducer struct {
+	sarama.SyncProducer
+	sendErr error
+	status  sarama.ProducerTxnStatusFlag
+	calls   []string
+}
+
+func (p *txnProducer) BeginTxn() error {
+	p.calls = append(p.calls, "begin")
+	p.status = sarama.ProducerTxnFlagInTransaction
+	return nil
+}
+
+func (p *txnProducer) SendMessages(_ []*sarama.ProducerMessage) error {
+	p.calls = append(p.calls, "send")
+	return p.sendErr
+}
+
+func (p *txnProducer) CommitTxn() error {
+	p.calls = append(p.calls, "commit")
+	p.status = sarama.ProducerTxnFlagReady
+	return nil
+}
+
+func (p *txnProducer) AbortTxn() error {
+	p.calls = append(p.calls, "abort")
+	p.status = sarama.ProducerTxnFlagReady
+	return nil
+}
+
+func (p *txnProducer) TxnStatus() sarama.ProducerTxnStatusFlag {
+	return p.status
+}
+
+func TestSendTransaction(t *testing.T) {
+	cases := []struct {
+		name      string
+		sendErr   error
+		wantCalls []string
+		wantErr   bool
+	}{
+		{
+			name:      "commit",
+			wantCalls: []string{"begin", "send", "commit"},
+		},
+		{
+			name:      "abort",
+			sendErr:   sarama.ErrNotEnoughReplicas,
+			wantCalls: []string{"begin", "send", "abort"},
+			wantErr:   true,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			params := test.NewEmptyOutputPluginParams()
+			p := &Plugin{config: &Config{TransactionalID: "file.d"}, logger: params.Logger}
+			p.registerMetrics(params.MetricCtl)
+
+			producer := &txnProducer{sendErr: tt.sendErr}
+			err := p.sendTransaction(&data{producer: producer}, []*sarama.ProducerMessage{{Topic: "logs"}})
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.wantCalls, producer.calls)
+		})
+	}
+}
@@ -0,0 +1,152 @@
+package kinesis
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/ozontech/file.d/pipeline"
+)
+
+const (
+	service     = "kinesis"
+	target      = "Kinesis_20131202.PutRecords"
+	contentType = "application/x-amz-json-1.1"
+
+	// maxRecords, maxRequestSize and maxRecordSize are the limits of PutRecords request of Kinesis API,
+	// the sizes include the data and the partition keys
+	maxRecords     = 500
+	maxRequestSize = 5 * 1024 * 1024
+	maxRecordSize  = 1024 * 1024
+
+	maxPartitionKeySize = 256
+)
+
+// client implements PutRecords of Kinesis API over the AWS JSON protocol
+type client struct {
+	http     *http.Client
+	endpoint string
+	region   string
+	stream   string
+	creds    aws.Credentials
+	signer   *v4.Signer
+	now      func() time.Time
+}
+
+// record is the data and the partition key of the record, they point to the buffer of the encoded events
+type record struct {
+	data []byte
+	key  []byte
+}
+
+// recordResult is the result of the record of PutRecords response, the error code is empty if the record is put
+type recordResult struct {
+	ErrorCode    string `json:"ErrorCode"`
+	ErrorMessage string `json:"ErrorMessage"`
+}
+
+type putRecordsResponse struct {
+	FailedRecordCount int            `json:"FailedRecordCount"`
+	Records           []recordResult `json:"Records"`
+}
+
+type apiError struct {
+	Type    string `json:"__type"`
+	Message string `json:"message"`
+}
+
+// putRecords sends the request encoded by the encodeRequest and returns the results in the order of the records
+func (c *client) putRecords(ctx context.Context, body []byte, count int) ([]recordResult, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("X-Amz-Target", target)
+	if err := c.sign(ctx, req, body); err != nil {
+		return nil, err
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		apiErr := apiError{}
+		_ = json.Unmarshal(respBody, &apiErr)
+		return nil, fmt.Errorf("PutRecords failed with status %d: %s %s", resp.StatusCode, apiErr.Type, apiErr.Message)
+	}
+
+	out := putRecordsResponse{}
+	if err := json.Unmarshal(respBody, &out); err != nil {
+		return nil, err
+	}
+	if len(out.Records) != count {
+		return nil, fmt.Errorf("PutRecords returned %d results for %d records", len(out.Records), count)
+	}
+
+	return out.Records, nil
+}
+
+// encodeRequest appends the JSON of PutRecords request, the data is encoded by base64 as the API requires
+func (c *client) encodeRequest(out []byte, records []record) []byte {
+	out = append(out, `{"StreamName":`...)
+	out = appendString(out, c.stream)
+	out = append(out, `,"Records":[`...)
+	for i, r := range records {
+		if i > 0 {
+			out = append(out, ',')
+		}
+		out = append(out, `{"Data":"`...)
+		l := len(out)
+		out = append(out, make([]byte, base64.StdEncoding.EncodedLen(len(r.data)))...)
+		base64.StdEncoding.Encode(out[l:], r.data)
+		out = append(out, `","PartitionKey":`...)
+		out = appendString(out, pipeline.ByteToStringUnsafe(r.key))
+		out = append(out, '}')
+	}
+	return append(out, "]}"...)
+}
+
+// appendString appends the quoted JSON string, the string is expected to be valid UTF-8
+func appendString(out []byte, s string) []byte {
+	const hex = "0123456789abcdef"
+
+	out = append(out, '"')
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"' || c == '\\':
+			out = append(out, '\\', c)
+		case c < 0x20:
+			out = append(out, '\\', 'u', '0', '0', hex[c>>4], hex[c&0xf])
+		default:
+			out = append(out, c)
+		}
+	}
+	return append(out, '"')
+}
+
+// sign adds AWS Signature Version 4 headers to the request, all headers set before are signed
+func (c *client) sign(ctx context.Context, req *http.Request, body []byte) error {
+	bodyHash := sha256.Sum256(body)
+	return c.signer.SignHTTP(ctx, c.creds, req, hex.EncodeToString(bodyHash[:]), service, c.region, c.now())
+}
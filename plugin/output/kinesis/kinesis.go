@@ -0,0 +1,412 @@
+// Package kinesis is an output plugin that puts events to the Amazon Kinesis Data Stream.
+package kinesis
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/ozontech/file.d/cfg"
+	"github.com/ozontech/file.d/fd"
+	"github.com/ozontech/file.d/metric"
+	"github.com/ozontech/file.d/pipeline"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+/*{ introduction
+It puts the events to the Amazon Kinesis Data Stream by PutRecords requests, each event is the record encoded as JSON.
+The batch is split to the requests by the Kinesis limits: 500 records and 5MB, the record larger than 1MB is dropped.
+
+The partition key is rendered by `partition_key` template of the event fields. The record gets the random partition key,
+so it's put to the random shard, if the template is empty or any field of it is missing.
+
+Kinesis responds with the result of each record, the events of the records which are throttled or failed
+are retried with backoff, the accepted ones aren't sent again. The batch is committed
+after all its records are accepted or the retries are exhausted.
+
+Credentials are taken from the config or from `AWS_ACCESS_KEY_ID`, `AWS_SECRET_ACCESS_KEY`
+and `AWS_SESSION_TOKEN` environment variables if config values are empty.
+
+**Example:**
+```yaml
+pipelines:
+  example_pipeline:
+    ...
+    output:
+      type: kinesis
+      stream: logs
+      region: eu-west-1
+      partition_key: ${service}:${host}
+    ...
+```
+}*/
+
+const (
+	outPluginType = "kinesis"
+)
+
+type Plugin struct {
+	config       *Config
+	logger       *zap.SugaredLogger
+	avgEventSize int
+	batcher      *pipeline.Batcher
+	controller   pipeline.OutputPluginController
+	client       *client
+
+	partitionKey []cfg.SubstitutionOp
+
+	// plugin metrics
+
+	requestErrorsMetric  *prometheus.CounterVec
+	failedRecordsMetric  *prometheus.CounterVec
+	droppedRecordsMetric *prometheus.CounterVec
+}
+
+// ! config-params
+// ^ config-params
+type Config struct {
+	// > @3@4@5@6
+	// >
+	// > The name of the stream to put the records to.
+	Stream string `json:"stream" required:"true"` // *
+
+	// > @3@4@5@6
+	// >
+	// > AWS region of the stream.
+	Region string `json:"region" default:"us-east-1"` // *
+
+	// > @3@4@5@6
+	// >
+	// > Kinesis API endpoint, e.g. `http://localhost:4566` for localstack.
+	// > By default, it's the endpoint of the `region`.
+	Endpoint string `json:"endpoint"` // *
+
+	// > @3@4@5@6
+	// >
+	// > AWS access key ID.
+	AccessKey string `json:"access_key"` // *
+
+	// > @3@4@5@6
+	// >
+	// > AWS secret access key.
+	SecretKey string `json:"secret_key"` // *
+
+	// > @3@4@5@6
+	// >
+	// > AWS session token for temporary credentials.
+	SessionToken string `json:"session_token"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The template of the partition key, the `${field}` parts are replaced by the values of the event fields,
+	// > e.g. `${service}:${host}`. The key longer than 256 bytes is truncated.
+	PartitionKey string `json:"partition_key"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The max number of PutRecords requests in flight, each request is sent by its own worker.
+	MaxInFlight  cfg.Expression `json:"max_in_flight" default:"gomaxprocs*4" parse:"expression"` // *
+	MaxInFlight_ int
+
+	// > @3@4@5@6
+	// >
+	// > Client timeout when sends requests to Kinesis.
+	RequestTimeout  cfg.Duration `json:"request_timeout" default:"10s" parse:"duration"` // *
+	RequestTimeout_ time.Duration
+
+	// > @3@4@5@6
+	// >
+	// > A maximum quantity of events to pack into one batch.
+	BatchSize  cfg.Expression `json:"batch_size" default:"500" parse:"expression"` // *
+	BatchSize_ int
+
+	// > @3@4@5@6
+	// >
+	// > A minimum size of events in a batch to send.
+	// > If both batch_size and batch_size_bytes are set, they will work together.
+	BatchSizeBytes  cfg.Expression `json:"batch_size_bytes" default:"0" parse:"expression"` // *
+	BatchSizeBytes_ int
+
+	// > @3@4@5@6
+	// >
+	// > After this timeout the batch will be sent even if batch isn't completed.
+	BatchFlushTimeout  cfg.Duration `json:"batch_flush_timeout" default:"200ms" parse:"duration"` // *
+	BatchFlushTimeout_ time.Duration
+
+	// > @3@4@5@6
+	// >
+	// > Retries of the batch or its failed records.
+	Retry int `json:"retry" default:"10"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The delay before the first retry, it's doubled after each retry.
+	Retention  cfg.Duration `json:"retention" default:"1s" parse:"duration"` // *
+	Retention_ time.Duration
+
+	// > @3@4@5@6
+	// >
+	// > The max delay between the retries.
+	MaxRetention  cfg.Duration `json:"max_retention" default:"1m" parse:"duration"` // *
+	MaxRetention_ time.Duration
+
+	// > @3@4@5@6
+	// >
+	// > Exit with the non-zero code if the records aren't put after all the retries, otherwise they are dropped.
+	FatalOnFailedInsert bool `json:"fatal_on_failed_insert" default:"false"` // *
+}
+
+// pending is the event which record is encoded to the buffer, the data is buf[begin:mid] and the key is buf[mid:end]
+type pending struct {
+	event *pipeline.Event
+	begin int
+	mid   int
+	end   int
+}
+
+type data struct {
+	buf     []byte
+	body    []byte
+	pending []pending
+	records []record
+}
+
+func init() {
+	fd.DefaultPluginRegistry.RegisterOutput(&pipeline.PluginStaticInfo{
+		Type:    outPluginType,
+		Factory: Factory,
+	})
+}
+
+func Factory() (pipeline.AnyPlugin, pipeline.AnyConfig) {
+	return &Plugin{}, &Config{}
+}
+
+func (p *Plugin) Start(config pipeline.AnyConfig, params *pipeline.OutputPluginParams) {
+	p.controller = params.Controller
+	p.logger = params.Logger
+	p.avgEventSize = params.PipelineSettings.AvgEventSize
+	p.config = config.(*Config)
+	p.registerMetrics(params.MetricCtl)
+
+	if p.config.PartitionKey != "" {
+		partitionKey, err := cfg.ParseSubstitution(p.config.PartitionKey)
+		if err != nil {
+			p.logger.Fatalf("wrong partition key template %q: %s", p.config.PartitionKey, err.Error())
+		}
+		p.partitionKey = partitionKey
+	}
+
+	p.client = p.newClient()
+
+	p.batcher = pipeline.NewBatcher(pipeline.BatcherOptions{
+		PipelineName:   params.PipelineName,
+		OutputType:     outPluginType,
+		OutFn:          p.out,
+		Controller:     p.controller,
+		Workers:        p.config.MaxInFlight_,
+		BatchSizeCount: p.config.BatchSize_,
+		BatchSizeBytes: p.config.BatchSizeBytes_,
+		FlushTimeout:   p.config.BatchFlushTimeout_,
+		MaxEventAge:    params.PipelineSettings.MaxEventAge,
+		CircuitBreaker: params.PipelineSettings.CircuitBreaker,
+		Health:         params.Health,
+		ErrorReporter:  params.ErrorReporter,
+		DeadLetter:     params.DeadLetter,
+		MetricCtl:      params.MetricCtl,
+		Retry: pipeline.BatcherRetry{
+			MaxRetries:        p.config.Retry,
+			InitialBackoff:    p.config.Retention_,
+			BackoffMultiplier: 2,
+			MaxBackoff:        p.config.MaxRetention_,
+		},
+		FatalOnFailedInsert: p.config.FatalOnFailedInsert,
+	})
+
+	p.batcher.Start(context.TODO())
+}
+
+func (p *Plugin) newClient() *client {
+	endpoint := p.config.Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://kinesis.%s.amazonaws.com/", p.config.Region)
+	}
+
+	return &client{
+		http:     &http.Client{Timeout: p.config.RequestTimeout_},
+		endpoint: endpoint,
+		region:   p.config.Region,
+		stream:   p.config.Stream,
+		creds: aws.Credentials{
+			AccessKeyID:     valueOrEnv(p.config.AccessKey, "AWS_ACCESS_KEY_ID"),
+			SecretAccessKey: valueOrEnv(p.config.SecretKey, "AWS_SECRET_ACCESS_KEY"),
+			SessionToken:    valueOrEnv(p.config.SessionToken, "AWS_SESSION_TOKEN"),
+		},
+		signer: v4.NewSigner(),
+		now:    time.Now,
+	}
+}
+
+func valueOrEnv(value, env string) string {
+	if value != "" {
+		return value
+	}
+	return os.Getenv(env)
+}
+
+func (p *Plugin) registerMetrics(ctl *metric.Ctl) {
+	p.requestErrorsMetric = ctl.RegisterCounter("output_kinesis_request_errors_total", "Number of failed PutRecords requests")
+	p.failedRecordsMetric = ctl.RegisterCounter("output_kinesis_failed_records_total",
+		"Number of records rejected by Kinesis and retried by the error code",
+		"code",
+	)
+	p.droppedRecordsMetric = ctl.RegisterCounter("output_kinesis_dropped_records_total", "Number of records larger than 1MB which are dropped")
+}
+
+func (p *Plugin) Stop() {
+	p.batcher.Stop()
+}
+
+func (p *Plugin) Out(event *pipeline.Event) {
+	p.batcher.Add(event)
+}
+
+// out puts the batch by the requests in order. If the request fails and nothing is put yet, the error is returned,
+// so the whole batch is retried, otherwise the events of the request and the rest of the batch are marked as failed.
+// The events of the records rejected by Kinesis are marked as failed too.
+func (p *Plugin) out(workerData *pipeline.WorkerData, batch *pipeline.Batch) error {
+	if *workerData == nil {
+		*workerData = &data{
+			buf: make([]byte, 0, p.config.BatchSize_*p.avgEventSize),
+		}
+	}
+
+	data := (*workerData).(*data)
+	// handle too much memory consumption
+	if cap(data.buf) > p.config.BatchSize_*p.avgEventSize {
+		data.buf = make([]byte, 0, p.config.BatchSize_*p.avgEventSize)
+	}
+
+	data.buf = data.buf[:0]
+	data.pending = data.pending[:0]
+	size := 0
+	put := false
+	var err error
+
+	// flush puts the pending records, their events are marked as failed if the request fails after something is put
+	flush := func() {
+		if err = p.put(data, batch); err == nil {
+			put = true
+			return
+		}
+		if put {
+			for _, pd := range data.pending {
+				batch.MarkFailed(pd.event)
+			}
+		}
+	}
+
+	batch.ForEach(func(event *pipeline.Event) bool {
+		if err != nil {
+			batch.MarkFailed(event)
+			return true
+		}
+
+		begin := len(data.buf)
+		data.buf = event.Root.Encode(data.buf)
+		mid := len(data.buf)
+		data.buf = p.appendPartitionKey(data.buf, event)
+		recordSize := len(data.buf) - begin
+
+		if recordSize > maxRecordSize {
+			p.droppedRecordsMetric.WithLabelValues().Inc()
+			batch.MarkRejected(event, fmt.Sprintf("record of %d bytes is larger than %d bytes", recordSize, maxRecordSize))
+			data.buf = data.buf[:begin]
+			return true
+		}
+
+		if len(data.pending) == maxRecords || size+recordSize > maxRequestSize {
+			flush()
+			size = 0
+			if err != nil {
+				if !put {
+					return false
+				}
+				batch.MarkFailed(event)
+				return true
+			}
+		}
+
+		data.pending = append(data.pending, pending{event: event, begin: begin, mid: mid, end: len(data.buf)})
+		size += recordSize
+		return true
+	})
+
+	if err == nil && len(data.pending) > 0 {
+		flush()
+	}
+	if err != nil && !put {
+		return err
+	}
+
+	return nil
+}
+
+// put sends the pending records, the events of the records rejected by Kinesis are marked as failed
+func (p *Plugin) put(data *data, batch *pipeline.Batch) error {
+	data.records = data.records[:0]
+	for _, pd := range data.pending {
+		data.records = append(data.records, record{data: data.buf[pd.begin:pd.mid], key: data.buf[pd.mid:pd.end]})
+	}
+	data.body = p.client.encodeRequest(data.body[:0], data.records)
+
+	results, err := p.client.putRecords(context.Background(), data.body, len(data.records))
+	if err != nil {
+		p.requestErrorsMetric.WithLabelValues().Inc()
+		p.logger.Errorf("can't put records to kinesis stream=%s: %s", p.config.Stream, err.Error())
+		return err
+	}
+
+	for i := range results {
+		if results[i].ErrorCode == "" {
+			continue
+		}
+		p.failedRecordsMetric.WithLabelValues(results[i].ErrorCode).Inc()
+		batch.MarkFailed(data.pending[i].event)
+	}
+	data.pending = data.pending[:0]
+
+	return nil
+}
+
+// appendPartitionKey appends the rendered partition key or the random one
+func (p *Plugin) appendPartitionKey(out []byte, event *pipeline.Event) []byte {
+	l := len(out)
+	if len(p.partitionKey) > 0 {
+		var ok bool
+		if out, ok = cfg.AppendSubstitution(out, p.partitionKey, event.Root, cfg.SubstitutionEscapeNone); ok && len(out) > l {
+			return truncate(out, l+maxPartitionKeySize)
+		}
+		out = out[:l]
+	}
+	return strconv.AppendUint(out, rand.Uint64(), 36)
+}
+
+// truncate cuts the buffer to the size keeping the last rune whole
+func truncate(out []byte, size int) []byte {
+	if len(out) <= size {
+		return out
+	}
+	for size > 0 && !utf8.RuneStart(out[size]) {
+		size--
+	}
+	return out[:size]
+}
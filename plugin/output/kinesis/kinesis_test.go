@@ -0,0 +1,177 @@
+package kinesis
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ozontech/file.d/pipeline"
+	"github.com/ozontech/file.d/test"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	insaneJSON "github.com/vitkovskii/insane-json"
+)
+
+type putRecordsRequest struct {
+	StreamName string `json:"StreamName"`
+	Records    []struct {
+		Data         string `json:"Data"`
+		PartitionKey string `json:"PartitionKey"`
+	} `json:"Records"`
+}
+
+// fakeKinesis throttles the records containing "throttled" on the first attempt
+// and fails the requests with the status while it's set
+type fakeKinesis struct {
+	mu       sync.Mutex
+	status   int
+	requests []putRecordsRequest
+	attempts map[string]int
+}
+
+func (f *fakeKinesis) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r.Header.Get("X-Amz-Target") != target || !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=key/") {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if f.status != 0 {
+		w.WriteHeader(f.status)
+		_, _ = w.Write([]byte(`{"__type":"InternalFailure","message":"try again"}`))
+		return
+	}
+
+	req := putRecordsRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	f.requests = append(f.requests, req)
+
+	resp := putRecordsResponse{}
+	for _, rec := range req.Records {
+		data, _ := base64.StdEncoding.DecodeString(rec.Data)
+		f.attempts[string(data)]++
+		if strings.Contains(string(data), "throttled") && f.attempts[string(data)] == 1 {
+			resp.FailedRecordCount++
+			resp.Records = append(resp.Records, recordResult{ErrorCode: "ProvisionedThroughputExceededException", ErrorMessage: "slow down"})
+			continue
+		}
+		resp.Records = append(resp.Records, recordResult{})
+	}
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+func startPlugin(t *testing.T, config *Config, params *pipeline.OutputPluginParams) (*Plugin, *fakeKinesis) {
+	fake := &fakeKinesis{attempts: map[string]int{}}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	config.Stream = "logs"
+	config.Endpoint = server.URL
+	config.AccessKey = "key"
+	config.SecretKey = "secret"
+	p := &Plugin{}
+	test.StartOutputPlugin(t, p, config, params)
+
+	return p, fake
+}
+
+type controller struct {
+	mu      sync.Mutex
+	commits []string
+}
+
+func (c *controller) Commit(event *pipeline.Event) {
+	c.mu.Lock()
+	c.commits = append(c.commits, event.Root.EncodeToString())
+	c.mu.Unlock()
+}
+
+func (c *controller) Error(err string) {
+	panic(err)
+}
+
+func TestOutFailedRecords(t *testing.T) {
+	ctl := &controller{}
+	params := test.NewEmptyOutputPluginParams()
+	params.Controller = ctl
+	p, fake := startPlugin(t, &Config{
+		PartitionKey:      "${service}",
+		BatchSize:         "3",
+		BatchFlushTimeout: "1h",
+		Retention:         "10ms",
+	}, params)
+
+	events := []string{`{"service":"a","message":"put"}`, `{"service":"b","message":"throttled"}`, `{"message":"no key"}`}
+	for _, e := range events {
+		root, err := insaneJSON.DecodeString(e)
+		require.NoError(t, err)
+		p.Out(&pipeline.Event{Root: root})
+	}
+
+	require.Eventually(t, func() bool {
+		ctl.mu.Lock()
+		defer ctl.mu.Unlock()
+		return len(ctl.commits) == len(events)
+	}, 5*time.Second, 10*time.Millisecond)
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+
+	// only the throttled record is sent again
+	require.Len(t, fake.requests, 2)
+	assert.Equal(t, "logs", fake.requests[0].StreamName)
+	assert.Len(t, fake.requests[0].Records, 3)
+	assert.Equal(t, "a", fake.requests[0].Records[0].PartitionKey)
+	assert.NotEmpty(t, fake.requests[0].Records[2].PartitionKey)
+	assert.Len(t, fake.requests[1].Records, 1)
+	assert.Equal(t, "b", fake.requests[1].Records[0].PartitionKey)
+	assert.Equal(t, 2, fake.attempts[events[1]])
+}
+
+func TestOutSplit(t *testing.T) {
+	p, fake := startPlugin(t, &Config{}, test.NewEmptyOutputPluginParams())
+
+	events := make([]string, 0, maxRecords+1)
+	for i := 0; i < maxRecords; i++ {
+		events = append(events, `{"a":1}`)
+	}
+	events = append(events, `{"message":"`+strings.Repeat("x", maxRecordSize)+`"}`, `{"b":2}`)
+	batch := test.NewBatch(t, events...)
+
+	workerData := pipeline.WorkerData(nil)
+	require.NoError(t, p.out(&workerData, batch))
+
+	// the record larger than 1MB is dropped, the rest is split by the records limit
+	require.Len(t, fake.requests, 2)
+	assert.Len(t, fake.requests[0].Records, maxRecords)
+	assert.Len(t, fake.requests[1].Records, 1)
+	assert.Equal(t, float64(1), testutil.ToFloat64(p.droppedRecordsMetric.WithLabelValues()))
+}
+
+func TestOutRequestError(t *testing.T) {
+	p, fake := startPlugin(t, &Config{}, test.NewEmptyOutputPluginParams())
+	fake.status = http.StatusInternalServerError
+
+	workerData := pipeline.WorkerData(nil)
+	assert.Error(t, p.out(&workerData, test.NewBatch(t, `{"a":1}`)))
+
+	fake.status = 0
+	assert.NoError(t, p.out(&workerData, test.NewBatch(t, `{"a":1}`)))
+	assert.Len(t, fake.requests, 1)
+}
+
+func TestTruncate(t *testing.T) {
+	assert.Equal(t, "abc", string(truncate([]byte("abc"), 5)))
+	assert.Equal(t, "ab", string(truncate([]byte("abc"), 2)))
+	assert.Equal(t, "a", string(truncate([]byte("aпр"), 2)))
+}
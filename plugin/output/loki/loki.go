@@ -0,0 +1,524 @@
+// Package loki is an output plugin that sends events to Grafana Loki.
+package loki
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/ozontech/file.d/cfg"
+	"github.com/ozontech/file.d/fd"
+	"github.com/ozontech/file.d/metric"
+	"github.com/ozontech/file.d/pipeline"
+	"github.com/prometheus/client_golang/prometheus"
+	insaneJSON "github.com/vitkovskii/insane-json"
+	"go.uber.org/zap"
+)
+
+/*{ introduction
+It sends events to Grafana Loki using the push API.
+The events are grouped to the streams by the labels which are taken from the event fields and the static labels,
+the label fields are removed from the event and the rest of the event is the log line.
+The events without the label field are sent without this label.
+
+The payload can be encoded in JSON, optionally compressed by gzip, or in protobuf compressed by snappy.
+The batch is retried if Loki responds with `429` or `5xx` status, other errors of the batch are logged and the batch is dropped.
+
+**Example:**
+```yaml
+pipelines:
+  example_pipeline:
+    ...
+    output:
+      type: loki
+      endpoint: http://loki:3100/loki/api/v1/push
+      label_fields: [k8s_namespace, k8s_pod, level]
+      static_labels:
+        cluster: prod
+      tenant_id: team-a
+      format: protobuf
+      out_of_order: sort
+    ...
+```
+The event `{"k8s_namespace":"payments","k8s_pod":"api-1","level":"error","message":"timeout","time":"2023-11-14T22:13:20Z"}`
+is sent to the stream `{cluster="prod", k8s_namespace="payments", k8s_pod="api-1", level="error"}`
+with the line `{"time":"2023-11-14T22:13:20Z","message":"timeout"}`.
+}*/
+
+const (
+	outPluginType = "loki"
+)
+
+const (
+	formatJSON = iota
+	formatProtobuf
+)
+
+const (
+	outOfOrderPass = iota
+	outOfOrderSort
+	outOfOrderClamp
+)
+
+var labelNameRe = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+type Plugin struct {
+	config       *Config
+	client       http.Client
+	logger       *zap.SugaredLogger
+	avgEventSize int
+	batcher      *pipeline.Batcher
+	controller   pipeline.OutputPluginController
+
+	labels     []labelConfig
+	authHeader string
+	timeFormat string
+
+	// lastTimes are the last sent times of the streams for the `clamp` out of order mode
+	lastTimes   map[string]int64
+	lastTimesMu sync.Mutex
+
+	// plugin metrics
+
+	sendErrorMetric    *prometheus.CounterVec
+	droppedBatchMetric *prometheus.CounterVec
+}
+
+// labelConfig is the label taken from the event field or the static one if the field is empty
+type labelConfig struct {
+	name  string
+	field []string
+	value string
+}
+
+// ! config-params
+// ^ config-params
+type Config struct {
+	// > @3@4@5@6
+	// >
+	// > A full URI of the push API. Format: `http://127.0.0.1:3100/loki/api/v1/push`.
+	Endpoint string `json:"endpoint" required:"true"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The event fields which are sent as the labels, e.g. `k8s_namespace` or `kubernetes.pod`.
+	// > The label name is the field selector with all the characters except letters, digits and `_` replaced by `_`.
+	LabelFields []string `json:"label_fields"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The labels which are added to all the streams.
+	StaticLabels map[string]string `json:"static_labels"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The max number of labels of the stream. The plugin fails to start if there are more labels,
+	// > so the streams aren't rejected by Loki.
+	MaxLabels int `json:"max_labels" default:"15"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The event field which contains the time of the entry. If it's missing or doesn't fit `time_format`,
+	// > the current time is used.
+	TimeField  cfg.FieldSelector `json:"time_field" parse:"selector" default:"time"` // *
+	TimeField_ []string
+
+	// > @3@4@5@6
+	// >
+	// > The format of `time_field`, it's a layout or one of the aliases of the `convert_date` plugin.
+	TimeFormat string `json:"time_format" default:"rfc3339nano"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The encoding of the payload. The protobuf payload is always compressed by snappy.
+	Format  string `json:"format" default:"json" options:"json|protobuf"` // *
+	Format_ int
+
+	// > @3@4@5@6
+	// >
+	// > The compression of the JSON payload.
+	Compression string `json:"compression" default:"none" options:"none|gzip"` // *
+
+	// > @3@4@5@6
+	// >
+	// > How to deal with the entries which are older than the previous ones of the stream:
+	// > * `pass` – send the entries as is, it's for Loki which accepts the unordered writes
+	// > * `sort` – sort the entries of each stream within the batch
+	// > * `clamp` – sort the entries and set the time of the entry which is older than the last sent one of the stream
+	// > to the time of the last sent one, so Loki which rejects the unordered writes accepts it
+	OutOfOrder  string `json:"out_of_order" default:"pass" options:"pass|sort|clamp"` // *
+	OutOfOrder_ int
+
+	// > @3@4@5@6
+	// >
+	// > The tenant which is sent in the `X-Scope-OrgID` header.
+	TenantID string `json:"tenant_id"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The username of the basic auth.
+	Username string `json:"username"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The password of the basic auth.
+	Password string `json:"password"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The bearer token. If it's set, the basic auth isn't used.
+	BearerToken string `json:"bearer_token"` // *
+
+	// > @3@4@5@6
+	// >
+	// > How many workers will be instantiated to send batches.
+	WorkersCount  cfg.Expression `json:"workers_count" default:"gomaxprocs*4" parse:"expression"` // *
+	WorkersCount_ int
+
+	// > @3@4@5@6
+	// >
+	// > Client timeout when sends requests to Loki.
+	RequestTimeout  cfg.Duration `json:"request_timeout" default:"10s" parse:"duration"` // *
+	RequestTimeout_ time.Duration
+
+	// > @3@4@5@6
+	// >
+	// > A maximum quantity of events to pack into one batch.
+	BatchSize  cfg.Expression `json:"batch_size" default:"capacity/4" parse:"expression"` // *
+	BatchSize_ int
+
+	// > @3@4@5@6
+	// >
+	// > A minimum size of events in a batch to send.
+	// > If both batch_size and batch_size_bytes are set, they will work together.
+	BatchSizeBytes  cfg.Expression `json:"batch_size_bytes" default:"0" parse:"expression"` // *
+	BatchSizeBytes_ int
+
+	// > @3@4@5@6
+	// >
+	// > After this timeout the batch will be sent even if batch isn't completed.
+	BatchFlushTimeout  cfg.Duration `json:"batch_flush_timeout" default:"200ms" parse:"duration"` // *
+	BatchFlushTimeout_ time.Duration
+
+	// > @3@4@5@6
+	// >
+	// > Retries of the batch sending.
+	Retry int `json:"retry" default:"10"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The delay before the first retry, it's doubled after each retry.
+	Retention  cfg.Duration `json:"retention" default:"1s" parse:"duration"` // *
+	Retention_ time.Duration
+
+	// > @3@4@5@6
+	// >
+	// > The max delay between the retries.
+	MaxRetention  cfg.Duration `json:"max_retention" default:"1m" parse:"duration"` // *
+	MaxRetention_ time.Duration
+
+	// > @3@4@5@6
+	// >
+	// > Exit with the non-zero code if the batch isn't sent after all the retries, otherwise the batch is dropped.
+	FatalOnFailedInsert bool `json:"fatal_on_failed_insert" default:"false"` // *
+}
+
+type data struct {
+	root      *insaneJSON.Root
+	payload   *payload
+	labels    []label
+	keyBuf    []byte
+	lineBuf   []byte
+	outBuf    []byte
+	protoBuf  []byte
+	snappyBuf []byte
+}
+
+func init() {
+	fd.DefaultPluginRegistry.RegisterOutput(&pipeline.PluginStaticInfo{
+		Type:    outPluginType,
+		Factory: Factory,
+	})
+}
+
+func Factory() (pipeline.AnyPlugin, pipeline.AnyConfig) {
+	return &Plugin{}, &Config{}
+}
+
+func (p *Plugin) Start(config pipeline.AnyConfig, params *pipeline.OutputPluginParams) {
+	p.controller = params.Controller
+	p.logger = params.Logger
+	p.avgEventSize = params.PipelineSettings.AvgEventSize
+	p.config = config.(*Config)
+	p.registerMetrics(params.MetricCtl)
+	p.client = http.Client{Timeout: p.config.RequestTimeout_}
+
+	if err := p.prepare(); err != nil {
+		p.logger.Fatal(err.Error())
+	}
+
+	var compression pipeline.BatchCompression
+	if p.config.Format_ == formatJSON {
+		compression = pipeline.BatchCompression(p.config.Compression)
+	}
+
+	p.batcher = pipeline.NewBatcher(pipeline.BatcherOptions{
+		PipelineName:   params.PipelineName,
+		OutputType:     outPluginType,
+		OutFn:          p.out,
+		Controller:     p.controller,
+		Workers:        p.config.WorkersCount_,
+		BatchSizeCount: p.config.BatchSize_,
+		BatchSizeBytes: p.config.BatchSizeBytes_,
+		FlushTimeout:   p.config.BatchFlushTimeout_,
+		MaxEventAge:    params.PipelineSettings.MaxEventAge,
+		CircuitBreaker: params.PipelineSettings.CircuitBreaker,
+		Health:         params.Health,
+		ErrorReporter:  params.ErrorReporter,
+		DeadLetter:     params.DeadLetter,
+		MetricCtl:      params.MetricCtl,
+		Compression:    compression,
+		Retry: pipeline.BatcherRetry{
+			MaxRetries:        p.config.Retry,
+			InitialBackoff:    p.config.Retention_,
+			BackoffMultiplier: 2,
+			MaxBackoff:        p.config.MaxRetention_,
+		},
+		FatalOnFailedInsert: p.config.FatalOnFailedInsert,
+	})
+
+	p.batcher.Start(context.TODO())
+}
+
+// prepare checks the labels and builds the values which are the same for all the batches
+func (p *Plugin) prepare() error {
+	p.labels = make([]labelConfig, 0, len(p.config.LabelFields)+len(p.config.StaticLabels))
+	names := make(map[string]bool)
+	for _, field := range p.config.LabelFields {
+		name := labelNameRe.ReplaceAllString(field, "_")
+		if names[name] {
+			return fmt.Errorf("duplicate label %s", name)
+		}
+		names[name] = true
+		p.labels = append(p.labels, labelConfig{name: name, field: cfg.ParseFieldSelector(field)})
+	}
+	for name, value := range p.config.StaticLabels {
+		if labelNameRe.MatchString(name) {
+			return fmt.Errorf("wrong label name %q, it should contain only letters, digits and _", name)
+		}
+		if names[name] {
+			return fmt.Errorf("duplicate label %s", name)
+		}
+		names[name] = true
+		p.labels = append(p.labels, labelConfig{name: name, value: value})
+	}
+	if len(p.labels) > p.config.MaxLabels {
+		return fmt.Errorf("too many labels: %d, max_labels is %d", len(p.labels), p.config.MaxLabels)
+	}
+	sort.Slice(p.labels, func(i, j int) bool {
+		return p.labels[i].name < p.labels[j].name
+	})
+
+	p.timeFormat = p.config.TimeFormat
+	if format, err := pipeline.ParseFormatName(p.config.TimeFormat); err == nil {
+		p.timeFormat = format
+	}
+
+	if p.config.BearerToken != "" {
+		p.authHeader = "Bearer " + p.config.BearerToken
+	} else if p.config.Username != "" {
+		credentials := p.config.Username + ":" + p.config.Password
+		p.authHeader = "Basic " + base64.StdEncoding.EncodeToString([]byte(credentials))
+	}
+
+	p.lastTimes = make(map[string]int64)
+
+	return nil
+}
+
+func (p *Plugin) registerMetrics(ctl *metric.Ctl) {
+	p.sendErrorMetric = ctl.RegisterCounter("output_loki_send_error", "Total Loki send errors")
+	p.droppedBatchMetric = ctl.RegisterCounter("output_loki_dropped_batches_total", "Number of batches rejected by Loki")
+}
+
+func (p *Plugin) Stop() {
+	p.batcher.Stop()
+}
+
+func (p *Plugin) Out(event *pipeline.Event) {
+	p.batcher.Add(event)
+}
+
+func (p *Plugin) out(workerData *pipeline.WorkerData, batch *pipeline.Batch) error {
+	if *workerData == nil {
+		*workerData = &data{
+			root:    insaneJSON.Spawn(),
+			payload: newPayload(),
+			labels:  make([]label, 0, len(p.labels)),
+			outBuf:  make([]byte, 0, p.config.BatchSize_*p.avgEventSize),
+		}
+	}
+
+	data := (*workerData).(*data)
+	// handle too much memory consumption
+	if cap(data.outBuf) > p.config.BatchSize_*p.avgEventSize {
+		data.outBuf = make([]byte, 0, p.config.BatchSize_*p.avgEventSize)
+	}
+
+	data.payload.reset()
+	batch.ForEach(func(event *pipeline.Event) bool {
+		p.appendEvent(data, event)
+		return true
+	})
+
+	if p.config.OutOfOrder_ != outOfOrderPass {
+		data.payload.sortEntries()
+	}
+	if p.config.OutOfOrder_ == outOfOrderClamp {
+		p.clampTimes(data.payload)
+	}
+
+	body, err := p.encode(data, batch)
+	if err != nil {
+		return err
+	}
+
+	retry, err := p.send(body, batch.Compression())
+	if err == nil {
+		return nil
+	}
+	p.sendErrorMetric.WithLabelValues().Inc()
+	if !retry {
+		p.droppedBatchMetric.WithLabelValues().Inc()
+		reason := fmt.Sprintf("batch is rejected by loki address=%s: %s", p.config.Endpoint, err.Error())
+		batch.ForEach(func(event *pipeline.Event) bool {
+			batch.MarkRejected(event, reason)
+			return true
+		})
+		return nil
+	}
+	p.logger.Errorf("can't send data to loki address=%s: %s", p.config.Endpoint, err.Error())
+
+	return err
+}
+
+// appendEvent adds the event to the stream of its labels, the label fields are removed from the copy of the event
+// since the event can be sent again
+func (p *Plugin) appendEvent(data *data, event *pipeline.Event) {
+	data.labels = data.labels[:0]
+	for i := range p.labels {
+		l := &p.labels[i]
+		if len(l.field) == 0 {
+			data.labels = append(data.labels, label{name: l.name, value: l.value})
+			continue
+		}
+		node := event.Root.Dig(l.field...)
+		if node == nil || node.AsString() == "" {
+			continue
+		}
+		data.labels = append(data.labels, label{name: l.name, value: node.AsString()})
+	}
+
+	ts := time.Now().UnixNano()
+	if node := event.Root.Dig(p.config.TimeField_...); node != nil {
+		if t, err := pipeline.ParseTime(p.timeFormat, node.AsString()); err == nil {
+			ts = t.UnixNano()
+		}
+	}
+
+	data.lineBuf = event.Root.Encode(data.lineBuf[:0])
+	if len(p.config.LabelFields) != 0 {
+		if err := data.root.DecodeBytes(data.lineBuf); err == nil {
+			for i := range p.labels {
+				if len(p.labels[i].field) != 0 {
+					data.root.Dig(p.labels[i].field...).Suicide()
+				}
+			}
+			data.lineBuf = data.root.Encode(data.lineBuf[:0])
+		}
+	}
+
+	data.keyBuf = appendLabelsKey(data.keyBuf[:0], data.labels)
+	data.payload.add(data.keyBuf, data.labels, ts, data.lineBuf)
+}
+
+// clampTimes sets the time of the entries which are older than the last sent one of the stream to that time
+func (p *Plugin) clampTimes(payload *payload) {
+	p.lastTimesMu.Lock()
+	defer p.lastTimesMu.Unlock()
+
+	for _, s := range payload.order {
+		last, has := p.lastTimes[s.key]
+		for i := range s.entries {
+			if has && s.entries[i].ts < last {
+				s.entries[i].ts = last
+			}
+			last = s.entries[i].ts
+			has = true
+		}
+		p.lastTimes[s.key] = last
+	}
+}
+
+func (p *Plugin) encode(data *data, batch *pipeline.Batch) ([]byte, error) {
+	if p.config.Format_ == formatProtobuf {
+		data.outBuf, data.protoBuf = data.payload.encodeProtobuf(data.outBuf[:0], data.protoBuf)
+		data.snappyBuf = snappy.Encode(data.snappyBuf[:cap(data.snappyBuf)], data.outBuf)
+		return data.snappyBuf, nil
+	}
+
+	data.outBuf = data.payload.encodeJSON(data.outBuf[:0])
+	body, err := batch.Compress(data.outBuf)
+	if err != nil {
+		return nil, fmt.Errorf("can't compress batch: %w", err)
+	}
+	return body, nil
+}
+
+// send returns whether the request should be retried if it fails
+func (p *Plugin) send(body []byte, compression pipeline.BatchCompression) (bool, error) {
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, p.config.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return false, fmt.Errorf("can't create request: %w", err)
+	}
+
+	if p.config.Format_ == formatProtobuf {
+		req.Header.Set("Content-Type", "application/x-protobuf")
+	} else {
+		req.Header.Set("Content-Type", "application/json")
+		if compression != pipeline.BatchCompressionNone {
+			req.Header.Set("Content-Encoding", string(compression))
+		}
+	}
+	if p.config.TenantID != "" {
+		req.Header.Set("X-Scope-OrgID", p.config.TenantID)
+	}
+	if p.authHeader != "" {
+		req.Header.Set("Authorization", p.authHeader)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return true, fmt.Errorf("can't send request: %w", err)
+	}
+	defer func(Body io.ReadCloser) {
+		_ = Body.Close()
+	}(resp.Body)
+
+	if resp.StatusCode >= http.StatusOK && resp.StatusCode < http.StatusMultipleChoices {
+		return false, nil
+	}
+
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= http.StatusInternalServerError
+
+	return retry, fmt.Errorf("response status is %s: %s", resp.Status, bytes.TrimSpace(b))
+}
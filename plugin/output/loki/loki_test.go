@@ -0,0 +1,175 @@
+package loki
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/ozontech/file.d/pipeline"
+	"github.com/ozontech/file.d/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+type request struct {
+	header http.Header
+	body   []byte
+}
+
+func startPlugin(t *testing.T, config *Config, status int) (*Plugin, chan request) {
+	requests := make(chan request, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		requests <- request{header: r.Header, body: body}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+
+	config.Endpoint = server.URL
+	p := &Plugin{}
+	test.StartOutputPlugin(t, p, config, nil)
+
+	return p, requests
+}
+
+func TestLokiJSON(t *testing.T) {
+	p, requests := startPlugin(t, &Config{
+		LabelFields:  []string{"k8s.namespace", "level"},
+		StaticLabels: map[string]string{"cluster": "prod"},
+		TenantID:     "team-a",
+		Username:     "user",
+		Password:     "pass",
+	}, http.StatusNoContent)
+
+	batch := test.NewBatch(t,
+		`{"k8s":{"namespace":"payments","pod":"api-1"},"level":"error","message":"timeout","time":"2023-11-14T22:13:20Z"}`,
+		`{"k8s":{"namespace":"payments"},"message":"no level","time":"2023-11-14T22:13:21.5Z"}`,
+		`{"k8s":{"namespace":"payments"},"level":"error","message":"a \"quoted\"\nline","time":"2023-11-14T22:13:22Z"}`,
+	)
+
+	data := pipeline.WorkerData(nil)
+	require.NoError(t, p.out(&data, batch))
+
+	req := <-requests
+	assert.Equal(t, "application/json", req.header.Get("Content-Type"))
+	assert.Equal(t, "team-a", req.header.Get("X-Scope-OrgID"))
+	assert.Equal(t, "Basic dXNlcjpwYXNz", req.header.Get("Authorization"))
+	assert.Equal(t, `{"streams":[`+
+		`{"stream":{"cluster":"prod","k8s_namespace":"payments","level":"error"},"values":[`+
+		`["1700000000000000000","{\"k8s\":{\"pod\":\"api-1\"},\"time\":\"2023-11-14T22:13:20Z\",\"message\":\"timeout\"}"],`+
+		`["1700000002000000000","{\"k8s\":{},\"time\":\"2023-11-14T22:13:22Z\",\"message\":\"a \\\"quoted\\\"\\nline\"}"]]},`+
+		`{"stream":{"cluster":"prod","k8s_namespace":"payments"},"values":[`+
+		`["1700000001500000000","{\"k8s\":{},\"message\":\"no level\",\"time\":\"2023-11-14T22:13:21.5Z\"}"]]}]}`,
+		string(req.body))
+
+	// the events aren't changed, so they can be sent again
+	assert.Equal(t, `{"k8s":{"namespace":"payments"},"message":"no level","time":"2023-11-14T22:13:21.5Z"}`, batch.Events[1].Root.EncodeToString())
+}
+
+func TestLokiProtobuf(t *testing.T) {
+	p, requests := startPlugin(t, &Config{
+		LabelFields: []string{"app"},
+		Format:      "protobuf",
+		BearerToken: "token",
+	}, http.StatusNoContent)
+
+	data := pipeline.WorkerData(nil)
+	require.NoError(t, p.out(&data, test.NewBatch(t, `{"app":"api","message":"hello","time":"2023-11-14T22:13:20.000000001Z"}`)))
+
+	req := <-requests
+	assert.Equal(t, "application/x-protobuf", req.header.Get("Content-Type"))
+	assert.Equal(t, "Bearer token", req.header.Get("Authorization"))
+
+	body, err := snappy.Decode(nil, req.body)
+	require.NoError(t, err)
+
+	// PushRequest.streams
+	streamMsg := consumeBytes(t, body, 1)
+	// StreamAdapter.labels and StreamAdapter.entries
+	assert.Equal(t, `{app="api"}`, string(consumeBytes(t, streamMsg, 1)))
+	entryMsg := consumeBytes(t, streamMsg[protowire.SizeTag(1)+protowire.SizeBytes(len(`{app="api"}`)):], 2)
+	// EntryAdapter.timestamp and EntryAdapter.line
+	timestampMsg := consumeBytes(t, entryMsg, 1)
+	line := consumeBytes(t, entryMsg[protowire.SizeTag(1)+protowire.SizeBytes(len(timestampMsg)):], 2)
+	assert.Equal(t, `{"time":"2023-11-14T22:13:20.000000001Z","message":"hello"}`, string(line))
+
+	seconds, n := protowire.ConsumeVarint(timestampMsg[protowire.SizeTag(1):])
+	require.Greater(t, n, 0)
+	nanos, _ := protowire.ConsumeVarint(timestampMsg[protowire.SizeTag(1)+n+protowire.SizeTag(2):])
+	assert.Equal(t, uint64(1700000000), seconds)
+	assert.Equal(t, uint64(1), nanos)
+}
+
+func consumeBytes(t *testing.T, b []byte, field protowire.Number) []byte {
+	num, typ, n := protowire.ConsumeTag(b)
+	require.Greater(t, n, 0)
+	require.Equal(t, field, num)
+	require.Equal(t, protowire.BytesType, typ)
+	v, m := protowire.ConsumeBytes(b[n:])
+	require.Greater(t, m, 0)
+	return v
+}
+
+func TestLokiOutOfOrder(t *testing.T) {
+	p, requests := startPlugin(t, &Config{OutOfOrder: "clamp"}, http.StatusNoContent)
+
+	data := pipeline.WorkerData(nil)
+	require.NoError(t, p.out(&data, test.NewBatch(t,
+		`{"message":"2","time":"2023-11-14T22:13:22Z"}`,
+		`{"message":"1","time":"2023-11-14T22:13:21Z"}`,
+	)))
+	assert.Equal(t, `{"streams":[{"stream":{},"values":[`+
+		`["1700000001000000000","{\"message\":\"1\",\"time\":\"2023-11-14T22:13:21Z\"}"],`+
+		`["1700000002000000000","{\"message\":\"2\",\"time\":\"2023-11-14T22:13:22Z\"}"]]}]}`,
+		string((<-requests).body))
+
+	// the entry older than the last sent one gets its time
+	require.NoError(t, p.out(&data, test.NewBatch(t, `{"message":"0","time":"2023-11-14T22:13:20Z"}`)))
+	assert.Equal(t, `{"streams":[{"stream":{},"values":[`+
+		`["1700000002000000000","{\"message\":\"0\",\"time\":\"2023-11-14T22:13:20Z\"}"]]}]}`,
+		string((<-requests).body))
+}
+
+func TestLokiErrors(t *testing.T) {
+	cases := []struct {
+		status  int
+		wantErr bool
+	}{
+		{status: http.StatusBadRequest, wantErr: false},
+		{status: http.StatusTooManyRequests, wantErr: true},
+		{status: http.StatusServiceUnavailable, wantErr: true},
+	}
+
+	for _, tt := range cases {
+		t.Run(http.StatusText(tt.status), func(t *testing.T) {
+			p, requests := startPlugin(t, &Config{}, tt.status)
+
+			data := pipeline.WorkerData(nil)
+			err := p.out(&data, test.NewBatch(t, `{"message":"hello"}`))
+			<-requests
+			assert.Equal(t, tt.wantErr, err != nil)
+		})
+	}
+}
+
+func TestLokiMaxLabels(t *testing.T) {
+	config := &Config{
+		Endpoint:     "http://127.0.0.1:3100/loki/api/v1/push",
+		LabelFields:  []string{"a", "b"},
+		StaticLabels: map[string]string{"c": "c"},
+		MaxLabels:    2,
+	}
+	test.NewConfig(config, map[string]int{"gomaxprocs": 1, "capacity": 64})
+	p := &Plugin{config: config}
+	assert.Error(t, p.prepare())
+
+	config.MaxLabels = 3
+	assert.NoError(t, p.prepare())
+
+	config.LabelFields = []string{"a.b", "a_b"}
+	assert.Error(t, p.prepare())
+}
@@ -0,0 +1,184 @@
+package loki
+
+import (
+	"sort"
+	"strconv"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// label is the label of the stream, the names are sorted
+type label struct {
+	name  string
+	value string
+}
+
+type entry struct {
+	ts int64
+	// start and end are the offsets of the line in the lines buffer of the payload
+	start int
+	end   int
+}
+
+type stream struct {
+	labels  []label
+	key     string
+	entries []entry
+}
+
+// payload groups the entries of the batch by the streams
+type payload struct {
+	streams map[string]*stream
+	// order keeps the order of the streams, so the encoded payload is stable
+	order []*stream
+	lines []byte
+}
+
+func newPayload() *payload {
+	return &payload{
+		streams: make(map[string]*stream),
+		order:   make([]*stream, 0),
+		lines:   make([]byte, 0),
+	}
+}
+
+func (p *payload) reset() {
+	clear(p.streams)
+	clear(p.order)
+	p.order = p.order[:0]
+	p.lines = p.lines[:0]
+}
+
+// add appends the entry to the stream by the labels key, the labels are copied for the new stream only
+func (p *payload) add(key []byte, labels []label, ts int64, line []byte) *stream {
+	s, has := p.streams[string(key)]
+	if !has {
+		s = &stream{
+			labels:  append([]label(nil), labels...),
+			key:     string(key),
+			entries: make([]entry, 0),
+		}
+		p.streams[s.key] = s
+		p.order = append(p.order, s)
+	}
+
+	start := len(p.lines)
+	p.lines = append(p.lines, line...)
+	s.entries = append(s.entries, entry{ts: ts, start: start, end: len(p.lines)})
+	return s
+}
+
+func (p *payload) line(e entry) []byte {
+	return p.lines[e.start:e.end]
+}
+
+// sortEntries sorts the entries of the streams by the time keeping the order of the equal ones
+func (p *payload) sortEntries() {
+	for _, s := range p.order {
+		sort.SliceStable(s.entries, func(i, j int) bool {
+			return s.entries[i].ts < s.entries[j].ts
+		})
+	}
+}
+
+// appendLabelsKey appends the labels in the Prometheus format, e.g. `{app="api", env="prod"}`
+func appendLabelsKey(out []byte, labels []label) []byte {
+	out = append(out, '{')
+	for i, l := range labels {
+		if i != 0 {
+			out = append(out, ", "...)
+		}
+		out = append(out, l.name...)
+		out = append(out, '=')
+		out = strconv.AppendQuote(out, l.value)
+	}
+	return append(out, '}')
+}
+
+// encodeJSON encodes the payload of the push API in JSON
+func (p *payload) encodeJSON(out []byte) []byte {
+	out = append(out, `{"streams":[`...)
+	for i, s := range p.order {
+		if i != 0 {
+			out = append(out, ',')
+		}
+		out = append(out, `{"stream":{`...)
+		for j, l := range s.labels {
+			if j != 0 {
+				out = append(out, ',')
+			}
+			out = appendJSONString(out, l.name)
+			out = append(out, ':')
+			out = appendJSONString(out, l.value)
+		}
+		out = append(out, `},"values":[`...)
+		for j, e := range s.entries {
+			if j != 0 {
+				out = append(out, ',')
+			}
+			out = append(out, `["`...)
+			out = strconv.AppendInt(out, e.ts, 10)
+			out = append(out, `",`...)
+			out = appendJSONString(out, string(p.line(e)))
+			out = append(out, ']')
+		}
+		out = append(out, "]}"...)
+	}
+	return append(out, "]}"...)
+}
+
+// encodeProtobuf encodes the payload of the push API in protobuf, the message is `logproto.PushRequest`
+func (p *payload) encodeProtobuf(out, buf []byte) ([]byte, []byte) {
+	for _, s := range p.order {
+		buf = buf[:0]
+		buf = protowire.AppendTag(buf, 1, protowire.BytesType)
+		buf = protowire.AppendString(buf, s.key)
+		for _, e := range s.entries {
+			line := p.line(e)
+			timestampSize := protowire.SizeTag(1) + protowire.SizeVarint(uint64(e.ts/1e9)) +
+				protowire.SizeTag(2) + protowire.SizeVarint(uint64(e.ts%1e9))
+			entrySize := protowire.SizeTag(1) + protowire.SizeBytes(timestampSize) +
+				protowire.SizeTag(2) + protowire.SizeBytes(len(line))
+
+			buf = protowire.AppendTag(buf, 2, protowire.BytesType)
+			buf = protowire.AppendVarint(buf, uint64(entrySize))
+			buf = protowire.AppendTag(buf, 1, protowire.BytesType)
+			buf = protowire.AppendVarint(buf, uint64(timestampSize))
+			buf = protowire.AppendTag(buf, 1, protowire.VarintType)
+			buf = protowire.AppendVarint(buf, uint64(e.ts/1e9))
+			buf = protowire.AppendTag(buf, 2, protowire.VarintType)
+			buf = protowire.AppendVarint(buf, uint64(e.ts%1e9))
+			buf = protowire.AppendTag(buf, 2, protowire.BytesType)
+			buf = protowire.AppendBytes(buf, line)
+		}
+
+		out = protowire.AppendTag(out, 1, protowire.BytesType)
+		out = protowire.AppendBytes(out, buf)
+	}
+	return out, buf
+}
+
+// appendJSONString appends the quoted JSON string, the string is expected to be valid UTF-8
+func appendJSONString(out []byte, s string) []byte {
+	const hex = "0123456789abcdef"
+
+	out = append(out, '"')
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"' || c == '\\':
+			out = append(out, '\\', c)
+		case c == '\n':
+			out = append(out, '\\', 'n')
+		case c == '\r':
+			out = append(out, '\\', 'r')
+		case c == '\t':
+			out = append(out, '\\', 't')
+		case c < 0x20:
+			out = append(out, '\\', 'u', '0', '0', hex[c>>4], hex[c&0xf])
+		default:
+			out = append(out, c)
+		}
+	}
+	return append(out, '"')
+}
@@ -0,0 +1,84 @@
+package mongo
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	insaneJSON "github.com/vitkovskii/insane-json"
+	"go.mongodb.org/mongo-driver/x/bsonx/bsoncore"
+)
+
+// bsonEncoder appends the JSON nodes as BSON documents
+type bsonEncoder struct {
+	out []byte
+
+	// dateNode is encoded as the date instead of its JSON value
+	dateNode *insaneJSON.Node
+	date     time.Time
+}
+
+// document appends the fields of the object node as the document
+func (e *bsonEncoder) document(node *insaneJSON.Node) {
+	index, out := bsoncore.AppendDocumentStart(e.out)
+	e.out = out
+	e.fields(node)
+	e.out, _ = bsoncore.AppendDocumentEnd(e.out, index)
+}
+
+// keyDocument appends the document of the single key, it's the filter of the upsert
+func (e *bsonEncoder) keyDocument(key string, node *insaneJSON.Node) {
+	index, out := bsoncore.AppendDocumentStart(e.out)
+	e.out = out
+	e.node(key, node)
+	e.out, _ = bsoncore.AppendDocumentEnd(e.out, index)
+}
+
+func (e *bsonEncoder) fields(node *insaneJSON.Node) {
+	for _, field := range node.AsFields() {
+		e.node(field.AsString(), field.AsFieldValue())
+	}
+}
+
+// node appends the JSON value, the integers are int64 and the other numbers are double
+func (e *bsonEncoder) node(key string, node *insaneJSON.Node) {
+	// the key is cut at NUL since it's a C string
+	if i := strings.IndexByte(key, 0); i >= 0 {
+		key = key[:i]
+	}
+
+	switch {
+	case node == e.dateNode:
+		e.out = bsoncore.AppendDateTimeElement(e.out, key, e.date.UnixMilli())
+	case node.IsObject():
+		index, out := bsoncore.AppendDocumentElementStart(e.out, key)
+		e.out = out
+		e.fields(node)
+		e.out, _ = bsoncore.AppendDocumentEnd(e.out, index)
+	case node.IsArray():
+		index, out := bsoncore.AppendArrayElementStart(e.out, key)
+		e.out = out
+		for i, item := range node.AsArray() {
+			e.node(strconv.Itoa(i), item)
+		}
+		e.out, _ = bsoncore.AppendArrayEnd(e.out, index)
+	case node.IsString():
+		e.out = bsoncore.AppendStringElement(e.out, key, node.AsString())
+	case node.IsNumber():
+		s := node.AsString()
+		if !strings.ContainsAny(s, ".eE") {
+			if v, err := strconv.ParseInt(s, 10, 64); err == nil {
+				e.out = bsoncore.AppendInt64Element(e.out, key, v)
+				return
+			}
+		}
+		v, _ := strconv.ParseFloat(s, 64)
+		e.out = bsoncore.AppendDoubleElement(e.out, key, v)
+	case node.IsTrue():
+		e.out = bsoncore.AppendBooleanElement(e.out, key, true)
+	case node.IsFalse():
+		e.out = bsoncore.AppendBooleanElement(e.out, key, false)
+	default:
+		e.out = bsoncore.AppendNullElement(e.out, key)
+	}
+}
@@ -0,0 +1,354 @@
+package mongo
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ozontech/file.d/pipeline"
+	"github.com/ozontech/file.d/test"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	insaneJSON "github.com/vitkovskii/insane-json"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	opReply = 1
+	opQuery = 2004
+	opMsg   = 2013
+)
+
+type command struct {
+	body bson.M
+	docs []bson.M
+}
+
+// fakeMongo rejects the documents containing "reject" on the first attempt by the retryable error,
+// the documents containing "invalid" are always rejected by the validation error, the documents containing "dup"
+// are rejected as duplicates and the command fails while failCommands is set
+type fakeMongo struct {
+	listener     net.Listener
+	maxBatchSize int
+
+	mu           sync.Mutex
+	failCommands bool
+	commands     []command
+	attempts     map[string]int
+}
+
+func newFakeMongo(t *testing.T) *fakeMongo {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = l.Close() })
+
+	m := &fakeMongo{listener: l, maxBatchSize: 2, attempts: map[string]int{}}
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go m.serve(c)
+		}
+	}()
+	return m
+}
+
+// serve replies to the legacy OP_QUERY of the handshake by OP_REPLY and to OP_MSG by OP_MSG
+func (m *fakeMongo) serve(c net.Conn) {
+	defer func() { _ = c.Close() }()
+
+	r := bufio.NewReader(c)
+	for {
+		header := make([]byte, 16)
+		if _, err := io.ReadFull(r, header); err != nil {
+			return
+		}
+		msg := make([]byte, binary.LittleEndian.Uint32(header)-16)
+		if _, err := io.ReadFull(r, msg); err != nil {
+			return
+		}
+
+		cmd := command{}
+		opCode := binary.LittleEndian.Uint32(header[12:])
+		if opCode == opQuery {
+			query := msg[4:]
+			query = query[bytes.IndexByte(query, 0)+1+8:]
+			_ = bson.Unmarshal(query, &cmd.body)
+		} else {
+			for sections := msg[4:]; len(sections) > 0; {
+				size := int(binary.LittleEndian.Uint32(sections[1:]))
+				if sections[0] == 0 {
+					_ = bson.Unmarshal(sections[1:1+size], &cmd.body)
+					sections = sections[1+size:]
+					continue
+				}
+				seq := sections[5 : 1+size]
+				seq = seq[bytes.IndexByte(seq, 0)+1:]
+				for len(seq) > 0 {
+					docSize := int(binary.LittleEndian.Uint32(seq))
+					doc := bson.M{}
+					_ = bson.Unmarshal(seq[:docSize], &doc)
+					cmd.docs = append(cmd.docs, doc)
+					seq = seq[docSize:]
+				}
+				sections = sections[1+size:]
+			}
+		}
+
+		body, _ := bson.Marshal(m.reply(cmd))
+		reply := binary.LittleEndian.AppendUint32(nil, 0)
+		reply = binary.LittleEndian.AppendUint32(reply, 0)
+		reply = append(reply, header[4:8]...)
+		if opCode == opQuery {
+			reply = binary.LittleEndian.AppendUint32(reply, opReply)
+			reply = binary.LittleEndian.AppendUint32(reply, 0)
+			reply = binary.LittleEndian.AppendUint64(reply, 0)
+			reply = binary.LittleEndian.AppendUint32(reply, 0)
+			reply = binary.LittleEndian.AppendUint32(reply, 1)
+		} else {
+			reply = binary.LittleEndian.AppendUint32(reply, opMsg)
+			reply = binary.LittleEndian.AppendUint32(reply, 0)
+			reply = append(reply, 0)
+		}
+		reply = append(reply, body...)
+		binary.LittleEndian.PutUint32(reply, uint32(len(reply)))
+		if _, err := c.Write(reply); err != nil {
+			return
+		}
+	}
+}
+
+func (m *fakeMongo) reply(cmd command) bson.D {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, isInsert := cmd.body["insert"]
+	_, isUpdate := cmd.body["update"]
+	if !isInsert && !isUpdate {
+		return bson.D{
+			{Key: "ismaster", Value: true},
+			{Key: "isWritablePrimary", Value: true},
+			{Key: "minWireVersion", Value: int32(0)},
+			{Key: "maxWireVersion", Value: int32(17)},
+			{Key: "maxBsonObjectSize", Value: int32(16 * 1024 * 1024)},
+			{Key: "maxMessageSizeBytes", Value: int32(48000000)},
+			{Key: "maxWriteBatchSize", Value: int32(m.maxBatchSize)},
+			{Key: "ok", Value: float64(1)},
+		}
+	}
+
+	m.commands = append(m.commands, cmd)
+	if m.failCommands {
+		return bson.D{
+			{Key: "ok", Value: float64(0)},
+			{Key: "errmsg", Value: "not enough disk space"},
+			{Key: "code", Value: int32(14031)},
+		}
+	}
+
+	writeErrors := bson.A{}
+	for i, doc := range cmd.docs {
+		if u, ok := doc["u"].(bson.M); ok {
+			doc = u
+		}
+		message, _ := doc["message"].(string)
+		m.attempts[message]++
+
+		code := 0
+		switch {
+		case strings.Contains(message, "reject") && m.attempts[message] == 1:
+			code = 11602
+		case strings.Contains(message, "invalid"):
+			code = 121
+		case strings.Contains(message, "dup"):
+			code = codeDuplicateKey
+		}
+		if code != 0 {
+			writeErrors = append(writeErrors, bson.D{
+				{Key: "index", Value: int32(i)},
+				{Key: "code", Value: int32(code)},
+				{Key: "errmsg", Value: "rejected"},
+			})
+		}
+	}
+	return bson.D{
+		{Key: "writeErrors", Value: writeErrors},
+		{Key: "n", Value: int32(len(cmd.docs))},
+		{Key: "ok", Value: float64(1)},
+	}
+}
+
+func startPlugin(t *testing.T, config *Config, params *pipeline.OutputPluginParams) (*Plugin, *fakeMongo) {
+	m := newFakeMongo(t)
+
+	config.ConnectionString = "mongodb://" + m.listener.Addr().String() + "/logs"
+	p := &Plugin{}
+	test.StartOutputPlugin(t, p, config, params)
+
+	return p, m
+}
+
+type controller struct {
+	mu      sync.Mutex
+	commits []string
+}
+
+func (c *controller) Commit(event *pipeline.Event) {
+	c.mu.Lock()
+	c.commits = append(c.commits, event.Root.EncodeToString())
+	c.mu.Unlock()
+}
+
+func (c *controller) Error(err string) {
+	panic(err)
+}
+
+func TestOutInsert(t *testing.T) {
+	ctl := &controller{}
+	params := test.NewEmptyOutputPluginParams()
+	params.Controller = ctl
+	p, m := startPlugin(t, &Config{
+		Collection:        "events-%",
+		WriteConcern:      "majority",
+		BatchSize:         "4",
+		BatchFlushTimeout: "1h",
+		Retention:         "10ms",
+	}, params)
+
+	events := []string{
+		`{"time":"2024-05-01T10:15:00Z","message":"ok","level":3,"ratio":0.5,"tags":["a",true],"user":{"id":null}}`,
+		`{"time":"2024-05-02T10:15:00Z","message":"reject"}`,
+		`{"time":"2024-05-01T11:15:00Z","message":"dup"}`,
+		`{"time":"2024-05-01T12:15:00Z","message":"next"}`,
+	}
+	for _, e := range events {
+		root, err := insaneJSON.DecodeString(e)
+		require.NoError(t, err)
+		p.Out(&pipeline.Event{Root: root})
+	}
+
+	require.Eventually(t, func() bool {
+		ctl.mu.Lock()
+		defer ctl.mu.Unlock()
+		return len(ctl.commits) == len(events)
+	}, 5*time.Second, 10*time.Millisecond)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// the documents are grouped by the collection and split by the batch size of the server,
+	// only the rejected document is written again
+	require.Len(t, m.commands, 4)
+	assert.Equal(t, "events-2024-05-01", m.commands[0].body["insert"])
+	assert.Equal(t, "logs", m.commands[0].body["$db"])
+	assert.Equal(t, false, m.commands[0].body["ordered"])
+	assert.Equal(t, bson.M{"w": "majority"}, m.commands[0].body["writeConcern"])
+	require.Len(t, m.commands[0].docs, 2)
+	// the driver generates the missing _id
+	assert.NotNil(t, m.commands[0].docs[0]["_id"])
+	delete(m.commands[0].docs[0], "_id")
+	assert.Equal(t, bson.M{
+		"time":    primitive.NewDateTimeFromTime(time.Date(2024, 5, 1, 10, 15, 0, 0, time.UTC)),
+		"message": "ok",
+		"level":   int64(3),
+		"ratio":   0.5,
+		"tags":    bson.A{"a", true},
+		"user":    bson.M{"id": nil},
+	}, m.commands[0].docs[0])
+	assert.Len(t, m.commands[1].docs, 1)
+	assert.Equal(t, "events-2024-05-01", m.commands[1].body["insert"])
+	assert.Equal(t, "events-2024-05-02", m.commands[2].body["insert"])
+	assert.Equal(t, "reject", m.commands[3].docs[0]["message"])
+	assert.Equal(t, 1, m.attempts["dup"])
+	assert.Equal(t, float64(1), testutil.ToFloat64(p.writeErrorsMetric.WithLabelValues("11602")))
+}
+
+func TestOutInvalid(t *testing.T) {
+	ctl := &controller{}
+	params := test.NewEmptyOutputPluginParams()
+	params.Controller = ctl
+	deadLetter := test.NewDeadLetter(params)
+	p, m := startPlugin(t, &Config{
+		Collection:        "events",
+		BatchSize:         "2",
+		BatchFlushTimeout: "1h",
+		Retention:         "10ms",
+	}, params)
+
+	for _, e := range []string{`{"message":"ok"}`, `{"message":"invalid"}`} {
+		root, err := insaneJSON.DecodeString(e)
+		require.NoError(t, err)
+		p.Out(&pipeline.Event{Root: root})
+	}
+
+	require.Eventually(t, func() bool {
+		ctl.mu.Lock()
+		defer ctl.mu.Unlock()
+		return len(ctl.commits) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// the document rejected by the validation isn't written again, it's passed to the dead letter
+	assert.Len(t, m.commands, 1)
+	assert.Equal(t, 1, m.attempts["invalid"])
+	assert.Equal(t, []string{
+		`{"message":"invalid","dead_letter":{"output":"mongo","error":"document is rejected by mongo collection=events: 121 rejected","attempts":1}}`,
+	}, deadLetter.Events())
+	assert.Equal(t, float64(1), testutil.ToFloat64(p.writeErrorsMetric.WithLabelValues("121")))
+}
+
+func TestOutUpsert(t *testing.T) {
+	p, m := startPlugin(t, &Config{
+		Collection: "users",
+		Mode:       "upsert",
+		UpsertKey:  "user.id",
+		TimeField:  "",
+	}, test.NewEmptyOutputPluginParams())
+
+	workerData := pipeline.WorkerData(nil)
+	batch := test.NewBatch(t, `{"user":{"id":1},"time":"now"}`, `{"message":"no key"}`)
+	require.NoError(t, p.out(&workerData, batch))
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	require.Len(t, m.commands, 1)
+	assert.Equal(t, "users", m.commands[0].body["update"])
+	require.Len(t, m.commands[0].docs, 1)
+	assert.Equal(t, bson.M{"user.id": int64(1)}, m.commands[0].docs[0]["q"])
+	assert.Equal(t, bson.M{"user": bson.M{"id": int64(1)}, "time": "now"}, m.commands[0].docs[0]["u"])
+	assert.Equal(t, true, m.commands[0].docs[0]["upsert"])
+	assert.Equal(t, float64(1), testutil.ToFloat64(p.droppedDocumentsMetric.WithLabelValues()))
+}
+
+func TestOutCommandError(t *testing.T) {
+	p, m := startPlugin(t, &Config{Collection: "events"}, test.NewEmptyOutputPluginParams())
+	m.mu.Lock()
+	m.failCommands = true
+	m.mu.Unlock()
+
+	workerData := pipeline.WorkerData(nil)
+	assert.Error(t, p.out(&workerData, test.NewBatch(t, `{"a":1}`)))
+
+	m.mu.Lock()
+	m.failCommands = false
+	m.mu.Unlock()
+	assert.NoError(t, p.out(&workerData, test.NewBatch(t, `{"a":1}`)))
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	assert.Len(t, m.commands, 2)
+}
@@ -0,0 +1,542 @@
+// Package nats is an output plugin that publishes events to NATS or JetStream.
+package nats
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/nats-io/nkeys"
+	"github.com/ozontech/file.d/cfg"
+	"github.com/ozontech/file.d/fd"
+	"github.com/ozontech/file.d/metric"
+	"github.com/ozontech/file.d/pipeline"
+	"github.com/ozontech/file.d/xtls"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+/*{ introduction
+It publishes events to NATS subjects. The subject is built by the `subject` template,
+the `${field}` parts are replaced by the values of the event fields.
+
+Without the `jetstream` it's a core NATS publishing, so there are no delivery guarantees:
+events are committed once they are passed to the client, the client buffers them while it reconnects.
+
+With the `jetstream` the messages are published to the JetStream stream which covers the subject.
+> It guarantees "at-least-once delivery": the events are committed only after the publish ack,
+> the events which aren't acked are published again.
+
+The connection is reconnected with backoff, the servers are used in turn.
+
+**Example**
+```yaml
+pipelines:
+  example_pipeline:
+    ...
+    output:
+      type: nats
+      servers: [nats://nats-1:4222, nats://nats-2:4222]
+      subject: logs.${k8s_namespace}.${level}
+      jetstream: true
+    ...
+```
+The event `{"k8s_namespace":"payments","level":"error","message":"timeout"}` is published to `logs.payments.error`.
+}*/
+
+const (
+	outPluginType = "nats"
+
+	minReconnectBackoff = 100 * time.Millisecond
+	maxReconnectBackoff = 5 * time.Second
+)
+
+const (
+	formatEvent = iota
+	formatNDJSON
+)
+
+var errNotConnected = errors.New("nats isn't connected")
+
+type Plugin struct {
+	config       *Config
+	logger       *zap.SugaredLogger
+	avgEventSize int
+	batcher      *pipeline.Batcher
+	controller   pipeline.OutputPluginController
+
+	subject []cfg.SubstitutionOp
+	// conn is reconnected by the client, the batches aren't published while it's reconnecting
+	conn *nats.Conn
+
+	// plugin metrics
+
+	connectErrorsMetric *prometheus.CounterVec
+	publishErrorsMetric *prometheus.CounterVec
+}
+
+// ! config-params
+// ^ config-params
+type Config struct {
+	// > @3@4@5@6
+	// >
+	// > NATS server URLs, they are used in turn to connect and reconnect, e.g. `nats://localhost:4222`.
+	Servers []string `json:"servers" required:"true"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The template of the subject, e.g. `logs.${service}`. The missing and the empty fields are replaced by `_`,
+	// > the dots, the spaces and the wildcards of the values are replaced by `_` too.
+	Subject string `json:"subject" required:"true"` // *
+
+	// > @3@4@5@6
+	// >
+	// > If set, the messages are published to JetStream and the events are committed after the publish ack.
+	JetStream bool `json:"jetstream" default:"false"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The max number of the JetStream messages of the worker which are waiting for the ack.
+	MaxPendingAcks int `json:"max_pending_acks" default:"256"` // *
+
+	// > @3@4@5@6
+	// >
+	// > How long to wait for the JetStream publish ack, the messages which aren't acked are published again.
+	AckTimeout  cfg.Duration `json:"ack_timeout" default:"5s" parse:"duration"` // *
+	AckTimeout_ time.Duration
+
+	// > @3@4@5@6
+	// >
+	// > The payload of the message:
+	// > * `event` – each event is the message
+	// > * `ndjson` – the events of the batch with the same subject are the message, they are separated by the new line
+	Format  string `json:"format" default:"event" options:"event|ndjson"` // *
+	Format_ int
+
+	// > @3@4@5@6
+	// >
+	// > User for the authentication.
+	User string `json:"user"` // *
+
+	// > @3@4@5@6
+	// >
+	// > Password for the authentication.
+	Password string `json:"password"` // *
+
+	// > @3@4@5@6
+	// >
+	// > Token for the authentication.
+	Token string `json:"token"` // *
+
+	// > @3@4@5@6
+	// >
+	// > User NKey seed for the authentication, it starts with `SU`.
+	NkeySeed string `json:"nkey_seed"` // *
+
+	// > @3@4@5@6
+	// >
+	// > If set, the connection is upgraded to TLS. It's upgraded anyway if the server requires it.
+	TLSEnabled bool `json:"tls_enabled" default:"false"` // *
+
+	// > @3@4@5@6
+	// >
+	// > CA certificate in PEM encoding or a path to the file to verify the server certificate.
+	CACert string `json:"ca_cert"` // *
+
+	// > @3@4@5@6
+	// >
+	// > How many workers will be instantiated to send batches.
+	WorkersCount  cfg.Expression `json:"workers_count" default:"gomaxprocs*4" parse:"expression"` // *
+	WorkersCount_ int
+
+	// > @3@4@5@6
+	// >
+	// > A maximum quantity of events to pack into one batch.
+	BatchSize  cfg.Expression `json:"batch_size" default:"capacity/4" parse:"expression"` // *
+	BatchSize_ int
+
+	// > @3@4@5@6
+	// >
+	// > A minimum size of events in a batch to send.
+	// > If both batch_size and batch_size_bytes are set, they will work together.
+	BatchSizeBytes  cfg.Expression `json:"batch_size_bytes" default:"0" parse:"expression"` // *
+	BatchSizeBytes_ int
+
+	// > @3@4@5@6
+	// >
+	// > After this timeout the batch will be sent even if batch isn't completed.
+	BatchFlushTimeout  cfg.Duration `json:"batch_flush_timeout" default:"200ms" parse:"duration"` // *
+	BatchFlushTimeout_ time.Duration
+
+	// > @3@4@5@6
+	// >
+	// > Retries of the batch sending.
+	Retry int `json:"retry" default:"10"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The delay before the first retry, it's doubled after each retry.
+	Retention  cfg.Duration `json:"retention" default:"1s" parse:"duration"` // *
+	Retention_ time.Duration
+
+	// > @3@4@5@6
+	// >
+	// > The max delay between the retries.
+	MaxRetention  cfg.Duration `json:"max_retention" default:"1m" parse:"duration"` // *
+	MaxRetention_ time.Duration
+
+	// > @3@4@5@6
+	// >
+	// > Exit with the non-zero code if the batch isn't sent after all the retries, otherwise the batch is dropped.
+	FatalOnFailedInsert bool `json:"fatal_on_failed_insert" default:"false"` // *
+}
+
+// message is the published message, the payload is the part of the payload buffer of the worker
+type message struct {
+	subject string
+	start   int
+	end     int
+	events  []*pipeline.Event
+	// ack is the future of the JetStream publish ack
+	ack jetstream.PubAckFuture
+	ok  bool
+}
+
+type data struct {
+	js         jetstream.JetStream
+	messages   []message
+	bySubject  map[string]int
+	payload    []byte
+	subjectBuf []byte
+}
+
+func init() {
+	fd.DefaultPluginRegistry.RegisterOutput(&pipeline.PluginStaticInfo{
+		Type:    outPluginType,
+		Factory: Factory,
+	})
+}
+
+func Factory() (pipeline.AnyPlugin, pipeline.AnyConfig) {
+	return &Plugin{}, &Config{}
+}
+
+func (p *Plugin) Start(config pipeline.AnyConfig, params *pipeline.OutputPluginParams) {
+	p.controller = params.Controller
+	p.logger = params.Logger
+	p.avgEventSize = params.PipelineSettings.AvgEventSize
+	p.config = config.(*Config)
+	p.registerMetrics(params.MetricCtl)
+
+	options, err := p.prepare()
+	if err != nil {
+		p.logger.Fatal(err.Error())
+	}
+
+	// the connection is established in the background and it's reconnected forever
+	p.conn, err = nats.Connect(strings.Join(p.config.Servers, ","), options...)
+	if err != nil {
+		p.logger.Fatalf("can't create nats connection: %s", err.Error())
+	}
+
+	p.batcher = pipeline.NewBatcher(pipeline.BatcherOptions{
+		PipelineName:   params.PipelineName,
+		OutputType:     outPluginType,
+		OutFn:          p.out,
+		Controller:     p.controller,
+		Workers:        p.config.WorkersCount_,
+		BatchSizeCount: p.config.BatchSize_,
+		BatchSizeBytes: p.config.BatchSizeBytes_,
+		FlushTimeout:   p.config.BatchFlushTimeout_,
+		MaxEventAge:    params.PipelineSettings.MaxEventAge,
+		CircuitBreaker: params.PipelineSettings.CircuitBreaker,
+		Health:         params.Health,
+		ErrorReporter:  params.ErrorReporter,
+		DeadLetter:     params.DeadLetter,
+		MetricCtl:      params.MetricCtl,
+		Retry: pipeline.BatcherRetry{
+			MaxRetries:        p.config.Retry,
+			InitialBackoff:    p.config.Retention_,
+			BackoffMultiplier: 2,
+			MaxBackoff:        p.config.MaxRetention_,
+		},
+		FatalOnFailedInsert: p.config.FatalOnFailedInsert,
+	})
+
+	p.batcher.Start(context.TODO())
+}
+
+// prepare parses the subject template and returns the options of the connection
+// with the credentials and the reconnect backoff
+func (p *Plugin) prepare() ([]nats.Option, error) {
+	if len(p.config.Servers) == 0 {
+		return nil, fmt.Errorf("servers should be set")
+	}
+	if p.config.MaxPendingAcks <= 0 {
+		return nil, fmt.Errorf("max_pending_acks should be greater than 0")
+	}
+
+	subject, err := cfg.ParseSubstitution(p.config.Subject)
+	if err != nil {
+		return nil, fmt.Errorf("wrong subject template %q: %w", p.config.Subject, err)
+	}
+	p.subject = subject
+
+	options := []nats.Option{
+		nats.Name("file.d"),
+		nats.DontRandomize(),
+		nats.MaxReconnects(-1),
+		nats.RetryOnFailedConnect(true),
+		nats.CustomReconnectDelay(func(attempts int) time.Duration {
+			return min(minReconnectBackoff<<min(attempts, 10), maxReconnectBackoff)
+		}),
+		nats.ConnectHandler(func(c *nats.Conn) {
+			p.logger.Infof("connected to nats server %s", c.ConnectedUrlRedacted())
+		}),
+		nats.ReconnectHandler(func(c *nats.Conn) {
+			p.logger.Infof("reconnected to nats server %s", c.ConnectedUrlRedacted())
+		}),
+		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
+			if err == nil {
+				return
+			}
+			p.connectErrorsMetric.WithLabelValues().Inc()
+			p.logger.Errorf("nats connection is broken, reconnecting: %s", err.Error())
+		}),
+	}
+
+	if p.config.User != "" {
+		options = append(options, nats.UserInfo(p.config.User, p.config.Password))
+	}
+	if p.config.Token != "" {
+		options = append(options, nats.Token(p.config.Token))
+	}
+	if p.config.NkeySeed != "" {
+		kp, err := nkeys.FromSeed([]byte(p.config.NkeySeed))
+		if err != nil {
+			return nil, fmt.Errorf("can't parse nkey seed: %w", err)
+		}
+		public, err := kp.PublicKey()
+		if err != nil || !nkeys.IsValidPublicUserKey(public) {
+			return nil, errors.New("nkey seed isn't a user seed")
+		}
+		options = append(options, nats.Nkey(public, kp.Sign))
+	}
+
+	if p.config.TLSEnabled || p.config.CACert != "" {
+		b := xtls.NewConfigBuilder()
+		if p.config.CACert != "" {
+			if err := b.AppendCARoot(p.config.CACert); err != nil {
+				return nil, fmt.Errorf("can't append CA root: %w", err)
+			}
+		}
+		options = append(options, nats.Secure(b.Build()))
+	}
+
+	return options, nil
+}
+
+func (p *Plugin) registerMetrics(ctl *metric.Ctl) {
+	p.connectErrorsMetric = ctl.RegisterCounter("output_nats_connect_errors", "Number of NATS connection errors")
+	p.publishErrorsMetric = ctl.RegisterCounter("output_nats_publish_errors", "Number of NATS messages which aren't published or acked")
+}
+
+func (p *Plugin) Stop() {
+	p.batcher.Stop()
+	p.conn.Close()
+}
+
+func (p *Plugin) Out(event *pipeline.Event) {
+	p.batcher.Add(event)
+}
+
+func (p *Plugin) out(workerData *pipeline.WorkerData, batch *pipeline.Batch) error {
+	if *workerData == nil {
+		*workerData = &data{
+			messages:  make([]message, 0),
+			bySubject: make(map[string]int),
+			payload:   make([]byte, 0, p.config.BatchSize_*p.avgEventSize),
+		}
+	}
+
+	data := (*workerData).(*data)
+	// handle too much memory consumption
+	if cap(data.payload) > p.config.BatchSize_*p.avgEventSize {
+		data.payload = make([]byte, 0, p.config.BatchSize_*p.avgEventSize)
+	}
+
+	if !p.conn.IsConnected() {
+		return errNotConnected
+	}
+
+	p.buildMessages(data, batch)
+	if p.config.JetStream {
+		if err := p.publishJetStream(data); err != nil {
+			return err
+		}
+	} else {
+		p.publishCore(data)
+	}
+
+	// the events of the failed messages are passed to the out again
+	for i := range data.messages {
+		m := &data.messages[i]
+		if m.ok {
+			continue
+		}
+		p.publishErrorsMetric.WithLabelValues().Inc()
+		for _, e := range m.events {
+			batch.MarkFailed(e)
+		}
+	}
+
+	return nil
+}
+
+// buildMessages renders the subjects and the payloads of the batch
+func (p *Plugin) buildMessages(data *data, batch *pipeline.Batch) {
+	// don't hold the events of the previous batch
+	for i := range data.messages[:cap(data.messages)] {
+		clear(data.messages[i].events)
+		data.messages[i].ack = nil
+	}
+	data.messages = data.messages[:0]
+	clear(data.bySubject)
+	data.payload = data.payload[:0]
+
+	// the payloads of the ndjson messages are appended after all events are grouped
+	batch.ForEach(func(event *pipeline.Event) bool {
+		subject := p.renderSubject(data, event)
+		if p.config.Format_ == formatNDJSON {
+			if i, has := data.bySubject[subject]; has {
+				data.messages[i].events = append(data.messages[i].events, event)
+				return true
+			}
+			data.bySubject[subject] = len(data.messages)
+		}
+
+		// the messages are reused to keep the events buffers
+		if len(data.messages) < cap(data.messages) {
+			data.messages = data.messages[:len(data.messages)+1]
+		} else {
+			data.messages = append(data.messages, message{})
+		}
+		m := &data.messages[len(data.messages)-1]
+		m.subject = subject
+		m.events = append(m.events[:0], event)
+		return true
+	})
+
+	for i := range data.messages {
+		m := &data.messages[i]
+		m.start = len(data.payload)
+		for j, e := range m.events {
+			if j > 0 {
+				data.payload = append(data.payload, '\n')
+			}
+			data.payload = e.Root.Encode(data.payload)
+		}
+		m.end = len(data.payload)
+		m.ok = false
+	}
+}
+
+// renderSubject builds the subject of the event, the field values are single subject tokens
+func (p *Plugin) renderSubject(data *data, event *pipeline.Event) string {
+	data.subjectBuf = data.subjectBuf[:0]
+	for i := range p.subject {
+		op := &p.subject[i]
+		if op.Kind == cfg.SubstitutionOpKindRaw {
+			data.subjectBuf = append(data.subjectBuf, op.Data[0]...)
+			continue
+		}
+
+		value := ""
+		if node := event.Root.Dig(op.Data...); node != nil {
+			value = node.AsString()
+		}
+		if value == "" {
+			data.subjectBuf = append(data.subjectBuf, '_')
+			continue
+		}
+		for j := 0; j < len(value); j++ {
+			c := value[j]
+			switch c {
+			case '.', ' ', '*', '>', '\t', '\r', '\n':
+				c = '_'
+			}
+			data.subjectBuf = append(data.subjectBuf, c)
+		}
+	}
+	return string(data.subjectBuf)
+}
+
+// publishCore writes the messages to the connection, the rest of the messages isn't sent after the error
+func (p *Plugin) publishCore(data *data) {
+	for i := range data.messages {
+		m := &data.messages[i]
+		if err := p.conn.Publish(m.subject, data.payload[m.start:m.end]); err != nil {
+			p.logger.Errorf("can't publish nats message: %s", err.Error())
+			return
+		}
+		m.ok = true
+	}
+}
+
+// publishJetStream publishes the messages and waits for the acks, at most MaxPendingAcks messages wait at the same time
+func (p *Plugin) publishJetStream(data *data) error {
+	if data.js == nil {
+		js, err := jetstream.New(p.conn)
+		if err != nil {
+			return fmt.Errorf("can't create jetstream context: %w", err)
+		}
+		data.js = js
+	}
+
+	// the acks are waited in the order of the messages
+	waited := 0
+	for i := range data.messages {
+		for ; i-waited >= p.config.MaxPendingAcks; waited++ {
+			if !p.waitAck(&data.messages[waited]) {
+				return nil
+			}
+		}
+
+		m := &data.messages[i]
+		ack, err := data.js.PublishAsync(m.subject, data.payload[m.start:m.end])
+		if err != nil {
+			p.logger.Errorf("can't publish nats message: %s", err.Error())
+			return nil
+		}
+		m.ack = ack
+	}
+
+	for ; waited < len(data.messages); waited++ {
+		if !p.waitAck(&data.messages[waited]) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// waitAck waits for the ack of the message, it returns false if the ack timeout is exceeded
+func (p *Plugin) waitAck(m *message) bool {
+	timer := time.NewTimer(p.config.AckTimeout_)
+	defer timer.Stop()
+
+	select {
+	case <-m.ack.Ok():
+		m.ok = true
+		return true
+	case err := <-m.ack.Err():
+		p.logger.Errorf("nats message to %s isn't acked: %s", m.subject, err.Error())
+		return true
+	case <-timer.C:
+		p.logger.Errorf("nats publish ack timeout %s is exceeded", p.config.AckTimeout_)
+		return false
+	}
+}
@@ -0,0 +1,191 @@
+package nats
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/ozontech/file.d/pipeline"
+	"github.com/ozontech/file.d/test"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type published struct {
+	subject string
+	data    string
+}
+
+// runServer runs the embedded NATS server with JetStream
+func runServer(t *testing.T) *server.Server {
+	s, err := server.NewServer(&server.Options{
+		Host:      "127.0.0.1",
+		Port:      -1,
+		NoLog:     true,
+		JetStream: true,
+		StoreDir:  t.TempDir(),
+	})
+	require.NoError(t, err)
+	go s.Start()
+	require.True(t, s.ReadyForConnections(5*time.Second), "nats server isn't ready")
+	t.Cleanup(s.Shutdown)
+	return s
+}
+
+func connect(t *testing.T, s *server.Server) *nats.Conn {
+	nc, err := nats.Connect(s.ClientURL())
+	require.NoError(t, err)
+	t.Cleanup(nc.Close)
+	return nc
+}
+
+func startPlugin(t *testing.T, config *Config) *Plugin {
+	p := &Plugin{}
+	test.StartOutputPlugin(t, p, config, nil)
+
+	require.Eventually(t, func() bool {
+		return p.conn.IsConnected()
+	}, 5*time.Second, 10*time.Millisecond, "plugin isn't connected")
+
+	return p
+}
+
+func TestPublishCore(t *testing.T) {
+	s := runServer(t)
+	nc := connect(t, s)
+	mu := sync.Mutex{}
+	messages := make([]published, 0)
+	_, err := nc.Subscribe("logs.>", func(m *nats.Msg) {
+		mu.Lock()
+		messages = append(messages, published{subject: m.Subject, data: string(m.Data)})
+		mu.Unlock()
+	})
+	require.NoError(t, err)
+	require.NoError(t, nc.Flush())
+	received := func() []published {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]published(nil), messages...)
+	}
+
+	p := startPlugin(t, &Config{
+		Servers: []string{s.ClientURL()},
+		Subject: "logs.${service}.${level}",
+	})
+
+	workerData := pipeline.WorkerData(nil)
+	require.NoError(t, p.out(&workerData, test.NewBatch(t,
+		`{"service":"api","level":"error","message":"timeout"}`,
+		`{"service":"api.v2","message":"no level"}`,
+	)))
+
+	require.Eventually(t, func() bool {
+		return len(received()) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, []published{
+		{subject: "logs.api.error", data: `{"service":"api","level":"error","message":"timeout"}`},
+		{subject: "logs.api_v2._", data: `{"service":"api.v2","message":"no level"}`},
+	}, received())
+}
+
+// streamMessages returns the messages of the stream in the order of the sequence
+func streamMessages(t *testing.T, stream jetstream.Stream) []published {
+	info, err := stream.Info(context.Background())
+	require.NoError(t, err)
+
+	messages := make([]published, 0)
+	for seq := uint64(1); seq <= info.State.LastSeq; seq++ {
+		m, err := stream.GetMsg(context.Background(), seq)
+		require.NoError(t, err)
+		messages = append(messages, published{subject: m.Subject, data: string(m.Data)})
+	}
+	return messages
+}
+
+func TestPublishJetStream(t *testing.T) {
+	s := runServer(t)
+	js, err := jetstream.New(connect(t, s))
+	require.NoError(t, err)
+	// there is no stream for logs.fail, so its message isn't acked
+	stream, err := js.CreateStream(context.Background(), jetstream.StreamConfig{
+		Name:     "LOGS",
+		Subjects: []string{"logs.api", "logs.db"},
+	})
+	require.NoError(t, err)
+
+	p := startPlugin(t, &Config{
+		Servers:        []string{s.ClientURL()},
+		Subject:        "logs.${service}",
+		JetStream:      true,
+		Format:         "ndjson",
+		MaxPendingAcks: 1,
+		AckTimeout:     "200ms",
+	})
+
+	workerData := pipeline.WorkerData(nil)
+	require.NoError(t, p.out(&workerData, test.NewBatch(t,
+		`{"service":"api","message":"1"}`,
+		`{"service":"db","message":"2"}`,
+		`{"service":"api","message":"3"}`,
+		`{"service":"fail","message":"4"}`,
+	)))
+
+	assert.Equal(t, []published{
+		{subject: "logs.api", data: "{\"service\":\"api\",\"message\":\"1\"}\n{\"service\":\"api\",\"message\":\"3\"}"},
+		{subject: "logs.db", data: `{"service":"db","message":"2"}`},
+	}, streamMessages(t, stream))
+
+	messages := workerData.(*data).messages
+	assert.Equal(t, []bool{true, true, false}, []bool{messages[0].ok, messages[1].ok, messages[2].ok})
+	assert.Equal(t, float64(1), testutil.ToFloat64(p.publishErrorsMetric))
+}
+
+func TestPublishJetStreamAckTimeout(t *testing.T) {
+	s := runServer(t)
+	nc := connect(t, s)
+	js, err := jetstream.New(nc)
+	require.NoError(t, err)
+	_, err = js.CreateStream(context.Background(), jetstream.StreamConfig{Name: "LOGS", Subjects: []string{"logs.api"}})
+	require.NoError(t, err)
+	// the subscriber of logs.noack doesn't answer, so the message isn't acked
+	_, err = nc.Subscribe("logs.noack", func(_ *nats.Msg) {})
+	require.NoError(t, err)
+	require.NoError(t, nc.Flush())
+
+	p := startPlugin(t, &Config{
+		Servers:    []string{s.ClientURL()},
+		Subject:    "logs.${service}",
+		JetStream:  true,
+		AckTimeout: "100ms",
+	})
+
+	workerData := pipeline.WorkerData(nil)
+	require.NoError(t, p.out(&workerData, test.NewBatch(t,
+		`{"service":"api"}`,
+		`{"service":"noack"}`,
+	)))
+
+	messages := workerData.(*data).messages
+	require.Len(t, messages, 2)
+	assert.True(t, messages[0].ok)
+	assert.False(t, messages[1].ok)
+}
+
+func TestPublishNotConnected(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := l.Addr().String()
+	require.NoError(t, l.Close())
+
+	p := &Plugin{}
+	test.StartOutputPlugin(t, p, &Config{Servers: []string{"nats://" + addr}, Subject: "logs"}, nil)
+
+	workerData := pipeline.WorkerData(nil)
+	assert.ErrorIs(t, p.out(&workerData, test.NewBatch(t, `{"message":"test"}`)), errNotConnected)
+}
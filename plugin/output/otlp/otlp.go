@@ -0,0 +1,627 @@
+// Package otlp is an output plugin that sends events to the OpenTelemetry collector as the OTLP logs.
+package otlp
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ozontech/file.d/cfg"
+	"github.com/ozontech/file.d/fd"
+	"github.com/ozontech/file.d/metric"
+	"github.com/ozontech/file.d/pipeline"
+	"github.com/ozontech/file.d/xtls"
+	"github.com/prometheus/client_golang/prometheus"
+	collogspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	_ "google.golang.org/grpc/encoding/gzip" // the gzip compressor of the calls
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+/*{ introduction
+It sends events to the OpenTelemetry collector as the OTLP log records by gRPC or HTTP with the protobuf payload.
+The record is made of the event fields:
+* the timestamp is taken from `time_field`, the observed timestamp is the time of sending
+* the severity text is taken from `severity_field` and the severity number is mapped from it,
+the levels `trace`, `debug`, `info`, `notice`, `warning`, `error`, `critical`, `alert` and `fatal` are known,
+other values can be mapped by `severity_map`
+* the body is the value of `body_field`, if it's missing, the body is the whole event encoded to JSON
+* the attributes are taken from `attribute_fields`, the objects and the arrays are encoded to JSON
+
+The resource attributes are the static `resource_attributes` and the values of `resource_fields`,
+the records of the batch are grouped by the resource.
+
+The batch is retried if the collector responds with the transient status: `429`, `502`, `503`, `504` for HTTP
+or `CANCELLED`, `DEADLINE_EXCEEDED`, `RESOURCE_EXHAUSTED`, `ABORTED`, `OUT_OF_RANGE`, `UNAVAILABLE`, `DATA_LOSS` for gRPC.
+Other errors of the batch are logged and the batch is dropped.
+
+**Example:**
+```yaml
+pipelines:
+  example_pipeline:
+    ...
+    output:
+      type: otlp
+      endpoint: http://otel-collector:4317
+      protocol: grpc
+      body_field: message
+      severity_field: level
+      attribute_fields: [trace_id, http.status]
+      resource_fields: [k8s_namespace, k8s_pod]
+      resource_attributes:
+        service.name: payments
+      headers:
+        x-api-key: secret
+    ...
+```
+}*/
+
+const (
+	outPluginType = "otlp"
+
+	httpExportPath = "/v1/logs"
+)
+
+const (
+	protocolGRPC = iota
+	protocolHTTP
+)
+
+// gRPC status codes which are retried according to the OTLP specification
+var retryableGRPCCodes = map[codes.Code]bool{
+	codes.Canceled:          true,
+	codes.DeadlineExceeded:  true,
+	codes.ResourceExhausted: true,
+	codes.Aborted:           true,
+	codes.OutOfRange:        true,
+	codes.Unavailable:       true,
+	codes.DataLoss:          true,
+}
+
+// defaultSeverities maps the levels to the OTLP severity numbers
+var defaultSeverities = map[pipeline.LogLevel]int{
+	pipeline.LevelEmergency:     21,
+	pipeline.LevelAlert:         19,
+	pipeline.LevelCritical:      18,
+	pipeline.LevelError:         17,
+	pipeline.LevelWarning:       13,
+	pipeline.LevelNotice:        10,
+	pipeline.LevelInformational: 9,
+	pipeline.LevelDebug:         5,
+}
+
+const severityTrace = 1
+
+type Plugin struct {
+	config       *Config
+	client       *http.Client
+	conn         *grpc.ClientConn
+	logsClient   collogspb.LogsServiceClient
+	logger       *zap.SugaredLogger
+	avgEventSize int
+	batcher      *pipeline.Batcher
+	controller   pipeline.OutputPluginController
+
+	url             string
+	headers         metadata.MD
+	timeFormat      string
+	attributes      []attribute
+	resourceFields  []attribute
+	staticResource  []*commonpb.KeyValue
+	scope           *commonpb.InstrumentationScope
+	severityMap     map[string]int
+	severityMapLock sync.Mutex
+
+	// plugin metrics
+
+	sendErrorMetric    *prometheus.CounterVec
+	droppedBatchMetric *prometheus.CounterVec
+}
+
+type attribute struct {
+	key   string
+	field []string
+}
+
+// ! config-params
+// ^ config-params
+type Config struct {
+	// > @3@4@5@6
+	// >
+	// > The address of the collector with the scheme, e.g. `http://127.0.0.1:4317` for gRPC
+	// > or `https://127.0.0.1:4318` for HTTP. TLS is used for the `https` scheme.
+	Endpoint string `json:"endpoint" required:"true"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The protocol to send the logs by.
+	Protocol  string `json:"protocol" default:"grpc" options:"grpc|http"` // *
+	Protocol_ int
+
+	// > @3@4@5@6
+	// >
+	// > The event field which contains the body of the record. If it's missing, the whole event is the body.
+	BodyField  cfg.FieldSelector `json:"body_field" parse:"selector" default:"message"` // *
+	BodyField_ []string
+
+	// > @3@4@5@6
+	// >
+	// > The event field which contains the time of the record.
+	TimeField  cfg.FieldSelector `json:"time_field" parse:"selector" default:"time"` // *
+	TimeField_ []string
+
+	// > @3@4@5@6
+	// >
+	// > The format of `time_field`, it's a layout or one of the aliases of the `convert_date` plugin.
+	TimeFormat string `json:"time_format" default:"rfc3339nano"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The event field which contains the severity of the record.
+	SeverityField  cfg.FieldSelector `json:"severity_field" parse:"selector" default:"level"` // *
+	SeverityField_ []string
+
+	// > @3@4@5@6
+	// >
+	// > The additional mapping of the severity texts to the OTLP severity numbers, e.g. `{"verbose": 1}`.
+	// > The texts are case-insensitive.
+	SeverityMap map[string]int `json:"severity_map"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The event fields which are sent as the attributes of the record, the key of the attribute is the field selector.
+	AttributeFields []string `json:"attribute_fields"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The static attributes of the resource.
+	ResourceAttributes map[string]string `json:"resource_attributes"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The event fields which are sent as the attributes of the resource, the key of the attribute is the field selector.
+	ResourceFields []string `json:"resource_fields"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The name of the instrumentation scope.
+	ScopeName string `json:"scope_name" default:"file.d"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The headers which are added to the requests, e.g. the authentication ones.
+	Headers map[string]string `json:"headers"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The compression of the payload.
+	Compression string `json:"compression" default:"none" options:"none|gzip"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The CA certificate to verify the collector, it's a PEM content or a path to the file.
+	CACert string `json:"ca_cert"` // *
+
+	// > @3@4@5@6
+	// >
+	// > Skip the verification of the collector certificate.
+	InsecureSkipVerify bool `json:"insecure_skip_verify" default:"false"` // *
+
+	// > @3@4@5@6
+	// >
+	// > How many workers will be instantiated to send batches.
+	WorkersCount  cfg.Expression `json:"workers_count" default:"gomaxprocs*4" parse:"expression"` // *
+	WorkersCount_ int
+
+	// > @3@4@5@6
+	// >
+	// > Client timeout when sends requests to the collector.
+	RequestTimeout  cfg.Duration `json:"request_timeout" default:"10s" parse:"duration"` // *
+	RequestTimeout_ time.Duration
+
+	// > @3@4@5@6
+	// >
+	// > A maximum quantity of events to pack into one batch.
+	BatchSize  cfg.Expression `json:"batch_size" default:"capacity/4" parse:"expression"` // *
+	BatchSize_ int
+
+	// > @3@4@5@6
+	// >
+	// > A minimum size of events in a batch to send.
+	// > If both batch_size and batch_size_bytes are set, they will work together.
+	BatchSizeBytes  cfg.Expression `json:"batch_size_bytes" default:"0" parse:"expression"` // *
+	BatchSizeBytes_ int
+
+	// > @3@4@5@6
+	// >
+	// > After this timeout the batch will be sent even if batch isn't completed.
+	BatchFlushTimeout  cfg.Duration `json:"batch_flush_timeout" default:"200ms" parse:"duration"` // *
+	BatchFlushTimeout_ time.Duration
+
+	// > @3@4@5@6
+	// >
+	// > Retries of the batch sending.
+	Retry int `json:"retry" default:"10"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The delay before the first retry, it's doubled after each retry.
+	Retention  cfg.Duration `json:"retention" default:"1s" parse:"duration"` // *
+	Retention_ time.Duration
+
+	// > @3@4@5@6
+	// >
+	// > The max delay between the retries.
+	MaxRetention  cfg.Duration `json:"max_retention" default:"1m" parse:"duration"` // *
+	MaxRetention_ time.Duration
+
+	// > @3@4@5@6
+	// >
+	// > Exit with the non-zero code if the batch isn't sent after all the retries, otherwise the batch is dropped.
+	FatalOnFailedInsert bool `json:"fatal_on_failed_insert" default:"false"` // *
+}
+
+type data struct {
+	// groups are the records with the same resource by the key of the resource fields
+	groups  map[string]*logspb.ScopeLogs
+	request *collogspb.ExportLogsServiceRequest
+
+	keyBuf []byte
+	outBuf []byte
+}
+
+func init() {
+	fd.DefaultPluginRegistry.RegisterOutput(&pipeline.PluginStaticInfo{
+		Type:    outPluginType,
+		Factory: Factory,
+	})
+}
+
+func Factory() (pipeline.AnyPlugin, pipeline.AnyConfig) {
+	return &Plugin{}, &Config{}
+}
+
+func (p *Plugin) Start(config pipeline.AnyConfig, params *pipeline.OutputPluginParams) {
+	p.controller = params.Controller
+	p.logger = params.Logger
+	p.avgEventSize = params.PipelineSettings.AvgEventSize
+	p.config = config.(*Config)
+	p.registerMetrics(params.MetricCtl)
+
+	if err := p.prepare(); err != nil {
+		p.logger.Fatal(err.Error())
+	}
+
+	if p.config.Protocol_ == protocolGRPC {
+		conn, err := p.newConn()
+		if err != nil {
+			p.logger.Fatalf("can't create grpc client: %s", err.Error())
+		}
+		p.conn = conn
+		p.logsClient = collogspb.NewLogsServiceClient(conn)
+	} else {
+		client, err := xtls.NewHTTPClient(p.config.CACert, p.config.InsecureSkipVerify, p.config.RequestTimeout_)
+		if err != nil {
+			p.logger.Fatalf("can't create client: %s", err.Error())
+		}
+		p.client = client
+	}
+
+	p.batcher = pipeline.NewBatcher(pipeline.BatcherOptions{
+		PipelineName:   params.PipelineName,
+		OutputType:     outPluginType,
+		OutFn:          p.out,
+		Controller:     p.controller,
+		Workers:        p.config.WorkersCount_,
+		BatchSizeCount: p.config.BatchSize_,
+		BatchSizeBytes: p.config.BatchSizeBytes_,
+		FlushTimeout:   p.config.BatchFlushTimeout_,
+		MaxEventAge:    params.PipelineSettings.MaxEventAge,
+		CircuitBreaker: params.PipelineSettings.CircuitBreaker,
+		Health:         params.Health,
+		ErrorReporter:  params.ErrorReporter,
+		DeadLetter:     params.DeadLetter,
+		MetricCtl:      params.MetricCtl,
+		Compression:    pipeline.BatchCompression(p.config.Compression),
+		Retry: pipeline.BatcherRetry{
+			MaxRetries:        p.config.Retry,
+			InitialBackoff:    p.config.Retention_,
+			BackoffMultiplier: 2,
+			MaxBackoff:        p.config.MaxRetention_,
+		},
+		FatalOnFailedInsert: p.config.FatalOnFailedInsert,
+	})
+
+	p.batcher.Start(context.TODO())
+}
+
+// prepare builds the values which are the same for all the batches
+func (p *Plugin) prepare() error {
+	endpoint := strings.TrimSuffix(p.config.Endpoint, "/")
+	if !strings.HasPrefix(endpoint, "http://") && !strings.HasPrefix(endpoint, "https://") {
+		return fmt.Errorf("endpoint %q should start with http:// or https://", p.config.Endpoint)
+	}
+	if p.config.Protocol_ == protocolGRPC {
+		// the target of the grpc client is the host of the endpoint
+		u, err := url.Parse(endpoint)
+		if err != nil {
+			return fmt.Errorf("wrong endpoint %q: %w", p.config.Endpoint, err)
+		}
+		p.url = u.Host
+	} else {
+		p.url = endpoint + httpExportPath
+	}
+	p.headers = metadata.New(p.config.Headers)
+
+	p.timeFormat = p.config.TimeFormat
+	if format, err := pipeline.ParseFormatName(p.config.TimeFormat); err == nil {
+		p.timeFormat = format
+	}
+
+	p.attributes = make([]attribute, 0, len(p.config.AttributeFields))
+	for _, field := range p.config.AttributeFields {
+		p.attributes = append(p.attributes, attribute{key: field, field: cfg.ParseFieldSelector(field)})
+	}
+	p.resourceFields = make([]attribute, 0, len(p.config.ResourceFields))
+	for _, field := range p.config.ResourceFields {
+		p.resourceFields = append(p.resourceFields, attribute{key: field, field: cfg.ParseFieldSelector(field)})
+	}
+
+	// the static attributes are sorted to build the same resource for all the batches
+	keys := make([]string, 0, len(p.config.ResourceAttributes))
+	for key := range p.config.ResourceAttributes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	p.staticResource = make([]*commonpb.KeyValue, 0, len(keys))
+	for _, key := range keys {
+		p.staticResource = append(p.staticResource, &commonpb.KeyValue{
+			Key:   key,
+			Value: stringValue(p.config.ResourceAttributes[key]),
+		})
+	}
+	p.scope = &commonpb.InstrumentationScope{Name: p.config.ScopeName}
+
+	p.severityMap = make(map[string]int, len(p.config.SeverityMap))
+	for text, number := range p.config.SeverityMap {
+		if number < 0 || number > 24 {
+			return fmt.Errorf("wrong severity number %d of %q, it should be in [0, 24]", number, text)
+		}
+		p.severityMap[strings.ToLower(text)] = number
+	}
+
+	return nil
+}
+
+func (p *Plugin) newConn() (*grpc.ClientConn, error) {
+	creds := insecure.NewCredentials()
+	if strings.HasPrefix(p.config.Endpoint, "https://") {
+		tlsConfig, err := xtls.NewClientConfig(p.config.CACert, p.config.InsecureSkipVerify)
+		if err != nil {
+			return nil, err
+		}
+		creds = credentials.NewTLS(tlsConfig)
+	}
+	return grpc.NewClient(p.url, grpc.WithTransportCredentials(creds))
+}
+
+func (p *Plugin) registerMetrics(ctl *metric.Ctl) {
+	p.sendErrorMetric = ctl.RegisterCounter("output_otlp_send_error", "Total OTLP send errors")
+	p.droppedBatchMetric = ctl.RegisterCounter("output_otlp_dropped_batches_total", "Number of batches rejected by the collector")
+}
+
+func (p *Plugin) Stop() {
+	p.batcher.Stop()
+	if p.conn != nil {
+		_ = p.conn.Close()
+	}
+}
+
+func (p *Plugin) Out(event *pipeline.Event) {
+	p.batcher.Add(event)
+}
+
+func (p *Plugin) out(workerData *pipeline.WorkerData, batch *pipeline.Batch) error {
+	if *workerData == nil {
+		*workerData = &data{
+			groups:  make(map[string]*logspb.ScopeLogs),
+			request: &collogspb.ExportLogsServiceRequest{},
+			outBuf:  make([]byte, 0, p.config.BatchSize_*p.avgEventSize),
+		}
+	}
+
+	data := (*workerData).(*data)
+	// handle too much memory consumption
+	if cap(data.outBuf) > p.config.BatchSize_*p.avgEventSize {
+		data.outBuf = make([]byte, 0, p.config.BatchSize_*p.avgEventSize)
+	}
+
+	clear(data.groups)
+	clear(data.request.ResourceLogs)
+	data.request.ResourceLogs = data.request.ResourceLogs[:0]
+
+	observed := uint64(time.Now().UnixNano())
+	batch.ForEach(func(event *pipeline.Event) bool {
+		p.appendRecord(data, event, observed)
+		return true
+	})
+
+	var (
+		retry bool
+		err   error
+	)
+	if p.config.Protocol_ == protocolGRPC {
+		retry, err = p.export(data.request, batch.Compression())
+	} else {
+		retry, err = p.post(data, batch)
+	}
+	if err == nil {
+		return nil
+	}
+	p.sendErrorMetric.WithLabelValues().Inc()
+	if !retry {
+		p.droppedBatchMetric.WithLabelValues().Inc()
+		reason := fmt.Sprintf("batch is rejected by otlp collector address=%s: %s", p.config.Endpoint, err.Error())
+		batch.ForEach(func(event *pipeline.Event) bool {
+			batch.MarkRejected(event, reason)
+			return true
+		})
+		return nil
+	}
+	p.logger.Errorf("can't send data to otlp collector address=%s: %s", p.config.Endpoint, err.Error())
+
+	return err
+}
+
+// appendRecord converts the event to the LogRecord and adds it to the group of the resource
+func (p *Plugin) appendRecord(data *data, event *pipeline.Event, observed uint64) {
+	// the key is made of the encoded resource fields, since the static attributes are the same for all the records
+	data.keyBuf = data.keyBuf[:0]
+	for i := range p.resourceFields {
+		if node := event.Root.Dig(p.resourceFields[i].field...); node != nil && !node.IsNull() {
+			data.keyBuf = append(data.keyBuf, p.resourceFields[i].key...)
+			data.keyBuf = append(data.keyBuf, '=')
+			data.keyBuf = node.Encode(data.keyBuf)
+		}
+		data.keyBuf = append(data.keyBuf, 0)
+	}
+
+	group, has := data.groups[string(data.keyBuf)]
+	if !has {
+		attributes := make([]*commonpb.KeyValue, len(p.staticResource), len(p.staticResource)+len(p.resourceFields))
+		copy(attributes, p.staticResource)
+		for i := range p.resourceFields {
+			attributes = appendKeyValue(attributes, p.resourceFields[i].key, event.Root.Dig(p.resourceFields[i].field...))
+		}
+
+		group = &logspb.ScopeLogs{Scope: p.scope}
+		data.groups[string(data.keyBuf)] = group
+		data.request.ResourceLogs = append(data.request.ResourceLogs, &logspb.ResourceLogs{
+			Resource:  &resourcepb.Resource{Attributes: attributes},
+			ScopeLogs: []*logspb.ScopeLogs{group},
+		})
+	}
+
+	record := &logspb.LogRecord{ObservedTimeUnixNano: observed}
+
+	if node := event.Root.Dig(p.config.TimeField_...); node != nil {
+		if t, err := pipeline.ParseTime(p.timeFormat, node.AsString()); err == nil {
+			record.TimeUnixNano = uint64(t.UnixNano())
+		}
+	}
+
+	if node := event.Root.Dig(p.config.SeverityField_...); node != nil {
+		record.SeverityText = node.AsString()
+		record.SeverityNumber = logspb.SeverityNumber(p.severityNumber(record.SeverityText))
+	}
+
+	if node := event.Root.Dig(p.config.BodyField_...); node != nil {
+		record.Body = nodeValue(node)
+	}
+	if record.Body == nil {
+		record.Body = stringValue(event.Root.EncodeToString())
+	}
+
+	for i := range p.attributes {
+		record.Attributes = appendKeyValue(record.Attributes, p.attributes[i].key, event.Root.Dig(p.attributes[i].field...))
+	}
+
+	group.LogRecords = append(group.LogRecords, record)
+}
+
+// severityNumber returns the OTLP severity number of the text, zero means the unspecified severity
+func (p *Plugin) severityNumber(text string) int {
+	if len(p.severityMap) != 0 {
+		if number, has := p.severityMap[strings.ToLower(text)]; has {
+			return number
+		}
+	}
+	if strings.EqualFold(strings.TrimSpace(text), "trace") {
+		return severityTrace
+	}
+	return defaultSeverities[pipeline.ParseLevelAsNumber(text)]
+}
+
+// export sends the request by the grpc client, it returns whether the request should be retried if it fails
+func (p *Plugin) export(request *collogspb.ExportLogsServiceRequest, compression pipeline.BatchCompression) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), p.config.RequestTimeout_)
+	defer cancel()
+	if len(p.headers) != 0 {
+		ctx = metadata.NewOutgoingContext(ctx, p.headers)
+	}
+
+	options := make([]grpc.CallOption, 0, 1)
+	if compression != pipeline.BatchCompressionNone {
+		options = append(options, grpc.UseCompressor(string(compression)))
+	}
+
+	resp, err := p.logsClient.Export(ctx, request, options...)
+	if err != nil {
+		return retryableGRPCCodes[status.Code(err)], fmt.Errorf("can't export logs: %w", err)
+	}
+	if rejected := resp.GetPartialSuccess().GetRejectedLogRecords(); rejected != 0 {
+		p.logger.Warnf("%d log records are rejected by otlp collector address=%s: %s",
+			rejected, p.config.Endpoint, resp.GetPartialSuccess().GetErrorMessage())
+	}
+
+	return false, nil
+}
+
+// post sends the request by HTTP, it returns whether the request should be retried if it fails
+func (p *Plugin) post(data *data, batch *pipeline.Batch) (bool, error) {
+	out, err := proto.MarshalOptions{}.MarshalAppend(data.outBuf[:0], data.request)
+	if err != nil {
+		return false, fmt.Errorf("can't marshal request: %w", err)
+	}
+	data.outBuf = out
+
+	body, err := batch.Compress(out)
+	if err != nil {
+		return false, fmt.Errorf("can't compress batch: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, p.url, bytes.NewReader(body))
+	if err != nil {
+		return false, fmt.Errorf("can't create request: %w", err)
+	}
+	for key, value := range p.config.Headers {
+		req.Header.Set(key, value)
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	if batch.Compression() != pipeline.BatchCompressionNone {
+		req.Header.Set("Content-Encoding", string(batch.Compression()))
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return true, fmt.Errorf("can't send request: %w", err)
+	}
+	defer func(Body io.ReadCloser) {
+		_ = Body.Close()
+	}(resp.Body)
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 1024))
+
+	if resp.StatusCode != http.StatusOK {
+		retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusBadGateway ||
+			resp.StatusCode == http.StatusServiceUnavailable || resp.StatusCode == http.StatusGatewayTimeout
+		return retry, fmt.Errorf("response status is %s", resp.Status)
+	}
+
+	return false, nil
+}
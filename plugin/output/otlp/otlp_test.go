@@ -0,0 +1,283 @@
+package otlp
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/ozontech/file.d/pipeline"
+	"github.com/ozontech/file.d/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	collogspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+type request struct {
+	path   string
+	header http.Header
+	body   []byte
+}
+
+func startPlugin(t *testing.T, config *Config, handler func(w http.ResponseWriter)) (*Plugin, chan request) {
+	requests := make(chan request, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		requests <- request{path: r.URL.Path, header: r.Header, body: body}
+		handler(w)
+	}))
+	t.Cleanup(server.Close)
+
+	config.Endpoint = server.URL
+	p := &Plugin{}
+	test.StartOutputPlugin(t, p, config, nil)
+
+	return p, requests
+}
+
+// fakeCollector keeps the requests and responds with the status
+type fakeCollector struct {
+	collogspb.UnimplementedLogsServiceServer
+
+	mu       sync.Mutex
+	status   codes.Code
+	requests []*collogspb.ExportLogsServiceRequest
+	metadata []metadata.MD
+}
+
+func (c *fakeCollector) Export(ctx context.Context, req *collogspb.ExportLogsServiceRequest) (*collogspb.ExportLogsServiceResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	c.requests = append(c.requests, req)
+	c.metadata = append(c.metadata, md)
+	if c.status != codes.OK {
+		return nil, status.Error(c.status, "status "+c.status.String())
+	}
+	return &collogspb.ExportLogsServiceResponse{}, nil
+}
+
+// anyValue returns the AnyValue as the string for the comparison
+func anyValue(t *testing.T, v *commonpb.AnyValue) string {
+	switch v := v.GetValue().(type) {
+	case *commonpb.AnyValue_StringValue:
+		return v.StringValue
+	case *commonpb.AnyValue_BoolValue:
+		return strconv.FormatBool(v.BoolValue)
+	case *commonpb.AnyValue_IntValue:
+		return strconv.FormatInt(v.IntValue, 10)
+	case *commonpb.AnyValue_DoubleValue:
+		return strconv.FormatFloat(v.DoubleValue, 'f', -1, 64)
+	}
+	require.Fail(t, "unexpected any value")
+	return ""
+}
+
+func attributes(t *testing.T, kvs []*commonpb.KeyValue) map[string]string {
+	result := map[string]string{}
+	for _, kv := range kvs {
+		result[kv.Key] = anyValue(t, kv.Value)
+	}
+	return result
+}
+
+type record struct {
+	time       uint64
+	severity   logspb.SeverityNumber
+	text       string
+	body       string
+	attributes map[string]string
+}
+
+func records(t *testing.T, resourceLogs *logspb.ResourceLogs) []record {
+	require.Len(t, resourceLogs.ScopeLogs, 1)
+	scopeLogs := resourceLogs.ScopeLogs[0]
+	assert.Equal(t, "file.d", scopeLogs.GetScope().GetName())
+
+	result := make([]record, 0)
+	for _, m := range scopeLogs.LogRecords {
+		assert.NotZero(t, m.ObservedTimeUnixNano)
+		result = append(result, record{
+			time:       m.TimeUnixNano,
+			severity:   m.SeverityNumber,
+			text:       m.SeverityText,
+			body:       anyValue(t, m.Body),
+			attributes: attributes(t, m.Attributes),
+		})
+	}
+	return result
+}
+
+func TestOTLPHTTP(t *testing.T) {
+	p, requests := startPlugin(t, &Config{
+		Protocol:           "http",
+		AttributeFields:    []string{"trace_id", "http.status", "ok", "tags"},
+		ResourceFields:     []string{"k8s.pod"},
+		ResourceAttributes: map[string]string{"service.name": "payments", "env": "prod"},
+		Headers:            map[string]string{"x-api-key": "secret"},
+	}, func(w http.ResponseWriter) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	batch := test.NewBatch(t,
+		`{"k8s":{"pod":"api-1"},"level":"error","message":"timeout","time":"2023-11-14T22:13:20Z","trace_id":"abc","http":{"status":504},"ok":false}`,
+		`{"k8s":{"pod":"api-2"},"level":"info","message":"started","time":"2023-11-14T22:13:21.5Z","tags":["a","b"]}`,
+		`{"k8s":{"pod":"api-1"},"level":"DEBUG","text":"no message","latency":1.5}`,
+	)
+
+	data := pipeline.WorkerData(nil)
+	require.NoError(t, p.out(&data, batch))
+
+	req := <-requests
+	assert.Equal(t, httpExportPath, req.path)
+	assert.Equal(t, "application/x-protobuf", req.header.Get("Content-Type"))
+	assert.Equal(t, "secret", req.header.Get("X-Api-Key"))
+
+	m := &collogspb.ExportLogsServiceRequest{}
+	require.NoError(t, proto.Unmarshal(req.body, m))
+	require.Len(t, m.ResourceLogs, 2)
+
+	first := m.ResourceLogs[0]
+	assert.Equal(t,
+		map[string]string{"env": "prod", "service.name": "payments", "k8s.pod": "api-1"},
+		attributes(t, first.GetResource().GetAttributes()),
+	)
+	assert.Equal(t, []record{
+		{
+			time:       1700000000000000000,
+			severity:   logspb.SeverityNumber_SEVERITY_NUMBER_ERROR,
+			text:       "error",
+			body:       "timeout",
+			attributes: map[string]string{"trace_id": "abc", "http.status": "504", "ok": "false"},
+		},
+		{
+			severity:   logspb.SeverityNumber_SEVERITY_NUMBER_DEBUG,
+			text:       "DEBUG",
+			body:       `{"k8s":{"pod":"api-1"},"level":"DEBUG","text":"no message","latency":1.5}`,
+			attributes: map[string]string{},
+		},
+	}, records(t, first))
+
+	second := m.ResourceLogs[1]
+	assert.Equal(t,
+		map[string]string{"env": "prod", "service.name": "payments", "k8s.pod": "api-2"},
+		attributes(t, second.GetResource().GetAttributes()),
+	)
+	assert.Equal(t, []record{
+		{
+			time:       1700000001500000000,
+			severity:   logspb.SeverityNumber_SEVERITY_NUMBER_INFO,
+			text:       "info",
+			body:       "started",
+			attributes: map[string]string{"tags": `["a","b"]`},
+		},
+	}, records(t, second))
+}
+
+func TestOTLPGRPC(t *testing.T) {
+	collector := &fakeCollector{}
+	server := grpc.NewServer()
+	collogspb.RegisterLogsServiceServer(server, collector)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	t.Cleanup(server.Stop)
+
+	p := &Plugin{}
+	test.StartOutputPlugin(t, p, &Config{
+		Endpoint:    "http://" + listener.Addr().String(),
+		Protocol:    "grpc",
+		Headers:     map[string]string{"X-Api-Key": "secret"},
+		Compression: "gzip",
+	}, nil)
+
+	batch := test.NewBatch(t, `{"level":"warn","message":"slow"}`)
+	data := pipeline.WorkerData(nil)
+	require.NoError(t, p.out(&data, batch))
+
+	collector.mu.Lock()
+	require.Len(t, collector.requests, 1)
+	assert.Equal(t, []string{"secret"}, collector.metadata[0].Get("x-api-key"))
+	assert.Equal(t, []record{
+		{severity: logspb.SeverityNumber_SEVERITY_NUMBER_WARN, text: "warn", body: "slow", attributes: map[string]string{}},
+	}, records(t, collector.requests[0].ResourceLogs[0]))
+
+	// UNAVAILABLE is retried
+	collector.status = codes.Unavailable
+	collector.mu.Unlock()
+	assert.Error(t, p.out(&data, batch))
+
+	// INVALID_ARGUMENT drops the batch
+	collector.mu.Lock()
+	collector.status = codes.InvalidArgument
+	collector.mu.Unlock()
+	assert.NoError(t, p.out(&data, batch))
+}
+
+func TestOTLPHTTPErrors(t *testing.T) {
+	cases := []struct {
+		name    string
+		status  int
+		wantErr bool
+	}{
+		{name: "unavailable", status: http.StatusServiceUnavailable, wantErr: true},
+		{name: "too_many_requests", status: http.StatusTooManyRequests, wantErr: true},
+		{name: "bad_request", status: http.StatusBadRequest, wantErr: false},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			p, requests := startPlugin(t, &Config{Protocol: "http"}, func(w http.ResponseWriter) {
+				w.WriteHeader(tt.status)
+			})
+
+			data := pipeline.WorkerData(nil)
+			err := p.out(&data, test.NewBatch(t, `{"message":"test"}`))
+			<-requests
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestOTLPSeverity(t *testing.T) {
+	p := &Plugin{severityMap: map[string]int{"verbose": 2}}
+	cases := []struct {
+		text string
+		want int
+	}{
+		{text: "trace", want: 1},
+		{text: "verbose", want: 2},
+		{text: "Debug", want: 5},
+		{text: "info", want: 9},
+		{text: "notice", want: 10},
+		{text: "warning", want: 13},
+		{text: "error", want: 17},
+		{text: "critical", want: 18},
+		{text: "alert", want: 19},
+		{text: "fatal", want: 21},
+		{text: "unknown", want: 0},
+	}
+
+	for _, tt := range cases {
+		assert.Equal(t, tt.want, p.severityNumber(tt.text), tt.text)
+	}
+}
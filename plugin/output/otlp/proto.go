@@ -0,0 +1,50 @@
+package otlp
+
+import (
+	"strconv"
+
+	insaneJSON "github.com/vitkovskii/insane-json"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+)
+
+func stringValue(s string) *commonpb.AnyValue {
+	return &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: s}}
+}
+
+// nodeValue converts the event field to the AnyValue, it returns nil for null,
+// the objects and the arrays of the event are sent as the JSON strings
+func nodeValue(node *insaneJSON.Node) *commonpb.AnyValue {
+	switch {
+	case node.IsNull():
+		return nil
+	case node.IsString():
+		return stringValue(node.AsString())
+	case node.IsTrue():
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_BoolValue{BoolValue: true}}
+	case node.IsFalse():
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_BoolValue{BoolValue: false}}
+	case node.IsNumber():
+		s := node.AsString()
+		if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: i}}
+		}
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return &commonpb.AnyValue{Value: &commonpb.AnyValue_DoubleValue{DoubleValue: f}}
+		}
+		return stringValue(s)
+	default:
+		return stringValue(node.EncodeToString())
+	}
+}
+
+// appendKeyValue appends the attribute of the field if it isn't null
+func appendKeyValue(out []*commonpb.KeyValue, key string, node *insaneJSON.Node) []*commonpb.KeyValue {
+	if node == nil {
+		return out
+	}
+	v := nodeValue(node)
+	if v == nil {
+		return out
+	}
+	return append(out, &commonpb.KeyValue{Key: key, Value: v})
+}
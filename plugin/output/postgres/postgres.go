@@ -21,6 +21,29 @@ import (
 
 /*{ introduction
 It sends the event batches to postgres db using pgx.
+
+The batch is inserted by one multi-row `INSERT` statement. If the conflict target is set,
+the statement is `INSERT ... ON CONFLICT ... DO UPDATE`, so the table keeps the latest state of the rows, e.g.:
+```yaml
+pipelines:
+  example_pipeline:
+    ...
+    output:
+      type: postgres
+      conn_string: "user=postgres host=localhost port=5432 dbname=postgres sslmode=disable pool_max_conns=10"
+      table: services
+      columns:
+        - name: service
+          type: string
+        - name: status
+          type: string
+        - name: updated_at
+          type: timestamp
+      conflict_columns: [service]
+      update_columns: [status, updated_at]
+```
+Postgres can't update the same row twice in one statement, so the events of the batch with the same values
+of the conflict columns are deduplicated: the last event is inserted for `DO UPDATE` and the first one for `DO NOTHING`.
 }*/
 
 var (
@@ -118,6 +141,32 @@ type Config struct {
 	// > and nullable options.
 	Columns []ConfigColumn `json:"columns" required:"true" slice:"true"` // *
 
+	// > @3@4@5@6
+	// >
+	// > Columns of the conflict target `ON CONFLICT (...)`, they must be in `columns`.
+	// > The unique columns are used if it's empty.
+	ConflictColumns []string `json:"conflict_columns" slice:"true"` // *
+
+	// > @3@4@5@6
+	// >
+	// > Constraint of the conflict target `ON CONFLICT ON CONSTRAINT ...`, it can't be set with `conflict_columns`.
+	// > Mark the columns of the constraint as unique to deduplicate the events of the batch.
+	ConflictConstraint string `json:"conflict_constraint"` // *
+
+	// > @3@4@5@6
+	// >
+	// > Action on the conflict:
+	// > * `update` – `DO UPDATE SET` the update columns by the values of the event,
+	// > it's `DO NOTHING` if there are no columns to update
+	// > * `nothing` – `DO NOTHING`
+	ConflictAction string `json:"conflict_action" default:"update" options:"update|nothing"` // *
+
+	// > @3@4@5@6
+	// >
+	// > Columns which are updated on the conflict, they must be in `columns`.
+	// > All the columns except the conflict ones are updated if it's empty.
+	UpdateColumns []string `json:"update_columns" slice:"true"` // *
+
 	// > @3@4@5@6
 	// >
 	// > Retries of insertion.
@@ -209,7 +258,12 @@ func (p *Plugin) Start(config pipeline.AnyConfig, params *pipeline.OutputPluginP
 		p.logger.Fatal("'db_health_check_period' can't be <1")
 	}
 
-	queryBuilder, err := NewQueryBuilder(p.config.Columns, p.config.Table)
+	queryBuilder, err := NewConflictQueryBuilder(p.config.Columns, p.config.Table, ConflictConfig{
+		Columns:       p.config.ConflictColumns,
+		Constraint:    p.config.ConflictConstraint,
+		Action:        p.config.ConflictAction,
+		UpdateColumns: p.config.UpdateColumns,
+	})
 	if err != nil {
 		p.logger.Fatal(err)
 	}
@@ -235,6 +289,11 @@ func (p *Plugin) Start(config pipeline.AnyConfig, params *pipeline.OutputPluginP
 		BatchSizeCount: p.config.BatchSize_,
 		BatchSizeBytes: p.config.BatchSizeBytes_,
 		FlushTimeout:   p.config.BatchFlushTimeout_,
+		MaxEventAge:    params.PipelineSettings.MaxEventAge,
+		CircuitBreaker: params.PipelineSettings.CircuitBreaker,
+		Health:         params.Health,
+		ErrorReporter:  params.ErrorReporter,
+		DeadLetter:     params.DeadLetter,
 		MetricCtl:      params.MetricCtl,
 	})
 
@@ -255,7 +314,7 @@ func (p *Plugin) Out(event *pipeline.Event) {
 	p.batcher.Add(event)
 }
 
-func (p *Plugin) out(_ *pipeline.WorkerData, batch *pipeline.Batch) {
+func (p *Plugin) out(_ *pipeline.WorkerData, batch *pipeline.Batch) error {
 	// _ *pipeline.WorkerData - doesn't required in this plugin, we can't parse
 	// events for uniques through bytes.
 	builder := p.queryBuilder.GetInsertBuilder()
@@ -263,8 +322,10 @@ func (p *Plugin) out(_ *pipeline.WorkerData, batch *pipeline.Batch) {
 	uniqFields := p.queryBuilder.GetUniqueFields()
 
 	// Deduplicate events, pg can't do upsert with duplication.
-	uniqueEventsMap := make(map[string]struct{}, len(batch.Events))
-	var anyValidValue bool
+	// The upsert keeps the last event, so the row gets the latest state.
+	uniqueEventsMap := make(map[string]int, len(batch.Events))
+	rows := make([][]any, 0, len(batch.Events))
+	upsert := p.queryBuilder.IsUpsert()
 
 	for _, event := range batch.Events {
 		fieldValues, uniqueID, err := p.processEvent(event, pgFields, uniqFields)
@@ -285,25 +346,30 @@ func (p *Plugin) out(_ *pipeline.WorkerData, batch *pipeline.Batch) {
 		}
 
 		// passes here only if event valid.
-		if _, ok := uniqueEventsMap[uniqueID]; ok {
+		if i, ok := uniqueEventsMap[uniqueID]; ok {
 			p.duplicatedEventMetric.WithLabelValues().Inc()
 			p.logger.Infof("event duplicated. Fields: %v, values: %v", pgFields, fieldValues)
+			if upsert {
+				rows[i] = fieldValues
+			}
 		} else {
 			if uniqueID != "" {
-				uniqueEventsMap[uniqueID] = struct{}{}
+				uniqueEventsMap[uniqueID] = len(rows)
 			}
-			builder = builder.Values(fieldValues...)
-			anyValidValue = true
+			rows = append(rows, fieldValues)
 		}
 	}
 
-	builder = builder.Suffix(p.queryBuilder.GetPostfix()).PlaceholderFormat(sq.Dollar)
-
 	// no valid events passed.
-	if !anyValidValue {
-		return
+	if len(rows) == 0 {
+		return nil
 	}
 
+	for _, row := range rows {
+		builder = builder.Values(row...)
+	}
+	builder = builder.Suffix(p.queryBuilder.GetPostfix()).PlaceholderFormat(sq.Dollar)
+
 	query, args, err := builder.ToSql()
 	if err != nil {
 		p.logger.Fatalf("Invalid SQL. query: %s, args: %v, err: %v", query, args, err)
@@ -324,7 +390,7 @@ func (p *Plugin) out(_ *pipeline.WorkerData, batch *pipeline.Batch) {
 			time.Sleep(p.config.Retention_)
 			continue
 		}
-		p.writtenEventMetric.WithLabelValues().Add(float64(len(uniqueEventsMap)))
+		p.writtenEventMetric.WithLabelValues().Add(float64(len(rows)))
 		break
 	}
 
@@ -332,6 +398,8 @@ func (p *Plugin) out(_ *pipeline.WorkerData, batch *pipeline.Batch) {
 		p.pool.Close()
 		p.logger.Fatalf("failed insert into %s. query: %s, args: %v, err: %v", p.config.Table, query, args, err)
 	}
+
+	return nil
 }
 
 func (p *Plugin) try(query string, argsSliceInterface []any) error {
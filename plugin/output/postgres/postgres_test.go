@@ -481,6 +481,64 @@ func TestPrivateOutFewUniqueEventsYetWithDeduplicationEventsAnpooladEvents(t *te
 	p.out(nil, batch)
 }
 
+func TestPrivateOutUpsertLastEvent(t *testing.T) {
+	testLogger := logger.Instance
+
+	columns := []ConfigColumn{
+		{
+			Name:       "service",
+			ColumnType: "string",
+		},
+		{
+			Name:       "status",
+			ColumnType: "string",
+		},
+	}
+
+	events := []string{
+		`{"service":"api","status":"starting"}`,
+		`{"service":"db","status":"ok"}`,
+		`{"service":"api","status":"ok"}`,
+	}
+	batch := &pipeline.Batch{}
+	for _, e := range events {
+		root, err := insaneJSON.DecodeString(e)
+		require.NoError(t, err)
+		defer insaneJSON.Release(root)
+		batch.Events = append(batch.Events, &pipeline.Event{Root: root})
+	}
+
+	ctl := gomock.NewController(t)
+	defer ctl.Finish()
+	mockpool := mock_pg.NewMockPgxIface(ctl)
+
+	var ctxMock = reflect.TypeOf((*context.Context)(nil)).Elem()
+
+	mockpool.EXPECT().Query(
+		gomock.AssignableToTypeOf(ctxMock),
+		"INSERT INTO services (service,status) VALUES ($1,$2),($3,$4) ON CONFLICT(service) DO UPDATE SET status=EXCLUDED.status",
+		[]any{preferSimpleProtocol, "api", "ok", "db", "ok"},
+	).Return(&rowsForTest{}, nil).Times(1)
+
+	builder, err := NewConflictQueryBuilder(columns, "services", ConflictConfig{
+		Columns: []string{"service"},
+		Action:  conflictActionUpdate,
+	})
+	require.NoError(t, err)
+
+	p := &Plugin{
+		config:       &Config{Columns: columns, Retry: 3},
+		queryBuilder: builder,
+		pool:         mockpool,
+		logger:       testLogger,
+		ctx:          context.Background(),
+	}
+
+	p.registerMetrics(metric.New("test", prometheus.NewRegistry()))
+
+	require.NoError(t, p.out(nil, batch))
+}
+
 // TODO replace with gomock
 type rowsForTest struct{}
 
@@ -3,6 +3,7 @@ package postgres
 import (
 	"errors"
 	"fmt"
+	"slices"
 	"strings"
 
 	sq "github.com/Masterminds/squirrel"
@@ -10,6 +11,8 @@ import (
 
 var ErrNoColumns = errors.New("no pg columns in config")
 var ErrEmptyTableName = errors.New("table name can't be empty string")
+var ErrConflictTargetAmbiguous = errors.New("conflict columns and conflict constraint can't be set together")
+var ErrNoConflictTarget = errors.New("update columns are set, but there are no conflict columns or constraint")
 
 type column struct {
 	Name    string
@@ -22,11 +25,30 @@ type PgQueryBuilder interface {
 	GetUniqueFields() map[string]pgType
 	GetInsertBuilder() sq.InsertBuilder
 	GetPostfix() string
+	IsUpsert() bool
+}
+
+// ConflictConfig is the ON CONFLICT clause of the insert.
+type ConflictConfig struct {
+	// Columns is the conflict target, the unique columns are used if it's empty.
+	Columns []string
+	// Constraint is the conflict target instead of the columns.
+	Constraint string
+	// Action is update or nothing.
+	Action string
+	// UpdateColumns are updated on the conflict, all the columns except the target ones are used if it's empty.
+	UpdateColumns []string
 }
 
 const (
 	doNothingPostfix = "ON CONFLICT (%s) DO NOTHING"
 	doUpdatePostfix  = "ON CONFLICT(%s) DO UPDATE SET %s"
+
+	doNothingConstraintPostfix = "ON CONFLICT ON CONSTRAINT %s DO NOTHING"
+	doUpdateConstraintPostfix  = "ON CONFLICT ON CONSTRAINT %s DO UPDATE SET %s"
+
+	conflictActionUpdate  = "update"
+	conflictActionNothing = "nothing"
 )
 
 type pgQueryBuilder struct {
@@ -34,10 +56,17 @@ type pgQueryBuilder struct {
 	uniqFields   map[string]pgType
 	queryBuilder sq.InsertBuilder
 	postfix      string
+	upsert       bool
 }
 
 // NewQueryBuilder returns new instance of builder.
+// The unique columns are the conflict target and the others are updated on the conflict.
 func NewQueryBuilder(cfgColumns []ConfigColumn, table string) (PgQueryBuilder, error) {
+	return NewConflictQueryBuilder(cfgColumns, table, ConflictConfig{Action: conflictActionUpdate})
+}
+
+// NewConflictQueryBuilder returns new instance of builder with the ON CONFLICT clause of the config.
+func NewConflictQueryBuilder(cfgColumns []ConfigColumn, table string, conflict ConflictConfig) (PgQueryBuilder, error) {
 	qb := &pgQueryBuilder{}
 
 	if len(cfgColumns) == 0 {
@@ -52,10 +81,21 @@ func NewQueryBuilder(cfgColumns []ConfigColumn, table string) (PgQueryBuilder, e
 	if err != nil {
 		return nil, err
 	}
+	if len(conflict.Columns) > 0 {
+		uniqueColumns, err = qb.conflictFields(pgFields, conflict)
+		if err != nil {
+			return nil, err
+		}
+	}
 	qb.uniqFields = uniqueColumns
-	query, postfix := qb.createQuery(pgFields, table)
-	qb.queryBuilder = query
+
+	postfix, upsert, err := qb.createPostfix(pgFields, uniqueColumns, conflict)
+	if err != nil {
+		return nil, err
+	}
+	qb.queryBuilder = qb.createQuery(pgFields, table)
 	qb.postfix = postfix
+	qb.upsert = upsert
 
 	return qb, nil
 }
@@ -80,6 +120,11 @@ func (qb *pgQueryBuilder) GetUniqueFields() map[string]pgType {
 	return qb.uniqFields
 }
 
+// IsUpsert returns whether the conflicting rows are updated.
+func (qb *pgQueryBuilder) IsUpsert() bool {
+	return qb.upsert
+}
+
 func (qb *pgQueryBuilder) initPgFields(cfgColumns []ConfigColumn) ([]column, map[string]pgType, error) {
 	pgFields := make([]column, 0, len(cfgColumns))
 	uniqFields := make(map[string]pgType)
@@ -109,36 +154,84 @@ func (qb *pgQueryBuilder) initPgFields(cfgColumns []ConfigColumn) ([]column, map
 	return pgFields, uniqFields, nil
 }
 
-func (qb *pgQueryBuilder) createQuery(pgFields []column, table string) (sq.InsertBuilder, string) {
-	postfix := ""
-	uniqFields := []string{}
+// conflictFields returns the conflict columns of the config instead of the unique ones.
+func (qb *pgQueryBuilder) conflictFields(pgFields []column, conflict ConflictConfig) (map[string]pgType, error) {
+	if conflict.Constraint != "" {
+		return nil, ErrConflictTargetAmbiguous
+	}
+
+	types := make(map[string]pgType, len(pgFields))
+	for _, field := range pgFields {
+		types[field.Name] = field.ColType
+	}
+
+	conflictFields := make(map[string]pgType, len(conflict.Columns))
+	for _, name := range conflict.Columns {
+		colType, ok := types[name]
+		if !ok {
+			return nil, fmt.Errorf("conflict column %q isn't in columns", name)
+		}
+		conflictFields[name] = colType
+	}
+	return conflictFields, nil
+}
+
+// createPostfix returns the ON CONFLICT clause and whether it updates the rows.
+// The rows aren't updated if there is nothing to update.
+func (qb *pgQueryBuilder) createPostfix(pgFields []column, conflictFields map[string]pgType, conflict ConflictConfig) (string, bool, error) {
+	// keep the order of the columns in the config
+	targetFields := make([]string, 0, len(conflictFields))
 	updateableFields := make([]string, 0, len(pgFields))
-	fieldsName := make([]string, 0, len(pgFields))
 	for _, field := range pgFields {
-		fieldsName = append(fieldsName, field.Name)
-		if field.Unique {
-			uniqFields = append(uniqFields, field.Name)
+		if _, ok := conflictFields[field.Name]; ok {
+			targetFields = append(targetFields, field.Name)
 		} else {
 			updateableFields = append(updateableFields, field.Name)
 		}
 	}
-	if len(uniqFields) > 0 && len(updateableFields) > 0 {
-		// ON CONFLICT (col1unique, col3unique) DO UPDATE SET col1updateable=EXCLUDED.col1updateable
-		updatePostfix := make([]string, 0, len(updateableFields))
 
-		uniquePostfixString := strings.Join(uniqFields, ",")
+	if len(targetFields) == 0 && conflict.Constraint == "" {
+		if len(conflict.UpdateColumns) > 0 {
+			return "", false, ErrNoConflictTarget
+		}
+		return "", false, nil
+	}
 
-		for _, field := range updateableFields {
-			updatePostfix = append(updatePostfix, field+"=EXCLUDED."+field)
+	if len(conflict.UpdateColumns) > 0 {
+		for _, name := range conflict.UpdateColumns {
+			if !slices.ContainsFunc(pgFields, func(field column) bool { return field.Name == name }) {
+				return "", false, fmt.Errorf("update column %q isn't in columns", name)
+			}
 		}
-		updatePostfixString := strings.Join(updatePostfix, ",")
+		updateableFields = conflict.UpdateColumns
+	}
 
-		postfix = fmt.Sprintf(doUpdatePostfix, uniquePostfixString, updatePostfixString)
-	} else if len(uniqFields) > 0 {
+	if conflict.Action == conflictActionNothing || len(updateableFields) == 0 {
 		// ON CONFLICT (col1, col2, col3) DO NOTHING
-		uniquePostfixString := strings.Join(uniqFields, ",")
-		postfix = fmt.Sprintf(doNothingPostfix, uniquePostfixString)
+		if conflict.Constraint != "" {
+			return fmt.Sprintf(doNothingConstraintPostfix, conflict.Constraint), false, nil
+		}
+		return fmt.Sprintf(doNothingPostfix, strings.Join(targetFields, ",")), false, nil
+	}
+
+	// ON CONFLICT (col1unique, col3unique) DO UPDATE SET col1updateable=EXCLUDED.col1updateable
+	updatePostfix := make([]string, 0, len(updateableFields))
+	for _, field := range updateableFields {
+		updatePostfix = append(updatePostfix, field+"=EXCLUDED."+field)
+	}
+	updatePostfixString := strings.Join(updatePostfix, ",")
+
+	if conflict.Constraint != "" {
+		return fmt.Sprintf(doUpdateConstraintPostfix, conflict.Constraint, updatePostfixString), true, nil
+	}
+	return fmt.Sprintf(doUpdatePostfix, strings.Join(targetFields, ","), updatePostfixString), true, nil
+}
+
+func (qb *pgQueryBuilder) createQuery(pgFields []column, table string) sq.InsertBuilder {
+	fieldsName := make([]string, 0, len(pgFields))
+	for _, field := range pgFields {
+		fieldsName = append(fieldsName, field.Name)
 	}
 
-	return sq.Insert(table).Columns(fieldsName...), postfix
+	return sq.Insert(table).Columns(fieldsName...)
 }
@@ -322,3 +322,98 @@ func TestGetUniqueFields(t *testing.T) {
 	uniqueFields := queryBuilder.GetUniqueFields()
 	require.Equal(t, expectedUniqueFields, uniqueFields)
 }
+
+func TestNewConflictQueryBuilder(t *testing.T) {
+	columns := []ConfigColumn{
+		{
+			Name:       "service",
+			ColumnType: "string",
+			Unique:     true,
+		},
+		{
+			Name:       "status",
+			ColumnType: "string",
+		},
+		{
+			Name:       "updated_at",
+			ColumnType: colTypeTimestamp,
+		},
+	}
+
+	cases := []struct {
+		name            string
+		conflict        ConflictConfig
+		returnedPostfix string
+		uniqueFields    map[string]pgType
+		upsert          bool
+		err             bool
+	}{
+		{
+			name:            "conflict columns",
+			conflict:        ConflictConfig{Columns: []string{"status"}, Action: conflictActionUpdate},
+			returnedPostfix: "ON CONFLICT(status) DO UPDATE SET service=EXCLUDED.service,updated_at=EXCLUDED.updated_at",
+			uniqueFields:    map[string]pgType{"status": pgString},
+			upsert:          true,
+		},
+		{
+			name:            "update columns",
+			conflict:        ConflictConfig{Action: conflictActionUpdate, UpdateColumns: []string{"status"}},
+			returnedPostfix: "ON CONFLICT(service) DO UPDATE SET status=EXCLUDED.status",
+			uniqueFields:    map[string]pgType{"service": pgString},
+			upsert:          true,
+		},
+		{
+			name:            "do nothing",
+			conflict:        ConflictConfig{Action: conflictActionNothing},
+			returnedPostfix: "ON CONFLICT (service) DO NOTHING",
+			uniqueFields:    map[string]pgType{"service": pgString},
+		},
+		{
+			name:            "constraint",
+			conflict:        ConflictConfig{Constraint: "services_pkey", Action: conflictActionUpdate},
+			returnedPostfix: "ON CONFLICT ON CONSTRAINT services_pkey DO UPDATE SET status=EXCLUDED.status,updated_at=EXCLUDED.updated_at",
+			uniqueFields:    map[string]pgType{"service": pgString},
+			upsert:          true,
+		},
+		{
+			name:            "constraint do nothing",
+			conflict:        ConflictConfig{Constraint: "services_pkey", Action: conflictActionNothing},
+			returnedPostfix: "ON CONFLICT ON CONSTRAINT services_pkey DO NOTHING",
+			uniqueFields:    map[string]pgType{"service": pgString},
+		},
+		{
+			name:     "columns and constraint",
+			conflict: ConflictConfig{Columns: []string{"service"}, Constraint: "services_pkey"},
+			err:      true,
+		},
+		{
+			name:     "unknown conflict column",
+			conflict: ConflictConfig{Columns: []string{"host"}},
+			err:      true,
+		},
+		{
+			name:     "unknown update column",
+			conflict: ConflictConfig{UpdateColumns: []string{"host"}},
+			err:      true,
+		},
+	}
+
+	for _, tCase := range cases {
+		t.Run(tCase.name, func(t *testing.T) {
+			queryBuilder, err := NewConflictQueryBuilder(columns, "services", tCase.conflict)
+			if tCase.err {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			require.Equal(t, tCase.returnedPostfix, queryBuilder.GetPostfix())
+			require.Equal(t, tCase.uniqueFields, queryBuilder.GetUniqueFields())
+			require.Equal(t, tCase.upsert, queryBuilder.IsUpsert())
+		})
+	}
+
+	noUnique := []ConfigColumn{{Name: "status", ColumnType: "string"}}
+	_, err := NewConflictQueryBuilder(noUnique, "services", ConflictConfig{UpdateColumns: []string{"status"}})
+	require.ErrorIs(t, err, ErrNoConflictTarget)
+}
@@ -0,0 +1,138 @@
+package prometheus_remote_write
+
+import (
+	"math"
+	"sort"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Messages of the remote write protocol are encoded by hand, since the plugin doesn't depend on generated code,
+// see https://github.com/prometheus/prometheus/blob/main/prompb/remote.proto.
+
+const (
+	writeRequestTimeseriesField = 1
+
+	timeSeriesLabelsField  = 1
+	timeSeriesSamplesField = 2
+
+	labelNameField  = 1
+	labelValueField = 2
+
+	sampleValueField     = 1
+	sampleTimestampField = 2
+)
+
+// label is the label of the series, the names are sorted
+type label struct {
+	name  string
+	value string
+}
+
+type sample struct {
+	// ts is the time in milliseconds
+	ts    int64
+	value float64
+}
+
+type series struct {
+	// labels are the encoded Label messages of the series
+	labels  []byte
+	samples []sample
+}
+
+// payload groups the samples of the batch by the series
+type payload struct {
+	series map[string]*series
+	// order keeps the order of the series, so the encoded payload is stable
+	order []*series
+}
+
+func newPayload() *payload {
+	return &payload{
+		series: make(map[string]*series),
+		order:  make([]*series, 0),
+	}
+}
+
+func (p *payload) reset() {
+	clear(p.series)
+	clear(p.order)
+	p.order = p.order[:0]
+}
+
+// add appends the sample to the series of the encoded labels,
+// it returns false if the series is new and there are maxSeries series already
+func (p *payload) add(labels []byte, s sample, maxSeries int) bool {
+	ser, has := p.series[string(labels)]
+	if !has {
+		if len(p.order) >= maxSeries {
+			return false
+		}
+		ser = &series{
+			labels:  append([]byte(nil), labels...),
+			samples: make([]sample, 0, 1),
+		}
+		p.series[string(ser.labels)] = ser
+		p.order = append(p.order, ser)
+	}
+
+	ser.samples = append(ser.samples, s)
+	return true
+}
+
+// dedupSamples sorts the samples of the series by the time, the last sample of the batch wins for the same time,
+// since the samples of the series should be ordered and unique
+func (p *payload) dedupSamples() {
+	for _, ser := range p.order {
+		sort.SliceStable(ser.samples, func(i, j int) bool {
+			return ser.samples[i].ts < ser.samples[j].ts
+		})
+
+		samples := ser.samples[:0]
+		for _, s := range ser.samples {
+			if len(samples) != 0 && samples[len(samples)-1].ts == s.ts {
+				samples[len(samples)-1] = s
+				continue
+			}
+			samples = append(samples, s)
+		}
+		ser.samples = samples
+	}
+}
+
+// encode appends the WriteRequest message, the series message is built in seriesBuf
+func (p *payload) encode(out, seriesBuf []byte) ([]byte, []byte) {
+	for _, ser := range p.order {
+		seriesBuf = append(seriesBuf[:0], ser.labels...)
+		for _, s := range ser.samples {
+			size := protowire.SizeTag(sampleValueField) + protowire.SizeFixed64() +
+				protowire.SizeTag(sampleTimestampField) + protowire.SizeVarint(uint64(s.ts))
+			seriesBuf = protowire.AppendTag(seriesBuf, timeSeriesSamplesField, protowire.BytesType)
+			seriesBuf = protowire.AppendVarint(seriesBuf, uint64(size))
+			seriesBuf = protowire.AppendTag(seriesBuf, sampleValueField, protowire.Fixed64Type)
+			seriesBuf = protowire.AppendFixed64(seriesBuf, math.Float64bits(s.value))
+			seriesBuf = protowire.AppendTag(seriesBuf, sampleTimestampField, protowire.VarintType)
+			seriesBuf = protowire.AppendVarint(seriesBuf, uint64(s.ts))
+		}
+
+		out = protowire.AppendTag(out, writeRequestTimeseriesField, protowire.BytesType)
+		out = protowire.AppendBytes(out, seriesBuf)
+	}
+	return out, seriesBuf
+}
+
+// appendLabels appends the Label messages of the series, they are also the key of the series
+func appendLabels(out []byte, labels []label) []byte {
+	for _, l := range labels {
+		size := protowire.SizeTag(labelNameField) + protowire.SizeBytes(len(l.name)) +
+			protowire.SizeTag(labelValueField) + protowire.SizeBytes(len(l.value))
+		out = protowire.AppendTag(out, timeSeriesLabelsField, protowire.BytesType)
+		out = protowire.AppendVarint(out, uint64(size))
+		out = protowire.AppendTag(out, labelNameField, protowire.BytesType)
+		out = protowire.AppendString(out, l.name)
+		out = protowire.AppendTag(out, labelValueField, protowire.BytesType)
+		out = protowire.AppendString(out, l.value)
+	}
+	return out
+}
@@ -0,0 +1,537 @@
+// Package prometheus_remote_write is an output plugin that sends the samples built from events by Prometheus remote write.
+package prometheus_remote_write
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/ozontech/file.d/cfg"
+	"github.com/ozontech/file.d/fd"
+	"github.com/ozontech/file.d/metric"
+	"github.com/ozontech/file.d/pipeline"
+	"github.com/ozontech/file.d/xtls"
+	"github.com/prometheus/client_golang/prometheus"
+	insaneJSON "github.com/vitkovskii/insane-json"
+	"go.uber.org/zap"
+)
+
+/*{ introduction
+It turns events into the samples and sends them to the endpoint of the Prometheus remote write protocol,
+e.g. Prometheus, VictoriaMetrics, Mimir or Thanos, so the metrics can be derived from the logs.
+
+Each event is the sample of the series:
+* the metric name is built by `metric_name` template, the `${field}` parts are replaced by the values of the event fields
+* the labels are taken from `label_fields` and `static_labels`, the missing and the empty ones are skipped
+* the value is taken from `value_field`, it's a number, a numeric string or a boolean
+* the timestamp is taken from `time_field`, the current time is used if it's missing
+
+The events without the value or the fields of the template are skipped.
+The samples of the same series within the batch are sent in one series ordered by the time,
+the last sample wins for the same time. The number of the series in the batch is capped by `max_series`,
+the samples of the extra series are dropped.
+
+The payload is the protobuf compressed by snappy.
+The batch is retried if the endpoint responds with `429` or `5xx` status, other errors of the batch are logged and the batch is dropped.
+
+**Example:**
+```yaml
+pipelines:
+  example_pipeline:
+    ...
+    output:
+      type: prometheus_remote_write
+      endpoint: http://prometheus:9090/api/v1/write
+      metric_name: "http_request_duration_${unit}"
+      value_field: duration
+      label_fields: [service, http.status]
+      static_labels:
+        source: file.d
+    ...
+```
+The event `{"service":"api","http":{"status":200},"duration":0.25,"unit":"seconds","time":"2023-11-14T22:13:20Z"}`
+is sent as the sample `http_request_duration_seconds{http_status="200", service="api", source="file.d"} 0.25 1700000000000`.
+}*/
+
+const (
+	outPluginType = "prometheus_remote_write"
+
+	metricNameLabel = "__name__"
+)
+
+var (
+	labelNameRe  = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+	metricNameRe = regexp.MustCompile(`[^a-zA-Z0-9_:]`)
+)
+
+type Plugin struct {
+	config       *Config
+	client       *http.Client
+	logger       *zap.SugaredLogger
+	avgEventSize int
+	batcher      *pipeline.Batcher
+	controller   pipeline.OutputPluginController
+
+	template   []cfg.SubstitutionOp
+	labels     []labelConfig
+	authHeader string
+	timeFormat string
+
+	// plugin metrics
+
+	sendErrorMetric      *prometheus.CounterVec
+	droppedBatchMetric   *prometheus.CounterVec
+	droppedSamplesMetric *prometheus.CounterVec
+}
+
+// labelConfig is the label taken from the event field or the static one if the field is empty,
+// the metric name label has neither the field nor the value
+type labelConfig struct {
+	name  string
+	field []string
+	value string
+}
+
+// ! config-params
+// ^ config-params
+type Config struct {
+	// > @3@4@5@6
+	// >
+	// > A full URI of the remote write API. Format: `http://127.0.0.1:9090/api/v1/write`.
+	Endpoint string `json:"endpoint" required:"true"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The template of the metric name, e.g. `app_${service}_requests_total`.
+	// > The characters except letters, digits, `_` and `:` are replaced by `_`.
+	MetricName string `json:"metric_name" required:"true"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The event field which contains the value of the sample.
+	ValueField  cfg.FieldSelector `json:"value_field" parse:"selector" required:"true"` // *
+	ValueField_ []string
+
+	// > @3@4@5@6
+	// >
+	// > The event fields which are sent as the labels, e.g. `service` or `http.status`.
+	// > The label name is the field selector with all the characters except letters, digits and `_` replaced by `_`.
+	LabelFields []string `json:"label_fields"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The labels which are added to all the series.
+	StaticLabels map[string]string `json:"static_labels"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The max number of the series in one batch, the samples of the extra series are dropped.
+	MaxSeries int `json:"max_series" default:"10000"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The event field which contains the time of the sample. If it's missing or doesn't fit `time_format`,
+	// > the current time is used.
+	TimeField  cfg.FieldSelector `json:"time_field" parse:"selector" default:"time"` // *
+	TimeField_ []string
+
+	// > @3@4@5@6
+	// >
+	// > The format of `time_field`, it's a layout or one of the aliases of the `convert_date` plugin.
+	TimeFormat string `json:"time_format" default:"rfc3339nano"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The headers which are added to the requests, e.g. `X-Scope-OrgID` of the tenant.
+	Headers map[string]string `json:"headers"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The username of the basic auth.
+	Username string `json:"username"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The password of the basic auth.
+	Password string `json:"password"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The bearer token. If it's set, the basic auth isn't used.
+	BearerToken string `json:"bearer_token"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The CA certificate to verify the endpoint, it's a PEM content or a path to the file.
+	CACert string `json:"ca_cert"` // *
+
+	// > @3@4@5@6
+	// >
+	// > Skip the verification of the endpoint certificate.
+	InsecureSkipVerify bool `json:"insecure_skip_verify" default:"false"` // *
+
+	// > @3@4@5@6
+	// >
+	// > How many workers will be instantiated to send batches.
+	WorkersCount  cfg.Expression `json:"workers_count" default:"gomaxprocs*4" parse:"expression"` // *
+	WorkersCount_ int
+
+	// > @3@4@5@6
+	// >
+	// > Client timeout when sends requests to the endpoint.
+	RequestTimeout  cfg.Duration `json:"request_timeout" default:"10s" parse:"duration"` // *
+	RequestTimeout_ time.Duration
+
+	// > @3@4@5@6
+	// >
+	// > A maximum quantity of events to pack into one batch.
+	BatchSize  cfg.Expression `json:"batch_size" default:"capacity/4" parse:"expression"` // *
+	BatchSize_ int
+
+	// > @3@4@5@6
+	// >
+	// > A minimum size of events in a batch to send.
+	// > If both batch_size and batch_size_bytes are set, they will work together.
+	BatchSizeBytes  cfg.Expression `json:"batch_size_bytes" default:"0" parse:"expression"` // *
+	BatchSizeBytes_ int
+
+	// > @3@4@5@6
+	// >
+	// > After this timeout the batch will be sent even if batch isn't completed.
+	BatchFlushTimeout  cfg.Duration `json:"batch_flush_timeout" default:"200ms" parse:"duration"` // *
+	BatchFlushTimeout_ time.Duration
+
+	// > @3@4@5@6
+	// >
+	// > Retries of the batch sending.
+	Retry int `json:"retry" default:"10"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The delay before the first retry, it's doubled after each retry.
+	Retention  cfg.Duration `json:"retention" default:"1s" parse:"duration"` // *
+	Retention_ time.Duration
+
+	// > @3@4@5@6
+	// >
+	// > The max delay between the retries.
+	MaxRetention  cfg.Duration `json:"max_retention" default:"1m" parse:"duration"` // *
+	MaxRetention_ time.Duration
+
+	// > @3@4@5@6
+	// >
+	// > Exit with the non-zero code if the batch isn't sent after all the retries, otherwise the batch is dropped.
+	FatalOnFailedInsert bool `json:"fatal_on_failed_insert" default:"false"` // *
+}
+
+type data struct {
+	payload   *payload
+	labels    []label
+	nameBuf   []byte
+	labelsBuf []byte
+	seriesBuf []byte
+	outBuf    []byte
+	snappyBuf []byte
+}
+
+func init() {
+	fd.DefaultPluginRegistry.RegisterOutput(&pipeline.PluginStaticInfo{
+		Type:    outPluginType,
+		Factory: Factory,
+	})
+}
+
+func Factory() (pipeline.AnyPlugin, pipeline.AnyConfig) {
+	return &Plugin{}, &Config{}
+}
+
+func (p *Plugin) Start(config pipeline.AnyConfig, params *pipeline.OutputPluginParams) {
+	p.controller = params.Controller
+	p.logger = params.Logger
+	p.avgEventSize = params.PipelineSettings.AvgEventSize
+	p.config = config.(*Config)
+	p.registerMetrics(params.MetricCtl)
+
+	if err := p.prepare(); err != nil {
+		p.logger.Fatal(err.Error())
+	}
+
+	client, err := xtls.NewHTTPClient(p.config.CACert, p.config.InsecureSkipVerify, p.config.RequestTimeout_)
+	if err != nil {
+		p.logger.Fatalf("can't create client: %s", err.Error())
+	}
+	p.client = client
+
+	p.batcher = pipeline.NewBatcher(pipeline.BatcherOptions{
+		PipelineName:   params.PipelineName,
+		OutputType:     outPluginType,
+		OutFn:          p.out,
+		Controller:     p.controller,
+		Workers:        p.config.WorkersCount_,
+		BatchSizeCount: p.config.BatchSize_,
+		BatchSizeBytes: p.config.BatchSizeBytes_,
+		FlushTimeout:   p.config.BatchFlushTimeout_,
+		MaxEventAge:    params.PipelineSettings.MaxEventAge,
+		CircuitBreaker: params.PipelineSettings.CircuitBreaker,
+		Health:         params.Health,
+		ErrorReporter:  params.ErrorReporter,
+		DeadLetter:     params.DeadLetter,
+		MetricCtl:      params.MetricCtl,
+		Retry: pipeline.BatcherRetry{
+			MaxRetries:        p.config.Retry,
+			InitialBackoff:    p.config.Retention_,
+			BackoffMultiplier: 2,
+			MaxBackoff:        p.config.MaxRetention_,
+		},
+		FatalOnFailedInsert: p.config.FatalOnFailedInsert,
+	})
+
+	p.batcher.Start(context.TODO())
+}
+
+// prepare parses the template, checks the labels and builds the values which are the same for all the batches
+func (p *Plugin) prepare() error {
+	template, err := cfg.ParseSubstitution(p.config.MetricName)
+	if err != nil {
+		return fmt.Errorf("wrong metric name template %q: %w", p.config.MetricName, err)
+	}
+	p.template = template
+
+	if p.config.MaxSeries <= 0 {
+		return fmt.Errorf("max_series should be positive")
+	}
+
+	p.labels = make([]labelConfig, 0, len(p.config.LabelFields)+len(p.config.StaticLabels)+1)
+	p.labels = append(p.labels, labelConfig{name: metricNameLabel})
+	names := map[string]bool{metricNameLabel: true}
+	for _, field := range p.config.LabelFields {
+		name := labelNameRe.ReplaceAllString(field, "_")
+		if names[name] {
+			return fmt.Errorf("duplicate label %s", name)
+		}
+		names[name] = true
+		p.labels = append(p.labels, labelConfig{name: name, field: cfg.ParseFieldSelector(field)})
+	}
+	for name, value := range p.config.StaticLabels {
+		if name == "" || labelNameRe.MatchString(name) {
+			return fmt.Errorf("wrong label name %q, it should contain only letters, digits and _", name)
+		}
+		if names[name] {
+			return fmt.Errorf("duplicate label %s", name)
+		}
+		names[name] = true
+		p.labels = append(p.labels, labelConfig{name: name, value: value})
+	}
+	// the labels of the series should be sorted by the name
+	sort.Slice(p.labels, func(i, j int) bool {
+		return p.labels[i].name < p.labels[j].name
+	})
+
+	p.timeFormat = p.config.TimeFormat
+	if format, err := pipeline.ParseFormatName(p.config.TimeFormat); err == nil {
+		p.timeFormat = format
+	}
+
+	if p.config.BearerToken != "" {
+		p.authHeader = "Bearer " + p.config.BearerToken
+	} else if p.config.Username != "" {
+		credentials := p.config.Username + ":" + p.config.Password
+		p.authHeader = "Basic " + base64.StdEncoding.EncodeToString([]byte(credentials))
+	}
+
+	return nil
+}
+
+func (p *Plugin) registerMetrics(ctl *metric.Ctl) {
+	p.sendErrorMetric = ctl.RegisterCounter("output_prometheus_remote_write_send_error", "Total remote write send errors")
+	p.droppedBatchMetric = ctl.RegisterCounter("output_prometheus_remote_write_dropped_batches_total", "Number of batches rejected by the endpoint")
+	p.droppedSamplesMetric = ctl.RegisterCounter("output_prometheus_remote_write_dropped_samples_total",
+		"Number of events which aren't sent as the samples",
+		"reason",
+	)
+}
+
+func (p *Plugin) Stop() {
+	p.batcher.Stop()
+}
+
+func (p *Plugin) Out(event *pipeline.Event) {
+	p.batcher.Add(event)
+}
+
+func (p *Plugin) out(workerData *pipeline.WorkerData, batch *pipeline.Batch) error {
+	if *workerData == nil {
+		*workerData = &data{
+			payload: newPayload(),
+			labels:  make([]label, 0, len(p.labels)),
+			outBuf:  make([]byte, 0, p.config.BatchSize_*p.avgEventSize),
+		}
+	}
+
+	data := (*workerData).(*data)
+	// handle too much memory consumption
+	if cap(data.outBuf) > p.config.BatchSize_*p.avgEventSize {
+		data.outBuf = make([]byte, 0, p.config.BatchSize_*p.avgEventSize)
+	}
+
+	data.payload.reset()
+	now := time.Now().UnixMilli()
+	batch.ForEach(func(event *pipeline.Event) bool {
+		p.appendEvent(data, event, now)
+		return true
+	})
+
+	if len(data.payload.order) == 0 {
+		return nil
+	}
+	data.payload.dedupSamples()
+
+	data.outBuf, data.seriesBuf = data.payload.encode(data.outBuf[:0], data.seriesBuf)
+	data.snappyBuf = snappy.Encode(data.snappyBuf[:cap(data.snappyBuf)], data.outBuf)
+
+	retry, err := p.send(data.snappyBuf)
+	if err == nil {
+		return nil
+	}
+	p.sendErrorMetric.WithLabelValues().Inc()
+	if !retry {
+		p.droppedBatchMetric.WithLabelValues().Inc()
+		reason := fmt.Sprintf("batch is rejected by remote write endpoint address=%s: %s", p.config.Endpoint, err.Error())
+		batch.ForEach(func(event *pipeline.Event) bool {
+			batch.MarkRejected(event, reason)
+			return true
+		})
+		return nil
+	}
+	p.logger.Errorf("can't send data to remote write endpoint address=%s: %s", p.config.Endpoint, err.Error())
+
+	return err
+}
+
+// appendEvent adds the sample of the event to its series
+func (p *Plugin) appendEvent(data *data, event *pipeline.Event, now int64) {
+	value, ok := parseValue(event.Root.Dig(p.config.ValueField_...))
+	if !ok {
+		p.droppedSamplesMetric.WithLabelValues("no_value").Inc()
+		return
+	}
+
+	name, ok := p.metricName(data, event)
+	if !ok {
+		p.droppedSamplesMetric.WithLabelValues("no_name").Inc()
+		return
+	}
+
+	data.labels = data.labels[:0]
+	for i := range p.labels {
+		l := &p.labels[i]
+		switch {
+		case l.name == metricNameLabel:
+			data.labels = append(data.labels, label{name: l.name, value: name})
+		case len(l.field) == 0:
+			data.labels = append(data.labels, label{name: l.name, value: l.value})
+		default:
+			node := event.Root.Dig(l.field...)
+			if node == nil || node.AsString() == "" {
+				continue
+			}
+			data.labels = append(data.labels, label{name: l.name, value: node.AsString()})
+		}
+	}
+
+	ts := now
+	if node := event.Root.Dig(p.config.TimeField_...); node != nil {
+		if t, err := pipeline.ParseTime(p.timeFormat, node.AsString()); err == nil {
+			ts = t.UnixMilli()
+		}
+	}
+
+	data.labelsBuf = appendLabels(data.labelsBuf[:0], data.labels)
+	if !data.payload.add(data.labelsBuf, sample{ts: ts, value: value}, p.config.MaxSeries) {
+		p.droppedSamplesMetric.WithLabelValues("series_limit").Inc()
+	}
+}
+
+// metricName builds the metric name by the template, it returns false if any field of the template is missing
+func (p *Plugin) metricName(data *data, event *pipeline.Event) (string, bool) {
+	data.nameBuf = data.nameBuf[:0]
+	for i := range p.template {
+		op := &p.template[i]
+		if op.Kind == cfg.SubstitutionOpKindRaw {
+			data.nameBuf = append(data.nameBuf, op.Data[0]...)
+			continue
+		}
+		node := event.Root.Dig(op.Data...)
+		if node == nil || node.AsString() == "" {
+			return "", false
+		}
+		data.nameBuf = append(data.nameBuf, node.AsString()...)
+	}
+
+	name := metricNameRe.ReplaceAllString(string(data.nameBuf), "_")
+	if name[0] >= '0' && name[0] <= '9' {
+		name = "_" + name
+	}
+	return name, true
+}
+
+// parseValue returns the value of the numbers, the numeric strings and the booleans
+func parseValue(node *insaneJSON.Node) (float64, bool) {
+	switch {
+	case node == nil:
+		return 0, false
+	case node.IsTrue():
+		return 1, true
+	case node.IsFalse():
+		return 0, true
+	case node.IsNumber() || node.IsString():
+		value, err := strconv.ParseFloat(strings.TrimSpace(node.AsString()), 64)
+		return value, err == nil
+	default:
+		return 0, false
+	}
+}
+
+// send returns whether the request should be retried if it fails
+func (p *Plugin) send(body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, p.config.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return false, fmt.Errorf("can't create request: %w", err)
+	}
+
+	for key, value := range p.config.Headers {
+		req.Header.Set(key, value)
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	if p.authHeader != "" {
+		req.Header.Set("Authorization", p.authHeader)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return true, fmt.Errorf("can't send request: %w", err)
+	}
+	defer func(Body io.ReadCloser) {
+		_ = Body.Close()
+	}(resp.Body)
+
+	if resp.StatusCode >= http.StatusOK && resp.StatusCode < http.StatusMultipleChoices {
+		return false, nil
+	}
+
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= http.StatusInternalServerError
+
+	return retry, fmt.Errorf("response status is %s: %s", resp.Status, bytes.TrimSpace(b))
+}
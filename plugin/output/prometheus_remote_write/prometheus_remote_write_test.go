@@ -0,0 +1,214 @@
+package prometheus_remote_write
+
+import (
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/ozontech/file.d/pipeline"
+	"github.com/ozontech/file.d/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+type request struct {
+	header http.Header
+	body   []byte
+}
+
+func startPlugin(t *testing.T, config *Config, status int) (*Plugin, chan request) {
+	requests := make(chan request, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		requests <- request{header: r.Header, body: body}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+
+	config.Endpoint = server.URL
+	p := &Plugin{}
+	test.StartOutputPlugin(t, p, config, nil)
+
+	return p, requests
+}
+
+type decodedSeries struct {
+	labels  [][2]string
+	samples []sample
+}
+
+func consumeField(t *testing.T, b []byte) (protowire.Number, []byte, uint64, []byte) {
+	num, typ, n := protowire.ConsumeTag(b)
+	require.GreaterOrEqual(t, n, 0)
+	b = b[n:]
+	switch typ {
+	case protowire.BytesType:
+		v, n := protowire.ConsumeBytes(b)
+		require.GreaterOrEqual(t, n, 0)
+		return num, v, 0, b[n:]
+	case protowire.VarintType:
+		v, n := protowire.ConsumeVarint(b)
+		require.GreaterOrEqual(t, n, 0)
+		return num, nil, v, b[n:]
+	case protowire.Fixed64Type:
+		v, n := protowire.ConsumeFixed64(b)
+		require.GreaterOrEqual(t, n, 0)
+		return num, nil, v, b[n:]
+	}
+	require.Failf(t, "unexpected wire type", "%d", typ)
+	return 0, nil, 0, nil
+}
+
+func decodeRequest(t *testing.T, body []byte) []decodedSeries {
+	b, err := snappy.Decode(nil, body)
+	require.NoError(t, err)
+
+	result := make([]decodedSeries, 0)
+	for len(b) > 0 {
+		num, seriesBytes, _, rest := consumeField(t, b)
+		b = rest
+		require.Equal(t, protowire.Number(writeRequestTimeseriesField), num)
+
+		s := decodedSeries{}
+		for len(seriesBytes) > 0 {
+			num, msg, _, rest := consumeField(t, seriesBytes)
+			seriesBytes = rest
+			switch num {
+			case timeSeriesLabelsField:
+				l := [2]string{}
+				for len(msg) > 0 {
+					num, v, _, rest := consumeField(t, msg)
+					msg = rest
+					l[num-1] = string(v)
+				}
+				s.labels = append(s.labels, l)
+			case timeSeriesSamplesField:
+				smp := sample{}
+				for len(msg) > 0 {
+					num, _, v, rest := consumeField(t, msg)
+					msg = rest
+					if num == sampleValueField {
+						smp.value = math.Float64frombits(v)
+					} else {
+						smp.ts = int64(v)
+					}
+				}
+				s.samples = append(s.samples, smp)
+			}
+		}
+		result = append(result, s)
+	}
+	return result
+}
+
+func TestRemoteWrite(t *testing.T) {
+	p, requests := startPlugin(t, &Config{
+		MetricName:   "http_request_duration_${unit}",
+		ValueField:   "duration",
+		LabelFields:  []string{"service", "http.status"},
+		StaticLabels: map[string]string{"source": "file.d"},
+		BearerToken:  "token",
+		Headers:      map[string]string{"X-Scope-OrgID": "team-a"},
+	}, http.StatusNoContent)
+
+	batch := test.NewBatch(t,
+		`{"service":"api","http":{"status":200},"duration":0.25,"unit":"seconds","time":"2023-11-14T22:13:21Z"}`,
+		`{"service":"api","http":{"status":200},"duration":"0.5","unit":"seconds","time":"2023-11-14T22:13:20Z"}`,
+		`{"service":"api","http":{"status":200},"duration":0.75,"unit":"seconds","time":"2023-11-14T22:13:21Z"}`,
+		`{"service":"db","duration":true,"unit":"total","time":"2023-11-14T22:13:20Z"}`,
+		`{"service":"db","duration":"abc","unit":"seconds","time":"2023-11-14T22:13:20Z"}`,
+		`{"service":"db","duration":1,"time":"2023-11-14T22:13:20Z"}`,
+	)
+
+	data := pipeline.WorkerData(nil)
+	require.NoError(t, p.out(&data, batch))
+
+	req := <-requests
+	assert.Equal(t, "application/x-protobuf", req.header.Get("Content-Type"))
+	assert.Equal(t, "snappy", req.header.Get("Content-Encoding"))
+	assert.Equal(t, "0.1.0", req.header.Get("X-Prometheus-Remote-Write-Version"))
+	assert.Equal(t, "Bearer token", req.header.Get("Authorization"))
+	assert.Equal(t, "team-a", req.header.Get("X-Scope-OrgID"))
+
+	assert.Equal(t, []decodedSeries{
+		{
+			labels: [][2]string{
+				{"__name__", "http_request_duration_seconds"},
+				{"http_status", "200"},
+				{"service", "api"},
+				{"source", "file.d"},
+			},
+			samples: []sample{
+				{ts: 1700000000000, value: 0.5},
+				{ts: 1700000001000, value: 0.75},
+			},
+		},
+		{
+			labels: [][2]string{
+				{"__name__", "http_request_duration_total"},
+				{"service", "db"},
+				{"source", "file.d"},
+			},
+			samples: []sample{
+				{ts: 1700000000000, value: 1},
+			},
+		},
+	}, decodeRequest(t, req.body))
+}
+
+func TestRemoteWriteMaxSeries(t *testing.T) {
+	p, requests := startPlugin(t, &Config{
+		MetricName:  "requests",
+		ValueField:  "count",
+		LabelFields: []string{"pod"},
+		MaxSeries:   2,
+	}, http.StatusOK)
+
+	batch := test.NewBatch(t,
+		`{"pod":"a","count":1,"time":"2023-11-14T22:13:20Z"}`,
+		`{"pod":"b","count":2,"time":"2023-11-14T22:13:20Z"}`,
+		`{"pod":"c","count":3,"time":"2023-11-14T22:13:20Z"}`,
+		`{"pod":"a","count":4,"time":"2023-11-14T22:13:21Z"}`,
+	)
+
+	data := pipeline.WorkerData(nil)
+	require.NoError(t, p.out(&data, batch))
+
+	series := decodeRequest(t, (<-requests).body)
+	require.Len(t, series, 2)
+	assert.Equal(t, [2]string{"pod", "a"}, series[0].labels[1])
+	assert.Len(t, series[0].samples, 2)
+	assert.Equal(t, [2]string{"pod", "b"}, series[1].labels[1])
+}
+
+func TestRemoteWriteErrors(t *testing.T) {
+	cases := []struct {
+		name    string
+		status  int
+		wantErr bool
+	}{
+		{name: "server_error", status: http.StatusInternalServerError, wantErr: true},
+		{name: "too_many_requests", status: http.StatusTooManyRequests, wantErr: true},
+		{name: "bad_request", status: http.StatusBadRequest, wantErr: false},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			p, requests := startPlugin(t, &Config{MetricName: "requests", ValueField: "count"}, tt.status)
+
+			data := pipeline.WorkerData(nil)
+			err := p.out(&data, test.NewBatch(t, `{"count":1}`))
+			<-requests
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,323 @@
+// Package socket is an output plugin that writes events to a TCP or Unix domain socket.
+package socket
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/ozontech/file.d/cfg"
+	"github.com/ozontech/file.d/fd"
+	"github.com/ozontech/file.d/metric"
+	"github.com/ozontech/file.d/pipeline"
+	"github.com/ozontech/file.d/xtls"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+/*{ introduction
+It writes the events to a TCP or Unix domain socket, it's the low level output for the custom collectors
+which have no specific protocol plugin. Each event is encoded as JSON and framed by `framing`.
+
+The batch is written at once and committed only after the write succeeds. If the write fails,
+the connection is closed and the batch is retried with backoff over the new connection,
+so the events may be delivered more than once.
+
+**Example:**
+```yaml
+pipelines:
+  example_pipeline:
+    ...
+    output:
+      type: socket
+      network: tcp
+      address: collector.local:9000
+      framing: length_prefix
+      tls_enabled: true
+    ...
+```
+}*/
+
+const (
+	outPluginType = "socket"
+
+	networkTCP  = "tcp"
+	networkUnix = "unix"
+)
+
+const (
+	framingNewline = iota
+	framingNull
+	framingLengthPrefix
+)
+
+// lengthPrefixSize is the size of the big endian length which precedes the event of the length_prefix framing
+const lengthPrefixSize = 4
+
+type Plugin struct {
+	config       *Config
+	logger       *zap.SugaredLogger
+	avgEventSize int
+	batcher      *pipeline.Batcher
+	controller   pipeline.OutputPluginController
+	tlsConfig    *tls.Config
+
+	// plugin metrics
+
+	writeErrorsMetric *prometheus.CounterVec
+	connectsMetric    *prometheus.CounterVec
+}
+
+// ! config-params
+// ^ config-params
+type Config struct {
+	// > @3@4@5@6
+	// >
+	// > The network of the socket.
+	Network string `json:"network" default:"tcp" options:"tcp|unix"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The address of the socket: `HOST:PORT` for `tcp`, e.g. `localhost:9000`, or the path for `unix`, e.g. `/var/run/collector.sock`.
+	Address string `json:"address" required:"true"` // *
+
+	// > @3@4@5@6
+	// >
+	// > How the events are separated in the stream:
+	// > * `newline` – each event is followed by `\n`
+	// > * `null` – each event is followed by the null byte
+	// > * `length_prefix` – each event is preceded by its length, the 4 bytes big endian number
+	Framing  string `json:"framing" default:"newline" options:"newline|null|length_prefix"` // *
+	Framing_ int
+
+	// > @3@4@5@6
+	// >
+	// > Use TLS for the `tcp` network.
+	TLSEnabled bool `json:"tls_enabled" default:"false"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The CA certificate to verify the server, it's a PEM content or a path to the file.
+	CACert string `json:"ca_cert"` // *
+
+	// > @3@4@5@6
+	// >
+	// > Skip the verification of the server certificate.
+	InsecureSkipVerify bool `json:"insecure_skip_verify" default:"false"` // *
+
+	// > @3@4@5@6
+	// >
+	// > How much time to wait for the connection.
+	ConnectionTimeout  cfg.Duration `json:"connection_timeout" default:"5s" parse:"duration"` // *
+	ConnectionTimeout_ time.Duration
+
+	// > @3@4@5@6
+	// >
+	// > How much time to wait for the batch to be written.
+	WriteTimeout  cfg.Duration `json:"write_timeout" default:"10s" parse:"duration"` // *
+	WriteTimeout_ time.Duration
+
+	// > @3@4@5@6
+	// >
+	// > How many workers will be instantiated to send batches, each worker has its own connection.
+	WorkersCount  cfg.Expression `json:"workers_count" default:"gomaxprocs*4" parse:"expression"` // *
+	WorkersCount_ int
+
+	// > @3@4@5@6
+	// >
+	// > A maximum quantity of events to pack into one batch.
+	BatchSize  cfg.Expression `json:"batch_size" default:"capacity/4" parse:"expression"` // *
+	BatchSize_ int
+
+	// > @3@4@5@6
+	// >
+	// > A minimum size of events in a batch to send.
+	// > If both batch_size and batch_size_bytes are set, they will work together.
+	BatchSizeBytes  cfg.Expression `json:"batch_size_bytes" default:"0" parse:"expression"` // *
+	BatchSizeBytes_ int
+
+	// > @3@4@5@6
+	// >
+	// > After this timeout the batch will be sent even if batch isn't completed.
+	BatchFlushTimeout  cfg.Duration `json:"batch_flush_timeout" default:"200ms" parse:"duration"` // *
+	BatchFlushTimeout_ time.Duration
+
+	// > @3@4@5@6
+	// >
+	// > Retries of the batch sending, each retry reconnects.
+	Retry int `json:"retry" default:"10"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The delay before the first retry, it's doubled after each retry.
+	Retention  cfg.Duration `json:"retention" default:"1s" parse:"duration"` // *
+	Retention_ time.Duration
+
+	// > @3@4@5@6
+	// >
+	// > The max delay between the retries.
+	MaxRetention  cfg.Duration `json:"max_retention" default:"1m" parse:"duration"` // *
+	MaxRetention_ time.Duration
+
+	// > @3@4@5@6
+	// >
+	// > Exit with the non-zero code if the batch isn't sent after all the retries, otherwise the batch is dropped.
+	FatalOnFailedInsert bool `json:"fatal_on_failed_insert" default:"false"` // *
+}
+
+type data struct {
+	outBuf []byte
+	conn   net.Conn
+}
+
+func init() {
+	fd.DefaultPluginRegistry.RegisterOutput(&pipeline.PluginStaticInfo{
+		Type:    outPluginType,
+		Factory: Factory,
+	})
+}
+
+func Factory() (pipeline.AnyPlugin, pipeline.AnyConfig) {
+	return &Plugin{}, &Config{}
+}
+
+func (p *Plugin) Start(config pipeline.AnyConfig, params *pipeline.OutputPluginParams) {
+	p.controller = params.Controller
+	p.logger = params.Logger
+	p.avgEventSize = params.PipelineSettings.AvgEventSize
+	p.config = config.(*Config)
+	p.registerMetrics(params.MetricCtl)
+
+	if p.config.TLSEnabled {
+		if p.config.Network != networkTCP {
+			p.logger.Fatal("tls is supported only by tcp network")
+		}
+
+		p.tlsConfig = &tls.Config{InsecureSkipVerify: p.config.InsecureSkipVerify}
+		if p.config.CACert != "" {
+			b := xtls.NewConfigBuilder()
+			if err := b.AppendCARoot(p.config.CACert); err != nil {
+				p.logger.Fatalf("can't append CA root: %s", err.Error())
+			}
+			p.tlsConfig = b.Build()
+			p.tlsConfig.InsecureSkipVerify = p.config.InsecureSkipVerify
+		}
+	}
+
+	p.batcher = pipeline.NewBatcher(pipeline.BatcherOptions{
+		PipelineName:   params.PipelineName,
+		OutputType:     outPluginType,
+		OutFn:          p.out,
+		Controller:     p.controller,
+		Workers:        p.config.WorkersCount_,
+		BatchSizeCount: p.config.BatchSize_,
+		BatchSizeBytes: p.config.BatchSizeBytes_,
+		FlushTimeout:   p.config.BatchFlushTimeout_,
+		MaxEventAge:    params.PipelineSettings.MaxEventAge,
+		CircuitBreaker: params.PipelineSettings.CircuitBreaker,
+		Health:         params.Health,
+		ErrorReporter:  params.ErrorReporter,
+		DeadLetter:     params.DeadLetter,
+		MetricCtl:      params.MetricCtl,
+		Retry: pipeline.BatcherRetry{
+			MaxRetries:        p.config.Retry,
+			InitialBackoff:    p.config.Retention_,
+			BackoffMultiplier: 2,
+			MaxBackoff:        p.config.MaxRetention_,
+		},
+		FatalOnFailedInsert: p.config.FatalOnFailedInsert,
+	})
+
+	p.batcher.Start(context.TODO())
+}
+
+func (p *Plugin) registerMetrics(ctl *metric.Ctl) {
+	p.writeErrorsMetric = ctl.RegisterCounter("output_socket_write_errors_total", "Number of failed connections and writes to the socket")
+	p.connectsMetric = ctl.RegisterCounter("output_socket_connects_total", "Number of connections to the socket")
+}
+
+func (p *Plugin) Stop() {
+	p.batcher.Stop()
+}
+
+func (p *Plugin) Out(event *pipeline.Event) {
+	p.batcher.Add(event)
+}
+
+func (p *Plugin) out(workerData *pipeline.WorkerData, batch *pipeline.Batch) error {
+	if *workerData == nil {
+		*workerData = &data{
+			outBuf: make([]byte, 0, p.config.BatchSize_*p.avgEventSize),
+		}
+	}
+
+	data := (*workerData).(*data)
+	// handle too much memory consumption
+	if cap(data.outBuf) > p.config.BatchSize_*p.avgEventSize {
+		data.outBuf = make([]byte, 0, p.config.BatchSize_*p.avgEventSize)
+	}
+
+	data.outBuf = p.encodeBatch(data.outBuf[:0], batch)
+
+	if data.conn == nil {
+		conn, err := p.connect()
+		if err != nil {
+			p.writeErrorsMetric.WithLabelValues().Inc()
+			p.logger.Errorf("can't connect to socket address=%s: %s", p.config.Address, err.Error())
+			return err
+		}
+		p.connectsMetric.WithLabelValues().Inc()
+		data.conn = conn
+	}
+
+	if err := p.write(data.conn, data.outBuf); err != nil {
+		p.writeErrorsMetric.WithLabelValues().Inc()
+		p.logger.Errorf("can't write to socket address=%s: %s", p.config.Address, err.Error())
+		// the connection is broken or its state is unknown after the partial write, so it's reconnected on the retry
+		_ = data.conn.Close()
+		data.conn = nil
+		return err
+	}
+
+	return nil
+}
+
+func (p *Plugin) encodeBatch(out []byte, batch *pipeline.Batch) []byte {
+	batch.ForEach(func(event *pipeline.Event) bool {
+		if p.config.Framing_ == framingLengthPrefix {
+			l := len(out)
+			out = append(out, make([]byte, lengthPrefixSize)...)
+			out = event.Root.Encode(out)
+			binary.BigEndian.PutUint32(out[l:], uint32(len(out)-l-lengthPrefixSize))
+			return true
+		}
+
+		out = event.Root.Encode(out)
+		if p.config.Framing_ == framingNull {
+			out = append(out, 0)
+		} else {
+			out = append(out, '\n')
+		}
+		return true
+	})
+	return out
+}
+
+func (p *Plugin) connect() (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: p.config.ConnectionTimeout_}
+	if p.tlsConfig != nil {
+		return tls.DialWithDialer(dialer, p.config.Network, p.config.Address, p.tlsConfig)
+	}
+	return dialer.Dial(p.config.Network, p.config.Address)
+}
+
+func (p *Plugin) write(conn net.Conn, b []byte) error {
+	if err := conn.SetWriteDeadline(time.Now().Add(p.config.WriteTimeout_)); err != nil {
+		return fmt.Errorf("can't set write deadline: %w", err)
+	}
+	_, err := conn.Write(b)
+	return err
+}
@@ -0,0 +1,97 @@
+package socket
+
+import (
+	"io"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/ozontech/file.d/pipeline"
+	"github.com/ozontech/file.d/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// listen accepts the connections and sends their data to the channel when they are closed
+func listen(t *testing.T, network, address string) (net.Listener, chan string) {
+	ln, err := net.Listen(network, address)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = ln.Close() })
+
+	received := make(chan string, 10)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				b, _ := io.ReadAll(conn)
+				received <- string(b)
+			}()
+		}
+	}()
+	return ln, received
+}
+
+func TestSocketFraming(t *testing.T) {
+	cases := []struct {
+		name    string
+		network string
+		framing string
+		want    string
+	}{
+		{
+			name:    "tcp_newline",
+			network: networkTCP,
+			framing: "newline",
+			want:    "{\"a\":1}\n{\"b\":\"c\"}\n",
+		},
+		{
+			name:    "unix_null",
+			network: networkUnix,
+			framing: "null",
+			want:    "{\"a\":1}\x00{\"b\":\"c\"}\x00",
+		},
+		{
+			name:    "tcp_length_prefix",
+			network: networkTCP,
+			framing: "length_prefix",
+			want:    "\x00\x00\x00\x07{\"a\":1}\x00\x00\x00\x09{\"b\":\"c\"}",
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			address := "127.0.0.1:0"
+			if tt.network == networkUnix {
+				address = filepath.Join(t.TempDir(), "socket.sock")
+			}
+			ln, received := listen(t, tt.network, address)
+
+			p := &Plugin{}
+			test.StartOutputPlugin(t, p, &Config{Network: tt.network, Address: ln.Addr().String(), Framing: tt.framing}, nil)
+
+			workerData := pipeline.WorkerData(nil)
+			require.NoError(t, p.out(&workerData, test.NewBatch(t, `{"a":1}`, `{"b":"c"}`)))
+			_ = workerData.(*data).conn.Close()
+
+			assert.Equal(t, tt.want, <-received)
+		})
+	}
+}
+
+func TestSocketReconnect(t *testing.T) {
+	address := filepath.Join(t.TempDir(), "socket.sock")
+	p := &Plugin{}
+	test.StartOutputPlugin(t, p, &Config{Network: networkUnix, Address: address}, nil)
+
+	workerData := pipeline.WorkerData(nil)
+	require.Error(t, p.out(&workerData, test.NewBatch(t, `{"a":1}`)))
+
+	_, received := listen(t, networkUnix, address)
+	require.NoError(t, p.out(&workerData, test.NewBatch(t, `{"a":1}`)))
+	_ = workerData.(*data).conn.Close()
+
+	assert.Equal(t, "{\"a\":1}\n", <-received)
+}
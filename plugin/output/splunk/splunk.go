@@ -5,11 +5,16 @@ import (
 	"bytes"
 	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/ozontech/file.d/cfg"
 	"github.com/ozontech/file.d/fd"
 	"github.com/ozontech/file.d/metric"
@@ -21,12 +26,43 @@ import (
 
 /*{ introduction
 It sends events to splunk.
+
+The `index`, `source`, `sourcetype` and `host` of the events can be set by the `${field}` templates,
+the fields of `indexed_fields` are sent as the indexed fields.
+
+If `use_ack` is set, the indexer acknowledgement of HEC is used: the batch is committed
+only after splunk confirms it's indexed, the batch which isn't confirmed in `ack_timeout` is sent again.
+The acknowledgement must be enabled for the token.
+
+The batch is retried if the request fails, splunk responds with `408`, `429` or `5xx` status
+or the acknowledgement times out. Other statuses are logged and the batch is dropped.
+
+**Example:**
+```yaml
+pipelines:
+  example_pipeline:
+    ...
+    output:
+      type: splunk
+      endpoint: https://splunk.example.com:8088/services/collector
+      token: secret
+      index: logs_${service}
+      sourcetype: _json
+      indexed_fields: [service, k8s_namespace]
+      use_ack: true
+      compression: gzip
+    ...
+```
 }*/
 
 const (
 	outPluginType = "splunk"
+
+	collectorPath = "/services/collector"
 )
 
+var errNoAckID = errors.New("response has no ackId, check the indexer acknowledgement is enabled for the token")
+
 type Plugin struct {
 	config       *Config
 	client       http.Client
@@ -35,9 +71,27 @@ type Plugin struct {
 	batcher      *pipeline.Batcher
 	controller   pipeline.OutputPluginController
 
+	meta          []metaField
+	indexedFields []indexedField
+	ackEndpoint   string
+	channel       string
+
 	// plugin metrics
 
-	sendErrorMetric *prometheus.CounterVec
+	sendErrorMetric     *prometheus.CounterVec
+	droppedEventsMetric *prometheus.CounterVec
+	ackTimeoutsMetric   *prometheus.CounterVec
+}
+
+// metaField is the metadata key of the event which is rendered by the template
+type metaField struct {
+	key      string
+	template []cfg.SubstitutionOp
+}
+
+type indexedField struct {
+	name string
+	path []string
 }
 
 // ! config-params
@@ -53,6 +107,59 @@ type Config struct {
 	// > Token for an authentication for a HEC endpoint.
 	Token string `json:"token" required:"true"` // *
 
+	// > @3@4@5@6
+	// >
+	// > The index of the events, e.g. `logs_${service}`. If it's empty, the default index of the token is used.
+	Index string `json:"index"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The source of the events, the template like `index`.
+	Source string `json:"source"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The sourcetype of the events, the template like `index`.
+	SourceType string `json:"sourcetype"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The host of the events, the template like `index`.
+	Host string `json:"host"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The fields of the event which are sent as the indexed fields, the name of the indexed field is the field path,
+	// > e.g. `k8s.pod`. The missing fields and the objects are skipped, the arrays are sent as the multivalue fields.
+	IndexedFields []string `json:"indexed_fields" slice:"true"` // *
+
+	// > @3@4@5@6
+	// >
+	// > Wait for the indexer acknowledgement of each batch before it's committed.
+	UseAck bool `json:"use_ack" default:"false"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The GUID of the HEC channel which is required for the acknowledgement. If it's empty, the random one is used.
+	Channel string `json:"channel"` // *
+
+	// > @3@4@5@6
+	// >
+	// > How long to wait for the acknowledgement before the batch is sent again.
+	AckTimeout  cfg.Duration `json:"ack_timeout" default:"1m" parse:"duration"` // *
+	AckTimeout_ time.Duration
+
+	// > @3@4@5@6
+	// >
+	// > How often to ask splunk whether the batch is indexed.
+	AckPollInterval  cfg.Duration `json:"ack_poll_interval" default:"1s" parse:"duration"` // *
+	AckPollInterval_ time.Duration
+
+	// > @3@4@5@6
+	// >
+	// > The compression of the requests.
+	Compression string `json:"compression" default:"none" options:"none|gzip"` // *
+
 	// > @3@4@5@6
 	// >
 	// > How many workers will be instantiated to send batches.
@@ -83,6 +190,28 @@ type Config struct {
 	// > After this timeout the batch will be sent even if batch isn't completed.
 	BatchFlushTimeout  cfg.Duration `json:"batch_flush_timeout" default:"200ms" parse:"duration"` // *
 	BatchFlushTimeout_ time.Duration
+
+	// > @3@4@5@6
+	// >
+	// > Retries of the batch sending.
+	Retry int `json:"retry" default:"10"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The delay before the first retry, it's doubled after each retry.
+	Retention  cfg.Duration `json:"retention" default:"1s" parse:"duration"` // *
+	Retention_ time.Duration
+
+	// > @3@4@5@6
+	// >
+	// > The max delay between the retries.
+	MaxRetention  cfg.Duration `json:"max_retention" default:"1m" parse:"duration"` // *
+	MaxRetention_ time.Duration
+
+	// > @3@4@5@6
+	// >
+	// > Exit with the non-zero code if the batch isn't sent after all the retries, otherwise the batch is dropped.
+	FatalOnFailedInsert bool `json:"fatal_on_failed_insert" default:"false"` // *
 }
 
 type data struct {
@@ -108,6 +237,10 @@ func (p *Plugin) Start(config pipeline.AnyConfig, params *pipeline.OutputPluginP
 	p.registerMetrics(params.MetricCtl)
 	p.client = p.newClient(p.config.RequestTimeout_)
 
+	if err := p.prepare(); err != nil {
+		p.logger.Fatal(err.Error())
+	}
+
 	p.batcher = pipeline.NewBatcher(pipeline.BatcherOptions{
 		PipelineName:   params.PipelineName,
 		OutputType:     outPluginType,
@@ -118,14 +251,84 @@ func (p *Plugin) Start(config pipeline.AnyConfig, params *pipeline.OutputPluginP
 		BatchSizeCount: p.config.BatchSize_,
 		BatchSizeBytes: p.config.BatchSizeBytes_,
 		FlushTimeout:   p.config.BatchFlushTimeout_,
+		MaxEventAge:    params.PipelineSettings.MaxEventAge,
+		CircuitBreaker: params.PipelineSettings.CircuitBreaker,
+		Health:         params.Health,
+		ErrorReporter:  params.ErrorReporter,
+		DeadLetter:     params.DeadLetter,
 		MetricCtl:      params.MetricCtl,
+		Compression:    pipeline.BatchCompression(p.config.Compression),
+		Retry: pipeline.BatcherRetry{
+			MaxRetries:        p.config.Retry,
+			InitialBackoff:    p.config.Retention_,
+			BackoffMultiplier: 2,
+			MaxBackoff:        p.config.MaxRetention_,
+		},
+		FatalOnFailedInsert: p.config.FatalOnFailedInsert,
 	})
 
 	p.batcher.Start(context.TODO())
 }
 
+// prepare parses the templates and the acknowledgement settings
+func (p *Plugin) prepare() error {
+	meta := []struct {
+		key      string
+		template string
+	}{
+		{key: "index", template: p.config.Index},
+		{key: "source", template: p.config.Source},
+		{key: "sourcetype", template: p.config.SourceType},
+		{key: "host", template: p.config.Host},
+	}
+	for _, m := range meta {
+		if m.template == "" {
+			continue
+		}
+		template, err := cfg.ParseSubstitution(m.template)
+		if err != nil {
+			return fmt.Errorf("wrong %s template %q: %w", m.key, m.template, err)
+		}
+		p.meta = append(p.meta, metaField{key: m.key, template: template})
+	}
+
+	for _, field := range p.config.IndexedFields {
+		p.indexedFields = append(p.indexedFields, indexedField{name: field, path: cfg.ParseFieldSelector(field)})
+	}
+
+	if !p.config.UseAck {
+		return nil
+	}
+	ackEndpoint, err := newAckEndpoint(p.config.Endpoint)
+	if err != nil {
+		return fmt.Errorf("wrong endpoint %q: %w", p.config.Endpoint, err)
+	}
+	p.ackEndpoint = ackEndpoint
+	p.channel = p.config.Channel
+	if p.channel == "" {
+		p.channel = uuid.NewString()
+	}
+
+	return nil
+}
+
+// newAckEndpoint returns the acknowledgement endpoint of the collector endpoint
+func newAckEndpoint(endpoint string) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", err
+	}
+	if i := strings.Index(u.Path, collectorPath); i >= 0 {
+		u.Path = u.Path[:i+len(collectorPath)]
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/ack"
+	return u.String(), nil
+}
+
 func (p *Plugin) registerMetrics(ctl *metric.Ctl) {
 	p.sendErrorMetric = ctl.RegisterCounter("output_splunk_send_error", "Total splunk send errors")
+	p.droppedEventsMetric = ctl.RegisterCounter("output_splunk_dropped_events_total", "Number of events rejected by splunk")
+	p.ackTimeoutsMetric = ctl.RegisterCounter("output_splunk_ack_timeouts_total", "Number of batches which aren't acknowledged in time")
 }
 
 func (p *Plugin) Stop() {
@@ -136,7 +339,7 @@ func (p *Plugin) Out(event *pipeline.Event) {
 	p.batcher.Add(event)
 }
 
-func (p *Plugin) out(workerData *pipeline.WorkerData, batch *pipeline.Batch) {
+func (p *Plugin) out(workerData *pipeline.WorkerData, batch *pipeline.Batch) error {
 	if *workerData == nil {
 		*workerData = &data{
 			outBuf: make([]byte, 0, p.config.BatchSize_*p.avgEventSize),
@@ -149,32 +352,108 @@ func (p *Plugin) out(workerData *pipeline.WorkerData, batch *pipeline.Batch) {
 		data.outBuf = make([]byte, 0, p.config.BatchSize_*p.avgEventSize)
 	}
 
-	root := insaneJSON.Spawn()
 	outBuf := data.outBuf[:0]
-
-	for _, event := range batch.Events {
-		root.AddField("event").MutateToNode(event.Root.Node)
-		outBuf = root.Encode(outBuf)
-		_ = root.DecodeString("{}")
-	}
-	insaneJSON.Release(root)
+	batch.ForEach(func(event *pipeline.Event) bool {
+		outBuf = p.appendEvent(outBuf, event.Root)
+		return true
+	})
 	data.outBuf = outBuf
 
 	p.logger.Debugf("trying to send: %s", outBuf)
 
-	for {
-		err := p.send(outBuf)
-		if err != nil {
-			p.sendErrorMetric.WithLabelValues().Inc()
-			p.logger.Errorf("can't send data to splunk address=%s: %s", p.config.Endpoint, err.Error())
-			time.Sleep(time.Second)
+	ackID, retry, err := p.send(outBuf, batch)
+	if err == nil && p.config.UseAck {
+		err = p.waitAck(ackID)
+		retry = true
+	}
+	if err == nil {
+		p.logger.Debugf("successfully sent: %s", outBuf)
+		return nil
+	}
+	if !retry {
+		p.droppedEventsMetric.WithLabelValues().Add(float64(batch.Len()))
+		reason := fmt.Sprintf("batch is rejected by splunk address=%s: %s", p.config.Endpoint, err.Error())
+		batch.ForEach(func(event *pipeline.Event) bool {
+			batch.MarkRejected(event, reason)
+			return true
+		})
+		return nil
+	}
+	p.sendErrorMetric.WithLabelValues().Inc()
+	p.logger.Errorf("can't send data to splunk address=%s: %s", p.config.Endpoint, err.Error())
+
+	return err
+}
+
+// appendEvent appends the HEC event with the metadata and the indexed fields
+func (p *Plugin) appendEvent(out []byte, root *insaneJSON.Root) []byte {
+	out = append(out, `{"event":`...)
+	out = root.Encode(out)
+
+	for i := range p.meta {
+		m := &p.meta[i]
+		l := len(out)
+		out = append(out, ',', '"')
+		out = append(out, m.key...)
+		out = append(out, '"', ':', '"')
+		valueStart := len(out)
+		out, _ = cfg.AppendSubstitution(out, m.template, root, cfg.SubstitutionEscapeAll)
+		if len(out) == valueStart {
+			// the empty value isn't sent, so splunk uses the default one
+			out = out[:l]
+			continue
+		}
+		out = append(out, '"')
+	}
+
+	if len(p.indexedFields) > 0 {
+		out = p.appendIndexedFields(out, root)
+	}
 
+	return append(out, '}')
+}
+
+func (p *Plugin) appendIndexedFields(out []byte, root *insaneJSON.Root) []byte {
+	l := len(out)
+	out = append(out, `,"fields":{`...)
+	empty := true
+	for i := range p.indexedFields {
+		field := &p.indexedFields[i]
+		node := root.Dig(field.path...)
+		if node == nil || node.IsNull() || node.IsObject() {
 			continue
 		}
 
-		break
+		if !empty {
+			out = append(out, ',')
+		}
+		empty = false
+
+		out = append(out, '"')
+		out = cfg.AppendEscapedJSON(out, field.name)
+		out = append(out, '"', ':')
+		if !node.IsArray() {
+			out = append(out, '"')
+			out = appendValue(out, node)
+			out = append(out, '"')
+			continue
+		}
+
+		out = append(out, '[')
+		for j, item := range node.AsArray() {
+			if j > 0 {
+				out = append(out, ',')
+			}
+			out = append(out, '"')
+			out = appendValue(out, item)
+			out = append(out, '"')
+		}
+		out = append(out, ']')
 	}
-	p.logger.Debugf("successfully sent: %s", outBuf)
+	if empty {
+		return out[:l]
+	}
+	return append(out, '}')
 }
 
 func (p *Plugin) maintenance(_ *pipeline.WorkerData) {}
@@ -191,46 +470,126 @@ func (p *Plugin) newClient(timeout time.Duration) http.Client {
 	}
 }
 
-func (p *Plugin) send(data []byte) error {
-	r := bytes.NewReader(data)
+// send compresses the batch and sends it, it returns the ackId if the acknowledgement is used
+// and whether the request should be retried if it fails
+func (p *Plugin) send(data []byte, batch *pipeline.Batch) (int64, bool, error) {
+	data, err := batch.Compress(data)
+	if err != nil {
+		return 0, false, fmt.Errorf("can't compress data: %w", err)
+	}
+
 	// todo pass context from parent.
-	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, p.config.Endpoint, r)
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, p.config.Endpoint, bytes.NewReader(data))
+	if err != nil {
+		return 0, false, fmt.Errorf("can't create request: %w", err)
+	}
+	if compression := batch.Compression(); compression != pipeline.BatchCompressionNone {
+		req.Header.Set("Content-Encoding", string(compression))
+	}
+
+	root, status, err := p.do(req)
+	defer insaneJSON.Release(root)
+	if err != nil {
+		retry := status == 0 || status == http.StatusRequestTimeout || status == http.StatusTooManyRequests ||
+			status >= http.StatusInternalServerError
+		return 0, retry, err
+	}
+
+	code := root.Dig("code")
+	if code == nil {
+		return 0, false, fmt.Errorf("invalid response format, expecting json with 'code' field, got: %s", root.EncodeToString())
+	}
+
+	if code.AsInt() > 0 {
+		return 0, false, fmt.Errorf("error while sending to splunk: %s", root.EncodeToString())
+	}
+
+	if !p.config.UseAck {
+		return 0, false, nil
+	}
+	ackID := root.Dig("ackId")
+	if ackID == nil {
+		return 0, true, errNoAckID
+	}
+
+	return int64(ackID.AsInt()), false, nil
+}
+
+// waitAck polls splunk until the batch is indexed or the ack_timeout passes
+func (p *Plugin) waitAck(ackID int64) error {
+	body := []byte(`{"acks":[` + strconv.FormatInt(ackID, 10) + `]}`)
+	deadline := time.Now().Add(p.config.AckTimeout_)
+	for {
+		acked, err := p.queryAck(body, ackID)
+		if err == nil && acked {
+			return nil
+		}
+
+		if time.Now().Add(p.config.AckPollInterval_).After(deadline) {
+			p.ackTimeoutsMetric.WithLabelValues().Inc()
+			if err != nil {
+				return fmt.Errorf("ackId=%d isn't acknowledged in %s: %w", ackID, p.config.AckTimeout_, err)
+			}
+			return fmt.Errorf("ackId=%d isn't acknowledged in %s", ackID, p.config.AckTimeout_)
+		}
+		time.Sleep(p.config.AckPollInterval_)
+	}
+}
+
+func (p *Plugin) queryAck(body []byte, ackID int64) (bool, error) {
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, p.ackEndpoint, bytes.NewReader(body))
 	if err != nil {
-		return fmt.Errorf("can't create request: %w", err)
+		return false, fmt.Errorf("can't create request: %w", err)
 	}
 
+	root, _, err := p.do(req)
+	defer insaneJSON.Release(root)
+	if err != nil {
+		return false, err
+	}
+
+	acked := root.Dig("acks", strconv.FormatInt(ackID, 10))
+	return acked != nil && acked.AsBool(), nil
+}
+
+// do sends the request to HEC and decodes the response, the status is zero if the response isn't received
+func (p *Plugin) do(req *http.Request) (*insaneJSON.Root, int, error) {
 	req.Header.Set("Authorization", "Splunk "+p.config.Token)
+	if p.channel != "" {
+		req.Header.Set("X-Splunk-Request-Channel", p.channel)
+	}
+
 	resp, err := p.client.Do(req)
 	if err != nil {
-		return fmt.Errorf("can't send request: %w", err)
+		return nil, 0, fmt.Errorf("can't send request: %w", err)
 	}
 	defer func(Body io.ReadCloser) {
 		_ = Body.Close()
 	}(resp.Body)
 
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("can't send request: %s", resp.Status)
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, resp.StatusCode, fmt.Errorf("can't send request: %s: %s", resp.Status, bytes.TrimSpace(b))
 	}
 
 	b, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return fmt.Errorf("can't read response: %w", err)
+		return nil, 0, fmt.Errorf("can't read response: %w", err)
 	}
 
 	root, err := insaneJSON.DecodeBytes(b)
-	defer insaneJSON.Release(root)
 	if err != nil {
-		return fmt.Errorf("can't decode response: %w", err)
+		insaneJSON.Release(root)
+		return nil, resp.StatusCode, fmt.Errorf("can't decode response: %w", err)
 	}
 
-	code := root.Dig("code")
-	if code == nil {
-		return fmt.Errorf("invalid response format, expecting json with 'code' field, got: %s", string(b))
-	}
+	return root, resp.StatusCode, nil
+}
 
-	if code.AsInt() > 0 {
-		return fmt.Errorf("error while sending to splunk: %s", string(b))
+// appendValue appends the value escaped for the JSON string, the objects and the arrays are appended as JSON
+func appendValue(out []byte, node *insaneJSON.Node) []byte {
+	if node.IsObject() || node.IsArray() {
+		return cfg.AppendEscapedJSON(out, node.EncodeToString())
 	}
-
-	return nil
+	return cfg.AppendEscapedJSON(out, node.AsString())
 }
@@ -4,10 +4,13 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"sync/atomic"
 	"testing"
 
 	"github.com/ozontech/file.d/pipeline"
+	"github.com/ozontech/file.d/test"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	insaneJSON "github.com/vitkovskii/insane-json"
 	"go.uber.org/zap"
 )
@@ -68,3 +71,118 @@ func TestSplunk(t *testing.T) {
 		})
 	}
 }
+
+func startPlugin(t *testing.T, config *Config, handler http.HandlerFunc) *Plugin {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	config.Endpoint = server.URL + "/services/collector/event"
+	config.Token = "token"
+	p := &Plugin{}
+	test.StartOutputPlugin(t, p, config, nil)
+	return p
+}
+
+func TestSplunkMetaAndIndexedFields(t *testing.T) {
+	var body []byte
+	p := startPlugin(t, &Config{
+		Index:         "logs_${service}",
+		SourceType:    "_json",
+		Host:          "${host}",
+		IndexedFields: []string{"service", "k8s.tags", "k8s", "missing"},
+	}, func(w http.ResponseWriter, r *http.Request) {
+		body, _ = io.ReadAll(r.Body)
+		_, _ = w.Write([]byte(`{"code":0}`))
+	})
+
+	data := pipeline.WorkerData(nil)
+	err := p.out(&data, test.NewBatch(t, `{"service":"api","k8s":{"tags":["a",1]}}`))
+	require.NoError(t, err)
+
+	assert.Equal(t, `{"event":{"service":"api","k8s":{"tags":["a",1]}},"index":"logs_api","sourcetype":"_json",`+
+		`"fields":{"service":"api","k8s.tags":["a","1"]}}`, string(body))
+}
+
+func TestSplunkAck(t *testing.T) {
+	var polls atomic.Int32
+	var channel string
+	p := startPlugin(t, &Config{
+		UseAck:          true,
+		AckPollInterval: "10ms",
+		AckTimeout:      "1s",
+	}, func(w http.ResponseWriter, r *http.Request) {
+		channel = r.Header.Get("X-Splunk-Request-Channel")
+		switch r.URL.Path {
+		case "/services/collector/event":
+			_, _ = w.Write([]byte(`{"text":"Success","code":0,"ackId":7}`))
+		case "/services/collector/ack":
+			body, _ := io.ReadAll(r.Body)
+			assert.Equal(t, `{"acks":[7]}`, string(body))
+			if polls.Add(1) < 3 {
+				_, _ = w.Write([]byte(`{"acks":{"7":false}}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"acks":{"7":true}}`))
+		}
+	})
+
+	data := pipeline.WorkerData(nil)
+	require.NoError(t, p.out(&data, test.NewBatch(t, `{"message":"acked"}`)))
+	assert.Equal(t, int32(3), polls.Load())
+	assert.NotEmpty(t, channel)
+}
+
+func TestSplunkAckTimeout(t *testing.T) {
+	p := startPlugin(t, &Config{
+		UseAck:          true,
+		Channel:         "0aa1a0ea-1d1f-4d5c-9a42-c3b1bf1e9f3e",
+		AckPollInterval: "10ms",
+		AckTimeout:      "50ms",
+	}, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "0aa1a0ea-1d1f-4d5c-9a42-c3b1bf1e9f3e", r.Header.Get("X-Splunk-Request-Channel"))
+		if r.URL.Path == "/services/collector/ack" {
+			_, _ = w.Write([]byte(`{"acks":{"0":false}}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"text":"Success","code":0,"ackId":0}`))
+	})
+
+	data := pipeline.WorkerData(nil)
+	assert.Error(t, p.out(&data, test.NewBatch(t, `{"message":"lost"}`)))
+}
+
+func TestSplunkRetry(t *testing.T) {
+	cases := []struct {
+		status    int
+		wantRetry bool
+	}{
+		{status: http.StatusServiceUnavailable, wantRetry: true},
+		{status: http.StatusTooManyRequests, wantRetry: true},
+		{status: http.StatusBadRequest, wantRetry: false},
+	}
+
+	for _, tt := range cases {
+		t.Run(http.StatusText(tt.status), func(t *testing.T) {
+			p := startPlugin(t, &Config{}, func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+			})
+
+			data := pipeline.WorkerData(nil)
+			err := p.out(&data, test.NewBatch(t, `{"message":"retry"}`))
+			assert.Equal(t, tt.wantRetry, err != nil)
+		})
+	}
+}
+
+func TestNewAckEndpoint(t *testing.T) {
+	cases := map[string]string{
+		"http://splunk:8088/services/collector":       "http://splunk:8088/services/collector/ack",
+		"http://splunk:8088/services/collector/event": "http://splunk:8088/services/collector/ack",
+		"https://proxy/splunk/":                       "https://proxy/splunk/ack",
+	}
+	for endpoint, want := range cases {
+		got, err := newAckEndpoint(endpoint)
+		require.NoError(t, err)
+		assert.Equal(t, want, got, endpoint)
+	}
+}
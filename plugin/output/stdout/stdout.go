@@ -1,8 +1,10 @@
 package stdout
 
 import (
-	"fmt"
+	"os"
+	"sync"
 
+	"github.com/ozontech/file.d/encoder"
 	"github.com/ozontech/file.d/fd"
 	"github.com/ozontech/file.d/pipeline"
 )
@@ -13,9 +15,34 @@ It writes events to stdout(also known as console).
 
 type Plugin struct {
 	controller pipeline.OutputPluginController
+	encoder    encoder.Encoder
+
+	mu  sync.Mutex
+	buf []byte
 }
 
-type Config struct{}
+// ! config-params
+// ^ config-params
+type Config struct {
+	// > @3@4@5@6
+	// >
+	// > The format of the events:
+	// > * `json` – one compact JSON per line
+	// > * `pretty_json` – indented JSON, the event takes several lines
+	// > * `logfmt` – `key=value` pairs, the nested fields are flattened by the dotted keys
+	// > * `csv` – the values of `csv_columns`, the missing fields are empty
+	Encoder string `json:"encoder" default:"json" options:"json|pretty_json|logfmt|csv"` // *
+
+	// > @3@4@5@6
+	// >
+	// > The fields written as the CSV columns in the order of the list. It's required for the `csv` encoder.
+	CSVColumns []string `json:"csv_columns" slice:"true"` // *
+
+	// > @3@4@5@6
+	// >
+	// > If set, the names of `csv_columns` are written as the first line.
+	CSVHeader bool `json:"csv_header" default:"true"` // *
+}
 
 func init() {
 	fd.DefaultPluginRegistry.RegisterOutput(&pipeline.PluginStaticInfo{
@@ -28,14 +55,39 @@ func Factory() (pipeline.AnyPlugin, pipeline.AnyConfig) {
 	return &Plugin{}, &Config{}
 }
 
-func (p *Plugin) Start(_ pipeline.AnyConfig, params *pipeline.OutputPluginParams) {
+func (p *Plugin) Start(config pipeline.AnyConfig, params *pipeline.OutputPluginParams) {
 	p.controller = params.Controller
+
+	c := config.(*Config)
+	enc, err := encoder.New(encoder.Config{
+		Type:       c.Encoder,
+		CSVColumns: c.CSVColumns,
+		CSVHeader:  c.CSVHeader,
+	})
+	if err != nil {
+		params.Logger.Fatalf("wrong encoder config: %s", err.Error())
+	}
+	p.encoder = enc
+
+	if header := enc.Header(); len(header) > 0 {
+		p.buf = append(append(p.buf, header...), '\n')
+		p.write(p.buf)
+	}
 }
 
 func (_ *Plugin) Stop() {}
 
 func (p *Plugin) Out(event *pipeline.Event) {
-	// nolint: forbidigo
-	fmt.Println(event.Root.EncodeToString())
+	if !event.IsChildParent() {
+		p.mu.Lock()
+		p.buf = p.encoder.Encode(p.buf[:0], event.Root)
+		p.buf = append(p.buf, '\n')
+		p.write(p.buf)
+		p.mu.Unlock()
+	}
 	p.controller.Commit(event)
 }
+
+func (p *Plugin) write(data []byte) {
+	_, _ = os.Stdout.Write(data)
+}
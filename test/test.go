@@ -4,6 +4,8 @@ import (
 	"math/rand"
 	"strconv"
 	"strings"
+	"sync"
+	"testing"
 	"time"
 
 	"github.com/ozontech/file.d/cfg"
@@ -13,6 +15,8 @@ import (
 	"github.com/ozontech/file.d/plugin/input/fake"
 	"github.com/ozontech/file.d/plugin/output/devnull"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+	insaneJSON "github.com/vitkovskii/insane-json"
 	"go.uber.org/atomic"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
@@ -199,6 +203,14 @@ func NewActionPluginStaticInfo(factory pipeline.PluginFactory, config pipeline.A
 	}
 }
 
+func NewEmptyInputPluginParams(controller pipeline.InputPluginController) *pipeline.InputPluginParams {
+	return &pipeline.InputPluginParams{
+		PluginDefaultParams: newDefaultParams(),
+		Controller:          controller,
+		Logger:              newLogger().Named("input"),
+	}
+}
+
 func NewEmptyOutputPluginParams() *pipeline.OutputPluginParams {
 	return &pipeline.OutputPluginParams{
 		PluginDefaultParams: newDefaultParams(),
@@ -206,6 +218,68 @@ func NewEmptyOutputPluginParams() *pipeline.OutputPluginParams {
 	}
 }
 
+// StartOutputPlugin parses the config, starts the plugin with the params and stops it on the test cleanup,
+// the empty params are used if they're nil
+func StartOutputPlugin(t *testing.T, plugin pipeline.OutputPlugin, config pipeline.AnyConfig, params *pipeline.OutputPluginParams) {
+	NewConfig(config, map[string]int{"gomaxprocs": 1, "capacity": 64})
+	if params == nil {
+		params = NewEmptyOutputPluginParams()
+	}
+
+	plugin.Start(config, params)
+	t.Cleanup(plugin.Stop)
+}
+
+// NewBatch returns the batch of the events decoded from the JSON strings to pass it to the OutFn of the output,
+// the roots are released on the test cleanup
+func NewBatch(t *testing.T, events ...string) *pipeline.Batch {
+	batch := &pipeline.Batch{}
+	for _, e := range events {
+		root, err := insaneJSON.DecodeString(e)
+		require.NoError(t, err)
+		t.Cleanup(func() { insaneJSON.Release(root) })
+		batch.Events = append(batch.Events, &pipeline.Event{Root: root})
+	}
+	return batch
+}
+
+// NewCompressedBatch returns the batch of NewBatch which is compressed by the codec like the batches of the Batcher
+func NewCompressedBatch(t *testing.T, compression pipeline.BatchCompression, events ...string) *pipeline.Batch {
+	batch := NewBatch(t, events...)
+	batch.SetCompression(compression)
+	return batch
+}
+
+// DeadLetterOutput keeps the JSON of the events passed to the dead letter of the params, see NewDeadLetter
+type DeadLetterOutput struct {
+	mu     sync.Mutex
+	events []string
+}
+
+// NewDeadLetter sets the dead letter of the params which passes the events to the returned output
+func NewDeadLetter(params *pipeline.OutputPluginParams) *DeadLetterOutput {
+	o := &DeadLetterOutput{}
+	params.DeadLetter = pipeline.NewDeadLetter(o, params.MetricCtl.RegisterCounter("dead_letter_events_total", "").WithLabelValues())
+	return o
+}
+
+func (o *DeadLetterOutput) Start(_ pipeline.AnyConfig, _ *pipeline.OutputPluginParams) {}
+
+func (o *DeadLetterOutput) Stop() {}
+
+func (o *DeadLetterOutput) Out(event *pipeline.Event) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.events = append(o.events, event.Root.EncodeToString())
+}
+
+// Events returns the events passed to the dead letter in order
+func (o *DeadLetterOutput) Events() []string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return append([]string(nil), o.events...)
+}
+
 func NewEmptyActionPluginParams() *pipeline.ActionPluginParams {
 	return &pipeline.ActionPluginParams{
 		PluginDefaultParams: newDefaultParams(),
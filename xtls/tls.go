@@ -7,7 +7,9 @@ import (
 	"encoding/pem"
 	"errors"
 	"fmt"
+	"net/http"
 	"os"
+	"time"
 )
 
 var (
@@ -118,6 +120,34 @@ func (b ConfigBuilder) Build() *tls.Config {
 	return b.cfg
 }
 
+// NewClientConfig returns the client tls config trusting the CA cert if it's set or the host's root CA set otherwise.
+// The CA cert is either PEM encoded content or a path to the PEM encoded file.
+func NewClientConfig(caCert string, insecureSkipVerify bool) (*tls.Config, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: insecureSkipVerify}
+	if caCert != "" {
+		b := NewConfigBuilder()
+		if err := b.AppendCARoot(caCert); err != nil {
+			return nil, fmt.Errorf("can't append CA root: %w", err)
+		}
+		tlsConfig = b.Build()
+		tlsConfig.InsecureSkipVerify = insecureSkipVerify
+	}
+	return tlsConfig, nil
+}
+
+// NewHTTPClient returns the HTTP client with the tls config of the NewClientConfig and the request timeout.
+func NewHTTPClient(caCert string, insecureSkipVerify bool, timeout time.Duration) (*http.Client, error) {
+	tlsConfig, err := NewClientConfig(caCert, insecureSkipVerify)
+	if err != nil {
+		return nil, err
+	}
+
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: &http.Transport{TLSClientConfig: tlsConfig},
+	}, nil
+}
+
 // isPEM checks if the content is PEM encoded.
 func isPEM(content []byte) bool {
 	return bytes.Contains(content, pemBegin)
@@ -143,3 +143,13 @@ Pafatg==
 	require.NotNil(t, tlsConfig)
 	require.NotNil(t, tlsConfig.RootCAs)
 }
+
+func TestNewClientConfig(t *testing.T) {
+	tlsConfig, err := NewClientConfig("", true)
+	require.NoError(t, err)
+	require.Nil(t, tlsConfig.RootCAs)
+	require.True(t, tlsConfig.InsecureSkipVerify)
+
+	_, err = NewClientConfig("/nonexistent/ca.crt", false)
+	require.Error(t, err)
+}